@@ -0,0 +1,127 @@
+// Package bench measures operation throughput end to end, with editors
+// connected to a real server over WebSockets, and the cost of transforming
+// and applying operations, and reports the results as JSON so they can be
+// compared release over release:
+//
+//	report, err := bench.Run(bench.DefaultScenarios, true)
+//	regressions := bench.Compare(baseline, report, 0.2)
+//
+// The hub logs every operation through the standard logger, which would
+// dominate the measurements; callers usually discard its output first.
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"collaborative-docs/internal/protocol"
+)
+
+// DefaultScenarios are the throughput runs the published baseline covers.
+var DefaultScenarios = []Scenario{
+	{Clients: 1, Documents: 1, Operations: 2000},
+	{Clients: 10, Documents: 1, Operations: 500},
+	{Clients: 10, Documents: 1, Operations: 500, Protocol: protocol.NameMsgpack},
+	{Clients: 10, Documents: 1, Operations: 500, Protocol: protocol.NameProto},
+	{Clients: 50, Documents: 10, Operations: 200},
+}
+
+// Report is the outcome of a benchmark run.
+type Report struct {
+	Time       time.Time          `json:"time"`
+	GoVersion  string             `json:"go_version"`
+	OS         string             `json:"os"`
+	Arch       string             `json:"arch"`
+	CPUs       int                `json:"cpus"`
+	Throughput []ThroughputResult `json:"throughput"`
+	Micro      []MicroResult      `json:"micro,omitempty"`
+}
+
+// Run measures throughput for each scenario and, if micro is set, runs
+// the microbenchmarks.
+func Run(scenarios []Scenario, micro bool) (*Report, error) {
+	report := &Report{
+		Time:      time.Now().UTC(),
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+	}
+	for _, s := range scenarios {
+		result, err := Throughput(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s, err)
+		}
+		report.Throughput = append(report.Throughput, result)
+	}
+	if micro {
+		report.Micro = Micro()
+	}
+	return report, nil
+}
+
+// ReadReport reads a report written by WriteReport.
+func ReadReport(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r Report
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return nil, fmt.Errorf("reading report %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// WriteReport writes r as indented JSON.
+func WriteReport(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Regression is a measurement worse than its baseline by more than the
+// tolerance.
+type Regression struct {
+	Name     string  `json:"name"`
+	Unit     string  `json:"unit"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %.1f %s, baseline %.1f", r.Name, r.Current, r.Unit, r.Baseline)
+}
+
+// Compare returns the measurements in current worse than in baseline by
+// more than tolerance, a fraction: throughput below baseline×(1-tolerance)
+// or a microbenchmark slower than baseline×(1+tolerance). Measurements
+// missing from either report are skipped.
+func Compare(baseline, current *Report, tolerance float64) []Regression {
+	var regressions []Regression
+	throughput := make(map[Scenario]float64, len(baseline.Throughput))
+	for _, r := range baseline.Throughput {
+		throughput[r.Scenario] = r.OpsPerSecond
+	}
+	for _, r := range current.Throughput {
+		if base, ok := throughput[r.Scenario]; ok && r.OpsPerSecond < base*(1-tolerance) {
+			regressions = append(regressions, Regression{Name: r.Scenario.String(), Unit: "ops/s", Baseline: base, Current: r.OpsPerSecond})
+		}
+	}
+
+	micro := make(map[string]float64, len(baseline.Micro))
+	for _, r := range baseline.Micro {
+		micro[r.Name] = r.NsPerOp
+	}
+	for _, r := range current.Micro {
+		if base, ok := micro[r.Name]; ok && r.NsPerOp > base*(1+tolerance) {
+			regressions = append(regressions, Regression{Name: r.Name, Unit: "ns/op", Baseline: base, Current: r.NsPerOp})
+		}
+	}
+	return regressions
+}
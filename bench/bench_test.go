@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"collaborative-docs/internal/protocol"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// TestThroughput verifies a small run acknowledges every operation.
+func TestThroughput(t *testing.T) {
+	s := Scenario{Clients: 4, Documents: 2, Operations: 20}
+	r, err := Throughput(s)
+	if err != nil {
+		t.Fatalf("Throughput() error: %v", err)
+	}
+	if r.Scenario != s || r.OpsPerSecond <= 0 || r.P50Millis <= 0 || r.P99Millis < r.P50Millis {
+		t.Errorf("Throughput() = %+v, want positive rate and latencies", r)
+	}
+
+	s.Protocol = protocol.NameMsgpack
+	if r, err := Throughput(s); err != nil || r.OpsPerSecond <= 0 {
+		t.Errorf("Throughput() over %s = %+v, %v", s.Protocol, r, err)
+	}
+
+	if _, err := Throughput(Scenario{Clients: 1}); err == nil {
+		t.Error("Throughput() with no documents succeeded")
+	}
+	if _, err := Throughput(Scenario{Clients: 1, Documents: 1, Operations: 1, Protocol: "collab.v9"}); err == nil {
+		t.Error("Throughput() with an unknown protocol succeeded")
+	}
+}
+
+// TestCompare verifies only measurements worse than the tolerance allows
+// are reported.
+func TestCompare(t *testing.T) {
+	s := Scenario{Clients: 1, Documents: 1, Operations: 10}
+	other := Scenario{Clients: 2, Documents: 1, Operations: 10}
+	baseline := &Report{
+		Throughput: []ThroughputResult{{Scenario: s, OpsPerSecond: 1000}, {Scenario: other, OpsPerSecond: 1000}},
+		Micro:      []MicroResult{{Name: "transform", NsPerOp: 100}, {Name: "apply", NsPerOp: 100}},
+	}
+	current := &Report{
+		Throughput: []ThroughputResult{{Scenario: s, OpsPerSecond: 700}, {Scenario: other, OpsPerSecond: 900}},
+		Micro:      []MicroResult{{Name: "transform", NsPerOp: 110}, {Name: "apply", NsPerOp: 150}, {Name: "new", NsPerOp: 1}},
+	}
+
+	got := Compare(baseline, current, 0.2)
+	if len(got) != 2 || got[0].Name != s.String() || got[0].Current != 700 || got[1].Name != "apply" || got[1].Baseline != 100 {
+		t.Errorf("Compare() = %v, want the 1-client throughput and apply", got)
+	}
+	if got := Compare(baseline, baseline, 0); len(got) != 0 {
+		t.Errorf("Compare() of a report with itself = %v, want none", got)
+	}
+}
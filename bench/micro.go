@@ -0,0 +1,105 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+
+	"collaborative-docs/internal/operations"
+)
+
+// MicroResult is the outcome of one microbenchmark.
+type MicroResult struct {
+	Name        string  `json:"name"`
+	Iterations  int     `json:"iterations"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+}
+
+// micro are the operation-level benchmarks Micro runs, in report order.
+var micro = []struct {
+	name string
+	fn   func(b *testing.B)
+}{
+	{"transform", benchTransform},
+	{"apply_10kb", benchApply},
+	{"transform_past_100", benchTransformPast},
+	{"compose_100", benchCompose},
+	{"diff_10kb", benchDiff},
+}
+
+// Micro measures transforming, applying, composing and diffing operations.
+// Each benchmark runs for about a second.
+func Micro() []MicroResult {
+	results := make([]MicroResult, 0, len(micro))
+	for _, m := range micro {
+		r := testing.Benchmark(m.fn)
+		results = append(results, MicroResult{
+			Name:        m.name,
+			Iterations:  r.N,
+			NsPerOp:     float64(r.T.Nanoseconds()) / float64(r.N),
+			BytesPerOp:  r.AllocedBytesPerOp(),
+			AllocsPerOp: r.AllocsPerOp(),
+		})
+	}
+	return results
+}
+
+// typing returns n single-character inserts, each after the previous one.
+func typing(n int) []*operations.Operation {
+	ops := make([]*operations.Operation, n)
+	for i := range ops {
+		ops[i] = operations.NewInsertOp(i, "x", i)
+	}
+	return ops
+}
+
+func benchTransform(b *testing.B) {
+	insert := operations.NewInsertOp(5, "hello", 1)
+	del := operations.NewDeleteOp(3, "abcd", 1)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, _, err := operations.Transform(insert, del); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchApply(b *testing.B) {
+	doc := strings.Repeat("lorem ipsum ", 1024)
+	op := operations.NewInsertOp(len(doc)/2, "hello", 1)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := operations.Apply(doc, op); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchTransformPast(b *testing.B) {
+	ops := []*operations.Operation{operations.NewInsertOp(0, "hello", 0)}
+	later := typing(100)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := operations.TransformPast(ops, later); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchCompose(b *testing.B) {
+	ops := typing(100)
+	b.ReportAllocs()
+	for b.Loop() {
+		operations.Compose(ops)
+	}
+}
+
+func benchDiff(b *testing.B) {
+	old := strings.Repeat("lorem ipsum ", 1024)
+	edited := old[:len(old)/2] + "hello" + old[len(old)/2:]
+	b.ReportAllocs()
+	for b.Loop() {
+		operations.Diff(old, edited, 1)
+	}
+}
@@ -0,0 +1,223 @@
+package bench
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/protocol"
+	"collaborative-docs/internal/server"
+)
+
+// ackTimeout bounds the wait for one operation's ack.
+const ackTimeout = 10 * time.Second
+
+// Scenario is one throughput run: Clients editors, spread evenly over
+// Documents documents, each sending Operations inserts.
+type Scenario struct {
+	Clients    int    `json:"clients"`
+	Documents  int    `json:"documents"`
+	Operations int    `json:"operations"`         // Per client
+	Protocol   string `json:"protocol,omitempty"` // Subprotocol the editors negotiate; empty is plain JSON
+}
+
+func (s Scenario) String() string {
+	name := fmt.Sprintf("%d clients/%d documents/%d ops", s.Clients, s.Documents, s.Operations)
+	if s.Protocol != "" {
+		name += " over " + s.Protocol
+	}
+	return name
+}
+
+// ThroughputResult is the outcome of one scenario.
+type ThroughputResult struct {
+	Scenario
+	Seconds      float64 `json:"seconds"`
+	OpsPerSecond float64 `json:"ops_per_second"` // Acknowledged operations across all clients
+	P50Millis    float64 `json:"p50_ms"`         // Time from sending an operation to its ack
+	P99Millis    float64 `json:"p99_ms"`
+}
+
+// Throughput starts a server and runs s against it. Each editor sends an
+// insert written against the latest version it has seen and waits for its
+// ack before sending the next, so the hub transforms operations past the
+// concurrent edits of the document's other editors, as in real sessions.
+func Throughput(s Scenario) (ThroughputResult, error) {
+	result := ThroughputResult{Scenario: s}
+	if s.Clients < 1 || s.Documents < 1 || s.Operations < 1 {
+		return result, errors.New("clients, documents and operations must be positive")
+	}
+	codec := protocol.JSON
+	if s.Protocol != "" {
+		var ok bool
+		if codec, ok = protocol.Lookup(s.Protocol); !ok {
+			return result, fmt.Errorf("unknown protocol %q", s.Protocol)
+		}
+	}
+
+	srv := server.New(server.Config{})
+	go srv.Hub().Run()
+	defer srv.Hub().Shutdown()
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	base := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/"
+
+	editors := make([]*editor, s.Clients)
+	for i := range editors {
+		e, err := dial(base, fmt.Sprintf("bench-%d", i%s.Documents), s.Protocol, codec)
+		if err != nil {
+			return result, err
+		}
+		defer e.conn.Close()
+		editors[i] = e
+	}
+
+	latencies := make([][]time.Duration, len(editors))
+	errs := make([]error, len(editors))
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, e := range editors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latencies[i], errs[i] = e.edit(fmt.Sprintf("c%d", i), s.Operations)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if err := errors.Join(errs...); err != nil {
+		return result, err
+	}
+
+	all := slices.Concat(latencies...)
+	slices.Sort(all)
+	result.Seconds = elapsed.Seconds()
+	result.OpsPerSecond = float64(len(all)) / elapsed.Seconds()
+	result.P50Millis = millis(all[len(all)/2])
+	result.P99Millis = millis(all[len(all)*99/100])
+	return result, nil
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// editor is one WebSocket connection sending operations.
+type editor struct {
+	conn       *websocket.Conn
+	codec      protocol.Codec
+	documentID string
+	version    atomic.Int64      // Latest version seen in an ack or relayed operation
+	replies    chan *hub.Message // Acks and errors
+}
+
+func dial(base, documentID, subprotocol string, codec protocol.Codec) (*editor, error) {
+	dialer := *websocket.DefaultDialer
+	if subprotocol != "" {
+		dialer.Subprotocols = []string{subprotocol}
+	}
+	conn, _, err := dialer.Dial(base+documentID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", documentID, err)
+	}
+	e := &editor{conn: conn, codec: codec, documentID: documentID, replies: make(chan *hub.Message, 1)}
+	go e.read()
+	return e, nil
+}
+
+// read tracks the document's version and passes on acks and errors until
+// the connection closes. The hub batches queued messages into one text
+// frame separated by newlines; binary frames carry one each.
+func (e *editor) read() {
+	defer close(e.replies)
+	for {
+		frameType, frame, err := e.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		messages := [][]byte{frame}
+		if frameType == websocket.TextMessage {
+			messages = bytes.Split(frame, []byte{'\n'})
+		}
+		for _, data := range messages {
+			data, err := e.codec.Decode(data)
+			if err != nil {
+				continue
+			}
+			msg, err := hub.MessageFromBytes(data)
+			if err != nil {
+				continue
+			}
+			switch msg.Type {
+			case hub.MsgTypeSync:
+				e.seen(msg.Version)
+			case hub.MsgTypeOperation:
+				if msg.Operation != nil {
+					e.seen(msg.Operation.Version)
+				}
+			case hub.MsgTypeAck, hub.MsgTypeError, hub.MsgTypeRejected:
+				e.seen(msg.Version)
+				e.replies <- msg
+			}
+		}
+	}
+}
+
+// seen records that the document reached version.
+func (e *editor) seen(version int) {
+	for {
+		current := e.version.Load()
+		if int64(version) <= current || e.version.CompareAndSwap(current, int64(version)) {
+			return
+		}
+	}
+}
+
+// edit sends n inserts, each after the previous one's ack, and returns how
+// long each ack took.
+func (e *editor) edit(prefix string, n int) ([]time.Duration, error) {
+	latencies := make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		op := operations.NewInsertOp(0, "x", int(e.version.Load()))
+		op.ID = fmt.Sprintf("%s-%d", prefix, i)
+		msg := hub.NewOperationMessage(op)
+		msg.DocumentID = e.documentID
+		data, err := msg.ToBytes()
+		if err == nil {
+			data, err = e.codec.Encode(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sent := time.Now()
+		if err := e.conn.WriteMessage(e.codec.FrameType(), data); err != nil {
+			return nil, fmt.Errorf("sending operation: %w", err)
+		}
+		select {
+		case reply, ok := <-e.replies:
+			switch {
+			case !ok:
+				return nil, errors.New("connection closed")
+			case reply.Type != hub.MsgTypeAck:
+				return nil, fmt.Errorf("operation %s: %s: %s", op.ID, reply.Type, reply.Reason)
+			case reply.AckID != op.ID:
+				return nil, fmt.Errorf("ack for %s, want %s", reply.AckID, op.ID)
+			}
+		case <-time.After(ackTimeout):
+			return nil, fmt.Errorf("no ack for operation %s", op.ID)
+		}
+		latencies = append(latencies, time.Since(sent))
+	}
+	return latencies, nil
+}
@@ -0,0 +1,115 @@
+// Command bench measures operation throughput and writes a JSON report,
+// optionally failing if it regressed against a baseline report:
+//
+//	go run ./cmd/bench -out bench-report.json -baseline bench/baseline.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"collaborative-docs/bench"
+	"collaborative-docs/internal/protocol"
+)
+
+func main() {
+	scenarios := flag.String("scenarios", "", "comma-separated clients×documents runs, such as 1x1,10x1; empty runs the defaults")
+	ops := flag.Int("ops", 0, "operations per client; zero uses each scenario's default")
+	subprotocol := flag.String("protocol", "", "subprotocol every editor negotiates, such as "+protocol.NameMsgpack+"; empty keeps each scenario's")
+	micro := flag.Bool("micro", true, "run the transform and apply microbenchmarks")
+	out := flag.String("out", "-", "report file, - for stdout")
+	baseline := flag.String("baseline", "", "report to compare against")
+	tolerance := flag.Float64("tolerance", 0.2, "fraction a measurement may be worse than the baseline")
+	verbose := flag.Bool("v", false, "keep the server's log output")
+	flag.Parse()
+
+	runs, err := parseScenarios(*scenarios, *ops)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *subprotocol != "" {
+		for i := range runs {
+			runs[i].Protocol = *subprotocol
+		}
+	}
+	logger := log.New(os.Stderr, "", 0)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	report, err := bench.Run(runs, *micro)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err := writeReport(*out, report); err != nil {
+		logger.Fatal(err)
+	}
+	for _, r := range report.Throughput {
+		logger.Printf("%s: %.0f ops/s, p50 %.2fms, p99 %.2fms", r.Scenario, r.OpsPerSecond, r.P50Millis, r.P99Millis)
+	}
+	for _, r := range report.Micro {
+		logger.Printf("%s: %.0f ns/op, %d allocs/op", r.Name, r.NsPerOp, r.AllocsPerOp)
+	}
+
+	if *baseline == "" {
+		return
+	}
+	base, err := bench.ReadReport(*baseline)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	regressions := bench.Compare(base, report, *tolerance)
+	for _, r := range regressions {
+		logger.Printf("regression: %s", r)
+	}
+	if len(regressions) > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseScenarios parses the -scenarios flag, giving each run ops
+// operations per client if ops is positive.
+func parseScenarios(s string, ops int) ([]bench.Scenario, error) {
+	var runs []bench.Scenario
+	if s == "" {
+		runs = append(runs, bench.DefaultScenarios...)
+	}
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			continue
+		}
+		clients, documents, ok := strings.Cut(strings.TrimSpace(part), "x")
+		c, err1 := strconv.Atoi(clients)
+		d, err2 := strconv.Atoi(documents)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid scenario %q, want clients x documents such as 10x1", part)
+		}
+		runs = append(runs, bench.Scenario{Clients: c, Documents: d, Operations: 200})
+	}
+	if ops > 0 {
+		for i := range runs {
+			runs[i].Operations = ops
+		}
+	}
+	return runs, nil
+}
+
+func writeReport(path string, report *bench.Report) error {
+	if path == "-" {
+		return bench.WriteReport(os.Stdout, report)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := bench.WriteReport(f, report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
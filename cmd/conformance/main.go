@@ -0,0 +1,85 @@
+// Command conformance writes the protocol's golden test vectors, generated
+// from this implementation, or checks a client's results against the
+// published ones:
+//
+//	go run ./cmd/conformance -out conformance/vectors.json
+//	go run ./cmd/conformance -check results.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"collaborative-docs/conformance"
+)
+
+func main() {
+	out := flag.String("out", "-", "file to write the generated vectors to, - for stdout")
+	check := flag.String("check", "", "results file to check against the published vectors instead")
+	verbose := flag.Bool("v", false, "keep the hub's log output")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "", 0)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	if *check != "" {
+		report, err := checkResults(*check)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		for _, f := range report.Failures {
+			logger.Printf("FAIL %s: %s", f.Vector, f.Reason)
+		}
+		logger.Printf("%d passed, %d failed, %d skipped", report.Passed, len(report.Failures), report.Skipped)
+		if len(report.Failures) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	vectors, err := conformance.Generate()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err := writeVectors(*out, vectors); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// checkResults checks the results in path against the published vectors.
+func checkResults(path string) (*conformance.Report, error) {
+	vectors, err := conformance.Published()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var results conformance.Results
+	if err := json.NewDecoder(f).Decode(&results); err != nil {
+		return nil, err
+	}
+	return conformance.Check(vectors, &results), nil
+}
+
+func writeVectors(path string, vectors *conformance.Vectors) error {
+	if path == "-" {
+		return conformance.WriteVectors(os.Stdout, vectors)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := conformance.WriteVectors(f, vectors); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"collaborative-docs/internal/auth"
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/handshake"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/publish"
+	"collaborative-docs/internal/schema"
 	"collaborative-docs/internal/server"
+	"collaborative-docs/internal/slug"
+	"collaborative-docs/internal/store"
 )
 
 func main() {
@@ -15,12 +26,65 @@ func main() {
 	if !strings.HasPrefix(port, ":") {
 		port = ":" + port
 	}
+	documents, closeStore := getStore()
+	defer closeStore()
 
 	srv := server.New(server.Config{
 		Port:           port,
 		StaticDir:      getEnv("STATIC_DIR", "static"),
 		LogEnabled:     getEnv("LOG_ENABLED", "true") == "true",
 		AllowedOrigins: getEnv("ALLOWED_ORIGINS", ""),
+
+		AttachmentDir:    getEnv("ATTACHMENT_DIR", ""),
+		AttachmentSecret: getEnv("ATTACHMENT_SECRET", ""),
+		PublicURL:        getEnv("PUBLIC_URL", ""),
+		AdminToken:       getEnv("ADMIN_TOKEN", ""),
+		Auth:             getAuthProvider(),
+		Handshake:        getHandshake(),
+		Approver:         getApprover(),
+
+		Schemas: getSchemas("DOCUMENT_SCHEMAS"),
+
+		Store:         documents,
+		FlushInterval: getDurationMS("FLUSH_INTERVAL_MS", 0),
+
+		StandbyOf:    getEnv("STANDBY_OF", ""),
+		StandbyToken: getEnv("STANDBY_TOKEN", ""),
+
+		Compaction: hub.CompactionPolicy{
+			Operations: getInt("COMPACT_EVERY_OPS"),
+			Interval:   getDurationMS("COMPACT_INTERVAL_MS", 0),
+			Retain:     getInt("COMPACT_RETAIN_OPS"),
+		},
+		IdleTTL: getDurationMS("DOCUMENT_IDLE_TTL_MS", 0),
+		Metrics: getEnv("METRICS_ENABLED", "false") == "true",
+
+		Compression: hub.CompressionPolicy{
+			Threshold: getInt("WS_COMPRESSION_THRESHOLD"),
+			Level:     getInt("WS_COMPRESSION_LEVEL"),
+		},
+
+		IDs: slug.Policy{
+			Denylist: getList("SLUG_DENYLIST"),
+			Reserved: getList("RESERVED_ID_PREFIXES"),
+		},
+
+		LatencyThreshold: getDurationMS("LATENCY_SLO_MS", 0),
+		CoalesceWindow:   getDurationMS("COALESCE_WINDOW_MS", 0),
+
+		MemoryHighWatermark:     getMegabytes("MEMORY_HIGH_MB"),
+		MemoryCriticalWatermark: getMegabytes("MEMORY_CRITICAL_MB"),
+
+		Abuse: hub.AbusePolicy{
+			MaxMessagesPerSecond: getFloat("ABUSE_MAX_RATE"),
+			ThrottleScore:        getFloat("ABUSE_THROTTLE_SCORE"),
+			DisconnectScore:      getFloat("ABUSE_DISCONNECT_SCORE"),
+		},
+		RateLimit: hub.RateLimit{
+			MessagesPerSecond: getFloat("RATE_LIMIT"),
+			Burst:             getInt("RATE_LIMIT_BURST"),
+			DisconnectAfter:   getInt("RATE_LIMIT_DISCONNECT_AFTER"),
+		},
 	})
 
 	quit := make(chan os.Signal, 1)
@@ -35,13 +99,189 @@ func main() {
 	}()
 
 	if err := srv.Run(); err != nil {
+		closeStore()
 		log.Fatalf("server failed: %v", err)
 	}
 }
 
+// getStore opens the document store named by DOCUMENT_STORE: a directory
+// holding one JSON file per document, or "sqlite:" and a database file.
+// Without it documents live in memory only. The returned function closes
+// the store.
+func getStore() (document.Store, func()) {
+	spec := os.Getenv("DOCUMENT_STORE")
+	if spec == "" {
+		return nil, func() {}
+	}
+	if path, ok := strings.CutPrefix(spec, "sqlite:"); ok {
+		db, err := store.OpenSQLite(path)
+		if err != nil {
+			log.Fatalf("failed to open document store: %v", err)
+		}
+		return db, func() {
+			if err := db.Close(); err != nil {
+				log.Printf("closing document store: %v", err)
+			}
+		}
+	}
+	dir, err := store.NewFile(spec)
+	if err != nil {
+		log.Fatalf("failed to open document store: %v", err)
+	}
+	return dir, func() {}
+}
+
 func getEnv(key, fallback string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return fallback
 }
+
+func getDurationMS(key string, fallback time.Duration) time.Duration {
+	ms, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || ms <= 0 {
+		return fallback
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// getSchemas parses a comma-separated list of prefix=schema pairs, such as
+// "notes-=title-body". A bare schema name applies to every document.
+func getSchemas(key string) map[string]*schema.Schema {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	schemas := make(map[string]*schema.Schema)
+	for _, entry := range strings.Split(value, ",") {
+		prefix, name, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			prefix, name = "", prefix
+		}
+		s, found := schema.Lookup(name)
+		if !found {
+			log.Fatalf("%s: unknown schema %q (available: %s)", key, name, strings.Join(schema.Names(), ", "))
+		}
+		schemas[prefix] = s
+	}
+	return schemas
+}
+
+// getList parses a comma-separated list, skipping empty entries.
+func getList(key string) []string {
+	var list []string
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func getFloat(key string) float64 {
+	f, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return f
+}
+
+func getInt(key string) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+func getMegabytes(key string) uint64 {
+	mb, err := strconv.ParseUint(os.Getenv(key), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return mb << 20
+}
+
+// getAuthProvider builds the identity provider from AUTH_API_KEYS (a
+// comma-separated list of key=subject pairs), AUTH_JWT_SECRET (HS256, with
+// optional AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE) and AUTH_OIDC_ISSUER
+// (with AUTH_OIDC_AUDIENCE, the client ID). Tokens are tried against each
+// configured provider; with none, authentication is off.
+func getAuthProvider() auth.Provider {
+	var chain auth.Chain
+
+	if value := os.Getenv("AUTH_API_KEYS"); value != "" {
+		keys := make(map[string]auth.Identity)
+		for _, entry := range strings.Split(value, ",") {
+			key, subject, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || key == "" || subject == "" {
+				log.Fatalf("AUTH_API_KEYS: entries must be key=subject")
+			}
+			keys[key] = auth.Identity{Subject: subject}
+		}
+		chain = append(chain, auth.NewStaticKeys(keys))
+	}
+
+	if secret := os.Getenv("AUTH_JWT_SECRET"); secret != "" {
+		p, err := auth.NewJWT(auth.JWTConfig{
+			Issuer:   os.Getenv("AUTH_JWT_ISSUER"),
+			Audience: os.Getenv("AUTH_JWT_AUDIENCE"),
+			Secret:   []byte(secret),
+			Leeway:   time.Minute,
+		})
+		if err != nil {
+			log.Fatalf("AUTH_JWT_SECRET: %v", err)
+		}
+		chain = append(chain, p)
+	}
+
+	if issuer := os.Getenv("AUTH_OIDC_ISSUER"); issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		p, err := auth.NewOIDC(ctx, issuer, os.Getenv("AUTH_OIDC_AUDIENCE"), nil)
+		if err != nil {
+			log.Fatalf("AUTH_OIDC_ISSUER: %v", err)
+		}
+		chain = append(chain, p)
+	}
+
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain
+}
+
+// getHandshake builds the pre-upgrade middleware: a per-address limit on
+// connection attempts from HANDSHAKE_RATE (per second) and HANDSHAKE_BURST,
+// a session length limit from MAX_SESSION_MS, and handshake logging with
+// LOG_HANDSHAKES=true.
+func getHandshake() []handshake.Middleware {
+	var middleware []handshake.Middleware
+	if getEnv("LOG_HANDSHAKES", "false") == "true" {
+		middleware = append(middleware, handshake.Log(nil))
+	}
+	if rate := getFloat("HANDSHAKE_RATE"); rate > 0 {
+		burst, err := strconv.Atoi(os.Getenv("HANDSHAKE_BURST"))
+		if err != nil || burst < 1 {
+			burst = 10
+		}
+		middleware = append(middleware, handshake.RateLimit(rate, burst, nil))
+	}
+	if d := getDurationMS("MAX_SESSION_MS", 0); d > 0 {
+		middleware = append(middleware, handshake.MaxSession(d, nil))
+	}
+	return middleware
+}
+
+// getApprover builds the publishing approver from PUBLISH_WEBHOOK_URL,
+// signing requests with PUBLISH_WEBHOOK_SECRET if set. Without a URL,
+// publishing is off.
+func getApprover() publish.Approver {
+	url := os.Getenv("PUBLISH_WEBHOOK_URL")
+	if url == "" {
+		return nil
+	}
+	return publish.NewWebhook(url, os.Getenv("PUBLISH_WEBHOOK_SECRET"), nil)
+}
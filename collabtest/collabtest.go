@@ -0,0 +1,316 @@
+// Package collabtest runs the collaboration server and SDK clients
+// in-process so applications can write integration tests against real
+// WebSocket traffic:
+//
+//	env := collabtest.New(t, "doc", "alice", "bob")
+//	env.TypeAs("alice", "hello ")
+//	env.TypeAs("bob", "world")
+//	if got := env.WaitConverged(); got != "hello world" { ... }
+package collabtest
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/server"
+	"collaborative-docs/sdk"
+)
+
+// DefaultTimeout bounds every wait in the testkit.
+const DefaultTimeout = 5 * time.Second
+
+// Env is a running server with connected clients, one per user, all editing
+// the same document. It is torn down automatically when the test ends.
+type Env struct {
+	t          testing.TB
+	documentID string
+	server     *server.Server
+	http       *httptest.Server
+	conns      *connTracker
+	clients    map[string]*sdk.Client
+	users      []string
+	timeout    time.Duration
+	faults     hub.Faults // Injected into new connections; guarded by mu
+	mu         sync.Mutex
+}
+
+// New starts a server and connects one SDK client per user to documentID.
+func New(t testing.TB, documentID string, users ...string) *Env {
+	t.Helper()
+
+	env := &Env{
+		t:          t,
+		documentID: documentID,
+		clients:    make(map[string]*sdk.Client),
+		timeout:    DefaultTimeout,
+	}
+	env.server = server.New(server.Config{WrapConn: env.wrapConn})
+	go env.server.Hub().Run()
+	env.http = httptest.NewUnstartedServer(env.server.Handler())
+	env.conns = &connTracker{Listener: env.http.Listener, conns: make(map[net.Conn]bool)}
+	env.http.Listener = env.conns
+	env.http.Start()
+	t.Cleanup(env.close)
+
+	for _, user := range users {
+		env.Connect(user)
+	}
+	return env
+}
+
+// Connect adds a client for user. A client joining after edits starts from
+// the content the server sends on connect.
+func (e *Env) Connect(user string) *sdk.Client {
+	e.t.Helper()
+	return e.ConnectWithOptions(user, sdk.Options{})
+}
+
+// ConnectWithOptions is like Connect with SDK options, such as reconnection.
+func (e *Env) ConnectWithOptions(user string, opts sdk.Options) *sdk.Client {
+	e.t.Helper()
+	if _, ok := e.clients[user]; ok {
+		e.t.Fatalf("collabtest: user %q already connected", user)
+	}
+
+	c, err := sdk.DialWithOptions(e.URL(), e.documentID, opts)
+	if err != nil {
+		e.t.Fatalf("collabtest: connect %s: %v", user, err)
+	}
+	e.clients[user] = c
+	e.users = append(e.users, user)
+	e.waitClients()
+	return c
+}
+
+// Disconnect closes user's client.
+func (e *Env) Disconnect(user string) {
+	e.t.Helper()
+	c := e.Client(user)
+	c.Close()
+	delete(e.clients, user)
+	for i, u := range e.users {
+		if u == user {
+			e.users = append(e.users[:i], e.users[i+1:]...)
+			break
+		}
+	}
+	e.waitClients()
+}
+
+// DropConnections cuts every open network connection to the server, as a
+// network failure would, without closing the clients.
+func (e *Env) DropConnections() {
+	e.conns.closeAll()
+}
+
+// SetFaults makes the network misbehave as f describes on connections
+// opened from now on; the zero value restores a reliable network. Combine
+// it with DropConnections to move reconnecting clients onto the new
+// network:
+//
+//	env.SetFaults(hub.Faults{Drop: 0.2, Reorder: 0.2})
+//	env.DropConnections()
+//	... edit ...
+//	env.SetFaults(hub.Faults{})
+//	env.DropConnections()
+//	env.WaitConverged()
+func (e *Env) SetFaults(f hub.Faults) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.faults = f
+}
+
+// wrapConn injects the current faults into a new server connection.
+func (e *Env) wrapConn(conn hub.Conn) hub.Conn {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.faults == (hub.Faults{}) {
+		return conn
+	}
+	return hub.NewFaultyConn(conn, e.faults)
+}
+
+// WaitConnected waits until every client reports a live connection and the
+// hub has registered them all.
+func (e *Env) WaitConnected() {
+	e.t.Helper()
+	e.waitFor("clients to reconnect", func() bool {
+		for _, c := range e.clients {
+			if c.State() != sdk.StateConnected {
+				return false
+			}
+		}
+		return e.Hub().ClientCountForDocument(e.documentID) == len(e.clients)
+	})
+}
+
+// waitClients waits until the hub has registered exactly the connected users.
+func (e *Env) waitClients() {
+	e.t.Helper()
+	e.waitFor("clients to register", func() bool {
+		return e.Hub().ClientCountForDocument(e.documentID) == len(e.clients)
+	})
+}
+
+// URL returns the server's WebSocket base URL.
+func (e *Env) URL() string {
+	return "ws" + strings.TrimPrefix(e.http.URL, "http")
+}
+
+// Hub returns the server's hub for direct inspection.
+func (e *Env) Hub() *hub.Hub {
+	return e.server.Hub()
+}
+
+// Client returns user's SDK client.
+func (e *Env) Client(user string) *sdk.Client {
+	e.t.Helper()
+	c, ok := e.clients[user]
+	if !ok {
+		e.t.Fatalf("collabtest: unknown user %q", user)
+	}
+	return c
+}
+
+// SetTimeout changes how long waits last before failing the test.
+func (e *Env) SetTimeout(d time.Duration) {
+	e.timeout = d
+}
+
+// TypeAs appends text as user and waits until the server has applied it,
+// so successive calls behave like users taking turns.
+func (e *Env) TypeAs(user, text string) {
+	e.t.Helper()
+	c := e.Client(user)
+	if err := c.Append(text); err != nil {
+		e.t.Fatalf("collabtest: %s typing %q: %v", user, text, err)
+	}
+	e.waitApplied(c)
+}
+
+// InsertAs inserts text at pos as user and waits until the server applied it.
+func (e *Env) InsertAs(user string, pos int, text string) {
+	e.t.Helper()
+	c := e.Client(user)
+	if err := c.Insert(pos, text); err != nil {
+		e.t.Fatalf("collabtest: %s inserting %q at %d: %v", user, text, pos, err)
+	}
+	e.waitApplied(c)
+}
+
+// DeleteAs deletes length bytes at pos as user and waits until the server
+// applied it.
+func (e *Env) DeleteAs(user string, pos, length int) {
+	e.t.Helper()
+	c := e.Client(user)
+	if err := c.Delete(pos, length); err != nil {
+		e.t.Fatalf("collabtest: %s deleting [%d, %d): %v", user, pos, pos+length, err)
+	}
+	e.waitApplied(c)
+}
+
+// Content returns the server's copy of the document.
+func (e *Env) Content() string {
+	doc := e.Hub().GetDocument(e.documentID)
+	if doc == nil {
+		return ""
+	}
+	return doc.GetContent()
+}
+
+// WaitConverged waits until every client's replica matches the server's
+// content and version, and returns that content.
+func (e *Env) WaitConverged() string {
+	e.t.Helper()
+
+	var content string
+	e.waitFor("clients to converge", func() bool {
+		doc := e.Hub().GetDocument(e.documentID)
+		if doc == nil {
+			return false
+		}
+		var version int
+		content, version = doc.GetContentAndVersion()
+		for _, c := range e.clients {
+			if c.Content() != content || c.Version() != version {
+				return false
+			}
+		}
+		return true
+	})
+	return content
+}
+
+// waitApplied waits until the server's version catches up with c's.
+func (e *Env) waitApplied(c *sdk.Client) {
+	e.t.Helper()
+	want := c.Version()
+	e.waitFor("server to apply the edit", func() bool {
+		doc := e.Hub().GetDocument(e.documentID)
+		return doc != nil && doc.GetVersion() >= want
+	})
+}
+
+// waitFor polls cond until it holds or the timeout passes, then reports
+// every client's state to help diagnose the failure.
+func (e *Env) waitFor(what string, cond func() bool) {
+	e.t.Helper()
+	deadline := time.Now().Add(e.timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			var sb strings.Builder
+			for _, user := range e.users {
+				c := e.clients[user]
+				fmt.Fprintf(&sb, "\n  %s: %q (v%d)", user, c.Content(), c.Version())
+				if err := c.Err(); err != nil {
+					fmt.Fprintf(&sb, " error: %v", err)
+				}
+			}
+			e.t.Fatalf("collabtest: timed out waiting for %s\n  server: %q%s", what, e.Content(), sb.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// connTracker records the server's accepted connections so tests can cut
+// them.
+type connTracker struct {
+	net.Listener
+	conns map[net.Conn]bool
+	mu    sync.Mutex
+}
+
+func (l *connTracker) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.conns[conn] = true
+	l.mu.Unlock()
+	return conn, nil
+}
+
+func (l *connTracker) closeAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for conn := range l.conns {
+		conn.Close()
+	}
+	clear(l.conns)
+}
+
+// close disconnects every client and stops the server.
+func (e *Env) close() {
+	for _, c := range e.clients {
+		c.Close()
+	}
+	e.Hub().Shutdown()
+	e.http.Close()
+}
@@ -0,0 +1,249 @@
+package collabtest
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/sdk"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// TestTypeAsConverges verifies turn-taking edits reach every client.
+func TestTypeAsConverges(t *testing.T) {
+	env := New(t, "notes", "alice", "bob", "carol")
+
+	env.TypeAs("alice", "hello")
+	env.TypeAs("bob", " world")
+	env.InsertAs("carol", 0, "> ")
+	env.DeleteAs("alice", 2, 1)
+
+	if got, want := env.WaitConverged(), "> ello world"; got != want {
+		t.Errorf("converged content = %q, want %q", got, want)
+	}
+	if users := env.Client("bob").Users(); users != 3 {
+		t.Errorf("bob sees %d users, want 3", users)
+	}
+}
+
+// TestConnectAndDisconnect verifies clients can join and leave mid-test.
+func TestConnectAndDisconnect(t *testing.T) {
+	env := New(t, "meeting", "alice")
+	env.Connect("dave")
+	env.TypeAs("alice", "agenda")
+
+	env.Disconnect("alice")
+	env.TypeAs("dave", "!")
+
+	if got := env.WaitConverged(); got != "agenda!" {
+		t.Errorf("converged content = %q, want %q", got, "agenda!")
+	}
+}
+
+// TestCursors verifies a shared cursor reaches collaborators and is
+// removed when its client leaves.
+func TestCursors(t *testing.T) {
+	env := New(t, "cursors", "alice", "bob")
+	bob := env.Client("bob")
+
+	if err := env.Client("alice").SetCursor(0, 3, "Alice", "#3366ff"); err != nil {
+		t.Fatal(err)
+	}
+	env.waitFor("cursor", func() bool { return len(bob.Cursors()) == 1 })
+	if c := bob.Cursors()[0]; c.Anchor != 0 || c.Head != 3 || c.Name != "Alice" || c.Color != "#3366ff" {
+		t.Errorf("bob sees cursor %+v, want alice's selection 0-3", c)
+	}
+
+	env.Disconnect("alice")
+	env.waitFor("cursor removal", func() bool { return len(bob.Cursors()) == 0 })
+}
+
+// TestReconnect verifies a client with reconnection enabled survives a
+// dropped connection, resubmitting an edit made while offline and catching
+// up on a change it missed.
+func TestReconnect(t *testing.T) {
+	env := New(t, "flaky")
+	backoff := sdk.Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}
+	bob := env.ConnectWithOptions("bob", sdk.Options{Reconnect: &backoff})
+	var states []sdk.ConnState
+	var mu sync.Mutex
+	bob.OnStateChange(func(state sdk.ConnState, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, state)
+	})
+	env.TypeAs("bob", "hello")
+
+	env.DropConnections()
+	env.waitFor("bob to notice", func() bool { return bob.State() == sdk.StateReconnecting })
+	if _, err := env.Hub().ReplaceContent("flaky", "hello world", 1); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+	if err := bob.Append("!"); err != nil {
+		t.Fatalf("offline edit: %v", err)
+	}
+	env.WaitConnected()
+
+	if got := env.WaitConverged(); got != "hello! world" {
+		t.Errorf("converged content = %q, want %q", got, "hello! world")
+	}
+	if bob.Pending() != 0 {
+		t.Errorf("bob has %d unacknowledged edits, want 0", bob.Pending())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []sdk.ConnState{sdk.StateReconnecting, sdk.StateConnected}; !reflect.DeepEqual(states, want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+}
+
+// TestFaults verifies a writer and a reader recover from a network that
+// drops, delays and duplicates messages both ways: once it heals and they
+// reconnect, every edit is applied exactly once and the replicas converge.
+// The SDK does not transform concurrent edits, so only one client writes.
+// Reordering is left out: TCP rules it out within a connection, and a
+// sync overtaken by a newer ack makes the writer adopt stale content.
+func TestFaults(t *testing.T) {
+	for seed := uint64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprint("seed ", seed), func(t *testing.T) {
+			env := New(t, "chaos")
+			backoff := sdk.Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}
+			alice := env.ConnectWithOptions("alice", sdk.Options{Reconnect: &backoff})
+			env.ConnectWithOptions("bob", sdk.Options{Reconnect: &backoff})
+
+			env.SetFaults(hub.Faults{Drop: 0.2, Duplicate: 0.2, Delay: 0.3, MaxDelay: 5 * time.Millisecond, Seed: seed})
+			env.DropConnections()
+			env.WaitConnected()
+			var want strings.Builder
+			for i := 0; i < 30; i++ {
+				text := string(rune('a' + i%26))
+				if err := alice.Append(text); err != nil {
+					t.Fatal(err)
+				}
+				want.WriteString(text)
+				time.Sleep(time.Millisecond)
+			}
+
+			env.SetFaults(hub.Faults{})
+			env.DropConnections()
+			env.WaitConnected()
+			// Until every edit is acknowledged, the replicas can agree on
+			// a prefix of them while the rest are being resubmitted.
+			env.waitFor("acks", func() bool { return alice.Pending() == 0 })
+			if got := env.WaitConverged(); got != want.String() {
+				t.Errorf("converged content = %q, want %q", got, want.String())
+			}
+		})
+	}
+}
+
+// TestLatencyCompensationHooks verifies each local edit is reported as
+// applied before it is reported as acknowledged, with its server version.
+func TestLatencyCompensationHooks(t *testing.T) {
+	env := New(t, "hooks", "alice")
+	alice := env.Client("alice")
+
+	var mu sync.Mutex
+	var log []string
+	alice.OnLocalEdit(func(op *operations.Operation) {
+		mu.Lock()
+		defer mu.Unlock()
+		log = append(log, fmt.Sprintf("local %t %q", op.ID != "", op.Text))
+	})
+	alice.OnAck(func(op *operations.Operation, version int) {
+		mu.Lock()
+		defer mu.Unlock()
+		log = append(log, fmt.Sprintf("ack %q v%d", op.Text, version))
+	})
+
+	for _, text := range []string{"a", "b"} {
+		env.TypeAs("alice", text)
+		env.waitFor("ack", func() bool { return alice.Pending() == 0 })
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{`local true "a"`, `ack "a" v1`, `local true "b"`, `ack "b" v2`}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("hooks = %q, want %q", log, want)
+	}
+}
+
+// TestRejectedEdit verifies an edit breaking the document's limits is
+// reported to its author and dropped from every replica.
+func TestRejectedEdit(t *testing.T) {
+	env := New(t, "config", "alice", "bob")
+	if err := env.Hub().SetLimits("config", schema.Limits{MaxLines: 2}); err != nil {
+		t.Fatalf("SetLimits() error: %v", err)
+	}
+	alice := env.Client("alice")
+
+	var mu sync.Mutex
+	var rejected []*schema.ViolationError
+	alice.OnReject(func(op *operations.Operation, violation *schema.ViolationError) {
+		mu.Lock()
+		defer mu.Unlock()
+		rejected = append(rejected, violation)
+	})
+
+	env.TypeAs("alice", "a\nb")
+	if err := alice.Append("\nc"); err != nil {
+		t.Fatalf("Append() error: %v", err)
+	}
+	env.waitFor("rejection", func() bool { return alice.Pending() == 0 })
+	if got := env.WaitConverged(); got != "a\nb" {
+		t.Errorf("converged content = %q, want %q", got, "a\nb")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(rejected) != 1 || rejected[0].Rule != schema.RuleMaxLines || rejected[0].Line != 2 {
+		t.Errorf("rejections = %+v, want one max_lines violation at line 2", rejected)
+	}
+}
+
+// TestFailedEdit verifies an edit the server cannot apply is reported to
+// its author with a code, and replaced in the replica by the server's
+// content.
+func TestFailedEdit(t *testing.T) {
+	env := New(t, "model", "alice")
+	if err := env.Hub().GetOrCreateDocument("model").SetKind(document.KindJSON); err != nil {
+		t.Fatalf("SetKind() error: %v", err)
+	}
+	alice := env.Client("alice")
+
+	var mu sync.Mutex
+	var codes []hub.ErrorCode
+	alice.OnError(func(op *operations.Operation, code hub.ErrorCode, reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if op != nil && op.Text == "x" {
+			codes = append(codes, code)
+		}
+	})
+
+	if err := alice.Insert(0, "x"); err != nil {
+		t.Fatalf("Insert() error: %v", err)
+	}
+	env.waitFor("resync", func() bool { return alice.Pending() == 0 && alice.Content() == "{}" })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(codes, []hub.ErrorCode{hub.ErrorWrongKind}) {
+		t.Errorf("errors = %v, want one wrong_kind", codes)
+	}
+}
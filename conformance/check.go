@@ -0,0 +1,138 @@
+package conformance
+
+import (
+	"fmt"
+	"maps"
+
+	"collaborative-docs/internal/operations"
+)
+
+// Results is what a client computed for the vectors, each by the vector's
+// name. A client may leave out vectors it does not handle.
+type Results struct {
+	Apply     map[string]ApplyResult              `json:"apply,omitempty"`
+	Transform map[string]TransformResult          `json:"transform,omitempty"`
+	Sessions  map[string]map[string]SessionResult `json:"sessions,omitempty"` // Then by client name
+}
+
+// ApplyResult is the content a client got applying an apply vector's
+// operation, or that it refused it.
+type ApplyResult struct {
+	Content string `json:"content,omitempty"`
+	Error   bool   `json:"error,omitempty"`
+}
+
+// TransformResult is what a client transformed a transform vector's
+// operations to.
+type TransformResult struct {
+	Op1Prime *operations.Operation `json:"op1_prime"`
+	Op2Prime *operations.Operation `json:"op2_prime"`
+}
+
+// SessionResult is what a client did replaying its transcript of a
+// session: making the edits and handling the messages received, in order.
+type SessionResult struct {
+	// Sent is the operations the client sent, in order. Their IDs are the
+	// client's own and are not compared.
+	Sent    []*operations.Operation `json:"sent"`
+	Content string                  `json:"content"`
+	Version int                     `json:"version"`
+}
+
+// Report is the outcome of checking results against vectors.
+type Report struct {
+	Passed   int       `json:"passed"`
+	Skipped  int       `json:"skipped"` // Vectors without a result
+	Failures []Failure `json:"failures"`
+}
+
+// Failure is a result that differs from its vector.
+type Failure struct {
+	Vector string `json:"vector"` // Kind and name, such as "apply/insert"
+	Reason string `json:"reason"`
+}
+
+// Check compares results to the vectors.
+func Check(v *Vectors, r *Results) *Report {
+	report := &Report{Failures: []Failure{}}
+	tally := func(vector string, found bool, reason string) {
+		switch {
+		case !found:
+			report.Skipped++
+		case reason != "":
+			report.Failures = append(report.Failures, Failure{vector, reason})
+		default:
+			report.Passed++
+		}
+	}
+
+	for _, vec := range v.Apply {
+		got, ok := r.Apply[vec.Name]
+		reason := ""
+		switch {
+		case got.Error != vec.Error && vec.Error:
+			reason = fmt.Sprintf("applied, giving %q; want an error", got.Content)
+		case got.Error != vec.Error:
+			reason = fmt.Sprintf("refused; want %q", vec.Want)
+		case got.Content != vec.Want:
+			reason = fmt.Sprintf("got %q, want %q", got.Content, vec.Want)
+		}
+		tally("apply/"+vec.Name, ok, reason)
+	}
+
+	for _, vec := range v.Transform {
+		got, ok := r.Transform[vec.Name]
+		reason := ""
+		switch {
+		case !sameOperation(got.Op1Prime, vec.Op1Prime):
+			reason = fmt.Sprintf("op1' is %v, want %v", got.Op1Prime, vec.Op1Prime)
+		case !sameOperation(got.Op2Prime, vec.Op2Prime):
+			reason = fmt.Sprintf("op2' is %v, want %v", got.Op2Prime, vec.Op2Prime)
+		}
+		tally("transform/"+vec.Name, ok, reason)
+	}
+
+	for _, session := range v.Sessions {
+		for _, t := range session.Clients {
+			got, ok := r.Sessions[session.Name][t.Client]
+			tally("sessions/"+session.Name+"/"+t.Client, ok, checkTranscript(session, t, got))
+		}
+	}
+	return report
+}
+
+// checkTranscript compares what a client did replaying t to what the
+// reference client did, returning why they differ or "".
+func checkTranscript(session Session, t Transcript, got SessionResult) string {
+	var sent []*operations.Operation
+	for _, s := range t.Steps {
+		if s.Send != nil {
+			sent = append(sent, s.Send.Operation)
+		}
+	}
+	for i, op := range sent {
+		if i >= len(got.Sent) {
+			return fmt.Sprintf("sent %d operations, want %d", len(got.Sent), len(sent))
+		}
+		if !sameOperation(got.Sent[i], op) {
+			return fmt.Sprintf("operation %d sent is %v, want %v", i+1, got.Sent[i], op)
+		}
+	}
+	if len(got.Sent) > len(sent) {
+		return fmt.Sprintf("sent %d operations, want %d", len(got.Sent), len(sent))
+	}
+	if got.Content != session.Content || got.Version != session.Version {
+		return fmt.Sprintf("ended with %q at version %d, want %q at version %d", got.Content, got.Version, session.Content, session.Version)
+	}
+	return ""
+}
+
+// sameOperation reports whether a and b make the same change at the same
+// version, whatever their IDs.
+func sameOperation(a, b *operations.Operation) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Type == b.Type && a.Position == b.Position && a.Text == b.Text &&
+		a.Version == b.Version && maps.Equal(a.Attributes, b.Attributes)
+}
@@ -0,0 +1,220 @@
+// Package conformance publishes golden test vectors of the collaboration
+// protocol, generated from this implementation, so clients written in
+// other languages, such as JavaScript or Swift, can check they edit
+// documents the way the server does:
+//
+//	vectors, err := conformance.Published()
+//	report := conformance.Check(vectors, results)
+//
+// There are three kinds of vector. Apply vectors give an operation, the
+// content it applies to and the content it produces, with positions in
+// UTF-16 code units. Transform vectors give two concurrent operations and
+// what each becomes once the other is applied first. Session vectors are
+// transcripts of clients editing one document through a real hub: each
+// client's edits, the messages it sent and received, and its copy of the
+// document after every step.
+//
+// A client replays the vectors and reports what it computed as Results;
+// Check compares them to the vectors. The published vectors are
+// regenerated with
+//
+//	go run ./cmd/conformance -out conformance/vectors.json
+//
+// and a test fails while they differ from what the implementation does.
+package conformance
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+)
+
+// Vectors is a set of golden test vectors.
+type Vectors struct {
+	Apply     []ApplyVector     `json:"apply"`
+	Transform []TransformVector `json:"transform"`
+	Sessions  []Session         `json:"sessions"`
+}
+
+// ApplyVector is an operation applied to content.
+type ApplyVector struct {
+	Name      string                `json:"name"`
+	Content   string                `json:"content"`
+	Operation *operations.Operation `json:"operation"`
+	Want      string                `json:"want,omitempty"`
+	Error     bool                  `json:"error,omitempty"` // The operation does not apply to the content
+}
+
+// TransformVector is two operations made concurrently on Content. Op1Prime
+// is Op1 transformed to apply after Op2, and Op2Prime Op2 to apply after
+// Op1; either order gives Want. An operation the other made redundant is
+// transformed to one with empty text, which changes nothing.
+type TransformVector struct {
+	Name     string                `json:"name"`
+	Content  string                `json:"content"`
+	Op1      *operations.Operation `json:"op1"`
+	Op2      *operations.Operation `json:"op2"`
+	Op1Prime *operations.Operation `json:"op1_prime"`
+	Op2Prime *operations.Operation `json:"op2_prime"`
+	Want     string                `json:"want"`
+}
+
+// Session is clients editing one document, which starts as Initial. Every
+// client ends with Content at Version.
+type Session struct {
+	Name       string       `json:"name"`
+	DocumentID string       `json:"document_id"`
+	Initial    string       `json:"initial"`
+	Clients    []Transcript `json:"clients"`
+	Content    string       `json:"content"`
+	Version    int          `json:"version"`
+}
+
+// Transcript is what happened at one client of a session, in order.
+type Transcript struct {
+	Client string `json:"client"`
+	Steps  []Step `json:"steps"`
+}
+
+// Step is one thing that happened at a client, which sets exactly one of
+// Edit, Send and Receive, and the client's copy of the document after it.
+//
+// Received messages are as the server sent them, except that server_time
+// and trace_id are left out and the client IDs of authors, including those
+// in conflict reports, are replaced by the names of the session's clients.
+type Step struct {
+	// Edit is a change the user made to the client's copy. Its version
+	// and ID are not set.
+	Edit *operations.Operation `json:"edit,omitempty"`
+	// Send is a message the client sent. A client has one operation at a
+	// time in flight, and sends the next edit once it is acknowledged.
+	Send    *hub.Message `json:"send,omitempty"`
+	Receive *hub.Message `json:"receive,omitempty"`
+	Content string       `json:"content"`
+	Version int          `json:"version"` // The server's version the copy is based on
+}
+
+//go:embed vectors.json
+var published []byte
+
+// Published returns the published vectors.
+func Published() (*Vectors, error) {
+	var v Vectors
+	if err := json.Unmarshal(published, &v); err != nil {
+		return nil, fmt.Errorf("published vectors: %w", err)
+	}
+	return &v, nil
+}
+
+// Generate computes the vectors from this implementation.
+func Generate() (*Vectors, error) {
+	v := &Vectors{}
+	for _, c := range applyCases {
+		want, err := operations.Apply(c.Content, c.Operation)
+		v.Apply = append(v.Apply, ApplyVector{
+			Name:      c.Name,
+			Content:   c.Content,
+			Operation: c.Operation,
+			Want:      want,
+			Error:     err != nil,
+		})
+	}
+	for _, c := range transformCases {
+		tv, err := transform(c)
+		if err != nil {
+			return nil, fmt.Errorf("transform vector %s: %w", c.Name, err)
+		}
+		v.Transform = append(v.Transform, tv)
+	}
+	for _, s := range sessionScripts {
+		session, err := record(s)
+		if err != nil {
+			return nil, fmt.Errorf("session %s: %w", s.name, err)
+		}
+		v.Sessions = append(v.Sessions, session)
+	}
+	return v, nil
+}
+
+// transform completes a transform vector, checking both orders agree.
+func transform(c TransformVector) (TransformVector, error) {
+	op1, op2, err := operations.Transform(c.Op1, c.Op2)
+	if err != nil {
+		return c, err
+	}
+	c.Op1Prime, c.Op2Prime = op1, op2
+	first, err := applyAll(c.Content, c.Op1, c.Op2Prime)
+	if err != nil {
+		return c, err
+	}
+	second, err := applyAll(c.Content, c.Op2, c.Op1Prime)
+	if err != nil {
+		return c, err
+	}
+	if first != second {
+		return c, fmt.Errorf("orders diverge: %q and %q", first, second)
+	}
+	c.Want = first
+	return c, nil
+}
+
+// applyAll applies ops to content in turn, skipping those with empty
+// text as the hub does.
+func applyAll(content string, ops ...*operations.Operation) (string, error) {
+	for _, op := range ops {
+		if op.Text == "" {
+			continue
+		}
+		var err error
+		if content, err = operations.Apply(content, op); err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+var (
+	bold    = operations.Attributes{operations.AttrBold: "true"}
+	heading = operations.Attributes{operations.AttrHeading: "1"}
+)
+
+// applyCases are the apply vectors, without their results.
+var applyCases = []ApplyVector{
+	{Name: "insert", Content: "hello world", Operation: operations.NewInsertOp(5, ",", 1)},
+	{Name: "insert at the end", Content: "hello", Operation: operations.NewInsertOp(5, "!", 1)},
+	{Name: "insert into empty", Content: "", Operation: operations.NewInsertOp(0, "hi", 0)},
+	{Name: "delete", Content: "hello world", Operation: operations.NewDeleteOp(5, " world", 1)},
+	{Name: "insert after an emoji", Content: "a😀b", Operation: operations.NewInsertOp(3, "x", 1)},
+	{Name: "delete an emoji", Content: "a😀b", Operation: operations.NewDeleteOp(1, "😀", 1)},
+	{Name: "insert with attributes", Content: "title", Operation: &operations.Operation{Type: operations.OpInsert, Position: 0, Text: "A ", Version: 1, Attributes: heading}},
+	{Name: "format leaves the text", Content: "hello", Operation: operations.NewFormatOp(0, "hell", bold, 1)},
+	{Name: "insert past the end", Content: "hello", Operation: operations.NewInsertOp(6, "!", 1)},
+	{Name: "delete of text not there", Content: "hello", Operation: operations.NewDeleteOp(0, "help", 1)},
+	{Name: "insert splitting an emoji", Content: "a😀b", Operation: operations.NewInsertOp(2, "x", 1)},
+}
+
+// transformCases are the transform vectors, without their results.
+var transformCases = []TransformVector{
+	{Name: "inserts apart", Content: "hello world", Op1: operations.NewInsertOp(0, ">", 1), Op2: operations.NewInsertOp(11, "!", 1)},
+	{Name: "inserts at one position", Content: "ab", Op1: operations.NewInsertOp(1, "X", 1), Op2: operations.NewInsertOp(1, "Y", 1)},
+	{Name: "insert inside a delete", Content: "hello world", Op1: operations.NewInsertOp(3, "XY", 1), Op2: operations.NewDeleteOp(1, "ello w", 1)},
+	{Name: "insert before a delete", Content: "hello world", Op1: operations.NewInsertOp(0, "> ", 1), Op2: operations.NewDeleteOp(5, " world", 1)},
+	{Name: "overlapping deletes", Content: "hello world", Op1: operations.NewDeleteOp(2, "llo w", 1), Op2: operations.NewDeleteOp(4, "o wor", 1)},
+	{Name: "the same delete", Content: "hello world", Op1: operations.NewDeleteOp(5, " world", 1), Op2: operations.NewDeleteOp(5, " world", 1)},
+	{Name: "delete inside a delete", Content: "hello world", Op1: operations.NewDeleteOp(0, "hello world", 1), Op2: operations.NewDeleteOp(3, "lo", 1)},
+	{Name: "inserts around an emoji", Content: "a😀b", Op1: operations.NewInsertOp(1, "<", 1), Op2: operations.NewInsertOp(3, ">", 1)},
+	{Name: "format and insert inside", Content: "hello world", Op1: operations.NewFormatOp(0, "hello", bold, 1), Op2: operations.NewInsertOp(2, "XY", 1)},
+	{Name: "format and overlapping delete", Content: "hello world", Op1: operations.NewFormatOp(0, "hello", bold, 1), Op2: operations.NewDeleteOp(3, "lo wo", 1)},
+}
+
+// WriteVectors writes v as indented JSON, as the published vectors are.
+func WriteVectors(w io.Writer, v *Vectors) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
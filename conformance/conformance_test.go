@@ -0,0 +1,76 @@
+package conformance
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+// TestPublished verifies the published vectors are what the implementation
+// does now.
+func TestPublished(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	v, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteVectors(&buf, v); err != nil {
+		t.Fatalf("WriteVectors() error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), published) {
+		t.Error("published vectors are out of date; regenerate them with go run ./cmd/conformance -out conformance/vectors.json")
+	}
+}
+
+// TestCheck verifies the vectors' own results pass and a wrong one fails.
+func TestCheck(t *testing.T) {
+	v, err := Published()
+	if err != nil {
+		t.Fatalf("Published() error: %v", err)
+	}
+	r := &Results{
+		Apply:     make(map[string]ApplyResult),
+		Transform: make(map[string]TransformResult),
+		Sessions:  make(map[string]map[string]SessionResult),
+	}
+	for _, vec := range v.Apply {
+		r.Apply[vec.Name] = ApplyResult{Content: vec.Want, Error: vec.Error}
+	}
+	for _, vec := range v.Transform {
+		r.Transform[vec.Name] = TransformResult{Op1Prime: vec.Op1Prime, Op2Prime: vec.Op2Prime}
+	}
+	total := len(v.Apply) + len(v.Transform)
+	for _, s := range v.Sessions {
+		r.Sessions[s.Name] = make(map[string]SessionResult)
+		for _, c := range s.Clients {
+			result := SessionResult{Content: s.Content, Version: s.Version}
+			for _, step := range c.Steps {
+				if step.Send != nil {
+					op := *step.Send.Operation
+					op.ID = "own-id"
+					result.Sent = append(result.Sent, &op)
+				}
+			}
+			r.Sessions[s.Name][c.Client] = result
+			total++
+		}
+	}
+	if report := Check(v, r); report.Passed != total || len(report.Failures) != 0 || report.Skipped != 0 {
+		t.Fatalf("Check(reference results) = %+v, want all %d passed", report, total)
+	}
+
+	first := v.Transform[0].Name
+	wrong := *v.Transform[0].Op2Prime
+	wrong.Position++
+	r.Transform[first] = TransformResult{Op1Prime: v.Transform[0].Op1Prime, Op2Prime: &wrong}
+	delete(r.Apply, v.Apply[0].Name)
+	report := Check(v, r)
+	if len(report.Failures) != 1 || report.Failures[0].Vector != "transform/"+first || report.Skipped != 1 {
+		t.Errorf("Check(one wrong, one missing) = %+v", report)
+	}
+}
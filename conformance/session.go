@@ -0,0 +1,364 @@
+package conformance
+
+import (
+	"fmt"
+	"time"
+
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+)
+
+// sessionDocument is the document every session edits.
+const sessionDocument = "conformance"
+
+// clientBuffer bounds the messages waiting for a session's client; scripts
+// deliver them long before it fills.
+const clientBuffer = 256
+
+// script is a session to record: clients connect in order, each receiving
+// what the hub sends on connecting, then take the actions in turn.
+type script struct {
+	name    string
+	initial string
+	clients []string
+	actions []action
+}
+
+// action is a client's user editing its copy or, with no edit, the client
+// handling every message the hub has sent it since it last did.
+type action struct {
+	client string
+	edit   *operations.Operation
+}
+
+func edit(client string, op *operations.Operation) action { return action{client: client, edit: op} }
+func deliver(client string) action                        { return action{client: client} }
+
+// sessionScripts are the sessions recorded as vectors.
+var sessionScripts = []script{
+	{
+		name:    "join",
+		initial: "hello",
+		clients: []string{"a"},
+	},
+	{
+		name:    "edit relayed",
+		initial: "hello",
+		clients: []string{"a", "b"},
+		actions: []action{
+			edit("a", operations.NewInsertOp(5, " world", 0)),
+			deliver("a"),
+			deliver("b"),
+		},
+	},
+	{
+		name:    "concurrent inserts",
+		initial: "ab",
+		clients: []string{"a", "b"},
+		actions: []action{
+			edit("a", operations.NewInsertOp(1, "X", 0)),
+			edit("b", operations.NewInsertOp(1, "Y", 0)),
+			deliver("a"),
+			deliver("b"),
+		},
+	},
+	{
+		name:    "concurrent delete and insert",
+		initial: "hello world",
+		clients: []string{"a", "b"},
+		actions: []action{
+			edit("a", operations.NewDeleteOp(0, "hello ", 0)),
+			edit("b", operations.NewInsertOp(11, "!", 0)),
+			edit("b", operations.NewInsertOp(0, "> ", 0)),
+			deliver("b"),
+			deliver("a"),
+			deliver("b"),
+		},
+	},
+	{
+		name:    "edits buffered behind one in flight",
+		initial: "",
+		clients: []string{"a", "b"},
+		actions: []action{
+			edit("a", operations.NewInsertOp(0, "one", 0)),
+			edit("a", operations.NewInsertOp(3, " two", 0)),
+			edit("b", operations.NewInsertOp(0, "zero ", 0)),
+			edit("a", operations.NewInsertOp(7, " three", 0)),
+			deliver("a"),
+			deliver("b"),
+			deliver("a"),
+			deliver("b"),
+			deliver("a"),
+			deliver("b"),
+		},
+	},
+	{
+		name:    "the same delete",
+		initial: "hello world",
+		clients: []string{"a", "b"},
+		actions: []action{
+			edit("a", operations.NewDeleteOp(5, " world", 0)),
+			edit("b", operations.NewDeleteOp(5, " world", 0)),
+			deliver("a"),
+			deliver("b"),
+		},
+	},
+	{
+		name:    "emoji",
+		initial: "a😀b",
+		clients: []string{"a", "b"},
+		actions: []action{
+			edit("a", operations.NewDeleteOp(1, "😀", 0)),
+			edit("b", operations.NewInsertOp(3, "🎉", 0)),
+			deliver("a"),
+			deliver("b"),
+		},
+	},
+	{
+		name:    "format",
+		initial: "hello world",
+		clients: []string{"a", "b"},
+		actions: []action{
+			edit("a", operations.NewFormatOp(6, "world", bold, 0)),
+			edit("b", operations.NewInsertOp(6, "big ", 0)),
+			deliver("a"),
+			deliver("b"),
+		},
+	},
+}
+
+// record runs s against a hub and returns its transcripts.
+func record(s script) (Session, error) {
+	h := hub.NewHub()
+	h.SetClock(clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+	go h.Run()
+	defer h.Shutdown()
+	if s.initial != "" {
+		if _, err := h.ReplaceContent(sessionDocument, s.initial, 0); err != nil {
+			return Session{}, err
+		}
+	}
+
+	names := make(map[string]string) // By client ID
+	clients := make(map[string]*client)
+	var order []*client
+	for _, name := range s.clients {
+		c := &client{name: name, hub: h, conn: hub.NewLocalClient(h, sessionDocument, clientBuffer), names: names}
+		names[c.conn.ID()] = name
+		clients[name] = c
+		order = append(order, c)
+		h.Register(c.conn)
+		settle(h)
+		if err := c.deliver(); err != nil {
+			return Session{}, fmt.Errorf("client %s: %w", name, err)
+		}
+	}
+	for i, a := range s.actions {
+		c := clients[a.client]
+		if c == nil {
+			return Session{}, fmt.Errorf("action %d: no client %s", i, a.client)
+		}
+		var err error
+		if a.edit != nil {
+			err = c.edit(a.edit)
+		} else {
+			err = c.deliver()
+		}
+		if err != nil {
+			return Session{}, fmt.Errorf("action %d at client %s: %w", i, a.client, err)
+		}
+	}
+
+	doc := h.GetDocument(sessionDocument)
+	if doc == nil {
+		return Session{}, fmt.Errorf("document was never created")
+	}
+	session := Session{Name: s.name, DocumentID: sessionDocument, Initial: s.initial}
+	session.Content, session.Version = doc.GetContentAndVersion()
+	for _, c := range order {
+		switch {
+		case c.sentID != "" || len(c.buffer) > 0:
+			return Session{}, fmt.Errorf("client %s has edits not acknowledged", c.name)
+		case c.content != session.Content || c.version != session.Version:
+			return Session{}, fmt.Errorf("client %s has %q at version %d, the hub %q at version %d",
+				c.name, c.content, c.version, session.Content, session.Version)
+		}
+		session.Clients = append(session.Clients, Transcript{Client: c.name, Steps: c.steps})
+	}
+	return session, nil
+}
+
+// settle waits until the hub has handled what it was sent and delivered
+// its replies: Clients goes through the hub's loop like a registration.
+func settle(h *hub.Hub) {
+	h.Clients()
+}
+
+// client is the reference client. It applies its user's edits at once,
+// keeps one operation in flight until it is acknowledged, and transforms
+// the operations it receives past its unacknowledged edits.
+type client struct {
+	name  string
+	hub   *hub.Hub
+	conn  *hub.Client
+	names map[string]string // Session client names by client ID
+
+	content string
+	version int
+	sentID  string                  // ID of the operation in flight, if any
+	flight  []*operations.Operation // The operation in flight, transformed past operations received since
+	buffer  []*operations.Operation // Edits waiting for the one in flight to be acknowledged
+	sent    int
+	steps   []Step
+}
+
+// step records what happened, with the client's copy after it.
+func (c *client) step(s Step) {
+	s.Content, s.Version = c.content, c.version
+	c.steps = append(c.steps, s)
+}
+
+// edit applies the user's edit and sends it unless an edit is in flight.
+func (c *client) edit(op *operations.Operation) error {
+	content, err := operations.Apply(c.content, op)
+	if err != nil {
+		return fmt.Errorf("script made invalid edit %s: %w", op, err)
+	}
+	c.content = content
+	c.buffer = append(c.buffer, op)
+	c.step(Step{Edit: op})
+	return c.send()
+}
+
+// send puts the next buffered edit in flight, unless one is.
+func (c *client) send() error {
+	if c.sentID != "" || len(c.buffer) == 0 {
+		return nil
+	}
+	op := *c.buffer[0]
+	c.buffer = c.buffer[1:]
+	op.Version = c.version
+	c.sent++
+	op.ID = fmt.Sprintf("%s-%d", c.name, c.sent)
+	c.sentID, c.flight = op.ID, []*operations.Operation{&op}
+
+	msg := hub.NewOperationMessage(&op)
+	msg.DocumentID = sessionDocument
+	data, err := msg.ToBytes()
+	if err != nil {
+		return err
+	}
+	c.step(Step{Send: msg})
+	c.hub.Submit(data, c.conn)
+	return nil
+}
+
+// deliver handles every message the hub has sent the client.
+func (c *client) deliver() error {
+	for {
+		select {
+		case data := <-c.conn.Messages():
+			msg, err := hub.MessageFromBytes(data)
+			if err != nil {
+				return err
+			}
+			if err := c.receive(msg); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// receive handles a message from the hub.
+func (c *client) receive(msg *hub.Message) error {
+	msg.ServerTime, msg.TraceID = 0, ""
+	c.rename(msg.Author)
+	if msg.Conflict != nil {
+		c.rename(msg.Conflict.Author)
+		for i := range msg.Conflict.With {
+			c.rename(&msg.Conflict.With[i])
+		}
+	}
+	switch msg.Type {
+	case hub.MsgTypeSync:
+		c.content, c.version = msg.Content, msg.Version
+	case hub.MsgTypeOperation:
+		if err := c.applyRemote(msg.Operation); err != nil {
+			return err
+		}
+	case hub.MsgTypeAck:
+		if msg.AckID != c.sentID {
+			return fmt.Errorf("ack for %s, want %s", msg.AckID, c.sentID)
+		}
+		c.version, c.sentID, c.flight = msg.Version, "", nil
+	case hub.MsgTypeRejected, hub.MsgTypeError:
+		return fmt.Errorf("edit %s %s: %s", msg.AckID, msg.Type, msg.Reason)
+	}
+	c.step(Step{Receive: msg})
+	if msg.Type == hub.MsgTypeAck {
+		return c.send()
+	}
+	return nil
+}
+
+// rename replaces an author's client ID, which differs from run to run,
+// with the session's name for the client.
+func (c *client) rename(a *hub.Author) {
+	if a != nil {
+		a.ClientID = c.names[a.ClientID]
+	}
+}
+
+// applyRemote applies another client's operation, transformed past the
+// client's unacknowledged edits, which are transformed past it in turn.
+func (c *client) applyRemote(op *operations.Operation) error {
+	if op == nil {
+		return nil
+	}
+	if op.Version != c.version+1 {
+		return fmt.Errorf("got operation version %d after version %d", op.Version, c.version)
+	}
+	remote := op
+	var err error
+	for _, pending := range []*[]*operations.Operation{&c.flight, &c.buffer} {
+		if remote, *pending, err = transformRemote(remote, *pending); err != nil {
+			return err
+		}
+	}
+	if remote != nil {
+		content, err := operations.Apply(c.content, remote)
+		if err != nil {
+			return fmt.Errorf("applying version %d: %w", op.Version, err)
+		}
+		c.content = content
+	}
+	c.version = op.Version
+	return nil
+}
+
+// transformRemote transforms remote past pending, and pending past remote,
+// taking each pending operation as the first, as the hub does when it
+// rebases a client's operation past one applied before it. It returns a
+// nil remote if pending made it redundant.
+func transformRemote(remote *operations.Operation, pending []*operations.Operation) (*operations.Operation, []*operations.Operation, error) {
+	var rebased []*operations.Operation
+	for i, p := range pending {
+		if remote == nil {
+			return nil, append(rebased, pending[i:]...), nil
+		}
+		next, rest, err := operations.Transform(p, remote)
+		if err != nil {
+			return nil, nil, err
+		}
+		if next.Text != "" {
+			rebased = append(rebased, next)
+		}
+		if remote = rest; remote.Text == "" {
+			remote = nil
+		}
+	}
+	return remote, rebased, nil
+}
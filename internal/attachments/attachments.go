@@ -0,0 +1,210 @@
+package attachments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultSlotTTL is how long an upload URL stays valid. Unreferenced
+// attachments younger than this are kept, since the client may not have
+// inserted the token yet.
+const DefaultSlotTTL = 15 * time.Minute
+
+// tokenPrefix marks an attachment reference inside document content.
+const tokenPrefix = "attachment:"
+
+// tokenPattern matches attachment reference tokens in document content.
+var tokenPattern = regexp.MustCompile(tokenPrefix + `([0-9a-f]{32})`)
+
+// Storage is the pluggable backend that holds attachment bytes.
+type Storage interface {
+	// PresignUpload returns a URL the client can PUT the file to until expires.
+	PresignUpload(key, contentType string, expires time.Time) (string, error)
+	// Delete removes a stored attachment. Deleting a missing key is not an error.
+	Delete(key string) error
+}
+
+// Attachment describes a file uploaded for a document.
+type Attachment struct {
+	ID          string    `json:"id"`
+	DocumentID  string    `json:"document_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Slot is an upload reservation returned to the client.
+type Slot struct {
+	Attachment
+	Token     string    `json:"token,omitempty"`
+	UploadURL string    `json:"upload_url,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Manager issues upload slots and garbage-collects attachments that are no
+// longer referenced by their document.
+type Manager struct {
+	storage Storage
+	maxSize int64
+	ttl     time.Duration
+	byDoc   map[string]map[string]*Attachment
+	mu      sync.Mutex
+}
+
+// NewManager creates a Manager backed by storage. Uploads larger than
+// maxSize bytes are refused.
+func NewManager(storage Storage, maxSize int64) *Manager {
+	return &Manager{
+		storage: storage,
+		maxSize: maxSize,
+		ttl:     DefaultSlotTTL,
+		byDoc:   make(map[string]map[string]*Attachment),
+	}
+}
+
+// RequestUpload reserves an attachment for a document and returns a slot
+// with a presigned upload URL and the token to insert into the document.
+func (m *Manager) RequestUpload(documentID, filename, contentType string, size int64) (*Slot, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("filename is required")
+	}
+	if size <= 0 || size > m.maxSize {
+		return nil, fmt.Errorf("size %d out of range (1, %d]", size, m.maxSize)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expires := now.Add(m.ttl)
+	url, err := m.storage.PresignUpload(id, contentType, expires)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign upload: %w", err)
+	}
+
+	att := &Attachment{
+		ID:          id,
+		DocumentID:  documentID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		CreatedAt:   now,
+	}
+
+	m.mu.Lock()
+	if m.byDoc[documentID] == nil {
+		m.byDoc[documentID] = make(map[string]*Attachment)
+	}
+	m.byDoc[documentID][id] = att
+	m.mu.Unlock()
+
+	return &Slot{
+		Attachment: *att,
+		Token:      Token(id),
+		UploadURL:  url,
+		ExpiresAt:  expires,
+	}, nil
+}
+
+// List returns the attachments tracked for a document.
+func (m *Manager) List(documentID string) []Attachment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]Attachment, 0, len(m.byDoc[documentID]))
+	for _, att := range m.byDoc[documentID] {
+		result = append(result, *att)
+	}
+	return result
+}
+
+// Collect deletes attachments of a document that content no longer
+// references and returns how many were removed. Attachments still within
+// their upload window are kept.
+func (m *Manager) Collect(documentID, content string) int {
+	referenced := make(map[string]bool)
+	for _, id := range References(content) {
+		referenced[id] = true
+	}
+
+	cutoff := time.Now().Add(-m.ttl)
+
+	m.mu.Lock()
+	var stale []string
+	for id, att := range m.byDoc[documentID] {
+		if !referenced[id] && att.CreatedAt.Before(cutoff) {
+			stale = append(stale, id)
+			delete(m.byDoc[documentID], id)
+		}
+	}
+	if len(m.byDoc[documentID]) == 0 {
+		delete(m.byDoc, documentID)
+	}
+	m.mu.Unlock()
+
+	for _, id := range stale {
+		if err := m.storage.Delete(id); err != nil {
+			log.Printf("failed to delete attachment %s: %v", id, err)
+		}
+	}
+	return len(stale)
+}
+
+// Documents returns the IDs of documents that have tracked attachments.
+func (m *Manager) Documents() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]string, 0, len(m.byDoc))
+	for id := range m.byDoc {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Usage returns how many attachments are tracked and their total size in
+// bytes, as declared when their uploads were requested.
+func (m *Manager) Usage() (count int, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, atts := range m.byDoc {
+		for _, att := range atts {
+			count++
+			bytes += att.Size
+		}
+	}
+	return count, bytes
+}
+
+// Token returns the reference token for an attachment ID.
+func Token(id string) string {
+	return tokenPrefix + id
+}
+
+// References returns the attachment IDs referenced in content.
+func References(content string) []string {
+	matches := tokenPattern.FindAllStringSubmatch(content, -1)
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = m[1]
+	}
+	return ids
+}
+
+// newID generates a random 128-bit attachment ID.
+func newID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate attachment id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
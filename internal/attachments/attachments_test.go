@@ -0,0 +1,139 @@
+package attachments
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeStorage records presign and delete calls.
+type fakeStorage struct {
+	deleted []string
+}
+
+func (f *fakeStorage) PresignUpload(key, contentType string, expires time.Time) (string, error) {
+	return "https://uploads.example.com/" + key, nil
+}
+
+func (f *fakeStorage) Delete(key string) error {
+	f.deleted = append(f.deleted, key)
+	return nil
+}
+
+// TestRequestUpload verifies slot issuance and size limits.
+func TestRequestUpload(t *testing.T) {
+	m := NewManager(&fakeStorage{}, 1024)
+
+	slot, err := m.RequestUpload("doc", "cat.png", "image/png", 512)
+	if err != nil {
+		t.Fatalf("RequestUpload() error: %v", err)
+	}
+	if slot.Token != Token(slot.ID) {
+		t.Errorf("token = %q, want %q", slot.Token, Token(slot.ID))
+	}
+	if !strings.HasSuffix(slot.UploadURL, slot.ID) {
+		t.Errorf("upload URL %q does not reference attachment", slot.UploadURL)
+	}
+	if got := len(m.List("doc")); got != 1 {
+		t.Errorf("List() returned %d attachments, want 1", got)
+	}
+
+	if _, err := m.RequestUpload("doc", "big.bin", "", 2048); err == nil {
+		t.Error("expected error for oversized upload")
+	}
+	if _, err := m.RequestUpload("doc", "", "", 10); err == nil {
+		t.Error("expected error for missing filename")
+	}
+}
+
+// TestCollect verifies only unreferenced attachments past their upload
+// window are removed.
+func TestCollect(t *testing.T) {
+	storage := &fakeStorage{}
+	m := NewManager(storage, 1024)
+	m.ttl = 0
+
+	kept, _ := m.RequestUpload("doc", "a.png", "image/png", 1)
+	dropped, _ := m.RequestUpload("doc", "b.png", "image/png", 1)
+
+	content := "see ![a](" + kept.Token + ")"
+	if n := m.Collect("doc", content); n != 1 {
+		t.Fatalf("Collect() removed %d, want 1", n)
+	}
+	if len(storage.deleted) != 1 || storage.deleted[0] != dropped.ID {
+		t.Errorf("deleted = %v, want [%s]", storage.deleted, dropped.ID)
+	}
+
+	m.ttl = time.Hour
+	m.RequestUpload("doc", "fresh.png", "image/png", 1)
+	if n := m.Collect("doc", content); n != 0 {
+		t.Errorf("Collect() removed %d fresh attachments, want 0", n)
+	}
+}
+
+// TestLocalStorageSignedUpload verifies uploads require a valid signature,
+// happen once and are served with their declared type, never sniffed.
+func TestLocalStorageSignedUpload(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir(), "http://example.com/attachments", []byte("secret"), 64)
+	if err != nil {
+		t.Fatalf("NewLocalStorage() error: %v", err)
+	}
+
+	key := strings.Repeat("ab", 16)
+	signed, err := storage.PresignUpload(key, "text/plain", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("PresignUpload() error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	storage.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, signed, strings.NewReader("hello")))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("signed PUT status = %d, want 201", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	storage.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/attachments/"+key, nil))
+	if rec.Body.String() != "hello" {
+		t.Errorf("GET body = %q, want %q", rec.Body.String(), "hello")
+	}
+	h := rec.Header()
+	if h.Get("Content-Type") != "text/plain" || h.Get("X-Content-Type-Options") != "nosniff" || h.Get("Content-Disposition") != "attachment" {
+		t.Errorf("GET headers = %v, want the declared type, nosniff and attachment", h)
+	}
+
+	rec = httptest.NewRecorder()
+	storage.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, signed, strings.NewReader("again")))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("second PUT status = %d, want 409", rec.Code)
+	}
+
+	untyped := strings.Repeat("cd", 16)
+	signed, _ = storage.PresignUpload(untyped, "", time.Now().Add(time.Minute))
+	storage.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, signed, strings.NewReader("<script>alert(1)</script>")))
+	rec = httptest.NewRecorder()
+	storage.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/attachments/"+untyped, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("untyped GET Content-Type = %q, want it not sniffed", ct)
+	}
+	retyped := strings.Replace(signed, "type=application%2Foctet-stream", "type=text%2Fhtml", 1)
+	rec = httptest.NewRecorder()
+	storage.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, retyped, strings.NewReader("<script>")))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("PUT with a changed type status = %d, want 403", rec.Code)
+	}
+
+	tampered := strings.Replace(signed, "sig=", "sig=0", 1)
+	rec = httptest.NewRecorder()
+	storage.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, tampered, strings.NewReader("evil")))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("tampered PUT status = %d, want 403", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	storage.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/attachments/..%2f..%2fetc", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("invalid key status = %d, want 404", rec.Code)
+	}
+}
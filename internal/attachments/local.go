@@ -0,0 +1,206 @@
+package attachments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyPattern matches attachment IDs, which also keeps keys from escaping the
+// storage directory.
+var keyPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// defaultContentType is served for attachments uploaded without a declared
+// type.
+const defaultContentType = "application/octet-stream"
+
+// typeSuffix names the file beside each attachment holding its declared
+// content type. Such names never match keyPattern, so they are not served.
+const typeSuffix = ".type"
+
+// LocalStorage stores attachments on the local filesystem and serves them
+// over HTTP. Upload URLs are signed with an HMAC so only holders of a slot
+// can write. Downloads carry the type declared for the upload, are never
+// sniffed and are sent as attachments, so an uploaded page cannot run as
+// the application's own origin.
+type LocalStorage struct {
+	dir     string
+	baseURL string // e.g. http://localhost:8080/attachments/
+	secret  []byte
+	maxSize int64
+}
+
+// NewLocalStorage creates filesystem-backed storage rooted at dir. baseURL is
+// the public URL prefix where the storage's ServeHTTP handler is mounted.
+func NewLocalStorage(dir, baseURL string, secret []byte, maxSize int64) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create attachment directory: %w", err)
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &LocalStorage{dir: dir, baseURL: baseURL, secret: secret, maxSize: maxSize}, nil
+}
+
+// PresignUpload returns a signed URL accepting a single PUT until expires.
+// The attachment is served as contentType, or as application/octet-stream
+// when it is empty.
+func (s *LocalStorage) PresignUpload(key, contentType string, expires time.Time) (string, error) {
+	if !isValidKey(key) {
+		return "", fmt.Errorf("invalid attachment key: %q", key)
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return "", fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("type", contentType)
+	q.Set("sig", s.sign(key, exp, contentType))
+	return s.baseURL + key + "?" + q.Encode(), nil
+}
+
+// Delete removes a stored attachment.
+func (s *LocalStorage) Delete(key string) error {
+	if !isValidKey(key) {
+		return fmt.Errorf("invalid attachment key: %q", key)
+	}
+	path := filepath.Join(s.dir, key)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(path + typeSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP handles signed uploads (PUT) and downloads (GET) for keys below
+// the mount point. A key is uploaded once: later PUTs, even with a valid
+// URL, get 409.
+func (s *LocalStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if !isValidKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+	path := filepath.Join(s.dir, key)
+
+	switch r.Method {
+	case http.MethodGet:
+		f, err := os.Open(path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, "attachment unavailable", http.StatusInternalServerError)
+			return
+		}
+		contentType := defaultContentType
+		if declared, err := os.ReadFile(path + typeSuffix); err == nil {
+			contentType = string(declared)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", "attachment")
+		http.ServeContent(w, r, "", info.ModTime(), f)
+
+	case http.MethodPut:
+		q := r.URL.Query()
+		if err := s.verify(key, q); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if _, err := os.Stat(path); err == nil {
+			http.Error(w, "attachment already uploaded", http.StatusConflict)
+			return
+		}
+		if err := os.WriteFile(path+typeSuffix, []byte(q.Get("type")), 0o644); err != nil {
+			log.Printf("attachment upload failed: %v", err)
+			http.Error(w, "upload failed", http.StatusInternalServerError)
+			return
+		}
+		err := s.write(path, http.MaxBytesReader(w, r.Body, s.maxSize))
+		if errors.Is(err, fs.ErrExist) {
+			http.Error(w, "attachment already uploaded", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("attachment upload failed: %v", err)
+			http.Error(w, "upload failed", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// write stores the body atomically via a temporary file. It fails with
+// fs.ErrExist if path was written already, even by a concurrent upload.
+func (s *LocalStorage) write(path string, body io.Reader) error {
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Link(tmp.Name(), path)
+}
+
+// verify checks the signature and expiry of an upload URL.
+func (s *LocalStorage) verify(key string, q url.Values) error {
+	exp := q.Get("expires")
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid expiry")
+	}
+	if time.Now().Unix() > unix {
+		return fmt.Errorf("upload URL expired")
+	}
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(s.sign(key, exp, q.Get("type")))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// sign computes the URL signature for a key, expiry and content type.
+func (s *LocalStorage) sign(key, expires, contentType string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "|" + expires + "|" + contentType))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isValidKey reports whether key is a well-formed attachment ID.
+func isValidKey(key string) bool {
+	return keyPattern.MatchString(key)
+}
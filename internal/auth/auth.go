@@ -0,0 +1,46 @@
+// Package auth validates client credentials against a pluggable identity
+// provider, so deployments can reuse their existing IdP. Built-in providers
+// accept static API keys and JWTs, including OIDC-issued ID tokens.
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidToken is returned for credentials a provider does not accept.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Identity is the authenticated principal behind a token.
+type Identity struct {
+	Subject string `json:"sub"`             // Stable user or service ID
+	Name    string `json:"name,omitempty"`  // Display name, if the provider has one
+	Email   string `json:"email,omitempty"` // Email address, if the provider has one
+}
+
+// Provider validates a bearer token. Implementations return an error
+// wrapping ErrInvalidToken for credentials they reject, and other errors
+// when they cannot decide, such as an unreachable key endpoint.
+type Provider interface {
+	ValidateToken(ctx context.Context, token string) (*Identity, error)
+}
+
+// Chain tries each provider in order and returns the first identity, so
+// users can sign in through an IdP while services use API keys.
+type Chain []Provider
+
+// ValidateToken implements Provider. It fails with ErrInvalidToken only if
+// every provider rejected the token.
+func (c Chain) ValidateToken(ctx context.Context, token string) (*Identity, error) {
+	err := error(ErrInvalidToken)
+	for _, p := range c {
+		id, perr := p.ValidateToken(ctx, token)
+		if perr == nil {
+			return id, nil
+		}
+		if !errors.Is(perr, ErrInvalidToken) {
+			err = perr
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"collaborative-docs/internal/clock"
+)
+
+// signJWT builds a token with the given header and claims, signed by sign.
+func signJWT(t *testing.T, header, claims map[string]any, sign func(signed []byte) []byte) string {
+	t.Helper()
+	enc := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := enc(header) + "." + enc(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func TestStaticKeys(t *testing.T) {
+	p := NewStaticKeys(map[string]Identity{"k-123": {Subject: "billing-service"}})
+
+	id, err := p.ValidateToken(context.Background(), "k-123")
+	if err != nil || id.Subject != "billing-service" {
+		t.Errorf("ValidateToken(valid) = %+v, %v", id, err)
+	}
+	if _, err := p.ValidateToken(context.Background(), "k-124"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ValidateToken(wrong) error = %v, want ErrInvalidToken", err)
+	}
+}
+
+// TestJWTClaims verifies HS256 signatures and the registered claims.
+func TestJWTClaims(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	p, err := NewJWT(JWTConfig{
+		Issuer:   "https://idp.example",
+		Audience: "docs",
+		Secret:   []byte("s3cret"),
+		Clock:    clock.NewFake(now),
+	})
+	if err != nil {
+		t.Fatalf("NewJWT() error: %v", err)
+	}
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":  "https://idp.example",
+			"aud":  []string{"other", "docs"},
+			"sub":  "user-7",
+			"name": "Carol",
+			"exp":  now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	header := map[string]any{"alg": "HS256", "typ": "JWT"}
+
+	id, err := p.ValidateToken(context.Background(), signJWT(t, header, claims(nil), hs256("s3cret")))
+	if err != nil || id.Subject != "user-7" || id.Name != "Carol" {
+		t.Fatalf("ValidateToken(valid) = %+v, %v", id, err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong secret", signJWT(t, header, claims(nil), hs256("guess"))},
+		{"expired", signJWT(t, header, claims(map[string]any{"exp": now.Add(-time.Second).Unix()}), hs256("s3cret"))},
+		{"no expiry", signJWT(t, header, claims(map[string]any{"exp": nil}), hs256("s3cret"))},
+		{"not yet valid", signJWT(t, header, claims(map[string]any{"nbf": now.Add(time.Hour).Unix()}), hs256("s3cret"))},
+		{"other issuer", signJWT(t, header, claims(map[string]any{"iss": "https://evil.example"}), hs256("s3cret"))},
+		{"other audience", signJWT(t, header, claims(map[string]any{"aud": "other"}), hs256("s3cret"))},
+		{"no subject", signJWT(t, header, claims(map[string]any{"sub": nil}), hs256("s3cret"))},
+		{"alg none", signJWT(t, map[string]any{"alg": "none"}, claims(nil), func([]byte) []byte { return nil })},
+		{"malformed", "not-a-jwt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.ValidateToken(context.Background(), tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+// TestOIDC verifies discovery, RS256 verification against the issuer's
+// key set, and refetching the key set when the IdP rotates keys.
+func TestOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error: %v", err)
+	}
+	kid := "key-1"
+
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	idp := httptest.NewServer(mux)
+	defer idp.Close()
+	issuer = idp.URL
+
+	p, err := NewOIDC(context.Background(), issuer, "docs-client", idp.Client())
+	if err != nil {
+		t.Fatalf("NewOIDC() error: %v", err)
+	}
+
+	rs256 := func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return sig
+	}
+	token := func(kid string) string {
+		return signJWT(t, map[string]any{"alg": "RS256", "kid": kid}, map[string]any{
+			"iss":   issuer,
+			"aud":   "docs-client",
+			"sub":   "oidc|42",
+			"email": "carol@example.com",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}, rs256)
+	}
+
+	id, err := p.ValidateToken(context.Background(), token(kid))
+	if err != nil || id.Subject != "oidc|42" || id.Email != "carol@example.com" {
+		t.Fatalf("ValidateToken() = %+v, %v", id, err)
+	}
+	if _, err := p.ValidateToken(context.Background(), token("unknown")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("unknown key ID error = %v, want ErrInvalidToken", err)
+	}
+
+	// The IdP rotates to a new key ID; the cached set is stale by then.
+	kid = "key-2"
+	p.config.Keys.(*JWKS).fetched = time.Time{}
+	if _, err := p.ValidateToken(context.Background(), token("key-2")); err != nil {
+		t.Errorf("rotated key error = %v", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	hs, _ := NewJWT(JWTConfig{Secret: []byte("s3cret")})
+	chain := Chain{hs, NewStaticKeys(map[string]Identity{"k-1": {Subject: "svc"}})}
+
+	if id, err := chain.ValidateToken(context.Background(), "k-1"); err != nil || id.Subject != "svc" {
+		t.Errorf("ValidateToken(api key) = %+v, %v", id, err)
+	}
+	if _, err := chain.ValidateToken(context.Background(), "k-2"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ValidateToken(unknown) error = %v, want ErrInvalidToken", err)
+	}
+}
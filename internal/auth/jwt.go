@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"collaborative-docs/internal/clock"
+)
+
+// KeySource looks up RSA public keys by key ID, such as a JWKS endpoint.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (*rsa.PublicKey, error)
+}
+
+// JWTConfig configures a JWT provider. Set Secret for HS256 tokens, Keys
+// for RS256 tokens, or both.
+type JWTConfig struct {
+	Issuer   string        // Required iss claim; empty accepts any issuer
+	Audience string        // Required in the aud claim; empty accepts any
+	Secret   []byte        // HMAC key for HS256
+	Keys     KeySource     // RSA keys for RS256
+	Leeway   time.Duration // Tolerated clock skew for exp and nbf
+	Clock    clock.Clock   // Nil uses the wall clock
+}
+
+// JWT is a Provider for signed JSON Web Tokens.
+type JWT struct {
+	config JWTConfig
+}
+
+// NewJWT returns a JWT provider.
+func NewJWT(cfg JWTConfig) (*JWT, error) {
+	if len(cfg.Secret) == 0 && cfg.Keys == nil {
+		return nil, errors.New("jwt: a secret or key source is required")
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+	return &JWT{config: cfg}, nil
+}
+
+// jwtHeader is the decoded JOSE header.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims are the registered and profile claims the provider reads.
+type jwtClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+}
+
+// audience accepts the aud claim as a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// ValidateToken implements Provider. It checks the signature, then the
+// issuer, audience, expiry and not-before claims. Tokens without a subject
+// or expiry are rejected.
+func (j *JWT) ValidateToken(ctx context.Context, token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed JWT", ErrInvalidToken)
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	if err := j.verify(ctx, header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := j.checkClaims(&claims); err != nil {
+		return nil, err
+	}
+	return &Identity{Subject: claims.Subject, Name: claims.Name, Email: claims.Email}, nil
+}
+
+// verify checks the signature over signed with the algorithm the header
+// names, refusing algorithms the provider has no key for.
+func (j *JWT) verify(ctx context.Context, header jwtHeader, signed string, signature []byte) error {
+	switch header.Alg {
+	case "HS256":
+		if len(j.config.Secret) == 0 {
+			break
+		}
+		mac := hmac.New(sha256.New, j.config.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+
+	case "RS256":
+		if j.config.Keys == nil {
+			break
+		}
+		key, err := j.config.Keys.Key(ctx, header.Kid)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+}
+
+func (j *JWT) checkClaims(c *jwtClaims) error {
+	now := j.config.Clock.Now()
+	switch {
+	case c.Subject == "":
+		return fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	case c.ExpiresAt == nil:
+		return fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	case now.After(time.Unix(*c.ExpiresAt, 0).Add(j.config.Leeway)):
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.NotBefore != nil && now.Add(j.config.Leeway).Before(time.Unix(*c.NotBefore, 0)):
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case j.config.Issuer != "" && c.Issuer != j.config.Issuer:
+		return fmt.Errorf("%w: issuer %q", ErrInvalidToken, c.Issuer)
+	case j.config.Audience != "" && !slices.Contains(c.Audience, j.config.Audience):
+		return fmt.Errorf("%w: audience %v", ErrInvalidToken, []string(c.Audience))
+	}
+	return nil
+}
+
+// decodeSegment decodes one base64url JSON segment of a JWT.
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
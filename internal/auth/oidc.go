@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval limits how often an unknown key ID refetches the key
+// set, so tokens with made-up key IDs cannot hammer the IdP.
+const jwksRefreshInterval = time.Minute
+
+// NewOIDC returns a JWT provider for ID tokens from an OpenID Connect
+// issuer, discovering its signing keys from the issuer's
+// /.well-known/openid-configuration. Tokens must be issued by issuer for
+// audience (the client ID registered with the IdP). client may be nil.
+func NewOIDC(ctx context.Context, issuer, audience string, client *http.Client) (*JWT, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, client, url, &discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if discovery.Issuer != issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", discovery.Issuer, issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery: no jwks_uri")
+	}
+
+	keys := NewJWKS(discovery.JWKSURI, client)
+	if err := keys.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return NewJWT(JWTConfig{Issuer: issuer, Audience: audience, Keys: keys, Leeway: time.Minute})
+}
+
+// JWKS is a KeySource backed by a JSON Web Key Set URL. Keys are refetched
+// when a token names an unknown key ID, which picks up IdP key rotation.
+type JWKS struct {
+	url     string
+	client  *http.Client
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// NewJWKS returns a key source fetching the key set at url on first use.
+// client may be nil.
+func NewJWKS(url string, client *http.Client) *JWKS {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &JWKS{url: url, client: client}
+}
+
+// Key implements KeySource.
+func (j *JWKS) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	j.mu.Lock()
+	key, ok := j.keys[kid]
+	stale := time.Since(j.fetched) >= jwksRefreshInterval
+	j.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if stale {
+		if err := j.refresh(ctx); err != nil {
+			return nil, err
+		}
+		j.mu.Lock()
+		key, ok = j.keys[kid]
+		j.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown key ID %q", ErrInvalidToken, kid)
+}
+
+// refresh replaces the cached keys with the current key set. Keys other
+// than RSA signing keys are skipped.
+func (j *JWKS) refresh(ctx context.Context) error {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, j.client, j.url, &set); err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.fetched = time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+// getJSON fetches url and decodes its JSON body into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
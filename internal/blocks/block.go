@@ -0,0 +1,84 @@
+package blocks
+
+import (
+	"strings"
+)
+
+// Kind identifies the structural role of a block.
+type Kind string
+
+const (
+	KindParagraph Kind = "paragraph" // Plain text line
+	KindHeading   Kind = "heading"   // Markdown "#" heading
+	KindListItem  Kind = "list_item" // Markdown "-", "*" or "+" list item
+)
+
+// maxHeadingLevel is the deepest heading level markdown supports.
+const maxHeadingLevel = 6
+
+// Block is a single line-level structural element of a markdown document.
+// Level is the heading level for headings and the indent depth for list items.
+type Block struct {
+	Kind  Kind   `json:"kind"`
+	Level int    `json:"level,omitempty"`
+	Text  string `json:"text"`
+}
+
+// Parse splits markdown content into blocks, one per line.
+func Parse(content string) []Block {
+	lines := strings.Split(content, "\n")
+	result := make([]Block, 0, len(lines))
+	for _, line := range lines {
+		result = append(result, parseLine(line))
+	}
+	return result
+}
+
+// parseLine classifies a single markdown line.
+func parseLine(line string) Block {
+	if level := headingLevel(line); level > 0 {
+		return Block{Kind: KindHeading, Level: level, Text: line[level+1:]}
+	}
+
+	trimmed := strings.TrimLeft(line, " ")
+	indent := len(line) - len(trimmed)
+	if len(trimmed) >= 2 && strings.ContainsRune("-*+", rune(trimmed[0])) && trimmed[1] == ' ' {
+		return Block{Kind: KindListItem, Level: indent / 2, Text: trimmed[2:]}
+	}
+
+	return Block{Kind: KindParagraph, Text: line}
+}
+
+// headingLevel returns the heading level of a line, or 0 if it is not a heading.
+func headingLevel(line string) int {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > maxHeadingLevel || level >= len(line) || line[level] != ' ' {
+		return 0
+	}
+	return level
+}
+
+// Render converts blocks back into markdown content.
+// List markers are normalized to "-" and indents to two spaces per level.
+func Render(blocks []Block) string {
+	lines := make([]string, len(blocks))
+	for i, b := range blocks {
+		lines[i] = b.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// String returns the markdown source line for the block.
+func (b Block) String() string {
+	switch b.Kind {
+	case KindHeading:
+		return strings.Repeat("#", b.Level) + " " + b.Text
+	case KindListItem:
+		return strings.Repeat("  ", b.Level) + "- " + b.Text
+	default:
+		return b.Text
+	}
+}
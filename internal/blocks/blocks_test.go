@@ -0,0 +1,217 @@
+package blocks
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// TestParseRender verifies markdown lines round-trip through blocks.
+func TestParseRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Block
+	}{
+		{
+			name:    "heading and paragraph",
+			content: "# Title\nBody text",
+			want: []Block{
+				{Kind: KindHeading, Level: 1, Text: "Title"},
+				{Kind: KindParagraph, Text: "Body text"},
+			},
+		},
+		{
+			name:    "nested list",
+			content: "- one\n  - two",
+			want: []Block{
+				{Kind: KindListItem, Level: 0, Text: "one"},
+				{Kind: KindListItem, Level: 1, Text: "two"},
+			},
+		},
+		{
+			name:    "hash without space is paragraph",
+			content: "#tag",
+			want:    []Block{{Kind: KindParagraph, Text: "#tag"}},
+		},
+		{
+			name:    "empty document",
+			content: "",
+			want:    []Block{{Kind: KindParagraph, Text: ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.content)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+			if rendered := Render(got); rendered != tt.content {
+				t.Errorf("Render() = %q, want %q", rendered, tt.content)
+			}
+		})
+	}
+}
+
+// TestApply verifies each structural operation on a small outline.
+func TestApply(t *testing.T) {
+	doc := "# A\n- b\n- c"
+
+	tests := []struct {
+		name    string
+		op      *Operation
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "insert paragraph",
+			op:   NewInsertBlockOp(1, Block{Kind: KindParagraph, Text: "intro"}, 0),
+			want: "# A\nintro\n- b\n- c",
+		},
+		{
+			name: "delete list item",
+			op:   NewDeleteBlockOp(2, 0),
+			want: "# A\n- b",
+		},
+		{
+			name: "move list item up",
+			op:   NewMoveBlockOp(2, 1, 0),
+			want: "# A\n- c\n- b",
+		},
+		{
+			name: "change heading level",
+			op:   NewSetLevelOp(0, 3, 0),
+			want: "### A\n- b\n- c",
+		},
+		{
+			name: "indent list item",
+			op:   NewSetLevelOp(2, 1, 0),
+			want: "# A\n- b\n  - c",
+		},
+		{
+			name:    "delete out of range",
+			op:      NewDeleteBlockOp(3, 0),
+			wantErr: true,
+		},
+		{
+			name:    "insert text with newline",
+			op:      NewInsertBlockOp(0, Block{Kind: KindParagraph, Text: "a\nb"}, 0),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply(Parse(doc), tt.op)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && Render(got) != tt.want {
+				t.Errorf("Apply() = %q, want %q", Render(got), tt.want)
+			}
+		})
+	}
+}
+
+// TestTransformConvergence checks the transform property for every pair of
+// operation kinds over randomly generated outlines.
+func TestTransformConvergence(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	randomOp := func(n int) *Operation {
+		switch rng.Intn(4) {
+		case 0:
+			return NewInsertBlockOp(rng.Intn(n+1), Block{Kind: KindParagraph, Text: "new"}, 0)
+		case 1:
+			return NewDeleteBlockOp(rng.Intn(n), 0)
+		case 2:
+			return NewMoveBlockOp(rng.Intn(n), rng.Intn(n), 0)
+		default:
+			return NewSetLevelOp(rng.Intn(n), 1+rng.Intn(maxHeadingLevel), 0)
+		}
+	}
+
+	for i := 0; i < 5000; i++ {
+		n := 1 + rng.Intn(6)
+		start := make([]Block, n)
+		for j := range start {
+			start[j] = Block{Kind: KindParagraph, Text: string(rune('a' + j))}
+		}
+
+		op1, op2 := randomOp(n), randomOp(n)
+		op1Prime, op2Prime, err := Transform(op1, op2)
+		if err != nil {
+			t.Fatalf("Transform(%s, %s) error: %v", op1, op2, err)
+		}
+
+		left, err := Apply(start, op1)
+		if err == nil {
+			left, err = Apply(left, op2Prime)
+		}
+		if err != nil {
+			t.Fatalf("%s then %s: %v", op1, op2Prime, err)
+		}
+
+		right, err := Apply(start, op2)
+		if err == nil {
+			right, err = Apply(right, op1Prime)
+		}
+		if err != nil {
+			t.Fatalf("%s then %s: %v", op2, op1Prime, err)
+		}
+
+		if !reflect.DeepEqual(left, right) {
+			t.Fatalf("diverged on %q for %s / %s: %q vs %q",
+				Render(start), op1, op2, Render(left), Render(right))
+		}
+	}
+}
+
+// TestSplice verifies only the lines an operation touches are re-rendered.
+func TestSplice(t *testing.T) {
+	doc := "# A\n* b\n+ c\n    * d"
+	tests := []struct {
+		name string
+		op   *Operation
+		want string
+	}{
+		{"move", NewMoveBlockOp(2, 1, 0), "# A\n+ c\n* b\n    * d"},
+		{"delete", NewDeleteBlockOp(1, 0), "# A\n+ c\n    * d"},
+		{"insert", NewInsertBlockOp(1, Block{Kind: KindListItem, Text: "x"}, 0), "# A\n- x\n* b\n+ c\n    * d"},
+		{"set level", NewSetLevelOp(3, 1, 0), "# A\n* b\n+ c\n  - d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Splice(doc, tt.op)
+			if err != nil {
+				t.Fatalf("Splice() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Splice() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiff verifies line insertions and deletions become block operations
+// and in-place edits none.
+func TestDiff(t *testing.T) {
+	if ops := Diff("a\nb\nc", "a\nB\nc", 3); len(ops) != 0 {
+		t.Errorf("in-place edit = %v, want none", ops)
+	}
+
+	ops := Diff("a\nb\nc", "a\n- x\n- y\nc", 3)
+	var got []string
+	for _, op := range ops {
+		got = append(got, op.String())
+	}
+	want := []string{
+		NewDeleteBlockOp(1, 3).String(),
+		NewInsertBlockOp(1, Block{Kind: KindListItem, Text: "x"}, 3).String(),
+		NewInsertBlockOp(2, Block{Kind: KindListItem, Text: "y"}, 3).String(),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+}
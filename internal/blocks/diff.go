@@ -0,0 +1,58 @@
+package blocks
+
+import "strings"
+
+// Diff returns the structural operations that turn the lines of oldDoc into
+// those of newDoc, for transforming block operations past text edits. The
+// lines between the longest common prefix and suffix are deleted and the
+// new ones inserted, unless there are as many of each: then the lines were
+// edited in place, which leaves every block where it was, and no operation
+// is returned. Each operation is stamped with version.
+func Diff(oldDoc, newDoc string, version int) []*Operation {
+	oldLines := strings.Split(oldDoc, "\n")
+	newLines := strings.Split(newDoc, "\n")
+
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	removed := len(oldLines) - prefix - suffix
+	added := len(newLines) - prefix - suffix
+	if removed == added {
+		return nil
+	}
+	ops := make([]*Operation, 0, removed+added)
+	for i := 0; i < removed; i++ {
+		ops = append(ops, NewDeleteBlockOp(prefix, version))
+	}
+	for i := 0; i < added; i++ {
+		ops = append(ops, NewInsertBlockOp(prefix+i, parseLine(newLines[prefix+i]), version))
+	}
+	return ops
+}
+
+// Splice applies a structural operation to markdown content line by line.
+// Unlike rendering the result of Apply, lines the operation does not touch
+// are kept exactly as written: only an inserted block and the block whose
+// level is set are rendered, so "* x" elsewhere stays "* x".
+func Splice(content string, op *Operation) (string, error) {
+	lines := strings.Split(content, "\n")
+	raw := make([]Block, len(lines))
+	for i, line := range lines {
+		raw[i] = Block{Kind: KindParagraph, Text: line}
+	}
+	if op != nil && op.Type == OpSetLevel && op.Index >= 0 && op.Index < len(lines) {
+		raw[op.Index] = parseLine(lines[op.Index])
+	}
+	result, err := Apply(raw, op)
+	if err != nil {
+		return "", err
+	}
+	return Render(result), nil
+}
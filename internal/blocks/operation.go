@@ -0,0 +1,188 @@
+package blocks
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OpType represents the type of structural operation.
+type OpType string
+
+const (
+	OpInsertBlock OpType = "insert_block" // Insert a new block before Index
+	OpDeleteBlock OpType = "delete_block" // Remove the block at Index
+	OpMoveBlock   OpType = "move_block"   // Move the block at Index to To
+	OpSetLevel    OpType = "set_level"    // Change the heading level or list depth at Index
+	OpNoop        OpType = "noop"         // Result of transforming away a redundant operation
+)
+
+// Operation is a structural edit that targets whole blocks instead of
+// character offsets, for outline-style editors.
+//
+// For moves, To is the destination index in the list after the block at
+// Index has been removed.
+type Operation struct {
+	Type    OpType `json:"type"`
+	Index   int    `json:"index"`
+	To      int    `json:"to,omitempty"`
+	Block   *Block `json:"block,omitempty"`
+	Level   int    `json:"level,omitempty"`
+	Version int    `json:"version"`
+}
+
+// NewInsertBlockOp creates an operation inserting block before index.
+func NewInsertBlockOp(index int, block Block, version int) *Operation {
+	return &Operation{Type: OpInsertBlock, Index: index, Block: &block, Version: version}
+}
+
+// NewDeleteBlockOp creates an operation removing the block at index.
+func NewDeleteBlockOp(index int, version int) *Operation {
+	return &Operation{Type: OpDeleteBlock, Index: index, Version: version}
+}
+
+// NewMoveBlockOp creates an operation moving the block at from to to.
+func NewMoveBlockOp(from, to int, version int) *Operation {
+	return &Operation{Type: OpMoveBlock, Index: from, To: to, Version: version}
+}
+
+// NewSetLevelOp creates an operation changing the level of the block at index.
+func NewSetLevelOp(index, level int, version int) *Operation {
+	return &Operation{Type: OpSetLevel, Index: index, Level: level, Version: version}
+}
+
+// String returns a human-readable representation of the operation.
+func (op *Operation) String() string {
+	switch op.Type {
+	case OpInsertBlock:
+		return fmt.Sprintf("InsertBlock(%s at %d, v%d)", op.Block.Kind, op.Index, op.Version)
+	case OpDeleteBlock:
+		return fmt.Sprintf("DeleteBlock(%d, v%d)", op.Index, op.Version)
+	case OpMoveBlock:
+		return fmt.Sprintf("MoveBlock(%d to %d, v%d)", op.Index, op.To, op.Version)
+	case OpSetLevel:
+		return fmt.Sprintf("SetLevel(%d to %d, v%d)", op.Index, op.Level, op.Version)
+	case OpNoop:
+		return fmt.Sprintf("Noop(v%d)", op.Version)
+	default:
+		return "Unknown block operation"
+	}
+}
+
+// Validate checks if the operation is well-formed.
+func (op *Operation) Validate() error {
+	if op.Index < 0 {
+		return fmt.Errorf("invalid index: %d (must be >= 0)", op.Index)
+	}
+
+	switch op.Type {
+	case OpInsertBlock:
+		if op.Block == nil {
+			return fmt.Errorf("insert_block operation must have a block")
+		}
+		if strings.Contains(op.Block.Text, "\n") {
+			return fmt.Errorf("block text must not contain newlines")
+		}
+		if err := validateLevel(op.Block.Kind, op.Block.Level); err != nil {
+			return err
+		}
+	case OpMoveBlock:
+		if op.To < 0 {
+			return fmt.Errorf("invalid move destination: %d (must be >= 0)", op.To)
+		}
+	case OpSetLevel:
+		if op.Level < 0 || op.Level > maxHeadingLevel {
+			return fmt.Errorf("invalid level: %d (must be in [0, %d])", op.Level, maxHeadingLevel)
+		}
+	case OpDeleteBlock, OpNoop:
+	default:
+		return fmt.Errorf("unknown block operation type: %s", op.Type)
+	}
+
+	if op.Version < 0 {
+		return fmt.Errorf("invalid version: %d (must be >= 0)", op.Version)
+	}
+
+	return nil
+}
+
+// validateLevel checks that level is meaningful for the block kind.
+func validateLevel(kind Kind, level int) error {
+	switch kind {
+	case KindHeading:
+		if level < 1 || level > maxHeadingLevel {
+			return fmt.Errorf("heading level %d out of range [1, %d]", level, maxHeadingLevel)
+		}
+	case KindListItem:
+		if level < 0 {
+			return fmt.Errorf("list depth %d must be >= 0", level)
+		}
+	case KindParagraph:
+		if level != 0 {
+			return fmt.Errorf("paragraph blocks have no level")
+		}
+	default:
+		return fmt.Errorf("unknown block kind: %s", kind)
+	}
+	return nil
+}
+
+// Apply executes a structural operation on a block list and returns the result.
+// The input slice is not modified.
+func Apply(blocks []Block, op *Operation) ([]Block, error) {
+	if op == nil {
+		return nil, fmt.Errorf("operation cannot be nil")
+	}
+
+	if err := op.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid operation: %w", err)
+	}
+
+	result := make([]Block, len(blocks), len(blocks)+1)
+	copy(result, blocks)
+
+	switch op.Type {
+	case OpInsertBlock:
+		if op.Index > len(result) {
+			return nil, fmt.Errorf("insert index %d out of range [0, %d]", op.Index, len(result))
+		}
+		result = append(result[:op.Index], append([]Block{*op.Block}, result[op.Index:]...)...)
+
+	case OpDeleteBlock:
+		if op.Index >= len(result) {
+			return nil, fmt.Errorf("delete index %d out of range [0, %d)", op.Index, len(result))
+		}
+		result = append(result[:op.Index], result[op.Index+1:]...)
+
+	case OpMoveBlock:
+		if op.Index >= len(result) {
+			return nil, fmt.Errorf("move index %d out of range [0, %d)", op.Index, len(result))
+		}
+		if op.To >= len(result) {
+			return nil, fmt.Errorf("move destination %d out of range [0, %d)", op.To, len(result))
+		}
+		moved := result[op.Index]
+		result = append(result[:op.Index], result[op.Index+1:]...)
+		result = append(result[:op.To], append([]Block{moved}, result[op.To:]...)...)
+
+	case OpSetLevel:
+		if op.Index >= len(result) {
+			return nil, fmt.Errorf("set_level index %d out of range [0, %d)", op.Index, len(result))
+		}
+		target := &result[op.Index]
+		switch target.Kind {
+		case KindHeading:
+			if op.Level == 0 {
+				target.Kind = KindParagraph
+			}
+		case KindParagraph:
+			if op.Level > 0 {
+				target.Kind = KindHeading
+			}
+		}
+		target.Level = op.Level
+
+	case OpNoop:
+	}
+
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package blocks
+
+import "fmt"
+
+// Transform adjusts two concurrent structural operations created against the
+// same document version so they can be applied sequentially without conflict.
+//
+// Like operations.Transform, it returns (op1', op2') such that
+//
+//	Apply(Apply(blocks, op1), op2') == Apply(Apply(blocks, op2), op1')
+//
+// Every operation is modelled as removing a block, inserting one, or both
+// (a move), plus set_level which only references a block. When both
+// operations target the same block, op1 wins.
+func Transform(op1, op2 *Operation) (*Operation, *Operation, error) {
+	if op1 == nil || op2 == nil {
+		return nil, nil, fmt.Errorf("operations cannot be nil")
+	}
+
+	if err := op1.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("op1 invalid: %w", err)
+	}
+	if err := op2.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("op2 invalid: %w", err)
+	}
+
+	op1Prime := clone(op1)
+	op2Prime := clone(op2)
+
+	s1, s2 := shapeOf(op1), shapeOf(op2)
+	if s1.hasTarget && s2.hasTarget && s1.target == s2.target {
+		transformSameTarget(op1Prime, op2Prime)
+		return op1Prime, op2Prime, nil
+	}
+
+	transformShape(op1Prime, s1, s2, true)
+	transformShape(op2Prime, s2, s1, false)
+	return op1Prime, op2Prime, nil
+}
+
+// shape describes an operation as an existing block it targets and/or a gap
+// it inserts into.
+type shape struct {
+	hasTarget bool // operation acts on the block at target
+	removes   bool // the target block is removed from its position
+	target    int
+	inserts   bool // operation inserts a block at gap
+	gap       int  // insertion index, relative to the list after removal
+}
+
+// shapeOf decomposes an operation into its target and insertion gap.
+func shapeOf(op *Operation) shape {
+	switch op.Type {
+	case OpInsertBlock:
+		return shape{inserts: true, gap: op.Index}
+	case OpDeleteBlock:
+		return shape{hasTarget: true, removes: true, target: op.Index}
+	case OpMoveBlock:
+		return shape{hasTarget: true, removes: true, target: op.Index, inserts: true, gap: op.To}
+	case OpSetLevel:
+		return shape{hasTarget: true, target: op.Index}
+	default:
+		return shape{}
+	}
+}
+
+// transformShape rewrites op (with shape self) so it applies after other.
+// wins breaks ties between two insertions at the same gap.
+func transformShape(op *Operation, self, other shape, wins bool) {
+	// Position of the other operation's target once self's block is removed.
+	otherTarget := other.target
+	if self.removes && other.hasTarget && otherTarget > self.target {
+		otherTarget--
+	}
+	// Position of self's target once the other operation's block is removed.
+	selfTarget := self.target
+	if other.removes && self.hasTarget && selfTarget > other.target {
+		selfTarget--
+	}
+
+	if self.hasTarget {
+		target := selfTarget
+		if other.inserts && target >= other.gap {
+			target++
+		}
+		op.Index = target
+	}
+
+	if self.inserts {
+		// Both gaps expressed against the list with both removals applied.
+		gap := self.gap
+		if other.removes && gap > otherTarget {
+			gap--
+		}
+		if other.inserts {
+			otherGap := other.gap
+			if self.removes && otherGap > selfTarget {
+				otherGap--
+			}
+			if gap > otherGap || (gap == otherGap && !wins) {
+				gap++
+			}
+		}
+
+		if op.Type == OpMoveBlock {
+			op.To = gap
+		} else {
+			op.Index = gap
+		}
+	}
+}
+
+// transformSameTarget resolves two operations acting on the same block.
+// op1 takes priority wherever the outcomes disagree.
+func transformSameTarget(op1, op2 *Operation) {
+	switch {
+	case op1.Type == OpDeleteBlock && op2.Type == OpDeleteBlock:
+		makeNoop(op1)
+		makeNoop(op2)
+
+	case op1.Type == OpDeleteBlock:
+		// The other operation moved or relabelled the block; delete it where it now lives.
+		if op2.Type == OpMoveBlock {
+			op1.Index = op2.To
+		}
+		makeNoop(op2)
+
+	case op2.Type == OpDeleteBlock:
+		if op1.Type == OpMoveBlock {
+			op2.Index = op1.To
+		}
+		makeNoop(op1)
+
+	case op1.Type == OpMoveBlock && op2.Type == OpMoveBlock:
+		op1.Index = op2.To
+		makeNoop(op2)
+
+	case op1.Type == OpMoveBlock:
+		// op2 is set_level: follow the block to its new position.
+		op2.Index = op1.To
+
+	case op2.Type == OpMoveBlock:
+		op1.Index = op2.To
+
+	default:
+		// Both set_level: op1's level wins.
+		makeNoop(op2)
+	}
+}
+
+// makeNoop turns op into a no-op, keeping its version.
+func makeNoop(op *Operation) {
+	op.Type = OpNoop
+	op.Index = 0
+	op.To = 0
+	op.Block = nil
+	op.Level = 0
+}
+
+// clone copies op and advances its version, mirroring operations.Transform.
+func clone(op *Operation) *Operation {
+	c := *op
+	if op.Block != nil {
+		b := *op.Block
+		c.Block = &b
+	}
+	c.Version = op.Version + 1
+	return &c
+}
@@ -0,0 +1,125 @@
+// Package clock abstracts time so timing-dependent features (modification
+// times, ping tickers, periodic sweeps, idle eviction) can be driven
+// deterministically in tests.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and tickers.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on C until stopped, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Fake is a manually advanced clock. Tickers fire only when Advance moves
+// time past their next deadline.
+type Fake struct {
+	now     time.Time
+	tickers []*fakeTicker
+	mu      sync.Mutex
+}
+
+// NewFake creates a fake clock set to start.
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+// Now returns the fake current time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// NewTicker creates a ticker that fires every d of fake time.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTicker{clock: f, period: d, next: f.now.Add(d), c: make(chan time.Time, 1)}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing every ticker deadline passed
+// along the way in time order. As with time.Ticker, ticks are dropped when
+// the receiver has not consumed the previous one.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	target := f.now.Add(d)
+	for {
+		sort.Slice(f.tickers, func(i, j int) bool { return f.tickers[i].next.Before(f.tickers[j].next) })
+		if len(f.tickers) == 0 || f.tickers[0].next.After(target) {
+			break
+		}
+		t := f.tickers[0]
+		f.now = t.next
+		t.next = t.next.Add(t.period)
+		select {
+		case t.c <- f.now:
+		default:
+		}
+	}
+	f.now = target
+}
+
+// TickerCount returns the number of active tickers, so tests can wait for a
+// goroutine to start its ticker before advancing.
+func (f *Fake) TickerCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.tickers)
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	period time.Duration
+	next   time.Time
+	c      chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, other := range f.tickers {
+		if other == t {
+			f.tickers = append(f.tickers[:i], f.tickers[i+1:]...)
+			return
+		}
+	}
+}
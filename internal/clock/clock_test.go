@@ -0,0 +1,64 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// TestFakeAdvance verifies time moves only when advanced.
+func TestFakeAdvance(t *testing.T) {
+	f := NewFake(epoch)
+	if !f.Now().Equal(epoch) {
+		t.Fatalf("Now() = %v, want %v", f.Now(), epoch)
+	}
+	f.Advance(90 * time.Second)
+	if want := epoch.Add(90 * time.Second); !f.Now().Equal(want) {
+		t.Errorf("Now() = %v, want %v", f.Now(), want)
+	}
+}
+
+// TestFakeTicker verifies ticks fire at deadlines, are dropped when unread,
+// and stop after Stop.
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(10 * time.Second)
+
+	f.Advance(9 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked before its period elapsed")
+	default:
+	}
+
+	f.Advance(time.Second)
+	select {
+	case tick := <-ticker.C():
+		if want := epoch.Add(10 * time.Second); !tick.Equal(want) {
+			t.Errorf("tick = %v, want %v", tick, want)
+		}
+	default:
+		t.Fatal("did not tick at its deadline")
+	}
+
+	// Three periods pass with nobody reading: only one tick is buffered.
+	f.Advance(30 * time.Second)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Error("unread ticks were queued")
+	default:
+	}
+
+	ticker.Stop()
+	if n := f.TickerCount(); n != 0 {
+		t.Errorf("TickerCount() = %d after Stop, want 0", n)
+	}
+	f.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Error("ticked after Stop")
+	default:
+	}
+}
@@ -0,0 +1,72 @@
+package document
+
+// SetOwner records the user who owns the document, who may always read
+// and edit it; "" clears it.
+func (d *Document) SetOwner(userID string) {
+	d.lock()
+	defer d.mu.Unlock()
+	d.owner = userID
+}
+
+// Owner returns the user who owns the document, or "" if none does.
+func (d *Document) Owner() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.owner
+}
+
+// SetRole gives a user a role on the document, whatever its visibility; an
+// empty role removes the user's entry.
+func (d *Document) SetRole(userID string, role Role) {
+	d.lock()
+	defer d.mu.Unlock()
+	if role == "" {
+		delete(d.roles, userID)
+		return
+	}
+	if d.roles == nil {
+		d.roles = make(map[string]Role)
+	}
+	d.roles[userID] = role
+}
+
+// RoleFor returns a user's role on the document and whether it has one.
+// The owner is an editor.
+func (d *Document) RoleFor(userID string) (Role, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if userID == "" {
+		return "", false
+	}
+	if userID == d.owner {
+		return RoleEditor, true
+	}
+	role, ok := d.roles[userID]
+	return role, ok
+}
+
+// Roles returns a copy of the roles given to users, by user ID.
+func (d *Document) Roles() map[string]Role {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	roles := make(map[string]Role, len(d.roles))
+	for id, role := range d.roles {
+		roles[id] = role
+	}
+	return roles
+}
+
+// RevokeAccess removes a user's role and, if the user owns the document,
+// its ownership, and reports whether there was either.
+func (d *Document) RevokeAccess(userID string) bool {
+	d.lock()
+	defer d.mu.Unlock()
+
+	_, ok := d.roles[userID]
+	delete(d.roles, userID)
+	if userID != "" && d.owner == userID {
+		d.owner = ""
+		ok = true
+	}
+	return ok
+}
@@ -0,0 +1,112 @@
+package document
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxCheckpoints     = 50  // Checkpoints a document may keep
+	maxCheckpointLabel = 200 // Longest label, in characters
+)
+
+var (
+	// ErrCheckpointNotFound is returned for a checkpoint the document does
+	// not have.
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+	// ErrTooManyCheckpoints is returned when creating a checkpoint on a
+	// document that has as many as it may keep.
+	ErrTooManyCheckpoints = fmt.Errorf("document has %d checkpoints, delete one first", maxCheckpoints)
+)
+
+// Checkpoint is a named copy of a text document's content at a version,
+// such as "before the reorg", kept as it was while editing continues so
+// the document can be restored to it after bad edits. Unlike history,
+// which compaction and the history window discard, a checkpoint stays
+// until deleted.
+type Checkpoint struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label"`
+	Version   int       `json:"version"` // The version whose content it holds
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateCheckpoint stores the current content as a checkpoint named label.
+// Labels must be short and printable but need not be unique; checkpoints
+// are told apart by their generated IDs. Only text documents have
+// checkpoints.
+func (d *Document) CreateCheckpoint(label string) (Checkpoint, error) {
+	label = strings.TrimSpace(label)
+	switch {
+	case label == "":
+		return Checkpoint{}, errors.New("checkpoint label is required")
+	case utf8.RuneCountInString(label) > maxCheckpointLabel:
+		return Checkpoint{}, fmt.Errorf("checkpoint label longer than %d characters", maxCheckpointLabel)
+	case strings.IndexFunc(label, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0:
+		return Checkpoint{}, fmt.Errorf("checkpoint label %q is not printable", label)
+	}
+
+	// Only an added checkpoint counts as a change; see lock.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.kind != KindText {
+		return Checkpoint{}, fmt.Errorf("%w: checkpoint of %s document", ErrWrongKind, d.kind)
+	}
+	if len(d.checkpoints) >= maxCheckpoints {
+		return Checkpoint{}, ErrTooManyCheckpoints
+	}
+	var id [8]byte
+	rand.Read(id[:])
+	cp := Checkpoint{
+		ID:        hex.EncodeToString(id[:]),
+		Label:     label,
+		Version:   d.version,
+		Content:   d.content,
+		CreatedAt: d.clock.Now(),
+	}
+	d.checkpoints = append(d.checkpoints, cp)
+	d.changes++
+	return cp, nil
+}
+
+// Checkpoints returns the document's checkpoints, oldest first.
+func (d *Document) Checkpoints() []Checkpoint {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.Clone(d.checkpoints)
+}
+
+// Checkpoint returns the checkpoint with the given ID.
+func (d *Document) Checkpoint(id string) (Checkpoint, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if i := d.checkpointIndex(id); i >= 0 {
+		return d.checkpoints[i], nil
+	}
+	return Checkpoint{}, ErrCheckpointNotFound
+}
+
+// DeleteCheckpoint removes the checkpoint with the given ID.
+func (d *Document) DeleteCheckpoint(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i := d.checkpointIndex(id)
+	if i < 0 {
+		return ErrCheckpointNotFound
+	}
+	d.checkpoints = slices.Delete(d.checkpoints, i, i+1)
+	d.changes++
+	return nil
+}
+
+func (d *Document) checkpointIndex(id string) int {
+	return slices.IndexFunc(d.checkpoints, func(cp Checkpoint) bool { return cp.ID == id })
+}
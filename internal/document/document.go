@@ -1,29 +1,152 @@
 package document
 
 import (
+	"collaborative-docs/internal/blocks"
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/jsondoc"
 	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/sanitize"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/internal/textnorm"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 )
 
+// Kind determines how document content is interpreted and edited.
+type Kind string
+
+const (
+	KindText Kind = "text" // Plain or markdown text edited with character operations
+	KindJSON Kind = "json" // JSON tree edited with jsondoc operations
+)
+
+// ErrWrongKind is returned for an edit the document's kind does not take,
+// such as a text operation on a JSON document.
+var ErrWrongKind = errors.New("wrong document kind")
+
+// Visibility controls who may open a document.
+type Visibility string
+
+const (
+	VisibilityOpen    Visibility = "open"    // Anyone who knows the ID may read and edit (the default)
+	VisibilityPrivate Visibility = "private" // Only admin-authorized requests
+	VisibilityLink    Visibility = "link"    // Anyone presenting the link token
+	VisibilityPublic  Visibility = "public"  // Anyone may read; editing needs the link token
+)
+
+// Valid reports whether v is a known visibility.
+func (v Visibility) Valid() bool {
+	switch v {
+	case VisibilityOpen, VisibilityPrivate, VisibilityLink, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
 // Document represents thread-safe shared document state.
 // It tracks content, version number, and last modification time.
 type Document struct {
-	content      string
-	version      int
-	lastModified time.Time
-	mu           sync.RWMutex
+	content       string
+	version       int
+	lastModified  time.Time
+	language      string
+	validator     string // Syntax checked after each change, e.g. "json"
+	kind          Kind
+	blobs         map[string]*Blob
+	metadata      map[string]string
+	disabled      map[string]bool // Optional features turned off for this document
+	visibility    Visibility
+	linkToken     string                 // Grants access to link and public documents
+	invites       map[string]*Invite     // By token
+	grants        map[string]Grant       // Access list, by access token
+	preferences   map[string]Preferences // By user ID
+	owner         string                 // User who may always read and edit
+	team          string                 // Team whose members get their team role; see Team
+	roles         map[string]Role        // Access list, by user ID
+	scheduled     []ScheduledAction      // Soonest first
+	clock         clock.Clock
+	schema        *schema.Schema      // Line structure enforced on text edits, if set
+	limits        schema.Limits       // Shape enforced on text edits
+	sanitizer     sanitize.Policy     // Cleaning applied to inserted text by the hub
+	normalization textnorm.Form       // Unicode form text is kept in
+	published     *Publication        // Last approved version, if any
+	checkpoints   []Checkpoint        // Named copies of the content, oldest first
+	changes       uint64              // Calls that may have changed durable state; see Changes
+	applied       appliedIDs          // Recent client operation IDs, for deduplication
+	history       []revision          // Changes behind the latest versions, oldest first
+	window        HistoryWindow       // Bounds history
+	formatting    operations.Spans    // Rich-text attributes over a text document's content
+	fences        Fences              // Ranges of a text document's content only some users may edit
+	edits         *operations.History // Operations by author, for per-client undo and redo
+	mu            sync.RWMutex
+
+	// Cell-level versioning for KindJSON documents.
+	pathVersions   jsondoc.PathVersions
+	conflictPolicy jsondoc.ConflictPolicy
 }
 
 // NewDocument creates a new empty document.
 func NewDocument() *Document {
+	return NewDocumentWithClock(clock.Real)
+}
+
+// NewDocumentWithClock creates a new empty document whose modification
+// times come from c.
+func NewDocumentWithClock(c clock.Clock) *Document {
 	return &Document{
 		content:      "",
 		version:      0,
-		lastModified: time.Now(),
+		lastModified: c.Now(),
+		clock:        c,
+		kind:         KindText,
+		blobs:        make(map[string]*Blob),
+		metadata:     make(map[string]string),
+		disabled:     make(map[string]bool),
+		applied:      appliedIDs{versions: make(map[string]int)},
+		edits:        operations.NewHistory(maxHistory),
+
+		pathVersions:   make(jsondoc.PathVersions),
+		conflictPolicy: jsondoc.PolicyLastWriterWins,
 	}
 }
 
+// GetKind returns the document kind.
+func (d *Document) GetKind() Kind {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.kind
+}
+
+// SetKind changes the document kind. It is only allowed before the first
+// edit; switching to KindJSON initializes the content to an empty object.
+func (d *Document) SetKind(kind Kind) error {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if d.kind == kind {
+		return nil
+	}
+	if d.version != 0 {
+		return fmt.Errorf("%w: cannot change kind of document at version %d", ErrWrongKind, d.version)
+	}
+
+	switch kind {
+	case KindText:
+		d.content = ""
+	case KindJSON:
+		d.content = "{}"
+		d.schema = nil // schemas describe text lines
+	default:
+		return fmt.Errorf("unknown document kind: %s", kind)
+	}
+	d.kind = kind
+	return nil
+}
+
 // GetContent returns the current document content.
 func (d *Document) GetContent() string {
 	d.mu.RLock()
@@ -31,14 +154,52 @@ func (d *Document) GetContent() string {
 	return d.content
 }
 
+// FormattedContent returns the current content with its rich-text
+// formatting, which only text documents carry, and version.
+func (d *Document) FormattedContent() (string, operations.Spans, int) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.content, slices.Clone(d.formatting), d.version
+}
+
 // SetContent updates the document content and increments the version.
-func (d *Document) SetContent(content string) {
-	d.mu.Lock()
+// Only text documents take content wholesale; others return ErrWrongKind,
+// since content that is not of their kind would break every later edit.
+func (d *Document) SetContent(content string) error {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if d.kind != KindText {
+		return fmt.Errorf("%w: content set on %s document", ErrWrongKind, d.kind)
+	}
+	previous := d.content
+	d.content = d.normalization.String(content)
+	d.version++
+	d.lastModified = d.clock.Now()
+	d.recordDiff(previous)
+	return nil
+}
+
+// SetContentIfChanged is like SetContent but leaves the document, including
+// its version, untouched when content is already current. It reports
+// whether the content changed.
+func (d *Document) SetContentIfChanged(content string) (int, bool, error) {
+	d.lock()
 	defer d.mu.Unlock()
 
+	if d.kind != KindText {
+		return d.version, false, fmt.Errorf("%w: content set on %s document", ErrWrongKind, d.kind)
+	}
+	content = d.normalization.String(content)
+	if content == d.content {
+		return d.version, false, nil
+	}
+	previous := d.content
 	d.content = content
 	d.version++
-	d.lastModified = time.Now()
+	d.lastModified = d.clock.Now()
+	d.recordDiff(previous)
+	return d.version, true, nil
 }
 
 // GetVersion returns the current version number.
@@ -48,6 +209,38 @@ func (d *Document) GetVersion() int {
 	return d.version
 }
 
+// GetLanguage returns the editing language (e.g. "go", "markdown"),
+// or an empty string for plain text documents.
+func (d *Document) GetLanguage() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.language
+}
+
+// SetLanguage sets the editing language metadata. It does not change the
+// content version.
+func (d *Document) SetLanguage(language string) {
+	d.lock()
+	defer d.mu.Unlock()
+	d.language = language
+}
+
+// GetValidator returns the name of the syntax validator run after each
+// change, or "" if there is none.
+func (d *Document) GetValidator() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.validator
+}
+
+// SetValidator sets the syntax validator run after each change; "" removes
+// it. It does not change the content version.
+func (d *Document) SetValidator(name string) {
+	d.lock()
+	defer d.mu.Unlock()
+	d.validator = name
+}
+
 // GetStats returns document version, last modified time, and content length.
 func (d *Document) GetStats() (version int, lastModified time.Time, length int) {
 	d.mu.RLock()
@@ -55,23 +248,623 @@ func (d *Document) GetStats() (version int, lastModified time.Time, length int)
 	return d.version, d.lastModified, len(d.content)
 }
 
+// Per-entry overheads used by MemoryUsage for map buckets and headers.
+const (
+	mapEntryOverhead = 48
+	blobOverhead     = 96
+	opOverhead       = 64
+)
+
+// MemoryUsage returns the approximate number of bytes the document retains:
+// content, blobs, metadata, JSON cell versions and revision history. It is an estimate for
+// ranking documents, not an exact heap measurement.
+func (d *Document) MemoryUsage() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	total := len(d.content) + len(d.language)
+	for id, b := range d.blobs {
+		total += len(id) + len(b.ContentType) + len(b.Filename) + len(b.Data) + blobOverhead
+	}
+	for k, v := range d.metadata {
+		total += len(k) + len(v) + mapEntryOverhead
+	}
+	for path := range d.pathVersions {
+		total += len(path) + mapEntryOverhead
+	}
+	for _, rev := range d.history {
+		for _, op := range rev.ops {
+			total += len(op.Text) + opOverhead
+		}
+	}
+	for _, cp := range d.checkpoints {
+		total += len(cp.ID) + len(cp.Label) + len(cp.Content)
+	}
+	return total
+}
+
+// CompactHistory discards per-cell version history, keeping only the newest
+// version for the whole document, and the revision history, and returns the
+// number of entries dropped. Conflict detection stays safe but becomes
+// document-wide, earlier versions can no longer be reconstructed, and
+// clients can no longer undo their earlier edits.
+func (d *Document) CompactHistory() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dropped := d.pathVersions.Compact() + len(d.history)
+	d.history = nil
+	d.edits.Reset()
+	return dropped
+}
+
 // ApplyOperation applies an OT operation and returns the new content and version.
 func (d *Document) ApplyOperation(op *operations.Operation) (string, int, error) {
-	d.mu.Lock()
+	d.lock()
 	defer d.mu.Unlock()
 
+	if d.kind != KindText {
+		return "", d.version, fmt.Errorf("%w: text operation on %s document", ErrWrongKind, d.kind)
+	}
+
+	if version, ok := d.applied.versions[op.ID]; ok && op.ID != "" {
+		return "", d.version, &DuplicateOperationError{ID: op.ID, Version: version}
+	}
+	if err := d.apply(op, ""); err != nil {
+		return "", d.version, err
+	}
+	return d.content, d.version, nil
+}
+
+// apply normalizes and applies op to the current content as the next
+// version, recorded as author's. Callers must hold d.mu.
+func (d *Document) apply(op *operations.Operation, author string) error {
+	d.normalizeOperation(op)
 	newContent, err := operations.Apply(d.content, op)
+	if err != nil {
+		return err
+	}
+	if err := d.checkBoundaries(d.content, newContent, op); err != nil {
+		return err
+	}
+	if err := d.checkSchema(newContent); err != nil {
+		return err
+	}
+
+	d.content = newContent
+	d.version++
+	d.lastModified = d.clock.Now()
+	d.record(author, op)
+	if op.ID != "" {
+		d.applied.add(op.ID, d.version)
+	}
+	return nil
+}
+
+// maxAppliedIDs bounds how many operation IDs a document remembers. A
+// client resubmitting an operation older than that applies it twice.
+const maxAppliedIDs = 1000
+
+// appliedIDs maps recently applied operation IDs to the version each one
+// produced, evicting the oldest first.
+type appliedIDs struct {
+	versions map[string]int
+	order    []string
+}
+
+func (a *appliedIDs) add(id string, version int) {
+	if len(a.order) == maxAppliedIDs {
+		delete(a.versions, a.order[0])
+		a.order = a.order[1:]
+	}
+	a.versions[id] = version
+	a.order = append(a.order, id)
+}
+
+// forgetAfter drops the IDs of operations that produced versions after
+// version, so they can be applied again.
+func (a *appliedIDs) forgetAfter(version int) {
+	kept := a.order[:0]
+	for _, id := range a.order {
+		if a.versions[id] > version {
+			delete(a.versions, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	a.order = kept
+}
+
+// DuplicateOperationError reports an operation whose ID was already
+// applied, typically one a client resubmitted after reconnecting.
+type DuplicateOperationError struct {
+	ID      string
+	Version int // Version the original application produced
+}
+
+func (e *DuplicateOperationError) Error() string {
+	return fmt.Sprintf("operation %s already applied at version %d", e.ID, e.Version)
+}
+
+// SetSchema enforces s on every later text edit; nil removes the schema.
+// The current content must already satisfy it.
+func (d *Document) SetSchema(s *schema.Schema) error {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if s != nil {
+		if d.kind != KindText {
+			return fmt.Errorf("schema on %s document", d.kind)
+		}
+		if err := s.Validate(d.content); err != nil {
+			return fmt.Errorf("current content does not satisfy schema: %w", err)
+		}
+	}
+	d.schema = s
+	return nil
+}
+
+// GetSchema returns the enforced schema, or nil if there is none.
+func (d *Document) GetSchema() *schema.Schema {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.schema
+}
+
+// SetLimits enforces l on every later text edit; zero Limits remove them.
+// The current content must already satisfy them.
+func (d *Document) SetLimits(l schema.Limits) error {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if l != (schema.Limits{}) && d.kind != KindText {
+		return fmt.Errorf("limits on %s document", d.kind)
+	}
+	if err := l.Validate(d.content); err != nil {
+		return fmt.Errorf("current content exceeds limits: %w", err)
+	}
+	d.limits = l
+	return nil
+}
+
+// Limits returns the enforced limits.
+func (d *Document) Limits() schema.Limits {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.limits
+}
+
+// ValidateContent reports whether content would be accepted as the
+// document's full content under its schema and limits.
+func (d *Document) ValidateContent(content string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.checkSchema(content)
+}
+
+// checkSchema validates content against the schema, if any, and the
+// limits. Callers must hold d.mu.
+func (d *Document) checkSchema(content string) error {
+	if err := d.limits.Validate(content); err != nil {
+		return err
+	}
+	if d.schema == nil {
+		return nil
+	}
+	return d.schema.Validate(content)
+}
+
+// VersionConflictError reports a write based on a stale document version.
+type VersionConflictError struct {
+	Expected int
+	Actual   int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict: expected %d, document is at %d", e.Expected, e.Actual)
+}
+
+// ReplaceContent replaces the content if the document is still at
+// expectedVersion, expressing the change as operations so connected clients
+// can apply it incrementally. Each returned operation carries the version
+// the document reached after applying it; the final version is returned.
+func (d *Document) ReplaceContent(expectedVersion int, content string) ([]*operations.Operation, int, error) {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if d.kind != KindText {
+		return nil, d.version, fmt.Errorf("%w: content replacement on %s document", ErrWrongKind, d.kind)
+	}
+	if d.version != expectedVersion {
+		return nil, d.version, &VersionConflictError{Expected: expectedVersion, Actual: d.version}
+	}
+	content = d.normalization.String(content)
+	if err := d.checkSchema(content); err != nil {
+		return nil, d.version, err
+	}
+
+	ops := operations.Diff(d.content, content, d.version)
+	for _, op := range ops {
+		newContent, err := operations.Apply(d.content, op)
+		if err != nil {
+			return nil, d.version, fmt.Errorf("failed to apply diff: %w", err)
+		}
+		d.content = newContent
+		d.version++
+		op.Version = d.version
+		d.record("", op)
+	}
+	if len(ops) > 0 {
+		d.lastModified = d.clock.Now()
+	}
+	return ops, d.version, nil
+}
+
+// ApplyBlockOperation applies a structural operation to the document's
+// markdown blocks and returns it as applied, with its new version. An
+// operation written against an older version is first transformed past
+// the changes since. Only the lines it touches are rewritten. An
+// operation that concurrent changes made redundant, such as deleting a
+// block already deleted, comes back as a no-op without a new version.
+func (d *Document) ApplyBlockOperation(op *blocks.Operation) (*blocks.Operation, int, error) {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if d.kind != KindText {
+		return nil, d.version, fmt.Errorf("%w: block operation on %s document", ErrWrongKind, d.kind)
+	}
+
+	op, err := d.rebaseBlockOperation(op)
+	if err != nil {
+		return nil, d.version, err
+	}
+	newContent, err := blocks.Splice(d.content, op)
+	if err != nil {
+		return nil, d.version, err
+	}
+	if op.Type == blocks.OpNoop {
+		op.Version = d.version
+		return op, d.version, nil
+	}
+	if err := d.checkSchema(newContent); err != nil {
+		return nil, d.version, err
+	}
+
+	previous := d.content
+	d.content = newContent
+	d.version++
+	d.lastModified = d.clock.Now()
+	d.recordDiff(previous)
+	op.Version = d.version
+	applied := *op
+	d.history[len(d.history)-1].block = &applied
+
+	return op, d.version, nil
+}
+
+// rebaseBlockOperation transforms a block operation written against an
+// older version past the changes since: block operations as they were
+// applied, and other edits as the lines they deleted and inserted.
+// Operations naming a version the document has not reached apply as
+// written. Callers must hold d.mu.
+func (d *Document) rebaseBlockOperation(op *blocks.Operation) (*blocks.Operation, error) {
+	if op == nil {
+		return nil, fmt.Errorf("block operation cannot be nil")
+	}
+	if op.Version >= d.version {
+		return op, nil
+	}
+	content, err := d.contentAt(op.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rev := range d.history {
+		if rev.version <= op.Version {
+			continue
+		}
+		next := content
+		for _, textOp := range rev.ops {
+			if next, err = operations.Apply(next, textOp); err != nil {
+				return nil, fmt.Errorf("failed to replay version %d: %w", rev.version, err)
+			}
+		}
+		var changes []*blocks.Operation
+		if rev.block != nil {
+			change := *rev.block
+			change.Version = op.Version
+			changes = []*blocks.Operation{&change}
+		} else {
+			changes = blocks.Diff(content, next, op.Version)
+		}
+		for _, change := range changes {
+			// The change was applied first, so it wins ties.
+			if _, op, err = blocks.Transform(change, op); err != nil {
+				return nil, err
+			}
+			op.Version = change.Version
+		}
+		content = next
+	}
+	return op, nil
+}
+
+// ApplyJSONOperation applies a JSON tree operation to a KindJSON document
+// and returns the new serialized content and version. An operation written
+// against an older version is first transformed past the JSON operations
+// since, so array indices follow concurrent inserts, deletes and moves.
+//
+// If op carries an IfVersion that the target cell has moved past, the
+// document's conflict policy decides the outcome. op is updated in place
+// with the path and value actually applied and its new PathVersion, so
+// callers can broadcast it as-is. An operation that concurrent changes
+// made redundant becomes a no-op and takes no new version.
+func (d *Document) ApplyJSONOperation(op *jsondoc.Operation) (string, int, error) {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if d.kind != KindJSON {
+		return "", d.version, fmt.Errorf("%w: json operation on %s document", ErrWrongKind, d.kind)
+	}
+
+	rebased, err := d.rebaseJSONOperation(op)
 	if err != nil {
 		return "", d.version, err
 	}
+	if rebased.Type == jsondoc.OpNoop {
+		*op = *rebased
+		return d.content, d.version, nil
+	}
 
+	resolved, err := jsondoc.ResolveConflict(d.content, rebased, d.pathVersions, d.conflictPolicy)
+	if err != nil {
+		return "", d.version, err
+	}
+
+	newContent, err := jsondoc.ApplyContent(d.content, resolved)
+	if err != nil {
+		return "", d.version, err
+	}
+
+	previous := d.content
 	d.content = newContent
 	d.version++
-	d.lastModified = time.Now()
+	d.lastModified = d.clock.Now()
+	d.recordDiff(previous)
+
+	d.pathVersions.Record(resolved, d.version)
+	*op = *resolved
+	op.Version = d.version
+	op.PathVersion = d.version
+	applied := *op
+	d.history[len(d.history)-1].json = &applied
 
 	return newContent, d.version, nil
 }
 
+// rebaseJSONOperation transforms a JSON operation written against an older
+// version past the JSON operations applied since. A version whose content
+// was replaced some other way, such as by a rollback, cannot be transformed
+// past and the client must resync. Callers must hold d.mu.
+func (d *Document) rebaseJSONOperation(op *jsondoc.Operation) (*jsondoc.Operation, error) {
+	if op == nil {
+		return nil, fmt.Errorf("json operation cannot be nil")
+	}
+	if op.Version >= d.version {
+		return op, nil
+	}
+	if oldest := d.oldest(); op.Version < oldest {
+		return nil, fmt.Errorf("%w: version %d, oldest is %d", ErrVersionUnavailable, op.Version, oldest)
+	}
+
+	for _, rev := range d.history {
+		if rev.version <= op.Version || (rev.json == nil && len(rev.ops) == 0) {
+			continue
+		}
+		if rev.json == nil {
+			return nil, fmt.Errorf("%w: version %d was not a json operation", ErrVersionUnavailable, rev.version)
+		}
+		change := *rev.json
+		change.Version = op.Version
+		// op wins ties, so of two sets of one cell the later applies, as
+		// the conflict policy then decides.
+		transformed, _, err := jsondoc.Transform(op, &change)
+		if err != nil {
+			return nil, err
+		}
+		transformed.Version = op.Version
+		op = transformed
+	}
+	return op, nil
+}
+
+// SetConflictPolicy sets how same-cell conflicts are resolved for JSON
+// operations carrying an IfVersion.
+func (d *Document) SetConflictPolicy(policy jsondoc.ConflictPolicy) {
+	d.lock()
+	defer d.mu.Unlock()
+	d.conflictPolicy = policy
+}
+
+// ConflictPolicy returns how same-cell conflicts are resolved for JSON
+// operations carrying an IfVersion.
+func (d *Document) ConflictPolicy() jsondoc.ConflictPolicy {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.conflictPolicy == "" {
+		return jsondoc.PolicyLastWriterWins
+	}
+	return d.conflictPolicy
+}
+
+// GetMetadata returns an application metadata value and whether it is set.
+func (d *Document) GetMetadata(key string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	value, ok := d.metadata[key]
+	return value, ok
+}
+
+// SetMetadata sets an application metadata value; an empty value removes
+// the key. It does not change the content version and reports whether the
+// stored metadata changed.
+func (d *Document) SetMetadata(key, value string) bool {
+	d.lock()
+	defer d.mu.Unlock()
+
+	old, exists := d.metadata[key]
+	if value == "" {
+		delete(d.metadata, key)
+		return exists
+	}
+	d.metadata[key] = value
+	return !exists || old != value
+}
+
+// Metadata returns a copy of all application metadata.
+func (d *Document) Metadata() map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	result := make(map[string]string, len(d.metadata))
+	for k, v := range d.metadata {
+		result[k] = v
+	}
+	return result
+}
+
+// SetVisibility sets who may open the document and the link token that
+// grants access to link and public documents.
+func (d *Document) SetVisibility(v Visibility, linkToken string) {
+	d.lock()
+	defer d.mu.Unlock()
+	d.visibility = v
+	d.linkToken = linkToken
+}
+
+// Visibility returns who may open the document and its link token.
+func (d *Document) Visibility() (Visibility, string) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.visibility == "" {
+		return VisibilityOpen, d.linkToken
+	}
+	return d.visibility, d.linkToken
+}
+
+// SetFeature enables or disables an optional feature, such as blobs or
+// presence, for this document. Features are enabled unless disabled here.
+// It reports whether the setting changed.
+func (d *Document) SetFeature(name string, enabled bool) bool {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if !d.disabled[name] == enabled {
+		return false
+	}
+	if enabled {
+		delete(d.disabled, name)
+	} else {
+		d.disabled[name] = true
+	}
+	return true
+}
+
+// FeatureEnabled reports whether an optional feature is enabled.
+func (d *Document) FeatureEnabled(name string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return !d.disabled[name]
+}
+
+// DisabledFeatures returns the disabled features in sorted order.
+func (d *Document) DisabledFeatures() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	names := make([]string, 0, len(d.disabled))
+	for name := range d.disabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetSanitizer sets how the hub cleans text inserted into this document.
+func (d *Document) SetSanitizer(p sanitize.Policy) {
+	d.lock()
+	defer d.mu.Unlock()
+	d.sanitizer = p
+}
+
+// Sanitizer returns how text inserted into this document is cleaned.
+func (d *Document) Sanitizer() sanitize.Policy {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.sanitizer
+}
+
+const (
+	maxBlobs     = 64               // Blobs a document may hold
+	maxBlobBytes = 16 * 1024 * 1024 // Total size of a document's blobs
+)
+
+var (
+	// ErrBlobExists is returned when storing a blob under an ID the
+	// document already holds one for.
+	ErrBlobExists = errors.New("blob already exists")
+	// ErrTooManyBlobs is returned when storing a blob would take a
+	// document past its blob count or size limit.
+	ErrTooManyBlobs = fmt.Errorf("document blobs limited to %d and %d bytes", maxBlobs, maxBlobBytes)
+)
+
+// Blob is a small binary file (e.g. a pasted image) stored with the document.
+type Blob struct {
+	ID          string `json:"id"`
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename,omitempty"`
+	Data        []byte `json:"data"`
+}
+
+// PutBlob stores a blob with the document. Blobs are never replaced, so
+// one collaborator cannot overwrite another's: an ID already taken returns
+// ErrBlobExists, and a blob past the document's limits ErrTooManyBlobs. It
+// does not change the content version.
+func (d *Document) PutBlob(blob *Blob) error {
+	d.lock()
+	defer d.mu.Unlock()
+	if _, ok := d.blobs[blob.ID]; ok {
+		return fmt.Errorf("%w: %s", ErrBlobExists, blob.ID)
+	}
+	size := len(blob.Data)
+	for _, b := range d.blobs {
+		size += len(b.Data)
+	}
+	if len(d.blobs) >= maxBlobs || size > maxBlobBytes {
+		return ErrTooManyBlobs
+	}
+	d.blobs[blob.ID] = blob
+	return nil
+}
+
+// GetBlob returns a stored blob, or nil if it does not exist.
+func (d *Document) GetBlob(id string) *Blob {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.blobs[id]
+}
+
+// BlobIDs returns the IDs of all blobs stored with the document.
+func (d *Document) BlobIDs() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	ids := make([]string, 0, len(d.blobs))
+	for id := range d.blobs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 // GetContentAndVersion atomically returns both content and version.
 func (d *Document) GetContentAndVersion() (string, int) {
 	d.mu.RLock()
@@ -1,6 +1,19 @@
 package document
 
 import (
+	"collaborative-docs/internal/blocks"
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/jsondoc"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/sanitize"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/internal/textnorm"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -144,7 +157,9 @@ func TestGetStats(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			doc := NewDocument()
+			fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+			doc := NewDocumentWithClock(fake)
+			fake.Advance(time.Minute)
 			doc.SetContent(tt.content)
 
 			version, lastModified, length := doc.GetStats()
@@ -157,8 +172,8 @@ func TestGetStats(t *testing.T) {
 				t.Errorf("length = %d, want %d", length, tt.wantLength)
 			}
 
-			if time.Since(lastModified) > time.Second {
-				t.Errorf("lastModified is too old: %v", lastModified)
+			if !lastModified.Equal(fake.Now()) {
+				t.Errorf("lastModified = %v, want %v", lastModified, fake.Now())
 			}
 		})
 	}
@@ -309,6 +324,903 @@ func TestContentSizes(t *testing.T) {
 	}
 }
 
+// TestApplyBlockOperation verifies structural operations rewrite markdown content.
+func TestApplyBlockOperation(t *testing.T) {
+	doc := NewDocument()
+	doc.SetContent("# Plan\n- first\n- second")
+
+	_, version, err := doc.ApplyBlockOperation(blocks.NewMoveBlockOp(2, 1, 1))
+	if err != nil {
+		t.Fatalf("ApplyBlockOperation() error: %v", err)
+	}
+
+	if content, want := doc.GetContent(), "# Plan\n- second\n- first"; content != want {
+		t.Errorf("content = %q, want %q", content, want)
+	}
+	if version != 2 {
+		t.Errorf("version = %d, want 2", version)
+	}
+
+	if _, _, err := doc.ApplyBlockOperation(blocks.NewDeleteBlockOp(9, 2)); err == nil {
+		t.Error("expected error for out-of-range block")
+	}
+	if v := doc.GetVersion(); v != 2 {
+		t.Errorf("version after failed op = %d, want 2", v)
+	}
+}
+
+// TestApplyBlockOperationStale verifies a block operation written against
+// an older version is transformed past the edits since, and that lines it
+// does not touch keep their markdown as written.
+func TestApplyBlockOperationStale(t *testing.T) {
+	doc := NewDocument()
+	doc.SetContent("# Plan\n* first\n* second\n* third") // 1
+
+	// A peer inserts a block above the list, then another prepends a line.
+	doc.ApplyBlockOperation(blocks.NewInsertBlockOp(1, blocks.Block{Kind: blocks.KindParagraph, Text: "intro"}, 1)) // 2
+	doc.ApplyOperation(operations.NewInsertOp(0, "Draft\n", 2))                                                     // 3
+
+	// Written against version 1, "second" was at index 2.
+	applied, version, err := doc.ApplyBlockOperation(blocks.NewDeleteBlockOp(2, 1))
+	if err != nil {
+		t.Fatalf("ApplyBlockOperation() error: %v", err)
+	}
+	if want := "Draft\n# Plan\nintro\n* first\n* third"; doc.GetContent() != want {
+		t.Errorf("content = %q, want %q", doc.GetContent(), want)
+	}
+	if applied.Index != 4 || applied.Version != version || version != 4 {
+		t.Errorf("applied = %v at version %d, want delete at 4, version 4", applied, version)
+	}
+
+	// Deleting "second" again is already done: a no-op, no new version.
+	applied, version, err = doc.ApplyBlockOperation(blocks.NewDeleteBlockOp(2, 1))
+	if err != nil || applied.Type != blocks.OpNoop || version != 4 {
+		t.Errorf("repeated delete = %v, %d, %v; want noop at version 4", applied, version, err)
+	}
+
+	if _, _, err := doc.ApplyBlockOperation(blocks.NewDeleteBlockOp(0, 9)); err != nil {
+		t.Errorf("future version refused: %v", err)
+	}
+}
+
+// TestJSONDocument verifies kind switching and JSON operations.
+func TestJSONDocument(t *testing.T) {
+	doc := NewDocument()
+
+	if err := doc.SetKind(KindJSON); err != nil {
+		t.Fatalf("SetKind() error: %v", err)
+	}
+	if got := doc.GetContent(); got != "{}" {
+		t.Errorf("initial JSON content = %q, want %q", got, "{}")
+	}
+
+	op := &jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"tasks"}, Value: []any{}}
+	content, version, err := doc.ApplyJSONOperation(op)
+	if err != nil {
+		t.Fatalf("ApplyJSONOperation() error: %v", err)
+	}
+	if content != `{"tasks":[]}` || version != 1 {
+		t.Errorf("got (%q, %d), want (%q, 1)", content, version, `{"tasks":[]}`)
+	}
+
+	if _, _, err := doc.ApplyOperation(operations.NewInsertOp(0, "x", 1)); err == nil {
+		t.Error("expected text operation on JSON document to fail")
+	}
+	if err := doc.SetKind(KindText); err == nil {
+		t.Error("expected kind change after first edit to fail")
+	}
+}
+
+// TestJSONCellVersions verifies edits to different cells never conflict while
+// stale edits to the same cell follow the document's policy.
+func TestJSONCellVersions(t *testing.T) {
+	doc := NewDocument()
+	doc.SetKind(KindJSON)
+	doc.SetConflictPolicy(jsondoc.PolicyReject)
+	doc.ApplyJSONOperation(&jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"a"}, Value: 1.0})
+	doc.ApplyJSONOperation(&jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"b"}, Value: 1.0})
+
+	// Both users saw version 2, then each edits a different cell.
+	seen := 2
+	first := &jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"a"}, Value: 2.0, IfVersion: &seen}
+	if _, _, err := doc.ApplyJSONOperation(first); err != nil {
+		t.Fatalf("edit of cell a failed: %v", err)
+	}
+	if first.PathVersion != 3 {
+		t.Errorf("PathVersion = %d, want 3", first.PathVersion)
+	}
+
+	second := &jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"b"}, Value: 2.0, IfVersion: &seen}
+	if _, _, err := doc.ApplyJSONOperation(second); err != nil {
+		t.Fatalf("edit of cell b conflicted: %v", err)
+	}
+
+	stale := &jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"a"}, Value: 3.0, IfVersion: &seen}
+	var conflict *jsondoc.ConflictError
+	if _, _, err := doc.ApplyJSONOperation(stale); !errors.As(err, &conflict) {
+		t.Errorf("stale edit of cell a error = %v, want conflict", err)
+	} else if conflict.Actual != 3 {
+		t.Errorf("cell a version = %d, want 3", conflict.Actual)
+	}
+}
+
+// TestJSONStaleOperation verifies a JSON operation written against an
+// older version follows concurrent array changes.
+func TestJSONStaleOperation(t *testing.T) {
+	doc := NewDocument()
+	doc.SetKind(KindJSON)
+	doc.ApplyJSONOperation(&jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"tasks"}, Value: []any{"a", "b"}}) // 1
+
+	// Another client inserts at the front after this one read version 1.
+	doc.ApplyJSONOperation(&jsondoc.Operation{Type: jsondoc.OpInsert, Path: jsondoc.Path{"tasks", 0}, Value: "new", Version: 1}) // 2
+
+	op := &jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"tasks", 1}, Value: "B", Version: 1}
+	content, version, err := doc.ApplyJSONOperation(op)
+	if err != nil {
+		t.Fatalf("ApplyJSONOperation() error: %v", err)
+	}
+	if want := `{"tasks":["new","a","B"]}`; content != want || version != 3 {
+		t.Errorf("content = %s at version %d, want %s at version 3", content, version, want)
+	}
+	if op.Path.String() != "/tasks/2" {
+		t.Errorf("applied path = %s, want /tasks/2", op.Path)
+	}
+
+	// A set of an element another client deleted is dropped.
+	doc.ApplyJSONOperation(&jsondoc.Operation{Type: jsondoc.OpDelete, Path: jsondoc.Path{"tasks", 0}, Version: 3}) // 4
+	op = &jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"tasks", 0}, Value: "x", Version: 3}
+	if _, version, err := doc.ApplyJSONOperation(op); err != nil || op.Type != jsondoc.OpNoop || version != 4 {
+		t.Errorf("set of deleted element = %s, %d, %v; want noop at version 4", op, version, err)
+	}
+}
+
+// TestMemoryUsage verifies memory accounting grows with everything the
+// document retains.
+func TestMemoryUsage(t *testing.T) {
+	doc := NewDocument()
+	base := doc.MemoryUsage()
+
+	doc.SetContent(strings.Repeat("a", 1000))
+	withContent := doc.MemoryUsage()
+	if want := base + 2*1000 + opOverhead; withContent != want { // content and the insert in history
+		t.Errorf("after content = %d, want %d", withContent, want)
+	}
+	doc.CompactHistory()
+	if withContent = doc.MemoryUsage(); withContent != base+1000 {
+		t.Errorf("after compaction = %d, want %d", withContent, base+1000)
+	}
+
+	if err := doc.PutBlob(&Blob{ID: "img", Data: make([]byte, 4096)}); err != nil {
+		t.Fatalf("PutBlob() error: %v", err)
+	}
+	withBlob := doc.MemoryUsage()
+	if err := doc.PutBlob(&Blob{ID: "img", Data: []byte{1}}); !errors.Is(err, ErrBlobExists) {
+		t.Errorf("PutBlob() over an existing ID error = %v, want ErrBlobExists", err)
+	}
+	if err := doc.PutBlob(&Blob{ID: "huge", Data: make([]byte, maxBlobBytes)}); !errors.Is(err, ErrTooManyBlobs) {
+		t.Errorf("PutBlob() past the size limit error = %v, want ErrTooManyBlobs", err)
+	}
+	if withBlob < withContent+4096 {
+		t.Errorf("after blob = %d, want >= %d", withBlob, withContent+4096)
+	}
+
+	doc.SetMetadata("title", "Notes")
+	if doc.MemoryUsage() <= withBlob {
+		t.Error("metadata not counted")
+	}
+}
+
+// TestReplaceContent verifies versioned replacement and conflict reporting.
+func TestReplaceContent(t *testing.T) {
+	doc := NewDocument()
+	doc.SetContent("the cat sat") // version 1
+
+	if _, _, err := doc.ReplaceContent(0, "stale"); err == nil {
+		t.Fatal("stale version accepted")
+	} else if conflict, ok := err.(*VersionConflictError); !ok || conflict.Actual != 1 {
+		t.Fatalf("error = %v, want VersionConflictError at version 1", err)
+	}
+
+	ops, version, err := doc.ReplaceContent(1, "the dog sat")
+	if err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+	if version != 3 || len(ops) != 2 || ops[1].Version != 3 {
+		t.Errorf("version = %d with %d ops, want 3 with 2", version, len(ops))
+	}
+	if got := doc.GetContent(); got != "the dog sat" {
+		t.Errorf("content = %q, want %q", got, "the dog sat")
+	}
+}
+
+// TestSchemaEnforcement verifies edits that break the schema are rejected
+// without changing the document.
+func TestSchemaEnforcement(t *testing.T) {
+	doc := NewDocument()
+	doc.SetContent("# Heading")
+	if err := doc.SetSchema(schema.TitleBody); err == nil {
+		t.Fatal("SetSchema accepted content that violates it")
+	}
+
+	doc = NewDocument()
+	if err := doc.SetSchema(schema.TitleBody); err != nil {
+		t.Fatalf("SetSchema() error: %v", err)
+	}
+	if _, _, err := doc.ApplyOperation(operations.NewInsertOp(0, "Title\n# Body", 0)); err != nil {
+		t.Fatalf("valid insert rejected: %v", err)
+	}
+	if _, _, err := doc.ApplyOperation(operations.NewInsertOp(0, "# ", 1)); err == nil {
+		t.Error("markdown title accepted")
+	}
+	if _, _, err := doc.ReplaceContent(1, "**Bold**\n"); err == nil {
+		t.Error("ReplaceContent accepted markdown title")
+	}
+	if content, version := doc.GetContentAndVersion(); content != "Title\n# Body" || version != 1 {
+		t.Errorf("document = %q at %d after rejected edits", content, version)
+	}
+}
+
+// TestSetContentIfChanged verifies identical content leaves the version alone.
+func TestSetContentIfChanged(t *testing.T) {
+	doc := NewDocument()
+	steps := []struct {
+		content     string
+		wantVersion int
+		wantChanged bool
+	}{
+		{"hello", 1, true},
+		{"hello", 1, false},
+		{"hello!", 2, true},
+		{"hello!", 2, false},
+	}
+
+	for _, step := range steps {
+		version, changed, err := doc.SetContentIfChanged(step.content)
+		if version != step.wantVersion || changed != step.wantChanged || err != nil {
+			t.Errorf("SetContentIfChanged(%q) = (%d, %v, %v), want (%d, %v, nil)",
+				step.content, version, changed, err, step.wantVersion, step.wantChanged)
+		}
+	}
+
+	json := NewDocument()
+	json.SetKind(KindJSON)
+	if err := json.SetContent("not json at all"); !errors.Is(err, ErrWrongKind) {
+		t.Errorf("SetContent() on a JSON document error = %v, want ErrWrongKind", err)
+	}
+	if _, changed, err := json.SetContentIfChanged("not json at all"); changed || !errors.Is(err, ErrWrongKind) {
+		t.Errorf("SetContentIfChanged() on a JSON document = %v, %v; want ErrWrongKind", changed, err)
+	}
+}
+
+// TestDuplicateOperation verifies an operation ID is applied once and that
+// the oldest IDs are forgotten past the limit.
+func TestDuplicateOperation(t *testing.T) {
+	doc := NewDocument()
+	op := operations.NewInsertOp(0, "a", 0)
+	op.ID = "op-1"
+	if _, _, err := doc.ApplyOperation(op); err != nil {
+		t.Fatalf("ApplyOperation() error: %v", err)
+	}
+	doc.ApplyOperation(operations.NewInsertOp(1, "b", 1)) // no ID, never deduplicated
+
+	_, version, err := doc.ApplyOperation(op)
+	var dup *DuplicateOperationError
+	if !errors.As(err, &dup) || dup.Version != 1 {
+		t.Fatalf("resubmission error = %v, want DuplicateOperationError at version 1", err)
+	}
+	if version != 2 || doc.GetContent() != "ab" {
+		t.Errorf("document at version %d with %q, want 2 with %q", version, doc.GetContent(), "ab")
+	}
+
+	for i := range maxAppliedIDs {
+		filler := operations.NewInsertOp(0, "x", 0)
+		filler.ID = fmt.Sprintf("filler-%d", i)
+		doc.ApplyOperation(filler)
+	}
+	if _, _, err := doc.ApplyOperation(op); err != nil {
+		t.Errorf("evicted ID still rejected: %v", err)
+	}
+}
+
+// TestContentAt verifies past versions are reconstructed across every kind
+// of edit, and that versions beyond the retained history are refused.
+func TestContentAt(t *testing.T) {
+	doc := NewDocument()
+	doc.ApplyOperation(operations.NewInsertOp(0, "hello", 0)) // 1
+	doc.SetContent("hello world")                             // 2
+	doc.ReplaceContent(2, "help world")                       // 3, 4
+	doc.SetContent("help world")                              // 5, unchanged
+	doc.ApplyBlockOperation(blocks.NewDeleteBlockOp(0, 5))    // 6
+
+	want := []string{"", "hello", "hello world", "hel world", "help world", "help world", doc.GetContent()}
+	for version, content := range want {
+		got, err := doc.ContentAt(version)
+		if err != nil || got != content {
+			t.Errorf("ContentAt(%d) = %q, %v; want %q", version, got, err, content)
+		}
+	}
+	if _, err := doc.ContentAt(len(want)); err == nil {
+		t.Error("future version accepted")
+	}
+
+	for i := range maxHistory {
+		doc.SetContent(fmt.Sprint(i))
+	}
+	if _, err := doc.ContentAt(0); !errors.Is(err, ErrVersionUnavailable) {
+		t.Errorf("ContentAt(0) error = %v, want ErrVersionUnavailable", err)
+	}
+	oldest := doc.GetVersion() - maxHistory
+	if got, err := doc.ContentAt(oldest + 1); err != nil || got != "0" {
+		t.Errorf("ContentAt(%d) = %q, %v; want %q", oldest+1, got, err, "0")
+	}
+}
+
+// TestUndoVersion verifies that undoing one version keeps later edits, and
+// that overlapping later edits are reported as a conflict.
+func TestUndoVersion(t *testing.T) {
+	doc := NewDocument()
+	doc.ApplyOperation(operations.NewInsertOp(0, "hello world", 0)) // 1
+	doc.ApplyOperation(operations.NewInsertOp(6, "key=s3cr3t ", 1)) // 2
+	doc.ApplyOperation(operations.NewInsertOp(0, "Note: ", 2))      // 3
+	doc.ApplyOperation(operations.NewInsertOp(28, "!", 3))          // 4
+	doc.ApplyOperation(operations.NewDeleteOp(0, "Note", 4))        // 5
+
+	ops, version, err := doc.UndoVersion(2)
+	if err != nil {
+		t.Fatalf("UndoVersion(2) error: %v", err)
+	}
+	if got := doc.GetContent(); got != ": hello world!" {
+		t.Errorf("content = %q, want %q", got, ": hello world!")
+	}
+	if len(ops) != 1 || ops[0].Version != 6 || version != 6 {
+		t.Errorf("ops = %v, version = %d; want one op at version 6", ops, version)
+	}
+
+	if _, _, err := doc.UndoVersion(5); err != nil {
+		t.Fatalf("UndoVersion(5) error: %v", err)
+	}
+	if got := doc.GetContent(); got != "Note: hello world!" {
+		t.Errorf("content after restoring a deletion = %q", got)
+	}
+
+	doc.ApplyOperation(operations.NewDeleteOp(7, "ell", 7)) // 8
+	if _, _, err := doc.UndoVersion(1); !errors.Is(err, ErrUndoConflict) {
+		t.Errorf("overlapping undo error = %v, want ErrUndoConflict", err)
+	}
+	if _, _, err := doc.UndoVersion(9); err == nil {
+		t.Error("future version accepted")
+	}
+	doc.CompactHistory()
+	if _, _, err := doc.UndoVersion(8); !errors.Is(err, ErrVersionUnavailable) {
+		t.Errorf("compacted undo error = %v, want ErrVersionUnavailable", err)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	doc := NewDocument()
+	doc.ApplyOperation(operations.NewInsertOp(0, "keep", 0)) // 1
+	op := operations.NewInsertOp(4, " tentative", 1)
+	op.ID = "op-2"
+	doc.ApplyOperation(op)                                   // 2
+	doc.ApplyOperation(operations.NewDeleteOp(0, "keep", 2)) // 3
+
+	if err := doc.Rollback(1); err != nil {
+		t.Fatalf("Rollback(1) error: %v", err)
+	}
+	if content, version := doc.GetContentAndVersion(); content != "keep" || version != 1 {
+		t.Errorf("after rollback = %q at %d, want %q at 1", content, version, "keep")
+	}
+	if _, _, err := doc.ApplyOperation(op); err != nil {
+		t.Errorf("rolled back operation ID still applied: %v", err)
+	}
+	if got, _ := doc.ContentAt(1); got != "keep" {
+		t.Errorf("ContentAt(1) = %q, want history trimmed to match", got)
+	}
+	if err := doc.Rollback(5); err == nil {
+		t.Error("future version accepted")
+	}
+}
+
+// TestRedact verifies that redaction scrubs the content and every retained
+// version, by pattern and by version range.
+func TestRedact(t *testing.T) {
+	doc := NewDocument()
+	doc.SetContent("user: ann")                      // 1
+	doc.SetContent("user: ann token=abc123")         // 2
+	doc.SetContent("user: ann token=abc123 role: x") // 3
+
+	content, version, err := doc.Redact(Redaction{Pattern: regexp.MustCompile(`token=\w+`)})
+	if err != nil {
+		t.Fatalf("Redact() error: %v", err)
+	}
+	if content != "user: ann [REDACTED] role: x" || version != 4 {
+		t.Errorf("Redact() = %q, %d", content, version)
+	}
+	for v := 0; v <= version; v++ {
+		if got, err := doc.ContentAt(v); err != nil || strings.Contains(got, "abc123") {
+			t.Errorf("ContentAt(%d) = %q, %v; want secret scrubbed", v, got, err)
+		}
+	}
+	if _, again, _ := doc.Redact(Redaction{Pattern: regexp.MustCompile(`abc123`)}); again != version {
+		t.Errorf("redaction matching nothing bumped version to %d", again)
+	}
+
+	doc = NewDocument()
+	doc.SetContent("notes")                                     // 1
+	doc.ApplyOperation(operations.NewInsertOp(5, " pw:", 1))    // 2
+	doc.ApplyOperation(operations.NewInsertOp(9, "hunter2", 2)) // 3
+	doc.ApplyOperation(operations.NewInsertOp(0, "# ", 3))      // 4
+	if _, _, err := doc.Redact(Redaction{FromVersion: 2, ToVersion: 3, Placeholder: "***"}); err != nil {
+		t.Fatalf("Redact(range) error: %v", err)
+	}
+	if got := doc.GetContent(); got != "# notes***" {
+		t.Errorf("content = %q, want %q", got, "# notes***")
+	}
+	want := []string{"", "notes", "notes", "notes***", "# notes***", "# notes***"}
+	for v, w := range want {
+		if got, err := doc.ContentAt(v); err != nil || got != w {
+			t.Errorf("ContentAt(%d) = %q, %v; want %q", v, got, err, w)
+		}
+	}
+	if _, _, err := doc.Redact(Redaction{FromVersion: 3, ToVersion: 9}); err == nil {
+		t.Error("out-of-range redaction accepted")
+	}
+}
+
+// TestRedactCopies verifies redaction also scrubs checkpoints and the
+// publication, which outlive history.
+func TestRedactCopies(t *testing.T) {
+	doc := NewDocument()
+	doc.SetContent("password=hunter2") // 1
+	cp, _ := doc.CreateCheckpoint("before")
+	doc.Publish(Publication{Version: 1, Content: doc.GetContent()})
+	doc.SetContent("password=") // 2
+
+	if _, _, err := doc.Redact(Redaction{Pattern: regexp.MustCompile(`hunter2`)}); err != nil {
+		t.Fatalf("Redact() error: %v", err)
+	}
+	if got, _ := doc.Checkpoint(cp.ID); got.Content != "password=[REDACTED]" {
+		t.Errorf("checkpoint content = %q, want the secret scrubbed", got.Content)
+	}
+	if p, _ := doc.Published(); p.Content != "password=[REDACTED]" {
+		t.Errorf("published content = %q, want the secret scrubbed", p.Content)
+	}
+
+	doc = NewDocument()
+	doc.SetContent("notes")                                  // 1
+	doc.ApplyOperation(operations.NewInsertOp(5, " pw:", 1)) // 2
+	cp, _ = doc.CreateCheckpoint("half typed")
+	doc.ApplyOperation(operations.NewInsertOp(9, "x", 2)) // 3
+	if _, _, err := doc.Redact(Redaction{FromVersion: 2, ToVersion: 3}); err != nil {
+		t.Fatalf("Redact(range) error: %v", err)
+	}
+	if got, _ := doc.Checkpoint(cp.ID); got.Content != "notes" {
+		t.Errorf("checkpoint inside the range = %q, want the content before it", got.Content)
+	}
+}
+
+// TestFormatting verifies a text document's formatting follows its edits,
+// redactions and rollbacks, and survives a snapshot.
+func TestFormatting(t *testing.T) {
+	bold := operations.Attributes{operations.AttrBold: "true"}
+	span := func(position, length int) operations.Span {
+		return operations.Span{Position: position, Length: length, Attributes: bold}
+	}
+	doc := NewDocument()
+	doc.SetContent("hello world")                                   // 1
+	doc.ApplyOperation(operations.NewFormatOp(6, "world", bold, 1)) // 2
+	doc.ApplyOperation(operations.NewInsertOp(0, "well, ", 2))      // 3
+	doc.ApplyOperation(operations.NewFormatOp(0, "well", bold, 3))  // 4
+	if _, spans, _ := doc.FormattedContent(); !reflect.DeepEqual(spans, operations.Spans{span(0, 4), span(12, 5)}) {
+		t.Errorf("formatting = %v, want \"well\" and \"world\" bold", spans)
+	}
+	if _, _, err := doc.Redact(Redaction{Pattern: regexp.MustCompile(`hello `)}); err != nil {
+		t.Fatalf("Redact() error: %v", err)
+	}
+	content, spans, _ := doc.FormattedContent()
+	if content != "well, [REDACTED]world" || !reflect.DeepEqual(spans, operations.Spans{span(0, 4), span(16, 5)}) {
+		t.Errorf("redacted = %q %v, want the formatting moved with the text", content, spans)
+	}
+	snap, _ := doc.Snapshot()
+	if again, _ := Restore(snap, clock.Real).Snapshot(); !reflect.DeepEqual(again.Formatting, spans) {
+		t.Errorf("restored formatting = %v, want %v", again.Formatting, spans)
+	}
+	if err := doc.Rollback(2); err != nil {
+		t.Fatalf("Rollback() error: %v", err)
+	}
+	if content, spans, _ := doc.FormattedContent(); content != "[REDACTED]world" || !reflect.DeepEqual(spans, operations.Spans{span(10, 5)}) {
+		t.Errorf("rolled back = %q %v, want \"world\" bold", content, spans)
+	}
+}
+
+// TestNormalization verifies content is kept in the document's form and
+// that operations splitting a character are refused.
+func TestNormalization(t *testing.T) {
+	const composed, decomposed = "\u00e9", "e\u0301"
+
+	doc := NewDocument()
+	doc.SetContent("caf" + decomposed)
+	version, changed, err := doc.SetNormalization(textnorm.FormNFC)
+	if err != nil || !changed || version != 2 || doc.GetContent() != "caf"+composed {
+		t.Fatalf("SetNormalization() = %d, %v, %v; content %q", version, changed, err, doc.GetContent())
+	}
+
+	op := operations.NewInsertOp(0, "d"+decomposed+"j"+decomposed+" ", 2)
+	if _, _, err := doc.ApplyOperation(op); err != nil {
+		t.Fatalf("ApplyOperation() error: %v", err)
+	}
+	if want := "d" + composed + "j" + composed + " "; op.Text != want {
+		t.Errorf("inserted text = %q, want %q", op.Text, want)
+	}
+
+	tests := []struct {
+		name string
+		op   *operations.Operation
+	}{
+		{"inside a cluster", operations.NewInsertOp(1, "x", 3)},
+		{"lone combining accent", operations.NewInsertOp(1, "\u0301", 3)},
+		{"delete half a cluster", operations.NewDeleteOp(0, "e", 3)},
+	}
+	doc.SetContent(composed + " and d")
+	if _, _, err := doc.SetNormalization(textnorm.FormNFD); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if _, _, err := doc.ApplyOperation(tt.op); !errors.Is(err, ErrSplitsCharacter) {
+			t.Errorf("%s: error = %v, want ErrSplitsCharacter", tt.name, err)
+		}
+	}
+
+	// Without a form only surrogate pairs are protected.
+	plain := NewDocument()
+	plain.SetContent(composed + "\U0001F600")
+	if _, _, err := plain.ApplyOperation(operations.NewInsertOp(2, "x", 1)); !errors.Is(err, ErrSplitsCharacter) {
+		t.Errorf("insert inside a surrogate pair: error = %v, want ErrSplitsCharacter", err)
+	}
+	if _, _, err := plain.ApplyOperation(operations.NewInsertOp(1, "\u0301", 1)); err != nil {
+		t.Errorf("combining accent without a form: error = %v", err)
+	}
+}
+
+func TestApplyRebased(t *testing.T) {
+	doc := NewDocument()
+	doc.ApplyOperation(operations.NewInsertOp(0, "hello world", 0)) // 1
+	doc.ApplyOperation(operations.NewInsertOp(0, "Say: ", 1))       // 2
+
+	// Written against version 1, before "Say: " was inserted.
+	op := operations.NewInsertOp(11, "!", 1)
+	op.ID = "bot-1"
+	applied, err := doc.ApplyRebased(op)
+	if err != nil {
+		t.Fatalf("ApplyRebased() error: %v", err)
+	}
+	if got := doc.GetContent(); got != "Say: hello world!" {
+		t.Errorf("content = %q, want %q", got, "Say: hello world!")
+	}
+	if applied.Position != 16 || applied.Version != 3 || applied.ID != "bot-1" {
+		t.Errorf("applied = %+v, want position 16 at version 3", applied)
+	}
+	var dup *DuplicateOperationError
+	if _, err := doc.ApplyRebased(op); !errors.As(err, &dup) || dup.Version != 3 {
+		t.Errorf("resubmitted ApplyRebased() error = %v, want duplicate at version 3", err)
+	}
+
+	// A deletion of text someone else already deleted changes nothing.
+	doc.ApplyOperation(operations.NewDeleteOp(0, "Say: ", 3)) // 4
+	if applied, err := doc.ApplyRebased(operations.NewDeleteOp(0, "Say: ", 3)); err != nil || applied != nil {
+		t.Errorf("redundant ApplyRebased() = %v, %v; want nothing applied", applied, err)
+	}
+	if doc.GetVersion() != 4 {
+		t.Errorf("version = %d, want 4", doc.GetVersion())
+	}
+	if _, err := doc.ApplyRebased(operations.NewInsertOp(0, "x", 5)); err == nil {
+		t.Error("ApplyRebased() from a future version succeeded")
+	}
+}
+
+func TestApplyConcurrent(t *testing.T) {
+	doc := NewDocument()
+	apply := func(op *operations.Operation, author string) *operations.Operation {
+		t.Helper()
+		applied, err := doc.ApplyConcurrent(op, author)
+		if err != nil {
+			t.Fatalf("ApplyConcurrent(%v, %s) error: %v", op, author, err)
+		}
+		return applied
+	}
+	apply(operations.NewInsertOp(0, "cat", 0), "alice") // 1
+
+	// Bob and Alice both edit version 1; Bob's edit is moved past Alice's.
+	apply(operations.NewInsertOp(0, "A ", 1), "alice") // 2
+	if applied := apply(operations.NewInsertOp(3, "s", 1), "bob"); applied.Position != 5 || applied.Version != 3 {
+		t.Errorf("applied = %+v, want position 5 at version 3", applied)
+	}
+
+	// Alice's next edit still names version 1, but her own insert is
+	// already beneath it in her replica; only Bob's is new to her.
+	if applied := apply(operations.NewInsertOp(2, "big ", 1), "alice"); applied.Position != 2 {
+		t.Errorf("applied = %+v, want position 2", applied)
+	}
+	if got := doc.GetContent(); got != "A big cats" {
+		t.Errorf("content = %q, want %q", got, "A big cats")
+	}
+
+	// Versions that cannot be rebased from apply as written.
+	apply(operations.NewInsertOp(0, ">", 99), "carol")
+	doc.CompactHistory()
+	apply(operations.NewInsertOp(1, " ", 0), "carol")
+	if got := doc.GetContent(); got != "> A big cats" {
+		t.Errorf("content = %q, want %q", got, "> A big cats")
+	}
+}
+
+// TestSnapshot verifies a restored document matches the one snapshotted,
+// and that changes are counted.
+func TestSnapshot(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDocumentWithClock(fake)
+	d.ApplyOperation(&operations.Operation{Type: operations.OpInsert, Position: 0, Text: "hello", Version: 0})
+	d.SetMetadata("title", "Greeting")
+	d.SetFeature("presence", false)
+	d.SetVisibility(VisibilityLink, "link-token")
+	d.AddInvite(Invite{Token: "inv", Role: RoleEditor})
+	if _, err := d.RedeemInvite("inv", "bob", "bob-token", fake.Now()); err != nil {
+		t.Fatalf("RedeemInvite() error: %v", err)
+	}
+	d.Publish(Publication{Version: 1, Content: "hello", PublishedAt: fake.Now()})
+	d.PutBlob(&Blob{ID: "b1", ContentType: "image/png", Data: []byte{1}})
+	d.SetPreferences("bob", Preferences{CursorColor: "#ff8800", LastReadVersion: 1})
+	d.SetOwner("alice")
+	d.SetRole("carol", RoleViewer)
+	d.SetTeam("writers")
+	d.Schedule(ScheduledAction{ID: "s1", Action: ActionLock, At: fake.Now().Add(time.Hour)})
+	d.SetSanitizer(sanitize.Standard)
+	if err := d.SetLimits(schema.Limits{MaxLineLength: 80}); err != nil {
+		t.Fatalf("SetLimits() error: %v", err)
+	}
+
+	snap, changes := d.Snapshot()
+	if changes != d.Changes() || changes == 0 {
+		t.Errorf("Snapshot() changes = %d, Changes() = %d", changes, d.Changes())
+	}
+	restored := Restore(snap, fake)
+	if content, version := restored.GetContentAndVersion(); content != "hello" || version != 1 {
+		t.Errorf("restored content %q at version %d", content, version)
+	}
+	if grant, ok := restored.GrantFor("bob-token"); !ok || grant.Role != RoleEditor {
+		t.Errorf("restored grant = %+v, %v", grant, ok)
+	}
+	if role, ok := restored.RoleFor("alice"); !ok || role != RoleEditor {
+		t.Errorf("restored owner's role = %q, %v; want editor", role, ok)
+	}
+	if role, ok := restored.RoleFor("carol"); !ok || role != RoleViewer {
+		t.Errorf("restored role = %q, %v; want viewer", role, ok)
+	}
+	if restored.Team() != "writers" {
+		t.Errorf("restored team = %q, want writers", restored.Team())
+	}
+	if restored.Sanitizer() != sanitize.Standard || restored.Limits().MaxLineLength != 80 {
+		t.Errorf("restored sanitizer %+v and limits %+v, want those set", restored.Sanitizer(), restored.Limits())
+	}
+	if again, _ := restored.Snapshot(); !reflect.DeepEqual(again, snap) {
+		t.Errorf("restored snapshot = %+v, want %+v", again, snap)
+	}
+
+	restored.CompactHistory()
+	if restored.Changes() != 0 {
+		t.Errorf("CompactHistory counted as a change")
+	}
+	restored.SetMetadata("title", "Other")
+	if restored.Changes() != 1 {
+		t.Errorf("Changes() = %d after SetMetadata, want 1", restored.Changes())
+	}
+	restored.SetSanitizer(sanitize.Policy{})
+	if restored.Changes() != 2 {
+		t.Errorf("Changes() = %d after SetSanitizer, want 2", restored.Changes())
+	}
+}
+
+// TestPreferences verifies that preferences are kept per user, that the
+// least recently updated are dropped past the limit, and deletion.
+func TestPreferences(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDocumentWithClock(fake)
+
+	if _, ok := d.Preferences("alice"); ok {
+		t.Error("preferences found before any were set")
+	}
+	stored := d.SetPreferences("alice", Preferences{CursorColor: "#00ff00", ScrollPosition: 12})
+	if !stored.UpdatedAt.Equal(fake.Now()) {
+		t.Errorf("UpdatedAt = %v, want %v", stored.UpdatedAt, fake.Now())
+	}
+	if got, ok := d.Preferences("alice"); !ok || got != stored {
+		t.Errorf("Preferences(alice) = %+v, %v; want %+v", got, ok, stored)
+	}
+	if _, ok := d.Preferences("bob"); ok {
+		t.Error("bob has alice's preferences")
+	}
+
+	// Filling the document drops alice, updated longest ago.
+	for i := 0; i < maxPreferenceUsers; i++ {
+		fake.Advance(time.Second)
+		d.SetPreferences(fmt.Sprintf("user-%d", i), Preferences{ScrollPosition: i})
+	}
+	if _, ok := d.Preferences("alice"); ok {
+		t.Error("alice kept past the limit")
+	}
+	if got, ok := d.Preferences("user-0"); !ok || got.ScrollPosition != 0 {
+		t.Errorf("Preferences(user-0) = %+v, %v", got, ok)
+	}
+
+	if !d.DeletePreferences("user-0") {
+		t.Error("DeletePreferences(user-0) = false")
+	}
+	if d.DeletePreferences("user-0") {
+		t.Error("DeletePreferences(user-0) = true the second time")
+	}
+}
+
+// TestSchedule verifies that scheduled actions are kept in time order and
+// taken once due.
+func TestSchedule(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDocumentWithClock(fake)
+	now := fake.Now()
+
+	if _, ok := d.NextScheduled(); ok {
+		t.Error("NextScheduled() found an action before any was scheduled")
+	}
+	d.Schedule(ScheduledAction{ID: "publish", Action: ActionPublish, At: now.Add(2 * time.Hour)})
+	d.Schedule(ScheduledAction{ID: "lock", Action: ActionLock, At: now.Add(time.Hour)})
+	d.Schedule(ScheduledAction{ID: "unlock", Action: ActionUnlock, At: now.Add(2 * time.Hour)})
+	if next, ok := d.NextScheduled(); !ok || !next.Equal(now.Add(time.Hour)) {
+		t.Errorf("NextScheduled() = %v, %v; want %v", next, ok, now.Add(time.Hour))
+	}
+
+	changes := d.Changes()
+	if due := d.TakeDue(now); len(due) != 0 || d.Changes() != changes {
+		t.Errorf("TakeDue before any is due = %+v, changes %d -> %d", due, changes, d.Changes())
+	}
+	// Actions due at once come in the order they were scheduled.
+	due := d.TakeDue(now.Add(3 * time.Hour))
+	var ids []string
+	for _, a := range due {
+		ids = append(ids, a.ID)
+	}
+	if want := []string{"lock", "publish", "unlock"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("TakeDue() = %v, want %v", ids, want)
+	}
+	if left := d.Scheduled(); len(left) != 0 {
+		t.Errorf("Scheduled() = %+v after taking all", left)
+	}
+
+	d.Schedule(ScheduledAction{ID: "a", Action: ActionLock, At: now})
+	if err := d.Unschedule("missing"); !errors.Is(err, ErrScheduledNotFound) {
+		t.Errorf("Unschedule(missing) error = %v", err)
+	}
+	if err := d.Unschedule("a"); err != nil {
+		t.Errorf("Unschedule(a) error: %v", err)
+	}
+	for i := 0; i < maxScheduledActions; i++ {
+		d.Schedule(ScheduledAction{ID: fmt.Sprint(i), Action: ActionLock, At: now})
+	}
+	if err := d.Schedule(ScheduledAction{ID: "over", Action: ActionLock, At: now}); !errors.Is(err, ErrScheduleFull) {
+		t.Errorf("Schedule past the limit error = %v", err)
+	}
+}
+
+// TestOperationsSince verifies that the retained operations replay a
+// replica to the current content, and that truncated versions cannot be
+// caught up from.
+func TestOperationsSince(t *testing.T) {
+	d := NewDocument()
+	d.SetContent("hello")                                                                                       // 1
+	d.ApplyOperation(&operations.Operation{Type: operations.OpInsert, Position: 5, Text: " world", Version: 1}) // 2
+	d.SetContent("hello there")                                                                                 // 3
+
+	for since, replica := range map[int]string{0: "", 1: "hello", 2: "hello world", 3: "hello there"} {
+		ops, err := d.OperationsSince(since)
+		if err != nil {
+			t.Fatalf("OperationsSince(%d) error: %v", since, err)
+		}
+		for _, op := range ops {
+			if replica, err = operations.Apply(replica, op); err != nil {
+				t.Fatalf("OperationsSince(%d): applying version %d: %v", since, op.Version, err)
+			}
+		}
+		if replica != "hello there" {
+			t.Errorf("OperationsSince(%d) replays to %q", since, replica)
+		}
+	}
+	if _, err := d.OperationsSince(4); !errors.Is(err, ErrFutureVersion) {
+		t.Errorf("OperationsSince(4) error = %v, want ErrFutureVersion", err)
+	}
+
+	if dropped := d.TruncateHistory(1); dropped != 2 {
+		t.Errorf("TruncateHistory(1) dropped %d, want 2", dropped)
+	}
+	if dropped := d.TruncateHistory(1); dropped != 0 {
+		t.Errorf("second TruncateHistory(1) dropped %d, want 0", dropped)
+	}
+	if _, err := d.OperationsSince(1); !errors.Is(err, ErrVersionUnavailable) {
+		t.Errorf("OperationsSince(1) after truncation error = %v, want ErrVersionUnavailable", err)
+	}
+	if ops, err := d.OperationsSince(2); err != nil || len(ops) == 0 || ops[0].Version != 3 {
+		t.Errorf("OperationsSince(2) after truncation = %+v, %v", ops, err)
+	}
+}
+
+// TestAuthorsSince verifies the authors of later changes are listed once
+// each, without the one asking or changes no client made.
+func TestAuthorsSince(t *testing.T) {
+	d := NewDocument()
+	d.SetContent("hello")                                              // 1
+	d.ApplyConcurrent(operations.NewInsertOp(5, " world", 1), "alice") // 2
+	d.ApplyConcurrent(operations.NewInsertOp(0, "> ", 2), "bob")       // 3
+	d.ApplyConcurrent(operations.NewInsertOp(0, "#", 3), "alice")      // 4
+
+	if got, err := d.AuthorsSince(0, "carol"); err != nil || !slices.Equal(got, []string{"alice", "bob"}) {
+		t.Errorf("AuthorsSince(0) = %v, %v; want alice, bob", got, err)
+	}
+	if got, err := d.AuthorsSince(2, "alice"); err != nil || !slices.Equal(got, []string{"bob"}) {
+		t.Errorf("AuthorsSince(2) except alice = %v, %v; want bob", got, err)
+	}
+	if got, err := d.AuthorsSince(4, ""); err != nil || len(got) != 0 {
+		t.Errorf("AuthorsSince(4) = %v, %v; want none", got, err)
+	}
+	d.TruncateHistory(1)
+	if _, err := d.AuthorsSince(1, ""); !errors.Is(err, ErrVersionUnavailable) {
+		t.Errorf("AuthorsSince(1) after truncation error = %v, want ErrVersionUnavailable", err)
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	doc := NewDocument()
+	doc.SetContent("hello")                                              // 1
+	doc.ApplyConcurrent(operations.NewInsertOp(5, " world", 1), "alice") // 2
+	doc.ApplyConcurrent(operations.NewInsertOp(0, "> ", 2), "bob")       // 3
+
+	op, err := doc.Undo("alice")
+	if err != nil || op == nil || op.Version != 4 {
+		t.Fatalf("Undo() = %v, %v; want an operation at version 4", op, err)
+	}
+	if got := doc.GetContent(); got != "> hello" {
+		t.Errorf("content after Undo = %q, want %q", got, "> hello")
+	}
+
+	// An edit sent before the sender saw its undo is transformed past it.
+	if _, err := doc.ApplyConcurrent(operations.NewInsertOp(13, "!", 3), "alice"); err != nil {
+		t.Fatalf("ApplyConcurrent() error: %v", err)
+	}
+	if got := doc.GetContent(); got != "> hello!" {
+		t.Errorf("content = %q, want %q", got, "> hello!")
+	}
+	if _, err := doc.Redo("alice"); !errors.Is(err, ErrNothingToRedo) {
+		t.Errorf("Redo() after an edit error = %v, want ErrNothingToRedo", err)
+	}
+
+	for _, step := range []struct {
+		name   string
+		do     func(string) (*operations.Operation, error)
+		author string
+		want   string
+	}{
+		{"alice undo", doc.Undo, "alice", "> hello"},
+		{"alice redo", doc.Redo, "alice", "> hello!"},
+		{"bob undo", doc.Undo, "bob", "hello!"},
+	} {
+		if _, err := step.do(step.author); err != nil {
+			t.Fatalf("%s error: %v", step.name, err)
+		}
+		if got := doc.GetContent(); got != step.want {
+			t.Errorf("%s: content = %q, want %q", step.name, got, step.want)
+		}
+	}
+	if _, err := doc.Undo("carol"); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("Undo() by a non-author error = %v, want ErrNothingToUndo", err)
+	}
+}
+
 // BenchmarkGetContent measures read performance
 func BenchmarkGetContent(b *testing.B) {
 	doc := NewDocument()
@@ -343,3 +1255,196 @@ func BenchmarkConcurrentReads(b *testing.B) {
 		}
 	})
 }
+
+// TestFences verifies fences move with edits, are only changed by those
+// allowed and survive a snapshot.
+func TestFences(t *testing.T) {
+	doc := NewDocument()
+	doc.SetContent("Dear NAME, regards") // 1
+	fence := Fence{ID: "sign-off", Position: 9, Length: 9, Label: "boilerplate"}
+	for _, bad := range []Fences{
+		{{ID: "", Position: 0, Length: 1}},
+		{{ID: "a", Position: 0, Length: 0}},
+		{{ID: "a", Position: 10, Length: 9}},
+		{{ID: "a", Position: 0, Length: 5}, {ID: "b", Position: 4, Length: 2}},
+		{{ID: "a", Position: 0, Length: 1}, {ID: "a", Position: 2, Length: 1}},
+	} {
+		if err := doc.SetFences(1, bad); err == nil {
+			t.Errorf("SetFences(%v) succeeded", bad)
+		}
+	}
+	var conflict *VersionConflictError
+	if err := doc.SetFences(0, Fences{fence}); !errors.As(err, &conflict) {
+		t.Errorf("SetFences() at a stale version error = %v, want a version conflict", err)
+	}
+	if err := doc.SetFences(1, Fences{fence}); err != nil {
+		t.Fatalf("SetFences() error: %v", err)
+	}
+
+	// Before the fence and at its edges are open to everyone; inside is not.
+	if _, err := doc.ApplyWithinWindow(operations.NewInsertOp(5, "Ms ", 1), "a", true); err != nil {
+		t.Fatalf("insert before the fence: %v", err)
+	}
+	if _, err := doc.ApplyWithinWindow(operations.NewInsertOp(21, "!", 2), "a", true); err != nil {
+		t.Fatalf("insert at the fence's end: %v", err)
+	}
+	for _, op := range []*operations.Operation{
+		operations.NewInsertOp(14, "x", 3),
+		operations.NewDeleteOp(11, " r", 3),
+		operations.NewFormatOp(12, "re", operations.Attributes{operations.AttrBold: "true"}, 3),
+	} {
+		if _, err := doc.ApplyWithinWindow(op, "a", true); !errors.Is(err, ErrFenced) {
+			t.Errorf("%v inside the fence error = %v, want ErrFenced", op, err)
+		}
+	}
+	// Written before the insert at version 2, this lands inside the fence
+	// once rebased.
+	if _, err := doc.ApplyWithinWindow(operations.NewDeleteOp(11, "re", 1), "b", true); !errors.Is(err, ErrFenced) {
+		t.Errorf("rebased delete error = %v, want ErrFenced", err)
+	}
+	if err := doc.CheckFencedContent("Dear Ms NAME, thanks!"); !errors.Is(err, ErrFenced) {
+		t.Errorf("CheckFencedContent() of changed fenced text = %v, want ErrFenced", err)
+	}
+	if err := doc.CheckFencedContent("Dear Ms X, regards!"); err != nil {
+		t.Errorf("CheckFencedContent() of changes outside = %v", err)
+	}
+
+	// Those allowed may change fenced text, which moves the fence.
+	if _, err := doc.ApplyWithinWindow(operations.NewInsertOp(14, "kind ", 3), "c", false); err != nil {
+		t.Fatalf("allowed insert inside the fence: %v", err)
+	}
+	want := Fences{{ID: "sign-off", Position: 12, Length: 14, Label: "boilerplate"}}
+	if fences, version := doc.Fences(); version != 4 || !reflect.DeepEqual(fences, want) {
+		t.Errorf("Fences() = %v at %d, want %v at 4", fences, version, want)
+	}
+	snap, _ := doc.Snapshot()
+	if again, _ := Restore(snap, clock.Real).Fences(); !reflect.DeepEqual(again, want) {
+		t.Errorf("restored fences = %v, want %v", again, want)
+	}
+
+	doc.SetOwner("alice")
+	doc.SetRole("bob", RoleMaintainer)
+	doc.SetRole("carol", RoleEditor)
+	for user, want := range map[string]bool{"alice": true, "bob": true, "carol": false, "": false} {
+		if got := doc.MayEditFences(user); got != want {
+			t.Errorf("MayEditFences(%q) = %v, want %v", user, got, want)
+		}
+	}
+}
+
+func TestCheckpoints(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	doc := NewDocumentWithClock(fake)
+	doc.ReplaceContent(0, "draft")
+
+	for _, label := range []string{"", "  ", "bell\a", strings.Repeat("x", maxCheckpointLabel+1)} {
+		if _, err := doc.CreateCheckpoint(label); err == nil {
+			t.Errorf("CreateCheckpoint(%q) succeeded", label)
+		}
+	}
+	cp, err := doc.CreateCheckpoint(" before edits ")
+	if err != nil {
+		t.Fatalf("CreateCheckpoint() error: %v", err)
+	}
+	if cp.ID == "" || cp.Label != "before edits" || cp.Content != "draft" || cp.Version != doc.GetVersion() || !cp.CreatedAt.Equal(fake.Now()) {
+		t.Errorf("checkpoint = %+v", cp)
+	}
+
+	doc.ReplaceContent(doc.GetVersion(), "vandalized")
+	if got, err := doc.Checkpoint(cp.ID); err != nil || got.Content != "draft" {
+		t.Errorf("Checkpoint() after edits = %+v, %v, want the content it was created with", got, err)
+	}
+	snap, _ := doc.Snapshot()
+	restored := Restore(snap, fake)
+	if got := restored.Checkpoints(); len(got) != 1 || got[0] != cp {
+		t.Errorf("checkpoints after Restore = %+v, want [%+v]", got, cp)
+	}
+
+	for len(doc.Checkpoints()) < maxCheckpoints {
+		doc.CreateCheckpoint("again")
+	}
+	changes := doc.Changes()
+	if _, err := doc.CreateCheckpoint("one too many"); !errors.Is(err, ErrTooManyCheckpoints) {
+		t.Errorf("CreateCheckpoint() over the limit error = %v, want ErrTooManyCheckpoints", err)
+	}
+	if doc.Changes() != changes {
+		t.Error("refused checkpoint counted as a change")
+	}
+	if err := doc.DeleteCheckpoint(cp.ID); err != nil {
+		t.Fatalf("DeleteCheckpoint() error: %v", err)
+	}
+	if _, err := doc.Checkpoint(cp.ID); !errors.Is(err, ErrCheckpointNotFound) {
+		t.Errorf("Checkpoint() after delete error = %v, want ErrCheckpointNotFound", err)
+	}
+	changes = doc.Changes()
+	if err := doc.DeleteCheckpoint(cp.ID); !errors.Is(err, ErrCheckpointNotFound) {
+		t.Errorf("DeleteCheckpoint() twice error = %v, want ErrCheckpointNotFound", err)
+	}
+	if doc.Changes() != changes {
+		t.Error("refused checkpoint deletion counted as a change")
+	}
+
+	json := NewDocument()
+	json.SetKind(KindJSON)
+	if _, err := json.CreateCheckpoint("tree"); !errors.Is(err, ErrWrongKind) {
+		t.Errorf("CreateCheckpoint() on a JSON document error = %v, want ErrWrongKind", err)
+	}
+}
+
+// TestOpLogReplay verifies log entries replay from any version they span
+// to the content they checksum, and that replay stops at the first entry
+// that does not check out.
+func TestOpLogReplay(t *testing.T) {
+	d := NewDocument()
+	d.SetContent("hello")                                                                                       // 1
+	d.ApplyOperation(&operations.Operation{Type: operations.OpInsert, Position: 5, Text: " world", Version: 1}) // 2
+	d.SetContent("hello there")                                                                                 // 3
+
+	first, err := d.LogEntry(0, 2)
+	if err != nil {
+		t.Fatalf("LogEntry(0, 2) error: %v", err)
+	}
+	second, err := d.LogEntry(2, 3)
+	if err != nil {
+		t.Fatalf("LogEntry(2, 3) error: %v", err)
+	}
+	if first.Checksum != Checksum("hello world") || second.Checksum != Checksum("hello there") {
+		t.Errorf("LogEntry() checksums are not of the content at To")
+	}
+	if _, err := d.LogEntry(2, 4); err == nil {
+		t.Errorf("LogEntry(2, 4) past the current version succeeded")
+	}
+
+	entries := []*LogEntry{first, second}
+	for _, tc := range []struct {
+		content string
+		version int
+	}{{"", 0}, {"hello", 1}, {"hello world", 2}} {
+		content, version, verified := ReplayLog(tc.content, tc.version, entries)
+		if content != "hello there" || version != 3 || !verified {
+			t.Errorf("ReplayLog(%q, %d) = %q, %d, %v, want %q, 3, true", tc.content, tc.version, content, version, verified, "hello there")
+		}
+	}
+
+	bad := *second
+	bad.Checksum = Checksum("garbage")
+	if content, version, verified := ReplayLog("", 0, []*LogEntry{first, &bad}); content != "hello world" || version != 2 || !verified {
+		t.Errorf("ReplayLog() with a bad last entry = %q, %d, %v, want %q, 2, true", content, version, verified, "hello world")
+	}
+	if content, version, verified := ReplayLog("hello", 1, []*LogEntry{second}); content != "hello" || version != 1 || verified {
+		t.Errorf("ReplayLog() over a gap = %q, %d, %v, want the start unverified", content, version, verified)
+	}
+
+	snap, _ := d.Snapshot()
+	if err := snap.Verify(); err != nil {
+		t.Errorf("Verify() of a fresh snapshot error: %v", err)
+	}
+	snap.Content = "hello thère"
+	if err := snap.Verify(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Verify() of corrupt content error = %v, want ErrChecksumMismatch", err)
+	}
+	snap.Checksum = ""
+	if err := snap.Verify(); err != nil {
+		t.Errorf("Verify() without a checksum error: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"collaborative-docs/internal/operations"
+)
+
+// maxFences bounds how many fenced ranges a document may have.
+const maxFences = 100
+
+// ErrFenced is returned for an edit that changes fenced text by a user who
+// may not edit fences.
+var ErrFenced = errors.New("range is fenced")
+
+// Fence is a range of a text document's content that only users allowed
+// to edit fences may change, such as template boilerplate; see
+// MayEditFences. Everyone else may edit around it. It moves with the
+// edits before it and shrinks with deletions inside it. Text inserted at
+// its edges stays outside it, and a fence whose text is all deleted goes
+// away.
+type Fence struct {
+	ID       string `json:"id"`
+	Position int    `json:"position"` // In UTF-16 code units
+	Length   int    `json:"length"`
+	Label    string `json:"label,omitempty"` // Why the range is fenced, for display
+}
+
+// end returns the position just past the fence.
+func (f Fence) end() int {
+	return f.Position + f.Length
+}
+
+// Fences are a text's fenced ranges in position order, none overlapping
+// or empty.
+type Fences []Fence
+
+// Apply returns the fences after op, which is assumed to apply to the text
+// they fence: an insert strictly inside a fence grows it, other inserts
+// before one move it and a delete takes the text it removes out of every
+// fence. Formats leave fences as they are. fs is not modified.
+func (fs Fences) Apply(op *operations.Operation) Fences {
+	start, n := op.Position, op.Length()
+	end := start + n
+	var out Fences
+	for _, f := range fs {
+		switch op.Type {
+		case operations.OpInsert:
+			if f.Position >= start {
+				f.Position += n
+			} else if f.end() > start {
+				f.Length += n
+			}
+		case operations.OpDelete:
+			if f.Position >= end {
+				f.Position -= n
+			} else if f.end() > start {
+				f.Length -= min(f.end(), end) - max(f.Position, start)
+				f.Position = min(f.Position, start)
+			}
+		}
+		if f.Length > 0 {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Check returns an error wrapping ErrFenced if op would change fenced
+// text: an insert strictly inside a fence, or a delete or format of any
+// of a fence's text.
+func (fs Fences) Check(op *operations.Operation) error {
+	start, end := op.Position, op.Position+op.Length()
+	for _, f := range fs {
+		inside := start > f.Position && start < f.end()
+		if op.Type != operations.OpInsert {
+			inside = max(start, f.Position) < min(end, f.end())
+		}
+		if inside {
+			if f.Label != "" {
+				return fmt.Errorf("%w: %s (%s)", ErrFenced, f.ID, f.Label)
+			}
+			return fmt.Errorf("%w: %s", ErrFenced, f.ID)
+		}
+	}
+	return nil
+}
+
+// validate checks fs fences content: IDs are set and distinct, and the
+// ranges are in order, not empty, do not overlap and neither start nor
+// end inside a character.
+func (fs Fences) validate(content string) error {
+	if len(fs) > maxFences {
+		return fmt.Errorf("%d fences, limit is %d", len(fs), maxFences)
+	}
+	ids := make(map[string]bool, len(fs))
+	for i, f := range fs {
+		switch {
+		case f.ID == "":
+			return fmt.Errorf("fence %d: id is required", i)
+		case ids[f.ID]:
+			return fmt.Errorf("fence %s: duplicate id", f.ID)
+		case f.Length <= 0:
+			return fmt.Errorf("fence %s: length must be positive", f.ID)
+		case i > 0 && f.Position < fs[i-1].end():
+			return fmt.Errorf("fence %s: overlaps or precedes fence %s", f.ID, fs[i-1].ID)
+		}
+		for _, pos := range []int{f.Position, f.end()} {
+			if _, ok := operations.Offset(content, pos); !ok {
+				return fmt.Errorf("fence %s: position %d out of range or inside a character", f.ID, pos)
+			}
+		}
+		ids[f.ID] = true
+	}
+	return nil
+}
+
+// SetFences replaces the document's fences with fs, whose positions are in
+// the content of version; a stale version returns a
+// *VersionConflictError. Only text documents have fences.
+func (d *Document) SetFences(version int, fs Fences) error {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if d.kind != KindText && len(fs) > 0 {
+		return fmt.Errorf("%w: fences on %s document", ErrWrongKind, d.kind)
+	}
+	if d.version != version {
+		return &VersionConflictError{Expected: version, Actual: d.version}
+	}
+	fs = slices.Clone(fs)
+	slices.SortStableFunc(fs, func(a, b Fence) int { return a.Position - b.Position })
+	if err := fs.validate(d.content); err != nil {
+		return err
+	}
+	d.fences = fs
+	return nil
+}
+
+// Fences returns the document's fences and the version whose content they
+// fence.
+func (d *Document) Fences() (Fences, int) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return slices.Clone(d.fences), d.version
+}
+
+// HasFences reports whether any of the document's content is fenced.
+func (d *Document) HasFences() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.fences) > 0
+}
+
+// CheckFences returns an error wrapping ErrFenced if op, applied to the
+// current content, would change fenced text.
+func (d *Document) CheckFences(op *operations.Operation) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.fences.Check(op)
+}
+
+// MayEditFences reports whether a user may change fenced text: the owner
+// and maintainers may.
+func (d *Document) MayEditFences(userID string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return userID != "" && (userID == d.owner || d.roles[userID] == RoleMaintainer)
+}
+
+// CheckFencedContent returns an error wrapping ErrFenced if replacing the
+// content with content, as ReplaceContent would, changes fenced text.
+func (d *Document) CheckFencedContent(content string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	fs := d.fences
+	for _, op := range operations.Diff(d.content, d.normalization.String(content), d.version) {
+		if err := fs.Check(op); err != nil {
+			return err
+		}
+		fs = fs.Apply(op)
+	}
+	return nil
+}
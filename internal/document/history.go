@@ -0,0 +1,491 @@
+package document
+
+import (
+	"collaborative-docs/internal/blocks"
+	"collaborative-docs/internal/jsondoc"
+	"collaborative-docs/internal/operations"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// maxHistory is how many versions back a document can be rewound unless
+// its history window says otherwise.
+const maxHistory = 1000
+
+// maxHistoryWindow bounds the versions a history window may keep.
+const maxHistoryWindow = 100000
+
+var (
+	// ErrVersionUnavailable is returned for versions older than the retained
+	// history.
+	ErrVersionUnavailable = errors.New("version no longer retained")
+	// ErrFutureVersion is returned for operations written against a version
+	// the document has not reached.
+	ErrFutureVersion = errors.New("version not reached yet")
+	// ErrUndoConflict is returned when later edits changed the text a
+	// version's change touched, so it cannot be undone cleanly.
+	ErrUndoConflict = errors.New("change overlaps later edits")
+	// ErrNothingToUndo is returned by Undo when the author has no edit left
+	// to undo.
+	ErrNothingToUndo = operations.ErrNothingToUndo
+	// ErrNothingToRedo is returned by Redo when the author has undone
+	// nothing since their last edit.
+	ErrNothingToRedo = operations.ErrNothingToRedo
+)
+
+// revision is the change that produced one version.
+type revision struct {
+	version int
+	ops     []*operations.Operation // turn the previous version's content into this one's
+	author  string                  // Client that made the change, if recorded
+	at      time.Time               // When it was made
+	block   *blocks.Operation       // The block operation that made the change, if one did
+	json    *jsondoc.Operation      // The JSON operation that made the change, if one did
+}
+
+// HistoryWindow bounds the changes a text document keeps behind its
+// latest version, which are what late operations are transformed past,
+// reconnecting clients catch up from and past versions are rebuilt from.
+// A version is in the window while it is among the newest Versions and
+// the changes after it were made within MaxAge. Operations written against
+// older versions need the full content instead.
+type HistoryWindow struct {
+	Versions int           `json:"versions,omitempty"` // Zero keeps 1000
+	MaxAge   time.Duration `json:"max_age,omitempty"`  // Zero keeps changes of any age
+}
+
+// Validate checks that the window is neither negative nor larger than a
+// document may keep.
+func (w HistoryWindow) Validate() error {
+	if w.Versions < 0 || w.Versions > maxHistoryWindow {
+		return fmt.Errorf("history window of %d versions out of range [0, %d]", w.Versions, maxHistoryWindow)
+	}
+	if w.MaxAge < 0 {
+		return fmt.Errorf("history window age %s is negative", w.MaxAge)
+	}
+	return nil
+}
+
+// versions returns how many revisions the window keeps.
+func (w HistoryWindow) versions() int {
+	if w.Versions == 0 {
+		return maxHistory
+	}
+	return w.Versions
+}
+
+// SetHistoryWindow changes the window of changes the document keeps.
+// Narrowing it drops the changes now outside it.
+func (d *Document) SetHistoryWindow(w HistoryWindow) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	d.lock()
+	defer d.mu.Unlock()
+	if d.kind != KindText && w != (HistoryWindow{}) {
+		return fmt.Errorf("history window on %s document", d.kind)
+	}
+	d.window = w
+	if n := len(d.history) - w.versions(); n > 0 {
+		d.history = append([]revision(nil), d.history[n:]...)
+	}
+	return nil
+}
+
+// HistoryWindow returns the window of changes the document keeps.
+func (d *Document) HistoryWindow() HistoryWindow {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.window
+}
+
+// oldest returns the oldest version in the history window: the history
+// reaches back to it, and the changes after it are recent enough. Callers
+// must hold d.mu.
+func (d *Document) oldest() int {
+	oldest := d.version - len(d.history)
+	if d.window.MaxAge > 0 {
+		cutoff := d.clock.Now().Add(-d.window.MaxAge)
+		oldest += sort.Search(len(d.history), func(i int) bool { return !d.history[i].at.Before(cutoff) })
+	}
+	return oldest
+}
+
+// record appends the change that produced the current version, made by
+// author or, if empty, by no client, dropping the oldest revision outside
+// the history window. Every version increment must be recorded so the history
+// stays contiguous. Callers must hold d.mu.
+func (d *Document) record(author string, ops ...*operations.Operation) {
+	d.edits.Record(d.version, author, d.revise(author, ops...)...)
+}
+
+// revise appends a revision to the history, without making it undoable,
+// and returns the copies of ops it keeps. Callers must hold d.mu.
+func (d *Document) revise(author string, ops ...*operations.Operation) []*operations.Operation {
+	if len(d.history) >= d.window.versions() {
+		d.history = append(d.history[:0], d.history[len(d.history)-d.window.versions()+1:]...)
+	}
+	copies := make([]*operations.Operation, len(ops))
+	for i, op := range ops {
+		c := *op
+		copies[i] = &c
+		if d.kind == KindText {
+			d.formatting = d.formatting.Apply(op)
+			d.fences = d.fences.Apply(op)
+		}
+	}
+	d.history = append(d.history, revision{version: d.version, ops: copies, author: author, at: d.clock.Now()})
+	return copies
+}
+
+// recordDiff records the change from previous to the current content.
+// Callers must hold d.mu.
+func (d *Document) recordDiff(previous string) {
+	d.record("", operations.Diff(previous, d.content, d.version-1)...)
+}
+
+// ContentAt reconstructs the content as of version by undoing later
+// changes. Only versions in the history window can be reached; older ones
+// return ErrVersionUnavailable.
+func (d *Document) ContentAt(version int) (string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.contentAt(version)
+}
+
+// contentAt implements ContentAt. Callers must hold d.mu.
+func (d *Document) contentAt(version int) (string, error) {
+	if version < 0 || version > d.version {
+		return "", fmt.Errorf("version %d out of range [0, %d]", version, d.version)
+	}
+	if oldest := d.oldest(); version < oldest {
+		return "", fmt.Errorf("%w: version %d, oldest is %d", ErrVersionUnavailable, version, oldest)
+	}
+
+	content := d.content
+	for i := len(d.history) - 1; i >= 0 && d.history[i].version > version; i-- {
+		ops := d.history[i].ops
+		for j := len(ops) - 1; j >= 0; j-- {
+			undone, err := operations.Apply(content, ops[j].Inverse())
+			if err != nil {
+				return "", fmt.Errorf("failed to undo version %d: %w", d.history[i].version, err)
+			}
+			content = undone
+		}
+	}
+	return content, nil
+}
+
+// OperationsSince returns copies of the operations that took a text
+// document from version to the current one, oldest first, each carrying
+// the version it produced, so a client that last saw version can catch up
+// without the full content. Versions older than the retained history
+// return ErrVersionUnavailable.
+func (d *Document) OperationsSince(version int) ([]*operations.Operation, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.kind != KindText {
+		return nil, fmt.Errorf("operations of %s document", d.kind)
+	}
+	switch oldest := d.oldest(); {
+	case version > d.version:
+		return nil, fmt.Errorf("%w: version %d, current is %d", ErrFutureVersion, version, d.version)
+	case version < oldest:
+		return nil, fmt.Errorf("%w: version %d, oldest is %d", ErrVersionUnavailable, version, oldest)
+	}
+
+	var ops []*operations.Operation
+	for _, rev := range d.history[len(d.history)-(d.version-version):] {
+		for _, op := range rev.ops {
+			c := *op
+			c.Version = rev.version
+			ops = append(ops, &c)
+		}
+	}
+	return ops, nil
+}
+
+// AuthorsSince returns who made the changes after version, in the order
+// they first did, leaving out except and changes no client made, so a
+// rebased operation's author can be told whose edits it was rebased past.
+// Versions older than the retained history return ErrVersionUnavailable.
+func (d *Document) AuthorsSince(version int, except string) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	switch oldest := d.oldest(); {
+	case version > d.version:
+		return nil, fmt.Errorf("%w: version %d, current is %d", ErrFutureVersion, version, d.version)
+	case version < oldest:
+		return nil, fmt.Errorf("%w: version %d, oldest is %d", ErrVersionUnavailable, version, oldest)
+	}
+
+	var authors []string
+	for _, rev := range d.history[len(d.history)-(d.version-version):] {
+		if rev.author != "" && rev.author != except && !slices.Contains(authors, rev.author) {
+			authors = append(authors, rev.author)
+		}
+	}
+	return authors, nil
+}
+
+// TruncateHistory drops all but the newest keep revisions and returns the
+// number dropped. Versions before them can no longer be reconstructed,
+// rebased from or caught up from; undo is not affected.
+func (d *Document) TruncateHistory(keep int) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dropped := len(d.history) - max(keep, 0)
+	if dropped <= 0 {
+		return 0
+	}
+	d.history = append([]revision(nil), d.history[dropped:]...)
+	return dropped
+}
+
+// Rollback discards every change after version, making it the current
+// version again. It is only for changes no client has seen, such as the
+// tentative part of a transaction that failed: versions are reused.
+func (d *Document) Rollback(version int) error {
+	d.lock()
+	defer d.mu.Unlock()
+
+	content, err := d.contentAt(version)
+	if err != nil {
+		return err
+	}
+	d.content = content
+	for i := len(d.history) - 1; i >= 0 && d.history[i].version > version; i-- {
+		ops := d.history[i].ops
+		for j := len(ops) - 1; j >= 0; j-- {
+			// Lossy: text deleted comes back unformatted and unfenced,
+			// and formats clear their attributes rather than restoring
+			// earlier ones.
+			d.formatting = d.formatting.Apply(ops[j].Inverse())
+			d.fences = d.fences.Apply(ops[j].Inverse())
+		}
+	}
+	d.history = d.history[:len(d.history)-(d.version-version)]
+	d.edits.Rollback(version)
+	d.applied.forgetAfter(version)
+	d.version = version
+	return nil
+}
+
+// UndoVersion reverts the change that produced version while keeping every
+// later edit, e.g. to remove accidentally pasted secrets. The change's
+// inverse is transformed past the subsequent history and applied as new
+// versions. Each returned operation carries the version the document
+// reached after applying it; the final version is returned. No operations
+// are returned if later edits already removed the change.
+func (d *Document) UndoVersion(version int) ([]*operations.Operation, int, error) {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if d.kind != KindText {
+		return nil, d.version, fmt.Errorf("undo on %s document", d.kind)
+	}
+	if version < 1 || version > d.version {
+		return nil, d.version, fmt.Errorf("version %d out of range [1, %d]", version, d.version)
+	}
+	i := len(d.history) - (d.version - version) - 1
+	if oldest := d.oldest(); version <= oldest {
+		return nil, d.version, fmt.Errorf("%w: version %d, oldest is %d", ErrVersionUnavailable, version, oldest+1)
+	}
+
+	changed := d.history[i].ops
+	undo := make([]*operations.Operation, 0, len(changed))
+	for j := len(changed) - 1; j >= 0; j-- {
+		if inv := changed[j].Inverse(); inv.Text != "" && inv.Type != operations.OpRetain {
+			undo = append(undo, inv)
+		}
+	}
+	for _, rev := range d.history[i+1:] {
+		var err error
+		if undo, err = operations.TransformUndo(undo, rev.ops); errors.Is(err, operations.ErrOverlap) {
+			return nil, d.version, fmt.Errorf("%w: version %d", ErrUndoConflict, rev.version)
+		} else if err != nil {
+			return nil, d.version, fmt.Errorf("failed to transform past version %d: %w", rev.version, err)
+		}
+	}
+
+	content := d.content
+	for _, op := range undo {
+		next, err := operations.Apply(content, op)
+		if err != nil {
+			return nil, d.version, fmt.Errorf("%w: %v", ErrUndoConflict, err)
+		}
+		content = next
+	}
+	if err := d.checkSchema(content); err != nil {
+		return nil, d.version, err
+	}
+
+	for _, op := range undo {
+		d.content, _ = operations.Apply(d.content, op)
+		d.version++
+		op.Version = d.version
+		d.record("", op)
+	}
+	if len(undo) > 0 {
+		d.lastModified = d.clock.Now()
+	}
+	return undo, d.version, nil
+}
+
+// Undo reverts author's latest edit that is not undone yet, keeping every
+// later edit, including the author's own. The revert is applied as the
+// next version and returned with that version, or nil if later edits
+// already reverted the edit. It is recorded as no client's, so operations
+// the author sent before seeing it are transformed past it. An edit whose
+// revert conflicts with later edits returns ErrUndoConflict and is
+// dropped, so the next Undo moves on to the one before it.
+func (d *Document) Undo(author string) (*operations.Operation, error) {
+	d.lock()
+	defer d.mu.Unlock()
+	return d.step(author, d.edits.Undo, d.edits.RecordUndo)
+}
+
+// Redo reapplies the edit author's latest Undo reverted, if the author
+// has not edited since; see Undo.
+func (d *Document) Redo(author string) (*operations.Operation, error) {
+	d.lock()
+	defer d.mu.Unlock()
+	return d.step(author, d.edits.Redo, d.edits.RecordRedo)
+}
+
+// step implements Undo and Redo: revert computes the operation and done
+// records it. Callers must hold d.mu.
+func (d *Document) step(author string, revert func(string) (*operations.Operation, error), done func(int, string, *operations.Operation)) (*operations.Operation, error) {
+	if d.kind != KindText {
+		return nil, fmt.Errorf("undo on %s document", d.kind)
+	}
+	op, err := revert(author)
+	if errors.Is(err, ErrNothingToUndo) || errors.Is(err, ErrNothingToRedo) {
+		return nil, err
+	}
+	var content string
+	if err == nil && op != nil {
+		content, err = operations.Apply(d.content, op)
+		if err == nil {
+			err = d.checkBoundaries(d.content, content, op)
+		}
+	}
+	if err != nil {
+		done(d.version, author, nil)
+		return nil, fmt.Errorf("%w: %v", ErrUndoConflict, err)
+	}
+	if op == nil {
+		done(d.version, author, nil)
+		return nil, nil
+	}
+	if err := d.checkSchema(content); err != nil {
+		return nil, err
+	}
+
+	d.content = content
+	d.version++
+	d.lastModified = d.clock.Now()
+	op.Version = d.version
+	done(d.version, author, d.revise("", op)[0])
+	return op, nil
+}
+
+// ApplyRebased applies op, written against the content of op.Version, after
+// transforming it past every later change, so a server-side component can
+// edit text it read a while ago. It returns the operation as applied, with
+// the version it produced, or nil if later edits made it redundant.
+// Versions older than the retained history return ErrVersionUnavailable.
+func (d *Document) ApplyRebased(op *operations.Operation) (*operations.Operation, error) {
+	d.lock()
+	defer d.mu.Unlock()
+	return d.rebase(op, "", rebaseStrict, false)
+}
+
+// ApplyConcurrent is ApplyRebased for an operation a client sent: later
+// changes by the same author are skipped, since its replica applied them
+// beneath op, and only those it had not seen are transformed past. An
+// operation whose version cannot be rebased from, because it is older than
+// the retained history or ahead of the document, is applied to the current
+// content as written, as clients that do not track versions expect.
+func (d *Document) ApplyConcurrent(op *operations.Operation, author string) (*operations.Operation, error) {
+	d.lock()
+	defer d.mu.Unlock()
+	return d.rebase(op, author, rebaseLenient, false)
+}
+
+// ApplyWithinWindow is ApplyConcurrent for a client that tracks versions
+// and must resync when it falls too far behind: an operation older than
+// the history window returns ErrVersionUnavailable instead of applying as
+// written. If fenced, the client may not edit fences, and an operation
+// that, once rebased, would change fenced text returns an error wrapping
+// ErrFenced.
+func (d *Document) ApplyWithinWindow(op *operations.Operation, author string, fenced bool) (*operations.Operation, error) {
+	d.lock()
+	defer d.mu.Unlock()
+	return d.rebase(op, author, rebaseWindowed, fenced)
+}
+
+// rebaseMode says what rebase does with an operation it cannot rebase.
+type rebaseMode int
+
+const (
+	rebaseStrict   rebaseMode = iota // Refuse versions older than the window or not reached
+	rebaseLenient                    // Apply them to the current content as written
+	rebaseWindowed                   // Refuse older versions; apply versions not reached as written
+)
+
+// rebase implements ApplyRebased, ApplyConcurrent and ApplyWithinWindow,
+// recording the change as author's and, if fenced, refusing one that
+// changes fenced text. Callers must hold d.mu.
+func (d *Document) rebase(op *operations.Operation, author string, mode rebaseMode, fenced bool) (*operations.Operation, error) {
+	if d.kind != KindText {
+		return nil, fmt.Errorf("%w: text operation on %s document", ErrWrongKind, d.kind)
+	}
+	if version, ok := d.applied.versions[op.ID]; ok && op.ID != "" {
+		return nil, &DuplicateOperationError{ID: op.ID, Version: version}
+	}
+
+	c := *op
+	rebased := []*operations.Operation{&c}
+	i := len(d.history) - (d.version - op.Version)
+	oldest := d.oldest()
+	switch {
+	case op.Version > d.version && mode == rebaseStrict:
+		return nil, fmt.Errorf("%w: version %d, current is %d", ErrFutureVersion, op.Version, d.version)
+	case op.Version < 0:
+		return nil, fmt.Errorf("version %d out of range [0, %d]", op.Version, d.version)
+	case op.Version < oldest && mode != rebaseLenient:
+		return nil, fmt.Errorf("%w: version %d, oldest is %d", ErrVersionUnavailable, op.Version, oldest)
+	case op.Version <= d.version && op.Version >= oldest:
+		for _, rev := range d.history[i:] {
+			if author != "" && rev.author == author {
+				continue
+			}
+			var err error
+			if rebased, err = operations.TransformPast(rebased, rev.ops); err != nil {
+				return nil, fmt.Errorf("failed to transform past version %d: %w", rev.version, err)
+			}
+			if len(rebased) == 0 {
+				return nil, nil
+			}
+		}
+	}
+
+	applied := rebased[0]
+	applied.ID = op.ID // Transform does not carry it
+	if fenced {
+		if err := d.fences.Check(applied); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.apply(applied, author); err != nil {
+		return nil, err
+	}
+	applied.Version = d.version
+	return applied, nil
+}
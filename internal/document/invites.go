@@ -0,0 +1,107 @@
+package document
+
+import (
+	"errors"
+	"time"
+)
+
+// Role is what a collaborator admitted by invite, or given a role by user
+// ID, may do.
+type Role string
+
+const (
+	RoleViewer Role = "viewer" // May read
+	RoleEditor Role = "editor" // May read and edit
+	// RoleMaintainer may read and edit, including fenced ranges.
+	RoleMaintainer Role = "maintainer"
+)
+
+var (
+	// ErrInviteNotFound is returned for an unknown invite token.
+	ErrInviteNotFound = errors.New("invite not found")
+	// ErrInviteExpired is returned for an invite past its expiry.
+	ErrInviteExpired = errors.New("invite expired")
+	// ErrInviteUsedUp is returned for an invite redeemed MaxUses times.
+	ErrInviteUsedUp = errors.New("invite has no uses left")
+)
+
+// Invite admits new collaborators to a document with a role.
+type Invite struct {
+	Token     string     `json:"token"`
+	Role      Role       `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Nil never expires
+	MaxUses   int        `json:"max_uses,omitempty"`   // Zero is unlimited
+	Uses      int        `json:"uses"`
+}
+
+// Grant is an access list entry created by redeeming an invite, looked up
+// by the access token handed to the new collaborator.
+type Grant struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+// AddInvite stores an invite for later redemption.
+func (d *Document) AddInvite(inv Invite) {
+	d.lock()
+	defer d.mu.Unlock()
+	if d.invites == nil {
+		d.invites = make(map[string]*Invite)
+	}
+	d.invites[inv.Token] = &inv
+}
+
+// RedeemInvite uses one redemption of the invite with token as of now and
+// adds name to the access list under accessToken with the invite's role.
+func (d *Document) RedeemInvite(token, name, accessToken string, now time.Time) (Grant, error) {
+	d.lock()
+	defer d.mu.Unlock()
+
+	inv, ok := d.invites[token]
+	switch {
+	case !ok:
+		return Grant{}, ErrInviteNotFound
+	case inv.ExpiresAt != nil && !now.Before(*inv.ExpiresAt):
+		return Grant{}, ErrInviteExpired
+	case inv.MaxUses > 0 && inv.Uses >= inv.MaxUses:
+		return Grant{}, ErrInviteUsedUp
+	}
+
+	inv.Uses++
+	grant := Grant{Name: name, Role: inv.Role}
+	if d.grants == nil {
+		d.grants = make(map[string]Grant)
+	}
+	d.grants[accessToken] = grant
+	return grant, nil
+}
+
+// GrantFor returns the access list entry for an access token.
+func (d *Document) GrantFor(accessToken string) (Grant, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	grant, ok := d.grants[accessToken]
+	return grant, ok
+}
+
+// ExpireInvite makes an invite expire at, so it admits no one from then.
+func (d *Document) ExpireInvite(token string, at time.Time) error {
+	d.lock()
+	defer d.mu.Unlock()
+	inv, ok := d.invites[token]
+	if !ok {
+		return ErrInviteNotFound
+	}
+	if inv.ExpiresAt == nil || at.Before(*inv.ExpiresAt) {
+		inv.ExpiresAt = &at
+	}
+	return nil
+}
+
+// HasInvite reports whether an invite with token exists, expired or not.
+func (d *Document) HasInvite(token string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.invites[token]
+	return ok
+}
@@ -0,0 +1,80 @@
+package document
+
+import (
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/textnorm"
+	"fmt"
+)
+
+// ErrSplitsCharacter is returned for an operation whose position falls
+// inside a character: always inside a surrogate pair, and inside a
+// grapheme cluster when the document has a normalization form.
+var ErrSplitsCharacter = operations.ErrSplitsCharacter
+
+// SetNormalization keeps the document's text in form f from now on,
+// normalizing the current content. It returns the resulting version and
+// reports whether the content changed.
+func (d *Document) SetNormalization(f textnorm.Form) (int, bool, error) {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if f != textnorm.FormNone && d.kind != KindText {
+		return d.version, false, fmt.Errorf("normalization of %s document", d.kind)
+	}
+	d.normalization = f
+	content := f.String(d.content)
+	if content == d.content {
+		return d.version, false, nil
+	}
+	previous := d.content
+	d.content = content
+	d.version++
+	d.lastModified = d.clock.Now()
+	d.recordDiff(previous)
+	return d.version, true, nil
+}
+
+// Normalization returns the form the document's text is kept in.
+func (d *Document) Normalization() textnorm.Form {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.normalization
+}
+
+// Normalize returns s in the document's normalization form, as it would
+// be stored.
+func (d *Document) Normalize(s string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.normalization.String(s)
+}
+
+// normalizeOperation puts an insert's text in the document's form.
+// Callers must hold d.mu.
+func (d *Document) normalizeOperation(op *operations.Operation) {
+	if op.Type == operations.OpInsert {
+		op.Text = d.normalization.String(op.Text)
+	}
+}
+
+// checkBoundaries reports an operation that starts or ends inside a
+// grapheme cluster when the document has a normalization form, given the
+// content before and after it; operations.Apply already refuses one that
+// splits a surrogate pair. Inserted text is checked in the content after,
+// so text that would merge into a neighbouring cluster, such as a lone
+// combining accent, is refused. Callers must hold d.mu.
+func (d *Document) checkBoundaries(before, after string, op *operations.Operation) error {
+	if d.normalization == textnorm.FormNone {
+		return nil
+	}
+	content := before
+	if op.Type == operations.OpInsert {
+		content = after
+	}
+	for _, pos := range []int{op.Position, op.Position + op.Length()} {
+		if i, ok := operations.Offset(content, pos); !ok || !textnorm.IsBoundary(content, i) {
+			return fmt.Errorf("%w: %d", ErrSplitsCharacter, pos)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package document
+
+import (
+	"collaborative-docs/internal/operations"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrChecksumMismatch is returned for a snapshot whose content does not
+// match the checksum it was saved with.
+var ErrChecksumMismatch = errors.New("content does not match its checksum")
+
+// OpLog is implemented by stores that also keep the operations applied to
+// each document since a base snapshot, so a document whose saved or loaded
+// content is corrupt can be rebuilt by replaying them.
+type OpLog interface {
+	// StartLog replaces a document's log with an empty one beginning at
+	// base.
+	StartLog(id string, base *Snapshot) error
+	// AppendLog adds e at the end of a document's log.
+	AppendLog(id string, e *LogEntry) error
+	// LoadLog returns a document's base snapshot and the entries after
+	// it, oldest first, or ErrNotStored. Entries past the first that
+	// fails to decode are left out.
+	LoadLog(id string) (*Snapshot, []*LogEntry, error)
+}
+
+// LogEntry is a batch of operations in an OpLog, those that took a
+// document from version From to version To.
+type LogEntry struct {
+	From     int                     `json:"from"`
+	To       int                     `json:"to"`
+	Ops      []*operations.Operation `json:"ops"`      // Each carrying the version it produced
+	Checksum string                  `json:"checksum"` // Of the content at To
+}
+
+// Checksum returns the checksum snapshots and log entries carry for
+// content.
+func Checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks the snapshot's content against its checksum. Snapshots
+// saved before checksums were kept have none and always pass.
+func (s *Snapshot) Verify() error {
+	if s.Checksum != "" && Checksum(s.Content) != s.Checksum {
+		return fmt.Errorf("%w: version %d", ErrChecksumMismatch, s.Version)
+	}
+	return nil
+}
+
+// LogEntry returns the operations that took the document from version
+// from to version to, with the checksum of the content at to. Versions
+// outside the retained history return ErrVersionUnavailable.
+func (d *Document) LogEntry(from, to int) (*LogEntry, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if from > to || to > d.version {
+		return nil, fmt.Errorf("log entry from version %d to %d, current is %d", from, to, d.version)
+	}
+	if oldest := d.oldest(); from < oldest {
+		return nil, fmt.Errorf("%w: version %d, oldest is %d", ErrVersionUnavailable, from, oldest)
+	}
+	content, err := d.contentAt(to)
+	if err != nil {
+		return nil, err
+	}
+	e := &LogEntry{From: from, To: to, Checksum: Checksum(content)}
+	for _, rev := range d.history[len(d.history)-(d.version-from):] {
+		if rev.version > to {
+			break
+		}
+		for _, op := range rev.ops {
+			c := *op
+			c.Version = rev.version
+			e.Ops = append(e.Ops, &c)
+		}
+	}
+	return e, nil
+}
+
+// ReplayLog applies the entries of a log after version to content, the
+// content at that version, and returns the content and version of the
+// last entry whose checksum it reproduced. Replay stops at the first entry
+// that leaves a gap, does not apply or ends on another checksum, as every
+// later one is suspect. verified is false when no entry checked out; then
+// content and version are returned as given.
+func ReplayLog(content string, version int, entries []*LogEntry) (string, int, bool) {
+	verified := false
+	for _, e := range entries {
+		if e.To <= version {
+			continue
+		}
+		if e.From > version {
+			break
+		}
+		next := content
+		var err error
+		for _, op := range e.Ops {
+			if op.Version <= version {
+				continue
+			}
+			if next, err = operations.Apply(next, op); err != nil {
+				break
+			}
+		}
+		if err != nil || Checksum(next) != e.Checksum {
+			break
+		}
+		content, version, verified = next, e.To, true
+	}
+	return content, version, verified
+}
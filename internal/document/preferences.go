@@ -0,0 +1,59 @@
+package document
+
+import "time"
+
+// maxPreferenceUsers bounds how many users' preferences a document keeps;
+// past it, those updated longest ago are dropped.
+const maxPreferenceUsers = 1000
+
+// Preferences are one user's settings for a document, kept with it so the
+// editing experience resumes where the user left off.
+type Preferences struct {
+	CursorColor     string    `json:"cursor_color,omitempty"`      // As #rrggbb
+	ScrollPosition  int       `json:"scroll_position,omitempty"`   // In the client's units, e.g. the first visible line
+	LastReadVersion int       `json:"last_read_version,omitempty"` // Latest version the user has seen
+	UpdatedAt       time.Time `json:"updated_at,omitempty"`        // Set when stored
+}
+
+// Preferences returns the preferences stored for a user and whether there
+// are any.
+func (d *Document) Preferences(userID string) (Preferences, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	p, ok := d.preferences[userID]
+	return p, ok
+}
+
+// SetPreferences replaces a user's preferences and returns them as
+// stored, with UpdatedAt set to now.
+func (d *Document) SetPreferences(userID string, p Preferences) Preferences {
+	d.lock()
+	defer d.mu.Unlock()
+
+	p.UpdatedAt = d.clock.Now()
+	if d.preferences == nil {
+		d.preferences = make(map[string]Preferences)
+	}
+	d.preferences[userID] = p
+	for len(d.preferences) > maxPreferenceUsers {
+		oldest := userID
+		for id, q := range d.preferences {
+			if q.UpdatedAt.Before(d.preferences[oldest].UpdatedAt) {
+				oldest = id
+			}
+		}
+		delete(d.preferences, oldest)
+	}
+	return p
+}
+
+// DeletePreferences removes a user's preferences and reports whether
+// there were any.
+func (d *Document) DeletePreferences(userID string) bool {
+	d.lock()
+	defer d.mu.Unlock()
+
+	_, ok := d.preferences[userID]
+	delete(d.preferences, userID)
+	return ok
+}
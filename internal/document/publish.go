@@ -0,0 +1,41 @@
+package document
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrStalePublication is returned when publishing a version older than the
+// one already published.
+var ErrStalePublication = errors.New("a newer version is already published")
+
+// Publication is a version of a document approved for readers outside the
+// editing session. It keeps its content while editing continues.
+type Publication struct {
+	Version     int       `json:"version"`
+	Content     string    `json:"content"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// Publish records p as the published version. Versions only move forward,
+// so a slow approval cannot replace a newer publication; publishing the
+// same version again is allowed.
+func (d *Document) Publish(p Publication) error {
+	d.lock()
+	defer d.mu.Unlock()
+	if d.published != nil && p.Version < d.published.Version {
+		return ErrStalePublication
+	}
+	d.published = &p
+	return nil
+}
+
+// Published returns the published version, if there is one.
+func (d *Document) Published() (Publication, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.published == nil {
+		return Publication{}, false
+	}
+	return *d.published, true
+}
@@ -0,0 +1,139 @@
+package document
+
+import (
+	"collaborative-docs/internal/operations"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultPlaceholder replaces redacted text when a Redaction names none.
+const DefaultPlaceholder = "[REDACTED]"
+
+// Redaction selects sensitive text to scrub from a document and its
+// retained history. Set either Pattern or a version range.
+type Redaction struct {
+	Pattern     *regexp.Regexp // Text matching Pattern
+	FromVersion int            // Or the text inserted by the changes producing FromVersion..ToVersion
+	ToVersion   int
+	Placeholder string // Replaces each match; defaults to DefaultPlaceholder
+}
+
+// Redact replaces the selected text with a placeholder in the content, in
+// every retained version and in the checkpoints and publication, so
+// neither the document, ContentAt, a restore nor the published page can
+// reveal it again, and returns the new content and version. Nothing
+// changes if the pattern matches nowhere. Schemas are
+// not checked: the redaction must apply whatever the placeholder does to
+// the line structure.
+//
+// With a version range, the versions inside the range are rewritten to the
+// content before it, and the text the range inserted is redacted from the
+// versions after it. With a pattern, text typed a few characters per
+// version leaves shorter, non-matching prefixes in the versions that
+// preceded its completion; use a range to cover those.
+func (d *Document) Redact(r Redaction) (string, int, error) {
+	d.lock()
+	defer d.mu.Unlock()
+
+	if d.kind != KindText {
+		return "", d.version, fmt.Errorf("redaction on %s document", d.kind)
+	}
+	placeholder := r.Placeholder
+	if placeholder == "" {
+		placeholder = DefaultPlaceholder
+	}
+
+	oldest := d.version - len(d.history)
+	contents, err := d.retainedContents()
+	if err != nil {
+		return "", d.version, err
+	}
+
+	var before string
+	pattern := r.Pattern
+	if pattern == nil {
+		if r.FromVersion < 1 || r.FromVersion > r.ToVersion || r.ToVersion > d.version {
+			return "", d.version, fmt.Errorf("version range [%d, %d] out of range [1, %d]", r.FromVersion, r.ToVersion, d.version)
+		}
+		if r.FromVersion-1 < oldest {
+			return "", d.version, fmt.Errorf("%w: version %d, oldest is %d", ErrVersionUnavailable, r.FromVersion-1, oldest)
+		}
+		before = contents[r.FromVersion-1-oldest]
+		var inserted strings.Builder
+		for _, op := range operations.Diff(before, contents[r.ToVersion-oldest], 0) {
+			if op.Type == operations.OpInsert {
+				inserted.WriteString(op.Text)
+			}
+		}
+		if inserted.Len() == 0 {
+			return "", d.version, fmt.Errorf("versions %d to %d inserted no text", r.FromVersion, r.ToVersion)
+		}
+		pattern = regexp.MustCompile(regexp.QuoteMeta(inserted.String()))
+		for v := r.FromVersion; v < r.ToVersion; v++ {
+			contents[v-oldest] = before
+		}
+	}
+
+	// Checkpoints and the publication are copies of past content kept
+	// after history moves on, so they are scrubbed like the versions.
+	scrub := func(content string, version int) string {
+		if r.Pattern == nil && version >= r.FromVersion && version < r.ToVersion {
+			content = before
+		}
+		return pattern.ReplaceAllLiteralString(content, placeholder)
+	}
+	for i, cp := range d.checkpoints {
+		d.checkpoints[i].Content = scrub(cp.Content, cp.Version)
+	}
+	if d.published != nil {
+		published := *d.published
+		published.Content = scrub(published.Content, published.Version)
+		d.published = &published
+	}
+
+	changed := pattern != r.Pattern
+	for i, content := range contents {
+		contents[i] = pattern.ReplaceAllLiteralString(content, placeholder)
+		changed = changed || contents[i] != content
+	}
+	if !changed {
+		return d.content, d.version, nil
+	}
+	for i := range d.history {
+		d.history[i].ops = operations.Diff(contents[i], contents[i+1], d.history[i].version-1)
+	}
+
+	// A new version tells clients their replica is stale. Its revision is
+	// empty: the rewritten history already ends at the redacted content.
+	for _, op := range operations.Diff(d.content, contents[len(contents)-1], d.version) {
+		d.formatting = d.formatting.Apply(op)
+		d.fences = d.fences.Apply(op)
+	}
+	d.content = contents[len(contents)-1]
+	d.version++
+	d.lastModified = d.clock.Now()
+	d.record("")
+	d.edits.Reset() // Its operations still hold the redacted text
+	return d.content, d.version, nil
+}
+
+// retainedContents returns the content of every retained version, oldest
+// first, ending with the current content. Callers must hold d.mu.
+func (d *Document) retainedContents() ([]string, error) {
+	contents := make([]string, len(d.history)+1)
+	contents[len(d.history)] = d.content
+	for i := len(d.history) - 1; i >= 0; i-- {
+		content := contents[i+1]
+		ops := d.history[i].ops
+		for j := len(ops) - 1; j >= 0; j-- {
+			undone, err := operations.Apply(content, ops[j].Inverse())
+			if err != nil {
+				return nil, fmt.Errorf("failed to undo version %d: %w", d.history[i].version, err)
+			}
+			content = undone
+		}
+		contents[i] = content
+	}
+	return contents, nil
+}
@@ -0,0 +1,107 @@
+package document
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// maxScheduledActions bounds the actions waiting on one document.
+const maxScheduledActions = 100
+
+var (
+	// ErrScheduleFull is returned when scheduling more than
+	// maxScheduledActions on a document.
+	ErrScheduleFull = errors.New("too many scheduled actions")
+	// ErrScheduledNotFound is returned for an unknown scheduled action.
+	ErrScheduledNotFound = errors.New("scheduled action not found")
+)
+
+// Action is something done to a document at a set time.
+type Action string
+
+const (
+	ActionLock         Action = "lock"          // Pause edits
+	ActionUnlock       Action = "unlock"        // Resume edits
+	ActionPublish      Action = "publish"       // Publish the content as of then
+	ActionExpireInvite Action = "expire_invite" // Stop an invite admitting anyone
+)
+
+// Valid reports whether a is a known action.
+func (a Action) Valid() bool {
+	switch a {
+	case ActionLock, ActionUnlock, ActionPublish, ActionExpireInvite:
+		return true
+	}
+	return false
+}
+
+// ScheduledAction is an action waiting for its time.
+type ScheduledAction struct {
+	ID     string    `json:"id"`
+	Action Action    `json:"action"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"` // Shown to clients when locking
+	Invite string    `json:"invite,omitempty"` // Token of the invite to expire
+}
+
+// Schedule stores an action to run at a.At.
+func (d *Document) Schedule(a ScheduledAction) error {
+	d.lock()
+	defer d.mu.Unlock()
+	if len(d.scheduled) >= maxScheduledActions {
+		return ErrScheduleFull
+	}
+	d.scheduled = append(d.scheduled, a)
+	// Stable, so actions due at once run in the order scheduled.
+	sort.SliceStable(d.scheduled, func(i, j int) bool { return d.scheduled[i].At.Before(d.scheduled[j].At) })
+	return nil
+}
+
+// Unschedule removes the scheduled action with id.
+func (d *Document) Unschedule(id string) error {
+	d.lock()
+	defer d.mu.Unlock()
+	for i, a := range d.scheduled {
+		if a.ID == id {
+			d.scheduled = append(d.scheduled[:i], d.scheduled[i+1:]...)
+			return nil
+		}
+	}
+	return ErrScheduledNotFound
+}
+
+// Scheduled returns the actions waiting, soonest first.
+func (d *Document) Scheduled() []ScheduledAction {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]ScheduledAction(nil), d.scheduled...)
+}
+
+// NextScheduled returns when the soonest waiting action is due, and false
+// if none is waiting.
+func (d *Document) NextScheduled() (time.Time, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.scheduled) == 0 {
+		return time.Time{}, false
+	}
+	return d.scheduled[0].At, true
+}
+
+// TakeDue removes and returns the actions due by now, soonest first.
+func (d *Document) TakeDue(now time.Time) []ScheduledAction {
+	if next, ok := d.NextScheduled(); !ok || next.After(now) {
+		return nil // nothing to change
+	}
+
+	d.lock()
+	defer d.mu.Unlock()
+	n := 0
+	for n < len(d.scheduled) && !d.scheduled[n].At.After(now) {
+		n++
+	}
+	due := append([]ScheduledAction(nil), d.scheduled[:n]...)
+	d.scheduled = append([]ScheduledAction(nil), d.scheduled[n:]...)
+	return due
+}
@@ -0,0 +1,222 @@
+package document
+
+import (
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/jsondoc"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/sanitize"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/internal/textnorm"
+	"errors"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ErrNotStored is returned by Store.Load for a document never saved, or
+// deleted since.
+var ErrNotStored = errors.New("document not stored")
+
+// Store persists documents across restarts. Implementations must be safe
+// for concurrent use; the hub saves from a background flush while loading
+// documents on demand.
+type Store interface {
+	// Load returns the saved state of a document, or ErrNotStored.
+	Load(id string) (*Snapshot, error)
+	// Save replaces the saved state of a document.
+	Save(id string, s *Snapshot) error
+	// List returns the IDs of all saved documents in sorted order.
+	List() ([]string, error)
+	// Delete removes a saved document. Deleting a missing one is not an
+	// error.
+	Delete(id string) error
+}
+
+// Snapshot is the durable state of a document. Edit history, operation
+// IDs kept for deduplication, and schemas, which the hub derives from its
+// configuration, are not part of it, so a restored document starts with no
+// earlier versions to rewind to.
+type Snapshot struct {
+	Content          string                 `json:"content"`
+	Checksum         string                 `json:"checksum,omitempty"` // Of Content; see Verify
+	Version          int                    `json:"version"`
+	LastModified     time.Time              `json:"last_modified"`
+	Kind             Kind                   `json:"kind"`
+	Language         string                 `json:"language,omitempty"`
+	Validator        string                 `json:"validator,omitempty"`
+	Normalization    textnorm.Form          `json:"normalization,omitempty"`
+	Sanitizer        sanitize.Policy        `json:"sanitizer,omitzero"`
+	Limits           schema.Limits          `json:"limits,omitzero"`
+	HistoryWindow    HistoryWindow          `json:"history_window,omitzero"`
+	Formatting       operations.Spans       `json:"formatting,omitempty"`
+	Fences           Fences                 `json:"fences,omitempty"`
+	ConflictPolicy   jsondoc.ConflictPolicy `json:"conflict_policy,omitempty"`
+	Metadata         map[string]string      `json:"metadata,omitempty"`
+	DisabledFeatures []string               `json:"disabled_features,omitempty"`
+	Visibility       Visibility             `json:"visibility,omitempty"`
+	LinkToken        string                 `json:"link_token,omitempty"`
+	Invites          []Invite               `json:"invites,omitempty"`
+	Grants           map[string]Grant       `json:"grants,omitempty"`      // By access token
+	Preferences      map[string]Preferences `json:"preferences,omitempty"` // By user ID
+	Owner            string                 `json:"owner,omitempty"`       // User ID
+	Team             string                 `json:"team,omitempty"`        // Team ID
+	Roles            map[string]Role        `json:"roles,omitempty"`       // By user ID
+	Scheduled        []ScheduledAction      `json:"scheduled,omitempty"`   // Soonest first
+	Published        *Publication           `json:"published,omitempty"`
+	Checkpoints      []Checkpoint           `json:"checkpoints,omitempty"` // Oldest first
+	Blobs            []*Blob                `json:"blobs,omitempty"`
+}
+
+// Snapshot returns the document's durable state and the change count it
+// reflects; see Changes.
+func (d *Document) Snapshot() (*Snapshot, uint64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	s := &Snapshot{
+		Content:        d.content,
+		Checksum:       Checksum(d.content),
+		Version:        d.version,
+		LastModified:   d.lastModified,
+		Kind:           d.kind,
+		Language:       d.language,
+		Validator:      d.validator,
+		Normalization:  d.normalization,
+		Sanitizer:      d.sanitizer,
+		Limits:         d.limits,
+		HistoryWindow:  d.window,
+		Formatting:     slices.Clone(d.formatting),
+		Fences:         slices.Clone(d.fences),
+		ConflictPolicy: d.conflictPolicy,
+		Visibility:     d.visibility,
+		LinkToken:      d.linkToken,
+		Owner:          d.owner,
+		Team:           d.team,
+	}
+	if len(d.metadata) > 0 {
+		s.Metadata = make(map[string]string, len(d.metadata))
+		for k, v := range d.metadata {
+			s.Metadata[k] = v
+		}
+	}
+	for name := range d.disabled {
+		s.DisabledFeatures = append(s.DisabledFeatures, name)
+	}
+	sort.Strings(s.DisabledFeatures)
+	for _, inv := range d.invites {
+		s.Invites = append(s.Invites, *inv)
+	}
+	sort.Slice(s.Invites, func(i, j int) bool { return s.Invites[i].Token < s.Invites[j].Token })
+	if len(d.grants) > 0 {
+		s.Grants = make(map[string]Grant, len(d.grants))
+		for token, g := range d.grants {
+			s.Grants[token] = g
+		}
+	}
+	if len(d.roles) > 0 {
+		s.Roles = make(map[string]Role, len(d.roles))
+		for id, role := range d.roles {
+			s.Roles[id] = role
+		}
+	}
+	s.Scheduled = append([]ScheduledAction(nil), d.scheduled...)
+	if len(d.preferences) > 0 {
+		s.Preferences = make(map[string]Preferences, len(d.preferences))
+		for id, p := range d.preferences {
+			s.Preferences[id] = p
+		}
+	}
+	if d.published != nil {
+		p := *d.published
+		s.Published = &p
+	}
+	s.Checkpoints = slices.Clone(d.checkpoints)
+	for _, b := range d.blobs {
+		s.Blobs = append(s.Blobs, b)
+	}
+	sort.Slice(s.Blobs, func(i, j int) bool { return s.Blobs[i].ID < s.Blobs[j].ID })
+	return s, d.changes
+}
+
+// Restore creates a document from a snapshot, with modification times
+// from c.
+func Restore(s *Snapshot, c clock.Clock) *Document {
+	d := NewDocumentWithClock(c)
+	d.content = s.Content
+	d.version = s.Version
+	if !s.LastModified.IsZero() {
+		d.lastModified = s.LastModified
+	}
+	if s.Kind != "" {
+		d.kind = s.Kind
+	}
+	d.language = s.Language
+	d.validator = s.Validator
+	d.normalization = s.Normalization
+	d.sanitizer = s.Sanitizer
+	d.limits = s.Limits
+	d.window = s.HistoryWindow
+	d.formatting = slices.Clone(s.Formatting)
+	d.fences = slices.Clone(s.Fences)
+	if s.ConflictPolicy != "" {
+		d.conflictPolicy = s.ConflictPolicy
+	}
+	for k, v := range s.Metadata {
+		d.metadata[k] = v
+	}
+	for _, name := range s.DisabledFeatures {
+		d.disabled[name] = true
+	}
+	d.visibility = s.Visibility
+	d.linkToken = s.LinkToken
+	if len(s.Invites) > 0 {
+		d.invites = make(map[string]*Invite, len(s.Invites))
+		for _, inv := range s.Invites {
+			d.invites[inv.Token] = &inv
+		}
+	}
+	if len(s.Grants) > 0 {
+		d.grants = make(map[string]Grant, len(s.Grants))
+		for token, g := range s.Grants {
+			d.grants[token] = g
+		}
+	}
+	d.owner = s.Owner
+	d.team = s.Team
+	if len(s.Roles) > 0 {
+		d.roles = make(map[string]Role, len(s.Roles))
+		for id, role := range s.Roles {
+			d.roles[id] = role
+		}
+	}
+	d.scheduled = append([]ScheduledAction(nil), s.Scheduled...)
+	if len(s.Preferences) > 0 {
+		d.preferences = make(map[string]Preferences, len(s.Preferences))
+		for id, p := range s.Preferences {
+			d.preferences[id] = p
+		}
+	}
+	if s.Published != nil {
+		p := *s.Published
+		d.published = &p
+	}
+	d.checkpoints = slices.Clone(s.Checkpoints)
+	for _, b := range s.Blobs {
+		d.blobs[b.ID] = b
+	}
+	return d
+}
+
+// Changes counts the calls that may have modified the document, so a
+// store can tell whether it changed since a snapshot was saved.
+func (d *Document) Changes() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.changes
+}
+
+// lock takes the write lock for a change, counting it for Changes.
+func (d *Document) lock() {
+	d.mu.Lock()
+	d.changes++
+}
@@ -0,0 +1,49 @@
+package document
+
+import "maps"
+
+// Team is a group of users who share the documents it owns: each member's
+// role applies to every one of them, so a document is shared with the
+// whole team without an access list entry per user.
+type Team struct {
+	ID      string          `json:"id"`
+	Name    string          `json:"name,omitempty"`
+	Members map[string]Role `json:"members"` // By user ID
+}
+
+// Clone returns a copy of the team that shares nothing with it.
+func (t *Team) Clone() *Team {
+	c := *t
+	c.Members = maps.Clone(t.Members)
+	if c.Members == nil {
+		c.Members = make(map[string]Role)
+	}
+	return &c
+}
+
+// TeamStore is implemented by stores that also keep teams.
+type TeamStore interface {
+	// LoadTeams returns every stored team.
+	LoadTeams() ([]*Team, error)
+	// SaveTeam creates or replaces a team.
+	SaveTeam(t *Team) error
+	// DeleteTeam removes a team; deleting a team not stored is not an
+	// error.
+	DeleteTeam(id string) error
+}
+
+// SetTeam makes a team the document's owner, whose members get their
+// team role on it; "" leaves it without one.
+func (d *Document) SetTeam(teamID string) {
+	d.lock()
+	defer d.mu.Unlock()
+	d.team = teamID
+}
+
+// Team returns the ID of the team that owns the document, or "" if none
+// does.
+func (d *Document) Team() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.team
+}
@@ -0,0 +1,32 @@
+package document
+
+// MetadataWorkflowState is the metadata key holding a document's
+// WorkflowState.
+const MetadataWorkflowState = "workflow_state"
+
+// WorkflowState is where a document stands in review.
+type WorkflowState string
+
+const (
+	WorkflowDraft    WorkflowState = "draft"     // Being written (the default)
+	WorkflowInReview WorkflowState = "in-review" // Waiting for reviewers
+	WorkflowFinal    WorkflowState = "final"     // Approved
+)
+
+// Valid reports whether s is a known workflow state.
+func (s WorkflowState) Valid() bool {
+	switch s {
+	case WorkflowDraft, WorkflowInReview, WorkflowFinal:
+		return true
+	}
+	return false
+}
+
+// WorkflowState returns the document's workflow state, WorkflowDraft if
+// none is set.
+func (d *Document) WorkflowState() WorkflowState {
+	if s, ok := d.GetMetadata(MetadataWorkflowState); ok && s != "" {
+		return WorkflowState(s)
+	}
+	return WorkflowDraft
+}
@@ -0,0 +1,120 @@
+// Package embed finds and resolves transclusions: references in a text
+// document to another document, or a range of its lines, written
+// ![[doc-id]], ![[doc-id#L3]] or ![[doc-id#L3-L10]]. The embedding
+// document stores only the reference; the embedded content is filled in
+// when the document is read or exported.
+package embed
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MaxDepth bounds how deeply embeds nest when resolving.
+const MaxDepth = 8
+
+// pattern matches a reference. Document IDs follow the server's rules:
+// letters, digits, hyphens and underscores, at most 100 characters.
+var pattern = regexp.MustCompile(`!\[\[([A-Za-z0-9_-]{1,100})(?:#L([0-9]{1,9})(?:-L([0-9]{1,9}))?)?\]\]`)
+
+// Reference is one embed in a document's content.
+type Reference struct {
+	DocumentID string
+	FromLine   int // First embedded line, 1-based; 0 embeds the whole document
+	ToLine     int // Last embedded line, inclusive
+	Start, End int // Byte offsets of the reference in the embedding content
+}
+
+// Parse returns the references in content, in order. References whose line
+// range ends before it starts are ignored.
+func Parse(content string) []Reference {
+	if !strings.Contains(content, "![[") {
+		return nil
+	}
+	var refs []Reference
+	for _, m := range pattern.FindAllStringSubmatchIndex(content, -1) {
+		ref := Reference{DocumentID: content[m[2]:m[3]], Start: m[0], End: m[1]}
+		if m[4] >= 0 {
+			ref.FromLine, _ = strconv.Atoi(content[m[4]:m[5]])
+			ref.ToLine = ref.FromLine
+			if m[6] >= 0 {
+				ref.ToLine, _ = strconv.Atoi(content[m[6]:m[7]])
+			}
+			if ref.FromLine < 1 || ref.ToLine < ref.FromLine {
+				continue
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// Documents returns the IDs of the documents content embeds, each once, in
+// order of first appearance.
+func Documents(content string) []string {
+	var ids []string
+	for _, ref := range Parse(content) {
+		if !slices.Contains(ids, ref.DocumentID) {
+			ids = append(ids, ref.DocumentID)
+		}
+	}
+	return ids
+}
+
+// Lookup returns a document's content, reporting false if it does not exist
+// or may not be embedded.
+type Lookup func(documentID string) (string, bool)
+
+// Resolve returns content, the content of documentID, with every reference
+// replaced by the content it names, resolving nested references up to
+// MaxDepth levels. References to documents lookup rejects, to lines past
+// the end, or back to a document being resolved are left as written.
+func Resolve(documentID, content string, lookup Lookup) string {
+	return resolve(content, lookup, []string{documentID})
+}
+
+func resolve(content string, lookup Lookup, stack []string) string {
+	refs := Parse(content)
+	if len(refs) == 0 || len(stack) > MaxDepth {
+		return content
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, ref := range refs {
+		b.WriteString(content[last:ref.Start])
+		last = ref.End
+
+		text, ok := "", !slices.Contains(stack, ref.DocumentID)
+		if ok {
+			text, ok = lookup(ref.DocumentID)
+		}
+		if ok {
+			text, ok = ref.lines(text)
+		}
+		if !ok {
+			b.WriteString(content[ref.Start:ref.End])
+			continue
+		}
+		b.WriteString(resolve(text, lookup, append(slices.Clip(stack), ref.DocumentID)))
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// lines returns the part of content the reference embeds, reporting false
+// if the range starts past the last line. A range running past the end is
+// cut short.
+func (r Reference) lines(content string) (string, bool) {
+	if r.FromLine == 0 {
+		return content, true
+	}
+	lines := strings.Split(content, "\n")
+	if r.FromLine > len(lines) {
+		return "", false
+	}
+	to := min(r.ToLine, len(lines))
+	return strings.Join(lines[r.FromLine-1:to], "\n"), true
+}
@@ -0,0 +1,62 @@
+package embed
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	content := "Intro ![[notes]] and ![[spec#L2-L4]] plus ![[spec#L7]]\n![[bad#L5-L2]] ![[no spaces]] ![[notes]]"
+	refs := Parse(content)
+
+	want := []Reference{
+		{DocumentID: "notes", Start: 6, End: 16},
+		{DocumentID: "spec", FromLine: 2, ToLine: 4, Start: 21, End: 36},
+		{DocumentID: "spec", FromLine: 7, ToLine: 7, Start: 42, End: 54},
+		{DocumentID: "notes", Start: 85, End: 95},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("Parse() = %+v, want %+v", refs, want)
+	}
+	if ids := Documents(content); !reflect.DeepEqual(ids, []string{"notes", "spec"}) {
+		t.Errorf("Documents() = %v, want [notes spec]", ids)
+	}
+}
+
+// TestResolve verifies whole-document and line-range embeds, nesting, and
+// that missing documents, out-of-range lines and cycles stay as written.
+func TestResolve(t *testing.T) {
+	docs := map[string]string{
+		"spec":   "one\ntwo\nthree\nfour",
+		"outer":  "[![[inner]]]",
+		"inner":  "<![[spec#L2]]>",
+		"loop":   "a ![[loop-b]]",
+		"loop-b": "b ![[loop]]",
+	}
+	lookup := func(id string) (string, bool) {
+		content, ok := docs[id]
+		return content, ok
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"whole document", "x ![[spec]] y", "x one\ntwo\nthree\nfour y"},
+		{"line range", "![[spec#L2-L3]]", "two\nthree"},
+		{"range past the end", "![[spec#L3-L9]]", "three\nfour"},
+		{"line past the end", "![[spec#L9]]", "![[spec#L9]]"},
+		{"missing document", "![[gone]]", "![[gone]]"},
+		{"nested", "![[outer]]", "[<two>]"},
+		{"cycle", "![[loop]]", "a b ![[loop]]"},
+		{"self", "me ![[home]]", "me ![[home]]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Resolve("home", tt.content, lookup); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"collaborative-docs/internal/operations"
+	"sync"
+	"time"
+)
+
+// Type identifies the kind of document change event.
+type Type string
+
+const (
+	TypeDocumentCreated    Type = "document_created"    // A document was first touched
+	TypeOperationApplied   Type = "operation_applied"   // An OT operation was applied
+	TypeContentSet         Type = "content_set"         // Content was replaced wholesale
+	TypeUserJoined         Type = "user_joined"         // A client connected to a document
+	TypeUserLeft           Type = "user_left"           // A client disconnected from a document
+	TypeLatencyAlert       Type = "latency_alert"       // An operation exceeded the latency threshold
+	TypeMemoryPressure     Type = "memory_pressure"     // Memory pressure level changed; Detail holds the level
+	TypeDocumentEvicted    Type = "document_evicted"    // An idle document was dropped from memory
+	TypeDocumentDeleted    Type = "document_deleted"    // A document was deleted through the API
+	TypeDocumentPaused     Type = "document_paused"     // Edits were paused; Detail holds the reason
+	TypeDocumentResumed    Type = "document_resumed"    // A paused document accepts edits again
+	TypeVisibilityChanged  Type = "visibility_changed"  // Who may open a document changed; Detail holds the visibility
+	TypeMemberJoined       Type = "member_joined"       // A collaborator redeemed an invite; Detail holds their name
+	TypeClientThrottled    Type = "client_throttled"    // A client's messages are dropped for abuse; Detail holds its ID
+	TypeClientDisconnected Type = "client_disconnected" // A client was disconnected for abuse; Detail holds its ID
+	TypeEmbedChanged       Type = "embed_changed"       // A document embedded in this one changed or was deleted; Detail holds its ID
+	TypeDocumentPublished  Type = "document_published"  // An approved snapshot was published; Version names it
+	TypeAccessChanged      Type = "access_changed"      // A document's owner or team, or a user's role, changed; Detail holds the user ID
+	TypeScheduleFired      Type = "schedule_fired"      // A scheduled action ran; Detail holds the action
+	TypeDocumentCompacted  Type = "document_compacted"  // Old operations were dropped after a snapshot; Detail holds how many
+	TypeOperationConflict  Type = "operation_conflict"  // Concurrent edits refused or reshaped an operation; Detail summarizes the report
+	TypeFencesChanged      Type = "fences_changed"      // A document's fenced ranges were replaced; Version is the one they fence
+	TypeCheckpointRestored Type = "checkpoint_restored" // A document was restored to a checkpoint; Detail holds its ID
+	TypeDocumentRedacted   Type = "document_redacted"   // Text was scrubbed from a document, its history, checkpoints and publication
+	TypeDocumentRebuilt    Type = "document_rebuilt"    // A document's content was rebuilt from its stored operation log
+	TypeTeamChanged        Type = "team_changed"        // A team was created or deleted or its members changed; Detail holds its ID
+)
+
+// Event is one entry in the document change stream.
+type Event struct {
+	Type       Type                  `json:"type"`
+	DocumentID string                `json:"document_id"`
+	Version    int                   `json:"version,omitempty"`
+	Operation  *operations.Operation `json:"operation,omitempty"`
+	Clients    int                   `json:"clients,omitempty"`
+	LatencyMS  float64               `json:"latency_ms,omitempty"`
+	Detail     string                `json:"detail,omitempty"`
+	TraceID    string                `json:"trace_id,omitempty"` // Of the client message that caused the event
+	Time       time.Time             `json:"time"`
+}
+
+// Sink receives change events. Publish is called from the hub goroutine, so
+// implementations should hand events off quickly.
+type Sink interface {
+	Publish(event Event)
+}
+
+// Bus fans events out to registered sinks.
+type Bus struct {
+	sinks []Sink
+	mu    sync.RWMutex
+}
+
+// NewBus creates an event bus with no sinks.
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// AddSink registers a sink for all future events.
+func (b *Bus) AddSink(s Sink) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sinks = append(b.sinks, s)
+}
+
+// Emit stamps the event time if unset and delivers it to every sink.
+func (b *Bus) Emit(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if len(b.sinks) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, s := range b.sinks {
+		s.Publish(event)
+	}
+}
@@ -0,0 +1,128 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink collects published events.
+type recordingSink struct {
+	events []Event
+}
+
+func (r *recordingSink) Publish(e Event) {
+	r.events = append(r.events, e)
+}
+
+// TestBusEmit verifies events reach every sink with a timestamp.
+func TestBusEmit(t *testing.T) {
+	bus := NewBus()
+	a, b := &recordingSink{}, &recordingSink{}
+	bus.AddSink(a)
+	bus.AddSink(b)
+
+	bus.Emit(Event{Type: TypeDocumentCreated, DocumentID: "doc"})
+
+	for i, s := range []*recordingSink{a, b} {
+		if len(s.events) != 1 {
+			t.Fatalf("sink %d received %d events, want 1", i, len(s.events))
+		}
+		if s.events[0].Time.IsZero() {
+			t.Errorf("sink %d: event time not set", i)
+		}
+	}
+}
+
+// flakyWriter fails a fixed number of writes before accepting records.
+type flakyWriter struct {
+	failures int
+	records  []Record
+	mu       sync.Mutex
+}
+
+func (w *flakyWriter) WriteRecords(ctx context.Context, records []Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("broker unavailable")
+	}
+	w.records = append(w.records, records...)
+	return nil
+}
+
+// TestKafkaSinkRetries verifies events survive transient write failures and
+// are keyed by document ID.
+func TestKafkaSinkRetries(t *testing.T) {
+	writer := &flakyWriter{failures: 2}
+	sink := NewKafkaSink(writer, "doc-events")
+
+	sink.Publish(Event{Type: TypeUserJoined, DocumentID: "alpha", Clients: 1})
+	sink.Publish(Event{Type: TypeOperationApplied, DocumentID: "beta", Version: 3})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sink.Close(ctx); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if len(writer.records) != 2 {
+		t.Fatalf("delivered %d records, want 2", len(writer.records))
+	}
+
+	for i, wantKey := range []string{"alpha", "beta"} {
+		r := writer.records[i]
+		if string(r.Key) != wantKey || r.Topic != "doc-events" {
+			t.Errorf("record %d: key %q topic %q, want key %q topic doc-events", i, r.Key, r.Topic, wantKey)
+		}
+		var e Event
+		if err := json.Unmarshal(r.Value, &e); err != nil || e.DocumentID != wantKey {
+			t.Errorf("record %d: value %s does not decode to event for %s", i, r.Value, wantKey)
+		}
+	}
+
+	// Publishing after Close must not panic or block.
+	sink.Publish(Event{Type: TypeUserLeft, DocumentID: "alpha"})
+}
+
+// failingWriter fails every write, like a Kafka cluster that is down.
+type failingWriter struct{}
+
+func (failingWriter) WriteRecords(ctx context.Context, records []Record) error {
+	return errors.New("broker unreachable")
+}
+
+// TestKafkaSinkOutage verifies Publish never blocks while Kafka is down:
+// once the queue is full, events are dropped and counted.
+func TestKafkaSinkOutage(t *testing.T) {
+	sink := NewKafkaSink(failingWriter{}, "doc-events")
+
+	const extra = 500
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < kafkaQueueSize+kafkaBatchSize+extra; i++ {
+			sink.Publish(Event{Type: TypeOperationApplied, DocumentID: "alpha", Version: i})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked with the queue full")
+	}
+	if n := sink.Dropped(); n < extra {
+		t.Errorf("Dropped() = %d, want at least %d", n, extra)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sink.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close() error = %v, want the deadline exceeded", err)
+	}
+}
@@ -0,0 +1,196 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	kafkaQueueSize     = 10000                  // Events buffered before Publish drops them
+	kafkaBatchSize     = 100                    // Maximum records per write
+	kafkaMinBackoff    = 100 * time.Millisecond // First retry delay after a failed write
+	kafkaMaxBackoff    = 10 * time.Second       // Retry delay cap
+	kafkaFlushInterval = 50 * time.Millisecond  // Maximum time a partial batch waits
+)
+
+// Record is a keyed message destined for a Kafka topic.
+type Record struct {
+	Topic string
+	Key   []byte
+	Value []byte
+	Time  time.Time
+}
+
+// RecordWriter is the producer the Kafka sink writes through. A thin adapter
+// over a client library (e.g. kafka-go's Writer with a hash balancer) is
+// enough; WriteRecords must return nil only once every record is acked.
+type RecordWriter interface {
+	WriteRecords(ctx context.Context, records []Record) error
+}
+
+// KafkaSink exports change events to a Kafka topic with at-least-once
+// delivery of the events it queues. Records are keyed by document ID so a
+// hash partitioner keeps each document's events ordered within one
+// partition. Failed batches are retried with exponential backoff until
+// they succeed or the sink is closed. Publish runs on the hub's goroutine,
+// so it never waits: while Kafka is down and the queue is full, new events
+// are dropped and counted in Dropped.
+type KafkaSink struct {
+	writer  RecordWriter
+	topic   string
+	queue   chan Event
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	ctx     context.Context
+	cancel  context.CancelFunc
+	dropped atomic.Int64
+}
+
+// NewKafkaSink creates a sink and starts its delivery goroutine.
+func NewKafkaSink(writer RecordWriter, topic string) *KafkaSink {
+	ctx, cancel := context.WithCancel(context.Background())
+	k := &KafkaSink{
+		writer:  writer,
+		topic:   topic,
+		queue:   make(chan Event, kafkaQueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go k.run()
+	return k
+}
+
+// Publish enqueues an event, dropping it if the sink is closed or its
+// queue is full.
+func (k *KafkaSink) Publish(event Event) {
+	select {
+	case <-k.closing:
+		log.Printf("kafka sink closed, dropping %s event for %s", event.Type, event.DocumentID)
+		return
+	default:
+	}
+
+	select {
+	case k.queue <- event:
+	default:
+		// Log the first drop and then every thousandth, so an outage
+		// does not flood the log.
+		if n := k.dropped.Add(1); n == 1 || n%1000 == 0 {
+			log.Printf("kafka sink queue full, dropped %d events so far, latest %s for %s", n, event.Type, event.DocumentID)
+		}
+	}
+}
+
+// Dropped returns how many events were dropped because the queue was full.
+func (k *KafkaSink) Dropped() int64 {
+	return k.dropped.Load()
+}
+
+// Close stops accepting events and waits for queued events to be written,
+// giving up on undelivered events once ctx expires.
+func (k *KafkaSink) Close(ctx context.Context) error {
+	k.once.Do(func() { close(k.closing) })
+	select {
+	case <-k.done:
+		return nil
+	case <-ctx.Done():
+		k.cancel()
+		<-k.done
+		return ctx.Err()
+	}
+}
+
+// run batches queued events and writes them until the sink is closed, then
+// flushes whatever is still queued.
+func (k *KafkaSink) run() {
+	defer close(k.done)
+	defer k.cancel()
+
+	batch := make([]Record, 0, kafkaBatchSize)
+	timer := time.NewTimer(kafkaFlushInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case event := <-k.queue:
+			batch = k.add(batch, event)
+
+		case <-timer.C:
+			batch = k.write(batch)
+			timer.Reset(kafkaFlushInterval)
+
+		case <-k.closing:
+			for {
+				select {
+				case event := <-k.queue:
+					batch = k.add(batch, event)
+				default:
+					k.write(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// add encodes an event into the batch, writing the batch once it is full.
+func (k *KafkaSink) add(batch []Record, event Event) []Record {
+	record, err := k.record(event)
+	if err != nil {
+		log.Printf("kafka sink: failed to encode event: %v", err)
+		return batch
+	}
+	batch = append(batch, record)
+	if len(batch) >= kafkaBatchSize {
+		return k.write(batch)
+	}
+	return batch
+}
+
+// write delivers a batch, retrying until it succeeds or the sink is
+// cancelled, and returns the emptied batch for reuse.
+func (k *KafkaSink) write(batch []Record) []Record {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	backoff := kafkaMinBackoff
+	for {
+		err := k.writer.WriteRecords(k.ctx, batch)
+		if err == nil {
+			return batch[:0]
+		}
+		if k.ctx.Err() != nil {
+			log.Printf("kafka sink: abandoning %d undelivered events: %v", len(batch), err)
+			return batch[:0]
+		}
+
+		log.Printf("kafka sink: write failed, retrying in %v: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-k.ctx.Done():
+		}
+		backoff = min(backoff*2, kafkaMaxBackoff)
+	}
+}
+
+// record encodes an event as a Kafka record keyed by document ID.
+func (k *KafkaSink) record(event Event) (Record, error) {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{
+		Topic: k.topic,
+		Key:   []byte(event.DocumentID),
+		Value: value,
+		Time:  event.Time,
+	}, nil
+}
@@ -0,0 +1,169 @@
+// Package export turns a document into a file to download: Markdown, HTML
+// or plain text. Rich text is converted to the format's own markup; other
+// documents keep their content and are given the right content type and
+// file name.
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/render"
+)
+
+// Format is a kind of file a document can be exported as.
+type Format string
+
+const (
+	FormatMarkdown Format = "md"
+	FormatHTML     Format = "html"
+	FormatText     Format = "txt"
+)
+
+// ParseFormat parses a format name. An empty name is Markdown.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case "":
+		return FormatMarkdown, nil
+	case FormatMarkdown, FormatHTML, FormatText:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown export format %q: want md, html or txt", name)
+}
+
+// File is an exported document.
+type File struct {
+	Name        string // Suggested file name, from the document's title
+	ContentType string
+	Data        []byte
+}
+
+// Export renders in, titled by its document ID, as a file in format f.
+func Export(in render.Input, f Format) (*File, error) {
+	switch f {
+	case FormatHTML:
+		data, err := render.HTML(in)
+		if err != nil {
+			return nil, err
+		}
+		return &File{in.Title + ".html", "text/html; charset=utf-8", data}, nil
+	case FormatMarkdown:
+		return &File{in.Title + ".md", "text/markdown; charset=utf-8", []byte(Markdown(in))}, nil
+	case FormatText:
+		if in.Kind == document.KindJSON {
+			return &File{in.Title + ".json", "application/json", []byte(in.Content)}, nil
+		}
+		return &File{in.Title + ".txt", "text/plain; charset=utf-8", []byte(in.Content)}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", f)
+}
+
+// Markdown renders the content of in as Markdown: rich text converted to
+// Markdown markup, JSON documents and code fenced, and markdown as it is.
+func Markdown(in render.Input) string {
+	switch {
+	case in.Kind == document.KindJSON:
+		var indented bytes.Buffer
+		if json.Indent(&indented, []byte(in.Content), "", "  ") != nil {
+			return fence("json", in.Content)
+		}
+		return fence("json", indented.String())
+	case in.Language != "" && in.Language != "markdown":
+		return fence(in.Language, in.Content)
+	case in.Language == "" && len(in.Formatting) > 0:
+		return richText(in.Formatting.Lines(in.Content))
+	}
+	return in.Content
+}
+
+// fence wraps text in a code fence longer than any run of backticks in it.
+func fence(language, text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	marker := strings.Repeat("`", max(3, longest+1))
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return marker + language + "\n" + text + marker + "\n"
+}
+
+// richText converts formatted lines to Markdown, as render does to HTML:
+// each line a paragraph, or a heading if its first run has one, and blank
+// lines left out.
+func richText(lines [][]operations.Run) string {
+	var paragraphs []string
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var b strings.Builder
+		if level := line[0].Attributes[operations.AttrHeading]; level != "" {
+			b.WriteString(strings.Repeat("#", int(level[0]-'0')) + " ")
+		}
+		for i, run := range line {
+			b.WriteString(inline(run, i == 0))
+		}
+		paragraphs = append(paragraphs, b.String())
+	}
+	if len(paragraphs) == 0 {
+		return ""
+	}
+	return strings.Join(paragraphs, "\n\n") + "\n"
+}
+
+// inline converts one run to Markdown. Emphasis markers go inside the
+// run's surrounding spaces, where Markdown recognizes them.
+func inline(run operations.Run, lineStart bool) string {
+	text := escape(run.Text, lineStart)
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	lead := text[:strings.Index(text, trimmed)]
+	trail := text[len(lead)+len(trimmed):]
+
+	marker := ""
+	if run.Attributes[operations.AttrBold] != "" {
+		marker += "**"
+	}
+	if run.Attributes[operations.AttrItalic] != "" {
+		marker += "*"
+	}
+	out := marker + trimmed + marker
+	if link := run.Attributes[operations.AttrLink]; render.SafeLink(link) {
+		out = "[" + out + "](" + linkEscaper.Replace(link) + ")"
+	}
+	return lead + out + trail
+}
+
+// linkEscaper escapes the characters that would end a link destination.
+var linkEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+
+// escape backslash-escapes the characters of text Markdown would read as
+// markup, and at the start of a line those that begin a block.
+func escape(text string, lineStart bool) string {
+	var b strings.Builder
+	for i, r := range text {
+		switch {
+		case strings.ContainsRune("\\`*_[]<>#|~!&", r):
+			b.WriteByte('\\')
+		case lineStart && i == 0 && strings.ContainsRune("-+=", r):
+			b.WriteByte('\\')
+		case lineStart && r == '.' && i > 0 && strings.Trim(text[:i], "0123456789") == "":
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
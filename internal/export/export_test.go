@@ -0,0 +1,65 @@
+package export
+
+import (
+	"strings"
+	"testing"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/render"
+)
+
+func TestMarkdown(t *testing.T) {
+	bold := operations.Attributes{operations.AttrBold: "true"}
+	tests := []struct {
+		name string
+		in   render.Input
+		want string
+	}{
+		{"markdown as it is", render.Input{Content: "# Title\n\n*x*"}, "# Title\n\n*x*"},
+		{"rich text", render.Input{Content: "Plan\n\nship it now\n1. not a list", Formatting: operations.Spans{
+			{Position: 0, Length: 4, Attributes: operations.Attributes{operations.AttrHeading: "2"}},
+			{Position: 10, Length: 3, Attributes: operations.Attributes{operations.AttrBold: "true", operations.AttrItalic: "true"}},
+			{Position: 13, Length: 4, Attributes: operations.Attributes{operations.AttrLink: "https://example.com/a b"}},
+		}}, "## Plan\n\nship ***it*** [now](https://example.com/a%20b)\n\n1\\. not a list\n"},
+		{"escaped", render.Input{Content: "*a* b_c", Formatting: operations.Spans{{Position: 4, Length: 3, Attributes: bold}}}, "\\*a\\* **b\\_c**\n"},
+		{"unsafe link", render.Input{Content: "x", Formatting: operations.Spans{
+			{Position: 0, Length: 1, Attributes: operations.Attributes{operations.AttrLink: "javascript:alert(1)"}},
+		}}, "x\n"},
+		{"code", render.Input{Language: "go", Content: "s := \"```\""}, "````go\ns := \"```\"\n````\n"},
+		{"json", render.Input{Kind: document.KindJSON, Content: `{"a":1}`}, "```json\n{\n  \"a\": 1\n}\n```\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Markdown(tt.in); got != tt.want {
+				t.Errorf("Markdown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Error("ParseFormat(\"pdf\") succeeded")
+	}
+	tests := []struct {
+		in          render.Input
+		format      Format
+		name, ctype string
+		contains    string
+	}{
+		{render.Input{Title: "notes", Content: "hi"}, FormatMarkdown, "notes.md", "text/markdown; charset=utf-8", "hi"},
+		{render.Input{Title: "notes", Content: "hi"}, FormatHTML, "notes.html", "text/html; charset=utf-8", "<p>hi</p>"},
+		{render.Input{Title: "notes", Content: "**hi**"}, FormatText, "notes.txt", "text/plain; charset=utf-8", "**hi**"},
+		{render.Input{Title: "data", Kind: document.KindJSON, Content: `{"a":1}`}, FormatText, "data.json", "application/json", `{"a":1}`},
+	}
+	for _, tt := range tests {
+		f, err := Export(tt.in, tt.format)
+		if err != nil {
+			t.Fatalf("Export(%s) error: %v", tt.format, err)
+		}
+		if f.Name != tt.name || f.ContentType != tt.ctype || !strings.Contains(string(f.Data), tt.contains) {
+			t.Errorf("Export(%s) = %s %s %q, want %s %s containing %q", tt.format, f.Name, f.ContentType, f.Data, tt.name, tt.ctype, tt.contains)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Package handshake runs HTTP middleware on WebSocket requests before the
+// upgrade, such as authentication, rate limiting, tenant resolution and
+// logging, so integrators compose their handshake instead of copying the
+// server's handler.
+//
+// Middleware hands what it resolves to the server through the request
+// context: WithIdentity records the authenticated principal, which then
+// replaces the server's own token check, and WithTenant records the
+// tenant. The server passes both to the hub's client, where they appear
+// in client listings. WithSessionDeadline bounds how long the connection
+// may stay open, for example until the credential expires. Middleware
+// refusing a request writes the response
+// itself and does not call the next handler.
+package handshake
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"collaborative-docs/internal/auth"
+)
+
+// Middleware wraps the handler that performs the upgrade.
+type Middleware func(next http.Handler) http.Handler
+
+// Chain wraps h in middleware; the first runs first.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+type contextKey int
+
+const (
+	identityKey contextKey = iota
+	tenantKey
+	deadlineKey
+)
+
+// WithIdentity returns a context carrying the authenticated principal.
+func WithIdentity(ctx context.Context, id *auth.Identity) context.Context {
+	return context.WithValue(ctx, identityKey, id)
+}
+
+// Identity returns the principal recorded by WithIdentity, or nil.
+func Identity(ctx context.Context) *auth.Identity {
+	id, _ := ctx.Value(identityKey).(*auth.Identity)
+	return id
+}
+
+// WithTenant returns a context carrying the tenant a request belongs to.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// Tenant returns the tenant recorded by WithTenant, or "".
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey).(string)
+	return tenant
+}
+
+// WithSessionDeadline returns a context recording when the connection must
+// close. An earlier deadline already recorded is kept.
+func WithSessionDeadline(ctx context.Context, deadline time.Time) context.Context {
+	if d, ok := SessionDeadline(ctx); ok && d.Before(deadline) {
+		return ctx
+	}
+	return context.WithValue(ctx, deadlineKey, deadline)
+}
+
+// SessionDeadline returns the deadline recorded by WithSessionDeadline.
+func SessionDeadline(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Value(deadlineKey).(time.Time)
+	return deadline, ok
+}
@@ -0,0 +1,113 @@
+package handshake
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"collaborative-docs/internal/auth"
+	"collaborative-docs/internal/clock"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), &auth.Identity{Subject: "alice"})))
+		})
+	}
+	tenant := ResolveTenant(func(r *http.Request) (string, error) {
+		if name, ok := strings.CutSuffix(r.Host, ".docs.example"); ok {
+			return name, nil
+		}
+		return "", errors.New("unknown tenant")
+	})
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+		if id := Identity(r.Context()); id == nil || id.Subject != "alice" || Tenant(r.Context()) != "acme" {
+			t.Errorf("handler got identity %v, tenant %q", id, Tenant(r.Context()))
+		}
+	}), mark("first"), authenticate, tenant, mark("second"))
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/doc", nil)
+	req.Host = "acme.docs.example"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got := strings.Join(order, ","); got != "first,second,handler" {
+		t.Errorf("order = %s", got)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws/doc", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("unknown tenant status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	h := RateLimit(0.5, 2, fake)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	attempt := func(addr string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ws/doc", nil)
+		req.RemoteAddr = addr
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := attempt("10.0.0.1:1000"); rec.Code != http.StatusOK {
+			t.Fatalf("attempt %d status = %d", i, rec.Code)
+		}
+	}
+	rec := attempt("10.0.0.1:1001")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("over limit = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := attempt("10.0.0.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("other address status = %d", rec.Code)
+	}
+	fake.Advance(2 * time.Second)
+	if rec := attempt("10.0.0.1:1002"); rec.Code != http.StatusOK {
+		t.Errorf("after refill status = %d", rec.Code)
+	}
+}
+
+func TestMaxSession(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	var got time.Time
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = SessionDeadline(r.Context())
+	}), MaxSession(time.Hour, fake), MaxSession(2*time.Hour, fake))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ws/doc", nil))
+	if want := time.Unix(0, 0).Add(time.Hour); !got.Equal(want) {
+		t.Errorf("deadline = %v, want the earlier %v", got, want)
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}), ResolveTenant(func(*http.Request) (string, error) { return "acme", nil }), Log(log.New(&buf, "", 0)))
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/doc", nil)
+	req.RemoteAddr = "10.0.0.1:1000"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got := buf.String(); !strings.HasPrefix(got, "handshake /ws/doc from 10.0.0.1: 403 in ") || !strings.Contains(got, `tenant "acme"`) {
+		t.Errorf("log = %q", got)
+	}
+}
@@ -0,0 +1,178 @@
+package handshake
+
+import (
+	"bufio"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"collaborative-docs/internal/clock"
+)
+
+// maxTracked bounds the client addresses RateLimit remembers; beyond it,
+// addresses whose allowance has refilled are forgotten.
+const maxTracked = 10000
+
+// RateLimit refuses more than burst handshakes at once from one client
+// address, refilling the allowance at perSecond, with 429 and a
+// Retry-After header. The address is the connection's, so behind a proxy
+// it is the proxy's. A nil clock uses the wall clock.
+func RateLimit(perSecond float64, burst int, c clock.Clock) Middleware {
+	if c == nil {
+		c = clock.Real
+	}
+	l := &limiter{perSecond: perSecond, burst: float64(burst), clock: c, buckets: make(map[string]*bucket)}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if wait := l.take(clientAddress(r)); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "too many connection attempts", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type limiter struct {
+	perSecond float64
+	burst     float64
+	clock     clock.Clock
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// take spends one of key's tokens, or returns how long until one is
+// available.
+func (l *limiter) take(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	if len(l.buckets) >= maxTracked {
+		for k, b := range l.buckets {
+			if l.refill(b, now) >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens, b.at = l.refill(b, now), now
+	if b.tokens < 1 {
+		if l.perSecond <= 0 {
+			return time.Hour
+		}
+		return time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+func (l *limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.at).Seconds()*l.perSecond)
+}
+
+// clientAddress returns the host of the request's remote address.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ResolveTenant records the tenant resolve returns for each request, such
+// as one named by the Host header or a path prefix, with WithTenant.
+// Requests it fails for are refused with 403 and the error.
+func ResolveTenant(resolve func(r *http.Request) (string, error)) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant, err := resolve(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+		})
+	}
+}
+
+// MaxSession closes connections d after their handshake, so that clients
+// reconnect and are authorized again. A nil clock uses the wall clock.
+func MaxSession(d time.Duration, c clock.Clock) Middleware {
+	if c == nil {
+		c = clock.Real
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithSessionDeadline(r.Context(), c.Now().Add(d))))
+		})
+	}
+}
+
+// Log logs each handshake with its outcome, duration, and the identity
+// and tenant that middleware before it resolved. Upgraded connections are
+// logged with status 101. A nil logger uses the standard logger.
+func Log(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			subject := ""
+			if id := Identity(r.Context()); id != nil {
+				subject = id.Subject
+			}
+			logger.Printf("handshake %s from %s: %d in %v (subject %q, tenant %q)",
+				r.URL.Path, clientAddress(r), rec.status, time.Since(start).Round(time.Microsecond), subject, Tenant(r.Context()))
+		})
+	}
+}
+
+// statusRecorder captures the response status. It can be hijacked, as the
+// upgrade requires.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.status, w.wroteHeader = http.StatusSwitchingProtocols, true
+	}
+	return conn, rw, err
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
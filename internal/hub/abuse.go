@@ -0,0 +1,288 @@
+package hub
+
+import (
+	"collaborative-docs/internal/events"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// AbusePolicy scores misbehaving connections and decides when to act on
+// them. Every client accrues points for flooding, refused or failed edits
+// and malformed messages; the score halves every HalfLife, so a client that
+// settles down recovers on its own.
+type AbusePolicy struct {
+	MaxMessagesPerSecond float64       // Rate above which each further message scores RateWeight
+	RateWeight           float64       // Points per message over the rate limit
+	RejectWeight         float64       // Points per refused or failed edit
+	ParseErrorWeight     float64       // Points per JSON message that is not a valid message
+	HalfLife             time.Duration // Time for a score to decay by half
+	ThrottleScore        float64       // Score at which messages are dropped; zero never throttles
+	DisconnectScore      float64       // Score at which the connection is closed; zero never disconnects
+}
+
+// DefaultAbusePolicy scores clients but never throttles or disconnects them.
+var DefaultAbusePolicy = AbusePolicy{
+	MaxMessagesPerSecond: 50,
+	RateWeight:           1,
+	RejectWeight:         2,
+	ParseErrorWeight:     5,
+	HalfLife:             10 * time.Second,
+}
+
+// clientStats counts one connection's traffic. It is only used while
+// handling the client's messages, in its room or on the Run goroutine, and
+// by work that runs there.
+type clientStats struct {
+	connected   time.Time
+	active      time.Time // Last edit or cursor move, for presence
+	messages    int
+	bytes       int
+	rejected    int
+	parseErrors int
+	dropped     int
+
+	windowStart time.Time // Start of the current one-second rate window
+	windowCount int       // Messages in the current window
+	rate        float64   // Messages in the last complete window
+
+	score        float64
+	scoredAt     time.Time // When score was last decayed
+	throttled    bool
+	disconnected bool // Closed for abuse; later messages already read are dropped
+}
+
+// ClientInfo describes one connection and its traffic.
+type ClientInfo struct {
+	ID                string    `json:"id"`
+	DocumentID        string    `json:"document_id"`
+	Subject           string    `json:"subject,omitempty"` // Authenticated principal
+	Tenant            string    `json:"tenant,omitempty"`
+	ReadOnly          bool      `json:"read_only,omitempty"`
+	Historical        bool      `json:"historical,omitempty"`
+	Connected         time.Time `json:"connected"`
+	Messages          int       `json:"messages"`
+	Bytes             int       `json:"bytes"`
+	MessagesPerSecond float64   `json:"messages_per_second"`
+	Rejected          int       `json:"rejected"`     // Refused or failed edits
+	ParseErrors       int       `json:"parse_errors"` // JSON messages that are not valid messages
+	Dropped           int       `json:"dropped"`      // Messages discarded while throttled
+	AbuseScore        float64   `json:"abuse_score"`
+	Throttled         bool      `json:"throttled,omitempty"`
+}
+
+// SetAbusePolicy replaces the policy used to score clients. Zero rates,
+// weights and half-life keep their defaults. It must be called before Run.
+func (h *Hub) SetAbusePolicy(p AbusePolicy) {
+	d := DefaultAbusePolicy
+	if p.MaxMessagesPerSecond <= 0 {
+		p.MaxMessagesPerSecond = d.MaxMessagesPerSecond
+	}
+	if p.RateWeight <= 0 {
+		p.RateWeight = d.RateWeight
+	}
+	if p.RejectWeight <= 0 {
+		p.RejectWeight = d.RejectWeight
+	}
+	if p.ParseErrorWeight <= 0 {
+		p.ParseErrorWeight = d.ParseErrorWeight
+	}
+	if p.HalfLife <= 0 {
+		p.HalfLife = d.HalfLife
+	}
+	h.abuse = p
+}
+
+// Clients returns every connection with its traffic statistics, highest
+// abuse score first.
+func (h *Hub) Clients() ([]ClientInfo, error) {
+	var infos []ClientInfo
+	if !h.do(func() { infos = h.clientInfos() }) {
+		return nil, ErrHubStopped
+	}
+	return infos, nil
+}
+
+func (h *Hub) clientInfos() []ClientInfo {
+	now := h.clock.Now()
+	h.mu.RLock()
+	infos := make([]ClientInfo, 0, len(h.clients))
+	for c := range h.clients {
+		s := &c.stats
+		score := h.decayedScore(s, now)
+		infos = append(infos, ClientInfo{
+			ID:                c.id,
+			DocumentID:        c.documentID,
+			Subject:           c.subject,
+			Tenant:            c.tenant,
+			ReadOnly:          c.readOnly,
+			Historical:        c.historical,
+			Connected:         s.connected,
+			Messages:          s.messages,
+			Bytes:             s.bytes,
+			MessagesPerSecond: s.currentRate(now),
+			Rejected:          s.rejected,
+			ParseErrors:       s.parseErrors,
+			Dropped:           s.dropped,
+			AbuseScore:        score,
+			Throttled:         s.throttled && score >= h.abuse.ThrottleScore,
+		})
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].AbuseScore != infos[j].AbuseScore {
+			return infos[i].AbuseScore > infos[j].AbuseScore
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// admit counts an inbound message against its sender and reports whether
+// the hub should handle it. Messages from throttled or disconnected clients
+// are dropped.
+func (h *Hub) admit(bm *broadcastMessage) bool {
+	c := bm.sender
+	if c == nil {
+		return true
+	}
+	if c.stats.disconnected {
+		return false
+	}
+
+	now := h.clock.Now()
+	s := &c.stats
+	s.messages++
+	h.tally(func(t *Traffic) { t.Messages++ })
+	s.bytes += len(bm.message)
+
+	if elapsed := now.Sub(s.windowStart); elapsed >= time.Second {
+		if elapsed < 2*time.Second {
+			s.rate = float64(s.windowCount)
+		} else {
+			s.rate = 0 // idle for a whole window
+		}
+		s.windowStart, s.windowCount = now, 0
+	}
+	s.windowCount++
+	if float64(s.windowCount) > h.abuse.MaxMessagesPerSecond {
+		h.score(c, h.abuse.RateWeight)
+	}
+
+	if s.disconnected {
+		return false
+	}
+	if s.throttled && h.decayedScore(s, now) < h.abuse.ThrottleScore {
+		s.throttled = false
+	}
+	if s.throttled {
+		s.dropped++
+		h.tally(func(t *Traffic) { t.Dropped++ })
+		return false
+	}
+	return true
+}
+
+// tally updates the hub's traffic counts.
+func (h *Hub) tally(update func(t *Traffic)) {
+	h.stateMu.Lock()
+	defer h.stateMu.Unlock()
+	update(&h.traffic)
+}
+
+// noteRejected records a refused or failed edit from c.
+func (h *Hub) noteRejected(c *Client) {
+	if c == nil {
+		return
+	}
+	c.stats.rejected++
+	h.tally(func(t *Traffic) { t.Rejected++ })
+	h.score(c, h.abuse.RejectWeight)
+}
+
+// noteParseError records a message from c that is JSON but not a valid
+// message. Plain text is legacy content, not an error.
+func (h *Hub) noteParseError(c *Client) {
+	if c == nil {
+		return
+	}
+	c.stats.parseErrors++
+	h.tally(func(t *Traffic) { t.ParseErrors++ })
+	h.score(c, h.abuse.ParseErrorWeight)
+}
+
+// score adds points to c's abuse score and throttles, releases or
+// disconnects it as the policy directs.
+func (h *Hub) score(c *Client, points float64) {
+	now := h.clock.Now()
+	s := &c.stats
+	s.score = h.decayedScore(s, now) + points
+	s.scoredAt = now
+
+	p := h.abuse
+	switch {
+	case p.DisconnectScore > 0 && s.score >= p.DisconnectScore:
+		log.Printf("disconnecting client %s on document %s (abuse score %.1f)", c.id, c.documentID, s.score)
+		h.events.Emit(events.Event{
+			Type:       events.TypeClientDisconnected,
+			DocumentID: c.documentID,
+			Detail:     c.id,
+		})
+		s.disconnected = true
+		h.tally(func(t *Traffic) { t.Disconnected++ })
+		c.stop(ErrAbusive)
+		h.removeClient(c)
+
+	case p.ThrottleScore > 0 && s.score >= p.ThrottleScore:
+		if s.throttled {
+			return
+		}
+		s.throttled = true
+		h.tally(func(t *Traffic) { t.Throttled++ })
+		log.Printf("throttling client %s on document %s (abuse score %.1f)", c.id, c.documentID, s.score)
+		h.events.Emit(events.Event{
+			Type:       events.TypeClientThrottled,
+			DocumentID: c.documentID,
+			Detail:     c.id,
+		})
+		if data, err := h.newThrottledMessage(c).ToBytes(); err == nil {
+			h.sendToClient(c, data)
+		}
+
+	default:
+		s.throttled = false
+	}
+}
+
+// decayedScore returns s's abuse score as of now.
+func (h *Hub) decayedScore(s *clientStats, now time.Time) float64 {
+	if s.score == 0 || !now.After(s.scoredAt) {
+		return s.score
+	}
+	halvings := float64(now.Sub(s.scoredAt)) / float64(h.abuse.HalfLife)
+	return s.score * math.Pow(0.5, halvings)
+}
+
+// currentRate returns the messages per second of the last complete window,
+// or zero if the client has been quiet since.
+func (s *clientStats) currentRate(now time.Time) float64 {
+	if now.Sub(s.windowStart) >= 2*time.Second {
+		return 0
+	}
+	return s.rate
+}
+
+// newThrottledMessage tells c its messages are being dropped and when its
+// score will have decayed below the throttle threshold.
+func (h *Hub) newThrottledMessage(c *Client) *Message {
+	halvings := math.Log2(c.stats.score / h.abuse.ThrottleScore)
+	retry := time.Duration(math.Ceil(halvings * float64(h.abuse.HalfLife)))
+	return &Message{
+		Type:         MsgTypeThrottled,
+		DocumentID:   c.documentID,
+		Reason:       "too many messages or errors",
+		RetryAfterMS: int(retry/time.Millisecond) + 1,
+	}
+}
@@ -0,0 +1,91 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/schema"
+	"errors"
+	"log"
+)
+
+// ErrorCode says why the hub could not apply a client's edit.
+type ErrorCode string
+
+const (
+	ErrorInvalidOperation ErrorCode = "invalid_operation" // The edit does not apply to the content, e.g. a position out of range
+	ErrorWrongKind        ErrorCode = "wrong_kind"        // The edit does not fit the document's kind, e.g. a JSON operation on text
+	ErrorResyncRequired   ErrorCode = "resync_required"   // The edit's version is outside the document's history window; the content follows
+	ErrorFenced           ErrorCode = "fenced"            // The edit changes fenced text the sender may not edit
+	ErrorRateLimited      ErrorCode = "rate_limited"      // The message exceeded the sender's rate limit and was dropped; retry after RetryAfterMS
+)
+
+// ackOperation confirms to the sender that the operation with the given
+// client ID, received as message traceID, is applied at version.
+// Operations without an ID are not acknowledged.
+func (h *Hub) ackOperation(sender *Client, documentID, id, traceID string, version int) {
+	if id == "" || sender == nil {
+		return
+	}
+	ack := NewAckMessage(documentID, id, version)
+	ack.TraceID = traceID
+	data, err := ack.ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(sender, data)
+}
+
+// ackID returns the ID the sender gave an edit: a text operation's own ID
+// or, for block and JSON operations, which have none, the message's AckID.
+func (m *Message) ackID() string {
+	if m.Operation != nil && m.Operation.ID != "" {
+		return m.Operation.ID
+	}
+	return m.AckID
+}
+
+// failEdit tells the sender why its edit failed. An edit that would break
+// the document's schema or limits is rejected as rejectViolation does; any
+// other failure is answered with an error message carrying the document's
+// current version, so the sender can rebase its edits onto it or resync
+// instead of diverging. An operation too far behind to transform is
+// followed by the document's content, for the sender to resync from.
+func (h *Hub) failEdit(sender *Client, documentID string, msg *Message, err error) {
+	if sender == nil {
+		return
+	}
+	var violation *schema.ViolationError
+	if errors.As(err, &violation) {
+		h.rejectViolation(sender, documentID, msg, err)
+		return
+	}
+
+	code := editErrorCode(err)
+	reply := &Message{Type: MsgTypeError, DocumentID: documentID, Code: code, Reason: err.Error(), AckID: msg.ackID(), TraceID: msg.TraceID}
+	doc := h.GetDocument(documentID)
+	if doc != nil {
+		reply.Version = doc.GetVersion()
+	}
+	data, err := reply.ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(sender, data)
+	if code == ErrorResyncRequired && doc != nil {
+		h.sendContent(documentID, doc, sender)
+	}
+}
+
+// editErrorCode returns the error code for an edit refused with err.
+func editErrorCode(err error) ErrorCode {
+	switch {
+	case errors.Is(err, document.ErrWrongKind):
+		return ErrorWrongKind
+	case errors.Is(err, document.ErrVersionUnavailable):
+		return ErrorResyncRequired
+	case errors.Is(err, document.ErrFenced):
+		return ErrorFenced
+	}
+	return ErrorInvalidOperation
+}
@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// ErrUnknownRole is returned for a role other than viewer, editor and
+// maintainer.
+var ErrUnknownRole = errors.New("unknown role")
+
+// SetOwner makes userID the owner of a document, who may always read and
+// edit it; "" leaves it without one. Like SetVisibility, it is enforced
+// when clients connect and on REST requests; sessions already open are
+// not affected.
+func (h *Hub) SetOwner(documentID, userID string) error {
+	var err error
+	if !h.do(func() {
+		err = h.setAccess(documentID, func(doc *document.Document) []string {
+			previous := doc.Owner()
+			doc.SetOwner(userID)
+			if previous == userID {
+				return []string{userID}
+			}
+			return []string{previous, userID}
+		})
+	}) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+// SetRoles gives users, by ID, a role on a document whatever its
+// visibility, so a viewer may only watch a document open to everyone else;
+// an empty role removes the user's entry. Either every change applies or,
+// if any is invalid, none does. Sessions already open are not affected.
+func (h *Hub) SetRoles(documentID string, roles map[string]document.Role) error {
+	for userID, role := range roles {
+		if userID == "" {
+			return fmt.Errorf("user ID is required")
+		}
+		if err := checkRole(role); err != nil {
+			return err
+		}
+	}
+	if len(roles) == 0 {
+		return nil
+	}
+	var err error
+	if !h.do(func() {
+		err = h.setAccess(documentID, func(doc *document.Document) []string {
+			users := make([]string, 0, len(roles))
+			for userID, role := range roles {
+				doc.SetRole(userID, role)
+				users = append(users, userID)
+			}
+			sort.Strings(users)
+			return users
+		})
+	}) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+// checkRole returns ErrUnknownRole unless role is viewer, editor,
+// maintainer or "", which removes an entry.
+func checkRole(role document.Role) error {
+	switch role {
+	case "", document.RoleViewer, document.RoleEditor, document.RoleMaintainer:
+		return nil
+	}
+	return fmt.Errorf("%w %q", ErrUnknownRole, role)
+}
+
+// setAccess applies an access change to a document and reports it for
+// each user change names.
+func (h *Hub) setAccess(documentID string, change func(doc *document.Document) []string) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+	users := change(h.GetOrCreateDocument(documentID))
+	log.Printf("document %s access changed", documentID)
+	for _, userID := range users {
+		if userID == "" {
+			continue
+		}
+		h.events.Emit(events.Event{
+			Type:       events.TypeAccessChanged,
+			DocumentID: documentID,
+			Detail:     userID,
+		})
+	}
+	return nil
+}
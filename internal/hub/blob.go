@@ -0,0 +1,224 @@
+package hub
+
+import (
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/document"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxBlobChunkSize   = 64 * 1024       // Maximum decoded bytes per blob chunk (64KB)
+	maxBlobSize        = 1024 * 1024     // Maximum assembled blob size (1MB)
+	maxBlobIDLength    = 64              // Maximum length of a client-chosen blob ID
+	blobAssemblyTTL    = 1 * time.Minute // Incomplete blobs older than this are dropped
+	maxPendingPerDoc   = 4               // Blobs that may be arriving at once for one document
+	maxPendingAllBlobs = 64              // Blobs that may be arriving at once across the hub
+)
+
+// maxBlobChunks is the largest chunk count a blob may declare.
+const maxBlobChunks = (maxBlobSize + maxBlobChunkSize - 1) / maxBlobChunkSize
+
+// BlobChunk is one piece of a small pasted file relayed over the WebSocket.
+// Data is base64-encoded on the wire.
+type BlobChunk struct {
+	ID          string `json:"id"`
+	Index       int    `json:"index"`
+	Total       int    `json:"total"`
+	ContentType string `json:"content_type,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Data        []byte `json:"data"`
+}
+
+// Validate checks chunk bounds against the blob limits.
+func (c *BlobChunk) Validate() error {
+	if c.ID == "" || len(c.ID) > maxBlobIDLength {
+		return fmt.Errorf("blob id must be 1-%d characters", maxBlobIDLength)
+	}
+	if c.Total < 1 || c.Total > maxBlobChunks {
+		return fmt.Errorf("blob chunk total %d out of range [1, %d]", c.Total, maxBlobChunks)
+	}
+	if c.Index < 0 || c.Index >= c.Total {
+		return fmt.Errorf("blob chunk index %d out of range [0, %d)", c.Index, c.Total)
+	}
+	if len(c.Data) > maxBlobChunkSize {
+		return fmt.Errorf("blob chunk of %d bytes exceeds %d", len(c.Data), maxBlobChunkSize)
+	}
+	return nil
+}
+
+// pendingBlob collects the chunks of a blob that is still arriving.
+type pendingBlob struct {
+	chunks      [][]byte
+	received    int
+	size        int
+	contentType string
+	filename    string
+	started     time.Time
+}
+
+// blobAssembler reassembles chunked blobs per document. Rooms use it at
+// once, so it has its own lock.
+type blobAssembler struct {
+	mu      sync.Mutex // Guards pending
+	pending map[string]*pendingBlob
+	clock   clock.Clock
+}
+
+// add records a chunk and returns the assembled blob once every chunk has
+// arrived.
+func (a *blobAssembler) add(documentID string, c *BlobChunk) (*document.Blob, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire()
+
+	key := documentID + "/" + c.ID
+	p, ok := a.pending[key]
+	if !ok {
+		if len(a.pending) >= maxPendingAllBlobs {
+			return nil, fmt.Errorf("blob %s refused: %d blobs already arriving", c.ID, maxPendingAllBlobs)
+		}
+		if a.pendingFor(documentID) >= maxPendingPerDoc {
+			return nil, fmt.Errorf("blob %s refused: %d blobs already arriving for the document", c.ID, maxPendingPerDoc)
+		}
+		p = &pendingBlob{chunks: make([][]byte, c.Total), started: a.clock.Now()}
+		a.pending[key] = p
+	}
+
+	if len(p.chunks) != c.Total {
+		delete(a.pending, key)
+		return nil, fmt.Errorf("blob %s changed chunk total from %d to %d", c.ID, len(p.chunks), c.Total)
+	}
+	if p.chunks[c.Index] != nil {
+		return nil, fmt.Errorf("duplicate chunk %d for blob %s", c.Index, c.ID)
+	}
+	if p.size+len(c.Data) > maxBlobSize {
+		delete(a.pending, key)
+		return nil, fmt.Errorf("blob %s exceeds %d bytes", c.ID, maxBlobSize)
+	}
+
+	p.chunks[c.Index] = c.Data
+	p.received++
+	p.size += len(c.Data)
+	if c.Index == 0 {
+		p.contentType = c.ContentType
+		p.filename = c.Filename
+	}
+
+	if p.received < c.Total {
+		return nil, nil
+	}
+
+	delete(a.pending, key)
+	data := make([]byte, 0, p.size)
+	for _, chunk := range p.chunks {
+		data = append(data, chunk...)
+	}
+	return &document.Blob{
+		ID:          c.ID,
+		ContentType: p.contentType,
+		Filename:    p.filename,
+		Data:        data,
+	}, nil
+}
+
+// pendingFor returns how many blobs are arriving for a document.
+func (a *blobAssembler) pendingFor(documentID string) int {
+	n := 0
+	for key := range a.pending {
+		if strings.HasPrefix(key, documentID+"/") {
+			n++
+		}
+	}
+	return n
+}
+
+// expire drops incomplete blobs whose sender stopped sending.
+func (a *blobAssembler) expire() {
+	cutoff := a.clock.Now().Add(-blobAssemblyTTL)
+	for key, p := range a.pending {
+		if p.started.Before(cutoff) {
+			log.Printf("dropping incomplete blob %s", key)
+			delete(a.pending, key)
+		}
+	}
+}
+
+// handleBlob relays a chunk to collaborators and stores the blob with the
+// document once complete. Chunks of a blob the document already holds,
+// or that its limits or the hub's pending assemblies cannot take, are
+// refused with an error to the sender.
+func (h *Hub) handleBlob(doc *document.Document, documentID string, msg *Message, sender *Client) {
+	if msg.Blob == nil {
+		return
+	}
+	err := msg.Blob.Validate()
+	if err == nil && doc.GetBlob(msg.Blob.ID) != nil {
+		err = fmt.Errorf("%w: %s", document.ErrBlobExists, msg.Blob.ID)
+	}
+	var blob *document.Blob
+	if err == nil {
+		blob, err = h.blobs.add(documentID, msg.Blob)
+	}
+	if err == nil && blob != nil {
+		err = doc.PutBlob(blob)
+	}
+	if err != nil {
+		log.Printf("blob chunk rejected for document %s: %v", documentID, err)
+		h.failEdit(sender, documentID, msg, err)
+		return
+	}
+	if blob != nil {
+		log.Printf("stored blob %s (%d bytes) on document %s", blob.ID, len(blob.Data), documentID)
+	}
+
+	msgBytes, err := msg.ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.broadcastToDocument(documentID, msgBytes, sender)
+}
+
+// handleBlobRequest sends a stored blob back to the requesting client in chunks.
+func (h *Hub) handleBlobRequest(doc *document.Document, documentID string, msg *Message, sender *Client) {
+	if msg.Blob == nil {
+		return
+	}
+
+	blob := doc.GetBlob(msg.Blob.ID)
+	if blob == nil {
+		log.Printf("blob %s not found on document %s", msg.Blob.ID, documentID)
+		return
+	}
+
+	for _, chunk := range splitBlob(blob) {
+		msgBytes, err := NewBlobMessage(documentID, chunk).ToBytes()
+		if err != nil {
+			log.Printf("serialization failed: %v", err)
+			return
+		}
+		h.sendToClient(sender, msgBytes)
+	}
+}
+
+// splitBlob divides a stored blob into wire chunks.
+func splitBlob(blob *document.Blob) []*BlobChunk {
+	total := (len(blob.Data) + maxBlobChunkSize - 1) / maxBlobChunkSize
+	if total == 0 {
+		total = 1
+	}
+
+	chunks := make([]*BlobChunk, total)
+	for i := range chunks {
+		start := i * maxBlobChunkSize
+		end := min(start+maxBlobChunkSize, len(blob.Data))
+		chunks[i] = &BlobChunk{ID: blob.ID, Index: i, Total: total, Data: blob.Data[start:end]}
+	}
+	chunks[0].ContentType = blob.ContentType
+	chunks[0].Filename = blob.Filename
+	return chunks
+}
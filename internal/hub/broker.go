@@ -0,0 +1,251 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Broker carries applied text operations between hubs running in
+// different processes, so clients connected to different server instances
+// can edit the same document. Each hub keeps its own clients and its own
+// copy of each document, publishes the operations it applies and applies
+// those its peers publish.
+type Broker interface {
+	// Publish sends data to every hub subscribed to the document,
+	// including the publisher. Messages for one document must arrive in
+	// the order they were published.
+	Publish(documentID string, data []byte) error
+
+	// Subscribe calls deliver with every message published for any
+	// document, from one goroutine at a time.
+	Subscribe(deliver func(documentID string, data []byte)) error
+}
+
+// SnapshotBroker is a Broker that stores messages while its peers are
+// unreachable and, when it cannot keep all of a document's, publishes a
+// snapshot of the document in their place. SetBroker gives it the hub's
+// snapshots.
+type SnapshotBroker interface {
+	Broker
+
+	// SetSnapshots sets how to read a document's current state, encoded
+	// for Publish. It is called before Subscribe.
+	SetSnapshots(snapshot func(documentID string) ([]byte, error))
+}
+
+// brokerMessage is an applied operation, or a snapshot standing in for
+// operations that could not be delivered, shared between hubs.
+type brokerMessage struct {
+	Node      string                `json:"node"` // The publishing hub, so it can skip its own messages
+	Operation *operations.Operation `json:"operation,omitempty"`
+	Snapshot  *brokerSnapshot       `json:"snapshot,omitempty"`
+	Author    *Author               `json:"author,omitempty"`
+	TraceID   string                `json:"trace_id,omitempty"`
+}
+
+// brokerSnapshot is a document's content at the publisher's version.
+type brokerSnapshot struct {
+	Content string `json:"content"`
+	Version int    `json:"version"`
+}
+
+// sharedSnapshots tracks the snapshots a hub exchanged with its peers.
+type sharedSnapshots struct {
+	mu      sync.Mutex
+	pending map[string]bool           // Documents with a snapshot of ours not yet back from the broker
+	floors  map[string]map[string]int // Version of each peer's last snapshot, by document and node
+}
+
+// SetBroker shares the text operations this hub applies with the other
+// hubs on b, and applies theirs. Call it before Run.
+//
+// Peers apply each operation as a concurrent edit, transformed past the
+// edits their own clients made since the version it was written against,
+// so documents stay in step while edits on different nodes are at least
+// the broker's delivery time apart. There is no global order for edits
+// made closer together, so placement of concurrent insertions can differ
+// between nodes; route a document's clients to one node where that
+// matters. Only documents a node has loaded or has clients on are kept in
+// step: others are loaded from the shared store on first use, so nodes
+// should share a Store. Block and JSON operations, metadata and presence stay on the node
+// that received them, and events are emitted only by the node that applied
+// an operation first.
+//
+// A SnapshotBroker may publish a document's content in place of operations
+// it could not deliver. Peers replace their copy with it, dropping their
+// own edits made since they last heard from the publisher; when two nodes
+// publish one for a document, the same node's wins on both.
+func (h *Hub) SetBroker(b Broker) error {
+	h.broker, h.node = b, newTraceID()
+	if sb, ok := b.(SnapshotBroker); ok {
+		sb.SetSnapshots(h.snapshotShared)
+	}
+	if err := b.Subscribe(h.receiveShared); err != nil {
+		h.broker = nil
+		return fmt.Errorf("subscribing to broker: %w", err)
+	}
+	return nil
+}
+
+// shareOperation publishes an operation this hub applied to its peers.
+func (h *Hub) shareOperation(documentID string, op *operations.Operation, author *Author, traceID string) {
+	if h.broker == nil {
+		return
+	}
+	data, err := json.Marshal(brokerMessage{Node: h.node, Operation: op, Author: author, TraceID: traceID})
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	if err := h.broker.Publish(documentID, data); err != nil {
+		log.Printf("sharing operation on document %s failed: %v (trace %s)", documentID, err, traceID)
+	}
+}
+
+// receiveShared applies a message from the broker on the Run goroutine.
+func (h *Hub) receiveShared(documentID string, data []byte) {
+	var m brokerMessage
+	if err := json.Unmarshal(data, &m); err != nil || (m.Operation == nil) == (m.Snapshot == nil) {
+		log.Printf("ignoring malformed broker message for document %s", documentID)
+		return
+	}
+	if m.Node == h.node {
+		if m.Snapshot != nil {
+			h.snapshots.mu.Lock()
+			delete(h.snapshots.pending, documentID)
+			h.snapshots.mu.Unlock()
+		}
+		return
+	}
+	h.do(func() { h.applyShared(documentID, &m) })
+}
+
+// applyShared applies an operation a peer applied to its copy of the
+// document, and relays it to this hub's clients.
+func (h *Hub) applyShared(documentID string, m *brokerMessage) {
+	h.mu.RLock()
+	doc := h.documents[documentID]
+	_, deleted := h.tombstones[documentID]
+	h.mu.RUnlock()
+	switch {
+	case deleted:
+		return
+	case doc == nil && h.ClientCountForDocument(documentID) == 0:
+		return // loaded from the store on first use
+	case doc == nil:
+		doc = h.GetOrCreateDocument(documentID)
+	}
+	if m.Snapshot != nil {
+		h.applySnapshot(documentID, doc, m)
+		return
+	}
+	if m.Operation.Version <= h.snapshots.floor(documentID, m.Node) {
+		return // in the peer's snapshot
+	}
+
+	// The peer reports the version the operation produced; it was written
+	// against the one before. The peer's earlier operations were applied
+	// here under the same author, so they are not transformed past.
+	op := *m.Operation
+	op.Version--
+	applied, err := doc.ApplyConcurrent(&op, "node:"+m.Node)
+	if err != nil {
+		log.Printf("shared operation on document %s failed: %v (trace %s)", documentID, err, m.TraceID)
+		return
+	}
+	if applied == nil {
+		return
+	}
+	log.Printf("shared operation applied to document %s, version: %d (trace %s)", documentID, applied.Version, m.TraceID)
+	h.relayShared(documentID, applied, m)
+	h.contentChanged(documentID, applied.Version, nil)
+}
+
+// relayShared sends this hub's clients an operation applied for a peer.
+func (h *Hub) relayShared(documentID string, op *operations.Operation, m *brokerMessage) {
+	msg := NewOperationMessage(op)
+	msg.DocumentID = documentID
+	msg.TraceID = m.TraceID
+	msg.Author = m.Author
+	data, err := msg.ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.broadcastOperation(documentID, op, data, nil)
+}
+
+// applySnapshot replaces this hub's copy of a document with a peer's
+// snapshot, and skips the peer's operations it covers. If this hub's own
+// snapshot of the document is still on its way, the node with the greater
+// ID wins, so the two do not swap copies.
+func (h *Hub) applySnapshot(documentID string, doc *document.Document, m *brokerMessage) {
+	h.snapshots.mu.Lock()
+	if h.snapshots.floors == nil {
+		h.snapshots.floors = make(map[string]map[string]int)
+	}
+	if h.snapshots.floors[documentID] == nil {
+		h.snapshots.floors[documentID] = make(map[string]int)
+	}
+	h.snapshots.floors[documentID][m.Node] = m.Snapshot.Version
+	ours := h.snapshots.pending[documentID] && h.node > m.Node
+	h.snapshots.mu.Unlock()
+	if ours {
+		return
+	}
+
+	// The changes are the peer's, so its later operations, written against
+	// the snapshot, are not transformed past them.
+	content, version := doc.GetContentAndVersion()
+	changed := false
+	for _, op := range operations.Diff(content, m.Snapshot.Content, version) {
+		applied, err := doc.ApplyConcurrent(op, "node:"+m.Node)
+		if err != nil {
+			log.Printf("snapshot of document %s from node %s failed: %v", documentID, m.Node, err)
+			break
+		}
+		version, changed = applied.Version, true
+		h.relayShared(documentID, applied, m)
+	}
+	if changed {
+		log.Printf("snapshot of document %s from node %s applied, version: %d", documentID, m.Node, version)
+		h.contentChanged(documentID, version, nil)
+	}
+}
+
+// snapshotShared encodes a document's current content for a
+// SnapshotBroker, and holds off peers' snapshots of it until it comes
+// back.
+func (h *Hub) snapshotShared(documentID string) ([]byte, error) {
+	h.mu.RLock()
+	doc := h.documents[documentID]
+	_, deleted := h.tombstones[documentID]
+	h.mu.RUnlock()
+	if doc == nil || deleted {
+		return nil, ErrDocumentNotFound
+	}
+	content, version := doc.GetContentAndVersion()
+	data, err := json.Marshal(brokerMessage{Node: h.node, Snapshot: &brokerSnapshot{Content: content, Version: version}})
+	if err != nil {
+		return nil, err
+	}
+	h.snapshots.mu.Lock()
+	if h.snapshots.pending == nil {
+		h.snapshots.pending = make(map[string]bool)
+	}
+	h.snapshots.pending[documentID] = true
+	h.snapshots.mu.Unlock()
+	return data, nil
+}
+
+// floor returns the version of node's last snapshot of a document, zero if
+// it sent none.
+func (s *sharedSnapshots) floor(documentID, node string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.floors[documentID][node]
+}
@@ -0,0 +1,200 @@
+package hub
+
+import (
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/operations"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// SyncMode is how a client wants remote operations delivered.
+type SyncMode string
+
+const (
+	SyncRealtime  SyncMode = "realtime"  // Every operation as soon as it is applied (default)
+	SyncCoalesced SyncMode = "coalesced" // Operations batched and sent once per interval
+)
+
+const (
+	defaultCoalesceInterval = 1 * time.Second        // Interval granted when a coalesced client names none
+	minCoalesceInterval     = 100 * time.Millisecond // Shortest interval a client may request
+	maxCoalesceInterval     = 10 * time.Second       // Longest interval a client may request
+)
+
+// SyncSettings is a client's requested or granted delivery cadence.
+// ContentDeltas asks for content changes, such as a legacy client's
+// content message, as an operation_batch against the version the client
+// holds rather than the full content, when that is smaller.
+type SyncSettings struct {
+	Mode          SyncMode `json:"mode"`
+	IntervalMS    int      `json:"interval_ms,omitempty"`
+	ContentDeltas bool     `json:"content_deltas,omitempty"`
+}
+
+// grant clamps requested settings to what the hub supports.
+func (s SyncSettings) grant() (SyncSettings, error) {
+	switch s.Mode {
+	case SyncRealtime, "":
+		return SyncSettings{Mode: SyncRealtime, ContentDeltas: s.ContentDeltas}, nil
+	case SyncCoalesced:
+		interval := time.Duration(s.IntervalMS) * time.Millisecond
+		if interval == 0 {
+			interval = defaultCoalesceInterval
+		}
+		interval = min(max(interval, minCoalesceInterval), maxCoalesceInterval)
+		return SyncSettings{Mode: SyncCoalesced, IntervalMS: int(interval / time.Millisecond), ContentDeltas: s.ContentDeltas}, nil
+	default:
+		return SyncSettings{}, fmt.Errorf("unknown sync mode %q", s.Mode)
+	}
+}
+
+// cadence holds the operations waiting for a coalesced client's next flush.
+// Broadcasts may come from outside the Run goroutine (metadata updates), so
+// it has its own lock.
+type cadence struct {
+	interval  time.Duration
+	pending   []*operations.Operation
+	scheduled bool // a flush is scheduled
+	mu        sync.Mutex
+}
+
+// handleSyncMode applies a client's requested cadence and replies with the
+// settings actually granted.
+func (h *Hub) handleSyncMode(documentID string, msg *Message, sender *Client) {
+	if msg.Sync == nil || sender == nil {
+		return
+	}
+	granted, err := msg.Sync.grant()
+	if err != nil {
+		log.Printf("sync mode rejected for document %s: %v", documentID, err)
+		return
+	}
+
+	h.mu.Lock()
+	previous := sender.cadence
+	if granted.Mode == SyncCoalesced {
+		sender.cadence = &cadence{interval: time.Duration(granted.IntervalMS) * time.Millisecond}
+	} else {
+		sender.cadence = nil
+	}
+	sender.deltas = granted.ContentDeltas
+	h.mu.Unlock()
+
+	// Operations queued under the old cadence must not be lost or reordered.
+	if previous != nil {
+		h.flushCadence(sender, previous)
+	}
+
+	data, err := NewSyncModeMessage(documentID, &granted).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(sender, data)
+}
+
+// broadcastOperation sends an applied operation to the document's clients,
+// immediately for realtime clients and at the next flush for coalesced ones.
+// With a coalescing window, it is held with the document's other recent
+// operations first.
+func (h *Hub) broadcastOperation(documentID string, op *operations.Operation, message []byte, exclude *Client) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.window > 0 {
+		h.holdOperation(documentID, op, message, exclude)
+		return
+	}
+	h.sendOperation(documentID, op, message, exclude)
+}
+
+// sendOperation implements broadcastOperation once an operation is due to
+// be sent. Callers must hold h.mu.
+func (h *Hub) sendOperation(documentID string, op *operations.Operation, message []byte, exclude *Client) {
+	message = h.stamp(message, documentID)
+
+	to := h.roomClients(documentID, func(c *Client) bool { return c != exclude && !c.historical })
+	realtime := to[:0]
+	for _, client := range to {
+		if cad := client.cadence; cad != nil {
+			h.queueOperation(client, cad, op)
+			continue
+		}
+		realtime = append(realtime, client)
+	}
+	h.deliver(h.rooms[documentID], message, realtime...)
+
+	log.Printf("broadcasted operation to %d clients on document: %s", len(realtime), documentID)
+}
+
+// queueOperation holds op for a coalesced client and schedules a flush if
+// none is pending.
+func (h *Hub) queueOperation(client *Client, cad *cadence, op *operations.Operation) {
+	cad.mu.Lock()
+	defer cad.mu.Unlock()
+
+	cad.pending = append(cad.pending, op)
+	if !cad.scheduled {
+		cad.scheduled = true
+		ticker := h.clock.NewTicker(cad.interval)
+		if !h.spawn(RoutineCadenceFlush, func() { h.awaitFlush(client, cad, ticker) }) {
+			ticker.Stop()
+		}
+	}
+}
+
+// awaitFlush flushes the client's queue on the first tick.
+func (h *Hub) awaitFlush(client *Client, cad *cadence, ticker clock.Ticker) {
+	defer ticker.Stop()
+
+	select {
+	case <-h.quit:
+	case <-ticker.C():
+		h.do(func() { h.flushCadence(client, cad) })
+	}
+}
+
+// flushCadence sends the client's queued operations as one batch.
+func (h *Hub) flushCadence(client *Client, cad *cadence) {
+	cad.mu.Lock()
+	ops := cad.pending
+	cad.pending = nil
+	cad.scheduled = false
+	cad.mu.Unlock()
+
+	if len(ops) == 0 {
+		return
+	}
+	data, err := NewOperationBatchMessage(client.documentID, ops).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(client, data)
+}
+
+// sendPendingFirst delivers a coalesced client's queued operations ahead of
+// another message, so the client never sees a later message before the
+// operations it depends on. Callers must hold h.mu.
+func (h *Hub) sendPendingFirst(client *Client) {
+	cad := client.cadence
+	if cad == nil {
+		return
+	}
+	cad.mu.Lock()
+	ops := cad.pending
+	cad.pending = nil
+	cad.mu.Unlock()
+	if len(ops) == 0 {
+		return
+	}
+
+	data, err := NewOperationBatchMessage(client.documentID, ops).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.deliver(client.room, h.stamp(data, client.documentID), client)
+}
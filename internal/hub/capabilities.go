@@ -0,0 +1,114 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"log"
+	"slices"
+)
+
+// Optional features a hub may advertise in its welcome message.
+const (
+	FeatureAttachments = "attachments" // attachment_request is served
+	FeatureBlobs       = "blobs"       // Small files pasted inline as blob chunks
+	FeatureMetadata    = "metadata"    // Document metadata get/set
+	FeaturePresence    = "presence"    // User counts, collaborator viewports and cursors
+	FeatureSchemas     = "schemas"     // Some documents enforce a line schema
+	FeatureSyncModes   = "sync_modes"  // Coalesced delivery via sync_mode
+	FeatureEphemeral   = "ephemeral"   // Relay-only messages with a TTL
+	FeatureConflicts   = "conflicts"   // Operations concurrent edits refuse or reshape are explained with a conflict message
+)
+
+// Capabilities describes what the hub supports, sent to each client on
+// connect so SDKs can feature-detect instead of hard-coding.
+type Capabilities struct {
+	MessageTypes   []MessageType `json:"message_types"`    // Types the hub accepts from clients
+	Compression    bool          `json:"compression"`      // permessage-deflate is negotiated
+	BinaryFrames   bool          `json:"binary_frames"`    // Binary WebSocket frames are accepted
+	MaxMessageSize int           `json:"max_message_size"` // Largest inbound frame in bytes
+	Features       []string      `json:"features"`
+}
+
+// Supports reports whether the named feature is enabled.
+func (c *Capabilities) Supports(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// clientMessageTypes are the message types the hub handles from clients.
+var clientMessageTypes = []MessageType{
+	MsgTypeContent,
+	MsgTypeOperation,
+	MsgTypeBlockOperation,
+	MsgTypeJSONOperation,
+	MsgTypeLanguage,
+	MsgTypeBlob,
+	MsgTypeBlobRequest,
+	MsgTypeMetadataSet,
+	MsgTypeMetadataGet,
+	MsgTypePreferencesSet,
+	MsgTypePreferencesGet,
+	MsgTypeViewport,
+	MsgTypeCursor,
+	MsgTypePresenceRequest,
+	MsgTypeSyncMode,
+	MsgTypeResync,
+	MsgTypeSyncRequest,
+	MsgTypeTransaction,
+	MsgTypeOutline,
+	MsgTypeUndo,
+	MsgTypeRedo,
+}
+
+// Capabilities returns what the hub currently supports.
+func (h *Hub) Capabilities() *Capabilities {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.capabilities(nil)
+}
+
+// capabilities builds the advertisement, leaving out features doc has
+// disabled when doc is not nil. Callers must hold h.mu.
+func (h *Hub) capabilities(doc *document.Document) *Capabilities {
+	types := append([]MessageType(nil), clientMessageTypes...)
+	features := []string{FeatureBlobs, FeatureMetadata, FeaturePresence, FeatureSyncModes, FeatureEphemeral, FeatureConflicts}
+	if h.attachments != nil {
+		types = append(types, MsgTypeAttachmentRequest)
+		features = append(features, FeatureAttachments)
+	}
+	if len(h.schemas) > 0 {
+		features = append(features, FeatureSchemas)
+	}
+	if doc != nil {
+		types = slices.DeleteFunc(types, func(t MessageType) bool {
+			f := (&Message{Type: t}).feature()
+			return f != "" && !doc.FeatureEnabled(f)
+		})
+		features = slices.DeleteFunc(features, func(f string) bool { return !doc.FeatureEnabled(f) })
+	}
+	return &Capabilities{
+		MessageTypes:   types,
+		MaxMessageSize: maxMessageSize,
+		Features:       features,
+	}
+}
+
+// sendWelcome sends a newly registered client the hub's capabilities and
+// whether it may edit.
+func (h *Hub) sendWelcome(client *Client) {
+	h.mu.RLock()
+	caps := h.capabilities(h.documents[client.documentID])
+	h.mu.RUnlock()
+
+	msg := NewWelcomeMessage(client.documentID, caps)
+	msg.ReadOnly = client.readOnly || client.historical
+	data, err := msg.ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(client, data)
+}
@@ -0,0 +1,50 @@
+package hub
+
+import (
+	"log"
+
+	"collaborative-docs/internal/events"
+)
+
+// RestoreCheckpoint sets a document's content back to a checkpoint's. Like
+// ReplaceContent it makes new versions rather than discarding history, so
+// the edits since can still be read and restored, and connected clients
+// receive it as server-generated operations. It returns the resulting
+// version, or document.ErrCheckpointNotFound.
+func (h *Hub) RestoreCheckpoint(documentID, checkpointID string) (int, error) {
+	var version int
+	var err error
+	if !h.do(func() { version, err = h.restoreCheckpoint(documentID, checkpointID) }) {
+		return 0, ErrHubStopped
+	}
+	return version, err
+}
+
+func (h *Hub) restoreCheckpoint(documentID, checkpointID string) (int, error) {
+	if h.IsDeleted(documentID) {
+		return 0, ErrDocumentDeleted
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return 0, ErrDocumentNotFound
+	}
+	cp, err := doc.Checkpoint(checkpointID)
+	if err != nil {
+		return doc.GetVersion(), err
+	}
+	// On the hub goroutine no edit lands between reading the version and
+	// replacing the content.
+	version, err := h.replaceContent(documentID, cp.Content, doc.GetVersion())
+	if err != nil {
+		return version, err
+	}
+
+	log.Printf("document %s restored to checkpoint %s (%q, version %d), version: %d", documentID, cp.ID, cp.Label, cp.Version, version)
+	h.events.Emit(events.Event{
+		Type:       events.TypeCheckpointRestored,
+		DocumentID: documentID,
+		Version:    version,
+		Detail:     cp.ID,
+	})
+	return version, nil
+}
@@ -1,9 +1,15 @@
 package hub
 
 import (
+	"context"
+	"errors"
 	"log"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"collaborative-docs/internal/protocol"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -14,43 +20,183 @@ const (
 	maxMessageSize = 512 * 1024                 // Maximum message size (512KB)
 )
 
+// Causes a client's context is canceled with, besides ErrHubStopped and
+// the deadline or cancellation of the context it was given.
+var (
+	// ErrDisconnected is the cause once the client has left the hub.
+	ErrDisconnected = errors.New("client disconnected")
+	// ErrSlowClient is the cause when the client's send buffer filled up.
+	ErrSlowClient = errors.New("client too slow to receive")
+	// ErrAbusive is the cause when the abuse policy disconnected the client.
+	ErrAbusive = errors.New("client disconnected for abuse")
+)
+
+// lastClientID numbers clients so collaborators can tell them apart.
+var lastClientID atomic.Uint64
+
 // Client represents a WebSocket connection to a browser.
 // It runs two concurrent goroutines: ReadPump for incoming
 // messages and WritePump for outgoing messages.
 type Client struct {
 	hub        *Hub
-	conn       *websocket.Conn
-	send       chan []byte // Buffered channel for outbound messages
+	conn       Conn
+	ctx        context.Context // Done once the client must stop; see Context
+	cancel     context.CancelCauseFunc
+	codec      protocol.Codec // Frame encoding negotiated at upgrade
+	send       chan []byte    // Buffered channel for outbound messages
 	documentID string
+	id         string       // Unique per process, shown to collaborators
+	subject    string       // Authenticated principal, if any
+	name       string       // Display name from the identity provider, if any
+	tenant     string       // Tenant resolved at the handshake, if any
+	cadence    *cadence     // Set for coalesced delivery; guarded by hub.mu
+	room       *room        // Document's room while registered; guarded by hub.mu
+	sendClosed bool         // send is closed; only used by the room's goroutine
+	deltas     bool         // Takes content changes as operation batches; guarded by hub.mu
+	historical bool         // Read-only session on a past version
+	version    int          // Version a historical session shows
+	readOnly   bool         // Live session that may watch but not edit
+	fences     bool         // May change fenced text; see AllowFences
+	stats      clientStats  // Traffic and abuse score; only used handling its document's messages
+	outline    bool         // Receives outline changes; only used handling its document's messages
+	bucket     *tokenBucket // Rate limit state; only used from ReadPump
 }
 
 // NewClient creates a new Client instance.
-func NewClient(hub *Hub, conn *websocket.Conn, documentID string) *Client {
-	return &Client{
+// Any Conn works, typically a *websocket.Conn or, in tests, a *Pipe.
+func NewClient(hub *Hub, conn Conn, documentID string) *Client {
+	c := &Client{
 		hub:        hub,
 		conn:       conn,
+		codec:      protocol.JSON,
 		send:       make(chan []byte, 256),
 		documentID: documentID,
+		id:         newClientID(),
+	}
+	c.SetContext(context.Background())
+	return c
+}
+
+// NewLocalClient creates a client with no WebSocket connection, for
+// in-process peers such as test harnesses. The caller must drain Messages
+// promptly or the hub drops the client once buffer messages are pending.
+func NewLocalClient(hub *Hub, documentID string, buffer int) *Client {
+	c := &Client{
+		hub:        hub,
+		send:       make(chan []byte, buffer),
+		documentID: documentID,
+		id:         newClientID(),
 	}
+	c.SetContext(context.Background())
+	return c
+}
+
+func newClientID() string {
+	return strconv.FormatUint(lastClientID.Add(1), 10)
+}
+
+// SetContext makes the client's context a child of ctx, typically the
+// handshake request's, so the session ends when ctx is canceled or reaches
+// its deadline. It must be called before Register.
+func (c *Client) SetContext(ctx context.Context) {
+	c.ctx, c.cancel = context.WithCancelCause(ctx)
+}
+
+// Context returns a context that is done once the client must stop: it
+// left the hub, was kicked, the hub shut down, or the context given to
+// SetContext ended. context.Cause tells which, for example ErrSlowClient
+// or ErrHubStopped.
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// done is the client's Done channel, or nil, which never fires, for
+// messages without a client.
+func (c *Client) done() <-chan struct{} {
+	if c == nil || c.ctx == nil {
+		return nil
+	}
+	return c.ctx.Done()
+}
+
+// stop cancels the client's context with cause; the first cause given is
+// the one reported.
+func (c *Client) stop(cause error) {
+	if c.cancel != nil {
+		c.cancel(cause)
+	}
+}
+
+// SetCodec sets the subprotocol the client's frames are encoded in; it
+// must be called before the pumps start. Clients default to protocol.JSON.
+func (c *Client) SetCodec(codec protocol.Codec) {
+	c.codec = codec
+}
+
+// SetIdentity records who opened the connection and for which tenant, as
+// resolved during the handshake; it must be called before Register.
+func (c *Client) SetIdentity(subject, tenant string) {
+	c.subject, c.tenant = subject, tenant
+}
+
+// ID returns the client's identifier, as shown to collaborators.
+func (c *Client) ID() string {
+	return c.id
+}
+
+// authorID identifies the client's changes in document history, so its
+// later operations are not transformed past them. Changes without a client
+// have no author.
+func (c *Client) authorID() string {
+	if c == nil {
+		return ""
+	}
+	return c.id
+}
+
+// Messages returns the client's outbound messages. The channel is closed
+// when the client is unregistered.
+func (c *Client) Messages() <-chan []byte {
+	return c.send
 }
 
 // ReadPump reads messages from the WebSocket and forwards them to the hub.
-// It runs until the connection closes, then unregisters the client.
+// It runs until the connection closes or the client's context is done,
+// then unregisters the client.
 func (c *Client) ReadPump() {
+	if !c.hub.routines.addPump(c, RoutineReadPump) {
+		c.conn.Close() // the hub has shut down
+		return
+	}
+	defer c.hub.routines.donePump(c, RoutineReadPump)
 	defer func() {
+		// Once the context is done WritePump sends the close frame and
+		// closes the connection.
+		if c.ctx.Err() == nil {
+			c.conn.Close()
+		}
 		c.hub.Unregister(c)
-		c.conn.Close()
 	}()
 
 	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		if c.ctx.Err() == nil {
+			c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		}
 		return nil
 	})
 
+	// Expiring the read deadline wakes ReadMessage without closing the
+	// connection, which WritePump still needs for the close frame.
+	stop := context.AfterFunc(c.ctx, func() { c.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
 	for {
-		_, message, err := c.conn.ReadMessage()
+		_, frame, err := c.conn.ReadMessage()
+		if c.ctx.Err() != nil {
+			break
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("unexpected websocket close: %v", err)
@@ -58,14 +204,35 @@ func (c *Client) ReadPump() {
 			break
 		}
 
-		c.hub.Broadcast(message, c)
+		message, err := c.codec.Decode(frame)
+		if err != nil {
+			c.hub.do(func() { c.hub.noteParseError(c) })
+			continue
+		}
+		if drop, err := c.overLimit(message); err != nil {
+			c.stop(err) // WritePump tells the peer why
+			break
+		} else if drop {
+			continue
+		}
+		if err := c.hub.Broadcast(c.ctx, message, c); err != nil {
+			c.stop(err) // WritePump tells the peer why
+			break
+		}
 	}
 }
 
 // WritePump sends messages from the hub to the WebSocket.
-// It also sends periodic pings to detect disconnected clients.
+// It also sends periodic pings to detect disconnected clients. Once the
+// client's context is done it sends what is already queued, then a close
+// frame giving the cause, and closes the connection.
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(pingPeriod)
+	if !c.hub.routines.addPump(c, RoutineWritePump) {
+		c.conn.Close() // the hub has shut down
+		return
+	}
+	defer c.hub.routines.donePump(c, RoutineWritePump)
+	ticker := c.hub.clock.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
@@ -77,31 +244,129 @@ func (c *Client) WritePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 
 			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.WriteMessage(websocket.CloseMessage, closeMessage(context.Cause(c.ctx)))
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			if err := c.write(message); err != nil {
 				return
 			}
-			w.Write(message)
 
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
-			}
+		case <-c.ctx.Done():
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.drain()
+			c.conn.WriteMessage(websocket.CloseMessage, closeMessage(context.Cause(c.ctx)))
+			return
 
-			if err := w.Close(); err != nil {
+		case <-ticker.C():
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		}
+	}
+}
 
-		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+// drain writes the messages the hub sent before the client stopped, such
+// as the notice explaining a kick. A registered client's room may still be
+// delivering them, so drain removes the client and waits, for at most
+// writeWait, until the room closes the send channel after them.
+func (c *Client) drain() {
+	c.hub.mu.RLock()
+	registered := c.hub.clients[c]
+	c.hub.mu.RUnlock()
+	if !registered {
+		select {
+		case message, ok := <-c.send:
+			if ok {
+				c.write(message)
+			}
+		default:
+		}
+		return
+	}
+
+	c.hub.Unregister(c)
+	timeout := time.NewTimer(writeWait)
+	defer timeout.Stop()
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
 				return
 			}
+			if err := c.write(message); err != nil {
+				return
+			}
+		case <-timeout.C:
+			return
+		}
+	}
+}
+
+// closeMessage is the close frame for a client stopped by cause.
+func closeMessage(cause error) []byte {
+	switch {
+	case errors.Is(cause, ErrHubStopped):
+		return websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	case errors.Is(cause, context.DeadlineExceeded):
+		return websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session expired")
+	case errors.Is(cause, ErrAbusive), errors.Is(cause, ErrRateLimited):
+		return websocket.FormatCloseMessage(websocket.ClosePolicyViolation, cause.Error())
+	case errors.Is(cause, ErrSlowClient):
+		return websocket.FormatCloseMessage(websocket.CloseTryAgainLater, cause.Error())
+	}
+	return websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+}
+
+// write sends message and any others already queued. Text frames batch
+// them separated by newlines; binary frames carry one message each.
+// Messages the codec cannot encode are skipped. Frames are compressed as
+// the hub's CompressionPolicy says.
+func (c *Client) write(message []byte) error {
+	queued := make([][]byte, 0, 1+len(c.send))
+	queued = append(queued, message)
+	for n := len(c.send); n > 0; n-- {
+		queued = append(queued, <-c.send)
+	}
+
+	frameType := c.codec.FrameType()
+	if frameType != websocket.TextMessage {
+		for _, message := range queued {
+			frame, err := c.codec.Encode(message)
+			if err != nil {
+				log.Printf("skipping message for client %s: %v", c.id, err)
+				continue
+			}
+			c.compress(len(frame))
+			if err := c.conn.WriteMessage(frameType, frame); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	frames := make([][]byte, 0, len(queued))
+	size := 0
+	for _, message := range queued {
+		frame, err := c.codec.Encode(message)
+		if err != nil {
+			log.Printf("skipping message for client %s: %v", c.id, err)
+			continue
+		}
+		frames = append(frames, frame)
+		size += len(frame) + 1
+	}
+	c.compress(size)
+	w, err := c.conn.NextWriter(frameType)
+	if err != nil {
+		return err
+	}
+	for i, frame := range frames {
+		if i > 0 {
+			w.Write([]byte{'\n'})
 		}
+		w.Write(frame)
 	}
+	return w.Close()
 }
@@ -0,0 +1,128 @@
+package hub
+
+import (
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/operations"
+	"log"
+	"time"
+)
+
+// heldOperation is an applied operation waiting out the coalescing window.
+type heldOperation struct {
+	op      *operations.Operation
+	message []byte  // Sent as is if nothing merges with op
+	sender  *Client // Not sent op; nil for server and peer operations
+}
+
+// SetCoalesceWindow holds the text operations applied to a document for up
+// to d before relaying them, and composes each client's consecutive
+// operations with operations.Compose, so a fast typist's keystrokes reach
+// collaborators as a few operations rather than one message each. Senders
+// are acknowledged at once; held operations are sent ahead of any other
+// message to the document's clients, so none sees a version before the
+// operations leading to it. Zero, the default, relays each operation as
+// soon as it is applied. Call it before Run.
+func (h *Hub) SetCoalesceWindow(d time.Duration) {
+	h.window = d
+}
+
+// holdOperation queues an applied operation and, for the first one held,
+// schedules the flush that ends the window. Callers must hold h.mu.
+func (h *Hub) holdOperation(documentID string, op *operations.Operation, message []byte, sender *Client) {
+	h.heldMu.Lock()
+	first := len(h.held[documentID]) == 0
+	h.held[documentID] = append(h.held[documentID], heldOperation{op: op, message: message, sender: sender})
+	h.heldMu.Unlock()
+
+	if first {
+		ticker := h.clock.NewTicker(h.window)
+		if !h.spawn(RoutineWindowFlush, func() { h.awaitWindow(documentID, ticker) }) {
+			ticker.Stop()
+		}
+	}
+}
+
+// awaitWindow sends the document's held operations on the first tick. If
+// something sent them early, a later batch may go out before its window
+// ends, which only costs composing less.
+func (h *Hub) awaitWindow(documentID string, ticker clock.Ticker) {
+	defer ticker.Stop()
+
+	select {
+	case <-h.quit:
+	case <-ticker.C():
+		h.do(func() { h.flushHeld(documentID) })
+	}
+}
+
+// releaseHeld sends the document's held operations, composing each run
+// from one client. Callers must hold h.mu.
+func (h *Hub) releaseHeld(documentID string) {
+	h.heldMu.Lock()
+	held := h.held[documentID]
+	delete(h.held, documentID)
+	h.heldMu.Unlock()
+
+	for len(held) > 0 {
+		// Operations without a sending client, such as the server's and
+		// peers', may have different authors and are not composed.
+		n := 1
+		for held[0].sender != nil && n < len(held) && held[n].sender == held[0].sender {
+			n++
+		}
+		run := held[:n]
+		held = held[n:]
+
+		ops := make([]*operations.Operation, len(run))
+		for i, ho := range run {
+			ops[i] = ho.op
+		}
+		composed := operations.Compose(ops)
+		if len(composed) == len(run) {
+			// Nothing merged: send the messages as applied.
+			for _, ho := range run {
+				h.sendOperation(documentID, ho.op, ho.message, ho.sender)
+			}
+			continue
+		}
+		// Composed operations carry the trace of the latest one.
+		var traceID string
+		if last, err := MessageFromBytes(run[n-1].message); err == nil {
+			traceID = last.TraceID
+		}
+		for _, op := range composed {
+			msg := NewOperationMessage(op)
+			msg.DocumentID = documentID
+			msg.TraceID = traceID
+			msg.Author = run[0].sender.author()
+			data, err := msg.ToBytes()
+			if err != nil {
+				log.Printf("serialization failed: %v", err)
+				continue
+			}
+			h.sendOperation(documentID, op, data, run[0].sender)
+		}
+		log.Printf("composed %d operations into %d on document: %s", len(run), len(composed), documentID)
+	}
+}
+
+// heldFor reports whether operations the client has not seen are held for
+// its document. Callers must hold h.mu.
+func (h *Hub) heldFor(client *Client) bool {
+	h.heldMu.Lock()
+	defer h.heldMu.Unlock()
+	for _, ho := range h.held[client.documentID] {
+		if ho.sender != client {
+			return true
+		}
+	}
+	return false
+}
+
+// flushHeld sends the document's held operations, e.g. before a client
+// joins that must not receive operations its sync already includes.
+func (h *Hub) flushHeld(documentID string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.releaseHeld(documentID)
+}
@@ -0,0 +1,165 @@
+package hub
+
+import (
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// DefaultCompactionRetain is how many versions compaction keeps when the
+// policy does not say.
+const DefaultCompactionRetain = 100
+
+// CompactionPolicy says when the hub compacts a document's operation
+// history: it saves a full snapshot to the store, if the hub has one, and
+// then drops all but the newest Retain operations. Clients resyncing from
+// a version still retained are sent the operations they missed; older ones
+// get the full content.
+type CompactionPolicy struct {
+	Operations int           // Compact a document after this many new versions; zero disables
+	Interval   time.Duration // Compact every loaded document this often; zero disables
+	Retain     int           // Versions kept for clients catching up; zero uses DefaultCompactionRetain
+}
+
+// SetCompaction sets when document histories are compacted. Without one,
+// a document keeps its last 1000 versions. It must be called before Run.
+func (h *Hub) SetCompaction(p CompactionPolicy) {
+	if p.Retain <= 0 {
+		p.Retain = DefaultCompactionRetain
+	}
+	h.compaction = p
+}
+
+// compactEvery compacts every loaded document on each tick until the hub
+// shuts down.
+func (h *Hub) compactEvery(ticker clock.Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-ticker.C():
+			h.do(h.compactAll)
+		}
+	}
+}
+
+// compactAll compacts every loaded document changed since it was last
+// compacted. Callers must be on the Run goroutine.
+func (h *Hub) compactAll() {
+	h.mu.RLock()
+	docs := make(map[string]*document.Document, len(h.documents))
+	for id, doc := range h.documents {
+		docs[id] = doc
+	}
+	h.mu.RUnlock()
+
+	h.stateMu.Lock()
+	for id := range h.compactedAt {
+		if docs[id] == nil {
+			delete(h.compactedAt, id) // Deleted or unloaded
+		}
+	}
+	for id, doc := range docs {
+		if version, ok := h.compactedAt[id]; ok && version == doc.GetVersion() {
+			delete(docs, id)
+		}
+	}
+	h.stateMu.Unlock()
+	for id, doc := range docs {
+		h.compact(id, doc)
+	}
+}
+
+// compactIfDue compacts a document that reached version once it has moved
+// the policy's Operations past its last compaction. Callers must be on the
+// Run goroutine or in the document's room.
+func (h *Hub) compactIfDue(documentID string, version int) {
+	if h.compaction.Operations <= 0 {
+		return
+	}
+	h.stateMu.Lock()
+	due := version-h.compactedAt[documentID] >= h.compaction.Operations
+	h.stateMu.Unlock()
+	if !due {
+		return
+	}
+	if doc := h.GetDocument(documentID); doc != nil {
+		h.compact(documentID, doc)
+	}
+}
+
+// compact saves a snapshot of the document, then drops the history it
+// covers beyond what the policy retains. A document that cannot be saved
+// keeps its history. Callers must be on the Run goroutine or in the
+// document's room.
+func (h *Hub) compact(documentID string, doc *document.Document) {
+	version := doc.GetVersion()
+	h.stateMu.Lock()
+	h.compactedAt[documentID] = version
+	h.stateMu.Unlock()
+	if p := h.persistence; p != nil {
+		p.mu.Lock()
+		saved := h.save(documentID, doc)
+		p.mu.Unlock()
+		if !saved {
+			return
+		}
+	}
+
+	dropped := doc.TruncateHistory(h.compaction.Retain)
+	if dropped == 0 {
+		return
+	}
+	log.Printf("document %s compacted at version %d, dropped %d operations", documentID, version, dropped)
+	h.events.Emit(events.Event{
+		Type:       events.TypeDocumentCompacted,
+		DocumentID: documentID,
+		Version:    version,
+		Detail:     strconv.Itoa(dropped),
+	})
+}
+
+// ErrInvalidHistoryWindow is returned for a history window out of range.
+var ErrInvalidHistoryWindow = errors.New("invalid history window")
+
+// SetHistoryWindow bounds the operations a text document keeps behind its
+// latest version by count and age. Operations from clients written against
+// a version outside it are refused with ErrorResyncRequired, and the client
+// is sent the content instead; reconnecting clients that far behind resync
+// from the content too. Compaction may keep fewer operations than the
+// window allows. The zero window keeps the last 1000 versions.
+func (h *Hub) SetHistoryWindow(documentID string, w document.HistoryWindow) error {
+	if err := w.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidHistoryWindow, err)
+	}
+	var err error
+	if !h.do(func() { err = h.setHistoryWindow(documentID, w) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) setHistoryWindow(documentID string, w document.HistoryWindow) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+	if err := h.GetOrCreateDocument(documentID).SetHistoryWindow(w); err != nil {
+		return err
+	}
+	log.Printf("document %s history window set to %+v", documentID, w)
+	return nil
+}
+
+// HistoryWindow returns the window of operations a document keeps.
+func (h *Hub) HistoryWindow(documentID string) document.HistoryWindow {
+	if doc := h.GetDocument(documentID); doc != nil {
+		return doc.HistoryWindow()
+	}
+	return document.HistoryWindow{}
+}
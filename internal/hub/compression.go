@@ -0,0 +1,67 @@
+package hub
+
+import (
+	"compress/flate"
+	"fmt"
+)
+
+// CompressionPolicy sets which messages to clients are compressed with
+// permessage-deflate. Compressing costs CPU and a little latency, so it
+// pays off for large messages such as a document's full content on a slow
+// link, not for the small operations of typing.
+type CompressionPolicy struct {
+	Threshold int // Compress writes of at least this many bytes; zero disables
+	Level     int // flate level from 1, fastest, to 9, smallest; zero uses the default
+}
+
+// Validate checks the level is one flate accepts.
+func (p CompressionPolicy) Validate() error {
+	if p.Threshold < 0 {
+		return fmt.Errorf("compression threshold %d is negative", p.Threshold)
+	}
+	if p.Level != 0 && (p.Level < flate.BestSpeed || p.Level > flate.BestCompression) {
+		return fmt.Errorf("compression level %d out of range [%d, %d]", p.Level, flate.BestSpeed, flate.BestCompression)
+	}
+	return nil
+}
+
+// SetCompression sets which messages are compressed for clients whose
+// connection negotiated permessage-deflate; the server negotiates it when
+// the policy's threshold is set. Without one nothing is compressed. It
+// must be called before Run.
+func (h *Hub) SetCompression(p CompressionPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	h.compression = p
+	return nil
+}
+
+// Compression returns the policy set with SetCompression. The server
+// negotiates permessage-deflate only when it compresses anything.
+func (h *Hub) Compression() CompressionPolicy {
+	return h.compression
+}
+
+// compressor is implemented by connections that can compress what they
+// write, such as a *websocket.Conn that negotiated permessage-deflate.
+type compressor interface {
+	EnableWriteCompression(enable bool)
+	SetCompressionLevel(level int) error
+}
+
+// compress turns compression on for the client's next write if it is n
+// bytes or more and the hub's policy compresses that much, and off
+// otherwise. Connections that cannot compress are left as they are.
+func (c *Client) compress(n int) {
+	cc, ok := c.conn.(compressor)
+	if !ok {
+		return
+	}
+	p := c.hub.compression
+	on := p.Threshold > 0 && n >= p.Threshold
+	if on && p.Level != 0 {
+		cc.SetCompressionLevel(p.Level) // validated by SetCompression
+	}
+	cc.EnableWriteCompression(on)
+}
@@ -0,0 +1,116 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/operations"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ConflictKind says what concurrent edits did to an operation.
+type ConflictKind string
+
+const (
+	ConflictRejected  ConflictKind = "rejected"  // It could not be applied after concurrent edits
+	ConflictRedundant ConflictKind = "redundant" // Concurrent edits already made the change, e.g. deleted the same text
+	ConflictOverlap   ConflictKind = "overlap"   // Concurrent edits deleted part of the text it deleted or formatted
+)
+
+// ConflictReport tells an operation's sender why the document does not
+// show its edit where and as it was typed: who edited concurrently, what
+// became of the operation and where it ended up.
+type ConflictReport struct {
+	Kind      ConflictKind          `json:"kind"`
+	Operation *operations.Operation `json:"operation"`         // As sent
+	Applied   *operations.Operation `json:"applied,omitempty"` // As applied, if it was
+	Author    *Author               `json:"author,omitempty"`  // The sender
+	With      []Author              `json:"with,omitempty"`    // Who made the edits it was rebased past
+	Reason    string                `json:"reason,omitempty"`  // Why it was rejected
+}
+
+// String summarizes the report, as the event stream records it.
+func (r *ConflictReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", r.Kind, r.Operation)
+	if r.Author != nil {
+		fmt.Fprintf(&b, " by %s", r.Author.label())
+	}
+	if len(r.With) > 0 {
+		names := make([]string, len(r.With))
+		for i, a := range r.With {
+			names[i] = a.label()
+		}
+		fmt.Fprintf(&b, " concurrent with %s", strings.Join(names, ", "))
+	}
+	if r.Applied != nil {
+		fmt.Fprintf(&b, "; applied as %s", r.Applied)
+	}
+	if r.Reason != "" {
+		fmt.Fprintf(&b, "; %s", r.Reason)
+	}
+	return b.String()
+}
+
+// label names the author for a person reading a report.
+func (a Author) label() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.ClientID
+}
+
+// concurrentEdits returns who other than sender changed doc since version,
+// and whether version is older than the history kept, in which case who is
+// unknown. Authors still connected are named; others, such as peers on the
+// broker, are given by ID.
+func (h *Hub) concurrentEdits(documentID string, doc *document.Document, version int, sender *Client) ([]Author, bool) {
+	ids, err := doc.AuthorsSince(version, sender.authorID())
+	if errors.Is(err, document.ErrVersionUnavailable) {
+		return nil, true
+	}
+	if len(ids) == 0 {
+		return nil, false
+	}
+
+	h.mu.RLock()
+	byID := make(map[string]*Author)
+	for _, c := range h.roomClients(documentID, func(*Client) bool { return true }) {
+		byID[c.id] = c.author()
+	}
+	h.mu.RUnlock()
+	authors := make([]Author, len(ids))
+	for i, id := range ids {
+		if a := byID[id]; a != nil {
+			authors[i] = *a
+		} else {
+			authors[i] = Author{ClientID: id}
+		}
+	}
+	return authors, false
+}
+
+// reportConflict records report in the event stream and sends it to the
+// operation's sender, if a client sent it.
+func (h *Hub) reportConflict(sender *Client, documentID string, version int, report *ConflictReport, traceID string) {
+	log.Printf("conflict on document %s: %s (trace %s)", documentID, report, traceID)
+	h.events.Emit(events.Event{
+		Type:       events.TypeOperationConflict,
+		DocumentID: documentID,
+		Version:    version,
+		Operation:  report.Operation,
+		Detail:     report.String(),
+		TraceID:    traceID,
+	})
+	if sender == nil {
+		return
+	}
+	data, err := (&Message{Type: MsgTypeConflict, DocumentID: documentID, Conflict: report, TraceID: traceID}).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(sender, data)
+}
@@ -0,0 +1,19 @@
+package hub
+
+import (
+	"io"
+	"time"
+)
+
+// Conn is the subset of *websocket.Conn that Client uses, so clients can run
+// over an in-memory Pipe in tests.
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	NextWriter(messageType int) (io.WriteCloser, error)
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
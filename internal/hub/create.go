@@ -0,0 +1,56 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"errors"
+	"log"
+)
+
+// ErrDocumentExists is returned when creating a document whose ID is
+// already in use, by a live document or a recently deleted one.
+var ErrDocumentExists = errors.New("document already exists")
+
+// CreateDocument creates a text document holding content, failing with
+// ErrDocumentExists if the ID is taken. Unlike GetOrCreateDocument it never
+// hands back an existing document, so callers choosing fresh IDs can retry
+// on conflict. Content that breaks the schema for the ID returns a
+// *schema.ViolationError, and no document is created.
+func (h *Hub) CreateDocument(documentID, content string) error {
+	var err error
+	if !h.do(func() { err = h.createDocument(documentID, content) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) createDocument(documentID, content string) error {
+	if h.IsDeleted(documentID) || h.GetDocument(documentID) != nil {
+		return ErrDocumentExists
+	}
+	if content == "" {
+		h.GetOrCreateDocument(documentID)
+		return nil
+	}
+
+	// The content is checked before the document is shared, so a rejected
+	// body leaves the ID free.
+	doc := document.NewDocumentWithClock(h.clock)
+	h.mu.RLock()
+	s := h.schemaFor(documentID)
+	h.mu.RUnlock()
+	if s != nil {
+		doc.SetSchema(s)
+	}
+	ops, _, err := doc.ReplaceContent(0, content)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.documents[documentID] = doc
+	h.mu.Unlock()
+	log.Printf("created new document: %s", documentID)
+	h.events.Emit(events.Event{Type: events.TypeDocumentCreated, DocumentID: documentID})
+	return h.relayServerOperations(documentID, ops, nil, "")
+}
@@ -0,0 +1,160 @@
+package hub
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxCursorPosition = 1 << 30 // Largest position a cursor may report
+	maxCursorName     = 64      // Longest display name, in characters
+)
+
+// Cursor is a client's caret and selection, relayed to collaborators so
+// they can show where others are editing. Anchor is where the selection
+// started and Head where the caret is; they are equal when nothing is
+// selected. Positions count UTF-16 code units, like operation positions.
+// The hub fills in ClientID, and Name with the client's display name if
+// the client gives none.
+type Cursor struct {
+	ClientID string `json:"client_id,omitempty"`
+	Anchor   int    `json:"anchor"`
+	Head     int    `json:"head"`
+	Name     string `json:"name,omitempty"`  // Display name
+	Color    string `json:"color,omitempty"` // Display color as #rrggbb
+	Left     bool   `json:"left,omitempty"`  // The client disconnected; drop its cursor
+}
+
+// Validate checks the positions and display color, and that the name is
+// short and printable.
+func (c *Cursor) Validate() error {
+	if c.Anchor < 0 || c.Head < 0 || c.Anchor > maxCursorPosition || c.Head > maxCursorPosition {
+		return fmt.Errorf("cursor [%d, %d] out of range [0, %d]", c.Anchor, c.Head, maxCursorPosition)
+	}
+	if utf8.RuneCountInString(c.Name) > maxCursorName {
+		return fmt.Errorf("cursor name longer than %d characters", maxCursorName)
+	}
+	if strings.IndexFunc(c.Name, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		return fmt.Errorf("cursor name %q is not printable", c.Name)
+	}
+	if c.Color != "" && !validColor(c.Color) {
+		return fmt.Errorf("cursor color %q is not #rrggbb", c.Color)
+	}
+	return nil
+}
+
+// validColor reports whether s is a hex color of the form #rrggbb.
+func validColor(s string) bool {
+	if len(s) != 7 || s[0] != '#' {
+		return false
+	}
+	for _, r := range s[1:] {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// presence holds the cursors shared on one document, by client. It is
+// guarded by h.stateMu.
+type presence map[*Client]Cursor
+
+// handleCursor records a client's cursor and relays it to the other
+// clients sharing its view of the document.
+func (h *Hub) handleCursor(documentID string, msg *Message, sender *Client) {
+	if msg.Cursor == nil || sender == nil {
+		return
+	}
+	if err := msg.Cursor.Validate(); err != nil {
+		log.Printf("cursor rejected for document %s: %v", documentID, err)
+		return
+	}
+	h.noteActivity(sender)
+
+	cursor := *msg.Cursor
+	cursor.ClientID, cursor.Left = sender.id, false
+	if cursor.Name == "" {
+		cursor.Name = sender.DisplayName()
+	}
+	if cursor.Color == "" {
+		cursor.Color = h.preferredColor(sender)
+	}
+	h.stateMu.Lock()
+	p, ok := h.presence[documentID]
+	if !ok {
+		p = make(presence)
+		h.presence[documentID] = p
+	}
+	p[sender] = cursor
+	h.stateMu.Unlock()
+
+	data, err := NewCursorMessage(documentID, &cursor).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.broadcastToView(sender, data)
+}
+
+// sendCursors gives a newly registered client the cursors its
+// collaborators last shared.
+func (h *Hub) sendCursors(client *Client) {
+	h.stateMu.Lock()
+	var cursors []Cursor
+	for other, cursor := range h.presence[client.documentID] {
+		if other != client && other.sameView(client) {
+			cursors = append(cursors, cursor)
+		}
+	}
+	h.stateMu.Unlock()
+	for _, cursor := range cursors {
+		data, err := NewCursorMessage(client.documentID, &cursor).ToBytes()
+		if err != nil {
+			log.Printf("serialization failed: %v", err)
+			return
+		}
+		h.sendToClient(client, data)
+	}
+}
+
+// forgetCursor drops an unregistered client's cursor and tells its
+// collaborators to remove it.
+func (h *Hub) forgetCursor(client *Client) {
+	h.stateMu.Lock()
+	p := h.presence[client.documentID]
+	_, ok := p[client]
+	delete(p, client)
+	if len(p) == 0 {
+		delete(h.presence, client.documentID)
+	}
+	h.stateMu.Unlock()
+	if !ok {
+		return
+	}
+
+	data, err := NewCursorMessage(client.documentID, &Cursor{ClientID: client.id, Left: true}).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.broadcastToView(client, data)
+}
+
+// Cursors returns the cursors shared on a document, ordered by client ID.
+func (h *Hub) Cursors(documentID string) []Cursor {
+	var cursors []Cursor
+	h.do(func() {
+		h.stateMu.Lock()
+		defer h.stateMu.Unlock()
+		for _, cursor := range h.presence[documentID] {
+			cursors = append(cursors, cursor)
+		}
+	})
+	sort.Slice(cursors, func(i, j int) bool { return cursors[i].ClientID < cursors[j].ClientID })
+	return cursors
+}
@@ -0,0 +1,137 @@
+package hub
+
+import (
+	"collaborative-docs/internal/slo"
+	"sort"
+)
+
+// Traffic counts inbound client messages and their outcomes since the hub
+// started.
+type Traffic struct {
+	Messages     int `json:"messages"`
+	Rejected     int `json:"rejected"`     // Refused or failed edits
+	ParseErrors  int `json:"parse_errors"` // JSON messages that are not valid messages
+	Dropped      int `json:"dropped"`      // Messages discarded while throttled
+	Throttled    int `json:"throttled"`    // Times a client was throttled
+	Disconnected int `json:"disconnected"` // Clients disconnected for abuse
+}
+
+// ErrorRates are the fractions of inbound messages that went wrong.
+type ErrorRates struct {
+	Rejected    float64 `json:"rejected"`
+	ParseErrors float64 `json:"parse_errors"`
+	Dropped     float64 `json:"dropped"`
+}
+
+// StorageUsage summarizes what the hub holds.
+type StorageUsage struct {
+	Documents        int   `json:"documents"`
+	DocumentBytes    int   `json:"document_bytes"` // Estimated, as in Stats
+	Tombstones       int   `json:"tombstones"`
+	Attachments      int   `json:"attachments"`
+	AttachmentsBytes int64 `json:"attachments_bytes"` // As declared when uploads were requested
+}
+
+// DocumentActivity is how busy a loaded document is right now.
+type DocumentActivity struct {
+	ID                string  `json:"id"`
+	Clients           int     `json:"clients"`
+	MessagesPerSecond float64 `json:"messages_per_second"` // Summed over connected clients
+	Messages          int     `json:"messages"`            // From connected clients
+	Rejected          int     `json:"rejected"`
+	Version           int     `json:"version"`
+	MemoryBytes       int     `json:"memory_bytes"`
+	P99MS             float64 `json:"p99_ms"`
+}
+
+// Dashboard is everything an operations dashboard shows about the hub, in
+// one snapshot.
+type Dashboard struct {
+	Clients      int                `json:"clients"`
+	Paused       int                `json:"paused_documents"`
+	Latency      slo.Snapshot       `json:"latency"`
+	Traffic      Traffic            `json:"traffic"`
+	ErrorRates   ErrorRates         `json:"error_rates"`
+	Storage      StorageUsage       `json:"storage"`
+	TopDocuments []DocumentActivity `json:"top_documents"` // Busiest first
+}
+
+// Dashboard returns the hub's statistics, traffic, error rates and storage
+// usage, with the top busiest documents. Documents are ranked by their
+// clients' current message rate, then by messages received.
+func (h *Hub) Dashboard(top int) (Dashboard, error) {
+	var d Dashboard
+	if !h.do(func() { d = h.dashboard(top) }) {
+		return Dashboard{}, ErrHubStopped
+	}
+	return d, nil
+}
+
+func (h *Hub) dashboard(top int) Dashboard {
+	stats := h.Stats()
+	latency := h.latency.Report()
+	h.stateMu.Lock()
+	paused, traffic := len(h.paused), h.traffic
+	h.stateMu.Unlock()
+	d := Dashboard{
+		Clients: stats.Clients,
+		Paused:  paused,
+		Latency: latency.Overall,
+		Traffic: traffic,
+		Storage: StorageUsage{
+			Documents:     len(stats.Documents),
+			DocumentBytes: stats.TotalMemoryBytes,
+		},
+		TopDocuments: []DocumentActivity{},
+	}
+	if n := float64(traffic.Messages); n > 0 {
+		d.ErrorRates = ErrorRates{
+			Rejected:    float64(traffic.Rejected) / n,
+			ParseErrors: float64(traffic.ParseErrors) / n,
+			Dropped:     float64(traffic.Dropped) / n,
+		}
+	}
+	h.mu.RLock()
+	d.Storage.Tombstones = len(h.tombstones)
+	h.mu.RUnlock()
+	if h.attachments != nil {
+		d.Storage.Attachments, d.Storage.AttachmentsBytes = h.attachments.Usage()
+	}
+
+	activity := make(map[string]*DocumentActivity, len(stats.Documents))
+	for _, ds := range stats.Documents {
+		activity[ds.ID] = &DocumentActivity{
+			ID:          ds.ID,
+			Clients:     ds.Clients,
+			Version:     ds.Version,
+			MemoryBytes: ds.MemoryBytes,
+			P99MS:       latency.Documents[ds.ID].P99MS,
+		}
+	}
+	for _, c := range h.clientInfos() {
+		if a, ok := activity[c.DocumentID]; ok {
+			a.MessagesPerSecond += c.MessagesPerSecond
+			a.Messages += c.Messages
+			a.Rejected += c.Rejected
+		}
+	}
+	for _, a := range activity {
+		d.TopDocuments = append(d.TopDocuments, *a)
+	}
+	sort.Slice(d.TopDocuments, func(i, j int) bool {
+		a, b := d.TopDocuments[i], d.TopDocuments[j]
+		switch {
+		case a.MessagesPerSecond != b.MessagesPerSecond:
+			return a.MessagesPerSecond > b.MessagesPerSecond
+		case a.Messages != b.Messages:
+			return a.Messages > b.Messages
+		case a.Clients != b.Clients:
+			return a.Clients > b.Clients
+		}
+		return a.ID < b.ID
+	})
+	if top >= 0 && len(d.TopDocuments) > top {
+		d.TopDocuments = d.TopDocuments[:top]
+	}
+	return d
+}
@@ -0,0 +1,141 @@
+package hub
+
+import (
+	"collaborative-docs/internal/events"
+	"fmt"
+	"log"
+	"time"
+)
+
+// tombstoneTTL is how long a deleted document ID keeps rejecting edits.
+const tombstoneTTL = 24 * time.Hour
+
+// Reasons reported in document_deleted messages and events.
+const (
+	DeleteReasonDeleted  = "deleted"  // Removed through the API
+	DeleteReasonEvicted  = "evicted"  // Dropped from memory under pressure
+	DeleteReasonUnloaded = "unloaded" // Saved to the store and dropped from memory under pressure
+)
+
+// tombstone remembers a deleted document so late edits are rejected
+// instead of silently recreating an empty document.
+type tombstone struct {
+	reason    string
+	deletedAt time.Time
+	archive   bool   // clients may keep a read-only view
+	content   string // archived content, when archive is set
+}
+
+// DeleteDocument removes a document. Connected clients receive a terminal
+// document_deleted message; with archive set they stay connected to a
+// read-only copy of the final content, otherwise they are disconnected.
+// Further edits to the ID are rejected until RestoreDocument is called.
+// A document already deleted returns ErrDocumentDeleted, and one that
+// never existed ErrDocumentNotFound.
+func (h *Hub) DeleteDocument(documentID string, archive bool) error {
+	h.GetDocument(documentID) // load a stored document so it can be buried
+	h.mu.Lock()
+	doc, ok := h.documents[documentID]
+	if !ok {
+		_, deleted := h.tombstones[documentID]
+		h.mu.Unlock()
+		if deleted {
+			return fmt.Errorf("%w: %s", ErrDocumentDeleted, documentID)
+		}
+		return fmt.Errorf("%w: %s", ErrDocumentNotFound, documentID)
+	}
+	content, version := doc.GetContentAndVersion()
+	ts := &tombstone{reason: DeleteReasonDeleted, deletedAt: h.clock.Now(), archive: archive}
+	if archive {
+		ts.content = content
+	}
+	h.bury(documentID, ts)
+	embedders := h.embeddersOf(documentID)
+	h.mu.Unlock()
+	h.forgetStored(documentID)
+
+	log.Printf("document %s deleted (archive: %v)", documentID, archive)
+	h.notifyDeleted(documentID, ts)
+	h.notifyEmbedders(embedders, &EmbedChange{DocumentID: documentID})
+	h.events.Emit(events.Event{
+		Type:       events.TypeDocumentDeleted,
+		DocumentID: documentID,
+		Version:    version,
+		Detail:     DeleteReasonDeleted,
+	})
+	return nil
+}
+
+// RestoreDocument clears a deleted document's tombstone so the ID can be
+// used again, starting from an empty document.
+func (h *Hub) RestoreDocument(documentID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.tombstones, documentID)
+}
+
+// IsDeleted reports whether documentID was deleted and not restored.
+func (h *Hub) IsDeleted(documentID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.tombstones[documentID]
+	return ok
+}
+
+// bury removes the document and records its tombstone, pruning expired
+// ones. Callers must hold h.mu.
+func (h *Hub) bury(documentID string, ts *tombstone) {
+	delete(h.documents, documentID)
+	delete(h.embeds, documentID)
+	delete(h.outlines, documentID)
+	delete(h.diagnostics, documentID)
+	for id, old := range h.tombstones {
+		if ts.deletedAt.Sub(old.deletedAt) > tombstoneTTL {
+			delete(h.tombstones, id)
+		}
+	}
+	h.tombstones[documentID] = ts
+}
+
+// tombstoneFor returns the document's tombstone, or nil if it is live.
+func (h *Hub) tombstoneFor(documentID string) *tombstone {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.tombstones[documentID]
+}
+
+// notifyDeleted sends the terminal message to every client on the document.
+func (h *Hub) notifyDeleted(documentID string, ts *tombstone) {
+	h.mu.RLock()
+	clients := h.roomClients(documentID, func(*Client) bool { return true })
+	h.mu.RUnlock()
+
+	for _, c := range clients {
+		h.notifyClientDeleted(c, ts)
+	}
+}
+
+// notifyClientDeleted sends the terminal message to one client and, unless
+// the document is archived, disconnects it.
+func (h *Hub) notifyClientDeleted(c *Client, ts *tombstone) {
+	data, err := newDeletedMessage(c.documentID, ts).ToBytes()
+	if err != nil {
+		log.Printf("deleted message creation failed: %v", err)
+		return
+	}
+	h.sendToClient(c, data)
+	if !ts.archive {
+		h.kick(c, ErrDocumentDeleted)
+	}
+}
+
+// newDeletedMessage builds the document_deleted message for a tombstone.
+func newDeletedMessage(documentID string, ts *tombstone) *Message {
+	return &Message{
+		Type:       MsgTypeDocumentDeleted,
+		DocumentID: documentID,
+		Content:    ts.content,
+		Reason:     ts.reason,
+		ReadOnly:   ts.archive,
+	}
+}
@@ -0,0 +1,79 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+	"log"
+)
+
+// broadcastContent sends the document's clients a content change, such as
+// a legacy client's content message or a renormalization, that produced
+// msg.Version. Live clients hold the version before it, so those that
+// asked for content deltas in their sync mode receive the change as the
+// operation_batch that turns that version into this one, queued with
+// their other operations if they are coalesced. The others, and every
+// client when the operations are no longer retained or take no fewer
+// bytes than the content, receive msg with the full content.
+func (h *Hub) broadcastContent(documentID string, doc *document.Document, msg *Message, exclude *Client) {
+	full, err := msg.ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	ops, delta := contentDelta(documentID, doc, msg, len(full))
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	full = h.stamp(full, documentID)
+	if delta != nil {
+		delta = h.stamp(delta, documentID)
+	}
+	h.releaseHeld(documentID)
+
+	var fulls, deltas []*Client
+	queued := 0
+	for _, client := range h.roomClients(documentID, func(c *Client) bool { return c != exclude && !c.historical }) {
+		switch {
+		case delta == nil || !client.deltas:
+			fulls = append(fulls, client)
+		case client.cadence != nil:
+			for _, op := range ops {
+				h.queueOperation(client, client.cadence, op)
+			}
+			queued++
+			continue
+		default:
+			deltas = append(deltas, client)
+		}
+		h.sendPendingFirst(client)
+	}
+	r := h.rooms[documentID]
+	h.deliver(r, full, fulls...)
+	h.deliver(r, delta, deltas...)
+
+	log.Printf("broadcasted content to %d clients on document %s, %d as deltas", len(fulls)+len(deltas)+queued, documentID, len(deltas)+queued)
+}
+
+// contentDelta returns the operations that made msg.Version from the
+// version before it and the operation_batch carrying them, or nil if they
+// are not retained or the batch is not smaller than size bytes.
+func contentDelta(documentID string, doc *document.Document, msg *Message, size int) ([]*operations.Operation, []byte) {
+	ops, err := doc.OperationsSince(msg.Version - 1)
+	if err != nil || len(ops) == 0 {
+		return nil, nil
+	}
+	for _, op := range ops {
+		if op.Version != msg.Version {
+			return nil, nil // the document has moved on
+		}
+	}
+	batch := NewOperationBatchMessage(documentID, ops)
+	batch.Version = msg.Version
+	batch.TraceID = msg.TraceID
+	data, err := batch.ToBytes()
+	if err != nil || len(data) >= size {
+		return nil, nil
+	}
+	return ops, data
+}
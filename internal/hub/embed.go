@@ -0,0 +1,83 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/embed"
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/operations"
+	"log"
+	"slices"
+	"sort"
+)
+
+// EmbedChange names an embedded document that changed.
+type EmbedChange struct {
+	DocumentID string `json:"document_id"`
+	Version    int    `json:"version"`
+}
+
+// contentChanged is called after each edit. It refreshes the document's
+// outline, from the lines change names when the edit was a single
+// operation, and its diagnostics, compacts its history when due, records
+// which documents it embeds, and tells every document embedding it,
+// directly or through other embeds, that their rendered content changed.
+func (h *Hub) contentChanged(documentID string, version int, change *operations.LineChange) {
+	h.updateOutline(documentID, change)
+	h.validate(documentID)
+	h.compactIfDue(documentID, version)
+
+	var embeds []string
+	if doc := h.GetDocument(documentID); doc != nil && doc.GetKind() == document.KindText {
+		embeds = embed.Documents(doc.GetContent())
+	}
+
+	h.mu.Lock()
+	if len(embeds) == 0 {
+		delete(h.embeds, documentID)
+	} else {
+		h.embeds[documentID] = embeds
+	}
+	embedders := h.embeddersOf(documentID)
+	h.mu.Unlock()
+
+	h.notifyEmbedders(embedders, &EmbedChange{DocumentID: documentID, Version: version})
+}
+
+// embeddersOf returns every document whose rendering includes documentID,
+// directly or through other embeds. Callers must hold h.mu.
+func (h *Hub) embeddersOf(documentID string) []string {
+	var embedders []string
+	seen := map[string]bool{documentID: true}
+	queue := []string{documentID}
+	for len(queue) > 0 {
+		embedded := queue[0]
+		queue = queue[1:]
+		for embedder, embeds := range h.embeds {
+			if !seen[embedder] && slices.Contains(embeds, embedded) {
+				seen[embedder] = true
+				embedders = append(embedders, embedder)
+				queue = append(queue, embedder)
+			}
+		}
+	}
+	sort.Strings(embedders)
+	return embedders
+}
+
+// notifyEmbedders sends embed_changed to the clients of each embedder and
+// emits an embed_changed event for it.
+func (h *Hub) notifyEmbedders(embedders []string, change *EmbedChange) {
+	for _, embedder := range embedders {
+		log.Printf("document %s embeds changed document %s", embedder, change.DocumentID)
+		h.events.Emit(events.Event{
+			Type:       events.TypeEmbedChanged,
+			DocumentID: embedder,
+			Version:    change.Version,
+			Detail:     change.DocumentID,
+		})
+		msg := &Message{Type: MsgTypeEmbedChanged, DocumentID: embedder, Embed: change}
+		if data, err := msg.ToBytes(); err == nil {
+			h.broadcastToDocument(embedder, data, nil)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultEphemeralTTL = 5 * time.Second  // Lifetime of an ephemeral message that names none
+	maxEphemeralTTL     = 60 * time.Second // Longest lifetime a client may request
+)
+
+// relayEphemeral forwards a message flagged ephemeral, such as a typing
+// indicator or reaction, to the document's other clients. Ephemeral
+// messages never touch document state: they do not create the document,
+// bump its version or reach the event stream, so sinks that persist or
+// audit events never see them. Messages that waited past their TTL before
+// the hub got to them are dropped, and relayed copies carry expires_at so
+// receivers can drop them too.
+func (h *Hub) relayEphemeral(documentID string, msg *Message, bm *broadcastMessage) {
+	if msg.Type.isEdit() {
+		log.Printf("rejecting ephemeral %s for document %s: edits cannot be ephemeral", msg.Type, documentID)
+		return
+	}
+
+	ttl := time.Duration(msg.TTLMS) * time.Millisecond
+	if ttl <= 0 {
+		ttl = defaultEphemeralTTL
+	}
+	ttl = min(ttl, maxEphemeralTTL)
+
+	received := bm.received
+	if received.IsZero() {
+		received = h.clock.Now()
+	}
+	expires := received.Add(ttl)
+	if !h.clock.Now().Before(expires) {
+		log.Printf("dropping stale ephemeral %s for document %s", msg.Type, documentID)
+		return
+	}
+
+	data, err := stampExpiry(bm.message, ttl, expires)
+	if err != nil {
+		log.Printf("ephemeral %s for document %s rejected: %v", msg.Type, documentID, err)
+		return
+	}
+	if bm.sender != nil && bm.sender.historical {
+		h.broadcastToView(bm.sender, data)
+		return
+	}
+	h.broadcastToDocument(documentID, data, bm.sender)
+}
+
+// stampExpiry sets ttl_ms and expires_at on a raw message, keeping any
+// fields the hub does not know about, such as a custom typing payload.
+func stampExpiry(raw []byte, ttl time.Duration, expires time.Time) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	fields["ttl_ms"] = json.RawMessage(strconv.FormatInt(ttl.Milliseconds(), 10))
+	fields["expires_at"] = json.RawMessage(strconv.FormatInt(expires.UnixMilli(), 10))
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return data, nil
+}
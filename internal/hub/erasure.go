@@ -0,0 +1,74 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErasureReport summarizes an EraseUser run.
+type ErasureReport struct {
+	UserID       string   `json:"user_id"`
+	Anonymized   bool     `json:"anonymized"`    // Values were replaced rather than removed
+	Documents    []string `json:"documents"`     // Documents that held the user's data, sorted
+	MetadataKeys int      `json:"metadata_keys"` // Metadata entries changed
+	Preferences  int      `json:"preferences"`   // Documents the user's preferences were removed from
+	Access       int      `json:"access"`        // Documents the user's role or ownership was removed from
+	Teams        []string `json:"teams"`         // Teams the user was removed from, sorted
+}
+
+// EraseUser removes a user ID from every document, for data erasure
+// requests. Metadata values equal to userID are replaced with replacement,
+// or removed when replacement is empty; watchers and connected clients are
+// notified as for any metadata change. The user's stored preferences,
+// roles, ownership and team memberships are deleted. The server records no authorship,
+// comments or audit trail by user, so these are the only places a user ID
+// is stored.
+func (h *Hub) EraseUser(userID, replacement string) (*ErasureReport, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("user ID is required")
+	}
+	report := &ErasureReport{UserID: userID, Anonymized: replacement != "", Documents: []string{}, Teams: []string{}}
+	for _, t := range h.Teams() {
+		if _, ok := t.Members[userID]; !ok {
+			continue
+		}
+		if err := h.SetTeamMembers(t.ID, map[string]document.Role{userID: ""}); err != nil && !errors.Is(err, ErrTeamNotFound) {
+			return report, fmt.Errorf("failed to erase user from team %s: %w", t.ID, err)
+		}
+		report.Teams = append(report.Teams, t.ID)
+	}
+	for _, documentID := range h.DocumentIDs() {
+		doc := h.GetDocument(documentID)
+		if doc == nil || h.IsDeleted(documentID) {
+			continue
+		}
+		changes := make(map[string]string)
+		for key, value := range doc.Metadata() {
+			if value == userID {
+				changes[key] = replacement
+			}
+		}
+		if len(changes) > 0 {
+			if err := h.setMetadata(doc, documentID, changes, nil); err != nil {
+				return report, fmt.Errorf("failed to erase user from document %s: %w", documentID, err)
+			}
+			report.MetadataKeys += len(changes)
+		}
+		hadPreferences := doc.DeletePreferences(userID)
+		if hadPreferences {
+			report.Preferences++
+		}
+		hadAccess := doc.RevokeAccess(userID)
+		if hadAccess {
+			report.Access++
+		}
+		if len(changes) > 0 || hadPreferences || hadAccess {
+			report.Documents = append(report.Documents, documentID)
+		}
+	}
+
+	log.Printf("user erased from %d documents (%d metadata keys, %d preferences, %d access entries) and %d teams", len(report.Documents), report.MetadataKeys, report.Preferences, report.Access, len(report.Teams))
+	return report, nil
+}
@@ -0,0 +1,171 @@
+package hub
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Faults describes network misbehavior to inject between the hub and a
+// client, to test that acknowledgements, resubmission and resync recover
+// from a bad network. Each rate is the probability, from 0 to 1, that a
+// message suffers the fault; the zero value injects nothing.
+type Faults struct {
+	Drop      float64       // The message is lost
+	Duplicate float64       // The message is delivered twice
+	Reorder   float64       // The message is held back and delivered after the next one
+	Delay     float64       // The message waits up to MaxDelay, holding back those after it
+	MaxDelay  time.Duration // Longest delay; zero means 50ms
+	Seed      uint64        // Seeds the choice of messages, so a failure can be replayed
+}
+
+// faultyConn is a Conn that injects Faults into the data messages passing
+// in both directions. Control frames, such as close, pass untouched.
+type faultyConn struct {
+	Conn
+	faults Faults
+
+	mu  sync.Mutex // Guards rng, used by both pumps
+	rng *rand.Rand
+
+	inbound []frame // Messages due to be read before the connection's next
+	held    *frame  // Message read early, delivered after the next one
+
+	writeMu  sync.Mutex // Orders writes with the held outbound message
+	outbound *frame     // Message written early, sent after the next one
+}
+
+// frame is one data message.
+type frame struct {
+	messageType int
+	data        []byte
+}
+
+// NewFaultyConn wraps conn so the messages the client reads and writes
+// suffer faults. It is meant for tests; frames a client batches into one
+// write suffer a fault together.
+func NewFaultyConn(conn Conn, faults Faults) Conn {
+	if faults.MaxDelay <= 0 {
+		faults.MaxDelay = 50 * time.Millisecond
+	}
+	return &faultyConn{
+		Conn:   conn,
+		faults: faults,
+		rng:    rand.New(rand.NewPCG(faults.Seed, faults.Seed)),
+	}
+}
+
+// roll reports whether a fault with the given rate happens.
+func (c *faultyConn) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Float64() < rate
+}
+
+// delay sleeps for a random time up to MaxDelay, if a delay is rolled.
+func (c *faultyConn) delay() {
+	if !c.roll(c.faults.Delay) {
+		return
+	}
+	c.mu.Lock()
+	d := time.Duration(c.rng.Int64N(int64(c.faults.MaxDelay)) + 1)
+	c.mu.Unlock()
+	time.Sleep(d)
+}
+
+// ReadMessage returns the next message from the client after faults.
+func (c *faultyConn) ReadMessage() (int, []byte, error) {
+	for {
+		if len(c.inbound) > 0 {
+			f := c.inbound[0]
+			c.inbound = c.inbound[1:]
+			return f.messageType, f.data, nil
+		}
+		messageType, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			if c.held != nil {
+				// Deliver what was held back; the error comes again on
+				// the next read.
+				held := c.held
+				c.held = nil
+				return held.messageType, held.data, nil
+			}
+			return messageType, data, err
+		}
+		switch {
+		case c.roll(c.faults.Drop):
+			continue
+		case c.held == nil && c.roll(c.faults.Reorder):
+			c.held = &frame{messageType, data}
+			continue
+		}
+		c.delay()
+		if c.roll(c.faults.Duplicate) {
+			c.inbound = append(c.inbound, frame{messageType, data})
+		}
+		if c.held != nil {
+			c.inbound = append(c.inbound, *c.held)
+			c.held = nil
+		}
+		return messageType, data, nil
+	}
+}
+
+// WriteMessage sends a message to the client after faults.
+func (c *faultyConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != websocket.TextMessage && messageType != websocket.BinaryMessage {
+		return c.Conn.WriteMessage(messageType, data)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	switch {
+	case c.roll(c.faults.Drop):
+		return nil
+	case c.outbound == nil && c.roll(c.faults.Reorder):
+		c.outbound = &frame{messageType, data}
+		return nil
+	}
+	c.delay()
+	if err := c.Conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	if c.roll(c.faults.Duplicate) {
+		if err := c.Conn.WriteMessage(messageType, data); err != nil {
+			return err
+		}
+	}
+	if c.outbound != nil {
+		held := c.outbound
+		c.outbound = nil
+		return c.Conn.WriteMessage(held.messageType, held.data)
+	}
+	return nil
+}
+
+// NextWriter buffers a message and writes it with WriteMessage on Close, so
+// batched frames suffer faults too.
+func (c *faultyConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &faultyWriter{conn: c, messageType: messageType}, nil
+}
+
+// faultyWriter is a message being written through a faultyConn.
+type faultyWriter struct {
+	conn        *faultyConn
+	messageType int
+	buf         bytes.Buffer
+}
+
+func (w *faultyWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *faultyWriter) Close() error {
+	return w.conn.WriteMessage(w.messageType, w.buf.Bytes())
+}
@@ -0,0 +1,121 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrUnknownFeature is returned when setting a feature that cannot be
+// toggled per document.
+var ErrUnknownFeature = errors.New("unknown feature")
+
+// documentFeatures are the features a document can disable.
+var documentFeatures = []string{
+	FeatureAttachments,
+	FeatureBlobs,
+	FeatureMetadata,
+	FeaturePresence,
+	FeatureSyncModes,
+	FeatureEphemeral,
+}
+
+// feature returns the optional feature a message needs, or "" if it needs
+// none.
+func (m *Message) feature() string {
+	if m.Ephemeral {
+		return FeatureEphemeral
+	}
+	switch m.Type {
+	case MsgTypeAttachmentRequest:
+		return FeatureAttachments
+	case MsgTypeBlob, MsgTypeBlobRequest:
+		return FeatureBlobs
+	case MsgTypeMetadataSet, MsgTypeMetadataGet:
+		return FeatureMetadata
+	case MsgTypeViewport, MsgTypeCursor, MsgTypePresenceRequest:
+		return FeaturePresence
+	case MsgTypeSyncMode:
+		return FeatureSyncModes
+	}
+	return ""
+}
+
+// featureDisabled reports whether msg needs a feature its document has
+// disabled. A document that does not exist yet has every feature enabled.
+func (h *Hub) featureDisabled(documentID string, msg *Message) bool {
+	f := msg.feature()
+	if f == "" {
+		return false
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil || doc.FeatureEnabled(f) {
+		return false
+	}
+	log.Printf("refusing %s for document %s: %s disabled", msg.Type, documentID, f)
+	return true
+}
+
+// SetDocumentFeatures enables or disables optional features for one
+// document, such as turning off presence for a large broadcast document.
+// Messages needing a disabled feature are dropped, and connected clients
+// receive the document's updated capabilities.
+func (h *Hub) SetDocumentFeatures(documentID string, features map[string]bool) error {
+	for name := range features {
+		if !isDocumentFeature(name) {
+			return fmt.Errorf("%w: %s", ErrUnknownFeature, name)
+		}
+	}
+	var err error
+	if !h.do(func() { err = h.setDocumentFeatures(documentID, features) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) setDocumentFeatures(documentID string, features map[string]bool) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+
+	doc := h.GetOrCreateDocument(documentID)
+	changed := false
+	for name, enabled := range features {
+		if doc.SetFeature(name, enabled) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	log.Printf("document %s features updated, disabled: %v", documentID, doc.DisabledFeatures())
+	h.mu.RLock()
+	caps := h.capabilities(doc)
+	h.mu.RUnlock()
+	data, err := NewCapabilitiesMessage(documentID, caps).ToBytes()
+	if err != nil {
+		return err
+	}
+	h.broadcastToDocument(documentID, data, nil)
+	return nil
+}
+
+// DocumentFeatures returns whether each per-document feature is enabled.
+func (h *Hub) DocumentFeatures(documentID string) map[string]bool {
+	doc := h.GetDocument(documentID)
+	features := make(map[string]bool, len(documentFeatures))
+	for _, name := range documentFeatures {
+		features[name] = doc == nil || doc.FeatureEnabled(name)
+	}
+	return features
+}
+
+func isDocumentFeature(name string) bool {
+	for _, f := range documentFeatures {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
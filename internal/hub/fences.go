@@ -0,0 +1,89 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"fmt"
+	"log"
+)
+
+// AllowFences lets the client change fenced text, as the document's owner
+// and maintainers may; see document.Fence. It must be called before
+// Register.
+func (c *Client) AllowFences() {
+	c.fences = true
+}
+
+// mayEditFences reports whether the client may change fenced text. Edits
+// without a client come from the server and may.
+func (c *Client) mayEditFences() bool {
+	return c == nil || c.fences
+}
+
+// SetFences replaces a text document's fenced ranges with fences, whose
+// positions are in the content of version, creating the document at
+// version 0. A stale version returns a *document.VersionConflictError.
+// Connected clients receive a fences message, and newly joining ones one
+// after their sync; they move the fences with later operations as the
+// document does.
+func (h *Hub) SetFences(documentID string, version int, fences document.Fences) error {
+	var err error
+	if !h.do(func() { err = h.setFences(documentID, version, fences) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) setFences(documentID string, version int, fences document.Fences) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+	doc := h.GetOrCreateDocument(documentID)
+	if err := doc.SetFences(version, fences); err != nil {
+		return err
+	}
+
+	log.Printf("document %s fences set at version %d: %d", documentID, version, len(fences))
+	if data, err := newFencesMessage(documentID, doc).ToBytes(); err == nil {
+		h.broadcastToDocument(documentID, data, nil)
+	}
+	h.events.Emit(events.Event{
+		Type:       events.TypeFencesChanged,
+		DocumentID: documentID,
+		Version:    version,
+	})
+	return nil
+}
+
+// sendFences sends a newly joined client its document's fences, if it has
+// any.
+func (h *Hub) sendFences(client *Client) {
+	doc := h.GetDocument(client.documentID)
+	if doc == nil || !doc.HasFences() {
+		return
+	}
+	if data, err := newFencesMessage(client.documentID, doc).ToBytes(); err == nil {
+		h.sendToClient(client, data)
+	}
+}
+
+// newFencesMessage builds the fences message for a document's current
+// fences.
+func newFencesMessage(documentID string, doc *document.Document) *Message {
+	fences, version := doc.Fences()
+	return &Message{Type: MsgTypeFences, DocumentID: documentID, Fences: fences, Version: version}
+}
+
+// checkFencedContent refuses content replacing a document's content if
+// the sender may not change the fenced text it would change.
+func checkFencedContent(doc *document.Document, sender *Client, content string) error {
+	if sender.mayEditFences() {
+		return nil
+	}
+	return doc.CheckFencedContent(content)
+}
+
+// errBlocksFenced is the reason a block operation on a document with
+// fences is refused to a client that may not edit them: blocks are
+// rewritten whole, so one cannot be checked against the fences.
+var errBlocksFenced = fmt.Errorf("%w: block operations on a document with fences", document.ErrFenced)
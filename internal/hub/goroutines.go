@@ -0,0 +1,182 @@
+package hub
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Kinds of goroutine the hub tracks, as reported by GoroutineReport.
+const (
+	RoutineRun           = "run"            // Hub.Run
+	RoutineReadPump      = "read_pump"      // Client.ReadPump
+	RoutineWritePump     = "write_pump"     // Client.WritePump
+	RoutinePersist       = "persist"        // Saves changed documents to the store
+	RoutineCadenceFlush  = "cadence_flush"  // Sends a coalesced client's batch
+	RoutineWindowFlush   = "window_flush"   // Sends a document's held operations
+	RoutineViewportFlush = "viewport_flush" // Relays a rate-limited viewport
+	RoutineKick          = "kick"           // Unregisters a kicked client
+	RoutineScheduler     = "scheduler"      // Runs scheduled actions when due
+	RoutineCompact       = "compact"        // Compacts document histories on an interval
+	RoutineMux           = "mux"            // Mux.Run and its pings
+	RoutineReap          = "reap"           // Evicts documents idle past the TTL
+	RoutineRoom          = "room"           // Delivers one document's messages to its clients
+	RoutineApply         = "apply"          // Applies the messages for one document
+)
+
+// routines tracks the hub's goroutines so Shutdown can wait for them and
+// GoroutineReport can show what is still running. Once stopping, no new
+// goroutine is admitted, which keeps Add from racing the final Wait.
+type routines struct {
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	stopping bool
+	running  map[string]int
+	pumps    map[*Client]*pumpState
+}
+
+// pumpState is which of a client's pumps are running.
+type pumpState struct {
+	read, write bool
+}
+
+func newRoutines() *routines {
+	return &routines{running: make(map[string]int), pumps: make(map[*Client]*pumpState)}
+}
+
+// add counts a goroutine of kind as started. It reports false, and counts
+// nothing, once the hub is stopping; the goroutine must then not run.
+func (r *routines) add(kind string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopping {
+		return false
+	}
+	r.wg.Add(1)
+	r.running[kind]++
+	return true
+}
+
+// done counts a goroutine added with add as finished.
+func (r *routines) done(kind string) {
+	r.mu.Lock()
+	r.running[kind]--
+	if r.running[kind] == 0 {
+		delete(r.running, kind)
+	}
+	r.mu.Unlock()
+	r.wg.Done()
+}
+
+// addPump is add for one of a client's pumps.
+func (r *routines) addPump(c *Client, kind string) bool {
+	if !r.add(kind) {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.pumps[c]
+	if p == nil {
+		p = &pumpState{}
+		r.pumps[c] = p
+	}
+	if kind == RoutineReadPump {
+		p.read = true
+	} else {
+		p.write = true
+	}
+	return true
+}
+
+// donePump is done for one of a client's pumps.
+func (r *routines) donePump(c *Client, kind string) {
+	r.mu.Lock()
+	if p := r.pumps[c]; p != nil {
+		if kind == RoutineReadPump {
+			p.read = false
+		} else {
+			p.write = false
+		}
+		if !p.read && !p.write {
+			delete(r.pumps, c)
+		}
+	}
+	r.mu.Unlock()
+	r.done(kind)
+}
+
+// stop admits no more goroutines, stops every client with running pumps,
+// including any that never registered, and waits for the running ones.
+func (r *routines) stop() {
+	r.mu.Lock()
+	r.stopping = true
+	for c := range r.pumps {
+		c.stop(ErrHubStopped)
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// spawn runs fn in a goroutine tracked as kind. It reports false, without
+// running fn, if the hub is stopping.
+func (h *Hub) spawn(kind string, fn func()) bool {
+	if !h.routines.add(kind) {
+		return false
+	}
+	go func() {
+		defer h.routines.done(kind)
+		fn()
+	}()
+	return true
+}
+
+// LingeringPump is a client whose pumps still run although the client has
+// stopped, for example after it was kicked or its connection broke.
+type LingeringPump struct {
+	ClientID   string `json:"client_id"`
+	DocumentID string `json:"document_id"`
+	Cause      string `json:"cause"` // Why the client stopped
+	ReadPump   bool   `json:"read_pump"`
+	WritePump  bool   `json:"write_pump"`
+}
+
+// GoroutineReport counts the hub's running goroutines, for finding leaks.
+type GoroutineReport struct {
+	Total   int            `json:"total"`
+	Running map[string]int `json:"running"` // By kind, such as "read_pump"
+	Clients int            `json:"clients"` // Registered clients; each runs two pumps
+	// Lingering lists clients that stopped but whose pumps have not
+	// returned. Pumps end promptly once their client stops, so entries
+	// that persist across reports point at a stuck connection.
+	Lingering []LingeringPump `json:"lingering"`
+}
+
+// GoroutineReport returns the goroutines the hub is running: its own loop
+// and workers, and the pumps of its clients. It is safe to call at any
+// time, including during and after Shutdown.
+func (h *Hub) GoroutineReport() GoroutineReport {
+	r := h.routines
+	r.mu.Lock()
+	report := GoroutineReport{Running: make(map[string]int, len(r.running)), Lingering: []LingeringPump{}}
+	for kind, n := range r.running {
+		report.Running[kind] = n
+		report.Total += n
+	}
+	for c, p := range r.pumps {
+		if c.ctx.Err() == nil {
+			continue
+		}
+		report.Lingering = append(report.Lingering, LingeringPump{
+			ClientID:   c.id,
+			DocumentID: c.documentID,
+			Cause:      context.Cause(c.ctx).Error(),
+			ReadPump:   p.read,
+			WritePump:  p.write,
+		})
+	}
+	r.mu.Unlock()
+
+	sort.Slice(report.Lingering, func(i, j int) bool { return report.Lingering[i].ClientID < report.Lingering[j].ClientID })
+	report.Clients = h.ClientCount()
+	return report
+}
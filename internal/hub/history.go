@@ -0,0 +1,92 @@
+package hub
+
+import "log"
+
+// NewHistoricalClient creates a client for a read-only session on a past
+// version of a document. On registration it receives that version's
+// content; its edits are refused and live edits are not relayed to it.
+// Viewports and ephemeral messages are shared with the other sessions on
+// the same version, so a revision can be reviewed together.
+func NewHistoricalClient(hub *Hub, conn Conn, documentID string, version int) *Client {
+	c := NewClient(hub, conn, documentID)
+	c.historical = true
+	c.version = version
+	return c
+}
+
+// sameView reports whether two clients see the same state of the same
+// document: both live, or both historical at one version.
+func (c *Client) sameView(other *Client) bool {
+	return c.documentID == other.documentID && c.historical == other.historical &&
+		(!c.historical || c.version == other.version)
+}
+
+// sendSnapshot sends a historical client its version's content, marked
+// read-only. If the version cannot be reconstructed, for example because
+// the document was evicted meanwhile, the client is disconnected.
+func (h *Hub) sendSnapshot(client *Client) {
+	doc := h.GetDocument(client.documentID)
+	if doc == nil {
+		log.Printf("historical session on missing document %s", client.documentID)
+		h.kick(client, ErrDisconnected)
+		return
+	}
+	content, err := doc.ContentAt(client.version)
+	if err != nil {
+		log.Printf("historical session on document %s: %v", client.documentID, err)
+		h.kick(client, err)
+		return
+	}
+
+	msg := NewContentMessage(content)
+	msg.DocumentID = client.documentID
+	msg.Version = client.version
+	msg.ReadOnly = true
+	data, err := msg.ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(client, data)
+}
+
+// handleHistorical handles a message from a historical session. Viewports,
+// cursors and ephemeral messages reach the sessions on the same version;
+// anything else is refused.
+func (h *Hub) handleHistorical(bm *broadcastMessage) {
+	client := bm.sender
+	msg, err := MessageFromBytes(bm.message)
+	switch {
+	case err != nil || IsLegacyContent(bm.message):
+		log.Printf("refusing legacy message from read-only session on document %s", client.documentID)
+		h.noteRejected(client)
+	case h.featureDisabled(client.documentID, msg):
+		h.noteRejected(client)
+	case msg.Ephemeral:
+		h.relayEphemeral(client.documentID, msg, bm)
+	case msg.Type == MsgTypeViewport:
+		h.handleViewport(client.documentID, msg, client)
+	case msg.Type == MsgTypeCursor:
+		h.handleCursor(client.documentID, msg, client)
+	case msg.Type == MsgTypePresenceRequest:
+		h.handlePresenceRequest(client.documentID, client)
+	default:
+		log.Printf("refusing %s from read-only session on document %s", msg.Type, client.documentID)
+		h.noteRejected(client)
+	}
+}
+
+// broadcastToView sends a message to the other clients sharing client's
+// view of its document.
+func (h *Hub) broadcastToView(client *Client, message []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	message = h.stamp(message, client.documentID)
+	h.releaseHeld(client.documentID)
+	to := h.roomClients(client.documentID, func(other *Client) bool { return other != client && other.sameView(client) })
+	for _, other := range to {
+		h.sendPendingFirst(other)
+	}
+	h.deliver(client.room, message, to...)
+}
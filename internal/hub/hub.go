@@ -1,142 +1,940 @@
 package hub
 
 import (
+	"collaborative-docs/internal/attachments"
+	"collaborative-docs/internal/blocks"
+	"collaborative-docs/internal/clock"
 	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/jsondoc"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/outline"
+	"collaborative-docs/internal/pressure"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/internal/slo"
+	"collaborative-docs/internal/validators"
+	"context"
+	"errors"
+	"hash/fnv"
 	"log"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 // broadcastMessage pairs a message with its sender for broadcast routing
 type broadcastMessage struct {
-	message []byte
-	sender  *Client
+	message  []byte
+	sender   *Client
+	received time.Time
+	done     chan struct{} // closed once handled, for Submit
+	traceID  string        // Assigned when first handled, kept if the message is queued
+	parsed   *Message      // Decoded to route it, until first handled
+}
+
+// parse decodes the message, reusing the copy decoded to route it.
+func (bm *broadcastMessage) parse() (*Message, error) {
+	if msg := bm.parsed; msg != nil {
+		bm.parsed = nil // Handling changes it; a queued message is decoded again
+		return msg, nil
+	}
+	return MessageFromBytes(bm.message)
+}
+
+// registration is a client for Run to register.
+type registration struct {
+	client *Client
+	done   chan struct{} // Closed once registered
 }
 
 // Hub coordinates WebSocket connections and routes messages
 // between clients editing the same document. It manages document-specific
 // client groups, applies operations to shared document state, and broadcasts
-// changes to connected clients.
+// changes to connected clients. Each document's messages are applied in its
+// room; see room.
 type Hub struct {
 	clients    map[*Client]bool
+	rooms      map[string]*room // Clients, work and delivery by document; guarded by mu
+	apply      sync.RWMutex     // Read by rooms applying messages, written by work spanning documents
 	broadcast  chan *broadcastMessage
-	register   chan *Client
+	register   chan registration
 	unregister chan *Client
+	exec       chan func()
 	documents  map[string]*document.Document
+	tombstones map[string]*tombstone
+	viewports  map[*Client]*viewportState // guarded by stateMu
+	presence   map[string]presence        // Cursors by document; guarded by stateMu
+	paused     map[string]*pause          // guarded by stateMu
+	stateMu    sync.Mutex                 // Held briefly, never while sending or taking mu
 	mu         sync.RWMutex
-	quit       chan struct{}
+	quit       chan struct{} // Closed when Shutdown starts
+	done       chan struct{} // Closed when Shutdown finishes
+	stopOnce   sync.Once
+	lines      subscribers[LineEvent]
+	metadata   subscribers[MetadataEvent]
+
+	attachments *attachments.Manager
+	blobs       blobAssembler
+	events      *events.Bus
+	latency     *slo.Tracker
+	memory      *pressure.Controller
+	persistence *persistence // Set by SetStore
+	broker      Broker       // Set by SetBroker
+	node        string       // Identifies this hub to its peers on the broker
+	snapshots   sharedSnapshots
+	routines    *routines
+	clock       clock.Clock
+	schemas     map[string]*schema.Schema // keyed by document ID prefix
+	teams       map[string]*document.Team // by ID; replaced, never modified, on change; guarded by mu
+	abuse       AbusePolicy
+	rateLimit   RateLimit
+	embeds      map[string][]string                // documents each document embeds; guarded by mu
+	outlines    map[string]*outline.Outline        // built on first request; guarded by mu
+	diagnostics map[string][]validators.Diagnostic // for documents with a validator; guarded by mu
+	traffic     Traffic                            // inbound messages since start; guarded by stateMu
+	metrics     hubMetrics                         // Set by RegisterMetrics
+
+	// Text operations waiting out the coalescing window, by document.
+	window time.Duration // Set by SetCoalesceWindow
+	held   map[string][]heldOperation
+	heldMu sync.Mutex // Guards held
+
+	// When each document's next scheduled action is due; only used from Run.
+	due        map[string]time.Time
+	scheduling bool // The scheduler goroutine is running
+
+	compaction  CompactionPolicy
+	compactedAt map[string]int // Version each document was last compacted at; guarded by stateMu
+
+	idleTTL time.Duration // Set by SetIdleTTL
+
+	compression CompressionPolicy // Set by SetCompression
+
+	// lastLegacy is the hash of the last relayed legacy message; only used
+	// with apply held for writing.
+	lastLegacy struct {
+		sum   uint64
+		valid bool
+	}
 }
 
 // NewHub creates and initializes a new Hub instance
 func NewHub() *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan *broadcastMessage),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		documents:  make(map[string]*document.Document),
-		quit:       make(chan struct{}),
+	h := &Hub{
+		clients:     make(map[*Client]bool),
+		rooms:       make(map[string]*room),
+		broadcast:   make(chan *broadcastMessage),
+		register:    make(chan registration),
+		unregister:  make(chan *Client),
+		exec:        make(chan func()),
+		documents:   make(map[string]*document.Document),
+		tombstones:  make(map[string]*tombstone),
+		viewports:   make(map[*Client]*viewportState),
+		presence:    make(map[string]presence),
+		paused:      make(map[string]*pause),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+		lines:       newSubscribers[LineEvent](),
+		metadata:    newSubscribers[MetadataEvent](),
+		blobs:       blobAssembler{pending: make(map[string]*pendingBlob), clock: clock.Real},
+		events:      events.NewBus(),
+		clock:       clock.Real,
+		schemas:     make(map[string]*schema.Schema),
+		teams:       make(map[string]*document.Team),
+		abuse:       DefaultAbusePolicy,
+		embeds:      make(map[string][]string),
+		outlines:    make(map[string]*outline.Outline),
+		diagnostics: make(map[string][]validators.Diagnostic),
+		routines:    newRoutines(),
+		held:        make(map[string][]heldOperation),
+		due:         make(map[string]time.Time),
+		compactedAt: make(map[string]int),
 	}
+	h.latency = slo.NewTracker(slo.DefaultThreshold, h.alertLatency)
+	return h
 }
 
 // Run starts the hub's main event loop, processing client
 // registration, unregistration, and message broadcasting.
 // This method blocks and should be run in a goroutine.
 func (h *Hub) Run() {
+	if !h.routines.add(RoutineRun) {
+		return // already shut down
+	}
+	defer h.routines.done(RoutineRun)
+	if h.persistence != nil {
+		ticker := h.clock.NewTicker(h.persistence.interval)
+		if !h.spawn(RoutinePersist, func() { h.persist(ticker) }) {
+			ticker.Stop()
+		}
+		h.spawn(RoutineScheduler, h.indexSchedules)
+	}
+	if h.compaction.Interval > 0 {
+		ticker := h.clock.NewTicker(h.compaction.Interval)
+		if !h.spawn(RoutineCompact, func() { h.compactEvery(ticker) }) {
+			ticker.Stop()
+		}
+	}
+	if h.idleTTL > 0 {
+		ticker := h.clock.NewTicker(reapInterval(h.idleTTL))
+		if !h.spawn(RoutineReap, func() { h.reap(ticker) }) {
+			ticker.Stop()
+		}
+	}
 	for {
+		// Work from the server itself, such as trusted server operations and
+		// admin calls, goes ahead of queued client messages.
+		select {
+		case fn := <-h.exec:
+			h.exclusively(fn)
+			continue
+		default:
+		}
+
 		select {
 		case <-h.quit:
 			log.Println("hub shutting down, closing all clients")
-			h.closeAllClients()
+			h.exclusively(h.closeAllClients)
 			return
 
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.mu.Unlock()
-			log.Printf("client registered, total: %d", len(h.clients))
-			h.broadcastUserCount()
+		case reg := <-h.register:
+			h.exclusively(func() { h.registerClient(reg.client) })
+			close(reg.done)
 
 		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				log.Printf("client unregistered, total: %d", len(h.clients))
-			}
-			h.mu.Unlock()
-			h.broadcastUserCount()
+			h.exclusively(func() { h.removeClient(client) })
+
+		case fn := <-h.exec:
+			h.exclusively(fn)
 
 		case bm := <-h.broadcast:
-			msg, err := MessageFromBytes(bm.message)
-			if err != nil || IsLegacyContent(bm.message) {
-				log.Printf("broadcasting legacy message to all clients")
-				h.broadcastToAll(bm.message, nil)
-				continue
+			h.exclusively(func() { h.handle(bm) })
+		}
+	}
+}
+
+// exclusively runs fn with h.apply held for writing, so no room applies a
+// message meanwhile.
+func (h *Hub) exclusively(fn func()) {
+	h.apply.Lock()
+	defer h.apply.Unlock()
+	fn()
+}
+
+// registerClient adds a client to its room and sends it the document.
+func (h *Hub) registerClient(client *Client) {
+	// Held operations are in the content the client is sent.
+	h.flushHeld(client.documentID)
+	h.mu.Lock()
+	if !h.join(client) {
+		h.mu.Unlock()
+		client.stop(ErrHubStopped)
+		close(client.send)
+		return
+	}
+	h.clients[client] = true
+	client.stats.connected = h.clock.Now()
+	client.stats.active = client.stats.connected
+	ts := h.tombstones[client.documentID]
+	h.mu.Unlock()
+	if ts != nil {
+		h.notifyClientDeleted(client, ts)
+	} else {
+		h.sendWelcome(client)
+		if client.historical {
+			h.sendSnapshot(client)
+		} else {
+			h.sendSync(client)
+			h.sendFences(client)
+			h.sendPreferences(client)
+			h.notifyClientPaused(client)
+		}
+		h.sendViewports(client)
+		h.sendCursors(client)
+		h.sendDiagnostics(client)
+	}
+	log.Printf("client registered, total: %d", h.ClientCount())
+	h.broadcastUserCount(client.documentID)
+	h.events.Emit(events.Event{
+		Type:       events.TypeUserJoined,
+		DocumentID: client.documentID,
+		Clients:    h.ClientCountForDocument(client.documentID),
+	})
+}
+
+// handle admits and handles one inbound message, then, for Submit, waits
+// for what it sent to be delivered.
+func (h *Hub) handle(bm *broadcastMessage) {
+	if h.admit(bm) {
+		h.handleBroadcast(bm)
+	}
+	if bm.done != nil {
+		h.settle()
+		close(bm.done)
+	}
+}
+
+// handleRouted handles a message taken for r on the room's goroutine.
+// Messages for r's document are applied alongside other rooms'; one that
+// reaches other documents, such as a transaction, waits for them.
+func (h *Hub) handleRouted(r *room, bm *broadcastMessage) {
+	if h.spansDocuments(r, bm) {
+		h.exclusively(func() { h.handle(bm) })
+		return
+	}
+	h.apply.RLock()
+	defer h.apply.RUnlock()
+	h.handle(bm)
+}
+
+// spansDocuments reports whether handling bm may touch documents other
+// than r's: transactions, legacy content relayed to every client, and
+// messages without r's document ID.
+func (h *Hub) spansDocuments(r *room, bm *broadcastMessage) bool {
+	if bm.sender != nil && bm.sender.historical {
+		return false
+	}
+	if IsLegacyContent(bm.message) {
+		return true
+	}
+	if bm.parsed == nil {
+		msg, err := MessageFromBytes(bm.message)
+		if err != nil {
+			return false // Only counted against the sender
+		}
+		bm.parsed = msg
+	}
+	return bm.parsed.Type == MsgTypeTransaction || bm.parsed.DocumentID != r.documentID
+}
+
+// route returns the document whose room handles bm: the sender's once it
+// is registered, else the one the message names. It returns "" for
+// messages the Run goroutine handles, those of senders still registering
+// and those not for one document.
+func (h *Hub) route(bm *broadcastMessage) string {
+	if c := bm.sender; c != nil {
+		h.mu.RLock()
+		registered := c.room != nil
+		h.mu.RUnlock()
+		if registered {
+			return c.documentID
+		}
+		return ""
+	}
+	if IsLegacyContent(bm.message) {
+		return ""
+	}
+	msg, err := MessageFromBytes(bm.message)
+	if err != nil || msg.Type == MsgTypeTransaction {
+		return ""
+	}
+	bm.parsed = msg
+	return msg.DocumentID
+}
+
+// kick disconnects a client for cause. Its context is canceled at once, so
+// its pumps stop and the close frame gives the cause; the removal itself
+// goes through the Unregister channel, as callers may hold h.mu.
+func (h *Hub) kick(client *Client, cause error) {
+	client.stop(cause)
+	h.spawn(RoutineKick, func() { h.Unregister(client) })
+}
+
+// removeClient unregisters a client, cancels its context and closes its
+// send channel, which ends its WritePump and so the connection.
+func (h *Hub) removeClient(client *Client) {
+	h.mu.Lock()
+	ok := h.detach(client, ErrDisconnected)
+	if ok {
+		log.Printf("client unregistered, total: %d", len(h.clients))
+	}
+	h.mu.Unlock()
+	h.broadcastUserCount(client.documentID)
+	if ok {
+		h.forgetViewport(client)
+		h.forgetCursor(client)
+		h.events.Emit(events.Event{
+			Type:       events.TypeUserLeft,
+			DocumentID: client.documentID,
+			Clients:    h.ClientCountForDocument(client.documentID),
+		})
+	}
+}
+
+// detach removes a registered client, stopping it with cause, and reports
+// whether it was registered. Its room closes its send channel once the
+// messages queued before are delivered; every send checks registration
+// under h.mu, so none is queued after. Callers must hold h.mu.
+func (h *Hub) detach(client *Client, cause error) bool {
+	if !h.clients[client] {
+		return false
+	}
+	delete(h.clients, client)
+	client.stop(cause)
+	h.leave(client)
+	return true
+}
+
+// handleBroadcast routes one inbound message: operations are applied to
+// the document and relayed, other message types are handled or forwarded.
+func (h *Hub) handleBroadcast(bm *broadcastMessage) {
+	if bm.sender != nil && bm.sender.historical {
+		h.handleHistorical(bm)
+		return
+	}
+	if h.refuseReadOnly(bm) {
+		h.noteRejected(bm.sender)
+		return
+	}
+
+	msg, err := bm.parse()
+	if err != nil || IsLegacyContent(bm.message) {
+		if !IsLegacyContent(bm.message) {
+			h.noteParseError(bm.sender)
+			return
+		}
+		// Legacy clients resend their whole content; relaying an identical
+		// copy again would only cause an update storm.
+		hash := fnv.New64a()
+		hash.Write(bm.message)
+		sum := hash.Sum64()
+		if h.lastLegacy.valid && h.lastLegacy.sum == sum {
+			log.Printf("skipping repeated legacy message")
+			return
+		}
+		h.lastLegacy.sum, h.lastLegacy.valid = sum, true
+		log.Printf("broadcasting legacy message to all clients")
+		h.broadcastToAll(bm.message, nil)
+		return
+	}
+
+	bm.trace(msg)
+	if msg.Type.isEdit() || msg.Type == MsgTypeTransaction {
+		h.noteActivity(bm.sender)
+	}
+	if msg.Type == MsgTypeTransaction {
+		h.handleTransaction(msg, bm.sender)
+		return
+	}
+
+	documentID := msg.DocumentID
+	if documentID == "" {
+		log.Printf("no document ID in message, broadcasting to all")
+		h.broadcastToAll(bm.message, nil)
+		return
+	}
+
+	if ts := h.tombstoneFor(documentID); ts != nil {
+		log.Printf("rejecting %s for deleted document %s (trace %s)", msg.Type, documentID, msg.TraceID)
+		if data, err := newDeletedMessage(documentID, ts).ToBytes(); err == nil {
+			h.sendToClient(bm.sender, data)
+		}
+		h.noteRejected(bm.sender)
+		return
+	}
+
+	if h.featureDisabled(documentID, msg) {
+		h.noteRejected(bm.sender)
+		return
+	}
+
+	if msg.Ephemeral {
+		h.relayEphemeral(documentID, msg, bm)
+		return
+	}
+
+	if h.holdIfPaused(documentID, msg, bm) {
+		return
+	}
+
+	doc := h.GetOrCreateDocument(documentID)
+
+	switch msg.Type {
+	case MsgTypeOperation:
+		if msg.Operation != nil {
+			// The operation is transformed past edits the sender had not
+			// seen at its version. The sender already shows the text as
+			// typed, so when cleaning, normalization or concurrent edits
+			// change it the sender is sent the document as applied.
+			typed := msg.Operation.Text
+			h.sanitizeOperation(doc, msg.Operation)
+			if msg.Operation.Text == "" && msg.Operation.Type == operations.OpInsert {
+				// Acknowledge it so the sender stops resubmitting it; the
+				// content that follows no longer holds the text.
+				log.Printf("insert into document %s empty after sanitizing, dropping (trace %s)", documentID, msg.TraceID)
+				h.ackOperation(bm.sender, documentID, msg.Operation.ID, msg.TraceID, doc.GetVersion())
+				h.sendContent(documentID, doc, bm.sender)
+				return
+			}
+			log.Printf("applying operation to document %s: %s (trace %s)", documentID, msg.Operation.String(), msg.TraceID)
+			// Who it is rebased past goes in a conflict report if the
+			// concurrent edits refuse or reshape it.
+			sent := *msg.Operation
+			with, stale := h.concurrentEdits(documentID, doc, sent.Version, bm.sender)
+			applied, err := doc.ApplyWithinWindow(msg.Operation, bm.sender.authorID(), !bm.sender.mayEditFences())
+			var dup *document.DuplicateOperationError
+			if errors.As(err, &dup) {
+				log.Printf("skipping resubmitted operation: %v (trace %s)", err, msg.TraceID)
+				h.ackOperation(bm.sender, documentID, dup.ID, msg.TraceID, dup.Version)
+				return
+			}
+			if err != nil {
+				log.Printf("operation failed: %v (trace %s)", err, msg.TraceID)
+				h.metrics.operationFailed(err)
+				h.noteRejected(bm.sender)
+				h.failEdit(bm.sender, documentID, msg, err)
+				if stale || len(with) > 0 {
+					h.reportConflict(bm.sender, documentID, doc.GetVersion(), &ConflictReport{
+						Kind: ConflictRejected, Operation: &sent, Author: bm.sender.author(), With: with, Reason: err.Error(),
+					}, msg.TraceID)
+				}
+				return
+			}
+			if applied == nil {
+				// Concurrent edits already made the change, e.g. deleted
+				// the same text.
+				log.Printf("operation on document %s redundant after concurrent edits, dropping (trace %s)", documentID, msg.TraceID)
+				h.ackOperation(bm.sender, documentID, msg.Operation.ID, msg.TraceID, doc.GetVersion())
+				h.reportConflict(bm.sender, documentID, doc.GetVersion(), &ConflictReport{
+					Kind: ConflictRedundant, Operation: &sent, Author: bm.sender.author(), With: with,
+				}, msg.TraceID)
+				return
+			}
+			msg.Operation = applied
+			newContent, newVersion := doc.GetContentAndVersion()
+
+			log.Printf("operation applied to document %s, version: %d, length: %d (trace %s)",
+				documentID, newVersion, len(newContent), msg.TraceID)
+
+			lineChange := operations.LinesChanged(newContent, msg.Operation)
+			h.publishLines(LineEvent{
+				DocumentID: documentID,
+				Version:    newVersion,
+				Language:   doc.GetLanguage(),
+				LineChange: lineChange,
+			})
+
+			msg.Operation.Version = newVersion
+			msg.Author = bm.sender.author()
+			h.events.Emit(events.Event{
+				Type:       events.TypeOperationApplied,
+				DocumentID: documentID,
+				Version:    newVersion,
+				Operation:  msg.Operation,
+				TraceID:    msg.TraceID,
+			})
+
+			msgBytes, err := msg.ToBytes()
+			if err != nil {
+				log.Printf("serialization failed: %v", err)
+				return
 			}
+			h.broadcastOperation(documentID, msg.Operation, msgBytes, bm.sender)
+			h.shareOperation(documentID, msg.Operation, msg.Author, msg.TraceID)
+			h.ackOperation(bm.sender, documentID, msg.Operation.ID, msg.TraceID, newVersion)
+			if msg.Operation.Text != typed {
+				h.sendContent(documentID, doc, bm.sender)
+			}
+			if sent.Type != operations.OpInsert && msg.Operation.Text != sent.Text {
+				h.reportConflict(bm.sender, documentID, newVersion, &ConflictReport{
+					Kind: ConflictOverlap, Operation: &sent, Applied: msg.Operation, Author: bm.sender.author(), With: with,
+				}, msg.TraceID)
+			}
+			h.observeLatency(documentID, bm)
+			h.contentChanged(documentID, newVersion, &lineChange)
+		}
 
-			documentID := msg.DocumentID
-			if documentID == "" {
-				log.Printf("no document ID in message, broadcasting to all")
-				h.broadcastToAll(bm.message, nil)
-				continue
+	case MsgTypeBlockOperation:
+		if msg.BlockOperation != nil {
+			log.Printf("applying block operation to document %s: %s (trace %s)", documentID, msg.BlockOperation.String(), msg.TraceID)
+			var applied *blocks.Operation
+			var newVersion int
+			var err error
+			if !bm.sender.mayEditFences() && doc.HasFences() {
+				err = errBlocksFenced
+			} else {
+				applied, newVersion, err = doc.ApplyBlockOperation(msg.BlockOperation)
+			}
+			if err != nil {
+				log.Printf("block operation failed: %v (trace %s)", err, msg.TraceID)
+				h.noteRejected(bm.sender)
+				h.failEdit(bm.sender, documentID, msg, err)
+				return
+			}
+			if applied.Type == blocks.OpNoop {
+				// Concurrent changes already did what it asked; nothing to relay.
+				h.ackOperation(bm.sender, documentID, msg.AckID, msg.TraceID, newVersion)
+				return
 			}
 
-			doc := h.GetOrCreateDocument(documentID)
-
-			switch msg.Type {
-			case MsgTypeOperation:
-				if msg.Operation != nil {
-					log.Printf("applying operation to document %s: %s", documentID, msg.Operation.String())
-					newContent, newVersion, err := doc.ApplyOperation(msg.Operation)
-					if err != nil {
-						log.Printf("operation failed: %v", err)
-						continue
-					}
-
-					log.Printf("operation applied to document %s, version: %d, length: %d",
-						documentID, newVersion, len(newContent))
-
-					msg.Operation.Version = newVersion
-
-					msgBytes, err := msg.ToBytes()
-					if err != nil {
-						log.Printf("serialization failed: %v", err)
-						continue
-					}
-					h.broadcastToDocument(documentID, msgBytes, bm.sender)
-				}
+			// Peers receive the operation as transformed and applied.
+			msg.BlockOperation = applied
+			msg.Author = bm.sender.author()
 
-			case MsgTypeContent:
-				if msg.Content != "" {
-					doc.SetContent(msg.Content)
-					msgBytes, _ := msg.ToBytes()
-					h.broadcastToDocument(documentID, msgBytes, bm.sender)
-				}
+			msgBytes, err := msg.ToBytes()
+			if err != nil {
+				log.Printf("serialization failed: %v", err)
+				return
+			}
+			h.broadcastToDocument(documentID, msgBytes, bm.sender)
+			h.ackOperation(bm.sender, documentID, msg.AckID, msg.TraceID, newVersion)
+			h.observeLatency(documentID, bm)
+			h.contentChanged(documentID, newVersion, nil)
+		}
 
-			default:
-				h.broadcastToDocument(documentID, bm.message, bm.sender)
+	case MsgTypeJSONOperation:
+		if msg.JSONOperation != nil {
+			// A brand-new document becomes a JSON document on its first JSON operation.
+			if err := doc.SetKind(document.KindJSON); err != nil {
+				log.Printf("json operation rejected for document %s: %v", documentID, err)
+				h.noteRejected(bm.sender)
+				h.failEdit(bm.sender, documentID, msg, err)
+				return
 			}
+
+			log.Printf("applying json operation to document %s: %s (trace %s)", documentID, msg.JSONOperation.String(), msg.TraceID)
+			_, newVersion, err := doc.ApplyJSONOperation(msg.JSONOperation)
+			if err != nil {
+				log.Printf("json operation failed: %v (trace %s)", err, msg.TraceID)
+				h.noteRejected(bm.sender)
+				h.failEdit(bm.sender, documentID, msg, err)
+				return
+			}
+
+			if msg.JSONOperation.Type == jsondoc.OpNoop {
+				// Concurrent changes already did away with it; nothing to relay.
+				h.ackOperation(bm.sender, documentID, msg.AckID, msg.TraceID, newVersion)
+				return
+			}
+
+			msg.Author = bm.sender.author()
+
+			msgBytes, err := msg.ToBytes()
+			if err != nil {
+				log.Printf("serialization failed: %v", err)
+				return
+			}
+			h.broadcastToDocument(documentID, msgBytes, bm.sender)
+			h.ackOperation(bm.sender, documentID, msg.AckID, msg.TraceID, newVersion)
+			h.observeLatency(documentID, bm)
+		}
+
+	case MsgTypeAttachmentRequest:
+		h.handleAttachmentRequest(documentID, msg, bm.sender)
+
+	case MsgTypeBlob:
+		h.handleBlob(doc, documentID, msg, bm.sender)
+
+	case MsgTypeBlobRequest:
+		h.handleBlobRequest(doc, documentID, msg, bm.sender)
+
+	case MsgTypeMetadataSet:
+		h.handleMetadataSet(doc, documentID, msg, bm.sender)
+
+	case MsgTypeMetadataGet:
+		h.handleMetadataGet(doc, documentID, bm.sender)
+
+	case MsgTypePreferencesSet:
+		h.handlePreferencesSet(doc, documentID, msg, bm.sender)
+
+	case MsgTypePreferencesGet:
+		h.handlePreferencesGet(doc, bm.sender)
+
+	case MsgTypeViewport:
+		h.handleViewport(documentID, msg, bm.sender)
+
+	case MsgTypeCursor:
+		h.handleCursor(documentID, msg, bm.sender)
+
+	case MsgTypePresenceRequest:
+		h.handlePresenceRequest(documentID, bm.sender)
+
+	case MsgTypeOutline:
+		h.handleOutline(documentID, bm.sender)
+
+	case MsgTypeUndo, MsgTypeRedo:
+		h.handleUndo(doc, documentID, msg, bm.sender)
+
+	case MsgTypeResync:
+		h.handleResync(documentID, doc, msg, bm.sender)
+
+	case MsgTypeSyncRequest:
+		h.sendSync(bm.sender)
+
+	case MsgTypeSyncMode:
+		h.handleSyncMode(documentID, msg, bm.sender)
+
+	case MsgTypeLanguage:
+		if len(msg.Language) > maxLanguageLength {
+			log.Printf("language for document %s too long, ignoring", documentID)
+			return
 		}
+		doc.SetLanguage(msg.Language)
+		log.Printf("document %s language set to %q", documentID, msg.Language)
+		h.broadcastToDocument(documentID, bm.message, bm.sender)
+
+	case MsgTypeContent:
+		if msg.Content != "" {
+			cleaned := doc.Normalize(doc.Sanitizer().Text(msg.Content))
+			rewritten := cleaned != msg.Content
+			msg.Content = cleaned
+			if rewritten && cleaned == "" {
+				log.Printf("content for document %s empty after sanitizing, dropping", documentID)
+				h.sendContent(documentID, doc, bm.sender)
+				return
+			}
+			err := doc.ValidateContent(msg.Content)
+			if err == nil {
+				err = checkFencedContent(doc, bm.sender, msg.Content)
+			}
+			if err != nil {
+				log.Printf("content rejected for document %s: %v", documentID, err)
+				h.noteRejected(bm.sender)
+				h.failEdit(bm.sender, documentID, msg, err)
+				return
+			}
+			version, changed, err := doc.SetContentIfChanged(msg.Content)
+			if err != nil {
+				log.Printf("content rejected for document %s: %v", documentID, err)
+				h.noteRejected(bm.sender)
+				h.failEdit(bm.sender, documentID, msg, err)
+				return
+			}
+			if !changed {
+				log.Printf("skipping unchanged content for document %s", documentID)
+				h.ackOperation(bm.sender, documentID, msg.AckID, msg.TraceID, version)
+				if rewritten {
+					h.sendContent(documentID, doc, bm.sender)
+				}
+				return
+			}
+			h.events.Emit(events.Event{
+				Type:       events.TypeContentSet,
+				DocumentID: documentID,
+				Version:    version,
+			})
+			msg.Version = version
+			h.broadcastContent(documentID, doc, msg, bm.sender)
+			h.ackOperation(bm.sender, documentID, msg.AckID, msg.TraceID, version)
+			if rewritten {
+				h.sendContent(documentID, doc, bm.sender)
+			}
+			h.contentChanged(documentID, version, nil)
+		}
+
+	default:
+		h.broadcastToDocument(documentID, bm.message, bm.sender)
+	}
+}
+
+// SetAttachments enables attachment uploads using the given manager.
+// It must be called before Run.
+func (h *Hub) SetAttachments(m *attachments.Manager) {
+	h.attachments = m
+}
+
+// handleAttachmentRequest issues an upload slot and replies to the sender only.
+func (h *Hub) handleAttachmentRequest(documentID string, msg *Message, sender *Client) {
+	if h.attachments == nil || msg.Attachment == nil {
+		log.Printf("attachment request for document %s ignored", documentID)
+		return
+	}
+
+	req := msg.Attachment
+	slot, err := h.attachments.RequestUpload(documentID, req.Filename, req.ContentType, req.Size)
+	if err != nil {
+		log.Printf("attachment request for document %s failed: %v", documentID, err)
+		return
+	}
+
+	msgBytes, err := NewAttachmentSlotMessage(slot).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(sender, msgBytes)
+}
+
+// CollectAttachments deletes attachments no longer referenced by their
+// document's content and returns how many were removed.
+func (h *Hub) CollectAttachments() int {
+	if h.attachments == nil {
+		return 0
+	}
+
+	removed := 0
+	for _, documentID := range h.attachments.Documents() {
+		content := ""
+		if doc := h.GetDocument(documentID); doc != nil {
+			content = doc.GetContent()
+		}
+		removed += h.attachments.Collect(documentID, content)
+	}
+	return removed
+}
+
+// SetClock replaces the wall clock used for document modification times,
+// ping tickers, blob expiry, latency and idle eviction. It must be called
+// before Run and before any document is created.
+func (h *Hub) SetClock(c clock.Clock) {
+	h.clock = c
+	h.blobs.clock = c
+}
+
+// Clock returns the hub's clock.
+func (h *Hub) Clock() clock.Clock {
+	return h.clock
+}
+
+// SetLatencyThreshold sets the per-operation latency above which a
+// latency_alert event is emitted. It must be called before Run.
+func (h *Hub) SetLatencyThreshold(d time.Duration) {
+	h.latency = slo.NewTracker(d, h.alertLatency)
+}
+
+// LatencyReport returns receive-to-broadcast latency histograms overall and
+// per document.
+func (h *Hub) LatencyReport() slo.Report {
+	return h.latency.Report()
+}
+
+// observeLatency records how long an operation took from being read off the
+// sender's connection to being queued for every other client.
+func (h *Hub) observeLatency(documentID string, bm *broadcastMessage) {
+	if bm.received.IsZero() {
+		return
 	}
+	h.latency.Observe(documentID, h.clock.Now().Sub(bm.received))
 }
 
-// Register adds a client to the hub.
+// alertLatency reports an operation that exceeded the latency threshold.
+func (h *Hub) alertLatency(a slo.Alert) {
+	log.Printf("operation on document %s took %v (threshold %v)", a.DocumentID, a.Latency, a.Threshold)
+	h.events.Emit(events.Event{
+		Type:       events.TypeLatencyAlert,
+		DocumentID: a.DocumentID,
+		LatencyMS:  float64(a.Latency) / float64(time.Millisecond),
+	})
+}
+
+// Register adds a client to the hub, returning once it is registered, so
+// messages handled in its document's room from then on reach it. Register
+// and Unregister return without effect once the hub has shut down.
 func (h *Hub) Register(client *Client) {
-	h.register <- client
+	reg := registration{client: client, done: make(chan struct{})}
+	select {
+	case h.register <- reg:
+	case <-h.quit:
+		return
+	}
+	select {
+	case <-reg.done:
+	case <-h.quit:
+	}
 }
 
 // Unregister removes a client from the hub.
 func (h *Hub) Unregister(client *Client) {
-	h.unregister <- client
+	select {
+	case h.unregister <- client:
+	case <-h.quit:
+	}
+}
+
+// Broadcast queues a message for the hub, which applies it or relays it to
+// the sender's collaborators. The sender parameter can be nil for system
+// messages. Each document's room takes one message at a time, so a caller
+// waits while the document is busy, but not for other documents; ctx
+// bounds the wait. The message is dropped, with ErrHubStopped once the hub
+// has shut down, or with the cause of ctx or of the sender's context if
+// either is done first.
+func (h *Hub) Broadcast(ctx context.Context, message []byte, sender *Client) error {
+	return h.dispatch(ctx, &broadcastMessage{
+		message:  message,
+		sender:   sender,
+		received: h.clock.Now(),
+	})
+}
+
+// Submit is like Broadcast but waits until the hub has handled the message,
+// so a caller can build its next operation on the result.
+func (h *Hub) Submit(message []byte, sender *Client) {
+	done := make(chan struct{})
+	err := h.dispatch(context.Background(), &broadcastMessage{
+		message:  message,
+		sender:   sender,
+		received: h.clock.Now(),
+		done:     done,
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case <-done:
+	case <-h.quit:
+	}
+}
+
+// dispatch hands bm to the room that handles it, or to the Run goroutine.
+// A sender's messages handled on Run are waited for, so the ones it sends
+// next through its room cannot overtake them.
+func (h *Hub) dispatch(ctx context.Context, bm *broadcastMessage) error {
+	documentID := h.route(bm)
+	if documentID == "" {
+		wait := bm.done == nil && bm.sender != nil
+		if wait {
+			bm.done = make(chan struct{})
+		}
+		select {
+		case h.broadcast <- bm:
+		case <-h.quit:
+			return ErrHubStopped
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-bm.sender.done():
+			return context.Cause(bm.sender.ctx)
+		}
+		if wait {
+			select {
+			case <-bm.done:
+			case <-h.quit:
+			}
+		}
+		return nil
+	}
+
+	r := h.take(documentID)
+	if r == nil {
+		return ErrHubStopped
+	}
+	select {
+	case r.work <- bm:
+		return nil
+	case <-h.quit:
+		h.release(r)
+		return ErrHubStopped
+	case <-ctx.Done():
+		h.release(r)
+		return context.Cause(ctx)
+	case <-bm.sender.done():
+		h.release(r)
+		return context.Cause(bm.sender.ctx)
+	}
 }
 
-// Broadcast sends a message to all connected clients.
-// The sender parameter can be nil for system messages.
-func (h *Hub) Broadcast(message []byte, sender *Client) {
-	h.broadcast <- &broadcastMessage{
-		message: message,
-		sender:  sender,
+// do runs fn on the hub goroutine, while no room applies a message, and
+// waits for it to finish and for the messages it sent to be delivered. It
+// returns false without running fn if the hub has shut down.
+func (h *Hub) do(fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case h.exec <- func() { fn(); h.settle(); close(done) }:
+	case <-h.quit:
+		return false
 	}
+	<-done
+	return true
 }
 
 // ClientCount returns the current number of connected clients.
@@ -151,65 +949,148 @@ func (h *Hub) ClientCountForDocument(documentID string) int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	count := 0
-	for client := range h.clients {
-		if client.documentID == documentID {
-			count++
-		}
+	if r := h.rooms[documentID]; r != nil {
+		return len(r.clients)
 	}
-	return count
+	return 0
 }
 
 // GetOrCreateDocument retrieves an existing document or creates a new one.
 func (h *Hub) GetOrCreateDocument(documentID string) *document.Document {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	if doc := h.GetDocument(documentID); doc != nil {
+		return doc
+	}
 
+	h.mu.Lock()
 	doc, exists := h.documents[documentID]
 	if !exists {
-		doc = document.NewDocument()
+		doc = document.NewDocumentWithClock(h.clock)
+		if s := h.schemaFor(documentID); s != nil {
+			doc.SetSchema(s) // an empty document satisfies any schema
+		}
 		h.documents[documentID] = doc
 		log.Printf("created new document: %s", documentID)
 	}
+	h.mu.Unlock()
+
+	if !exists {
+		h.events.Emit(events.Event{Type: events.TypeDocumentCreated, DocumentID: documentID})
+	}
 	return doc
 }
 
-// GetDocument retrieves a document by ID, returns nil if not found.
-func (h *Hub) GetDocument(documentID string) *document.Document {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return h.documents[documentID]
+// SetSchema enforces s on text documents created from now on whose IDs
+// start with prefix; an empty prefix matches every document. When several
+// prefixes match, the longest wins. A nil schema removes the rule.
+func (h *Hub) SetSchema(prefix string, s *schema.Schema) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s == nil {
+		delete(h.schemas, prefix)
+		return
+	}
+	h.schemas[prefix] = s
 }
 
-// broadcastUserCount sends the current user count to all connected clients.
-func (h *Hub) broadcastUserCount() {
+// schemaFor returns the schema for a new document. Callers must hold h.mu.
+func (h *Hub) schemaFor(documentID string) *schema.Schema {
+	var best *schema.Schema
+	bestLen := -1
+	for prefix, s := range h.schemas {
+		if strings.HasPrefix(documentID, prefix) && len(prefix) > bestLen {
+			best, bestLen = s, len(prefix)
+		}
+	}
+	return best
+}
+
+// AddEventSink registers a sink for the document change event stream.
+func (h *Hub) AddEventSink(s events.Sink) {
+	h.events.AddSink(s)
+}
+
+// GetDocument retrieves a document by ID, returns nil if not found.
+// With a store, a document not in memory is loaded from it.
+func (h *Hub) GetDocument(documentID string) *document.Document {
 	h.mu.RLock()
-	defer h.mu.RUnlock()
+	doc := h.documents[documentID]
+	h.mu.RUnlock()
+	if doc != nil || h.persistence == nil {
+		return doc
+	}
 
-	docCounts := make(map[string]int)
-	for client := range h.clients {
-		docCounts[client.documentID]++
+	loaded := h.loadDocument(documentID)
+	if loaded == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if doc := h.documents[documentID]; doc != nil {
+		return doc // loaded or created meanwhile
+	}
+	if _, deleted := h.tombstones[documentID]; deleted {
+		return nil
+	}
+	if s := h.schemaFor(documentID); s != nil {
+		if err := loaded.SetSchema(s); err != nil {
+			log.Printf("stored document %s does not satisfy its schema: %v", documentID, err)
+		}
 	}
+	h.documents[documentID] = loaded
+	log.Printf("loaded document: %s", documentID)
+	return loaded
+}
 
-	for documentID, count := range docCounts {
-		msg := NewUserCountMessage(count)
-		msgBytes, err := msg.ToBytes()
+// DocumentIDs returns the IDs of all loaded documents in sorted order.
+// With a store, documents saved there but not loaded are included.
+func (h *Hub) DocumentIDs() []string {
+	seen := make(map[string]bool)
+	if p := h.persistence; p != nil {
+		stored, err := p.store.List()
 		if err != nil {
-			log.Printf("user count message creation failed: %v", err)
-			continue
+			log.Printf("listing stored documents failed: %v", err)
 		}
-
-		for client := range h.clients {
-			if client.documentID == documentID {
-				select {
-				case client.send <- msgBytes:
-				default:
-				}
-			}
+		for _, id := range stored {
+			seen[id] = true
 		}
+	}
+	h.mu.RLock()
+	for id := range h.documents {
+		seen[id] = true
+	}
+	for id := range h.tombstones {
+		delete(seen, id)
+	}
+	h.mu.RUnlock()
 
-		log.Printf("broadcasted user count %d to document: %s", count, documentID)
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
 	}
+	sort.Strings(ids)
+	return ids
+}
+
+// broadcastUserCount sends a document's current user count to its clients.
+// A client too busy to take it gets the next one instead.
+func (h *Hub) broadcastUserCount(documentID string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	r := h.rooms[documentID]
+	if r == nil {
+		return
+	}
+	count := len(r.clients)
+	msgBytes, err := NewUserCountMessage(count).ToBytes()
+	if err != nil {
+		log.Printf("user count message creation failed: %v", err)
+		return
+	}
+	to := h.roomClients(documentID, func(*Client) bool { return true })
+	h.enqueue(r, delivery{message: h.stamp(msgBytes, documentID), to: to, lenient: true})
+
+	log.Printf("broadcasted user count %d to document: %s", count, documentID)
 }
 
 // broadcastToAll sends a message to all connected clients (legacy support).
@@ -218,66 +1099,82 @@ func (h *Hub) broadcastToAll(message []byte, exclude *Client) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	for client := range h.clients {
-		// Skip the sender if exclude is provided
-		if exclude != nil && client == exclude {
-			continue
-		}
-
-		select {
-		case client.send <- message:
-		default:
-			// Use Unregister channel instead of direct deletion to avoid race condition
-			go h.Unregister(client)
-			log.Printf("client marked for removal due to full send buffer")
-		}
+	for documentID, r := range h.rooms {
+		// Skip the sender if exclude is provided, and historical sessions
+		h.deliver(r, message, h.roomClients(documentID, func(c *Client) bool {
+			return c != exclude && !c.historical
+		})...)
 	}
 }
 
-// broadcastToDocument sends a message to all clients editing a specific document.
+// broadcastToDocument sends a message to all clients editing a specific
+// document; historical sessions only see their own version.
 // The exclude parameter can be nil to send to all clients, or set to skip the sender.
 func (h *Hub) broadcastToDocument(documentID string, message []byte, exclude *Client) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	sentCount := 0
-	for client := range h.clients {
-		if client.documentID == documentID {
-			// Skip the sender if exclude is provided
-			if exclude != nil && client == exclude {
-				continue
-			}
+	message = h.stamp(message, documentID)
+	h.releaseHeld(documentID)
 
-			select {
-			case client.send <- message:
-				sentCount++
-			default:
-				// Use Unregister channel instead of direct deletion to avoid race condition
-				go h.Unregister(client)
-				log.Printf("client marked for removal due to full send buffer")
-			}
-		}
+	// Skip the sender if exclude is provided
+	to := h.roomClients(documentID, func(c *Client) bool { return c != exclude && !c.historical })
+	for _, client := range to {
+		h.sendPendingFirst(client)
 	}
+	h.deliver(h.rooms[documentID], message, to...)
 
-	log.Printf("broadcasted message to %d clients on document: %s", sentCount, documentID)
+	log.Printf("broadcasted message to %d clients on document: %s", len(to), documentID)
 }
 
-// Shutdown gracefully stops the hub and closes all client connections.
+// sendToClient delivers a message to a single registered client without blocking.
+func (h *Hub) sendToClient(client *Client, message []byte) {
+	if client == nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	if h.heldFor(client) {
+		h.releaseHeld(client.documentID)
+	}
+	h.deliver(client.room, h.stamp(message, client.documentID), client)
+}
+
+// Shutdown gracefully stops the hub and closes all client connections. It
+// blocks until Run, the hub's workers and every client's pumps have
+// returned, then, with a store, saves every changed document. A pump
+// blocked writing to an unresponsive peer holds it up for at most the
+// write timeout. It is safe to call more than once and from several
+// goroutines, but not from the hub's own; every call returns once the
+// first has finished.
 func (h *Hub) Shutdown() {
-	close(h.quit)
+	h.stopOnce.Do(func() {
+		close(h.quit)
+		h.routines.stop()
+		h.flush()
+		close(h.done)
+	})
 }
 
-// closeAllClients closes all client connections during shutdown.
+// Done returns a channel that is closed once Shutdown has finished.
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+// closeAllClients cancels every client with ErrHubStopped during
+// shutdown, so each WritePump sends a going-away close frame and closes
+// its connection.
 func (h *Hub) closeAllClients() {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	for client := range h.clients {
-		close(client.send)
-		if err := client.conn.Close(); err != nil {
-			log.Printf("error closing client connection: %v", err)
-		}
+		h.detach(client, ErrHubStopped)
 	}
-	h.clients = make(map[*Client]bool)
 	log.Printf("all clients closed")
 }
@@ -1,13 +1,34 @@
 package hub
 
 import (
+	"context"
 	"encoding/json"
-	"net/http/httptest"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/metrics"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/outline"
+	"collaborative-docs/internal/pressure"
+	"collaborative-docs/internal/sanitize"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/internal/store"
+	"collaborative-docs/internal/textnorm"
+	"collaborative-docs/internal/validators"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -39,15 +60,7 @@ func TestClientRegistration(t *testing.T) {
 	h := NewHub()
 	go h.Run()
 
-	// Create a mock WebSocket connection
-	server := httptest.NewServer(nil)
-	defer server.Close()
-
-	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
-	if err != nil {
-		t.Skip("skipping test that requires WebSocket server")
-	}
+	conn := NewPipe()
 	defer conn.Close()
 
 	client := NewClient(h, conn, "test-doc")
@@ -130,7 +143,7 @@ func TestBroadcast(t *testing.T) {
 
 	// Broadcast test message
 	testMessage := []byte("Test broadcast message")
-	h.Broadcast(testMessage, nil)
+	h.Broadcast(context.Background(), testMessage, nil)
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -287,7 +300,7 @@ func TestBroadcastToSelf(t *testing.T) {
 
 	// Broadcast message
 	testMessage := []byte("Self broadcast")
-	h.Broadcast(testMessage, nil)
+	h.Broadcast(context.Background(), testMessage, nil)
 
 	// Verify client receives its own broadcast
 	select {
@@ -331,7 +344,7 @@ func TestConcurrentBroadcasts(t *testing.T) {
 	for i := 0; i < numBroadcasts; i++ {
 		go func(id int) {
 			defer wg.Done()
-			h.Broadcast([]byte("message"), nil)
+			h.Broadcast(context.Background(), []byte("message"), nil)
 		}(i)
 	}
 
@@ -339,38 +352,4394 @@ func TestConcurrentBroadcasts(t *testing.T) {
 	// If we get here without panicking or deadlocking, the test passes
 }
 
-// BenchmarkBroadcast measures broadcast performance with varying client counts.
-func BenchmarkBroadcast(b *testing.B) {
-	clientCounts := []int{1, 10, 100}
+// TestSubscribeLines verifies applied operations produce line events for subscribers.
+func TestSubscribeLines(t *testing.T) {
+	h := NewHub()
+	go h.Run()
 
-	for _, numClients := range clientCounts {
-		b.Run(string(rune('0'+numClients)), func(b *testing.B) {
-			h := NewHub()
-			go h.Run()
+	doc := h.GetOrCreateDocument("code-doc")
+	doc.SetContent("package main\n\nfunc main() {}")
 
-			// Register clients
-			for i := 0; i < numClients; i++ {
-				client := &Client{
-					hub:        h,
-					conn:       nil,
-					send:       make(chan []byte, 256),
-					documentID: "test-doc",
+	events, cancel := h.SubscribeLines("code-doc")
+	defer cancel()
+
+	h.Broadcast(context.Background(), []byte(`{"type":"language","document_id":"code-doc","language":"go"}`), nil)
+	h.Broadcast(context.Background(), []byte(`{"type":"operation","document_id":"code-doc","operation":{"type":"insert","position":14,"text":"// a\n// b\n","version":1}}`), nil)
+
+	select {
+	case ev := <-events:
+		if ev.StartLine != 2 || ev.OldLines != 1 || ev.NewLines != 3 {
+			t.Errorf("line change = %+v, want start 2, old 1, new 3", ev.LineChange)
+		}
+		if ev.Language != "go" {
+			t.Errorf("language = %q, want %q", ev.Language, "go")
+		}
+		if ev.Version != 2 {
+			t.Errorf("version = %d, want 2", ev.Version)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not receive line event")
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("channel not closed after cancel")
+	}
+}
+
+// TestBlobRelay verifies chunked blobs are relayed, assembled, and served on request.
+func TestBlobRelay(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	sender := &Client{hub: h, send: make(chan []byte, 256), documentID: "blob-doc"}
+	peer := &Client{hub: h, send: make(chan []byte, 256), documentID: "blob-doc"}
+	h.Register(sender)
+	h.Register(peer)
+	time.Sleep(50 * time.Millisecond)
+	drainSystemMessages(t, sender.send)
+	drainSystemMessages(t, peer.send)
+
+	for i, part := range []string{"hello ", "world"} {
+		msg := NewBlobMessage("blob-doc", &BlobChunk{ID: "img1", Index: i, Total: 2, ContentType: "text/plain", Data: []byte(part)})
+		data, _ := msg.ToBytes()
+		h.Broadcast(context.Background(), data, sender)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-peer.send:
+		case <-time.After(time.Second):
+			t.Fatalf("peer did not receive chunk %d", i)
+		}
+	}
+
+	blob := h.GetDocument("blob-doc").GetBlob("img1")
+	if blob == nil || string(blob.Data) != "hello world" {
+		t.Fatalf("stored blob = %+v, want data %q", blob, "hello world")
+	}
+
+	h.Broadcast(context.Background(), []byte(`{"type":"blob_request","document_id":"blob-doc","blob":{"id":"img1"}}`), peer)
+	select {
+	case data := <-peer.send:
+		msg, err := MessageFromBytes(data)
+		if err != nil || msg.Blob == nil || string(msg.Blob.Data) != "hello world" {
+			t.Errorf("blob response = %s, want assembled blob", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no response to blob request")
+	}
+
+	overwrite, _ := NewBlobMessage("blob-doc", &BlobChunk{ID: "img1", Index: 0, Total: 1, Data: []byte("evil")}).ToBytes()
+	h.Broadcast(context.Background(), overwrite, peer)
+	select {
+	case data := <-peer.send:
+		if msg, err := MessageFromBytes(data); err != nil || msg.Type != MsgTypeError {
+			t.Errorf("overwrite reply = %s, want an error", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply to overwriting a blob")
+	}
+	if blob := h.GetDocument("blob-doc").GetBlob("img1"); string(blob.Data) != "hello world" {
+		t.Errorf("blob after overwrite = %q, want it kept", blob.Data)
+	}
+
+	oversized := &BlobChunk{ID: "big", Index: 0, Total: 1, Data: make([]byte, maxBlobChunkSize+1)}
+	if err := oversized.Validate(); err == nil {
+		t.Error("expected oversized chunk to be rejected")
+	}
+
+	assembler := blobAssembler{pending: make(map[string]*pendingBlob), clock: clock.Real}
+	for i := 0; i < maxPendingPerDoc; i++ {
+		if _, err := assembler.add("doc", &BlobChunk{ID: strconv.Itoa(i), Total: 2, Data: []byte("x")}); err != nil {
+			t.Fatalf("add(%d) error: %v", i, err)
+		}
+	}
+	if _, err := assembler.add("doc", &BlobChunk{ID: "one-too-many", Total: 2, Data: []byte("x")}); err == nil {
+		t.Error("blob past the pending limit accepted")
+	}
+	if _, err := assembler.add("other-doc", &BlobChunk{ID: "a", Total: 2, Data: []byte("x")}); err != nil {
+		t.Errorf("blob for another document refused: %v", err)
+	}
+}
+
+// TestDocumentMetadata verifies metadata changes reach watchers and clients.
+func TestDocumentMetadata(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	client := &Client{hub: h, send: make(chan []byte, 256), documentID: "meta-doc"}
+	h.Register(client)
+	time.Sleep(50 * time.Millisecond)
+	drainSystemMessages(t, client.send)
+
+	events, cancel := h.WatchMetadata("meta-doc")
+	defer cancel()
+
+	if err := h.SetDocumentMetadata("meta-doc", map[string]string{"status": "review"}); err != nil {
+		t.Fatalf("SetDocumentMetadata() error: %v", err)
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Changes["status"] != "review" {
+			t.Errorf("event changes = %v, want status=review", ev.Changes)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watcher did not receive metadata event")
+	}
+
+	select {
+	case data := <-client.send:
+		msg, _ := MessageFromBytes(data)
+		if msg.Type != MsgTypeMetadata || msg.Metadata["status"] != "review" {
+			t.Errorf("client received %s, want metadata change", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client did not receive metadata broadcast")
+	}
+
+	// Setting the same value again is not a change.
+	h.SetDocumentMetadata("meta-doc", map[string]string{"status": "review"})
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event for unchanged value: %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tooLong := strings.Repeat("k", maxMetadataKeyLength+1)
+	if err := h.SetDocumentMetadata("meta-doc", map[string]string{tooLong: "x"}); err == nil {
+		t.Error("expected error for oversized key")
+	}
+}
+
+// TestLatencyTracking verifies operations are timed per document and slow
+// ones raise a latency_alert event.
+func TestLatencyTracking(t *testing.T) {
+	h := NewHub()
+	h.SetLatencyThreshold(time.Nanosecond)
+	sink := make(chan events.Event, 16)
+	h.AddEventSink(sinkFunc(func(e events.Event) { sink <- e }))
+	go h.Run()
+	defer h.Shutdown()
+
+	h.Broadcast(context.Background(), []byte(`{"type":"operation","document_id":"slo-doc","operation":{"type":"insert","position":0,"text":"x","version":0}}`), nil)
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case e := <-sink:
+			if e.Type != events.TypeLatencyAlert {
+				continue
+			}
+			if e.DocumentID != "slo-doc" || e.LatencyMS <= 0 {
+				t.Errorf("alert = %+v", e)
+			}
+			if n := h.LatencyReport().Documents["slo-doc"].Count; n != 1 {
+				t.Errorf("observed %d operations, want 1", n)
+			}
+			return
+		case <-deadline:
+			t.Fatal("did not receive latency alert")
+		}
+	}
+}
+
+// sinkFunc adapts a function to events.Sink.
+type sinkFunc func(events.Event)
+
+func (f sinkFunc) Publish(e events.Event) { f(e) }
+
+// TestStats verifies documents are reported heaviest first with client counts.
+func TestStats(t *testing.T) {
+	h := NewHub()
+	h.GetOrCreateDocument("small").SetContent("hi")
+	h.GetOrCreateDocument("large").SetContent(strings.Repeat("x", 500))
+
+	h.mu.Lock()
+	h.clients[&Client{hub: h, send: make(chan []byte, 1), documentID: "small"}] = true
+	h.mu.Unlock()
+
+	stats := h.Stats()
+	if len(stats.Documents) != 2 {
+		t.Fatalf("got %d documents, want 2", len(stats.Documents))
+	}
+	if stats.Documents[0].ID != "large" || stats.Documents[1].ID != "small" {
+		t.Errorf("order = %s, %s; want large, small", stats.Documents[0].ID, stats.Documents[1].ID)
+	}
+	if stats.Documents[1].Clients != 1 || stats.Clients != 1 {
+		t.Errorf("clients = %d (total %d), want 1", stats.Documents[1].Clients, stats.Clients)
+	}
+	if stats.TotalMemoryBytes != stats.Documents[0].MemoryBytes+stats.Documents[1].MemoryBytes {
+		t.Errorf("total = %d, want sum of documents", stats.TotalMemoryBytes)
+	}
+}
+
+// TestCheckMemory verifies pressure levels compact history, evict idle
+// documents, and emit events, while documents with clients are kept.
+func TestCheckMemory(t *testing.T) {
+	var used uint64
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHub()
+	h.SetClock(fake)
+	h.SetMemoryController(pressure.NewControllerWithReader(
+		pressure.Config{High: 100, Critical: 200}, func() uint64 { return used }))
+
+	var got []events.Event
+	h.AddEventSink(sinkFunc(func(e events.Event) { got = append(got, e) }))
+
+	h.GetOrCreateDocument("idle").SetContent("x")
+	h.GetOrCreateDocument("busy").SetContent("y")
+	h.mu.Lock()
+	h.clients[&Client{hub: h, send: make(chan []byte, 1), documentID: "busy"}] = true
+	h.mu.Unlock()
+	got = nil
+
+	used = 150
+	if level := h.CheckMemory(); level != pressure.LevelHigh {
+		t.Fatalf("level = %s, want high", level)
+	}
+	if h.GetDocument("idle") == nil {
+		t.Error("recently modified document evicted under high pressure")
+	}
+
+	fake.Advance(idleEvictAfter)
+	h.CheckMemory()
+	if h.GetDocument("idle") != nil {
+		t.Error("idle document not evicted under high pressure")
+	}
+
+	h.GetOrCreateDocument("fresh").SetContent("z")
+	got = got[:len(got)-1] // document_created
+	used = 250
+	h.CheckMemory()
+	if h.GetDocument("fresh") != nil {
+		t.Error("document without clients not evicted under critical pressure")
+	}
+	if h.GetDocument("busy") == nil {
+		t.Error("document with clients evicted")
+	}
+
+	var types []events.Type
+	for _, e := range got {
+		types = append(types, e.Type)
+	}
+	want := []events.Type{
+		events.TypeMemoryPressure, events.TypeDocumentEvicted,
+		events.TypeMemoryPressure, events.TypeDocumentEvicted,
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("events = %v, want %v", types, want)
+	}
+}
+
+// TestClientPumps verifies ReadPump and WritePump over in-memory pipes:
+// operations are relayed between clients, pings follow the clock, and
+// closing the connection unregisters the client.
+func TestClientPumps(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHub()
+	h.SetClock(fake)
+	go h.Run()
+	defer h.Shutdown()
+
+	alice, bob := NewPipe(), NewPipe()
+	for _, conn := range []*Pipe{alice, bob} {
+		c := NewClient(h, conn, "pump-doc")
+		h.Register(c)
+		go c.WritePump()
+		go c.ReadPump()
+	}
+
+	op := `{"type":"operation","document_id":"pump-doc","operation":{"type":"insert","position":0,"text":"hi","version":0}}`
+	if err := alice.Send([]byte(op)); err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+
+	for {
+		frame, err := bob.Receive(time.Second)
+		if err != nil {
+			t.Fatalf("bob did not receive the operation: %v", err)
+		}
+		if strings.Contains(string(frame), `"type":"operation"`) {
+			break
+		}
+	}
+	if got := h.GetDocument("pump-doc").GetContent(); got != "hi" {
+		t.Errorf("content = %q, want %q", got, "hi")
+	}
+
+	for fake.TickerCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	fake.Advance(pingPeriod)
+	deadline := time.Now().Add(time.Second)
+	for alice.Pings() == 0 || bob.Pings() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("clients did not ping after one ping period")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	alice.Close()
+	deadline = time.Now().Add(time.Second)
+	for h.ClientCountForDocument("pump-doc") != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("closed client was not unregistered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestDeleteDocument verifies connected clients get a terminal message, edits
+// to the deleted ID are rejected, and archived documents stay readable.
+func TestDeleteDocument(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	readDeleted := func(t *testing.T, c *Client) *Message {
+		t.Helper()
+		for {
+			select {
+			case data, ok := <-c.Messages():
+				if !ok {
+					t.Fatal("client closed before document_deleted")
 				}
-				h.Register(client)
+				msg, err := MessageFromBytes(data)
+				if err == nil && msg.Type == MsgTypeDocumentDeleted {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no document_deleted message")
+			}
+		}
+	}
 
-				// Drain messages to prevent channel blocking
-				go func(c *Client) {
-					for range c.send {
-					}
-				}(client)
+	h.GetOrCreateDocument("gone").SetContent("draft")
+	editor := NewLocalClient(h, "gone", 16)
+	h.Register(editor)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := h.DeleteDocument("gone", false); err != nil {
+		t.Fatalf("DeleteDocument() error: %v", err)
+	}
+	if msg := readDeleted(t, editor); msg.ReadOnly || msg.Reason != DeleteReasonDeleted {
+		t.Errorf("terminal message = %+v", msg)
+	}
+	for range editor.Messages() {
+		// Drained until the hub disconnects the client.
+	}
+
+	h.Submit([]byte(`{"type":"operation","document_id":"gone","operation":{"type":"insert","position":0,"text":"x","version":0}}`), nil)
+	if h.GetDocument("gone") != nil {
+		t.Error("edit recreated the deleted document")
+	}
+
+	late := NewLocalClient(h, "gone", 16)
+	h.Register(late)
+	readDeleted(t, late)
+
+	h.GetOrCreateDocument("kept").SetContent("final text")
+	viewer := NewLocalClient(h, "kept", 16)
+	h.Register(viewer)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := h.DeleteDocument("kept", true); err != nil {
+		t.Fatalf("DeleteDocument() error: %v", err)
+	}
+	if msg := readDeleted(t, viewer); !msg.ReadOnly || msg.Content != "final text" {
+		t.Errorf("archive message = %+v", msg)
+	}
+	if h.ClientCountForDocument("kept") != 1 {
+		t.Error("archive viewer was disconnected")
+	}
+
+	h.RestoreDocument("gone")
+	h.Submit([]byte(`{"type":"operation","document_id":"gone","operation":{"type":"insert","position":0,"text":"x","version":0}}`), nil)
+	if doc := h.GetDocument("gone"); doc == nil || doc.GetContent() != "x" {
+		t.Error("restored ID did not accept a fresh edit")
+	}
+}
+
+// TestViewport verifies viewports are relayed with the sender's ID, throttled
+// to the newest update per interval, replayed to late joiners, and cleared
+// when the sender leaves.
+func TestViewport(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHub()
+	h.SetClock(fake)
+	go h.Run()
+	defer h.Shutdown()
+
+	nextViewport := func(t *testing.T, c *Client) *Viewport {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type == MsgTypeViewport {
+					return msg.Viewport
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no viewport message")
+			}
+		}
+	}
+	noViewport := func(t *testing.T, c *Client) {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type == MsgTypeViewport {
+					t.Fatalf("unexpected viewport %+v", msg.Viewport)
+				}
+			default:
+				return
 			}
+		}
+	}
+	send := func(c *Client, first, last int) {
+		h.Submit([]byte(fmt.Sprintf(`{"type":"viewport","document_id":"view-doc","viewport":{"client_id":"spoofed","first_line":%d,"last_line":%d}}`, first, last)), c)
+	}
 
-			time.Sleep(100 * time.Millisecond)
-			message := []byte("Benchmark message")
+	reader, watcher := NewLocalClient(h, "view-doc", 16), NewLocalClient(h, "view-doc", 16)
+	h.Register(reader)
+	h.Register(watcher)
 
-			b.ResetTimer()
-			for i := 0; i < b.N; i++ {
-				h.Broadcast(message, nil)
+	send(reader, 0, 40)
+	if vp := nextViewport(t, watcher); vp.ClientID != reader.ID() || vp.FirstLine != 0 || vp.LastLine != 40 {
+		t.Errorf("viewport = %+v, want lines 0-40 from client %s", vp, reader.ID())
+	}
+
+	send(reader, 10, 50)
+	send(reader, 20, 60)
+	send(reader, -1, 5)
+	noViewport(t, watcher)
+
+	fake.Advance(viewportInterval)
+	if vp := nextViewport(t, watcher); vp.FirstLine != 20 || vp.LastLine != 60 {
+		t.Errorf("throttled viewport = %+v, want the newest (lines 20-60)", vp)
+	}
+
+	late := NewLocalClient(h, "view-doc", 16)
+	h.Register(late)
+	if vp := nextViewport(t, late); vp.ClientID != reader.ID() || vp.FirstLine != 20 {
+		t.Errorf("late joiner got %+v, want reader's current viewport", vp)
+	}
+
+	h.Unregister(reader)
+	if vp := nextViewport(t, watcher); vp.ClientID != reader.ID() || !vp.Left {
+		t.Errorf("after leave got %+v, want left notice", vp)
+	}
+}
+
+// TestSchemaByPrefix verifies new documents get the schema of the longest
+// matching prefix and the hub drops edits that break it.
+func TestSchemaByPrefix(t *testing.T) {
+	h := NewHub()
+	h.SetSchema("notes-", schema.TitleBody)
+	h.SetSchema("notes-raw-", &schema.Schema{Name: "free"})
+	go h.Run()
+	defer h.Shutdown()
+
+	if got := h.GetOrCreateDocument("notes-1").GetSchema(); got != schema.TitleBody {
+		t.Errorf("notes-1 schema = %v, want title-body", got)
+	}
+	if got := h.GetOrCreateDocument("notes-raw-1").GetSchema(); got == nil || got.Name != "free" {
+		t.Errorf("notes-raw-1 schema = %v, want free", got)
+	}
+	if got := h.GetOrCreateDocument("other").GetSchema(); got != nil {
+		t.Errorf("other schema = %v, want none", got)
+	}
+
+	h.Submit([]byte(`{"type":"content","document_id":"notes-1","content":"# Not a title"}`), nil)
+	h.Submit([]byte(`{"type":"operation","document_id":"notes-1","operation":{"type":"insert","position":0,"text":"**x**","version":0}}`), nil)
+	if content := h.GetDocument("notes-1").GetContent(); content != "" {
+		t.Errorf("content = %q, want schema-breaking edits rejected", content)
+	}
+}
+
+// TestSyncModeCoalesced verifies a coalesced client gets remote operations
+// batched once per interval, ahead of any later message, while realtime
+// clients are unaffected.
+func TestSyncModeCoalesced(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHub()
+	h.SetClock(fake)
+	go h.Run()
+	defer h.Shutdown()
+
+	next := func(t *testing.T, c *Client, want MessageType) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				msg, err := MessageFromBytes(data)
+				if err != nil || msg.Type == MsgTypeUserCount || msg.Type == MsgTypeWelcome || msg.Type == MsgTypeSync {
+					continue
+				}
+				if msg.Type != want {
+					t.Fatalf("got %s message, want %s", msg.Type, want)
+				}
+				return msg
+			case <-time.After(time.Second):
+				t.Fatalf("no %s message", want)
+			}
+		}
+	}
+	none := func(t *testing.T, c *Client) {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type != MsgTypeUserCount && msg.Type != MsgTypeWelcome && msg.Type != MsgTypeSync {
+					t.Fatalf("unexpected %s message", msg.Type)
+				}
+			default:
+				return
+			}
+		}
+	}
+	insert := func(c *Client, text string) {
+		h.Submit([]byte(`{"type":"operation","document_id":"slow-doc","operation":{"type":"insert","position":0,"text":"`+text+`","version":0}}`), c)
+	}
+
+	editor := NewLocalClient(h, "slow-doc", 16)
+	slow := NewLocalClient(h, "slow-doc", 16)
+	h.Register(editor)
+	h.Register(slow)
+
+	h.Submit([]byte(`{"type":"sync_mode","document_id":"slow-doc","sync":{"mode":"coalesced","interval_ms":5}}`), slow)
+	if granted := next(t, slow, MsgTypeSyncMode).Sync; granted.Mode != SyncCoalesced || granted.IntervalMS != 100 {
+		t.Errorf("granted %+v, want coalesced at the 100ms minimum", granted)
+	}
+	if stats := h.Stats(); stats.Documents[0].Coalesced != 1 {
+		t.Errorf("coalesced clients = %d, want 1", stats.Documents[0].Coalesced)
+	}
+
+	insert(editor, "a")
+	insert(editor, "b")
+	insert(editor, "c")
+	none(t, slow)
+
+	fake.Advance(minCoalesceInterval)
+	batch := next(t, slow, MsgTypeOperationBatch)
+	if len(batch.Operations) != 3 || batch.Operations[0].Version != 1 || batch.Operations[2].Version != 3 {
+		t.Fatalf("batch = %+v, want versions 1-3", batch.Operations)
+	}
+
+	insert(editor, "d")
+	h.Submit([]byte(`{"type":"metadata_set","document_id":"slow-doc","metadata":{"title":"x"}}`), editor)
+	if batch := next(t, slow, MsgTypeOperationBatch); len(batch.Operations) != 1 || batch.Operations[0].Version != 4 {
+		t.Errorf("batch before metadata = %+v, want version 4", batch.Operations)
+	}
+	next(t, slow, MsgTypeMetadata)
+
+	h.Submit([]byte(`{"type":"sync_mode","document_id":"slow-doc","sync":{"mode":"realtime"}}`), slow)
+	next(t, slow, MsgTypeSyncMode)
+	insert(editor, "e")
+	next(t, slow, MsgTypeOperation)
+}
+
+// TestContentDeduplication verifies repeated identical content and legacy
+// messages are neither applied nor relayed again.
+func TestContentDeduplication(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	watcher := NewLocalClient(h, "dup-doc", 16)
+	h.Register(watcher)
+
+	content := []byte(`{"type":"content","document_id":"dup-doc","content":"same"}`)
+	for i := 0; i < 3; i++ {
+		h.Submit(content, nil)
+		h.Submit([]byte("legacy text"), nil)
+	}
+	h.Submit([]byte("other legacy text"), nil)
+
+	if version := h.GetDocument("dup-doc").GetVersion(); version != 1 {
+		t.Errorf("version = %d, want 1", version)
+	}
+
+	counts := make(map[string]int)
+	for len(watcher.Messages()) > 0 {
+		data := <-watcher.Messages()
+		msg, err := MessageFromBytes(data)
+		switch {
+		case err != nil:
+			counts[string(data)]++
+		case msg.Type == MsgTypeContent:
+			counts[msg.Content]++
+		}
+	}
+	want := map[string]int{"same": 1, "legacy text": 1, "other legacy text": 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("relayed %v, want %v", counts, want)
+	}
+}
+
+// TestPauseDocument verifies paused documents hold or reject edits, clients
+// are told, and resuming replays held edits in order.
+func TestPauseDocument(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	next := func(t *testing.T, c *Client, want MessageType) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type == want {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("no %s message", want)
+			}
+		}
+	}
+	insert := func(text string, pos, version int) {
+		h.Submit([]byte(fmt.Sprintf(`{"type":"operation","document_id":"held-doc","operation":{"type":"insert","position":%d,"text":"%s","version":%d}}`, pos, text, version)), nil)
+	}
+
+	editor := NewLocalClient(h, "held-doc", 16)
+	h.Register(editor)
+
+	if err := h.PauseDocument("held-doc", PauseOptions{Reason: "maintenance", Queue: true}); err != nil {
+		t.Fatalf("PauseDocument() error: %v", err)
+	}
+	if msg := next(t, editor, MsgTypeDocumentPaused); msg.Reason != "maintenance" {
+		t.Errorf("paused message = %+v", msg)
+	}
+	insert("a", 0, 0)
+	insert("b", 1, 1)
+	if doc := h.GetDocument("held-doc"); doc != nil && doc.GetContent() != "" {
+		t.Errorf("content = %q while paused, want edits held", doc.GetContent())
+	}
+	if _, err := h.ReplaceContent("held-doc", "api", 0); !errors.Is(err, ErrDocumentPaused) {
+		t.Errorf("ReplaceContent() error = %v, want ErrDocumentPaused", err)
+	}
+
+	late := NewLocalClient(h, "held-doc", 16)
+	h.Register(late)
+	next(t, late, MsgTypeDocumentPaused)
+
+	if err := h.ResumeDocument("held-doc"); err != nil {
+		t.Fatalf("ResumeDocument() error: %v", err)
+	}
+	next(t, editor, MsgTypeDocumentResumed)
+	if got := h.GetDocument("held-doc").GetContent(); got != "ab" {
+		t.Errorf("content after resume = %q, want %q", got, "ab")
+	}
+	if err := h.ResumeDocument("held-doc"); !errors.Is(err, ErrNotPaused) {
+		t.Errorf("second ResumeDocument() error = %v, want ErrNotPaused", err)
+	}
+
+	h.PauseDocument("held-doc", PauseOptions{Reason: "abuse"})
+	next(t, editor, MsgTypeDocumentPaused)
+	h.Submit([]byte(`{"type":"operation","document_id":"held-doc","operation":{"type":"insert","position":0,"text":"x","version":0}}`), editor)
+	if msg := next(t, editor, MsgTypeDocumentPaused); msg.RetryAfterMS != int(defaultRetryAfter/time.Millisecond) {
+		t.Errorf("rejection = %+v, want retry hint", msg)
+	}
+	if !h.IsPaused("held-doc") || h.GetDocument("held-doc").GetContent() != "ab" {
+		t.Error("rejected edit was applied")
+	}
+}
+
+// TestEphemeralMessages verifies ephemeral messages are relayed with their
+// custom fields and an expiry, never touch document state or events, and are
+// dropped once stale.
+func TestEphemeralMessages(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	h := NewHub()
+	h.SetClock(fake)
+	var emitted []events.Event
+	h.AddEventSink(sinkFunc(func(e events.Event) { emitted = append(emitted, e) }))
+	go h.Run()
+	defer h.Shutdown()
+
+	watcher := NewLocalClient(h, "eph-doc", 16)
+	h.Register(watcher)
+	h.do(func() { emitted = nil }) // drop user_joined
+
+	typing := []byte(`{"type":"typing","document_id":"eph-doc","ephemeral":true,"ttl_ms":2000,"user":"ann"}`)
+	h.Submit(typing, nil)
+	h.do(func() {
+		h.handleBroadcast(&broadcastMessage{message: typing, received: start.Add(-3 * time.Second)})
+	})
+	h.Submit([]byte(`{"type":"operation","document_id":"eph-doc","ephemeral":true,"operation":{"type":"insert","position":0,"text":"x","version":0}}`), nil)
+
+	var relayed []map[string]any
+	for len(watcher.Messages()) > 0 {
+		var fields map[string]any
+		if err := json.Unmarshal(<-watcher.Messages(), &fields); err == nil && fields["type"] == "typing" {
+			relayed = append(relayed, fields)
+		}
+	}
+	if len(relayed) != 1 {
+		t.Fatalf("relayed %d typing messages, want 1 (stale one dropped)", len(relayed))
+	}
+	if relayed[0]["user"] != "ann" || relayed[0]["expires_at"] != float64(start.Add(2*time.Second).UnixMilli()) {
+		t.Errorf("relayed %v, want custom field kept and expiry set", relayed[0])
+	}
+	if h.GetDocument("eph-doc") != nil {
+		t.Error("ephemeral messages created the document")
+	}
+	h.do(func() {
+		if len(emitted) != 0 {
+			t.Errorf("emitted %v, want no events", emitted)
+		}
+	})
+}
+
+// TestMessageStamp verifies outbound JSON messages carry the server time and
+// the document version, and legacy messages pass through unchanged.
+func TestMessageStamp(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHub()
+	h.SetClock(clock.NewFake(start))
+	doc := h.GetOrCreateDocument("stamp-doc")
+	doc.SetContent("hello")
+	now := strconv.FormatInt(start.UnixMilli(), 10)
+	version := strconv.Itoa(doc.GetVersion())
+
+	tests := []struct {
+		name       string
+		documentID string
+		in         string
+		want       string
+	}{
+		{"loaded document", "stamp-doc", `{"type":"content"}`, `{"server_time":` + now + `,"version":` + version + `,"type":"content"}`},
+		{"unknown document", "other", `{"type":"content"}`, `{"server_time":` + now + `,"type":"content"}`},
+		{"empty object", "other", `{}`, `{"server_time":` + now + `}`},
+		{"legacy text", "stamp-doc", `plain text`, `plain text`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.mu.RLock()
+			got := string(h.stamp([]byte(tt.in), tt.documentID))
+			h.mu.RUnlock()
+			if got != tt.want {
+				t.Errorf("stamp(%s) = %s, want %s", tt.in, got, tt.want)
+			}
+			if !IsLegacyContent([]byte(tt.in)) && !json.Valid([]byte(got)) {
+				t.Errorf("stamp(%s) produced invalid JSON", tt.in)
+			}
+		})
+	}
+
+	go h.Run()
+	defer h.Shutdown()
+	watcher := NewLocalClient(h, "stamp-doc", 16)
+	h.Register(watcher)
+	h.Submit([]byte(`{"type":"operation","document_id":"stamp-doc","operation":{"type":"insert","position":5,"text":"!","version":1}}`), nil)
+	for len(watcher.Messages()) > 0 {
+		msg, err := MessageFromBytes(<-watcher.Messages())
+		if err != nil || msg.Type != MsgTypeOperation {
+			continue
+		}
+		if msg.ServerTime != start.UnixMilli() || msg.Version != doc.GetVersion() {
+			t.Errorf("operation stamped (%d, %d), want (%d, %d)", msg.ServerTime, msg.Version, start.UnixMilli(), doc.GetVersion())
+		}
+		return
+	}
+	t.Error("watcher received no operation")
+}
+
+// TestWelcomeCapabilities verifies a connecting client first receives the
+// hub's capabilities, reflecting optional features as they are enabled.
+func TestWelcomeCapabilities(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	welcome := func(t *testing.T) *Capabilities {
+		t.Helper()
+		c := NewLocalClient(h, "caps-doc", 16)
+		h.Register(c)
+		defer h.Unregister(c)
+		msg, err := MessageFromBytes(<-c.Messages())
+		if err != nil || msg.Type != MsgTypeWelcome || msg.Capabilities == nil {
+			t.Fatalf("first message = %+v (%v), want welcome with capabilities", msg, err)
+		}
+		return msg.Capabilities
+	}
+
+	caps := welcome(t)
+	if caps.MaxMessageSize != maxMessageSize {
+		t.Errorf("max message size = %d, want %d", caps.MaxMessageSize, maxMessageSize)
+	}
+	if !reflect.DeepEqual(caps.MessageTypes, clientMessageTypes) {
+		t.Errorf("message types = %v, want %v", caps.MessageTypes, clientMessageTypes)
+	}
+	if caps.Supports(FeatureSchemas) || caps.Supports(FeatureAttachments) {
+		t.Errorf("features = %v, want schemas and attachments disabled", caps.Features)
+	}
+	if !caps.Supports(FeatureSyncModes) {
+		t.Errorf("features = %v, want %s", caps.Features, FeatureSyncModes)
+	}
+
+	h.SetSchema("notes/", schema.TitleBody)
+	if caps := welcome(t); !caps.Supports(FeatureSchemas) {
+		t.Errorf("features = %v, want %s after SetSchema", caps.Features, FeatureSchemas)
+	}
+}
+
+// TestOperationAck verifies operations with an ID are acknowledged to their
+// sender, and a resubmitted ID is acknowledged again without being applied
+// or relayed twice.
+func TestOperationAck(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	author := NewLocalClient(h, "ack-doc", 16)
+	watcher := NewLocalClient(h, "ack-doc", 16)
+	h.Register(author)
+	h.Register(watcher)
+
+	op := []byte(`{"type":"operation","document_id":"ack-doc","operation":{"type":"insert","position":0,"text":"hi","version":0,"id":"op-1"}}`)
+	h.Submit(op, author)
+	h.Submit(op, author) // resubmitted after a reconnect
+
+	var acks []*Message
+	for len(author.Messages()) > 0 {
+		if msg, err := MessageFromBytes(<-author.Messages()); err == nil && msg.Type == MsgTypeAck {
+			acks = append(acks, msg)
+		}
+	}
+	if len(acks) != 2 || acks[0].AckID != "op-1" || acks[0].Version != 1 || acks[1].Version != 1 {
+		t.Errorf("acks = %+v, want two for op-1 at version 1", acks)
+	}
+
+	relayed := 0
+	for len(watcher.Messages()) > 0 {
+		if msg, err := MessageFromBytes(<-watcher.Messages()); err == nil && msg.Type == MsgTypeOperation {
+			relayed++
+		}
+	}
+	if relayed != 1 {
+		t.Errorf("watcher received %d operations, want 1", relayed)
+	}
+	if got := h.GetDocument("ack-doc").GetContent(); got != "hi" {
+		t.Errorf("content = %q, want %q", got, "hi")
+	}
+}
+
+// TestResync verifies a reconnecting client receives the operations it
+// missed only when its version is stale, and the current content once
+// those operations are no longer retained.
+func TestResync(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	doc := h.GetOrCreateDocument("resync-doc")
+	doc.SetContent("hello")
+	doc.SetContent("hello world") // version 2
+
+	tests := []struct {
+		name     string
+		version  int
+		replica  string
+		truncate bool
+		want     MessageType
+	}{
+		{"current", 2, "hello world", false, ""},
+		{"stale", 1, "hello", false, MsgTypeOperationBatch},
+		{"fresh replica", 0, "", false, MsgTypeOperationBatch},
+		{"compacted", 0, "", true, MsgTypeContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.truncate {
+				doc.TruncateHistory(1)
+			}
+			c := NewLocalClient(h, "resync-doc", 16)
+			h.Register(c)
+			defer h.Unregister(c)
+
+			h.Submit([]byte(fmt.Sprintf(`{"type":"resync","document_id":"resync-doc","version":%d}`, tt.version)), c)
+			var got *Message
+			for len(c.Messages()) > 0 {
+				if msg, err := MessageFromBytes(<-c.Messages()); err == nil && (msg.Type == MsgTypeContent || msg.Type == MsgTypeOperationBatch) {
+					got = msg
+				}
+			}
+			if got == nil {
+				if tt.want != "" {
+					t.Fatalf("no resync reply, want %s", tt.want)
+				}
+				return
+			}
+			if got.Type != tt.want {
+				t.Fatalf("resync reply %s, want %s", got.Type, tt.want)
+			}
+			replica := got.Content
+			if got.Type == MsgTypeOperationBatch {
+				replica = tt.replica
+				for _, op := range got.Operations {
+					var err error
+					if replica, err = operations.Apply(replica, op); err != nil {
+						t.Fatalf("applying resync operation at version %d: %v", op.Version, err)
+					}
+				}
+			}
+			if replica != "hello world" || got.Version != 2 {
+				t.Errorf("resync = (%q, %d), want (%q, 2)", replica, got.Version, "hello world")
+			}
+		})
+	}
+}
+
+// TestHistoricalSession verifies a read-only session receives its version's
+// content, is isolated from live edits, cannot edit, and shares viewports
+// only with sessions on the same version.
+func TestHistoricalSession(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	doc := h.GetOrCreateDocument("rev-doc")
+	doc.SetContent("first draft")
+	doc.SetContent("second draft")
+
+	live := NewLocalClient(h, "rev-doc", 16)
+	reviewer := NewHistoricalClient(h, nil, "rev-doc", 1)
+	reviewer.send = make(chan []byte, 16)
+	peer := NewHistoricalClient(h, nil, "rev-doc", 1)
+	peer.send = make(chan []byte, 16)
+	other := NewHistoricalClient(h, nil, "rev-doc", 2)
+	other.send = make(chan []byte, 16)
+	for _, c := range []*Client{live, reviewer, peer, other} {
+		h.Register(c)
+	}
+	h.do(func() {}) // wait for the registrations
+
+	received := func(c *Client) []*Message {
+		var msgs []*Message
+		for len(c.Messages()) > 0 {
+			msg, err := MessageFromBytes(<-c.Messages())
+			if err == nil && msg.Type != MsgTypeUserCount && msg.Type != MsgTypeWelcome {
+				msgs = append(msgs, msg)
+			}
+		}
+		return msgs
+	}
+	snapshot := received(reviewer)
+	if len(snapshot) != 1 || snapshot[0].Content != "first draft" || snapshot[0].Version != 1 || !snapshot[0].ReadOnly {
+		t.Fatalf("reviewer received %+v, want read-only snapshot of version 1", snapshot)
+	}
+	received(peer)
+	received(other)
+	received(live)
+
+	h.Submit([]byte(`{"type":"operation","document_id":"rev-doc","operation":{"type":"insert","position":0,"text":"x","version":2}}`), reviewer)
+	h.Submit([]byte(`{"type":"content","document_id":"rev-doc","content":"overwritten"}`), reviewer)
+	if got := doc.GetContent(); got != "second draft" {
+		t.Errorf("content = %q after read-only edits, want %q", got, "second draft")
+	}
+
+	h.Submit([]byte(`{"type":"operation","document_id":"rev-doc","operation":{"type":"insert","position":0,"text":"x","version":2}}`), live)
+	if msgs := received(reviewer); len(msgs) != 0 {
+		t.Errorf("reviewer received live traffic %+v", msgs)
+	}
+
+	h.Submit([]byte(`{"type":"viewport","document_id":"rev-doc","viewport":{"first_line":0,"last_line":3}}`), reviewer)
+	if msgs := received(peer); len(msgs) != 1 || msgs[0].Viewport == nil || msgs[0].Viewport.ClientID != reviewer.ID() {
+		t.Errorf("peer received %+v, want reviewer's viewport", msgs)
+	}
+	if msgs := received(other); len(msgs) != 0 {
+		t.Errorf("session on another version received %+v", msgs)
+	}
+	if msgs := received(live); len(msgs) != 0 {
+		t.Errorf("live client received %+v", msgs)
+	}
+}
+
+// TestDocumentFeatures verifies that disabling a feature for one document
+// drops messages needing it, updates connected clients' capabilities and
+// leaves other documents alone.
+func TestDocumentFeatures(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	next := func(t *testing.T, c *Client, want MessageType) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type == want {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("no %s message", want)
+			}
+		}
+	}
+
+	viewer := NewLocalClient(h, "quiet-doc", 16)
+	peer := NewLocalClient(h, "quiet-doc", 16)
+	h.Register(viewer)
+	h.Register(peer)
+	next(t, viewer, MsgTypeWelcome)
+
+	if err := h.SetDocumentFeatures("quiet-doc", map[string]bool{"comments": false}); !errors.Is(err, ErrUnknownFeature) {
+		t.Errorf("SetDocumentFeatures(comments) error = %v, want ErrUnknownFeature", err)
+	}
+	if err := h.SetDocumentFeatures("quiet-doc", map[string]bool{FeaturePresence: false, FeatureBlobs: false}); err != nil {
+		t.Fatalf("SetDocumentFeatures() error: %v", err)
+	}
+	caps := next(t, viewer, MsgTypeCapabilities).Capabilities
+	if caps == nil || caps.Supports(FeaturePresence) || caps.Supports(FeatureBlobs) || !caps.Supports(FeatureMetadata) {
+		t.Errorf("capabilities = %+v, want presence and blobs disabled", caps)
+	}
+	if slices.Contains(caps.MessageTypes, MsgTypeViewport) {
+		t.Error("viewport still advertised")
+	}
+	if got := h.DocumentFeatures("quiet-doc"); got[FeaturePresence] || !got[FeatureMetadata] {
+		t.Errorf("DocumentFeatures() = %v", got)
+	}
+
+	h.Submit([]byte(`{"type":"viewport","document_id":"quiet-doc","viewport":{"first_line":0,"last_line":10}}`), peer)
+	h.Submit([]byte(`{"type":"metadata_get","document_id":"quiet-doc"}`), viewer)
+	for msg := (*Message)(nil); msg == nil || msg.Type != MsgTypeMetadata; {
+		select {
+		case data := <-viewer.Messages():
+			msg, _ = MessageFromBytes(data)
+			if msg.Type == MsgTypeViewport {
+				t.Error("viewport relayed with presence disabled")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no metadata reply")
+		}
+	}
+	late := NewLocalClient(h, "quiet-doc", 16)
+	h.Register(late)
+	if caps := next(t, late, MsgTypeWelcome).Capabilities; caps.Supports(FeaturePresence) {
+		t.Error("welcome advertises disabled presence")
+	}
+	if !h.Capabilities().Supports(FeaturePresence) {
+		t.Error("hub-wide capabilities lost presence")
+	}
+
+	h.SetDocumentFeatures("quiet-doc", map[string]bool{FeaturePresence: true})
+	if caps := next(t, viewer, MsgTypeCapabilities).Capabilities; !caps.Supports(FeaturePresence) {
+		t.Error("presence not re-enabled")
+	}
+}
+
+// TestRedact verifies that redaction forces editors and historical viewers
+// of the document to resync onto scrubbed content.
+func TestRedact(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	doc := h.GetOrCreateDocument("leak-doc")
+	doc.SetContent("db: postgres")
+	doc.SetContent("db: postgres password=hunter2")
+
+	editor := NewLocalClient(h, "leak-doc", 16)
+	viewer := NewHistoricalClient(h, nil, "leak-doc", 2)
+	viewer.send = make(chan []byte, 16)
+	h.Register(editor)
+	h.Register(viewer)
+	h.do(func() {}) // wait for the registrations
+	for _, c := range []*Client{editor, viewer} {
+		for len(c.Messages()) > 0 {
+			<-c.Messages()
+		}
+	}
+
+	version, err := h.Redact("leak-doc", document.Redaction{Pattern: regexp.MustCompile(`password=\S+`)})
+	if err != nil || version != 3 {
+		t.Fatalf("Redact() = %d, %v; want version 3", version, err)
+	}
+	for name, c := range map[string]*Client{"editor": editor, "viewer": viewer} {
+		select {
+		case data := <-c.Messages():
+			msg, _ := MessageFromBytes(data)
+			if msg.Type != MsgTypeContent || msg.Content != "db: postgres [REDACTED]" {
+				t.Errorf("%s received %+v, want redacted content", name, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s was not resynced", name)
+		}
+	}
+	if _, err := h.Redact("missing-doc", document.Redaction{Pattern: regexp.MustCompile(`x`)}); err == nil {
+		t.Error("redacting a missing document succeeded")
+	}
+}
+
+// TestEraseUser verifies that a user ID is removed or anonymized in every
+// document's metadata, and removed from access lists, and that clients see
+// the change.
+func TestEraseUser(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	h.SetDocumentMetadata("doc-a", map[string]string{"owner": "u-42", "title": "Plan"})
+	h.SetDocumentMetadata("doc-b", map[string]string{"reviewer": "u-42", "editor": "u-42", "owner": "u-7"})
+	h.SetDocumentMetadata("doc-c", map[string]string{"owner": "u-7"})
+	h.SetRoles("doc-c", map[string]document.Role{"u-42": document.RoleViewer})
+
+	client := NewLocalClient(h, "doc-b", 16)
+	h.Register(client)
+	h.do(func() {}) // wait for the registration
+	for len(client.Messages()) > 0 {
+		<-client.Messages()
+	}
+
+	report, err := h.EraseUser("u-42", "")
+	if err != nil {
+		t.Fatalf("EraseUser() error: %v", err)
+	}
+	if !reflect.DeepEqual(report.Documents, []string{"doc-a", "doc-b", "doc-c"}) || report.MetadataKeys != 3 || report.Access != 1 || report.Anonymized {
+		t.Errorf("report = %+v", report)
+	}
+	if got := h.GetDocument("doc-b").Metadata(); !reflect.DeepEqual(got, map[string]string{"owner": "u-7"}) {
+		t.Errorf("doc-b metadata = %v", got)
+	}
+	select {
+	case data := <-client.Messages():
+		if msg, _ := MessageFromBytes(data); msg.Type != MsgTypeMetadata || len(msg.Metadata) != 2 {
+			t.Errorf("client received %+v, want removal of two keys", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client not notified")
+	}
+
+	report, _ = h.EraseUser("u-7", "deleted-user")
+	if report.MetadataKeys != 2 || !report.Anonymized {
+		t.Errorf("anonymize report = %+v", report)
+	}
+	if owner, _ := h.GetDocument("doc-c").GetMetadata("owner"); owner != "deleted-user" {
+		t.Errorf("doc-c owner = %q, want anonymized", owner)
+	}
+}
+
+// TestReadOnlyClient verifies that a read-only live session is told it may
+// not edit, receives updates and has its edits rejected, and that
+// visibility changes are reported as events.
+func TestReadOnlyClient(t *testing.T) {
+	h := NewHub()
+	var emitted []events.Event
+	h.AddEventSink(sinkFunc(func(e events.Event) { emitted = append(emitted, e) }))
+	go h.Run()
+	defer h.Shutdown()
+
+	token, err := h.SetVisibility("pub-doc", document.VisibilityPublic)
+	if err != nil || token == "" {
+		t.Fatalf("SetVisibility() = %q, %v; want a link token", token, err)
+	}
+	if _, err := h.SetVisibility("pub-doc", "secret"); err == nil {
+		t.Error("unknown visibility accepted")
+	}
+
+	reader := NewReadOnlyClient(h, nil, "pub-doc")
+	reader.send = make(chan []byte, 16)
+	h.Register(reader)
+	h.do(func() {}) // wait for the registration
+	welcome, _ := MessageFromBytes(<-reader.Messages())
+	if welcome.Type != MsgTypeWelcome || !welcome.ReadOnly {
+		t.Errorf("welcome = %+v, want read-only", welcome)
+	}
+
+	h.Submit([]byte(`{"type":"operation","document_id":"pub-doc","operation":{"type":"insert","position":0,"text":"x","version":0,"id":"op-1"}}`), reader)
+	for msg := (*Message)(nil); msg == nil || msg.Type != MsgTypeRejected; {
+		select {
+		case data := <-reader.Messages():
+			msg, _ = MessageFromBytes(data)
+			if msg.Type == MsgTypeRejected && (msg.AckID != "op-1" || msg.Reason != ErrReadOnly.Error()) {
+				t.Errorf("rejection = %+v, want op-1 refused as read-only", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("read-only session not told its edit was rejected")
+		}
+	}
+	h.Submit([]byte(`{"type":"content","document_id":"pub-doc","content":"defaced"}`), reader)
+	if got := h.GetDocument("pub-doc").GetContent(); got != "" {
+		t.Errorf("content = %q, want read-only edits refused", got)
+	}
+	h.Submit([]byte(`{"type":"operation","document_id":"pub-doc","operation":{"type":"insert","position":0,"text":"hi","version":0}}`), nil)
+	for msg := (*Message)(nil); msg == nil || msg.Type != MsgTypeOperation; {
+		select {
+		case data := <-reader.Messages():
+			msg, _ = MessageFromBytes(data)
+		case <-time.After(time.Second):
+			t.Fatal("read-only session missed an update")
+		}
+	}
+
+	var changes []string
+	h.do(func() {
+		for _, e := range emitted {
+			if e.Type == events.TypeVisibilityChanged {
+				changes = append(changes, e.Detail)
+			}
+		}
+	})
+	if !reflect.DeepEqual(changes, []string{"public"}) {
+		t.Errorf("visibility events = %v, want [public]", changes)
+	}
+}
+
+// TestInvites verifies invite redemption limits and that collaborators are
+// told who joined.
+func TestInvites(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	h := NewHub()
+	h.SetClock(fake)
+	go h.Run()
+	defer h.Shutdown()
+
+	watcher := NewLocalClient(h, "team-doc", 16)
+	h.Register(watcher)
+	h.do(func() {}) // wait for the registration
+	for len(watcher.Messages()) > 0 {
+		<-watcher.Messages()
+	}
+
+	if _, err := h.CreateInvite("team-doc", "owner", 0, 0); err == nil {
+		t.Error("unknown role accepted")
+	}
+	inv, err := h.CreateInvite("team-doc", document.RoleEditor, time.Hour, 1)
+	if err != nil {
+		t.Fatalf("CreateInvite() error: %v", err)
+	}
+	accessToken, role, err := h.RedeemInvite("team-doc", inv.Token, "Carol")
+	if err != nil || accessToken == "" || role != document.RoleEditor {
+		t.Fatalf("RedeemInvite() = %q, %q, %v", accessToken, role, err)
+	}
+	if grant, ok := h.GetDocument("team-doc").GrantFor(accessToken); !ok || grant.Name != "Carol" {
+		t.Errorf("GrantFor() = %+v, %v; want Carol", grant, ok)
+	}
+	select {
+	case data := <-watcher.Messages():
+		msg, _ := MessageFromBytes(data)
+		if msg.Type != MsgTypeMemberJoined || msg.Member == nil || msg.Member.Name != "Carol" || msg.Member.Via != "invite" {
+			t.Errorf("watcher received %+v, want Carol joined via invite", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no member_joined message")
+	}
+
+	if _, _, err := h.RedeemInvite("team-doc", inv.Token, "Dave"); !errors.Is(err, document.ErrInviteUsedUp) {
+		t.Errorf("second redemption error = %v, want ErrInviteUsedUp", err)
+	}
+	timed, _ := h.CreateInvite("team-doc", document.RoleViewer, time.Minute, 0)
+	fake.Advance(time.Minute)
+	if _, _, err := h.RedeemInvite("team-doc", timed.Token, "Erin"); !errors.Is(err, document.ErrInviteExpired) {
+		t.Errorf("expired redemption error = %v, want ErrInviteExpired", err)
+	}
+	if _, _, err := h.RedeemInvite("team-doc", "bogus", "Frank"); !errors.Is(err, document.ErrInviteNotFound) {
+		t.Errorf("unknown token error = %v, want ErrInviteNotFound", err)
+	}
+}
+
+// TestAbuseScoring verifies per-client statistics and that a misbehaving
+// client is throttled, released as its score decays, then disconnected.
+func TestAbuseScoring(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	h := NewHub()
+	h.SetClock(fake)
+	h.SetAbusePolicy(AbusePolicy{
+		MaxMessagesPerSecond: 10,
+		HalfLife:             10 * time.Second,
+		ThrottleScore:        6,
+		DisconnectScore:      12,
+	})
+	var emitted []events.Event
+	h.AddEventSink(sinkFunc(func(e events.Event) { emitted = append(emitted, e) }))
+	go h.Run()
+	defer h.Shutdown()
+
+	c := NewLocalClient(h, "abuse-doc", 256)
+	h.Register(c)
+	h.do(func() {}) // wait for the registration
+
+	cursor := []byte(`{"type":"cursor","document_id":"abuse-doc"}`)
+	sent := 0
+	submit := func(data []byte) {
+		sent += len(data)
+		h.Submit(data, c)
+	}
+	for i := 0; i < 3; i++ {
+		submit(cursor)
+	}
+	// JSON that is not a message scores 5 and a failed edit 2, which
+	// crosses the throttle threshold; the next message is dropped.
+	submit([]byte(`{"type":5}`))
+	submit([]byte(`{"type":"operation","document_id":"abuse-doc","operation":{"type":"delete","position":5,"length":3,"version":0}}`))
+	submit(cursor)
+
+	fake.Advance(time.Second)
+	submit(cursor) // closes the first rate window; still throttled
+
+	infos, err := h.Clients()
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("Clients() = %+v, %v", infos, err)
+	}
+	info := infos[0]
+	if info.ID != c.ID() || info.Messages != 7 || info.Bytes != sent || info.MessagesPerSecond != 6 ||
+		info.ParseErrors != 1 || info.Rejected != 1 || info.Dropped != 2 || !info.Throttled {
+		t.Errorf("ClientInfo = %+v", info)
+	}
+	if !info.Connected.Equal(start) {
+		t.Errorf("Connected = %v, want %v", info.Connected, start)
+	}
+
+	var throttled *Message
+	for len(c.Messages()) > 0 {
+		if msg, _ := MessageFromBytes(<-c.Messages()); msg.Type == MsgTypeThrottled {
+			throttled = msg
+		}
+	}
+	// The score of 7 decays below 6 in about 2.2s.
+	if throttled == nil || throttled.RetryAfterMS < 2200 || throttled.RetryAfterMS > 2300 {
+		t.Errorf("throttled notice = %+v, want a retry hint of about 2.2s", throttled)
+	}
+
+	fake.Advance(20 * time.Second)
+	submit(cursor)
+	infos, _ = h.Clients()
+	if infos[0].Throttled || infos[0].Dropped != 2 {
+		t.Errorf("after decay ClientInfo = %+v, want released", infos[0])
+	}
+
+	// A flood past the rate limit throttles the client again, then closes it.
+	for i := 0; i < 30; i++ {
+		submit(cursor)
+	}
+	if n := h.ClientCount(); n != 0 {
+		t.Errorf("ClientCount() = %d, want flooding client disconnected", n)
+	}
+
+	var actions []events.Type
+	h.do(func() {
+		for _, e := range emitted {
+			if e.Type == events.TypeClientThrottled || e.Type == events.TypeClientDisconnected {
+				actions = append(actions, e.Type)
+			}
+		}
+	})
+	want := []events.Type{events.TypeClientThrottled, events.TypeClientThrottled, events.TypeClientDisconnected}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("abuse events = %v, want %v", actions, want)
+	}
+}
+
+// TestTransaction verifies that a cross-document transaction applies all
+// of its operations or none, and that collaborators only see it committed.
+func TestTransaction(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	h.GetOrCreateDocument("tx-a").SetContent("intro\nsection\n")
+	h.GetOrCreateDocument("tx-b").SetContent("other\n")
+	sender := NewLocalClient(h, "tx-a", 64)
+	watcher := NewLocalClient(h, "tx-b", 64)
+	h.Register(sender)
+	h.Register(watcher)
+	h.do(func() {}) // wait for the registrations
+	drainSystemMessages(t, sender.send)
+	drainSystemMessages(t, watcher.send)
+
+	reply := func() *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-sender.Messages():
+				msg, _ := MessageFromBytes(data)
+				if msg.Type == MsgTypeTransactionCommitted || msg.Type == MsgTypeTransactionAborted {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no transaction reply")
+				return nil
+			}
+		}
+	}
+
+	// Move "section\n" from tx-a to the end of tx-b.
+	h.Submit([]byte(`{"type":"transaction","transaction":{"id":"move-1","operations":[
+		{"document_id":"tx-a","operation":{"type":"delete","position":6,"text":"section\n","version":1}},
+		{"document_id":"tx-b","operation":{"type":"insert","position":6,"text":"section\n","version":1}}]}}`), sender)
+	msg := reply()
+	if msg.Type != MsgTypeTransactionCommitted || msg.Transaction.ID != "move-1" ||
+		msg.Transaction.Operations[0].Operation.Version != 2 || msg.Transaction.Operations[1].Operation.Version != 2 {
+		t.Fatalf("reply = %+v, want committed at version 2 in both documents", msg)
+	}
+	if a, b := h.GetDocument("tx-a").GetContent(), h.GetDocument("tx-b").GetContent(); a != "intro\n" || b != "other\nsection\n" {
+		t.Errorf("contents = %q, %q; want the section moved", a, b)
+	}
+	if op, _ := MessageFromBytes(<-watcher.Messages()); op.Type != MsgTypeOperation || op.Operation.Text != "section\n" {
+		t.Errorf("collaborator received %+v, want the insert", op)
+	}
+
+	// The second operation fails, so the first is rolled back unseen.
+	h.Submit([]byte(`{"type":"transaction","transaction":{"id":"move-2","operations":[
+		{"document_id":"tx-b","operation":{"type":"insert","position":0,"text":"x","version":2}},
+		{"document_id":"tx-a","operation":{"type":"delete","position":40,"text":"gone","version":2}}]}}`), sender)
+	if msg := reply(); msg.Type != MsgTypeTransactionAborted || msg.Transaction.ID != "move-2" || msg.Reason == "" {
+		t.Errorf("reply = %+v, want aborted with a reason", msg)
+	}
+	if content, version := h.GetDocument("tx-b").GetContentAndVersion(); content != "other\nsection\n" || version != 2 {
+		t.Errorf("tx-b = %q at %d, want unchanged at 2", content, version)
+	}
+	select {
+	case data := <-watcher.Messages():
+		t.Errorf("collaborator received %s from an aborted transaction", data)
+	default:
+	}
+
+	// Documents with restricted visibility cannot be reached from another
+	// document's connection.
+	if _, err := h.SetVisibility("tx-b", document.VisibilityPrivate); err != nil {
+		t.Fatalf("SetVisibility() error: %v", err)
+	}
+	h.Submit([]byte(`{"type":"transaction","transaction":{"operations":[
+		{"document_id":"tx-b","operation":{"type":"insert","position":0,"text":"x","version":2}}]}}`), sender)
+	if msg := reply(); msg.Type != MsgTypeTransactionAborted || !strings.Contains(msg.Reason, ErrNotShared.Error()) {
+		t.Errorf("reply = %+v, want aborted as not shared", msg)
+	}
+}
+
+// TestEmbedChanged verifies that editing a document notifies the
+// documents embedding it, including through nested embeds.
+func TestEmbedChanged(t *testing.T) {
+	h := NewHub()
+	var emitted []events.Event
+	h.AddEventSink(sinkFunc(func(e events.Event) { emitted = append(emitted, e) }))
+	go h.Run()
+	defer h.Shutdown()
+
+	if _, err := h.ReplaceContent("report", "Summary\n![[figures#L1-L2]]\n", 0); err != nil {
+		t.Fatalf("ReplaceContent(report) error: %v", err)
+	}
+	if _, err := h.ReplaceContent("book", "![[report]]", 0); err != nil {
+		t.Fatalf("ReplaceContent(book) error: %v", err)
+	}
+	reader := NewLocalClient(h, "report", 16)
+	h.Register(reader)
+	h.do(func() {}) // wait for the registration
+	drainSystemMessages(t, reader.send)
+
+	version, err := h.ReplaceContent("figures", "42\n", 0)
+	if err != nil {
+		t.Fatalf("ReplaceContent(figures) error: %v", err)
+	}
+	msg, _ := MessageFromBytes(<-reader.Messages())
+	if msg.Type != MsgTypeEmbedChanged || msg.Embed == nil || msg.Embed.DocumentID != "figures" || msg.Embed.Version != version {
+		t.Errorf("embedding client received %+v, want embed_changed for figures", msg)
+	}
+
+	embedders := func() []string {
+		var ids []string
+		h.do(func() {
+			for _, e := range emitted {
+				if e.Type == events.TypeEmbedChanged && e.Detail == "figures" {
+					ids = append(ids, e.DocumentID)
+				}
+			}
+		})
+		return ids
+	}
+	if got := embedders(); !reflect.DeepEqual(got, []string{"book", "report"}) {
+		t.Errorf("embed_changed events for %v, want [book report]", got)
+	}
+
+	// Once the reference is removed, changes no longer propagate.
+	if _, err := h.ReplaceContent("report", "Summary\n", 1); err != nil {
+		t.Fatalf("ReplaceContent(report) error: %v", err)
+	}
+	if _, err := h.ReplaceContent("figures", "43\n", version); err != nil {
+		t.Fatalf("ReplaceContent(figures) error: %v", err)
+	}
+	if got := embedders(); len(got) != 2 {
+		t.Errorf("embed_changed events for %v after the embed was removed", got)
+	}
+}
+
+// TestOutline verifies that a client asking for the outline receives it,
+// then receives updates only when an edit changes the headings.
+func TestOutline(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	h.GetOrCreateDocument("guide").SetContent("# Guide\nintro\n## Install\nsteps")
+	sidebar := NewLocalClient(h, "guide", 16)
+	editor := NewLocalClient(h, "guide", 16)
+	h.Register(sidebar)
+	h.Register(editor)
+	h.do(func() {}) // wait for the registrations
+	drainSystemMessages(t, sidebar.send)
+
+	next := func() *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-sidebar.Messages():
+				if msg, _ := MessageFromBytes(data); msg.Type == MsgTypeOutline || msg.Type == MsgTypeOperation {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no message")
+				return nil
+			}
+		}
+	}
+
+	h.Submit([]byte(`{"type":"outline","document_id":"guide"}`), sidebar)
+	msg := next()
+	want := []outline.Heading{{Level: 1, Text: "Guide", Line: 0}, {Level: 2, Text: "Install", Line: 2}}
+	if msg.Type != MsgTypeOutline || !reflect.DeepEqual(msg.Outline, want) {
+		t.Fatalf("reply = %+v, want outline %+v", msg, want)
+	}
+
+	// Typing in the body leaves the outline alone.
+	h.Submit([]byte(`{"type":"operation","document_id":"guide","operation":{"type":"insert","position":8,"text":"An ","version":1}}`), editor)
+	if msg := next(); msg.Type != MsgTypeOperation {
+		t.Errorf("got %s after a body edit, want only the operation", msg.Type)
+	}
+
+	h.Submit([]byte(`{"type":"operation","document_id":"guide","operation":{"type":"insert","position":0,"text":"# Preface\n","version":2}}`), editor)
+	next() // the operation
+	msg = next()
+	want = []outline.Heading{{Level: 1, Text: "Preface", Line: 0}, {Level: 1, Text: "Guide", Line: 1}, {Level: 2, Text: "Install", Line: 3}}
+	if msg.Type != MsgTypeOutline || !reflect.DeepEqual(msg.Outline, want) {
+		t.Errorf("update = %+v, want outline %+v", msg, want)
+	}
+	if got, err := h.Outline("guide"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Outline() = %+v, %v", got, err)
+	}
+}
+
+func TestSanitizer(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	if err := h.SetSanitizer("paste", sanitize.Standard); err != nil {
+		t.Fatalf("SetSanitizer() error: %v", err)
+	}
+	editor := NewLocalClient(h, "paste", 16)
+	viewer := NewLocalClient(h, "paste", 16)
+	h.Register(editor)
+	h.Register(viewer)
+	h.do(func() {}) // wait for the registrations
+	drainSystemMessages(t, editor.send)
+	drainSystemMessages(t, viewer.send)
+
+	next := func(c *Client) *Message {
+		t.Helper()
+		select {
+		case data := <-c.Messages():
+			msg, _ := MessageFromBytes(data)
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("no message")
+			return nil
+		}
+	}
+
+	h.Submit([]byte(`{"type":"operation","document_id":"paste","operation":{"type":"insert","position":0,"text":"a\r\nb\u0000c","version":0}}`), editor)
+	if msg := next(viewer); msg.Type != MsgTypeOperation || msg.Operation.Text != "a\nbc" {
+		t.Errorf("viewer got %+v, want the cleaned operation", msg)
+	}
+	// The editor typed the raw text, so it is sent the document as applied.
+	if msg := next(editor); msg.Type != MsgTypeContent || msg.Content != "a\nbc" || msg.Version != 1 {
+		t.Errorf("editor got %+v, want content \"a\\nbc\" at version 1", msg)
+	}
+
+	h.Submit([]byte(`{"type":"operation","document_id":"paste","operation":{"type":"insert","position":0,"text":"\u0007","version":1,"id":"bell"}}`), editor)
+	if msg := next(editor); msg.Type != MsgTypeAck || msg.AckID != "bell" || msg.Version != 1 {
+		t.Errorf("editor got %+v after an insert of only control characters, want it acknowledged", msg)
+	}
+	if msg := next(editor); msg.Type != MsgTypeContent || msg.Version != 1 {
+		t.Errorf("editor got %+v after an insert of only control characters, want content at version 1", msg)
+	}
+
+	h.Submit([]byte(`{"type":"content","document_id":"paste","content":"x\r\ny"}`), editor)
+	if msg := next(viewer); msg.Type != MsgTypeContent || msg.Content != "x\ny" {
+		t.Errorf("viewer got %+v, want the cleaned content", msg)
+	}
+	if msg := next(editor); msg.Type != MsgTypeContent || msg.Content != "x\ny" {
+		t.Errorf("editor got %+v, want the cleaned content", msg)
+	}
+	if got := h.GetDocument("paste").GetContent(); got != "x\ny" {
+		t.Errorf("content = %q, want %q", got, "x\ny")
+	}
+}
+
+func TestNormalization(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	h.GetOrCreateDocument("names").SetContent("Zoe\u0308")
+	editor := NewLocalClient(h, "names", 16)
+	viewer := NewLocalClient(h, "names", 16)
+	h.Register(editor)
+	h.Register(viewer)
+	h.do(func() {}) // wait for the registrations
+	drainSystemMessages(t, editor.send)
+	drainSystemMessages(t, viewer.send)
+
+	next := func(c *Client) *Message {
+		t.Helper()
+		select {
+		case data := <-c.Messages():
+			msg, _ := MessageFromBytes(data)
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("no message")
+			return nil
+		}
+	}
+
+	if err := h.SetNormalization("names", "nfkc"); !errors.Is(err, ErrUnknownNormalization) {
+		t.Errorf("SetNormalization(nfkc) error = %v, want ErrUnknownNormalization", err)
+	}
+	if err := h.SetNormalization("names", textnorm.FormNFC); err != nil {
+		t.Fatalf("SetNormalization() error: %v", err)
+	}
+	if msg := next(viewer); msg.Type != MsgTypeContent || msg.Content != "Zo\u00eb" || msg.Version != 2 {
+		t.Errorf("viewer got %+v, want the normalized content at version 2", msg)
+	}
+	next(editor)
+
+	h.Submit([]byte(`{"type":"operation","document_id":"names","operation":{"type":"insert","position":3,"text":" Noe\u0308l","version":2}}`), editor)
+	if msg := next(viewer); msg.Type != MsgTypeOperation || msg.Operation.Text != " No\u00ebl" {
+		t.Errorf("viewer got %+v, want the normalized operation", msg)
+	}
+	if msg := next(editor); msg.Type != MsgTypeContent || msg.Content != "Zo\u00eb No\u00ebl" {
+		t.Errorf("editor got %+v, want the content as applied", msg)
+	}
+
+	// A lone accent, which would merge into the "o", is refused.
+	h.Submit([]byte(`{"type":"operation","document_id":"names","operation":{"type":"insert","position":2,"text":"\u0308","version":3}}`), editor)
+	if got := h.GetDocument("names").GetVersion(); got != 3 {
+		t.Errorf("version = %d after an insert inside a character, want 3", got)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	h.GetOrCreateDocument("app.conf").SetContent("port = 80")
+	if err := h.SetLimits("app.conf", schema.Limits{MaxLineLength: 5}); err == nil {
+		t.Error("SetLimits() accepted limits the content already breaks")
+	}
+	if err := h.SetLimits("app.conf", schema.Limits{MaxLines: -1}); !errors.Is(err, ErrInvalidLimits) {
+		t.Errorf("SetLimits(-1) error = %v, want ErrInvalidLimits", err)
+	}
+	if err := h.SetLimits("app.conf", schema.Limits{MaxLineLength: 12, MaxLines: 2}); err != nil {
+		t.Fatalf("SetLimits() error: %v", err)
+	}
+
+	editor := NewLocalClient(h, "app.conf", 16)
+	h.Register(editor)
+	h.do(func() {}) // wait for the registration
+	drainSystemMessages(t, editor.send)
+	next := func() *Message {
+		t.Helper()
+		select {
+		case data := <-editor.Messages():
+			msg, _ := MessageFromBytes(data)
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("no message")
+			return nil
+		}
+	}
+
+	h.Submit([]byte(`{"type":"operation","document_id":"app.conf","operation":{"type":"insert","position":9,"text":" # http","version":1,"id":"op-1"}}`), editor)
+	msg := next()
+	want := schema.ViolationError{Line: 0, Rule: schema.RuleMaxLength, Limit: 12, Actual: 16, Reason: "is 16 characters, limit 12"}
+	if msg.Type != MsgTypeRejected || msg.AckID != "op-1" || msg.Version != 1 || msg.Violation == nil || *msg.Violation != want {
+		t.Errorf("reply = %+v, want rejected op-1 with violation %+v", msg, want)
+	}
+	if msg := next(); msg.Type != MsgTypeContent || msg.Content != "port = 80" {
+		t.Errorf("after rejection got %+v, want the current content", msg)
+	}
+
+	h.Submit([]byte(`{"type":"content","document_id":"app.conf","content":"a\nb\nc"}`), editor)
+	if msg := next(); msg.Type != MsgTypeRejected || msg.Violation == nil || msg.Violation.Rule != schema.RuleMaxLines || msg.Violation.Line != 2 {
+		t.Errorf("reply = %+v, want rejected with a max_lines violation at line 2", msg)
+	}
+}
+
+func TestValidator(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	h.GetOrCreateDocument("settings").SetContent(`{"port": 80`)
+	editor := NewLocalClient(h, "settings", 16)
+	h.Register(editor)
+	h.do(func() {}) // wait for the registration
+	drainSystemMessages(t, editor.send)
+
+	next := func(c *Client) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, _ := MessageFromBytes(data); msg.Type == MsgTypeDiagnostics {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no diagnostics")
+				return nil
+			}
+		}
+	}
+
+	if err := h.SetValidator("settings", "xml"); !errors.Is(err, ErrUnknownValidator) {
+		t.Errorf("SetValidator(xml) error = %v, want ErrUnknownValidator", err)
+	}
+	if err := h.SetValidator("settings", "json"); err != nil {
+		t.Fatalf("SetValidator() error: %v", err)
+	}
+	want := []validators.Diagnostic{{Line: 0, Column: 10, Message: "unexpected end of JSON input"}}
+	if msg := next(editor); !reflect.DeepEqual(msg.Diagnostics, want) {
+		t.Errorf("diagnostics = %+v, want %+v", msg.Diagnostics, want)
+	}
+
+	// A collaborator joining later is told about the problem too.
+	viewer := NewLocalClient(h, "settings", 16)
+	h.Register(viewer)
+	if msg := next(viewer); !reflect.DeepEqual(msg.Diagnostics, want) {
+		t.Errorf("diagnostics on join = %+v, want %+v", msg.Diagnostics, want)
+	}
+
+	h.Submit([]byte(`{"type":"operation","document_id":"settings","operation":{"type":"insert","position":11,"text":"}","version":1}}`), editor)
+	if msg := next(viewer); len(msg.Diagnostics) != 0 {
+		t.Errorf("diagnostics after the fix = %+v, want none", msg.Diagnostics)
+	}
+	if diagnostics, validator := h.Diagnostics("settings"); len(diagnostics) != 0 || validator != "json" {
+		t.Errorf("Diagnostics() = %+v, %q", diagnostics, validator)
+	}
+}
+
+// TestPublish verifies that approved snapshots are published in version
+// order and announced to collaborators.
+func TestPublish(t *testing.T) {
+	h := NewHub()
+	var emitted []events.Event
+	h.AddEventSink(sinkFunc(func(e events.Event) { emitted = append(emitted, e) }))
+	go h.Run()
+	defer h.Shutdown()
+
+	h.ReplaceContent("notes", "v1", 0)
+	c := NewLocalClient(h, "notes", 16)
+	h.Register(c)
+	h.do(func() {}) // wait for the registration
+	drainSystemMessages(t, c.send)
+
+	if err := h.Publish("missing", document.Publication{Version: 1}); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Publish(missing) error = %v, want ErrDocumentNotFound", err)
+	}
+	if err := h.Publish("notes", document.Publication{Version: 2, Content: "v2"}); err != nil {
+		t.Fatalf("Publish() error: %v", err)
+	}
+	select {
+	case data := <-c.Messages():
+		if msg, _ := MessageFromBytes(data); msg.Type != MsgTypePublished || msg.Version != 2 {
+			t.Errorf("message = %s, want published at version 2", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no published message")
+	}
+
+	// A slower approval of an older snapshot does not roll readers back.
+	if err := h.Publish("notes", document.Publication{Version: 1, Content: "v1"}); !errors.Is(err, document.ErrStalePublication) {
+		t.Errorf("Publish(older) error = %v, want ErrStalePublication", err)
+	}
+	p, ok := h.Published("notes")
+	if !ok || p.Version != 2 || p.Content != "v2" || p.PublishedAt.IsZero() {
+		t.Errorf("Published() = %+v, %v", p, ok)
+	}
+	var published []int
+	h.do(func() {
+		for _, e := range emitted {
+			if e.Type == events.TypeDocumentPublished {
+				published = append(published, e.Version)
+			}
+		}
+	})
+	if !reflect.DeepEqual(published, []int{2}) {
+		t.Errorf("document_published events for versions %v, want [2]", published)
+	}
+}
+
+// TestDashboard verifies that the dashboard ranks documents by activity
+// and counts refused and malformed messages.
+func TestDashboard(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	h.ReplaceContent("quiet", "q", 0)
+	busy := NewLocalClient(h, "busy", 16)
+	h.Register(busy)
+	for i := 0; i < 3; i++ {
+		h.Submit([]byte(`{"type":"operation","document_id":"busy","operation":{"type":"insert","position":0,"text":"a","version":0}}`), busy)
+	}
+	h.Submit([]byte(`{"type":"operation","document_id":"busy","operation":{"type":"insert","position":99,"text":"a","version":3}}`), busy)
+	h.Submit([]byte(`{"type":5}`), busy)
+
+	d, err := h.Dashboard(10)
+	if err != nil {
+		t.Fatalf("Dashboard() error: %v", err)
+	}
+	want := Traffic{Messages: 5, Rejected: 1, ParseErrors: 1}
+	if d.Traffic != want {
+		t.Errorf("traffic = %+v, want %+v", d.Traffic, want)
+	}
+	if d.ErrorRates.Rejected != 0.2 || d.ErrorRates.ParseErrors != 0.2 {
+		t.Errorf("error rates = %+v, want 0.2 rejected and malformed", d.ErrorRates)
+	}
+	if d.Clients != 1 || d.Storage.Documents != 2 || d.Storage.DocumentBytes == 0 {
+		t.Errorf("dashboard = %+v", d)
+	}
+	if len(d.TopDocuments) != 2 || d.TopDocuments[0].ID != "busy" || d.TopDocuments[0].Messages != 5 || d.TopDocuments[0].Rejected != 1 {
+		t.Errorf("top documents = %+v, want busy first", d.TopDocuments)
+	}
+
+	if d, _ := h.Dashboard(1); len(d.TopDocuments) != 1 {
+		t.Errorf("Dashboard(1) ranked %d documents", len(d.TopDocuments))
+	}
+}
+
+// TestApplyOperation verifies that server operations are transformed,
+// broadcast and exempt from rate limits.
+func TestApplyOperation(t *testing.T) {
+	h := NewHub()
+	h.SetAbusePolicy(AbusePolicy{MaxMessagesPerSecond: 1, ThrottleScore: 1})
+	go h.Run()
+	defer h.Shutdown()
+
+	h.ReplaceContent("draft", "teh cat", 0)
+	reader := NewLocalClient(h, "draft", 64)
+	h.Register(reader)
+	h.do(func() {}) // wait for the registration
+	drainSystemMessages(t, reader.send)
+
+	// A bot read version 1, then a collaborator edited the start.
+	h.Submit([]byte(`{"type":"operation","document_id":"draft","operation":{"type":"insert","position":0,"text":"See ","version":1}}`), reader)
+	for i := 0; i < 10; i++ {
+		if _, err := h.ApplyOperation("draft", operations.NewInsertOp(7, ".", 1)); err != nil {
+			t.Fatalf("ApplyOperation() error: %v", err)
+		}
+	}
+	version, err := h.ApplyOperation("draft", operations.NewDeleteOp(0, "teh ", 1))
+	if err != nil {
+		t.Fatalf("ApplyOperation() error: %v", err)
+	}
+	if got, want := h.GetDocument("draft").GetContent(), "See cat.........."; got != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+	if version != 13 {
+		t.Errorf("version = %d, want 13", version)
+	}
+
+	var relayed int
+	for len(reader.send) > 0 {
+		if msg, _ := MessageFromBytes(<-reader.send); msg.Type == MsgTypeOperation {
+			relayed++
+		}
+	}
+	if relayed != 11 {
+		t.Errorf("reader received %d server operations, want 11", relayed)
+	}
+	if d, _ := h.Dashboard(0); d.Traffic.Messages != 1 || d.Traffic.Throttled != 0 {
+		t.Errorf("traffic = %+v, want only the client's message counted", d.Traffic)
+	}
+}
+
+func TestCreateDocument(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	if err := h.CreateDocument("fresh", ""); err != nil {
+		t.Fatalf("CreateDocument() error: %v", err)
+	}
+	if h.GetDocument("fresh") == nil {
+		t.Fatal("document was not created")
+	}
+	if err := h.CreateDocument("fresh", ""); !errors.Is(err, ErrDocumentExists) {
+		t.Errorf("CreateDocument(existing) error = %v, want ErrDocumentExists", err)
+	}
+	if err := h.DeleteDocument("fresh", false); err != nil {
+		t.Fatalf("DeleteDocument() error: %v", err)
+	}
+	if err := h.CreateDocument("fresh", ""); !errors.Is(err, ErrDocumentExists) {
+		t.Errorf("CreateDocument(deleted) error = %v, want ErrDocumentExists", err)
+	}
+
+	if err := h.CreateDocument("drafted", "first line"); err != nil {
+		t.Fatalf("CreateDocument(with content) error: %v", err)
+	}
+	if content, version := h.GetDocument("drafted").GetContentAndVersion(); content != "first line" || version == 0 {
+		t.Errorf("content = %q at version %d, want the initial body", content, version)
+	}
+
+	h.SetSchema("notes-", schema.TitleBody)
+	var violation *schema.ViolationError
+	if err := h.CreateDocument("notes-1", "**not a title**"); !errors.As(err, &violation) {
+		t.Errorf("CreateDocument(breaking schema) error = %v, want a violation", err)
+	}
+	if h.GetDocument("notes-1") != nil {
+		t.Error("document breaking its schema was created")
+	}
+}
+
+func TestConcurrentOperations(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	version, _ := h.ReplaceContent("race", "cat", 0)
+	alice := NewLocalClient(h, "race", 16)
+	bob := NewLocalClient(h, "race", 16)
+	h.Register(alice)
+	h.Register(bob)
+	h.do(func() {}) // wait for the registrations
+	drainSystemMessages(t, alice.send)
+	drainSystemMessages(t, bob.send)
+
+	// Both edit the same version; Bob's insert is moved past Alice's.
+	submit := func(c *Client, pos int, text string) {
+		h.Submit([]byte(fmt.Sprintf(`{"type":"operation","document_id":"race","operation":{"type":"insert","position":%d,"text":%q,"version":%d}}`, pos, text, version)), c)
+	}
+	submit(alice, 0, "A ")
+	submit(bob, 3, "s")
+	if got := h.GetDocument("race").GetContent(); got != "A cats" {
+		t.Errorf("content = %q, want %q", got, "A cats")
+	}
+	select {
+	case data := <-alice.Messages():
+		msg, _ := MessageFromBytes(data)
+		if msg == nil || msg.Operation == nil || msg.Operation.Position != 5 || msg.Operation.Version != version+2 {
+			t.Errorf("Alice received %s, want Bob's insert at 5", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Alice received no operation")
+	}
+}
+
+// stallingStore is a memoryStore whose loads of one document wait until
+// release is closed.
+type stallingStore struct {
+	*memoryStore
+	stalled string
+	loading chan struct{} // Closed once the stalled load starts
+	release chan struct{}
+}
+
+func (s *stallingStore) Load(id string) (*document.Snapshot, error) {
+	if id == s.stalled {
+		close(s.loading)
+		<-s.release
+	}
+	return s.memoryStore.Load(id)
+}
+
+// TestRoomsApplyIndependently verifies a document whose room is stuck
+// applying a message holds up neither the hub nor other documents' edits.
+func TestRoomsApplyIndependently(t *testing.T) {
+	s := &stallingStore{
+		memoryStore: &memoryStore{docs: make(map[string]*document.Snapshot)},
+		stalled:     "stalled",
+		loading:     make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+	h := NewHub()
+	h.SetStore(s, time.Hour)
+	go h.Run()
+	defer h.Shutdown()
+	released := sync.OnceFunc(func() { close(s.release) })
+	defer released()
+
+	watcher := NewLocalClient(h, "quiet", 16)
+	h.Register(watcher)
+	drainSystemMessages(t, watcher.send)
+
+	go h.Broadcast(context.Background(), []byte(`{"type":"operation","document_id":"stalled","operation":{"type":"insert","position":0,"text":"x","version":0}}`), nil)
+	select {
+	case <-s.loading:
+	case <-time.After(time.Second):
+		t.Fatal("stalled document was not loaded")
+	}
+
+	submitted := make(chan struct{})
+	go func() {
+		h.Submit([]byte(`{"type":"operation","document_id":"quiet","operation":{"type":"insert","position":0,"text":"hi","version":0}}`), nil)
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+	case <-time.After(time.Second):
+		t.Fatal("edit to another document waited for the stalled one")
+	}
+	if msg, err := MessageFromBytes(<-watcher.Messages()); err != nil || msg.Type != MsgTypeOperation {
+		t.Errorf("watcher received %+v, want the operation", msg)
+	}
+	if n := h.ClientCount(); n != 1 {
+		t.Errorf("ClientCount() = %d while a room is stalled", n)
+	}
+
+	released()
+	h.do(func() {}) // waits for the stalled room to finish
+	if got := h.GetDocument("stalled").GetContent(); got != "x" {
+		t.Errorf("stalled document content = %q, want %q", got, "x")
+	}
+}
+
+// TestRoomQueueFull verifies a room that falls behind drops further
+// messages and kicks their recipients rather than making the sender wait,
+// while still queueing lifecycle steps.
+func TestRoomQueueFull(t *testing.T) {
+	h := NewHub()
+	defer h.Shutdown()
+	r := &room{documentID: "full", clients: make(map[*Client]bool), wake: make(chan struct{}, 1)}
+	c := NewLocalClient(h, "full", 1)
+
+	h.mu.Lock()
+	for range roomQueue {
+		h.enqueue(r, delivery{message: []byte("m"), to: []*Client{c}})
+	}
+	if cause := context.Cause(c.Context()); cause != nil {
+		t.Errorf("client stopped with %v before the queue was full", cause)
+	}
+	h.enqueue(r, delivery{message: []byte("m"), to: []*Client{c}, lenient: true})
+	if cause := context.Cause(c.Context()); cause != nil {
+		t.Errorf("lenient message stopped the client with %v", cause)
+	}
+	h.enqueue(r, delivery{message: []byte("m"), to: []*Client{c}})
+	h.enqueue(r, delivery{close: c})
+	h.mu.Unlock()
+
+	if cause := context.Cause(c.Context()); cause != ErrSlowClient {
+		t.Errorf("client cause = %v, want ErrSlowClient", cause)
+	}
+	if r.queued != roomQueue || len(r.queue) != roomQueue+1 || r.queue[roomQueue].close != c {
+		t.Errorf("queue holds %d messages in %d deliveries, want %d and the close", r.queued, len(r.queue), roomQueue)
+	}
+}
+
+// TestClientContext verifies the hub cancels clients' contexts with the
+// reason they stopped, and that cancellation ends the pumps.
+func TestClientContext(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	wait := func(name string, done <-chan struct{}) {
+		t.Helper()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: not done", name)
+		}
+	}
+	connect := func(ctx context.Context) (*Client, *Pipe) {
+		conn := NewPipe()
+		c := NewClient(h, conn, "ctx-doc")
+		c.SetContext(ctx)
+		h.Register(c)
+		go c.WritePump()
+		go c.ReadPump()
+		return c, conn
+	}
+
+	parent, cancel := context.WithCancel(context.Background())
+	session, conn := connect(parent)
+	cancel()
+	wait("canceled session", conn.Done())
+	if cause := context.Cause(session.Context()); !errors.Is(cause, context.Canceled) {
+		t.Errorf("canceled session cause = %v", cause)
+	}
+	deadline := time.Now().Add(time.Second)
+	for h.ClientCountForDocument("ctx-doc") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("canceled session was not unregistered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	slow := NewLocalClient(h, "ctx-doc", 1)
+	h.Register(slow)
+	for i := 0; i < 3; i++ {
+		h.Submit([]byte(`{"type":"operation","document_id":"ctx-doc","operation":{"type":"insert","position":0,"text":"x","version":`+strconv.Itoa(i)+`}}`), nil)
+	}
+	wait("slow client", slow.Context().Done())
+	if cause := context.Cause(slow.Context()); cause != ErrSlowClient {
+		t.Errorf("slow client cause = %v, want ErrSlowClient", cause)
+	}
+
+	left := NewLocalClient(h, "ctx-doc", 16)
+	h.Register(left)
+	h.Unregister(left)
+	wait("unregistered client", left.Context().Done())
+	if cause := context.Cause(left.Context()); cause != ErrDisconnected {
+		t.Errorf("unregistered client cause = %v, want ErrDisconnected", cause)
+	}
+
+	live, conn := connect(context.Background())
+	h.do(func() {})
+	h.Shutdown()
+	wait("client at shutdown", conn.Done())
+	if cause := context.Cause(live.Context()); cause != ErrHubStopped {
+		t.Errorf("client at shutdown cause = %v, want ErrHubStopped", cause)
+	}
+}
+
+// memoryStore is a document.Store kept in a map.
+type memoryStore struct {
+	mu    sync.Mutex
+	docs  map[string]*document.Snapshot
+	saves int
+}
+
+func (s *memoryStore) Load(id string) (*document.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.docs[id]
+	if !ok {
+		return nil, document.ErrNotStored
+	}
+	return snap, nil
+}
+
+func (s *memoryStore) Save(id string, snap *document.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs[id] = snap
+	s.saves++
+	return nil
+}
+
+func (s *memoryStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.docs))
+	for id := range s.docs {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.docs, id)
+	return nil
+}
+
+func (s *memoryStore) version(id string) (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if snap, ok := s.docs[id]; ok {
+		return snap.Version, s.saves
+	}
+	return -1, s.saves
+}
+
+// TestStore verifies documents are saved in batches and at shutdown,
+// removed from the store when deleted, and loaded by a later hub.
+func TestStore(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	ms := &memoryStore{docs: make(map[string]*document.Snapshot)}
+	h := NewHub()
+	h.SetClock(fake)
+	h.SetStore(ms, time.Second)
+	go h.Run()
+
+	insert := func(h *Hub, version int, text string) {
+		h.Submit([]byte(`{"type":"operation","document_id":"notes","operation":{"type":"insert","position":0,"text":"`+text+`","version":`+strconv.Itoa(version)+`}}`), nil)
+	}
+	insert(h, 0, "c")
+	insert(h, 1, "b")
+	h.GetOrCreateDocument("scratch")
+	if v, _ := ms.version("notes"); v != -1 {
+		t.Fatalf("saved before the flush interval, version %d", v)
+	}
+
+	for fake.TickerCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fake.Advance(time.Second)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := ms.version("notes"); v == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("changes were not saved after the flush interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, saves := ms.version("notes")
+	fake.Advance(time.Second)
+	h.do(func() {})
+	if _, again := ms.version("notes"); again != saves {
+		t.Errorf("unchanged documents saved again: %d saves, want %d", again, saves)
+	}
+
+	if err := h.DeleteDocument("scratch", false); err != nil {
+		t.Fatalf("DeleteDocument() error: %v", err)
+	}
+	if v, _ := ms.version("scratch"); v != -1 {
+		t.Error("deleted document is still stored")
+	}
+	insert(h, 2, "a")
+	h.Shutdown()
+	if v, _ := ms.version("notes"); v != 3 {
+		t.Errorf("stored version after Shutdown = %d, want 3", v)
+	}
+
+	restarted := NewHub()
+	restarted.SetStore(ms, time.Second)
+	go restarted.Run()
+	defer restarted.Shutdown()
+	if ids := restarted.DocumentIDs(); !slices.Equal(ids, []string{"notes"}) {
+		t.Errorf("DocumentIDs() = %v, want the stored document", ids)
+	}
+	if err := restarted.CreateDocument("notes", ""); !errors.Is(err, ErrDocumentExists) {
+		t.Errorf("CreateDocument(stored) error = %v, want ErrDocumentExists", err)
+	}
+	doc := restarted.GetDocument("notes")
+	if doc == nil {
+		t.Fatal("stored document was not loaded")
+	}
+	if content, version := doc.GetContentAndVersion(); content != "abc" || version != 3 {
+		t.Errorf("loaded %q at version %d, want \"abc\" at 3", content, version)
+	}
+	insert(restarted, 3, "_")
+	if !restarted.unload("notes") {
+		t.Fatal("unload() of an idle document failed")
+	}
+	if reloaded := restarted.GetDocument("notes"); reloaded == doc || reloaded.GetContent() != "_abc" {
+		t.Errorf("unloaded document was not saved and reloaded")
+	}
+}
+
+// TestRebuild verifies a document whose stored content was corrupted is
+// not loaded, and that Rebuild replays the operation log over the log's
+// base, saves the result and resyncs clients.
+func TestRebuild(t *testing.T) {
+	fs, err := store.NewFile(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFile() error: %v", err)
+	}
+	h := NewHub()
+	h.SetStore(fs, time.Hour)
+	go h.Run()
+	doc := h.GetOrCreateDocument("notes")
+	for _, content := range []string{"hello", "hello world", "hello world!"} {
+		doc.SetContent(content)
+		if !h.flush() {
+			t.Fatalf("flush() of %q failed", content)
+		}
+	}
+	h.Shutdown()
+
+	snap, err := fs.Load("notes")
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	snap.Content = "hel\x00\x00 wor"
+	if err := fs.Save("notes", snap); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	h = NewHub()
+	h.SetStore(fs, time.Hour)
+	go h.Run()
+	defer h.Shutdown()
+	if h.GetDocument("notes") != nil {
+		t.Fatal("corrupt document was loaded")
+	}
+	editor := NewLocalClient(h, "notes", 16)
+	h.Register(editor)
+	h.do(func() {}) // wait for the registration
+	for len(editor.Messages()) > 0 {
+		<-editor.Messages()
+	}
+
+	res, err := h.Rebuild("notes")
+	if err != nil {
+		t.Fatalf("Rebuild() error: %v", err)
+	}
+	if want := (RebuildResult{From: 1, Replayed: 3, Version: 4}); res != want {
+		t.Errorf("Rebuild() = %+v, want %+v", res, want)
+	}
+	select {
+	case data := <-editor.Messages():
+		msg, _ := MessageFromBytes(data)
+		if msg.Type != MsgTypeContent || msg.Content != "hello world!" || msg.Version != 4 {
+			t.Errorf("editor received %+v, want the rebuilt content", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("editor was not resynced")
+	}
+	if snap, err := fs.Load("notes"); err != nil || snap.Verify() != nil || snap.Content != "hello world!" || snap.Version != 4 {
+		t.Errorf("stored after Rebuild: %+v, %v", snap, err)
+	}
+
+	memory := NewHub()
+	memory.SetStore(&memoryStore{docs: make(map[string]*document.Snapshot)}, time.Hour)
+	go memory.Run()
+	defer memory.Shutdown()
+	if _, err := memory.Rebuild("notes"); !errors.Is(err, ErrNoOpLog) {
+		t.Errorf("Rebuild() without a log error = %v, want ErrNoOpLog", err)
+	}
+}
+
+// TestTeams verifies teams are kept in a store that keeps them, that a
+// document records its team, and that erasing a user removes them from
+// teams.
+func TestTeams(t *testing.T) {
+	fs, err := store.NewFile(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFile() error: %v", err)
+	}
+	h := NewHub()
+	h.SetStore(fs, time.Hour)
+	go h.Run()
+	if err := h.CreateTeam("writers", "Writers"); err != nil {
+		t.Fatalf("CreateTeam() error: %v", err)
+	}
+	if err := h.CreateTeam("writers", ""); !errors.Is(err, ErrTeamExists) {
+		t.Errorf("CreateTeam() again error = %v, want ErrTeamExists", err)
+	}
+	if err := h.SetTeamMembers("writers", map[string]document.Role{"alice": document.RoleEditor, "bob": "owner"}); !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("SetTeamMembers() with an unknown role error = %v, want ErrUnknownRole", err)
+	}
+	if err := h.SetTeamMembers("writers", map[string]document.Role{"alice": document.RoleEditor, "bob": document.RoleViewer}); err != nil {
+		t.Fatalf("SetTeamMembers() error: %v", err)
+	}
+	if err := h.SetTeamMembers("legal", map[string]document.Role{"alice": document.RoleEditor}); !errors.Is(err, ErrTeamNotFound) {
+		t.Errorf("SetTeamMembers() of a missing team error = %v, want ErrTeamNotFound", err)
+	}
+	if err := h.SetTeam("plan", "writers"); err != nil {
+		t.Fatalf("SetTeam() error: %v", err)
+	}
+	h.Shutdown()
+
+	h = NewHub()
+	h.SetStore(fs, time.Hour)
+	go h.Run()
+	defer h.Shutdown()
+	if role, ok := h.TeamRole("writers", "bob"); !ok || role != document.RoleViewer {
+		t.Errorf("TeamRole() after restart = %q, %v; want viewer", role, ok)
+	}
+	if team := h.GetDocument("plan").Team(); team != "writers" {
+		t.Errorf("document team after restart = %q, want writers", team)
+	}
+	report, err := h.EraseUser("bob", "")
+	if err != nil || !slices.Equal(report.Teams, []string{"writers"}) {
+		t.Errorf("EraseUser() = %+v, %v; want bob removed from writers", report, err)
+	}
+	if _, ok := h.TeamRole("writers", "bob"); ok {
+		t.Error("erased user is still a team member")
+	}
+	if err := h.DeleteTeam("writers"); err != nil {
+		t.Fatalf("DeleteTeam() error: %v", err)
+	}
+	if teams := h.Teams(); len(teams) != 0 {
+		t.Errorf("Teams() after DeleteTeam = %+v", teams)
+	}
+}
+
+// TestGoroutineReport verifies pumps and workers are counted, that pumps
+// of a stopped client are reported until they return, and that Shutdown
+// waits for every goroutine.
+func TestGoroutineReport(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	waitFor := func(what string, cond func(GoroutineReport) bool) GoroutineReport {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			r := h.GoroutineReport()
+			if cond(r) {
+				return r
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: report %+v", what, r)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	alice, bob := NewPipe(), NewPipe()
+	for _, conn := range []*Pipe{alice, bob} {
+		c := NewClient(h, conn, "leak-doc")
+		h.Register(c)
+		go c.WritePump()
+		go c.ReadPump()
+	}
+	r := waitFor("pumps running", func(r GoroutineReport) bool {
+		return r.Running[RoutineReadPump] == 2 && r.Running[RoutineWritePump] == 2
+	})
+	if r.Running[RoutineRun] != 1 || r.Running[RoutineRoom] != 1 || r.Running[RoutineApply] != 1 || r.Clients != 2 || r.Total != 7 || len(r.Lingering) != 0 {
+		t.Errorf("report = %+v", r)
+	}
+
+	alice.Close()
+	waitFor("pumps of a broken connection returned", func(r GoroutineReport) bool {
+		return r.Running[RoutineReadPump] == 1 && r.Running[RoutineWritePump] == 1 && r.Clients == 1
+	})
+
+	// Without a WritePump to close it, a Pipe keeps the ReadPump of a
+	// kicked client blocked.
+	stuckConn := NewPipe()
+	stuck := NewClient(h, stuckConn, "leak-doc")
+	h.Register(stuck)
+	go stuck.ReadPump()
+	waitFor("read pump started", func(r GoroutineReport) bool { return r.Running[RoutineReadPump] == 2 })
+	h.do(func() { h.kick(stuck, ErrSlowClient) })
+	r = waitFor("kicked client lingering", func(r GoroutineReport) bool { return len(r.Lingering) == 1 })
+	if l := r.Lingering[0]; l.ClientID != stuck.ID() || l.Cause != ErrSlowClient.Error() || !l.ReadPump || l.WritePump {
+		t.Errorf("lingering = %+v", l)
+	}
+	stuckConn.Close()
+	waitFor("lingering pump returned", func(r GoroutineReport) bool { return len(r.Lingering) == 0 })
+
+	h.Shutdown()
+	if r := h.GoroutineReport(); r.Total != 0 || len(r.Running) != 0 {
+		t.Errorf("report after Shutdown = %+v", r)
+	}
+	select {
+	case <-bob.Done():
+	default:
+		t.Error("connection still open after Shutdown returned")
+	}
+}
+
+func TestUndo(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	h.GetOrCreateDocument("notes").SetContent("hello")
+	alice := NewLocalClient(h, "notes", 16)
+	bob := NewLocalClient(h, "notes", 16)
+	h.Register(alice)
+	h.Register(bob)
+	h.do(func() {}) // wait for the registrations
+	drainSystemMessages(t, alice.send)
+	drainSystemMessages(t, bob.send)
+
+	next := func(c *Client) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, _ := MessageFromBytes(data); msg.Type != MsgTypeAck {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no message")
+				return nil
+			}
+		}
+	}
+
+	h.Submit([]byte(`{"type":"operation","document_id":"notes","operation":{"type":"insert","position":5,"text":" world","version":1}}`), alice)
+	next(bob)
+	h.Submit([]byte(`{"type":"undo","document_id":"notes"}`), bob)
+	h.Submit([]byte(`{"type":"undo","document_id":"notes"}`), alice)
+	for _, c := range []*Client{alice, bob} {
+		msg := next(c)
+		if msg.Type != MsgTypeOperation || msg.Operation.Type != operations.OpDelete || msg.Operation.Text != " world" || msg.Operation.Version != 3 {
+			t.Errorf("got %+v, want the delete of alice's insert at version 3", msg)
+		}
+	}
+
+	h.Submit([]byte(`{"type":"redo","document_id":"notes"}`), alice)
+	if msg := next(bob); msg.Type != MsgTypeOperation || msg.Operation.Type != operations.OpInsert {
+		t.Errorf("got %+v, want the insert reapplied", msg)
+	}
+	if got := h.GetDocument("notes").GetContent(); got != "hello world" {
+		t.Errorf("content = %q, want %q", got, "hello world")
+	}
+}
+
+// TestShutdownConcurrent verifies Shutdown can be called repeatedly and
+// from several goroutines while clients are still sending.
+func TestShutdownConcurrent(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	var conns []*Pipe
+	for i := 0; i < 3; i++ {
+		conn := NewPipe()
+		c := NewClient(h, conn, "busy-doc")
+		h.Register(c)
+		go c.WritePump()
+		go c.ReadPump()
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		go func() {
+			for i := 0; ; i++ {
+				op := fmt.Sprintf(`{"type":"operation","document_id":"busy-doc","operation":{"type":"insert","position":0,"text":"%d","version":0}}`, i%10)
+				if conn.Send([]byte(op)) != nil {
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	select {
+	case <-h.Done():
+		t.Fatal("Done closed before Shutdown")
+	default:
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Shutdown()
+			select {
+			case <-h.Done():
+			default:
+				t.Error("Shutdown returned before Done was closed")
+			}
+		}()
+	}
+	wg.Wait()
+	h.Shutdown()
+	if r := h.GoroutineReport(); r.Total != 0 {
+		t.Errorf("report after Shutdown = %+v", r)
+	}
+}
+
+func TestBroadcastContext(t *testing.T) {
+	h := NewHub() // not running, so nothing takes the message
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.Broadcast(ctx, []byte("message"), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Broadcast() to a busy hub error = %v, want DeadlineExceeded", err)
+	}
+
+	sender := NewLocalClient(h, "test-doc", 1)
+	sender.stop(ErrSlowClient)
+	if err := h.Broadcast(context.Background(), []byte("message"), sender); !errors.Is(err, ErrSlowClient) {
+		t.Errorf("Broadcast() from a stopped sender error = %v, want ErrSlowClient", err)
+	}
+
+	h.Shutdown()
+	if err := h.Broadcast(context.Background(), []byte("message"), nil); !errors.Is(err, ErrHubStopped) {
+		t.Errorf("Broadcast() after Shutdown error = %v, want ErrHubStopped", err)
+	}
+}
+
+// TestSync verifies a joining client receives the document's content and
+// version after its welcome, and again when it sends sync_request.
+func TestSync(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	doc := h.GetOrCreateDocument("notes")
+	doc.SetContent("hello")
+	_, version := doc.GetContentAndVersion()
+
+	sync := func(c *Client) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, _ := MessageFromBytes(data); msg.Type == MsgTypeSync {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no sync message")
+				return nil
+			}
+		}
+	}
+
+	c := NewLocalClient(h, "notes", 16)
+	h.Register(c)
+	if msg := sync(c); msg.DocumentID != "notes" || msg.Content != "hello" || msg.Version != version {
+		t.Errorf("got %+v, want content %q at version %d", msg, "hello", version)
+	}
+
+	doc.SetContent("hello world")
+	_, version = doc.GetContentAndVersion()
+	h.Submit([]byte(`{"type":"sync_request","document_id":"notes"}`), c)
+	if msg := sync(c); msg.Content != "hello world" || msg.Version != version {
+		t.Errorf("got %+v, want content %q at version %d", msg, "hello world", version)
+	}
+
+	empty := NewLocalClient(h, "new-doc", 16)
+	h.Register(empty)
+	if msg := sync(empty); msg.Content != "" || msg.Version != 0 {
+		t.Errorf("got %+v for a new document, want empty content at version 0", msg)
+	}
+}
+
+// TestTraceID verifies a message's trace ID reaches the sender's ack, the
+// collaborators' copy and the event it causes, and that the hub assigns
+// one when the client sends none or an unsafe one.
+func TestTraceID(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	sink := make(chan events.Event, 16)
+	h.AddEventSink(sinkFunc(func(e events.Event) {
+		if e.Type == events.TypeOperationApplied {
+			sink <- e
+		}
+	}))
+	alice := NewLocalClient(h, "notes", 16)
+	bob := NewLocalClient(h, "notes", 16)
+	h.Register(alice)
+	h.Register(bob)
+	h.do(func() {}) // wait for the registrations
+	drainSystemMessages(t, alice.send)
+	drainSystemMessages(t, bob.send)
+
+	next := func(c *Client) *Message {
+		t.Helper()
+		select {
+		case data := <-c.Messages():
+			msg, err := MessageFromBytes(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("no message")
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name, traceID string
+		keep          bool
+	}{
+		{"chosen by client", "report-42", true},
+		{"missing", "", false},
+		{"unsafe", "a\nb", false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := operations.NewInsertOp(i, "x", i)
+			op.ID = fmt.Sprintf("op-%d", i)
+			data, _ := (&Message{Type: MsgTypeOperation, DocumentID: "notes", Operation: op, TraceID: tt.traceID}).ToBytes()
+			h.Submit(data, alice)
+
+			ack := next(alice)
+			if ack.Type != MsgTypeAck || !validTraceID(ack.TraceID) {
+				t.Fatalf("got %+v, want an ack with a trace ID", ack)
+			}
+			if tt.keep && ack.TraceID != tt.traceID {
+				t.Errorf("ack trace ID = %q, want %q", ack.TraceID, tt.traceID)
+			}
+			if !tt.keep && ack.TraceID == tt.traceID {
+				t.Errorf("ack trace ID = %q, want one assigned by the hub", ack.TraceID)
+			}
+			if got := next(bob); got.TraceID != ack.TraceID {
+				t.Errorf("collaborator's trace ID = %q, want %q", got.TraceID, ack.TraceID)
+			}
+			if e := <-sink; e.TraceID != ack.TraceID {
+				t.Errorf("event trace ID = %q, want %q", e.TraceID, ack.TraceID)
+			}
+		})
+	}
+}
+
+// TestCursors verifies cursors reach collaborators and late joiners, are
+// validated, and are removed when their client disconnects.
+func TestCursors(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	nextCursor := func(t *testing.T, c *Client) *Cursor {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type == MsgTypeCursor {
+					return msg.Cursor
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no cursor message")
+			}
+		}
+	}
+	send := func(c *Client, cursor string) {
+		h.Submit([]byte(`{"type":"cursor","document_id":"notes","cursor":`+cursor+`}`), c)
+	}
+
+	alice, bob := NewLocalClient(h, "notes", 16), NewLocalClient(h, "notes", 16)
+	h.Register(alice)
+	h.Register(bob)
+
+	send(alice, `{"client_id":"spoofed","anchor":2,"head":5,"name":"Alice","color":"#ff8800"}`)
+	want := Cursor{ClientID: alice.ID(), Anchor: 2, Head: 5, Name: "Alice", Color: "#ff8800"}
+	if c := nextCursor(t, bob); *c != want {
+		t.Errorf("cursor = %+v, want %+v", c, want)
+	}
+
+	send(alice, `{"anchor":1,"head":1,"color":"orange"}`)
+	send(alice, `{"anchor":-1,"head":1}`)
+	if got := h.Cursors("notes"); len(got) != 1 || got[0] != want {
+		t.Errorf("Cursors after invalid updates = %+v, want [%+v]", got, want)
+	}
+
+	late := NewLocalClient(h, "notes", 16)
+	h.Register(late)
+	if c := nextCursor(t, late); *c != want {
+		t.Errorf("late joiner got %+v, want %+v", c, want)
+	}
+
+	h.Unregister(alice)
+	if c := nextCursor(t, bob); c.ClientID != alice.ID() || !c.Left {
+		t.Errorf("got %+v, want alice's cursor removed", c)
+	}
+	if got := h.Cursors("notes"); len(got) != 0 {
+		t.Errorf("Cursors after disconnect = %+v, want none", got)
+	}
+}
+
+// TestPresenceRequest verifies a presence request is answered with every
+// collaborator in one message, with cursors and idle states.
+func TestPresenceRequest(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHub()
+	h.SetClock(fake)
+	go h.Run()
+	defer h.Shutdown()
+
+	presence := func(c *Client) []Collaborator {
+		t.Helper()
+		h.Submit([]byte(`{"type":"presence_request","document_id":"notes"}`), c)
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type == MsgTypePresence {
+					return msg.Presence
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no presence message")
+			}
+		}
+	}
+
+	alice, bob, carol := NewLocalClient(h, "notes", 64), NewLocalClient(h, "notes", 64), NewLocalClient(h, "notes", 64)
+	bob.SetDisplayName("Bob")
+	h.Register(alice)
+	h.Register(bob)
+	h.Register(carol)
+	h.Submit([]byte(`{"type":"cursor","document_id":"notes","cursor":{"anchor":2,"head":5,"name":"Alice"}}`), alice)
+	fake.Advance(3 * time.Minute)
+	h.Submit([]byte(`{"type":"operation","document_id":"notes","operation":{"type":"insert","position":0,"text":"hi","version":0}}`), bob)
+
+	got := presence(carol)
+	if len(got) != 2 {
+		t.Fatalf("presence = %+v, want alice and bob", got)
+	}
+	byID := map[string]Collaborator{got[0].ClientID: got[0], got[1].ClientID: got[1]}
+	a, b := byID[alice.ID()], byID[bob.ID()]
+	if a.Name != "Alice" || a.Cursor == nil || a.Cursor.Head != 5 || !a.Idle || a.IdleMS != 180000 {
+		t.Errorf("alice = %+v, want her cursor, idle for three minutes", a)
+	}
+	if b.Name != "Bob" || b.Cursor != nil || b.Idle || b.IdleMS != 0 {
+		t.Errorf("bob = %+v, want no cursor, active", b)
+	}
+	if got[0].ClientID > got[1].ClientID {
+		t.Errorf("presence not ordered by client ID: %+v", got)
+	}
+}
+
+// TestRateLimit verifies ReadPump drops messages over a client's rate
+// limit, warns it once per run of dropped messages and disconnects it when
+// the run goes on.
+func TestRateLimit(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHub()
+	h.SetClock(fake)
+	h.SetRateLimit(RateLimit{MessagesPerSecond: 10, Burst: 2, DisconnectAfter: 3})
+	go h.Run()
+	defer h.Shutdown()
+
+	conn := NewPipe()
+	c := NewClient(h, conn, "flood")
+	h.Register(c)
+	go c.WritePump()
+	go c.ReadPump()
+	send := func(text string) {
+		t.Helper()
+		op := `{"type":"operation","document_id":"flood","operation":{"id":"` + text + `","type":"insert","position":0,"text":"` + text + `","version":0}}`
+		if err := conn.Send([]byte(op)); err != nil {
+			t.Fatalf("Send() error: %v", err)
+		}
+	}
+	// warning returns the next rate_limited error the client receives.
+	warning := func() *Message {
+		t.Helper()
+		for {
+			frame, err := conn.Receive(time.Second)
+			if err != nil {
+				t.Fatalf("no rate_limited error: %v", err)
+			}
+			for _, line := range strings.Split(string(frame), "\n") {
+				if msg, err := MessageFromBytes([]byte(line)); err == nil && msg.Code == ErrorRateLimited {
+					return msg
+				}
+			}
+		}
+	}
+
+	send("a")
+	send("b")
+	send("c")
+	if msg := warning(); msg.AckID != "c" || msg.RetryAfterMS <= 0 || msg.RetryAfterMS > 101 {
+		t.Errorf("warning = %+v, want it for c with a retry within 100ms", msg)
+	}
+
+	fake.Advance(time.Second)
+	send("d")
+	send("e")
+	send("f")
+	send("g")
+	send("h")
+	select {
+	case <-c.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("flooding client was not disconnected")
+	}
+	if cause := context.Cause(c.Context()); cause != ErrRateLimited {
+		t.Errorf("cause = %v, want ErrRateLimited", cause)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(h.GetDocument("flood").GetContent()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := h.GetDocument("flood").GetContent(); len(got) != 4 || strings.ContainsAny(got, "cfgh") {
+		t.Errorf("content = %q, want only the four messages within the limit", got)
+	}
+}
+
+// TestFaultyConn verifies each fault applies to messages in both
+// directions, and that control frames pass untouched.
+func TestFaultyConn(t *testing.T) {
+	// exchange writes out through a faulty pipe and sends in to it, and
+	// returns what each side received.
+	exchange := func(f Faults, in, out []string) (read, written []string) {
+		pipe := NewPipe()
+		conn := NewFaultyConn(pipe, f)
+		for _, s := range out {
+			conn.WriteMessage(websocket.TextMessage, []byte(s))
+		}
+		for _, s := range in {
+			pipe.Send([]byte(s))
+		}
+		time.AfterFunc(20*time.Millisecond, func() { pipe.Close() })
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			read = append(read, string(data))
+		}
+		for {
+			data, err := pipe.Receive(10 * time.Millisecond)
+			if err != nil {
+				break
+			}
+			written = append(written, string(data))
+		}
+		return read, written
+	}
+
+	tests := []struct {
+		name   string
+		faults Faults
+		want   []string
+	}{
+		{"none", Faults{}, []string{"a", "b"}},
+		{"drop", Faults{Drop: 1}, nil},
+		{"duplicate", Faults{Duplicate: 1}, []string{"a", "a", "b", "b"}},
+		{"reorder", Faults{Reorder: 1}, []string{"b", "a"}},
+		{"delay", Faults{Delay: 1, MaxDelay: time.Millisecond}, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read, written := exchange(tt.faults, []string{"a", "b"}, []string{"a", "b"})
+			if !reflect.DeepEqual(read, tt.want) {
+				t.Errorf("read %q, want %q", read, tt.want)
+			}
+			if !reflect.DeepEqual(written, tt.want) {
+				t.Errorf("wrote %q, want %q", written, tt.want)
+			}
+		})
+	}
+
+	pipe := NewPipe()
+	NewFaultyConn(pipe, Faults{Drop: 1}).WriteMessage(websocket.PingMessage, nil)
+	if pipe.Pings() != 1 {
+		t.Error("ping dropped, want control frames untouched")
+	}
+}
+
+// fakeRedis is an in-memory Redis pub/sub server that delivers each
+// subscriber's messages in order from its own goroutine, and refuses
+// publishing while down.
+type fakeRedis struct {
+	mu   sync.Mutex
+	subs map[string][]chan [2]string // by pattern prefix
+	down bool
+}
+
+func (r *fakeRedis) setDown(down bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.down = down
+}
+
+func (r *fakeRedis) Publish(channel string, payload []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return errors.New("connection refused")
+	}
+	for prefix, subs := range r.subs {
+		if strings.HasPrefix(channel, prefix) {
+			for _, sub := range subs {
+				sub <- [2]string{channel, string(payload)}
+			}
+		}
+	}
+	return nil
+}
+
+func (r *fakeRedis) PSubscribe(pattern string, handler func(channel string, payload []byte)) error {
+	sub := make(chan [2]string, 64)
+	r.mu.Lock()
+	r.subs[strings.TrimSuffix(pattern, "*")] = append(r.subs[strings.TrimSuffix(pattern, "*")], sub)
+	r.mu.Unlock()
+	go func() {
+		for m := range sub {
+			handler(m[0], []byte(m[1]))
+		}
+	}()
+	return nil
+}
+
+// TestRedisBroker verifies that edits made on one hub reach the clients
+// and document of another hub sharing the Redis channel, in both
+// directions, and that documents a node has not loaded are left alone.
+func TestRedisBroker(t *testing.T) {
+	redis := &fakeRedis{subs: make(map[string][]chan [2]string)}
+	start := func() *Hub {
+		h := NewHub()
+		b := NewRedisBroker(redis, "")
+		t.Cleanup(b.Close)
+		if err := h.SetBroker(b); err != nil {
+			t.Fatal(err)
+		}
+		go h.Run()
+		t.Cleanup(h.Shutdown)
+		return h
+	}
+	h1, h2 := start(), start()
+
+	alice, bob := NewLocalClient(h1, "notes", 16), NewLocalClient(h2, "notes", 16)
+	h1.Register(alice)
+	h2.Register(bob)
+
+	nextOperation := func(t *testing.T, c *Client) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type == MsgTypeOperation {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no operation message")
+			}
+		}
+	}
+	waitContent := func(h *Hub, want string) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			if doc := h.GetDocument("notes"); doc != nil && doc.GetContent() == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("content = %q, want %q", h.GetDocument("notes").GetContent(), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	h1.Submit([]byte(`{"type":"operation","document_id":"notes","operation":{"type":"insert","position":0,"text":"hello","version":0},"trace_id":"t-1"}`), alice)
+	waitContent(h2, "hello")
+	msg := nextOperation(t, bob)
+	if msg.Operation.Text != "hello" || msg.Operation.Version != 1 || msg.TraceID != "t-1" {
+		t.Errorf("bob got %+v (trace %q), want hello at version 1 (trace t-1)", msg.Operation, msg.TraceID)
+	}
+
+	h2.Submit([]byte(`{"type":"operation","document_id":"notes","operation":{"type":"insert","position":5,"text":" world","version":1}}`), bob)
+	waitContent(h1, "hello world")
+	waitContent(h2, "hello world")
+	if v1, v2 := h1.GetDocument("notes").GetVersion(), h2.GetDocument("notes").GetVersion(); v1 != 2 || v2 != 2 {
+		t.Errorf("versions = %d, %d, want 2, 2", v1, v2)
+	}
+
+	h1.Submit([]byte(`{"type":"operation","document_id":"draft","operation":{"type":"insert","position":0,"text":"x","version":0}}`), nil)
+	waitContent(h1, "hello world")
+	time.Sleep(20 * time.Millisecond)
+	if doc := h2.GetDocument("draft"); doc != nil {
+		t.Errorf("h2 loaded draft with %q, want it left alone", doc.GetContent())
+	}
+}
+
+// TestRedisBrokerOutage verifies that edits made while Redis is down reach
+// the other node once it is back, as stored operations or, past the
+// backlog, as a snapshot that the publisher's later operations build on.
+func TestRedisBrokerOutage(t *testing.T) {
+	redis := &fakeRedis{subs: make(map[string][]chan [2]string)}
+	start := func() *Hub {
+		h := NewHub()
+		b := newRedisBroker(redis, "", 2, 10*time.Millisecond)
+		t.Cleanup(b.Close)
+		if err := h.SetBroker(b); err != nil {
+			t.Fatal(err)
+		}
+		go h.Run()
+		t.Cleanup(h.Shutdown)
+		return h
+	}
+	h1, h2 := start(), start()
+	alice, bob := NewLocalClient(h1, "notes", 64), NewLocalClient(h2, "notes", 64)
+	h1.Register(alice)
+	h2.Register(bob)
+	waitContent := func(h *Hub, want string) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			if doc := h.GetDocument("notes"); doc != nil && doc.GetContent() == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("content = %q, want %q", h.GetDocument("notes").GetContent(), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	insert := func(position int, text string, version int) {
+		h1.Submit([]byte(fmt.Sprintf(`{"type":"operation","document_id":"notes","operation":{"type":"insert","position":%d,"text":%q,"version":%d}}`, position, text, version)), alice)
+	}
+
+	redis.setDown(true)
+	insert(0, "hello", 0)
+	time.Sleep(30 * time.Millisecond)
+	if doc := h2.GetDocument("notes"); doc != nil && doc.GetContent() != "" {
+		t.Fatalf("h2 content during the outage = %q, want none", doc.GetContent())
+	}
+	redis.setDown(false)
+	waitContent(h2, "hello")
+
+	redis.setDown(true)
+	insert(5, " a", 1)
+	insert(7, " b", 2)
+	insert(9, " c", 3)
+	time.Sleep(30 * time.Millisecond)
+	redis.setDown(false)
+	waitContent(h2, "hello a b c")
+
+	insert(11, "!", 4)
+	waitContent(h2, "hello a b c!")
+	waitContent(h1, "hello a b c!")
+}
+
+// TestCoalesceWindow verifies operations are held for the window and a
+// sender's keystrokes composed, and that held operations reach a client
+// ahead of its acknowledgement but never reach one that joined since.
+func TestCoalesceWindow(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHub()
+	h.SetClock(fake)
+	h.SetCoalesceWindow(50 * time.Millisecond)
+	go h.Run()
+	defer h.Shutdown()
+
+	next := func(t *testing.T, c *Client) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				msg, err := MessageFromBytes(data)
+				if err != nil || msg.Type == MsgTypeUserCount || msg.Type == MsgTypeWelcome || msg.Type == MsgTypeSync {
+					continue
+				}
+				return msg
+			case <-time.After(time.Second):
+				t.Fatal("no message")
+			}
+		}
+	}
+	none := func(t *testing.T, c *Client) {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type != MsgTypeUserCount && msg.Type != MsgTypeWelcome && msg.Type != MsgTypeSync {
+					t.Fatalf("unexpected %s message", msg.Type)
+				}
+			default:
+				return
+			}
+		}
+	}
+	insert := func(c *Client, pos int, text, id string, version int) {
+		h.Submit([]byte(fmt.Sprintf(`{"type":"operation","document_id":"fast-doc","operation":{"type":"insert","position":%d,"text":%q,"version":%d,"id":%q}}`, pos, text, version, id)), c)
+	}
+
+	typist := NewLocalClient(h, "fast-doc", 16)
+	reader := NewLocalClient(h, "fast-doc", 16)
+	h.Register(typist)
+	h.Register(reader)
+
+	insert(typist, 0, "h", "t1", 0)
+	insert(typist, 1, "i", "t2", 1)
+	if ack := next(t, typist); ack.Type != MsgTypeAck || ack.AckID != "t1" {
+		t.Errorf("typist got %+v, want the ack at once", ack)
+	}
+	none(t, reader)
+
+	fake.Advance(50 * time.Millisecond)
+	msg := next(t, reader)
+	if msg.Type != MsgTypeOperation || msg.Operation.Text != "hi" || msg.Operation.Version != 2 {
+		t.Fatalf("reader got %+v, want the keystrokes composed at version 2", msg)
+	}
+
+	// The reader's edit is acknowledged only after the edit it follows.
+	insert(typist, 2, "!", "t3", 2)
+	insert(reader, 0, ">", "r1", 2)
+	if msg := next(t, reader); msg.Type != MsgTypeOperation || msg.Operation.Text != "!" {
+		t.Fatalf("reader got %+v, want the held operation first", msg)
+	}
+	if ack := next(t, reader); ack.Type != MsgTypeAck || ack.Version != 4 {
+		t.Errorf("reader got %+v, want its ack at version 4", ack)
+	}
+
+	// A client joining gets held operations in its sync, not again after.
+	fake.Advance(50 * time.Millisecond)
+	for msg := next(t, typist); msg.Type != MsgTypeOperation; msg = next(t, typist) {
+	}
+	insert(typist, 4, "?", "t4", 4)
+	if ack := next(t, typist); ack.Type != MsgTypeAck || ack.AckID != "t4" {
+		t.Errorf("typist got %+v, want its ack", ack)
+	}
+	late := NewLocalClient(h, "fast-doc", 16)
+	h.Register(late)
+	if msg := next(t, reader); msg.Type != MsgTypeOperation || msg.Operation.Text != "?" {
+		t.Errorf("reader got %+v, want the held operation when a client joins", msg)
+	}
+	fake.Advance(50 * time.Millisecond)
+	none(t, late)
+	if got := h.GetDocument("fast-doc").GetContent(); got != ">hi!?" {
+		t.Errorf("content = %q, want %q", got, ">hi!?")
+	}
+}
+
+// TestAuthors verifies relayed operations name the client that made them,
+// whatever the message claims, and that ClientsForDocument lists editors.
+func TestAuthors(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	nextOperation := func(t *testing.T, c *Client) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type == MsgTypeOperation {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no operation message")
+			}
+		}
+	}
+
+	alice, bob := NewLocalClient(h, "team-doc", 16), NewLocalClient(h, "team-doc", 16)
+	alice.SetIdentity("u-alice", "")
+	alice.SetDisplayName("Alice")
+	bob.SetIdentity("u-bob", "")
+	viewer := NewReadOnlyClient(h, nil, "team-doc")
+	h.Register(alice)
+	h.Register(bob)
+	h.Register(viewer)
+
+	h.Submit([]byte(`{"type":"operation","document_id":"team-doc","author":{"client_id":"1","name":"Mallory"},"operation":{"type":"insert","position":0,"text":"hi","version":0}}`), alice)
+	want := Author{ClientID: alice.ID(), UserID: "u-alice", Name: "Alice"}
+	if msg := nextOperation(t, bob); msg.Author == nil || *msg.Author != want {
+		t.Errorf("author = %+v, want %+v", msg.Author, want)
+	}
+	h.Submit([]byte(`{"type":"undo","document_id":"team-doc"}`), alice)
+	if msg := nextOperation(t, bob); msg.Author == nil || *msg.Author != want {
+		t.Errorf("undo author = %+v, want %+v", msg.Author, want)
+	}
+	if _, err := h.ReplaceContent("team-doc", "server text", 2); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+	if msg := nextOperation(t, bob); msg.Author != nil {
+		t.Errorf("server operation author = %+v, want none", msg.Author)
+	}
+
+	editors := h.ClientsForDocument("team-doc")
+	wantEditors := []Author{want, {ClientID: bob.ID(), UserID: "u-bob", Name: "u-bob"}}
+	if !reflect.DeepEqual(editors, wantEditors) {
+		t.Errorf("ClientsForDocument() = %+v, want %+v", editors, wantEditors)
+	}
+}
+
+// TestPreferences verifies that a user's preferences are stored, validated,
+// sent to the user's later connections and used as the cursor color, and
+// that erasing the user deletes them.
+func TestPreferences(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	next := func(t *testing.T, c *Client, typ MessageType) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type == typ {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("no %s message", typ)
+			}
+		}
+	}
+
+	alice, anon := NewLocalClient(h, "pref-doc", 16), NewLocalClient(h, "pref-doc", 16)
+	alice.SetIdentity("u-alice", "")
+	h.Register(alice)
+	h.Register(anon)
+
+	h.Submit([]byte(`{"type":"preferences_set","document_id":"pref-doc","preferences":{"cursor_color":"#00aa00","scroll_position":5}}`), alice)
+	msg := next(t, alice, MsgTypePreferences)
+	if p := msg.Preferences; p == nil || p.CursorColor != "#00aa00" || p.ScrollPosition != 5 || p.UpdatedAt.IsZero() {
+		t.Errorf("stored preferences = %+v", p)
+	}
+
+	// Invalid preferences and those of connections without a user are
+	// not stored.
+	h.Submit([]byte(`{"type":"preferences_set","document_id":"pref-doc","preferences":{"cursor_color":"red"}}`), alice)
+	h.Submit([]byte(`{"type":"preferences_set","document_id":"pref-doc","preferences":{"last_read_version":9}}`), alice)
+	h.Submit([]byte(`{"type":"preferences_set","document_id":"pref-doc","preferences":{"scroll_position":1}}`), anon)
+	h.Submit([]byte(`{"type":"preferences_get","document_id":"pref-doc"}`), alice)
+	if p := next(t, alice, MsgTypePreferences).Preferences; p == nil || p.CursorColor != "#00aa00" {
+		t.Errorf("preferences after invalid updates = %+v", p)
+	}
+	h.Submit([]byte(`{"type":"preferences_get","document_id":"pref-doc"}`), anon)
+	if p := next(t, anon, MsgTypePreferences).Preferences; p == nil || *p != (document.Preferences{}) {
+		t.Errorf("anonymous preferences = %+v, want none", p)
+	}
+
+	// The user's next connection resumes with them, and shares its cursor
+	// in the stored color.
+	again := NewLocalClient(h, "pref-doc", 16)
+	again.SetIdentity("u-alice", "")
+	h.Register(again)
+	if p := next(t, again, MsgTypePreferences).Preferences; p == nil || p.ScrollPosition != 5 {
+		t.Errorf("preferences on connect = %+v", p)
+	}
+	h.Submit([]byte(`{"type":"cursor","document_id":"pref-doc","cursor":{"anchor":0,"head":0}}`), again)
+	if c := next(t, anon, MsgTypeCursor).Cursor; c == nil || c.Color != "#00aa00" {
+		t.Errorf("cursor = %+v, want the stored color", c)
+	}
+
+	report, err := h.EraseUser("u-alice", "")
+	if err != nil || report.Preferences != 1 || !reflect.DeepEqual(report.Documents, []string{"pref-doc"}) {
+		t.Errorf("EraseUser() = %+v, %v", report, err)
+	}
+	if _, ok := h.GetDocument("pref-doc").Preferences("u-alice"); ok {
+		t.Error("preferences kept after erasure")
+	}
+}
+
+// TestWorkflowState verifies that editors can move a document through the
+// workflow states, that collaborators see the change, and that unknown
+// states and read-only sessions are refused.
+func TestWorkflowState(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	editor, other := NewLocalClient(h, "review-doc", 16), NewLocalClient(h, "review-doc", 16)
+	viewer := NewReadOnlyClient(h, nil, "review-doc")
+	h.Register(editor)
+	h.Register(other)
+	h.Register(viewer)
+	h.do(func() {}) // wait for the registrations
+	for len(other.Messages()) > 0 {
+		<-other.Messages()
+	}
+
+	doc := h.GetOrCreateDocument("review-doc")
+	if got := doc.WorkflowState(); got != document.WorkflowDraft {
+		t.Errorf("initial state = %q, want draft", got)
+	}
+
+	h.Submit([]byte(`{"type":"metadata_set","document_id":"review-doc","metadata":{"workflow_state":"in-review"}}`), editor)
+	select {
+	case data := <-other.Messages():
+		if msg, _ := MessageFromBytes(data); msg.Type != MsgTypeMetadata || msg.Metadata["workflow_state"] != "in-review" {
+			t.Errorf("collaborator received %+v, want the new state", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("collaborator not notified")
+	}
+
+	h.Submit([]byte(`{"type":"metadata_set","document_id":"review-doc","metadata":{"workflow_state":"approved"}}`), editor)
+	h.Submit([]byte(`{"type":"metadata_set","document_id":"review-doc","metadata":{"workflow_state":"final"}}`), viewer)
+	if got := doc.WorkflowState(); got != document.WorkflowInReview {
+		t.Errorf("state = %q after refused changes, want in-review", got)
+	}
+
+	if err := h.SetDocumentMetadata("review-doc", map[string]string{"workflow_state": ""}); err != nil {
+		t.Fatalf("SetDocumentMetadata() error: %v", err)
+	}
+	if got := doc.WorkflowState(); got != document.WorkflowDraft {
+		t.Errorf("state = %q after clearing, want draft", got)
+	}
+}
+
+// TestScheduledActions verifies that scheduled locks, publishing and invite
+// expiry run when due and are announced, that the scheduler only runs while
+// actions wait, and that a restarted hub finds actions in its store.
+func TestScheduledActions(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHub()
+	h.SetClock(fake)
+	var fired []string
+	h.AddEventSink(sinkFunc(func(e events.Event) {
+		if e.Type == events.TypeScheduleFired {
+			fired = append(fired, e.Detail)
+		}
+	}))
+	go h.Run()
+	defer h.Shutdown()
+
+	next := func(t *testing.T, c *Client, want MessageType) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && msg.Type == want {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("no %s message", want)
+			}
+		}
+	}
+	waitTickers := func(t *testing.T, fake *clock.Fake, n int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for fake.TickerCount() != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d tickers running, want %d", fake.TickerCount(), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if err := h.CreateDocument("timed", "hello"); err != nil {
+		t.Fatalf("CreateDocument() error: %v", err)
+	}
+	inv, err := h.CreateInvite("timed", document.RoleViewer, 0, 0)
+	if err != nil {
+		t.Fatalf("CreateInvite() error: %v", err)
+	}
+	client := NewLocalClient(h, "timed", 16)
+	h.Register(client)
+
+	start := fake.Now()
+	if _, err := h.ScheduleAction("timed", document.ScheduledAction{Action: "explode", At: start}); !errors.Is(err, ErrUnknownAction) {
+		t.Errorf("unknown action error = %v, want ErrUnknownAction", err)
+	}
+	if _, err := h.ScheduleAction("missing", document.ScheduledAction{Action: document.ActionLock, At: start}); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("missing document error = %v, want ErrDocumentNotFound", err)
+	}
+	if _, err := h.ScheduleAction("timed", document.ScheduledAction{Action: document.ActionExpireInvite, At: start, Invite: "nope"}); !errors.Is(err, document.ErrInviteNotFound) {
+		t.Errorf("unknown invite error = %v, want ErrInviteNotFound", err)
+	}
+	if n := fake.TickerCount(); n != 0 {
+		t.Errorf("%d tickers running with nothing scheduled", n)
+	}
+
+	for _, a := range []document.ScheduledAction{
+		{Action: document.ActionUnlock, At: start.Add(3 * time.Minute)},
+		{Action: document.ActionLock, At: start.Add(time.Minute), Reason: "exam"},
+		{Action: document.ActionPublish, At: start.Add(2 * time.Minute)},
+		{Action: document.ActionExpireInvite, At: start.Add(2 * time.Minute), Invite: inv.Token},
+	} {
+		if _, err := h.ScheduleAction("timed", a); err != nil {
+			t.Fatalf("ScheduleAction(%s) error: %v", a.Action, err)
+		}
+	}
+	cancelled, _ := h.ScheduleAction("timed", document.ScheduledAction{Action: document.ActionPublish, At: start.Add(time.Hour)})
+	if err := h.CancelScheduled("timed", cancelled.ID); err != nil {
+		t.Errorf("CancelScheduled() error: %v", err)
+	}
+	if err := h.CancelScheduled("timed", cancelled.ID); !errors.Is(err, document.ErrScheduledNotFound) {
+		t.Errorf("second CancelScheduled() error = %v, want ErrScheduledNotFound", err)
+	}
+	if actions := h.ScheduledActions("timed"); len(actions) != 4 || actions[0].Action != document.ActionLock {
+		t.Fatalf("ScheduledActions() = %+v, want 4 starting with the lock", actions)
+	}
+
+	waitTickers(t, fake, 1)
+	fake.Advance(time.Minute)
+	if msg := next(t, client, MsgTypeDocumentPaused); msg.Reason != "exam" {
+		t.Errorf("paused message = %+v, want the lock's reason", msg)
+	}
+	if msg := next(t, client, MsgTypeScheduleFired); msg.Schedule == nil || msg.Schedule.Action != document.ActionLock {
+		t.Errorf("schedule_fired = %+v, want the lock", msg)
+	}
+
+	fake.Advance(time.Minute)
+	next(t, client, MsgTypePublished)
+	if p, ok := h.GetDocument("timed").Published(); !ok || p.Content != "hello" {
+		t.Errorf("published %+v, %v; want the content as of then", p, ok)
+	}
+	if _, _, err := h.RedeemInvite("timed", inv.Token, "Carol"); !errors.Is(err, document.ErrInviteExpired) {
+		t.Errorf("RedeemInvite() after expiry error = %v, want ErrInviteExpired", err)
+	}
+
+	fake.Advance(time.Minute)
+	next(t, client, MsgTypeDocumentResumed)
+	waitTickers(t, fake, 0)
+	h.do(func() {})
+	if want := []string{"lock", "publish", "expire_invite", "unlock"}; !slices.Equal(fired, want) {
+		t.Errorf("schedule_fired events = %v, want %v", fired, want)
+	}
+	if actions := h.ScheduledActions("timed"); len(actions) != 0 {
+		t.Errorf("ScheduledActions() = %+v after all ran", actions)
+	}
+
+	// Actions outlive the hub when it has a store.
+	ms := &memoryStore{docs: make(map[string]*document.Snapshot)}
+	stored := NewHub()
+	stored.SetStore(ms, time.Hour)
+	go stored.Run()
+	stored.CreateDocument("later", "")
+	if _, err := stored.ScheduleAction("later", document.ScheduledAction{Action: document.ActionLock, At: start.Add(time.Hour)}); err != nil {
+		t.Fatalf("ScheduleAction() error: %v", err)
+	}
+	stored.Shutdown()
+
+	restarted := NewHub()
+	restarted.SetClock(fake)
+	restarted.SetStore(ms, time.Hour)
+	go restarted.Run()
+	defer restarted.Shutdown()
+	waitTickers(t, fake, 2) // Saving and the scheduler
+	fake.Advance(time.Hour)
+	deadline := time.Now().Add(time.Second)
+	for !restarted.IsPaused("later") {
+		if time.Now().After(deadline) {
+			t.Fatal("stored lock did not run after a restart")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestCompaction verifies that a document's history is compacted after the
+// policy's number of operations and on its interval, once a snapshot is
+// stored, keeping the operations it retains.
+func TestCompaction(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	ms := &memoryStore{docs: make(map[string]*document.Snapshot)}
+	h := NewHub()
+	h.SetClock(fake)
+	h.SetStore(ms, time.Hour)
+	h.SetCompaction(CompactionPolicy{Operations: 5, Interval: time.Minute, Retain: 2})
+	compacted := make(chan events.Event, 4)
+	h.AddEventSink(sinkFunc(func(e events.Event) {
+		if e.Type == events.TypeDocumentCompacted {
+			compacted <- e
+		}
+	}))
+	go h.Run()
+	defer h.Shutdown()
+
+	insert := func(version int) {
+		h.Submit([]byte(fmt.Sprintf(`{"type":"operation","document_id":"log-doc","operation":{"type":"insert","position":0,"text":"x","version":%d}}`, version)), nil)
+	}
+	for v := 0; v < 4; v++ {
+		insert(v)
+	}
+	doc := h.GetDocument("log-doc")
+	if ops, err := doc.OperationsSince(0); err != nil || len(ops) != 4 {
+		t.Fatalf("OperationsSince(0) before compaction = %d operations, %v", len(ops), err)
+	}
+	insert(4)
+	select {
+	case e := <-compacted:
+		if e.Version != 5 || e.Detail != "3" {
+			t.Errorf("compaction event = %+v, want version 5 dropping 3", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not compacted after 5 operations")
+	}
+	if v, _ := ms.version("log-doc"); v != 5 {
+		t.Errorf("stored version %d at compaction, want 5", v)
+	}
+	if _, err := doc.OperationsSince(2); !errors.Is(err, document.ErrVersionUnavailable) {
+		t.Errorf("OperationsSince(2) after compaction error = %v, want ErrVersionUnavailable", err)
+	}
+	if ops, err := doc.OperationsSince(3); err != nil || len(ops) != 2 {
+		t.Errorf("OperationsSince(3) = %d operations, %v; want the 2 retained", len(ops), err)
+	}
+
+	// Edits that bypass the hub's message path are compacted on the interval.
+	for v := 5; v < 8; v++ {
+		doc.ApplyOperation(&operations.Operation{Type: operations.OpInsert, Position: 0, Text: "y", Version: v})
+	}
+	for fake.TickerCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	fake.Advance(time.Minute)
+	select {
+	case e := <-compacted:
+		if e.Version != 8 || e.Detail != "3" {
+			t.Errorf("interval compaction event = %+v, want version 8 dropping 3", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not compacted on the interval")
+	}
+	if v, _ := ms.version("log-doc"); v != 8 {
+		t.Errorf("stored version %d at interval compaction, want 8", v)
+	}
+}
+
+// TestEditErrors verifies that the sender of an edit that fails receives an
+// error with a code and the current version, and that block, JSON and
+// content edits are acknowledged by their message's ack_id.
+func TestEditErrors(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	reply := func(t *testing.T, c *Client, data string) *Message {
+		t.Helper()
+		h.Submit([]byte(data), c)
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, err := MessageFromBytes(data); err == nil && (msg.Type == MsgTypeAck || msg.Type == MsgTypeError) {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no ack or error")
+			}
+		}
+	}
+
+	h.GetOrCreateDocument("text-doc").SetContent("abc") // version 1
+	editor := NewLocalClient(h, "text-doc", 16)
+	h.Register(editor)
+
+	msg := reply(t, editor, `{"type":"operation","document_id":"text-doc","operation":{"id":"op-1","type":"insert","position":99,"text":"x","version":1}}`)
+	if msg.Type != MsgTypeError || msg.Code != ErrorInvalidOperation || msg.AckID != "op-1" || msg.Version != 1 || msg.Reason == "" {
+		t.Errorf("out of range operation reply = %+v, want invalid_operation error at version 1", msg)
+	}
+	msg = reply(t, editor, `{"type":"json_operation","document_id":"text-doc","ack_id":"j-1","json_operation":{"type":"set","path":["a"],"value":1}}`)
+	if msg.Type != MsgTypeError || msg.Code != ErrorWrongKind || msg.AckID != "j-1" {
+		t.Errorf("JSON operation on text reply = %+v, want wrong_kind error", msg)
+	}
+	msg = reply(t, editor, `{"type":"content","document_id":"text-doc","ack_id":"c-1","content":"abcd"}`)
+	if msg.Type != MsgTypeAck || msg.AckID != "c-1" || msg.Version != 2 {
+		t.Errorf("content reply = %+v, want ack at version 2", msg)
+	}
+	msg = reply(t, editor, `{"type":"operation","document_id":"text-doc","operation":{"id":"op-2","type":"insert","position":4,"text":"e","version":2}}`)
+	if msg.Type != MsgTypeAck || msg.AckID != "op-2" || msg.Version != 3 {
+		t.Errorf("operation reply = %+v, want ack at version 3", msg)
+	}
+	msg = reply(t, editor, `{"type":"metadata_set","document_id":"text-doc","ack_id":"m-1","metadata":{"workflow_state":"shelved"}}`)
+	if msg.Type != MsgTypeError || msg.Code != ErrorInvalidOperation || msg.AckID != "m-1" || msg.Version != 3 {
+		t.Errorf("invalid metadata reply = %+v, want invalid_operation error at version 3", msg)
+	}
+
+	modeler := NewLocalClient(h, "json-doc", 16)
+	h.Register(modeler)
+	msg = reply(t, modeler, `{"type":"json_operation","document_id":"json-doc","ack_id":"j-2","json_operation":{"type":"set","path":["a"],"value":1}}`)
+	if msg.Type != MsgTypeAck || msg.AckID != "j-2" || msg.Version != 1 {
+		t.Errorf("JSON operation reply = %+v, want ack at version 1", msg)
+	}
+	msg = reply(t, modeler, `{"type":"operation","document_id":"json-doc","operation":{"id":"op-3","type":"insert","position":0,"text":"x","version":1}}`)
+	if msg.Type != MsgTypeError || msg.Code != ErrorWrongKind || msg.AckID != "op-3" || msg.Version != 1 {
+		t.Errorf("text operation on JSON reply = %+v, want wrong_kind error at version 1", msg)
+	}
+	msg = reply(t, modeler, `{"type":"content","document_id":"json-doc","ack_id":"c-2","content":"not json at all"}`)
+	if msg.Type != MsgTypeError || msg.Code != ErrorWrongKind || msg.AckID != "c-2" || msg.Version != 1 {
+		t.Errorf("content on JSON reply = %+v, want wrong_kind error at version 1", msg)
+	}
+	msg = reply(t, modeler, `{"type":"json_operation","document_id":"json-doc","ack_id":"j-3","json_operation":{"type":"set","path":["b"],"value":2}}`)
+	if msg.Type != MsgTypeAck || msg.AckID != "j-3" || msg.Version != 2 {
+		t.Errorf("JSON operation after refused content reply = %+v, want ack at version 2", msg)
+	}
+}
+
+// TestMux verifies sessions multiplexed over one connection: each is a
+// client of its own on its document, messages are routed by session both
+// ways, and sessions end when closed, refused or the connection drops.
+func TestMux(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	conn := NewPipe()
+	mux := NewMux(h, conn, func(session, documentID string, readOnly bool, conn Conn) error {
+		if documentID == "mux-secret" {
+			return errors.New("forbidden")
+		}
+		c := NewClient(h, conn, documentID)
+		h.Register(c)
+		go c.WritePump()
+		go c.ReadPump()
+		return nil
+	})
+	go mux.Run()
+
+	// next returns the first message matching want, skipping others.
+	next := func(what string, want func(*Message) bool) *Message {
+		t.Helper()
+		for {
+			frame, err := conn.Receive(time.Second)
+			if err != nil {
+				t.Fatalf("waiting for %s: %v", what, err)
+			}
+			for _, line := range strings.Split(string(frame), "\n") {
+				msg, err := MessageFromBytes([]byte(line))
+				if err != nil {
+					t.Fatalf("malformed message %q: %v", line, err)
+				}
+				if msg.Session == "" {
+					t.Fatalf("message without a session: %s", line)
+				}
+				if want(msg) {
+					return msg
+				}
+			}
+		}
+	}
+	send := func(msg string) {
+		t.Helper()
+		if err := conn.Send([]byte(msg)); err != nil {
+			t.Fatalf("Send() error: %v", err)
+		}
+	}
+	waitCount := func(documentID string, want int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for h.ClientCountForDocument(documentID) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s has %d clients, want %d", documentID, h.ClientCountForDocument(documentID), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	closed := func(session string) string {
+		t.Helper()
+		return next(session+" closed", func(m *Message) bool {
+			return m.Type == MsgTypeSessionClosed && m.Session == session
+		}).Reason
+	}
+
+	for _, open := range []string{
+		`{"type":"session_open","session":"tab1","document_id":"mux-a"}`,
+		`{"type":"session_open","session":"tab2","document_id":"mux-a"}`,
+		`{"type":"session_open","session":"tab3","document_id":"mux-b"}`,
+		`{"type":"session_open","session":"tab4","document_id":"mux-secret"}`,
+	} {
+		send(open)
+	}
+	if reason := closed("tab4"); reason != "forbidden" {
+		t.Errorf("refused session reason = %q, want forbidden", reason)
+	}
+	waitCount("mux-a", 2)
+	waitCount("mux-b", 1)
+
+	send(`{"type":"operation","session":"tab1","document_id":"mux-a","operation":{"id":"op1","type":"insert","position":0,"text":"hi","version":0}}`)
+	var relayed, ack *Message
+	next("operation and ack", func(m *Message) bool {
+		switch m.Type {
+		case MsgTypeOperation:
+			relayed = m
+		case MsgTypeAck:
+			ack = m
+		}
+		return relayed != nil && ack != nil
+	})
+	if relayed.Session != "tab2" || relayed.Operation.Text != "hi" {
+		t.Errorf("operation relayed to %s with %+v, want tab2 with the insert", relayed.Session, relayed.Operation)
+	}
+	if ack.Session != "tab1" || ack.AckID != "op1" {
+		t.Errorf("ack %q went to %s, want op1 to tab1", ack.AckID, ack.Session)
+	}
+	if doc := h.GetDocument("mux-b"); doc != nil && doc.GetContent() != "" {
+		t.Errorf("mux-b content = %q, want it untouched", doc.GetContent())
+	}
+
+	send(`{"type":"sync_request","session":"tab9"}`)
+	if reason := closed("tab9"); reason != "session not open" {
+		t.Errorf("unknown session reason = %q, want session not open", reason)
+	}
+
+	send(`{"type":"session_close","session":"tab2"}`)
+	if reason := closed("tab2"); reason != "" {
+		t.Errorf("closed session reason = %q, want none", reason)
+	}
+	waitCount("mux-a", 1)
+
+	conn.Close()
+	waitCount("mux-a", 0)
+	waitCount("mux-b", 0)
+}
+
+// TestIdleEviction verifies documents without clients are saved and
+// unloaded once idle for the TTL, documents with clients are kept, and
+// EvictDocument does the same on demand.
+func TestIdleEviction(t *testing.T) {
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	ms := &memoryStore{docs: make(map[string]*document.Snapshot)}
+	h := NewHub()
+	h.SetClock(fake)
+	h.SetStore(ms, time.Hour)
+	h.SetIdleTTL(10 * time.Minute)
+	evicted := make(chan events.Event, 4)
+	h.AddEventSink(sinkFunc(func(e events.Event) {
+		if e.Type == events.TypeDocumentEvicted {
+			evicted <- e
+		}
+	}))
+	go h.Run()
+	defer h.Shutdown()
+
+	h.GetOrCreateDocument("idle").SetContent("kept")
+	h.GetOrCreateDocument("busy")
+	h.Register(NewLocalClient(h, "busy", 64))
+	for h.ClientCountForDocument("busy") != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if n := h.DocumentCount(); n != 2 {
+		t.Errorf("DocumentCount() = %d, want 2", n)
+	}
+	if err := h.EvictDocument("busy"); !errors.Is(err, ErrDocumentBusy) {
+		t.Errorf("EvictDocument(busy) error = %v, want ErrDocumentBusy", err)
+	}
+	if err := h.EvictDocument("missing"); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("EvictDocument(missing) error = %v, want ErrDocumentNotFound", err)
+	}
+
+	for fake.TickerCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	fake.Advance(9 * time.Minute)
+	fake.Advance(2 * time.Minute)
+	select {
+	case e := <-evicted:
+		if e.DocumentID != "idle" || e.Detail != DeleteReasonUnloaded {
+			t.Errorf("eviction event = %+v, want idle unloaded", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle document not evicted after the TTL")
+	}
+	if n := h.DocumentCount(); n != 1 {
+		t.Errorf("DocumentCount() after eviction = %d, want 1", n)
+	}
+	if v, _ := ms.version("idle"); v != 1 {
+		t.Errorf("stored version = %d, want 1", v)
+	}
+	if doc := h.GetDocument("idle"); doc == nil || doc.GetContent() != "kept" {
+		t.Error("evicted document did not load again from the store")
+	}
+
+	// Without a store, an evicted document is gone.
+	h2 := NewHub()
+	h2.GetOrCreateDocument("gone")
+	if err := h2.EvictDocument("gone"); err != nil {
+		t.Fatalf("EvictDocument() error: %v", err)
+	}
+	if h2.DocumentCount() != 0 || !h2.IsDeleted("gone") {
+		t.Error("evicted document without a store is still loaded or not refused")
+	}
+}
+
+// TestContentDeltas verifies content changes reach clients that asked for
+// deltas as an operation batch against the version they hold, and reach
+// other clients, or everyone when the delta is no smaller, as content.
+func TestContentDeltas(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	lines := strings.Repeat("the quick brown fox jumps over the lazy dog. ", 10)
+	content := lines + "the end"
+	h.GetOrCreateDocument("delta-doc").SetContent(content)
+	legacy := NewLocalClient(h, "delta-doc", 16)
+	plain := NewLocalClient(h, "delta-doc", 16)
+	modern := NewLocalClient(h, "delta-doc", 16)
+	for _, c := range []*Client{legacy, plain, modern} {
+		h.Register(c)
+	}
+	h.Submit([]byte(`{"type":"sync_mode","document_id":"delta-doc","sync":{"content_deltas":true}}`), modern)
+	h.do(func() {})
+
+	next := func(t *testing.T, c *Client) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				msg, err := MessageFromBytes(data)
+				if err == nil && (msg.Type == MsgTypeContent || msg.Type == MsgTypeOperationBatch) {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no content change")
+			}
+		}
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    MessageType
+	}{
+		{"small edit", lines + "the very end", MsgTypeOperationBatch},
+		{"rewrite", "x", MsgTypeContent},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.Submit([]byte(`{"type":"content","document_id":"delta-doc","content":"`+tt.content+`"}`), legacy)
+			if got := next(t, plain); got.Type != MsgTypeContent || got.Content != tt.content {
+				t.Errorf("plain client received %s %q, want the content", got.Type, got.Content)
+			}
+
+			got := next(t, modern)
+			if got.Type != tt.want || got.Version != i+2 {
+				t.Fatalf("received %s at version %d, want %s at %d", got.Type, got.Version, tt.want, i+2)
+			}
+			replica := got.Content
+			if got.Type == MsgTypeOperationBatch {
+				replica = content
+				for _, op := range got.Operations {
+					var err error
+					if replica, err = operations.Apply(replica, op); err != nil {
+						t.Fatalf("applying delta: %v", err)
+					}
+				}
+			}
+			if replica != tt.content {
+				t.Errorf("replica = %q, want %q", replica, tt.content)
+			}
+			content = tt.content
+		})
+	}
+}
+
+// TestConflictReports verifies the sender of an operation that concurrent
+// edits made redundant, cut short or could not rebase is told who and what
+// caused it, and that the event stream records it, while operations
+// rebased cleanly go unreported.
+func TestConflictReports(t *testing.T) {
+	h := NewHub()
+	sink := make(chan events.Event, 16)
+	h.AddEventSink(sinkFunc(func(e events.Event) {
+		if e.Type == events.TypeOperationConflict {
+			sink <- e
+		}
+	}))
+	go h.Run()
+	defer h.Shutdown()
+
+	alice, bob := NewLocalClient(h, "conflict-doc", 64), NewLocalClient(h, "conflict-doc", 64)
+	alice.SetDisplayName("Alice")
+	bob.SetDisplayName("Bob")
+	h.Register(alice)
+	h.Register(bob)
+	op := func(c *Client, kind string, position int, text string, version int) {
+		h.Submit([]byte(fmt.Sprintf(`{"type":"operation","document_id":"conflict-doc","operation":{"type":%q,"position":%d,"text":%q,"version":%d}}`, kind, position, text, version)), c)
+	}
+	report := func(t *testing.T) *ConflictReport {
+		t.Helper()
+		var got *ConflictReport
+		for len(alice.Messages()) > 0 {
+			if msg, err := MessageFromBytes(<-alice.Messages()); err == nil && msg.Type == MsgTypeConflict {
+				if got != nil {
+					t.Fatalf("second conflict report %+v", msg.Conflict)
+				}
+				got = msg.Conflict
+			}
+		}
+		if got != nil {
+			select {
+			case e := <-sink:
+				if e.Detail != got.String() || e.Operation == nil {
+					t.Errorf("event = %+v, want the report %q", e, got)
+				}
+			default:
+				t.Error("conflict not recorded in the event stream")
+			}
+		}
+		return got
+	}
+
+	op(alice, "insert", 0, "hello world", 0)
+	op(bob, "insert", 0, ">", 1)
+	op(alice, "insert", 11, "!", 1)
+	if r := report(t); r != nil {
+		t.Fatalf("report for a cleanly rebased insert: %+v", r)
+	}
+
+	op(bob, "delete", 7, "world", 3)
+	op(alice, "delete", 7, "world", 3)
+	r := report(t)
+	if r == nil || r.Kind != ConflictRedundant || r.Author.Name != "Alice" || len(r.With) != 1 || r.With[0].Name != "Bob" {
+		t.Fatalf("report for a delete of deleted text = %+v, want redundant with Bob", r)
+	}
+
+	op(alice, "delete", 5, "o world!", 3)
+	r = report(t)
+	if r == nil || r.Kind != ConflictOverlap || r.Operation.Text != "o world!" || r.Applied == nil || r.Applied.Text != "o !" {
+		t.Fatalf("report for an overlapping delete = %+v, want overlap applied as %q", r, "o !")
+	}
+	if got := r.String(); got != `overlap: Delete('o world!' at 5, v3) by Alice concurrent with Bob; applied as Delete('o !' at 5, v5)` {
+		t.Errorf("String() = %q", got)
+	}
+
+	h.do(func() { h.GetDocument("conflict-doc").TruncateHistory(1) })
+	op(alice, "insert", 0, "#", 1)
+	if r = report(t); r == nil || r.Kind != ConflictRejected || r.Applied != nil || !strings.Contains(r.Reason, "no longer retained") {
+		t.Fatalf("report for an operation older than the history = %+v, want rejected", r)
+	}
+	if got := h.GetDocument("conflict-doc").GetContent(); got != ">hell" {
+		t.Errorf("content = %q, want %q", got, ">hell")
+	}
+}
+
+// TestHistoryWindow verifies operations written against a version outside
+// a document's history window, by count or by age, are refused with
+// resync_required and the content, while those inside it are transformed.
+func TestHistoryWindow(t *testing.T) {
+	h := NewHub()
+	fake := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	h.SetClock(fake)
+	go h.Run()
+	defer h.Shutdown()
+
+	if err := h.SetHistoryWindow("window-doc", document.HistoryWindow{Versions: -1}); !errors.Is(err, ErrInvalidHistoryWindow) {
+		t.Fatalf("SetHistoryWindow(-1) = %v, want ErrInvalidHistoryWindow", err)
+	}
+	if err := h.SetHistoryWindow("window-doc", document.HistoryWindow{Versions: 2, MaxAge: time.Minute}); err != nil {
+		t.Fatalf("SetHistoryWindow() error: %v", err)
+	}
+	client := NewLocalClient(h, "window-doc", 64)
+	h.Register(client)
+	op := func(position int, text string, version int) []*Message {
+		h.Submit([]byte(fmt.Sprintf(`{"type":"operation","document_id":"window-doc","operation":{"id":"op-%d-%s","type":"insert","position":%d,"text":%q,"version":%d}}`, version, text, position, text, version)), client)
+		var got []*Message
+		for len(client.Messages()) > 0 {
+			if msg, err := MessageFromBytes(<-client.Messages()); err == nil {
+				got = append(got, msg)
+			}
+		}
+		return got
+	}
+	for i, text := range []string{"a", "b", "c"} {
+		op(i, text, i)
+	}
+
+	// Versions 1 to 3 are kept; version 0 is too far behind.
+	if got := op(0, "x", 0); len(got) < 2 || got[0].Code != ErrorResyncRequired || got[1].Type != MsgTypeContent || got[1].Content != "abc" || got[1].Version != 3 {
+		t.Fatalf("replies to an operation outside the window = %+v, want resync_required and the content", got)
+	}
+	if got := op(3, "d", 1); len(got) == 0 || got[0].Type != MsgTypeAck {
+		t.Fatalf("replies to an operation inside the window = %+v, want an ack", got)
+	}
+
+	fake.Advance(2 * time.Minute)
+	if got := op(0, "y", 3); len(got) == 0 || got[0].Code != ErrorResyncRequired {
+		t.Errorf("replies to an operation behind changes older than the window = %+v, want resync_required", got)
+	}
+	if got := op(0, "z", 4); len(got) == 0 || got[0].Type != MsgTypeAck {
+		t.Errorf("replies to an operation on the current version = %+v, want an ack", got)
+	}
+	if got := h.GetDocument("window-doc").GetContent(); got != "zabcd" {
+		t.Errorf("content = %q, want %q", got, "zabcd")
+	}
+	if w := h.HistoryWindow("window-doc"); w.Versions != 2 || w.MaxAge != time.Minute {
+		t.Errorf("HistoryWindow() = %+v", w)
+	}
+}
+
+// TestImportContent verifies an import replaces the content as one
+// version, sending connected clients the whole of it, and that content
+// breaking the document's limits changes nothing.
+func TestImportContent(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	if version, err := h.ImportContent("imported", "# New"); err != nil || version != 1 {
+		t.Fatalf("ImportContent() of a new document = %d, %v; want version 1", version, err)
+	}
+	if _, err := h.ReplaceContent("imported", "# New\nline one\nline two", 1); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+	editor := NewLocalClient(h, "imported", 16)
+	h.Register(editor)
+	h.do(func() {}) // wait for the registration
+	for len(editor.Messages()) > 0 {
+		<-editor.Messages()
+	}
+
+	version, err := h.ImportContent("imported", "# Imported\nbody")
+	if err != nil || version != 3 {
+		t.Fatalf("ImportContent() = %d, %v; want version 3", version, err)
+	}
+	select {
+	case data := <-editor.Messages():
+		msg, _ := MessageFromBytes(data)
+		if msg.Type != MsgTypeContent || msg.Content != "# Imported\nbody" || msg.Version != 3 {
+			t.Errorf("editor received %+v, want the imported content at version 3", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("editor did not receive the import")
+	}
+
+	if err := h.SetLimits("imported", schema.Limits{MaxLines: 2}); err != nil {
+		t.Fatalf("SetLimits() error: %v", err)
+	}
+	var violation *schema.ViolationError
+	if _, err := h.ImportContent("imported", "a\nb\nc"); !errors.As(err, &violation) {
+		t.Errorf("ImportContent() past the limits error = %v, want a violation", err)
+	}
+	if content, version := h.GetDocument("imported").GetContentAndVersion(); content != "# Imported\nbody" || version != 3 {
+		t.Errorf("content = %q at %d after a refused import", content, version)
+	}
+}
+
+// TestFences verifies fences reach clients, and that only clients allowed
+// to edit them may change fenced text.
+func TestFences(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	if _, err := h.ReplaceContent("letter", "Hi,\nSigned", 0); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+	if err := h.SetFences("letter", 1, document.Fences{{ID: "sig", Position: 4, Length: 6}}); err != nil {
+		t.Fatalf("SetFences() error: %v", err)
+	}
+	// receive returns the next message of type typ the client receives.
+	receive := func(c *Client, typ MessageType) *Message {
+		t.Helper()
+		for {
+			select {
+			case data := <-c.Messages():
+				if msg, _ := MessageFromBytes(data); msg.Type == typ {
+					return msg
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("no %s message", typ)
+			}
+		}
+	}
+	submit := func(c *Client, op *operations.Operation) {
+		op.ID = c.ID() + "-" + op.Text
+		msg := NewOperationMessage(op)
+		msg.DocumentID = "letter"
+		data, _ := msg.ToBytes()
+		h.Submit(data, c)
+	}
+
+	editor := NewLocalClient(h, "letter", 64)
+	maintainer := NewLocalClient(h, "letter", 64)
+	maintainer.AllowFences()
+	h.Register(editor)
+	h.Register(maintainer)
+	if msg := receive(editor, MsgTypeFences); len(msg.Fences) != 1 || msg.Version != 1 {
+		t.Errorf("fences on joining = %+v, want the fence at version 1", msg)
+	}
+
+	submit(editor, operations.NewInsertOp(6, "x", 1))
+	if msg := receive(editor, MsgTypeError); msg.Code != ErrorFenced {
+		t.Errorf("editor's edit inside the fence got %+v, want code %s", msg, ErrorFenced)
+	}
+	submit(editor, operations.NewInsertOp(3, " you", 1))
+	receive(editor, MsgTypeAck)
+	submit(maintainer, operations.NewInsertOp(5, "-", 1))
+	receive(maintainer, MsgTypeAck)
+	if content, version := h.GetDocument("letter").GetContentAndVersion(); content != "Hi, you\nS-igned" || version != 3 {
+		t.Errorf("content = %q at %d, want both allowed edits", content, version)
+	}
+
+	if err := h.SetFences("letter", 3, nil); err != nil {
+		t.Fatalf("SetFences() error: %v", err)
+	}
+	if msg := receive(editor, MsgTypeFences); len(msg.Fences) != 0 {
+		t.Errorf("fences after removing them = %v, want none", msg.Fences)
+	}
+	content := NewContentMessage("Bye")
+	content.DocumentID = "letter"
+	data, _ := content.ToBytes()
+	h.Submit(data, editor)
+	if c := h.GetDocument("letter").GetContent(); c != "Bye" {
+		t.Errorf("content = %q once unfenced, want the editor's", c)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	h := NewHub()
+	reg := metrics.NewRegistry()
+	h.RegisterMetrics(reg)
+	go h.Run()
+	defer h.Shutdown()
+
+	reader := NewLocalClient(h, "m-doc", 64)
+	slow := NewLocalClient(h, "m-doc", 1)
+	h.Register(reader)
+	h.Register(slow)
+	for i := 0; i < 3; i++ {
+		h.Submit([]byte(`{"type":"operation","document_id":"m-doc","operation":{"type":"insert","position":0,"text":"x","version":`+strconv.Itoa(i)+`}}`), reader)
+	}
+	h.Submit([]byte(`{"type":"operation","document_id":"m-doc","operation":{"type":"delete","position":10,"text":"y","version":3}}`), reader)
+	select {
+	case <-slow.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("slow client was not dropped")
+	}
+	deadline := time.Now().Add(time.Second)
+	for h.ClientCountForDocument("m-doc") != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("slow client was not unregistered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var out strings.Builder
+	reg.WriteTo(&out)
+	for _, want := range []string{
+		"collab_operations_applied_total 3\n",
+		`collab_operations_failed_total{reason="invalid_operation"} 1` + "\n",
+		"collab_clients_dropped_total 1\n",
+		`collab_clients{document="m-doc"} 1` + "\n",
+		"collab_clients_connected 1\n",
+		"collab_documents_loaded 1\n",
+		`collab_document_size_bytes{document="m-doc"} `,
+		"# TYPE collab_broadcast_fanout_seconds histogram\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), `collab_broadcast_fanout_seconds_bucket{le="+Inf"} `) || strings.Contains(out.String(), "collab_broadcast_fanout_seconds_count 0\n") {
+		t.Errorf("broadcasts were not timed:\n%s", out.String())
+	}
+
+	if _, err := h.SetVisibility("m-doc", document.VisibilityPrivate); err != nil {
+		t.Fatalf("SetVisibility() error: %v", err)
+	}
+	out.Reset()
+	reg.WriteTo(&out)
+	if strings.Contains(out.String(), "m-doc") || !strings.Contains(out.String(), "collab_documents_loaded 1\n") {
+		t.Errorf("metrics name a private document or stopped counting it:\n%s", out.String())
+	}
+}
+
+func TestRestoreCheckpoint(t *testing.T) {
+	h := NewHub()
+	var restored []events.Event
+	h.AddEventSink(sinkFunc(func(e events.Event) {
+		if e.Type == events.TypeCheckpointRestored {
+			restored = append(restored, e)
+		}
+	}))
+	go h.Run()
+	defer h.Shutdown()
+
+	if _, err := h.RestoreCheckpoint("nowhere", "x"); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("RestoreCheckpoint() on a missing document error = %v, want ErrDocumentNotFound", err)
+	}
+	if _, err := h.ReplaceContent("notes", "good text", 0); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+	cp, err := h.GetDocument("notes").CreateCheckpoint("good")
+	if err != nil {
+		t.Fatalf("CreateCheckpoint() error: %v", err)
+	}
+	if _, err := h.ReplaceContent("notes", "bad text", h.GetDocument("notes").GetVersion()); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+
+	c := NewLocalClient(h, "notes", 64)
+	h.Register(c)
+	version, err := h.RestoreCheckpoint("notes", cp.ID)
+	if err != nil {
+		t.Fatalf("RestoreCheckpoint() error: %v", err)
+	}
+	if content, v := h.GetDocument("notes").GetContentAndVersion(); content != "good text" || v != version || v <= cp.Version {
+		t.Errorf("content = %q at %d, want the checkpoint's as new version %d", content, v, version)
+	}
+	replica := "bad text"
+	for replica != "good text" {
+		select {
+		case data := <-c.Messages():
+			if msg, err := MessageFromBytes(data); err == nil && msg.Type == MsgTypeOperation {
+				if replica, err = operations.Apply(replica, msg.Operation); err != nil {
+					t.Fatalf("Apply() error: %v", err)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("client replica = %q, want the restore's operations", replica)
+		}
+	}
+	if _, err := h.RestoreCheckpoint("notes", "missing"); !errors.Is(err, document.ErrCheckpointNotFound) {
+		t.Errorf("RestoreCheckpoint() of a missing checkpoint error = %v, want ErrCheckpointNotFound", err)
+	}
+	if len(restored) != 1 || restored[0].Detail != cp.ID || restored[0].Version != version {
+		t.Errorf("events = %+v, want one checkpoint_restored", restored)
+	}
+}
+
+// BenchmarkBroadcast measures broadcast performance with varying client counts.
+func BenchmarkBroadcast(b *testing.B) {
+	clientCounts := []int{1, 10, 100}
+
+	for _, numClients := range clientCounts {
+		b.Run(string(rune('0'+numClients)), func(b *testing.B) {
+			h := NewHub()
+			go h.Run()
+
+			// Register clients
+			for i := 0; i < numClients; i++ {
+				client := &Client{
+					hub:        h,
+					conn:       nil,
+					send:       make(chan []byte, 256),
+					documentID: "test-doc",
+				}
+				h.Register(client)
+
+				// Drain messages to prevent channel blocking
+				go func(c *Client) {
+					for range c.send {
+					}
+				}(client)
+			}
+
+			time.Sleep(100 * time.Millisecond)
+			message := []byte("Benchmark message")
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.Broadcast(context.Background(), message, nil)
+			}
+		})
+	}
+}
+
+// BenchmarkBroadcastIsolation measures how long an operation on one
+// document takes to reach its collaborator while another document's 500
+// clients are sent a steady stream of messages, against an idle hub.
+func BenchmarkBroadcastIsolation(b *testing.B) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	for _, busy := range []int{0, 500} {
+		b.Run(fmt.Sprintf("unrelated_clients=%d", busy), func(b *testing.B) {
+			h := NewHub()
+			go h.Run()
+			defer h.Shutdown()
+
+			for range busy {
+				c := NewLocalClient(h, "busy-doc", 1024)
+				h.Register(c)
+				go func() {
+					for range c.Messages() {
+					}
+				}()
+			}
+			watcher := NewLocalClient(h, "quiet-doc", 1024)
+			h.Register(watcher)
+			h.do(func() {})
+			for len(watcher.Messages()) > 0 {
+				<-watcher.Messages()
+			}
+
+			stop := make(chan struct{})
+			defer close(stop)
+			if busy > 0 {
+				noise := []byte(`{"type":"noise","document_id":"busy-doc"}`)
+				go func() {
+					ticker := time.NewTicker(time.Millisecond)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-stop:
+							return
+						case <-ticker.C:
+							h.Broadcast(context.Background(), noise, nil)
+						}
+					}
+				}()
+			}
+
+			for i := 0; b.Loop(); i++ {
+				op := fmt.Sprintf(`{"type":"operation","document_id":"quiet-doc","operation":{"type":"insert","position":0,"text":"x","version":%d}}`, i)
+				h.Broadcast(context.Background(), []byte(op), nil)
+				for {
+					msg, err := MessageFromBytes(<-watcher.Messages())
+					if err == nil && msg.Type == MsgTypeOperation {
+						break
+					}
+				}
 			}
 		})
 	}
@@ -404,7 +4773,7 @@ func drainSystemMessages(t *testing.T, ch chan []byte) {
 		case msg := <-ch:
 			var parsed Message
 			if err := json.Unmarshal(msg, &parsed); err == nil {
-				if parsed.Type == MsgTypeUserCount {
+				if parsed.Type == MsgTypeUserCount || parsed.Type == MsgTypeWelcome || parsed.Type == MsgTypeSync {
 					continue
 				}
 			}
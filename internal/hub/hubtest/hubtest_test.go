@@ -0,0 +1,114 @@
+package hubtest
+
+import (
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"testing"
+	"time"
+
+	"collaborative-docs/internal/operations"
+)
+
+// TestSoak verifies hub invariants under client churn. The run is short by
+// default; set SOAK_DURATION (e.g. "5m") for a long soak.
+func TestSoak(t *testing.T) {
+	duration := 2 * time.Second
+	if testing.Short() {
+		duration = 300 * time.Millisecond
+	}
+	if env := os.Getenv("SOAK_DURATION"); env != "" {
+		d, err := time.ParseDuration(env)
+		if err != nil {
+			t.Fatalf("invalid SOAK_DURATION: %v", err)
+		}
+		duration = d
+	}
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	report := Soak(t, SoakConfig{
+		Duration:  duration,
+		Clients:   200,
+		Documents: 5,
+		Seed:      1,
+	})
+
+	if report.Registrations < 200 || report.Applied == 0 {
+		t.Errorf("soak did too little work: %+v", report)
+	}
+	t.Logf("soak report: %+v", report)
+}
+
+// TestSimulate verifies that clients editing at once converge with the hub
+// under several latency distributions, for a spread of seeds.
+func TestSimulate(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	latencies := map[string]Latency{
+		"uniform":     Uniform(time.Millisecond, 200*time.Millisecond),
+		"exponential": Exponential(50 * time.Millisecond),
+	}
+	seeds := uint64(20)
+	if testing.Short() {
+		seeds = 5
+	}
+	for name, latency := range latencies {
+		t.Run(name, func(t *testing.T) {
+			for seed := uint64(1); seed <= seeds; seed++ {
+				report := Simulate(t, SimConfig{
+					Initial: "the quick brown fox",
+					Clients: 4,
+					Edits:   30,
+					Latency: latency,
+					Think:   Uniform(0, 100*time.Millisecond),
+					Seed:    seed,
+				})
+				if report.Edits == 0 || report.Version < 2 {
+					t.Errorf("seed %d did too little work: %+v", seed, report)
+				}
+			}
+		})
+	}
+}
+
+// TestSimulateInsertInsideDelete replays an insert made inside text another
+// client deletes at the same time, which once left the hub rejecting the
+// delete and the clients apart.
+func TestSimulateInsertInsideDelete(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, first := range []int{0, 1} {
+		report := Simulate(t, SimConfig{
+			Initial: "abcdef",
+			Clients: 2,
+			Edits:   1,
+			Script: func(client, _ int, content string, _ *rand.Rand) *operations.Operation {
+				if client == 0 {
+					return operations.NewDeleteOp(1, "bcde", 0)
+				}
+				return operations.NewInsertOp(3, "X", 0)
+			},
+			// Both edit "abcdef" before seeing the other's edit, and the
+			// first client's reaches the hub first.
+			Latency: Fixed(10 * time.Millisecond),
+			Think: func() Latency {
+				n := 0
+				return func(*rand.Rand) time.Duration {
+					n++
+					if n-1 == first {
+						return 0
+					}
+					return time.Millisecond
+				}
+			}(),
+		})
+		if report.Content != "af" {
+			t.Errorf("client %d first: content = %q, want %q", first, report.Content, "af")
+		}
+	}
+}
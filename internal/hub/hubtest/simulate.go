@@ -0,0 +1,394 @@
+package hubtest
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+)
+
+// simDocument is the document every simulated client edits.
+const simDocument = "sim"
+
+// Latency draws the one-way delay of a message.
+type Latency func(rng *rand.Rand) time.Duration
+
+// Fixed delays every message by d.
+func Fixed(d time.Duration) Latency {
+	return func(*rand.Rand) time.Duration { return d }
+}
+
+// Uniform delays each message by a time drawn evenly from [min, max].
+func Uniform(min, max time.Duration) Latency {
+	return func(rng *rand.Rand) time.Duration {
+		return min + time.Duration(rng.Int64N(int64(max-min)+1))
+	}
+}
+
+// Exponential delays each message by a time drawn from an exponential
+// distribution with the given mean, so most messages are quick and a few
+// lag far behind, overtaking each other across clients.
+func Exponential(mean time.Duration) Latency {
+	return func(rng *rand.Rand) time.Duration {
+		return time.Duration(rng.ExpFloat64() * float64(mean))
+	}
+}
+
+// Script returns a client's next edit to content, its copy of the
+// document, or nil to make none this step. The operation's version and ID
+// are filled in when it is sent.
+type Script func(client, step int, content string, rng *rand.Rand) *operations.Operation
+
+// RandomEdit is the default Script: it inserts one to three letters, or
+// deletes up to four characters, at a random position.
+func RandomEdit(_, _ int, content string, rng *rand.Rand) *operations.Operation {
+	if n := len(content); n > 0 && rng.IntN(3) == 0 {
+		pos := rng.IntN(n)
+		end := pos + 1 + rng.IntN(min(4, n-pos))
+		return operations.NewDeleteOp(pos, content[pos:end], 0)
+	}
+	text := make([]byte, 1+rng.IntN(3))
+	for i := range text {
+		text[i] = byte('a' + rng.IntN(26))
+	}
+	return operations.NewInsertOp(rng.IntN(len(content)+1), string(text), 0)
+}
+
+// SimConfig controls a simulation.
+type SimConfig struct {
+	Initial string  // Content the document starts with
+	Clients int     // Scripted clients editing the document
+	Edits   int     // Script steps each client takes
+	Script  Script  // Chooses each edit; nil means RandomEdit
+	Latency Latency // Delay of each message in either direction; nil means none
+	Think   Latency // Pause before each of a client's steps; nil means none
+	Seed    uint64  // Seeds every random choice, so a failure can be replayed
+}
+
+// SimReport summarizes a simulation.
+type SimReport struct {
+	Content  string        // Final content, the same at the hub and every client
+	Version  int           // Final version
+	Edits    int           // Edits the clients made
+	Messages int           // Messages delivered between the hub and clients
+	Elapsed  time.Duration // Virtual time until the last message was delivered
+}
+
+// Simulate runs a discrete-event simulation of a hub and cfg.Clients
+// scripted clients editing one document in virtual time, so a run takes
+// no longer than the work it does and every seed replays exactly.
+//
+// Each message between a client and the hub is delayed by cfg.Latency.
+// Like a WebSocket, each connection keeps its messages in order in each
+// direction, but messages from different clients reach the hub in
+// whatever order their delays give, so edits interleave as they would on
+// a slow, uneven network. Clients follow the usual OT client protocol:
+// they apply their own edits at once, keep one operation in flight until
+// it is acknowledged, and transform the operations they receive past
+// their unacknowledged edits.
+//
+// Once every message is delivered it asserts that:
+//   - the hub applied or acknowledged every edit, rejecting none;
+//   - every client received the hub's operations in version order;
+//   - every client's copy equals the hub's content and version.
+func Simulate(t testing.TB, cfg SimConfig) SimReport {
+	t.Helper()
+	if cfg.Script == nil {
+		cfg.Script = RandomEdit
+	}
+	if cfg.Latency == nil {
+		cfg.Latency = Fixed(0)
+	}
+	if cfg.Think == nil {
+		cfg.Think = Fixed(0)
+	}
+
+	start := time.Unix(0, 0)
+	fake := clock.NewFake(start)
+	h := hub.NewHub()
+	h.SetClock(fake)
+	go h.Run()
+	defer h.Shutdown()
+
+	s := &simulation{t: t, cfg: cfg, hub: h, now: start, rng: rand.New(rand.NewPCG(cfg.Seed, cfg.Seed))}
+	if cfg.Initial != "" {
+		if _, err := h.ReplaceContent(simDocument, cfg.Initial, 0); err != nil {
+			t.Fatalf("seeding document: %v", err)
+		}
+	}
+	for i := 0; i < cfg.Clients; i++ {
+		c := s.connect(i)
+		s.clients = append(s.clients, c)
+		s.schedule(s.now.Add(cfg.Think(s.rng)), func() { s.step(c) })
+	}
+
+	for s.events.Len() > 0 {
+		ev := heap.Pop(&s.events).(*simEvent)
+		if ev.at.After(s.now) {
+			fake.Advance(ev.at.Sub(s.now))
+			s.now = ev.at
+		}
+		ev.run()
+	}
+
+	doc := h.GetDocument(simDocument)
+	if doc == nil {
+		t.Fatal("document was never created")
+	}
+	content, version := doc.GetContentAndVersion()
+	for _, c := range s.clients {
+		switch {
+		case c.err != nil:
+			t.Errorf("client %d: %v (seed %d)", c.index, c.err, cfg.Seed)
+		case c.sentID != "" || len(c.buffer) > 0:
+			t.Errorf("client %d: %d edits never acknowledged (seed %d)", c.index, len(c.buffer)+1, cfg.Seed)
+		case c.content != content || c.version != version:
+			t.Errorf("client %d diverged (seed %d):\nhub:    %q at version %d\nclient: %q at version %d",
+				c.index, cfg.Seed, content, version, c.content, c.version)
+		}
+	}
+	return SimReport{
+		Content:  content,
+		Version:  version,
+		Edits:    s.edits,
+		Messages: s.messages,
+		Elapsed:  s.now.Sub(start),
+	}
+}
+
+// simulation is the state of a run. Everything happens on the caller's
+// goroutine; the hub's runs in step, since each message is handled with
+// Submit and its replies collected before the next event.
+type simulation struct {
+	t        testing.TB
+	cfg      SimConfig
+	hub      *hub.Hub
+	now      time.Time
+	rng      *rand.Rand
+	events   simQueue
+	seq      int
+	clients  []*simClient
+	edits    int
+	messages int
+}
+
+// simClient is a scripted client with its copy of the document.
+type simClient struct {
+	index   int
+	conn    *hub.Client
+	content string
+	version int                     // The hub's version content is based on
+	sentID  string                  // ID of the operation in flight, if any
+	flight  []*operations.Operation // The operation in flight, transformed past operations received since
+	buffer  []*operations.Operation // Edits waiting for the one in flight to be acknowledged
+	steps   int                     // Script steps taken
+	sent    int                     // Operations sent, numbering their IDs
+	err     error
+
+	toHub, fromHub time.Time // Last arrival on each direction of the connection
+}
+
+// connect registers client i and gives it the document as the hub sends it.
+func (s *simulation) connect(i int) *simClient {
+	conn := hub.NewLocalClient(s.hub, simDocument, observerBuffer)
+	s.hub.Register(conn)
+	c := &simClient{index: i, conn: conn}
+	for data := range conn.Messages() {
+		if msg, err := hub.MessageFromBytes(data); err == nil && msg.Type == hub.MsgTypeSync {
+			c.content, c.version = msg.Content, msg.Version
+			return c
+		}
+	}
+	s.t.Fatalf("client %d was dropped before it synced", i)
+	return nil
+}
+
+// step runs a client's next script step and schedules the one after.
+func (s *simulation) step(c *simClient) {
+	if c.steps >= s.cfg.Edits || c.err != nil {
+		return
+	}
+	c.steps++
+	if op := s.cfg.Script(c.index, c.steps, c.content, s.rng); op != nil {
+		content, err := operations.Apply(c.content, op)
+		if err != nil {
+			s.t.Fatalf("client %d step %d: script made invalid edit %s: %v", c.index, c.steps, op, err)
+		}
+		c.content = content
+		c.buffer = append(c.buffer, op)
+		s.edits++
+		s.send(c)
+	}
+	s.schedule(s.now.Add(s.cfg.Think(s.rng)), func() { s.step(c) })
+}
+
+// send puts the client's next buffered edit in flight, unless one is.
+func (s *simulation) send(c *simClient) {
+	if c.sentID != "" || len(c.buffer) == 0 {
+		return
+	}
+	op := *c.buffer[0]
+	c.buffer = c.buffer[1:]
+	op.Version = c.version
+	c.sent++
+	op.ID = fmt.Sprintf("c%d-%d", c.index, c.sent)
+	c.sentID, c.flight = op.ID, []*operations.Operation{&op}
+
+	msg := hub.NewOperationMessage(&op)
+	msg.DocumentID = simDocument
+	data, err := msg.ToBytes()
+	if err != nil {
+		s.t.Fatalf("serialization failed: %v", err)
+	}
+	c.toHub = s.deliverAt(c.toHub)
+	s.schedule(c.toHub, func() {
+		s.messages++
+		s.hub.Submit(data, c.conn)
+		s.collect()
+	})
+}
+
+// collect schedules delivery of everything the hub has sent since the last
+// call.
+func (s *simulation) collect() {
+	for _, c := range s.clients {
+		for {
+			select {
+			case data := <-c.conn.Messages():
+				c.fromHub = s.deliverAt(c.fromHub)
+				s.schedule(c.fromHub, func() {
+					s.messages++
+					s.receive(c, data)
+				})
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
+
+// receive handles a message from the hub at a client.
+func (s *simulation) receive(c *simClient, data []byte) {
+	msg, err := hub.MessageFromBytes(data)
+	if err != nil || c.err != nil {
+		return
+	}
+	switch msg.Type {
+	case hub.MsgTypeOperation:
+		c.err = c.applyRemote(msg.Operation)
+	case hub.MsgTypeAck:
+		if msg.AckID != c.sentID {
+			c.err = fmt.Errorf("ack for %s, want %s", msg.AckID, c.sentID)
+			return
+		}
+		// The hub acknowledges a redundant edit at the current version.
+		if msg.Version != c.version && msg.Version != c.version+1 {
+			c.err = fmt.Errorf("ack at version %d after version %d", msg.Version, c.version)
+			return
+		}
+		c.version, c.sentID, c.flight = msg.Version, "", nil
+		s.send(c)
+	case hub.MsgTypeRejected, hub.MsgTypeError:
+		c.err = fmt.Errorf("edit %s %s: %s", msg.AckID, msg.Type, msg.Reason)
+	}
+}
+
+// applyRemote applies another client's operation, transformed past the
+// client's own unacknowledged edits, which are transformed past it in turn.
+func (c *simClient) applyRemote(op *operations.Operation) error {
+	if op == nil {
+		return nil
+	}
+	if op.Version != c.version+1 {
+		return fmt.Errorf("got operation version %d after version %d", op.Version, c.version)
+	}
+	remote := op
+	var err error
+	for _, pending := range []*[]*operations.Operation{&c.flight, &c.buffer} {
+		if remote, *pending, err = transformRemote(remote, *pending); err != nil {
+			return err
+		}
+	}
+	if remote != nil {
+		content, err := operations.Apply(c.content, remote)
+		if err != nil {
+			return fmt.Errorf("applying version %d: %w", op.Version, err)
+		}
+		c.content = content
+	}
+	c.version = op.Version
+	return nil
+}
+
+// transformRemote transforms remote past pending, and pending past remote,
+// taking each pending operation as the first, as the hub does when it
+// rebases a client's operation past one applied before it. It returns a
+// nil remote if pending made it redundant.
+func transformRemote(remote *operations.Operation, pending []*operations.Operation) (*operations.Operation, []*operations.Operation, error) {
+	var rebased []*operations.Operation
+	for i, p := range pending {
+		if remote == nil {
+			return nil, append(rebased, pending[i:]...), nil
+		}
+		next, rest, err := operations.Transform(p, remote)
+		if err != nil {
+			return nil, nil, err
+		}
+		if next.Text != "" {
+			rebased = append(rebased, next)
+		}
+		if remote = rest; remote.Text == "" {
+			remote = nil
+		}
+	}
+	return remote, rebased, nil
+}
+
+// deliverAt returns when a message sent now arrives, after the last one
+// sent the same way on the connection.
+func (s *simulation) deliverAt(last time.Time) time.Time {
+	at := s.now.Add(s.cfg.Latency(s.rng))
+	if at.Before(last) {
+		return last
+	}
+	return at
+}
+
+// schedule queues fn to run at the given virtual time, after anything
+// already queued for then.
+func (s *simulation) schedule(at time.Time, fn func()) {
+	s.seq++
+	heap.Push(&s.events, &simEvent{at: at, seq: s.seq, run: fn})
+}
+
+// simEvent is something that happens at a point in virtual time.
+type simEvent struct {
+	at  time.Time
+	seq int // Breaks ties in scheduling order
+	run func()
+}
+
+// simQueue orders events by time; it implements heap.Interface.
+type simQueue []*simEvent
+
+func (q simQueue) Len() int { return len(q) }
+func (q simQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q simQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *simQueue) Push(x any)   { *q = append(*q, x.(*simEvent)) }
+func (q *simQueue) Pop() any {
+	old := *q
+	ev := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ev
+}
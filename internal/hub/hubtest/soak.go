@@ -0,0 +1,264 @@
+// Package hubtest provides a soak-test harness that drives a hub with many
+// in-process clients and checks its invariants.
+package hubtest
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+)
+
+// SoakConfig controls a soak run.
+type SoakConfig struct {
+	Duration  time.Duration // How long clients churn and send operations
+	Clients   int           // Concurrent fake editors
+	Documents int           // Documents the editors are spread over
+	Seed      int64         // Random seed; runs with the same seed make the same choices per worker
+}
+
+// SoakReport summarizes a soak run.
+type SoakReport struct {
+	Registrations int64 // Client registrations performed
+	Operations    int64 // Operations sent to the hub
+	Applied       int   // Operations applied across all documents
+}
+
+const (
+	settleTimeout  = 5 * time.Second       // How long to wait for the hub to quiesce
+	editorBuffer   = 256                   // Matches the send buffer of WebSocket clients
+	observerBuffer = 1 << 16               // Observers must never be dropped as slow consumers
+	minThinkTime   = 5 * time.Millisecond  // Shortest pause between an editor's operations
+	maxThinkTime   = 50 * time.Millisecond // Longest pause between an editor's operations
+)
+
+// Soak runs a hub with cfg.Clients fake editors that repeatedly register,
+// send random insert/delete operations, and unregister, for cfg.Duration.
+// Each document also has an observer client that stays connected for the
+// whole run and replays every broadcast operation on a local replica.
+//
+// Afterwards it asserts that:
+//   - observers saw every applied operation exactly once, in version order;
+//   - each observer's replica equals the hub's document content;
+//   - client counts match the observers still registered;
+//   - shutting the hub down leaves no goroutines behind.
+func Soak(t testing.TB, cfg SoakConfig) SoakReport {
+	t.Helper()
+
+	baseline := runtime.NumGoroutine()
+
+	h := hub.NewHub()
+	go h.Run()
+
+	docIDs := make([]string, cfg.Documents)
+	observers := make([]*observer, cfg.Documents)
+	for i := range docIDs {
+		docIDs[i] = fmt.Sprintf("soak-%d", i)
+		h.GetOrCreateDocument(docIDs[i]).SetContent("seed")
+		observers[i] = newObserver(h, docIDs[i])
+	}
+	waitFor(t, "observers to register", func() bool { return h.ClientCount() == cfg.Documents })
+
+	var report SoakReport
+	deadline := time.Now().Add(cfg.Duration)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Clients; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(cfg.Seed + int64(worker)))
+			for time.Now().Before(deadline) {
+				editSession(h, docIDs[rng.Intn(len(docIDs))], rng, deadline, &report)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, obs := range observers {
+		select {
+		case <-obs.done:
+			t.Fatalf("observer %s was dropped by the hub", docIDs[i])
+		default:
+		}
+	}
+	waitFor(t, "editors to unregister", func() bool { return h.ClientCount() == cfg.Documents })
+	for _, id := range docIDs {
+		if n := h.ClientCountForDocument(id); n != 1 {
+			t.Errorf("document %s has %d clients after churn, want 1 (its observer)", id, n)
+		}
+	}
+
+	for i, id := range docIDs {
+		doc := h.GetDocument(id)
+		obs := observers[i]
+		waitFor(t, "observer "+id+" to catch up", func() bool {
+			_, version := doc.GetContentAndVersion()
+			return obs.caughtUp(version)
+		})
+
+		content, version := doc.GetContentAndVersion()
+		replica, seen, err := obs.state()
+		if err != nil {
+			t.Errorf("observer %s: %v", id, err)
+			continue
+		}
+		// The first version is the seed SetContent, which observers never see.
+		if seen != version-1 {
+			t.Errorf("observer %s saw %d operations, document has %d", id, seen, version-1)
+		}
+		if replica != content {
+			t.Errorf("document %s diverged:\nhub:     %q\nreplica: %q", id, content, replica)
+		}
+		report.Applied += version - 1
+	}
+
+	h.Shutdown()
+	for _, obs := range observers {
+		<-obs.done
+	}
+	waitFor(t, "goroutines to exit", func() bool { return runtime.NumGoroutine() <= baseline })
+
+	return report
+}
+
+// editSession registers one editor on documentID, sends a burst of
+// operations while draining its messages, then unregisters it.
+func editSession(h *hub.Hub, documentID string, rng *rand.Rand, deadline time.Time, report *SoakReport) {
+	client := hub.NewLocalClient(h, documentID, editorBuffer)
+	h.Register(client)
+	atomic.AddInt64(&report.Registrations, 1)
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for range client.Messages() {
+		}
+	}()
+
+	doc := h.GetOrCreateDocument(documentID)
+	for n := rng.Intn(20); n > 0 && time.Now().Before(deadline); n-- {
+		msg := hub.NewOperationMessage(randomOp(doc.GetContent(), rng))
+		msg.DocumentID = documentID
+		data, err := msg.ToBytes()
+		if err != nil {
+			continue
+		}
+		if h.Broadcast(client.Context(), data, client) != nil {
+			break
+		}
+		atomic.AddInt64(&report.Operations, 1)
+		time.Sleep(minThinkTime + time.Duration(rng.Int63n(int64(maxThinkTime-minThinkTime))))
+	}
+
+	h.Unregister(client)
+	<-drained
+}
+
+// randomOp builds an insert or delete against a recent snapshot of content.
+// Concurrent edits may make it invalid by the time the hub applies it, in
+// which case the hub rejects it and nobody sees it.
+func randomOp(content string, rng *rand.Rand) *operations.Operation {
+	if len(content) > 0 && rng.Intn(3) == 0 {
+		pos := rng.Intn(len(content))
+		end := pos + 1 + rng.Intn(min(3, len(content)-pos))
+		return operations.NewDeleteOp(pos, content[pos:end], 0)
+	}
+	return operations.NewInsertOp(rng.Intn(len(content)+1), string(rune('a'+rng.Intn(26))), 0)
+}
+
+// observer stays registered on a document and replays broadcast operations.
+type observer struct {
+	pending [][]byte // received but not yet replayed, so draining stays cheap
+	replica string
+	seen    int
+	err     error
+	mu      sync.Mutex
+	done    chan struct{}
+}
+
+// newObserver registers an observer client on documentID, whose content
+// must be the "seed" text at version 1.
+func newObserver(h *hub.Hub, documentID string) *observer {
+	o := &observer{replica: "seed", done: make(chan struct{})}
+	client := hub.NewLocalClient(h, documentID, observerBuffer)
+	h.Register(client)
+
+	go func() {
+		defer close(o.done)
+		for data := range client.Messages() {
+			o.mu.Lock()
+			o.pending = append(o.pending, data)
+			o.mu.Unlock()
+		}
+	}()
+	return o
+}
+
+// replay applies pending broadcast operations to the replica, checking that
+// versions arrive in order with no gaps. Callers must hold o.mu.
+func (o *observer) replay() {
+	for _, data := range o.pending {
+		if o.err != nil {
+			break
+		}
+		o.handle(data)
+	}
+	o.pending = nil
+}
+
+// handle applies one broadcast message. Callers must hold o.mu.
+func (o *observer) handle(data []byte) {
+	msg, err := hub.MessageFromBytes(data)
+	if err != nil || msg.Type != hub.MsgTypeOperation || msg.Operation == nil {
+		return
+	}
+
+	// Version 1 is the seed content, so the n-th operation has version n+1.
+	if want := o.seen + 2; msg.Operation.Version != want {
+		o.err = fmt.Errorf("got operation version %d, want %d", msg.Operation.Version, want)
+		return
+	}
+	replica, err := operations.Apply(o.replica, msg.Operation)
+	if err != nil {
+		o.err = fmt.Errorf("replaying version %d: %w", msg.Operation.Version, err)
+		return
+	}
+	o.replica = replica
+	o.seen++
+}
+
+// caughtUp reports whether the replica has reached version, or stopped
+// early because of an error.
+func (o *observer) caughtUp(version int) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.replay()
+	return o.err != nil || o.seen+1 >= version
+}
+
+// state returns the replica, the number of operations replayed, and the
+// first invariant violation, if any.
+func (o *observer) state() (string, int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.replay()
+	return o.replica, o.seen, o.err
+}
+
+// waitFor polls cond until it holds or settleTimeout passes.
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(settleTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
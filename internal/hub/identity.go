@@ -0,0 +1,61 @@
+package hub
+
+import "sort"
+
+// Author identifies who made an operation relayed to collaborators, or a
+// client connected to a document.
+type Author struct {
+	ClientID string `json:"client_id"`
+	UserID   string `json:"user_id,omitempty"` // Authenticated principal, if any
+	Name     string `json:"name,omitempty"`    // Display name
+}
+
+// SetDisplayName records the name collaborators see for the client, such
+// as the one its identity provider gave; it must be called before
+// Register.
+func (c *Client) SetDisplayName(name string) {
+	c.name = name
+}
+
+// UserID returns the authenticated principal that opened the connection,
+// or "" if none did.
+func (c *Client) UserID() string {
+	return c.subject
+}
+
+// DisplayName returns the name collaborators see for the client: the one
+// set with SetDisplayName, or else its user ID.
+func (c *Client) DisplayName() string {
+	if c.name != "" {
+		return c.name
+	}
+	return c.subject
+}
+
+// author returns the client's identity for the operations it makes, or
+// nil for changes without a client.
+func (c *Client) author() *Author {
+	if c == nil {
+		return nil
+	}
+	return &Author{ClientID: c.id, UserID: c.subject, Name: c.DisplayName()}
+}
+
+// ClientsForDocument returns the identities of the clients that can edit a
+// document, in the order they connected. Read-only and historical sessions
+// are left out.
+func (h *Hub) ClientsForDocument(documentID string) []Author {
+	h.mu.RLock()
+	var authors []Author
+	for _, client := range h.roomClients(documentID, func(c *Client) bool { return !c.readOnly && !c.historical }) {
+		authors = append(authors, *client.author())
+	}
+	h.mu.RUnlock()
+
+	// Client IDs count up from 1.
+	sort.Slice(authors, func(i, j int) bool {
+		a, b := authors[i].ClientID, authors[j].ClientID
+		return len(a) < len(b) || len(a) == len(b) && a < b
+	})
+	return authors
+}
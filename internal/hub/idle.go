@@ -0,0 +1,104 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"collaborative-docs/internal/clock"
+)
+
+// ErrDocumentBusy is returned when evicting a document with connected
+// clients.
+var ErrDocumentBusy = errors.New("document has connected clients")
+
+// SetIdleTTL makes the hub evict documents that have gone ttl without
+// connected clients or changes, checking every ttl/2 and at least once a
+// minute. With a store they are saved first and load again on next use;
+// without one they are gone, and their IDs are refused like deleted ones
+// with the reason evicted. Zero, the default, keeps documents loaded. It
+// must be called before Run.
+func (h *Hub) SetIdleTTL(ttl time.Duration) {
+	h.idleTTL = ttl
+}
+
+// DocumentCount returns how many documents are loaded.
+func (h *Hub) DocumentCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.documents)
+}
+
+// EvictDocument drops a loaded document without connected clients from
+// memory, as the idle TTL would. It returns ErrDocumentNotFound if the
+// document is not loaded, ErrDocumentBusy if it has clients, and an error
+// if it changed while being saved or could not be saved.
+func (h *Hub) EvictDocument(documentID string) error {
+	h.mu.RLock()
+	doc := h.documents[documentID]
+	busy := h.hasClients(documentID)
+	h.mu.RUnlock()
+	switch {
+	case doc == nil:
+		return ErrDocumentNotFound
+	case busy:
+		return ErrDocumentBusy
+	case !h.evict(documentID, doc.GetVersion()):
+		return errors.New("document changed or could not be saved")
+	}
+	log.Printf("document %s evicted", documentID)
+	return nil
+}
+
+// reapInterval is how often documents are checked against ttl.
+func reapInterval(ttl time.Duration) time.Duration {
+	return min(ttl/2, time.Minute)
+}
+
+// reap evicts idle documents on each tick until the hub shuts down.
+func (h *Hub) reap(ticker clock.Ticker) {
+	defer ticker.Stop()
+	active := make(map[string]time.Time)
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-ticker.C():
+			if n := h.reapIdle(active); n > 0 {
+				log.Printf("evicted %d idle documents", n)
+			}
+		}
+	}
+}
+
+// reapIdle evicts the documents idle for the TTL. active holds when each
+// loaded document was last seen with clients, and is kept up to date.
+func (h *Hub) reapIdle(active map[string]time.Time) int {
+	now := h.clock.Now()
+	loaded := make(map[string]bool)
+	evicted := 0
+	for _, ds := range h.Stats().Documents {
+		loaded[ds.ID] = true
+		if ds.Clients > 0 {
+			active[ds.ID] = now
+			continue
+		}
+		last := ds.LastModified
+		if seen := active[ds.ID]; seen.After(last) {
+			last = seen
+		}
+		if now.Sub(last) < h.idleTTL {
+			continue
+		}
+		if h.evict(ds.ID, ds.Version) {
+			delete(loaded, ds.ID)
+			evicted++
+		}
+	}
+	for id := range active {
+		if !loaded[id] {
+			delete(active, id)
+		}
+	}
+	return evicted
+}
@@ -0,0 +1,65 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"fmt"
+	"log"
+)
+
+// ImportContent sets a text document's content to an imported file's as
+// one change, creating the document if it does not exist, and returns the
+// resulting version. Connected clients receive the whole content, as for a
+// legacy content message, rather than operations, so edits they have not
+// yet sent are lost. Content that breaks the document's schema or limits
+// returns a *schema.ViolationError and changes nothing.
+func (h *Hub) ImportContent(documentID, content string) (int, error) {
+	var version int
+	var err error
+	if !h.do(func() { version, err = h.importContent(documentID, content) }) {
+		return 0, ErrHubStopped
+	}
+	return version, err
+}
+
+func (h *Hub) importContent(documentID, content string) (int, error) {
+	if h.IsDeleted(documentID) {
+		return 0, ErrDocumentDeleted
+	}
+	if h.isPaused(documentID) {
+		return 0, ErrDocumentPaused
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		if err := h.createDocument(documentID, content); err != nil {
+			return 0, err
+		}
+		version := h.GetDocument(documentID).GetVersion()
+		log.Printf("document %s imported, version: %d", documentID, version)
+		return version, nil
+	}
+	if kind := doc.GetKind(); kind != document.KindText {
+		return doc.GetVersion(), fmt.Errorf("%w: import into %s document", document.ErrWrongKind, kind)
+	}
+	if err := doc.ValidateContent(content); err != nil {
+		return doc.GetVersion(), err
+	}
+
+	version, changed, err := doc.SetContentIfChanged(content)
+	if err != nil || !changed {
+		return version, err
+	}
+	h.events.Emit(events.Event{
+		Type:       events.TypeContentSet,
+		DocumentID: documentID,
+		Version:    version,
+	})
+	msg := NewContentMessage(doc.GetContent())
+	msg.DocumentID = documentID
+	msg.Version = version
+	h.broadcastContent(documentID, doc, msg, nil)
+	h.contentChanged(documentID, version, nil)
+
+	log.Printf("document %s imported, version: %d", documentID, version)
+	return version, nil
+}
@@ -0,0 +1,85 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Member describes a collaborator admitted to a document.
+type Member struct {
+	Name string        `json:"name"`
+	Role document.Role `json:"role"`
+	Via  string        `json:"via"` // How they were admitted, e.g. "invite"
+}
+
+// maxMemberNameLength bounds the display name given when redeeming an
+// invite.
+const maxMemberNameLength = 64
+
+// CreateInvite creates an invite admitting collaborators to a document
+// with role. A positive ttl sets an expiry and a positive maxUses limits
+// redemptions.
+func (h *Hub) CreateInvite(documentID string, role document.Role, ttl time.Duration, maxUses int) (*document.Invite, error) {
+	if role != document.RoleViewer && role != document.RoleEditor {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	if h.IsDeleted(documentID) {
+		return nil, ErrDocumentDeleted
+	}
+
+	inv := document.Invite{Token: newToken(), Role: role, MaxUses: max(maxUses, 0)}
+	if ttl > 0 {
+		expires := h.clock.Now().Add(ttl)
+		inv.ExpiresAt = &expires
+	}
+	h.GetOrCreateDocument(documentID).AddInvite(inv)
+	log.Printf("invite created for document %s (role: %s, max uses: %d)", documentID, role, inv.MaxUses)
+	return &inv, nil
+}
+
+// RedeemInvite admits name to a document with an invite token and returns
+// the access token and role granted. Connected collaborators receive a
+// member_joined message.
+func (h *Hub) RedeemInvite(documentID, token, name string) (string, document.Role, error) {
+	if name == "" || len(name) > maxMemberNameLength {
+		return "", "", fmt.Errorf("name must be 1-%d characters", maxMemberNameLength)
+	}
+	var accessToken string
+	var role document.Role
+	var err error
+	if !h.do(func() { accessToken, role, err = h.redeemInvite(documentID, token, name) }) {
+		return "", "", ErrHubStopped
+	}
+	return accessToken, role, err
+}
+
+func (h *Hub) redeemInvite(documentID, token, name string) (string, document.Role, error) {
+	if h.IsDeleted(documentID) {
+		return "", "", ErrDocumentDeleted
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return "", "", document.ErrInviteNotFound
+	}
+
+	accessToken := newToken()
+	grant, err := doc.RedeemInvite(token, name, accessToken, h.clock.Now())
+	if err != nil {
+		return "", "", err
+	}
+
+	log.Printf("invite redeemed for document %s (role: %s)", documentID, grant.Role)
+	h.events.Emit(events.Event{
+		Type:       events.TypeMemberJoined,
+		DocumentID: documentID,
+		Detail:     name,
+	})
+	member := &Member{Name: name, Role: grant.Role, Via: "invite"}
+	if data, err := NewMemberJoinedMessage(documentID, member).ToBytes(); err == nil {
+		h.broadcastToDocument(documentID, data, nil)
+	}
+	return accessToken, grant.Role, nil
+}
@@ -0,0 +1,41 @@
+package hub
+
+import (
+	"collaborative-docs/internal/jsondoc"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrUnknownConflictPolicy is returned when setting a conflict policy the
+// hub does not know.
+var ErrUnknownConflictPolicy = errors.New("unknown conflict policy")
+
+// SetConflictPolicy decides how a JSON document's stale edits of a cell,
+// those whose if_version the cell has moved past, are handled from now on.
+func (h *Hub) SetConflictPolicy(documentID string, policy jsondoc.ConflictPolicy) error {
+	if !policy.Valid() {
+		return fmt.Errorf("%w: %s", ErrUnknownConflictPolicy, policy)
+	}
+	var err error
+	if !h.do(func() {
+		if h.IsDeleted(documentID) {
+			err = ErrDocumentDeleted
+			return
+		}
+		h.GetOrCreateDocument(documentID).SetConflictPolicy(policy)
+		log.Printf("document %s conflict policy set to %q", documentID, policy)
+	}) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+// ConflictPolicy returns how a JSON document's stale edits of a cell are
+// handled; documents the hub does not hold use last writer wins.
+func (h *Hub) ConflictPolicy(documentID string) jsondoc.ConflictPolicy {
+	if doc := h.GetDocument(documentID); doc != nil {
+		return doc.ConflictPolicy()
+	}
+	return jsondoc.PolicyLastWriterWins
+}
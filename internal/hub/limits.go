@@ -0,0 +1,72 @@
+package hub
+
+import (
+	"collaborative-docs/internal/schema"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrInvalidLimits is returned for negative limits.
+var ErrInvalidLimits = errors.New("invalid limits")
+
+// SetLimits bounds a text document's line length and line count, for uses
+// such as collaborative config editing. Edits that would break them are
+// refused and the sender receives a rejected message naming the offending
+// line. The current content must already satisfy them; zero Limits remove
+// them.
+func (h *Hub) SetLimits(documentID string, l schema.Limits) error {
+	if l.MaxLineLength < 0 || l.MaxLines < 0 {
+		return fmt.Errorf("%w: %+v", ErrInvalidLimits, l)
+	}
+	var err error
+	if !h.do(func() { err = h.setLimits(documentID, l) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) setLimits(documentID string, l schema.Limits) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+	if err := h.GetOrCreateDocument(documentID).SetLimits(l); err != nil {
+		return err
+	}
+	log.Printf("document %s limits set to %+v", documentID, l)
+	return nil
+}
+
+// Limits returns the limits a document enforces.
+func (h *Hub) Limits(documentID string) schema.Limits {
+	if doc := h.GetDocument(documentID); doc != nil {
+		return doc.Limits()
+	}
+	return schema.Limits{}
+}
+
+// rejectViolation tells the sender that its edit broke the document's
+// schema or limits, naming the line, rule and limit so the editor can
+// point at it. Other failures are only logged.
+func (h *Hub) rejectViolation(sender *Client, documentID string, msg *Message, err error) {
+	var violation *schema.ViolationError
+	if sender == nil || !errors.As(err, &violation) {
+		return
+	}
+	h.rejectEdit(sender, documentID, msg, err.Error(), violation)
+}
+
+// rejectEdit tells the sender why its edit was refused, then sends the
+// document's content so the sender can drop the edit it applied locally.
+func (h *Hub) rejectEdit(sender *Client, documentID string, msg *Message, reason string, violation *schema.ViolationError) {
+	reply := &Message{Type: MsgTypeRejected, DocumentID: documentID, Reason: reason, Violation: violation, AckID: msg.ackID(), TraceID: msg.TraceID}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return
+	}
+	reply.Version = doc.GetVersion()
+	if data, err := reply.ToBytes(); err == nil {
+		h.sendToClient(sender, data)
+	}
+	h.sendContent(documentID, doc, sender)
+}
@@ -0,0 +1,28 @@
+package hub
+
+import (
+	"collaborative-docs/internal/operations"
+)
+
+// maxLanguageLength bounds the language metadata clients may set.
+const maxLanguageLength = 64
+
+// LineEvent reports which lines of a document an applied operation touched.
+type LineEvent struct {
+	DocumentID string
+	Version    int
+	Language   string
+	operations.LineChange
+}
+
+// SubscribeLines registers a listener for line-level change events on a
+// document. The returned cancel function unsubscribes and closes the channel.
+func (h *Hub) SubscribeLines(documentID string) (<-chan LineEvent, func()) {
+	return h.lines.subscribe(documentID)
+}
+
+// publishLines delivers a line event to every subscriber of the document
+// without blocking the hub.
+func (h *Hub) publishLines(event LineEvent) {
+	h.lines.publish(event.DocumentID, event)
+}
@@ -1,7 +1,14 @@
 package hub
 
 import (
+	"collaborative-docs/internal/attachments"
+	"collaborative-docs/internal/blocks"
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/jsondoc"
 	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/outline"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/internal/validators"
 	"encoding/json"
 	"fmt"
 )
@@ -13,16 +20,127 @@ const (
 	MsgTypeContent   MessageType = "content"    // Full content update
 	MsgTypeOperation MessageType = "operation"  // OT operation
 	MsgTypeUserCount MessageType = "user_count" // System message for user count
+
+	MsgTypeBlockOperation MessageType = "block_operation" // Structural markdown operation
+	MsgTypeLanguage       MessageType = "language"        // Set code-editing language metadata
+	MsgTypeJSONOperation  MessageType = "json_operation"  // JSON document tree operation
+
+	MsgTypeAttachmentRequest MessageType = "attachment_request" // Client asks for an upload slot
+	MsgTypeAttachmentSlot    MessageType = "attachment_slot"    // Server replies with a presigned URL
+
+	MsgTypeBlob        MessageType = "blob"         // Chunk of a small pasted file
+	MsgTypeBlobRequest MessageType = "blob_request" // Client asks for a stored blob by ID
+
+	MsgTypeMetadata    MessageType = "metadata"     // Metadata snapshot or change notification
+	MsgTypeMetadataSet MessageType = "metadata_set" // Client sets metadata keys (empty value deletes)
+	MsgTypeMetadataGet MessageType = "metadata_get" // Client asks for all metadata
+
+	MsgTypePreferences    MessageType = "preferences"     // The user's stored preferences for the document
+	MsgTypePreferencesSet MessageType = "preferences_set" // Client replaces its user's preferences
+	MsgTypePreferencesGet MessageType = "preferences_get" // Client asks for its user's preferences
+
+	MsgTypeDocumentDeleted MessageType = "document_deleted" // Terminal notice; Content holds the archive when ReadOnly
+	MsgTypeDocumentPaused  MessageType = "document_paused"  // Edits are paused; also the reply to a rejected edit
+	MsgTypeDocumentResumed MessageType = "document_resumed" // Edits are accepted again
+
+	MsgTypeViewport MessageType = "viewport" // Lines a collaborator has on screen, throttled by the hub
+	MsgTypeCursor   MessageType = "cursor"   // A collaborator's caret and selection, with display name and color
+
+	MsgTypePresenceRequest MessageType = "presence_request" // Client asks for every collaborator's cursor and idle state at once
+	MsgTypePresence        MessageType = "presence"         // The reply: Presence lists the other clients sharing the sender's view
+
+	MsgTypeSyncMode       MessageType = "sync_mode"       // Client requests a delivery cadence; the hub replies with the one granted
+	MsgTypeOperationBatch MessageType = "operation_batch" // Operations queued for a coalesced client, in version order
+
+	MsgTypeWelcome      MessageType = "welcome"       // First message on connect, carrying the hub's capabilities
+	MsgTypeCapabilities MessageType = "capabilities"  // Capabilities changed, e.g. a feature was disabled for the document
+	MsgTypeAck          MessageType = "ack"           // An operation with AckID is applied at Version
+	MsgTypeError        MessageType = "error"         // The sender's edit failed; Code says why and Version is the document's
+	MsgTypeResync       MessageType = "resync"        // Reconnected client at Version asks for current content
+	MsgTypeSync         MessageType = "sync"          // Content and Version after welcome, and the reply to sync_request
+	MsgTypeSyncRequest  MessageType = "sync_request"  // Client asks for a sync whatever its version
+	MsgTypeMemberJoined MessageType = "member_joined" // A collaborator was admitted, e.g. by redeeming an invite
+	MsgTypeThrottled    MessageType = "throttled"     // The hub drops this connection's messages until RetryAfterMS passes
+
+	MsgTypeTransaction          MessageType = "transaction"           // Operations on several documents, applied all or none
+	MsgTypeTransactionCommitted MessageType = "transaction_committed" // Every operation applied; each carries its resulting version
+	MsgTypeTransactionAborted   MessageType = "transaction_aborted"   // Nothing applied; Reason says why
+
+	MsgTypeEmbedChanged MessageType = "embed_changed" // A document this one embeds changed; Embed names it
+	MsgTypeOutline      MessageType = "outline"       // Client asks for the heading outline; the hub replies and sends later changes
+
+	MsgTypeRejected    MessageType = "rejected"    // The sender's edit was refused; Violation says where it broke the document's schema or limits
+	MsgTypeDiagnostics MessageType = "diagnostics" // Syntax problems the document's validator found; none means valid
+	MsgTypePublished   MessageType = "published"   // An approved snapshot at Version is now the document's published version
+	MsgTypeConflict    MessageType = "conflict"    // Concurrent edits refused or reshaped the sender's operation; Conflict says how
+	MsgTypeFences      MessageType = "fences"      // The document's fenced ranges at Version, after sync and when replaced; none means no fences
+
+	MsgTypeScheduleFired MessageType = "schedule_fired" // A scheduled action ran; Schedule names it and Reason says why it failed, if it did
+
+	MsgTypeUndo MessageType = "undo" // Client reverts its latest edit not yet undone; the revert arrives as an operation
+	MsgTypeRedo MessageType = "redo" // Client reapplies the edit its latest undo reverted
+
+	MsgTypeSessionOpen   MessageType = "session_open"   // Mux peer opens Session on DocumentID; see Mux
+	MsgTypeSessionClose  MessageType = "session_close"  // Mux peer ends Session
+	MsgTypeSessionClosed MessageType = "session_closed" // Session ended or never opened; Reason says why, if the server ended it
 )
 
 // Message represents the WebSocket protocol for exchanging
 // document content, operations, or system notifications.
 type Message struct {
-	Type       MessageType            `json:"type"`
-	DocumentID string                 `json:"document_id,omitempty"`
-	Content    string                 `json:"content,omitempty"`
-	Operation  *operations.Operation  `json:"operation,omitempty"`
-	UserCount  int                    `json:"user_count,omitempty"`
+	Type       MessageType           `json:"type"`
+	DocumentID string                `json:"document_id,omitempty"`
+	Content    string                `json:"content,omitempty"`
+	Operation  *operations.Operation `json:"operation,omitempty"`
+	UserCount  int                   `json:"user_count,omitempty"`
+	Author     *Author               `json:"author,omitempty"` // Who made a relayed operation; none for the server's
+
+	BlockOperation *blocks.Operation       `json:"block_operation,omitempty"`
+	Language       string                  `json:"language,omitempty"`
+	JSONOperation  *jsondoc.Operation      `json:"json_operation,omitempty"`
+	Attachment     *attachments.Slot       `json:"attachment,omitempty"`
+	Blob           *BlobChunk              `json:"blob,omitempty"`
+	Metadata       map[string]string       `json:"metadata,omitempty"`
+	Preferences    *document.Preferences   `json:"preferences,omitempty"`
+	Reason         string                  `json:"reason,omitempty"`
+	Code           ErrorCode               `json:"code,omitempty"` // Why an error message's edit failed
+	ReadOnly       bool                    `json:"read_only,omitempty"`
+	RetryAfterMS   int                     `json:"retry_after_ms,omitempty"`
+	Viewport       *Viewport               `json:"viewport,omitempty"`
+	Cursor         *Cursor                 `json:"cursor,omitempty"`
+	Presence       []Collaborator          `json:"presence,omitempty"`
+	Sync           *SyncSettings           `json:"sync,omitempty"`
+	Capabilities   *Capabilities           `json:"capabilities,omitempty"`
+	Member         *Member                 `json:"member,omitempty"`
+	Transaction    *Transaction            `json:"transaction,omitempty"`
+	Embed          *EmbedChange            `json:"embed,omitempty"`
+	Outline        []outline.Heading       `json:"outline,omitempty"`
+	Violation      *schema.ViolationError  `json:"violation,omitempty"`
+	Conflict       *ConflictReport         `json:"conflict,omitempty"`
+	Diagnostics    []validators.Diagnostic `json:"diagnostics,omitempty"`
+	Fences         document.Fences         `json:"fences,omitempty"`
+
+	Schedule *document.ScheduledAction `json:"schedule,omitempty"` // The action a schedule_fired message reports
+	Session  string                    `json:"session,omitempty"`  // The Mux session, such as a browser tab, the message belongs to
+
+	Operations []*operations.Operation `json:"operations,omitempty"`
+	AckID      string                  `json:"ack_id,omitempty"`
+	// TraceID follows a client message through the hub. The hub sets it
+	// on receipt unless the client chose one, logs it, and echoes it in
+	// the sender's ack, rejection or transaction reply, in the copies
+	// collaborators receive, and in the events the message causes.
+	TraceID string `json:"trace_id,omitempty"`
+
+	// Set by the hub on every outbound document message.
+	ServerTime int64 `json:"server_time,omitempty"` // Unix milliseconds
+	Version    int   `json:"version,omitempty"`     // Document version when sent
+
+	// Ephemeral messages (typing, reactions) are relayed but never applied,
+	// persisted or emitted as events. TTLMS bounds how long they stay
+	// relevant; the hub sets ExpiresAt (Unix milliseconds) when relaying.
+	Ephemeral bool  `json:"ephemeral,omitempty"`
+	TTLMS     int   `json:"ttl_ms,omitempty"`
+	ExpiresAt int64 `json:"expires_at,omitempty"`
 }
 
 // NewContentMessage creates a message with full content.
@@ -33,6 +151,17 @@ func NewContentMessage(content string) *Message {
 	}
 }
 
+// NewSyncMessage creates the message that brings a client to a document's
+// content at version.
+func NewSyncMessage(documentID, content string, version int) *Message {
+	return &Message{
+		Type:       MsgTypeSync,
+		DocumentID: documentID,
+		Content:    content,
+		Version:    version,
+	}
+}
+
 // NewOperationMessage creates a message with an OT operation.
 func NewOperationMessage(op *operations.Operation) *Message {
 	return &Message{
@@ -41,6 +170,140 @@ func NewOperationMessage(op *operations.Operation) *Message {
 	}
 }
 
+// NewBlockOperationMessage creates a message with a structural block operation.
+func NewBlockOperationMessage(op *blocks.Operation) *Message {
+	return &Message{
+		Type:           MsgTypeBlockOperation,
+		BlockOperation: op,
+	}
+}
+
+// NewJSONOperationMessage creates a message with a JSON tree operation.
+func NewJSONOperationMessage(op *jsondoc.Operation) *Message {
+	return &Message{
+		Type:          MsgTypeJSONOperation,
+		JSONOperation: op,
+	}
+}
+
+// NewAttachmentSlotMessage creates a reply carrying an upload slot.
+func NewAttachmentSlotMessage(slot *attachments.Slot) *Message {
+	return &Message{
+		Type:       MsgTypeAttachmentSlot,
+		DocumentID: slot.DocumentID,
+		Attachment: slot,
+	}
+}
+
+// NewBlobMessage creates a message carrying one blob chunk.
+func NewBlobMessage(documentID string, chunk *BlobChunk) *Message {
+	return &Message{
+		Type:       MsgTypeBlob,
+		DocumentID: documentID,
+		Blob:       chunk,
+	}
+}
+
+// NewMetadataMessage creates a message carrying document metadata.
+func NewMetadataMessage(documentID string, metadata map[string]string) *Message {
+	return &Message{
+		Type:       MsgTypeMetadata,
+		DocumentID: documentID,
+		Metadata:   metadata,
+	}
+}
+
+// NewPreferencesMessage creates a message carrying a user's preferences
+// for a document.
+func NewPreferencesMessage(documentID string, p *document.Preferences) *Message {
+	return &Message{
+		Type:        MsgTypePreferences,
+		DocumentID:  documentID,
+		Preferences: p,
+	}
+}
+
+// NewViewportMessage creates a message carrying a collaborator's viewport.
+func NewViewportMessage(documentID string, vp *Viewport) *Message {
+	return &Message{
+		Type:       MsgTypeViewport,
+		DocumentID: documentID,
+		Viewport:   vp,
+	}
+}
+
+// NewCursorMessage creates a message relaying a collaborator's cursor.
+func NewCursorMessage(documentID string, cursor *Cursor) *Message {
+	return &Message{
+		Type:       MsgTypeCursor,
+		DocumentID: documentID,
+		Cursor:     cursor,
+	}
+}
+
+// NewSyncModeMessage creates a message carrying delivery cadence settings.
+func NewSyncModeMessage(documentID string, settings *SyncSettings) *Message {
+	return &Message{
+		Type:       MsgTypeSyncMode,
+		DocumentID: documentID,
+		Sync:       settings,
+	}
+}
+
+// NewOperationBatchMessage creates a message carrying several operations.
+func NewOperationBatchMessage(documentID string, ops []*operations.Operation) *Message {
+	return &Message{
+		Type:       MsgTypeOperationBatch,
+		DocumentID: documentID,
+		Operations: ops,
+	}
+}
+
+// NewWelcomeMessage creates the message greeting a newly connected client.
+func NewWelcomeMessage(documentID string, caps *Capabilities) *Message {
+	return &Message{
+		Type:         MsgTypeWelcome,
+		DocumentID:   documentID,
+		Capabilities: caps,
+	}
+}
+
+// NewCapabilitiesMessage creates a notice of changed capabilities.
+func NewCapabilitiesMessage(documentID string, caps *Capabilities) *Message {
+	return &Message{
+		Type:         MsgTypeCapabilities,
+		DocumentID:   documentID,
+		Capabilities: caps,
+	}
+}
+
+// NewMemberJoinedMessage creates a notice that a collaborator was admitted.
+func NewMemberJoinedMessage(documentID string, member *Member) *Message {
+	return &Message{
+		Type:       MsgTypeMemberJoined,
+		DocumentID: documentID,
+		Member:     member,
+	}
+}
+
+// NewAckMessage creates a reply confirming an applied operation.
+func NewAckMessage(documentID, id string, version int) *Message {
+	return &Message{
+		Type:       MsgTypeAck,
+		DocumentID: documentID,
+		AckID:      id,
+		Version:    version,
+	}
+}
+
+// NewLanguageMessage creates a message setting the document language.
+func NewLanguageMessage(language string) *Message {
+	return &Message{
+		Type:     MsgTypeLanguage,
+		Language: language,
+	}
+}
+
 // NewUserCountMessage creates a user count system message.
 func NewUserCountMessage(count int) *Message {
 	return &Message{
@@ -0,0 +1,104 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"fmt"
+	"log"
+)
+
+const (
+	maxMetadataKeys        = 100  // Maximum metadata keys per document
+	maxMetadataKeyLength   = 64   // Maximum metadata key length
+	maxMetadataValueLength = 1024 // Maximum metadata value length
+)
+
+// MetadataEvent reports metadata keys that changed on a document.
+// A key mapped to an empty string was removed.
+type MetadataEvent struct {
+	DocumentID string
+	Changes    map[string]string
+}
+
+// WatchMetadata registers a listener for metadata changes on a document.
+// The returned cancel function unsubscribes and closes the channel.
+func (h *Hub) WatchMetadata(documentID string) (<-chan MetadataEvent, func()) {
+	return h.metadata.subscribe(documentID)
+}
+
+// SetDocumentMetadata sets metadata keys on a document (an empty value
+// deletes the key) and notifies watchers and connected clients.
+func (h *Hub) SetDocumentMetadata(documentID string, changes map[string]string) error {
+	return h.setMetadata(h.GetOrCreateDocument(documentID), documentID, changes, nil)
+}
+
+// setMetadata validates and applies metadata changes, then broadcasts the
+// keys that actually changed to everyone but exclude.
+func (h *Hub) setMetadata(doc *document.Document, documentID string, changes map[string]string, exclude *Client) error {
+	if err := validateMetadata(doc, changes); err != nil {
+		return err
+	}
+
+	changed := make(map[string]string)
+	for key, value := range changes {
+		if doc.SetMetadata(key, value) {
+			changed[key] = value
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	h.metadata.publish(documentID, MetadataEvent{DocumentID: documentID, Changes: changed})
+
+	msgBytes, err := NewMetadataMessage(documentID, changed).ToBytes()
+	if err != nil {
+		return err
+	}
+	h.broadcastToDocument(documentID, msgBytes, exclude)
+	return nil
+}
+
+// validateMetadata checks key/value sizes, the per-document key limit and
+// the values of reserved keys.
+func validateMetadata(doc *document.Document, changes map[string]string) error {
+	current := doc.Metadata()
+	added := 0
+	for key, value := range changes {
+		if key == "" || len(key) > maxMetadataKeyLength {
+			return fmt.Errorf("metadata key must be 1-%d characters", maxMetadataKeyLength)
+		}
+		if len(value) > maxMetadataValueLength {
+			return fmt.Errorf("metadata value for %q exceeds %d characters", key, maxMetadataValueLength)
+		}
+		if key == document.MetadataWorkflowState && value != "" && !document.WorkflowState(value).Valid() {
+			return fmt.Errorf("unknown workflow state %q", value)
+		}
+		if _, exists := current[key]; !exists && value != "" {
+			added++
+		}
+	}
+	if len(current)+added > maxMetadataKeys {
+		return fmt.Errorf("document would exceed %d metadata keys", maxMetadataKeys)
+	}
+	return nil
+}
+
+// handleMetadataSet applies metadata changes sent by a client, answering
+// invalid ones with an error.
+func (h *Hub) handleMetadataSet(doc *document.Document, documentID string, msg *Message, sender *Client) {
+	if err := h.setMetadata(doc, documentID, msg.Metadata, sender); err != nil {
+		log.Printf("metadata update for document %s rejected: %v", documentID, err)
+		h.noteRejected(sender)
+		h.failEdit(sender, documentID, msg, err)
+	}
+}
+
+// handleMetadataGet replies to the sender with all document metadata.
+func (h *Hub) handleMetadataGet(doc *document.Document, documentID string, sender *Client) {
+	msgBytes, err := NewMetadataMessage(documentID, doc.Metadata()).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(sender, msgBytes)
+}
@@ -0,0 +1,85 @@
+package hub
+
+import (
+	"errors"
+
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/metrics"
+	"collaborative-docs/internal/schema"
+)
+
+// fanoutBuckets are the upper bounds, in seconds, of the broadcast fan-out
+// latency histogram.
+var fanoutBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// hubMetrics are the metrics the hub records as things happen. The zero
+// value records nothing, for a hub whose metrics are not registered.
+type hubMetrics struct {
+	failed  *metrics.CounterVec // Text operations refused, by error code
+	fanout  *metrics.Histogram  // From queueing a message to delivering it to every recipient
+	dropped *metrics.Counter    // Clients kicked for a full send buffer
+	limited *metrics.Counter    // Messages dropped over a client's rate limit
+}
+
+// RegisterMetrics registers the hub's metrics with r: clients connected
+// per document, operations applied, operations refused because they do not
+// transform onto or apply to the document, broadcast fan-out latency,
+// document sizes and clients dropped for full send buffers. Private
+// documents are counted in the totals but get no series of their own, so
+// scraping does not reveal their IDs. It must be called before Run.
+func (h *Hub) RegisterMetrics(r *metrics.Registry) {
+	h.AddEventSink(appliedCounter{r.Counter("collab_operations_applied_total", "Operations applied to documents.")})
+	h.metrics = hubMetrics{
+		failed:  r.CounterVec("collab_operations_failed_total", "Text operations refused because they could not be transformed or applied, by error code.", "reason"),
+		fanout:  r.Histogram("collab_broadcast_fanout_seconds", "Time from queueing a message for a document's clients to delivering it to all of them.", fanoutBuckets),
+		dropped: r.Counter("collab_clients_dropped_total", "Clients disconnected because their send buffer was full."),
+		limited: r.Counter("collab_messages_rate_limited_total", "Messages dropped because their client exceeded its rate limit."),
+	}
+
+	r.GaugeFunc("collab_clients_connected", "Clients connected.", func() float64 {
+		return float64(h.Stats().Clients)
+	})
+	r.GaugeFunc("collab_documents_loaded", "Documents in memory.", func() float64 {
+		return float64(len(h.Stats().Documents))
+	})
+	r.GaugeVecFunc("collab_clients", "Clients connected per document.", "document", func() map[string]float64 {
+		clients := make(map[string]float64)
+		for _, d := range h.Stats().Documents {
+			if d.Clients > 0 && !d.Private {
+				clients[d.ID] = float64(d.Clients)
+			}
+		}
+		return clients
+	})
+	r.GaugeVecFunc("collab_document_size_bytes", "Approximate memory used by each loaded document.", "document", func() map[string]float64 {
+		sizes := make(map[string]float64)
+		for _, d := range h.Stats().Documents {
+			if !d.Private {
+				sizes[d.ID] = float64(d.MemoryBytes)
+			}
+		}
+		return sizes
+	})
+}
+
+// appliedCounter counts the operations applied, as the hub emits them to
+// its event sinks.
+type appliedCounter struct {
+	applied *metrics.Counter
+}
+
+func (a appliedCounter) Publish(e events.Event) {
+	if e.Type == events.TypeOperationApplied {
+		a.applied.Inc()
+	}
+}
+
+// operationFailed counts a text operation refused with err.
+func (m *hubMetrics) operationFailed(err error) {
+	var violation *schema.ViolationError
+	if errors.As(err, &violation) {
+		m.failed.Inc("violation")
+		return
+	}
+	m.failed.Inc(string(editErrorCode(err)))
+}
@@ -0,0 +1,386 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MaxMuxSessions is how many sessions one Mux carries at once.
+const MaxMuxSessions = 64
+
+// errSessionClosed is returned by a session's Conn once the session ended.
+var errSessionClosed = errors.New("session closed")
+
+// OpenFunc opens a Mux session on documentID, read-only if readOnly, with
+// its client running over conn: it registers the client and starts its
+// pumps, as for a connection of its own. An error refuses the session and
+// is reported to the peer as the reason.
+type OpenFunc func(session, documentID string, readOnly bool, conn Conn) error
+
+// Mux carries several client sessions over one connection, so a browser
+// whose tabs share a SharedWorker holds one WebSocket rather than one per
+// tab. Each session is a Client of its own on the document it opened, with
+// its own presence, and every message names its session both ways:
+//
+//   - session_open with Session, DocumentID and optionally ReadOnly opens
+//     a session; its welcome and sync follow as on a new connection. An
+//     open for a session already open replaces it.
+//   - Any other message with Session goes to that session's client.
+//   - session_close with Session ends the session.
+//   - session_closed with Session reports that a session ended, with a
+//     Reason if the server ended it, could not open it or has none open.
+//
+// Messages are JSON in text frames. Run serves the connection.
+type Mux struct {
+	hub  *Hub
+	conn Conn
+	open OpenFunc
+
+	writeMu sync.Mutex // Serializes writes to conn
+
+	mu       sync.Mutex
+	sessions map[string]*muxConn
+	broken   bool           // conn failed; sessions end without notice
+	live     sync.WaitGroup // Sessions not yet ended
+	done     chan struct{}  // Closed when Run returns
+}
+
+// NewMux creates a Mux serving conn, opening sessions with open.
+func NewMux(hub *Hub, conn Conn, open OpenFunc) *Mux {
+	return &Mux{
+		hub:      hub,
+		conn:     conn,
+		open:     open,
+		sessions: make(map[string]*muxConn),
+		done:     make(chan struct{}),
+	}
+}
+
+// muxHeader is the part of an inbound message the Mux routes by.
+type muxHeader struct {
+	Type       MessageType `json:"type"`
+	Session    string      `json:"session"`
+	DocumentID string      `json:"document_id"`
+	ReadOnly   bool        `json:"read_only"`
+}
+
+// Run reads the connection and routes its messages until it closes or
+// the hub shuts down, then ends every session.
+func (m *Mux) Run() {
+	if !m.hub.routines.add(RoutineMux) {
+		m.conn.Close() // the hub has shut down
+		return
+	}
+	defer m.hub.routines.done(RoutineMux)
+	defer m.closeAll()
+	if !m.hub.spawn(RoutineMux, m.ping) {
+		return
+	}
+
+	m.conn.SetReadLimit(maxMessageSize)
+	m.conn.SetReadDeadline(time.Now().Add(pongWait))
+	m.conn.SetPongHandler(func(string) error {
+		return m.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, frame, err := m.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("unexpected mux close: %v", err)
+			}
+			return
+		}
+		var header muxHeader
+		if err := json.Unmarshal(frame, &header); err != nil || header.Session == "" {
+			log.Printf("mux: dropping message without a session")
+			continue
+		}
+
+		switch header.Type {
+		case MsgTypeSessionOpen:
+			m.openSession(header)
+		case MsgTypeSessionClose:
+			if c := m.session(header.Session); c != nil {
+				c.end("")
+			}
+		default:
+			c := m.session(header.Session)
+			if c == nil {
+				m.notifyClosed(header.Session, "session not open")
+				continue
+			}
+			if frame, err = stripSession(frame); err != nil {
+				continue
+			}
+			select {
+			case c.in <- frame:
+			case <-c.closed:
+			}
+		}
+	}
+}
+
+// ping keeps the connection alive, and once the hub shuts down gives the
+// sessions writeWait to report that they ended before closing it.
+func (m *Mux) ping() {
+	ticker := m.hub.clock.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.hub.quit:
+			ended := make(chan struct{})
+			go func() {
+				m.live.Wait()
+				close(ended)
+			}()
+			select {
+			case <-ended:
+			case <-time.After(writeWait):
+			}
+			m.write(websocket.CloseMessage, closeMessage(ErrHubStopped))
+			m.conn.Close()
+			return
+		case <-ticker.C():
+			if err := m.write(websocket.PingMessage, nil); err != nil {
+				m.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// openSession opens the session h asks for.
+func (m *Mux) openSession(h muxHeader) {
+	select {
+	case <-m.hub.quit:
+		m.notifyClosed(h.Session, "server shutting down")
+		return
+	default:
+	}
+	if old := m.session(h.Session); old != nil {
+		old.end("replaced")
+	}
+	m.mu.Lock()
+	if len(m.sessions) >= MaxMuxSessions {
+		m.mu.Unlock()
+		m.notifyClosed(h.Session, "too many sessions")
+		return
+	}
+	c := newMuxConn(m, h.Session)
+	m.sessions[h.Session] = c
+	m.live.Add(1)
+	m.mu.Unlock()
+
+	if err := m.open(h.Session, h.DocumentID, h.ReadOnly, c); err != nil {
+		c.end(err.Error())
+	}
+}
+
+// stripSession removes the session from an inbound message. The hub
+// relays some messages as received, and each copy gets the session of the
+// client it goes to.
+func stripSession(frame []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(frame, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "session")
+	return json.Marshal(fields)
+}
+
+// session returns the open session with id, or nil.
+func (m *Mux) session(id string) *muxConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[id]
+}
+
+// notifyClosed tells the peer that session has ended, or never opened.
+func (m *Mux) notifyClosed(session, reason string) {
+	data, err := (&Message{Type: MsgTypeSessionClosed, Session: session, Reason: reason}).ToBytes()
+	if err != nil {
+		return
+	}
+	m.write(websocket.TextMessage, data)
+}
+
+// write sends one frame on the connection.
+func (m *Mux) write(messageType int, data []byte) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return m.conn.WriteMessage(messageType, data)
+}
+
+// closeAll ends every session once the connection is gone and closes it.
+func (m *Mux) closeAll() {
+	close(m.done)
+	m.mu.Lock()
+	m.broken = true
+	sessions := make([]*muxConn, 0, len(m.sessions))
+	for _, c := range m.sessions {
+		sessions = append(sessions, c)
+	}
+	m.mu.Unlock()
+	for _, c := range sessions {
+		c.end("")
+	}
+	m.conn.Close()
+}
+
+// muxConn is one session's end of a Mux: the Conn its client runs over.
+// Pings and deadlines are the Mux's business, so it drops the client's
+// pings and only honors a read deadline that has passed, which is how
+// ReadPump wakes itself to stop.
+type muxConn struct {
+	mux     *Mux
+	session string
+	prefix  []byte      // `{"session":"<id>",`, spliced into outbound messages
+	in      chan []byte // Inbound frames for the client
+
+	closed     chan struct{}
+	endOnce    sync.Once
+	expired    chan struct{} // Closed once a past read deadline is set
+	expireOnce sync.Once
+}
+
+func newMuxConn(m *Mux, session string) *muxConn {
+	quoted, _ := json.Marshal(session)
+	return &muxConn{
+		mux:     m,
+		session: session,
+		prefix:  append(append([]byte(`{"session":`), quoted...), ','),
+		in:      make(chan []byte, 256),
+		closed:  make(chan struct{}),
+		expired: make(chan struct{}),
+	}
+}
+
+// end closes the session, tells the peer with reason unless the connection
+// is gone, and forgets it.
+func (c *muxConn) end(reason string) {
+	c.endOnce.Do(func() {
+		close(c.closed)
+		m := c.mux
+		m.mu.Lock()
+		if m.sessions[c.session] == c {
+			delete(m.sessions, c.session)
+		}
+		broken := m.broken
+		m.mu.Unlock()
+		if !broken {
+			m.notifyClosed(c.session, reason)
+		}
+		m.live.Done()
+	})
+}
+
+// tag adds the session to each newline-separated message in frame.
+func (c *muxConn) tag(frame []byte) []byte {
+	out := make([]byte, 0, len(frame)+len(c.prefix))
+	for i, line := range bytes.Split(frame, []byte{'\n'}) {
+		if i > 0 {
+			out = append(out, '\n')
+		}
+		if rest, ok := bytes.CutPrefix(line, []byte{'{'}); ok && len(bytes.TrimSpace(rest)) > 1 {
+			out = append(append(out, c.prefix...), rest...)
+		} else {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+// ReadMessage implements Conn.
+func (c *muxConn) ReadMessage() (int, []byte, error) {
+	select {
+	case frame := <-c.in:
+		return websocket.TextMessage, frame, nil
+	case <-c.closed:
+		return 0, nil, io.EOF
+	case <-c.expired:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteMessage implements Conn. A close message ends the session, with
+// the close frame's text as the reason.
+func (c *muxConn) WriteMessage(messageType int, data []byte) error {
+	select {
+	case <-c.closed:
+		return errSessionClosed
+	default:
+	}
+	switch messageType {
+	case websocket.PingMessage, websocket.PongMessage:
+		return nil
+	case websocket.CloseMessage:
+		var reason string
+		if len(data) > 2 {
+			reason = string(data[2:])
+		}
+		c.end(reason)
+		return nil
+	case websocket.TextMessage:
+		return c.mux.write(messageType, c.tag(data))
+	}
+	return errors.New("mux sessions carry text frames only")
+}
+
+// NextWriter implements Conn; the frame is sent when the writer is closed.
+func (c *muxConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	select {
+	case <-c.closed:
+		return nil, errSessionClosed
+	default:
+	}
+	return &muxWriter{conn: c, messageType: messageType}, nil
+}
+
+// SetReadLimit implements Conn; the Mux limits the connection's frames.
+func (c *muxConn) SetReadLimit(int64) {}
+
+// SetReadDeadline implements Conn.
+func (c *muxConn) SetReadDeadline(t time.Time) error {
+	if !t.IsZero() && !t.After(time.Now()) {
+		c.expireOnce.Do(func() { close(c.expired) })
+	}
+	return nil
+}
+
+// SetWriteDeadline implements Conn; the Mux sets the connection's.
+func (c *muxConn) SetWriteDeadline(time.Time) error { return nil }
+
+// SetPongHandler implements Conn. Sessions never see pongs.
+func (c *muxConn) SetPongHandler(func(string) error) {}
+
+// Close implements Conn.
+func (c *muxConn) Close() error {
+	c.end("")
+	return nil
+}
+
+type muxWriter struct {
+	conn        *muxConn
+	messageType int
+	buf         bytes.Buffer
+}
+
+func (w *muxWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *muxWriter) Close() error {
+	return w.conn.WriteMessage(w.messageType, w.buf.Bytes())
+}
@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/textnorm"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrUnknownNormalization is returned when setting a normalization form
+// the hub does not know.
+var ErrUnknownNormalization = errors.New("unknown normalization form")
+
+// SetNormalization keeps a document's text in Unicode form f, normalizing
+// inserts and content sets from now on and refusing operations whose
+// positions fall inside a grapheme cluster. If the current content is not
+// in form f it is normalized, and connected clients receive it.
+func (h *Hub) SetNormalization(documentID string, f textnorm.Form) error {
+	if !f.Valid() {
+		return fmt.Errorf("%w: %s", ErrUnknownNormalization, f)
+	}
+	var err error
+	if !h.do(func() { err = h.setNormalization(documentID, f) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) setNormalization(documentID string, f textnorm.Form) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+	doc := h.GetOrCreateDocument(documentID)
+	version, changed, err := doc.SetNormalization(f)
+	if err != nil {
+		return err
+	}
+	log.Printf("document %s normalization set to %q", documentID, f)
+	if !changed {
+		return nil
+	}
+
+	h.events.Emit(events.Event{
+		Type:       events.TypeContentSet,
+		DocumentID: documentID,
+		Version:    version,
+	})
+	msg := NewContentMessage(doc.GetContent())
+	msg.DocumentID = documentID
+	msg.Version = version
+	h.broadcastContent(documentID, doc, msg, nil)
+	h.contentChanged(documentID, version, nil)
+	return nil
+}
+
+// Normalization returns the Unicode form a document's text is kept in.
+func (h *Hub) Normalization(documentID string) textnorm.Form {
+	if doc := h.GetDocument(documentID); doc != nil {
+		return doc.Normalization()
+	}
+	return textnorm.FormNone
+}
@@ -0,0 +1,105 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/outline"
+	"fmt"
+	"log"
+)
+
+// Outline returns the heading outline of a text document. The hub builds a
+// document's outline the first time it is asked for and keeps it current
+// from then on, updating only the lines each operation touched.
+func (h *Hub) Outline(documentID string) ([]outline.Heading, error) {
+	var headings []outline.Heading
+	var err error
+	if !h.do(func() { headings, err = h.outline(documentID) }) {
+		return nil, ErrHubStopped
+	}
+	return headings, err
+}
+
+func (h *Hub) outline(documentID string) ([]outline.Heading, error) {
+	if h.IsDeleted(documentID) {
+		return nil, ErrDocumentDeleted
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return nil, fmt.Errorf("document %s not found", documentID)
+	}
+	if kind := doc.GetKind(); kind != document.KindText {
+		return nil, fmt.Errorf("outline of %s document", kind)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	o, ok := h.outlines[documentID]
+	if !ok {
+		o = outline.New(doc.GetContent())
+		h.outlines[documentID] = o
+	}
+	return o.Headings(), nil
+}
+
+// handleOutline replies with the document's outline and sends the client
+// every later change to it.
+func (h *Hub) handleOutline(documentID string, sender *Client) {
+	if sender == nil {
+		return
+	}
+	headings, err := h.outline(documentID)
+	if err != nil {
+		log.Printf("outline request for document %s ignored: %v", documentID, err)
+		return
+	}
+	sender.outline = true
+	if data, err := newOutlineMessage(documentID, headings).ToBytes(); err == nil {
+		h.sendToClient(sender, data)
+	}
+}
+
+// updateOutline refreshes a tracked outline after an edit, rescanning only
+// the lines change names, or the whole content when change is nil, and
+// sends the new outline to the clients following it.
+func (h *Hub) updateOutline(documentID string, change *operations.LineChange) {
+	h.mu.RLock()
+	o, ok := h.outlines[documentID]
+	h.mu.RUnlock()
+	if !ok {
+		return
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return
+	}
+
+	content := doc.GetContent()
+	h.mu.Lock()
+	var changed bool
+	if change != nil {
+		changed = o.Update(content, *change)
+	} else {
+		changed = o.Reparse(content)
+	}
+	headings := o.Headings()
+	h.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	data, err := newOutlineMessage(documentID, headings).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	data = h.stamp(data, documentID)
+	h.deliver(h.rooms[documentID], data, h.roomClients(documentID, func(c *Client) bool { return c.outline && !c.historical })...)
+}
+
+// newOutlineMessage builds the outline message for a document.
+func newOutlineMessage(documentID string, headings []outline.Heading) *Message {
+	return &Message{Type: MsgTypeOutline, DocumentID: documentID, Outline: headings}
+}
@@ -0,0 +1,198 @@
+package hub
+
+import (
+	"collaborative-docs/internal/events"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	maxPausedQueue    = 1000             // Edits held per paused document before further ones are rejected
+	defaultRetryAfter = 30 * time.Second // Retry hint when a pause names none
+)
+
+var (
+	// ErrDocumentPaused is returned when writing to a paused document.
+	ErrDocumentPaused = errors.New("document paused")
+	// ErrNotPaused is returned when resuming a document that is not paused.
+	ErrNotPaused = errors.New("document not paused")
+)
+
+// PauseOptions configures a document pause.
+type PauseOptions struct {
+	Reason     string        // Shown to clients, e.g. "maintenance"
+	Queue      bool          // Hold edits and apply them on resume instead of rejecting them
+	RetryAfter time.Duration // Hint sent with rejected edits; defaults to 30s
+}
+
+// pause is the state of a paused document. It is guarded by h.stateMu.
+type pause struct {
+	PauseOptions
+	queued []*broadcastMessage
+}
+
+// PauseDocument stops edits to a document, for maintenance or abuse
+// handling. Connected and newly joining clients receive a document_paused
+// message. Edits are held for ResumeDocument when opts.Queue is set, up to
+// a limit; otherwise, or beyond the limit, the sender gets a
+// document_paused reply with a retry hint. Read-only traffic continues.
+func (h *Hub) PauseDocument(documentID string, opts PauseOptions) error {
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = defaultRetryAfter
+	}
+	var err error
+	if !h.do(func() { err = h.pauseDocument(documentID, opts) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) pauseDocument(documentID string, opts PauseOptions) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+
+	h.stateMu.Lock()
+	p, ok := h.paused[documentID]
+	if ok {
+		p.PauseOptions = opts // keep already queued edits
+	} else {
+		p = &pause{PauseOptions: opts}
+		h.paused[documentID] = p
+	}
+	paused := newPausedMessage(documentID, p)
+	h.stateMu.Unlock()
+
+	log.Printf("document %s paused (reason: %q, queue: %v)", documentID, opts.Reason, opts.Queue)
+	if data, err := paused.ToBytes(); err == nil {
+		h.broadcastToDocument(documentID, data, nil)
+	}
+	h.events.Emit(events.Event{
+		Type:       events.TypeDocumentPaused,
+		DocumentID: documentID,
+		Detail:     opts.Reason,
+	})
+	return nil
+}
+
+// ResumeDocument lifts a pause. Clients receive a document_resumed message,
+// then any queued edits are applied and relayed in arrival order.
+func (h *Hub) ResumeDocument(documentID string) error {
+	var err error
+	if !h.do(func() { err = h.resumeDocument(documentID) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) resumeDocument(documentID string) error {
+	h.stateMu.Lock()
+	p, ok := h.paused[documentID]
+	delete(h.paused, documentID)
+	h.stateMu.Unlock()
+	if !ok {
+		return ErrNotPaused
+	}
+
+	log.Printf("document %s resumed, replaying %d queued edits", documentID, len(p.queued))
+	resumed := &Message{Type: MsgTypeDocumentResumed, DocumentID: documentID}
+	if data, err := resumed.ToBytes(); err == nil {
+		h.broadcastToDocument(documentID, data, nil)
+	}
+	h.events.Emit(events.Event{
+		Type:       events.TypeDocumentResumed,
+		DocumentID: documentID,
+	})
+
+	for _, bm := range p.queued {
+		// Time spent paused is not processing latency.
+		bm.received = h.clock.Now()
+		h.handleBroadcast(bm)
+	}
+	return nil
+}
+
+// IsPaused reports whether edits to the document are paused.
+func (h *Hub) IsPaused(documentID string) bool {
+	var ok bool
+	if !h.do(func() { ok = h.isPaused(documentID) }) {
+		return false
+	}
+	return ok
+}
+
+// isPaused is IsPaused for callers on the Run goroutine or in a room.
+func (h *Hub) isPaused(documentID string) bool {
+	h.stateMu.Lock()
+	defer h.stateMu.Unlock()
+	_, ok := h.paused[documentID]
+	return ok
+}
+
+// holdIfPaused queues or rejects an edit to a paused document and reports
+// whether it did so.
+func (h *Hub) holdIfPaused(documentID string, msg *Message, bm *broadcastMessage) bool {
+	if !msg.Type.isEdit() {
+		return false
+	}
+	h.stateMu.Lock()
+	p, ok := h.paused[documentID]
+	if !ok {
+		h.stateMu.Unlock()
+		return false
+	}
+	if p.Queue && len(p.queued) < maxPausedQueue {
+		p.queued = append(p.queued, bm)
+		h.stateMu.Unlock()
+		return true
+	}
+	reply := newPausedMessage(documentID, p)
+	h.stateMu.Unlock()
+
+	log.Printf("rejecting %s for paused document %s (trace %s)", msg.Type, documentID, msg.TraceID)
+	reply.TraceID = msg.TraceID
+	if data, err := reply.ToBytes(); err == nil {
+		h.sendToClient(bm.sender, data)
+	}
+	h.noteRejected(bm.sender)
+	return true
+}
+
+// notifyClientPaused tells a newly registered client its document is paused.
+func (h *Hub) notifyClientPaused(c *Client) {
+	h.stateMu.Lock()
+	p, ok := h.paused[c.documentID]
+	var paused *Message
+	if ok {
+		paused = newPausedMessage(c.documentID, p)
+	}
+	h.stateMu.Unlock()
+	if !ok {
+		return
+	}
+	if data, err := paused.ToBytes(); err == nil {
+		h.sendToClient(c, data)
+	}
+}
+
+// isEdit reports whether messages of this type change the document.
+func (t MessageType) isEdit() bool {
+	switch t {
+	case MsgTypeOperation, MsgTypeBlockOperation, MsgTypeJSONOperation,
+		MsgTypeContent, MsgTypeLanguage, MsgTypeMetadataSet, MsgTypeBlob,
+		MsgTypeUndo, MsgTypeRedo:
+		return true
+	}
+	return false
+}
+
+// newPausedMessage builds the document_paused message for a pause.
+func newPausedMessage(documentID string, p *pause) *Message {
+	return &Message{
+		Type:         MsgTypeDocumentPaused,
+		DocumentID:   documentID,
+		Reason:       p.Reason,
+		RetryAfterMS: int(p.RetryAfter / time.Millisecond),
+	}
+}
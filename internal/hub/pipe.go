@@ -0,0 +1,152 @@
+package hub
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrPipeClosed is returned by Pipe operations after either end closes.
+var ErrPipeClosed = errors.New("pipe closed")
+
+// Pipe is an in-memory connection between a Client and a test peer. The
+// Client side implements Conn; the peer sends and receives text messages.
+// Deadlines are accepted but ignored, and pings are counted, not answered.
+type Pipe struct {
+	toClient   chan []byte
+	fromClient chan []byte
+	closed     chan struct{}
+	closeOnce  sync.Once
+	pings      int
+	mu         sync.Mutex
+}
+
+// NewPipe creates an open in-memory connection.
+func NewPipe() *Pipe {
+	return &Pipe{
+		toClient:   make(chan []byte, 256),
+		fromClient: make(chan []byte, 256),
+		closed:     make(chan struct{}),
+	}
+}
+
+// Send delivers a text message to the client's ReadMessage.
+func (p *Pipe) Send(data []byte) error {
+	select {
+	case <-p.closed:
+		return ErrPipeClosed
+	case p.toClient <- data:
+		return nil
+	}
+}
+
+// Receive returns the next frame the client wrote, waiting up to timeout.
+// Frames may hold several newline-separated messages, as WritePump batches
+// queued messages into one frame.
+func (p *Pipe) Receive(timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case data := <-p.fromClient:
+		return data, nil
+	case <-p.closed:
+		// Deliver frames written before the close.
+		select {
+		case data := <-p.fromClient:
+			return data, nil
+		default:
+			return nil, ErrPipeClosed
+		}
+	case <-timer.C:
+		return nil, errors.New("receive timed out")
+	}
+}
+
+// Pings returns how many ping control messages the client has written.
+func (p *Pipe) Pings() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pings
+}
+
+// Done is closed once either end closes the pipe.
+func (p *Pipe) Done() <-chan struct{} {
+	return p.closed
+}
+
+// Close closes the pipe; the client's ReadMessage returns an error.
+func (p *Pipe) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
+
+// ReadMessage implements Conn.
+func (p *Pipe) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-p.toClient:
+		return websocket.TextMessage, data, nil
+	case <-p.closed:
+		return 0, nil, io.EOF
+	}
+}
+
+// WriteMessage implements Conn. A close message closes the pipe.
+func (p *Pipe) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case websocket.PingMessage:
+		p.mu.Lock()
+		p.pings++
+		p.mu.Unlock()
+		return nil
+	case websocket.CloseMessage:
+		return p.Close()
+	}
+
+	select {
+	case <-p.closed:
+		return ErrPipeClosed
+	case p.fromClient <- append([]byte(nil), data...):
+		return nil
+	}
+}
+
+// NextWriter implements Conn; the frame is sent when the writer is closed.
+func (p *Pipe) NextWriter(messageType int) (io.WriteCloser, error) {
+	select {
+	case <-p.closed:
+		return nil, ErrPipeClosed
+	default:
+	}
+	return &pipeWriter{pipe: p, messageType: messageType}, nil
+}
+
+// SetReadLimit implements Conn.
+func (p *Pipe) SetReadLimit(int64) {}
+
+// SetReadDeadline implements Conn.
+func (p *Pipe) SetReadDeadline(time.Time) error { return nil }
+
+// SetWriteDeadline implements Conn.
+func (p *Pipe) SetWriteDeadline(time.Time) error { return nil }
+
+// SetPongHandler implements Conn. Pipes never send pongs.
+func (p *Pipe) SetPongHandler(func(string) error) {}
+
+type pipeWriter struct {
+	pipe        *Pipe
+	messageType int
+	buf         bytes.Buffer
+}
+
+func (w *pipeWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *pipeWriter) Close() error {
+	return w.pipe.WriteMessage(w.messageType, w.buf.Bytes())
+}
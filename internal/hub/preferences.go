@@ -0,0 +1,89 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"fmt"
+	"log"
+)
+
+// validatePreferences checks preferences a client sends for a document.
+func validatePreferences(doc *document.Document, p *document.Preferences) error {
+	if p.CursorColor != "" && !validColor(p.CursorColor) {
+		return fmt.Errorf("cursor color %q is not #rrggbb", p.CursorColor)
+	}
+	if p.ScrollPosition < 0 || p.ScrollPosition > maxCursorPosition {
+		return fmt.Errorf("scroll position %d out of range [0, %d]", p.ScrollPosition, maxCursorPosition)
+	}
+	if version := doc.GetVersion(); p.LastReadVersion < 0 || p.LastReadVersion > version {
+		return fmt.Errorf("last read version %d out of range [0, %d]", p.LastReadVersion, version)
+	}
+	return nil
+}
+
+// handlePreferencesSet replaces the sender's preferences for the document
+// and replies with them as stored. Preferences are kept by user, so
+// connections without an authenticated user cannot store any.
+func (h *Hub) handlePreferencesSet(doc *document.Document, documentID string, msg *Message, sender *Client) {
+	if sender == nil || msg.Preferences == nil {
+		return
+	}
+	if sender.UserID() == "" {
+		log.Printf("preferences for document %s rejected: no authenticated user", documentID)
+		return
+	}
+	if err := validatePreferences(doc, msg.Preferences); err != nil {
+		log.Printf("preferences for document %s rejected: %v", documentID, err)
+		return
+	}
+	p := doc.SetPreferences(sender.UserID(), *msg.Preferences)
+	h.replyPreferences(sender, &p)
+}
+
+// handlePreferencesGet replies to the sender with its stored preferences
+// for the document, empty if it has none.
+func (h *Hub) handlePreferencesGet(doc *document.Document, sender *Client) {
+	if sender == nil {
+		return
+	}
+	p, _ := doc.Preferences(sender.UserID())
+	h.replyPreferences(sender, &p)
+}
+
+// sendPreferences gives a newly registered client the preferences its
+// user stored for the document, if any.
+func (h *Hub) sendPreferences(client *Client) {
+	if client.UserID() == "" {
+		return
+	}
+	doc := h.GetDocument(client.documentID)
+	if doc == nil {
+		return
+	}
+	if p, ok := doc.Preferences(client.UserID()); ok {
+		h.replyPreferences(client, &p)
+	}
+}
+
+// replyPreferences sends preferences to one client.
+func (h *Hub) replyPreferences(client *Client, p *document.Preferences) {
+	data, err := NewPreferencesMessage(client.documentID, p).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(client, data)
+}
+
+// preferredColor returns the cursor color the client's user chose for the
+// document, or "" if none.
+func (h *Hub) preferredColor(client *Client) string {
+	if client.UserID() == "" {
+		return ""
+	}
+	doc := h.GetDocument(client.documentID)
+	if doc == nil {
+		return ""
+	}
+	p, _ := doc.Preferences(client.UserID())
+	return p.CursorColor
+}
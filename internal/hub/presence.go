@@ -0,0 +1,69 @@
+package hub
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// collaboratorIdleAfter is how long a collaborator must go without editing
+// or moving its cursor to be reported idle.
+const collaboratorIdleAfter = 2 * time.Minute
+
+// Collaborator is one client in a presence snapshot: who it is, the cursor
+// it last shared, if any, and how long it has gone without editing or
+// moving its cursor.
+type Collaborator struct {
+	ClientID string  `json:"client_id"`
+	Name     string  `json:"name,omitempty"`   // Display name, from its cursor or identity
+	Color    string  `json:"color,omitempty"`  // Display color as #rrggbb
+	Cursor   *Cursor `json:"cursor,omitempty"` // Caret and selection, if shared
+	Idle     bool    `json:"idle,omitempty"`   // Inactive for at least two minutes
+	IdleMS   int64   `json:"idle_ms"`          // Time since its last edit or cursor move
+}
+
+// noteActivity records that a client edited or moved its cursor, for the
+// idle state presence snapshots report.
+func (h *Hub) noteActivity(client *Client) {
+	if client != nil {
+		client.stats.active = h.clock.Now()
+	}
+}
+
+// handlePresenceRequest answers a presence_request with every other
+// client sharing the sender's view of the document, so a client that has
+// just reconnected can show its collaborators at once rather than as each
+// next moves its cursor.
+func (h *Hub) handlePresenceRequest(documentID string, sender *Client) {
+	if sender == nil {
+		return
+	}
+	h.mu.RLock()
+	others := h.roomClients(documentID, func(c *Client) bool { return c != sender && c.sameView(sender) })
+	h.mu.RUnlock()
+
+	now := h.clock.Now()
+	collaborators := make([]Collaborator, 0, len(others))
+	for _, c := range others {
+		collaborator := Collaborator{ClientID: c.id, Name: c.DisplayName(), Color: h.preferredColor(c)}
+		h.stateMu.Lock()
+		cursor, ok := h.presence[documentID][c]
+		h.stateMu.Unlock()
+		if ok {
+			collaborator.Cursor = &cursor
+			collaborator.Name, collaborator.Color = cursor.Name, cursor.Color
+		}
+		idle := now.Sub(c.stats.active)
+		collaborator.IdleMS = idle.Milliseconds()
+		collaborator.Idle = idle >= collaboratorIdleAfter
+		collaborators = append(collaborators, collaborator)
+	}
+	sort.Slice(collaborators, func(i, j int) bool { return collaborators[i].ClientID < collaborators[j].ClientID })
+
+	data, err := (&Message{Type: MsgTypePresence, DocumentID: documentID, Presence: collaborators}).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(sender, data)
+}
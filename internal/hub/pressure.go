@@ -0,0 +1,127 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/pressure"
+	"log"
+	"time"
+)
+
+// idleEvictAfter is how long a document without clients must go unmodified
+// before it is evicted under high (but not critical) memory pressure.
+const idleEvictAfter = 10 * time.Minute
+
+// SetMemoryController enables memory pressure handling in CheckMemory.
+// It must be called before Run.
+func (h *Hub) SetMemoryController(c *pressure.Controller) {
+	h.memory = c
+}
+
+// CheckMemory samples memory use and sheds load when it is above the
+// watermarks: under high pressure it compacts document history and evicts
+// documents idle for idleEvictAfter; under critical pressure it evicts every
+// document without connected clients, heaviest first. Level changes are
+// emitted as memory_pressure events and each eviction as document_evicted.
+func (h *Hub) CheckMemory() pressure.Level {
+	if h.memory == nil {
+		return pressure.LevelNormal
+	}
+
+	level, used, changed := h.memory.Sample()
+	if changed {
+		log.Printf("memory pressure %s (%d bytes in use)", level, used)
+		h.events.Emit(events.Event{Type: events.TypeMemoryPressure, Detail: level.String()})
+	}
+	if level == pressure.LevelNormal {
+		return level
+	}
+
+	compacted := 0
+	for _, doc := range h.snapshotDocuments() {
+		compacted += doc.CompactHistory()
+	}
+
+	evicted := h.evictIdle(level == pressure.LevelCritical)
+	if compacted > 0 || evicted > 0 {
+		log.Printf("memory pressure %s: compacted %d history entries, evicted %d documents",
+			level, compacted, evicted)
+	}
+	return level
+}
+
+// evictIdle removes documents with no connected clients, heaviest first.
+// Unless all is set, only documents unmodified for idleEvictAfter qualify.
+func (h *Hub) evictIdle(all bool) int {
+	evicted := 0
+	for _, ds := range h.Stats().Documents {
+		if ds.Clients > 0 || (!all && h.clock.Now().Sub(ds.LastModified) < idleEvictAfter) {
+			continue
+		}
+		if h.evict(ds.ID, ds.Version) {
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// evict drops a document at version from memory and emits
+// document_evicted. It reports false, keeping the document, if it has
+// clients or, with a store, changed or could not be saved.
+func (h *Hub) evict(documentID string, version int) bool {
+	if h.persistence != nil {
+		// Stored documents are unloaded rather than buried, and come
+		// back on next use.
+		if !h.unload(documentID) {
+			return false
+		}
+		h.latency.Forget(documentID)
+		h.events.Emit(events.Event{
+			Type:       events.TypeDocumentEvicted,
+			DocumentID: documentID,
+			Version:    version,
+			Detail:     DeleteReasonUnloaded,
+		})
+		return true
+	}
+
+	h.mu.Lock()
+	// Re-check under the lock: a client may have joined since the caller
+	// looked.
+	if h.hasClients(documentID) {
+		h.mu.Unlock()
+		return false
+	}
+	h.bury(documentID, &tombstone{reason: DeleteReasonEvicted, deletedAt: h.clock.Now()})
+	embedders := h.embeddersOf(documentID)
+	h.mu.Unlock()
+	h.notifyEmbedders(embedders, &EmbedChange{DocumentID: documentID})
+
+	h.latency.Forget(documentID)
+	h.events.Emit(events.Event{
+		Type:       events.TypeDocumentEvicted,
+		DocumentID: documentID,
+		Version:    version,
+		Detail:     DeleteReasonEvicted,
+	})
+	return true
+}
+
+// hasClients reports whether any client is connected to documentID.
+// Callers must hold h.mu.
+func (h *Hub) hasClients(documentID string) bool {
+	r := h.rooms[documentID]
+	return r != nil && len(r.clients) > 0
+}
+
+// snapshotDocuments returns the currently loaded documents.
+func (h *Hub) snapshotDocuments() []*document.Document {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	docs := make([]*document.Document, 0, len(h.documents))
+	for _, doc := range h.documents {
+		docs = append(docs, doc)
+	}
+	return docs
+}
@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"errors"
+	"log"
+)
+
+// ErrDocumentNotFound is returned when publishing a document the hub does
+// not have.
+var ErrDocumentNotFound = errors.New("document not found")
+
+// Publish records an approved snapshot as the document's published
+// version. Approval happens outside the hub, so by the time it arrives the
+// document may have moved on; the snapshot is kept as approved, and an
+// older snapshot than the one already published is refused with
+// document.ErrStalePublication. Collaborators receive a published message.
+func (h *Hub) Publish(documentID string, p document.Publication) error {
+	var err error
+	if !h.do(func() { err = h.publish(documentID, p) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) publish(documentID string, p document.Publication) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return ErrDocumentNotFound
+	}
+	if p.PublishedAt.IsZero() {
+		p.PublishedAt = h.clock.Now()
+	}
+	if err := doc.Publish(p); err != nil {
+		return err
+	}
+
+	log.Printf("document %s published at version %d", documentID, p.Version)
+	msg := &Message{Type: MsgTypePublished, DocumentID: documentID, Version: p.Version}
+	if data, err := msg.ToBytes(); err == nil {
+		h.broadcastToDocument(documentID, data, nil)
+	}
+	h.events.Emit(events.Event{
+		Type:       events.TypeDocumentPublished,
+		DocumentID: documentID,
+		Version:    p.Version,
+	})
+	return nil
+}
+
+// Published returns the document's published version, if it has one.
+func (h *Hub) Published(documentID string) (document.Publication, bool) {
+	doc := h.GetDocument(documentID)
+	if doc == nil || h.IsDeleted(documentID) {
+		return document.Publication{}, false
+	}
+	return doc.Published()
+}
@@ -0,0 +1,110 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"collaborative-docs/internal/events"
+)
+
+// ErrRateLimited is the cause when a client kept sending over its rate
+// limit after being warned.
+var ErrRateLimited = errors.New("client disconnected for exceeding its message rate")
+
+// RateLimit caps how fast each connection's messages reach the hub, so a
+// flooding client cannot starve the others of the hub's single inbound
+// channel. Unlike AbusePolicy, which scores messages the hub has already
+// taken, it acts in the client's ReadPump: each client has a bucket of
+// Burst tokens refilled at MessagesPerSecond, every message takes one, and
+// a message finding the bucket empty is dropped. The first message
+// dropped in a row is answered with a rate_limited error, and a client
+// with DisconnectAfter messages dropped in a row is disconnected with
+// ErrRateLimited.
+type RateLimit struct {
+	MessagesPerSecond float64 // Sustained rate allowed; zero disables the limit
+	Burst             int     // Messages a client may send at once; zero allows a second's worth
+	DisconnectAfter   int     // Messages dropped in a row before disconnecting; zero never disconnects
+}
+
+// SetRateLimit limits how fast each client's messages reach the hub. The
+// zero value, the default, does not limit them. It must be called before
+// Run.
+func (h *Hub) SetRateLimit(l RateLimit) {
+	if l.Burst <= 0 {
+		l.Burst = max(1, int(math.Ceil(l.MessagesPerSecond)))
+	}
+	h.rateLimit = l
+}
+
+// tokenBucket is one client's share of the rate limit. It is only used
+// from the client's ReadPump.
+type tokenBucket struct {
+	tokens  float64
+	at      time.Time // When tokens was last refilled
+	dropped int       // Messages dropped in a row
+}
+
+// overLimit takes a token for message and reports whether there was none,
+// in which case message must be dropped. It warns the client of the first
+// message dropped in a row, and returns ErrRateLimited once the client is
+// to be disconnected.
+func (c *Client) overLimit(message []byte) (bool, error) {
+	l := c.hub.rateLimit
+	if l.MessagesPerSecond <= 0 {
+		return false, nil
+	}
+	now := c.hub.clock.Now()
+	b := c.bucket
+	if b == nil {
+		b = &tokenBucket{tokens: float64(l.Burst), at: now}
+		c.bucket = b
+	}
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.at).Seconds()*l.MessagesPerSecond)
+	b.at = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.dropped = 0
+		return false, nil
+	}
+
+	b.dropped++
+	c.hub.metrics.limited.Inc()
+	if l.DisconnectAfter > 0 && b.dropped >= l.DisconnectAfter {
+		log.Printf("disconnecting client %s on document %s: %d messages over the rate limit", c.id, c.documentID, b.dropped)
+		c.hub.events.Emit(events.Event{
+			Type:       events.TypeClientDisconnected,
+			DocumentID: c.documentID,
+			Detail:     c.id,
+		})
+		return true, ErrRateLimited
+	}
+	if b.dropped == 1 {
+		wait := time.Duration((1 - b.tokens) / l.MessagesPerSecond * float64(time.Second))
+		c.warnRateLimited(message, wait)
+	}
+	return true, nil
+}
+
+// warnRateLimited tells the client that message was dropped for exceeding
+// its rate limit, and when it may send again.
+func (c *Client) warnRateLimited(message []byte, wait time.Duration) {
+	reply := &Message{
+		Type:         MsgTypeError,
+		DocumentID:   c.documentID,
+		Code:         ErrorRateLimited,
+		Reason:       fmt.Sprintf("rate limit of %g messages per second exceeded", c.hub.rateLimit.MessagesPerSecond),
+		RetryAfterMS: int(wait.Milliseconds()) + 1,
+	}
+	if msg, err := MessageFromBytes(message); err == nil {
+		reply.AckID, reply.TraceID = msg.ackID(), msg.TraceID
+	}
+	data, err := reply.ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	c.hub.do(func() { c.hub.sendToClient(c, data) })
+}
@@ -0,0 +1,187 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"errors"
+	"fmt"
+	"log"
+)
+
+var (
+	// ErrNoOpLog is returned by Rebuild when the hub's store keeps no
+	// operation log.
+	ErrNoOpLog = errors.New("store keeps no operation log")
+	// ErrNothingToRebuild is returned by Rebuild when neither the stored
+	// document, its log's base nor its checkpoints hold content that
+	// checks out.
+	ErrNothingToRebuild = errors.New("no verified content to rebuild from")
+)
+
+// RebuildResult describes a rebuilt document.
+type RebuildResult struct {
+	From     int `json:"from"`     // Version of the snapshot or checkpoint replay started from
+	Replayed int `json:"replayed"` // Last version replayed from the log and verified
+	Version  int `json:"version"`  // New version of the document
+}
+
+// Rebuild recovers a document whose stored or loaded content is corrupt,
+// as from storage faults or a bad deploy. It replays the store's operation
+// log over the last snapshot that matches its checksum, whether the stored
+// document, the log's base or a checkpoint, verifying the checksum after
+// each logged save, and installs the newest content that checks out as a
+// new version. Changes not yet saved are discarded. The document is saved
+// at once, clearing a failed load, and every client is forced to resync.
+func (h *Hub) Rebuild(documentID string) (RebuildResult, error) {
+	var res RebuildResult
+	var err error
+	if !h.do(func() { res, err = h.rebuild(documentID) }) {
+		return RebuildResult{}, ErrHubStopped
+	}
+	return res, err
+}
+
+func (h *Hub) rebuild(documentID string) (RebuildResult, error) {
+	p := h.persistence
+	if p == nil {
+		return RebuildResult{}, ErrNoOpLog
+	}
+	oplog, ok := p.store.(document.OpLog)
+	if !ok {
+		return RebuildResult{}, ErrNoOpLog
+	}
+	if h.IsDeleted(documentID) {
+		return RebuildResult{}, ErrDocumentDeleted
+	}
+	res, content, err := h.replayLog(oplog, documentID)
+	if err != nil {
+		return res, err
+	}
+
+	h.events.Emit(events.Event{
+		Type:       events.TypeDocumentRebuilt,
+		DocumentID: documentID,
+		Version:    res.Version,
+	})
+	h.events.Emit(events.Event{
+		Type:       events.TypeContentSet,
+		DocumentID: documentID,
+		Version:    res.Version,
+	})
+	msg := NewContentMessage(content)
+	msg.DocumentID = documentID
+	msg.Version = res.Version
+	data, err := msg.ToBytes()
+	if err != nil {
+		return res, fmt.Errorf("serialization failed: %w", err)
+	}
+	h.broadcastToDocument(documentID, data, nil)
+	h.contentChanged(documentID, res.Version, nil)
+
+	h.mu.RLock()
+	viewers := h.roomClients(documentID, func(c *Client) bool { return c.historical })
+	h.mu.RUnlock()
+	for _, client := range viewers {
+		h.sendSnapshot(client)
+	}
+
+	log.Printf("document %s rebuilt from version %d, replayed to %d, version: %d", documentID, res.From, res.Replayed, res.Version)
+	return res, nil
+}
+
+// replayLog builds the rebuilt document, installs it and saves it,
+// returning its content. Callers must not hold h.persistence.mu.
+func (h *Hub) replayLog(oplog document.OpLog, documentID string) (RebuildResult, string, error) {
+	p := h.persistence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h.mu.RLock()
+	loaded := h.documents[documentID]
+	h.mu.RUnlock()
+	stored, err := p.store.Load(documentID)
+	if err != nil && !errors.Is(err, document.ErrNotStored) {
+		log.Printf("rebuilding document %s without its stored copy: %v", documentID, err)
+	}
+	base, entries, err := oplog.LoadLog(documentID)
+	if err != nil && !errors.Is(err, document.ErrNotStored) {
+		return RebuildResult{}, "", fmt.Errorf("loading operation log: %w", err)
+	}
+
+	// Settings, checkpoints and the rest come from the freshest copy
+	// that decoded: the loaded document unless its load failed.
+	var settings *document.Snapshot
+	switch {
+	case loaded != nil && !p.broken[documentID]:
+		settings, _ = loaded.Snapshot()
+	case stored != nil:
+		settings = stored
+	case base != nil:
+		settings = base
+	default:
+		return RebuildResult{}, "", fmt.Errorf("%w: document %s", ErrNothingToRebuild, documentID)
+	}
+
+	type start struct {
+		content string
+		version int
+		trusted bool // Matched its own checksum
+	}
+	var starts []start
+	for _, s := range []*document.Snapshot{stored, base} {
+		if s != nil && s.Checksum != "" && s.Verify() == nil {
+			starts = append(starts, start{s.Content, s.Version, true})
+		}
+	}
+	for _, cp := range settings.Checkpoints {
+		starts = append(starts, start{cp.Content, cp.Version, false})
+	}
+
+	var res RebuildResult
+	var content string
+	found := false
+	for _, s := range starts {
+		c, v, verified := document.ReplayLog(s.content, s.version, entries)
+		if !verified && !s.trusted {
+			continue // A checkpoint is only as good as the log entries it leads to
+		}
+		if !found || v > res.Replayed {
+			res.From, res.Replayed, content, found = s.version, v, c, true
+		}
+	}
+	if !found {
+		return RebuildResult{}, "", fmt.Errorf("%w: document %s", ErrNothingToRebuild, documentID)
+	}
+
+	rebuilt := *settings
+	res.Version = max(res.Replayed, settings.Version) + 1
+	if loaded != nil {
+		res.Version = max(res.Version, loaded.GetVersion()+1)
+	}
+	if content != settings.Content {
+		// Formatting and fences are positions in the content they were
+		// saved with.
+		rebuilt.Formatting, rebuilt.Fences = nil, nil
+	}
+	rebuilt.Content = content
+	rebuilt.Checksum = document.Checksum(content)
+	rebuilt.Version = res.Version
+	rebuilt.LastModified = h.clock.Now()
+	doc := document.Restore(&rebuilt, h.clock)
+
+	h.mu.Lock()
+	if s := h.schemaFor(documentID); s != nil {
+		if err := doc.SetSchema(s); err != nil {
+			log.Printf("rebuilt document %s does not satisfy its schema: %v", documentID, err)
+		}
+	}
+	h.documents[documentID] = doc
+	delete(h.outlines, documentID)
+	delete(h.diagnostics, documentID)
+	h.mu.Unlock()
+	delete(p.saved, documentID)
+	delete(p.broken, documentID)
+	delete(p.logged, documentID) // the log starts again at the rebuilt content
+	h.save(documentID, doc)
+	return res, content, nil
+}
@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"fmt"
+	"log"
+)
+
+// Redact scrubs sensitive text from a document, its retained history, its
+// checkpoints and its publication, for secret-leak or erasure requests,
+// and returns the new version. Every
+// client of the document is forced to resync: editors receive the redacted
+// content and historical viewers a fresh snapshot. Unlike other admin
+// writes it works on paused documents, which may be paused for this.
+func (h *Hub) Redact(documentID string, r document.Redaction) (int, error) {
+	var version int
+	var err error
+	if !h.do(func() { version, err = h.redact(documentID, r) }) {
+		return 0, ErrHubStopped
+	}
+	return version, err
+}
+
+func (h *Hub) redact(documentID string, r document.Redaction) (int, error) {
+	if h.IsDeleted(documentID) {
+		return 0, ErrDocumentDeleted
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return 0, fmt.Errorf("document %s not found", documentID)
+	}
+
+	previous := doc.GetVersion()
+	content, version, err := doc.Redact(r)
+	if err != nil {
+		return version, err
+	}
+	h.events.Emit(events.Event{
+		Type:       events.TypeDocumentRedacted,
+		DocumentID: documentID,
+		Version:    version,
+	})
+	if version == previous {
+		return version, nil
+	}
+	if p := h.persistence; p != nil {
+		p.mu.Lock()
+		delete(p.logged, documentID) // its operation log holds the redacted text
+		p.mu.Unlock()
+	}
+
+	h.events.Emit(events.Event{
+		Type:       events.TypeContentSet,
+		DocumentID: documentID,
+		Version:    version,
+	})
+	msg := NewContentMessage(content)
+	msg.DocumentID = documentID
+	msg.Version = version
+	data, err := msg.ToBytes()
+	if err != nil {
+		return version, fmt.Errorf("serialization failed: %w", err)
+	}
+	h.broadcastToDocument(documentID, data, nil)
+	h.contentChanged(documentID, version, nil)
+
+	h.mu.RLock()
+	viewers := h.roomClients(documentID, func(c *Client) bool { return c.historical })
+	h.mu.RUnlock()
+	for _, client := range viewers {
+		h.sendSnapshot(client)
+	}
+
+	log.Printf("document %s redacted, version: %d", documentID, version)
+	return version, nil
+}
@@ -0,0 +1,252 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// redisQueueSize bounds messages waiting to be published. Publish
+	// blocks beyond it rather than dropping, since a lost operation leaves
+	// the nodes' copies of a document apart.
+	redisQueueSize = 1024
+
+	// redisBacklogSize bounds the messages kept for one document while
+	// Redis is unreachable; beyond it they are replaced by a snapshot.
+	redisBacklogSize = 256
+
+	// redisRetryInterval is how often publishing is retried while Redis is
+	// unreachable.
+	redisRetryInterval = time.Second
+)
+
+// ErrBrokerClosed is returned when publishing through a closed broker.
+var ErrBrokerClosed = errors.New("broker closed")
+
+// RedisClient is the subset of a Redis client the broker needs. An adapter
+// over a library such as go-redis is enough; PSubscribe must call handler
+// for each message in the order Redis delivers them, one at a time.
+type RedisClient interface {
+	Publish(channel string, payload []byte) error
+	PSubscribe(pattern string, handler func(channel string, payload []byte)) error
+}
+
+// RedisBroker is a Broker over Redis pub/sub with a channel per document,
+// named by a prefix and the document ID, e.g. "docs:report". Redis
+// delivers each channel's messages to every subscriber in the order they
+// were published. Pub/sub does not keep messages, so a node that loses its
+// connection misses what was published meanwhile.
+//
+// When publishing fails, the broker stores messages by document and
+// retries every second, then publishes them in order once Redis is back,
+// so an outage delays edits between nodes rather than forking the
+// document. A document whose backlog outgrows 256 messages is published
+// as a snapshot of its content instead, read when Redis is back.
+type RedisBroker struct {
+	client  RedisClient
+	prefix  string
+	limit   int           // Messages kept per document while unreachable
+	retry   time.Duration // Between attempts while unreachable
+	queue   chan redisMessage
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+
+	mu       sync.Mutex
+	snapshot func(documentID string) ([]byte, error) // Set by SetSnapshots
+
+	// Only used by run: the documents with stored messages, in the order
+	// they fell behind, and their backlogs.
+	behind  []string
+	backlog map[string]*redisBacklog
+}
+
+// redisMessage is a message waiting to be published.
+type redisMessage struct {
+	documentID string
+	payload    []byte
+}
+
+// redisBacklog is a document's messages stored while Redis is unreachable.
+type redisBacklog struct {
+	messages []redisMessage
+	snapshot bool // Publish a snapshot in place of the dropped messages
+}
+
+// NewRedisBroker creates a broker and starts its publishing goroutine. The
+// prefix defaults to "docs:" and must not contain glob characters.
+func NewRedisBroker(client RedisClient, prefix string) *RedisBroker {
+	return newRedisBroker(client, prefix, redisBacklogSize, redisRetryInterval)
+}
+
+func newRedisBroker(client RedisClient, prefix string, limit int, retry time.Duration) *RedisBroker {
+	if prefix == "" {
+		prefix = "docs:"
+	}
+	b := &RedisBroker{
+		client:  client,
+		prefix:  prefix,
+		limit:   limit,
+		retry:   retry,
+		queue:   make(chan redisMessage, redisQueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		backlog: make(map[string]*redisBacklog),
+	}
+	go b.run()
+	return b
+}
+
+// Publish queues data for the document's channel. Messages are published
+// one at a time, in order, so the hub's Run loop never waits on Redis.
+func (b *RedisBroker) Publish(documentID string, data []byte) error {
+	select {
+	case b.queue <- redisMessage{documentID, data}:
+		return nil
+	case <-b.closing:
+		return ErrBrokerClosed
+	}
+}
+
+// Subscribe delivers messages published to any document's channel.
+func (b *RedisBroker) Subscribe(deliver func(documentID string, data []byte)) error {
+	return b.client.PSubscribe(b.prefix+"*", func(channel string, payload []byte) {
+		if documentID, ok := strings.CutPrefix(channel, b.prefix); ok {
+			deliver(documentID, payload)
+		}
+	})
+}
+
+// SetSnapshots implements SnapshotBroker.
+func (b *RedisBroker) SetSnapshots(snapshot func(documentID string) ([]byte, error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.snapshot = snapshot
+}
+
+// Close publishes what is still queued and stops the broker. Messages
+// still stored because Redis is unreachable are dropped.
+func (b *RedisBroker) Close() {
+	b.once.Do(func() { close(b.closing) })
+	<-b.done
+}
+
+// run publishes queued messages until the broker is closed, then flushes
+// whatever is still queued. While documents are behind it stores new
+// messages too, so each document's stay in order, and retries on a timer.
+func (b *RedisBroker) run() {
+	defer close(b.done)
+	retry := time.NewTimer(b.retry)
+	retry.Stop()
+	defer retry.Stop()
+	armed := false
+	for {
+		select {
+		case m := <-b.queue:
+			b.send(m)
+		case <-retry.C:
+			armed = false
+			b.replay()
+		case <-b.closing:
+			for {
+				select {
+				case m := <-b.queue:
+					b.send(m)
+				default:
+					if b.replay(); len(b.behind) > 0 {
+						log.Printf("redis broker: closed with %d documents unpublished", len(b.behind))
+					}
+					return
+				}
+			}
+		}
+		if len(b.behind) > 0 && !armed {
+			retry.Reset(b.retry)
+			armed = true
+		}
+	}
+}
+
+// send publishes m, or stores it if Redis is unreachable.
+func (b *RedisBroker) send(m redisMessage) {
+	if len(b.behind) > 0 {
+		b.store(m)
+		return
+	}
+	if err := b.publish(m); err != nil {
+		log.Printf("redis broker: publishing to %s%s failed, storing until it recovers: %v", b.prefix, m.documentID, err)
+		b.store(m)
+	}
+}
+
+// store adds m to its document's backlog. A full backlog is dropped for a
+// snapshot, which covers the messages after it too.
+func (b *RedisBroker) store(m redisMessage) {
+	bl := b.backlog[m.documentID]
+	if bl == nil {
+		bl = &redisBacklog{}
+		b.backlog[m.documentID] = bl
+		b.behind = append(b.behind, m.documentID)
+	}
+	switch {
+	case bl.snapshot:
+	case len(bl.messages) < b.limit:
+		bl.messages = append(bl.messages, m)
+	default:
+		log.Printf("redis broker: more than %d messages stored for document %s, publishing a snapshot instead", b.limit, m.documentID)
+		bl.messages, bl.snapshot = nil, true
+	}
+}
+
+// replay publishes the stored messages, document by document, until one
+// fails. Each document's are published in order, and what is left stays
+// stored for the next attempt.
+func (b *RedisBroker) replay() {
+	replayed := 0
+	for len(b.behind) > 0 {
+		documentID := b.behind[0]
+		bl := b.backlog[documentID]
+		if bl.snapshot {
+			data, err := b.readSnapshot(documentID)
+			if err != nil {
+				log.Printf("redis broker: dropping stored messages for document %s: %v", documentID, err)
+			} else if err := b.publish(redisMessage{documentID, data}); err != nil {
+				return
+			}
+			bl.snapshot = false
+			replayed++
+		}
+		for len(bl.messages) > 0 {
+			if err := b.publish(bl.messages[0]); err != nil {
+				return
+			}
+			bl.messages = bl.messages[1:]
+			replayed++
+		}
+		delete(b.backlog, documentID)
+		b.behind = b.behind[1:]
+	}
+	if replayed > 0 {
+		log.Printf("redis broker: reconnected, published %d stored messages", replayed)
+	}
+}
+
+// readSnapshot reads a document's snapshot through the function set by
+// SetSnapshots.
+func (b *RedisBroker) readSnapshot(documentID string) ([]byte, error) {
+	b.mu.Lock()
+	snapshot := b.snapshot
+	b.mu.Unlock()
+	if snapshot == nil {
+		return nil, errors.New("no snapshots set")
+	}
+	return snapshot(documentID)
+}
+
+// publish sends one message to its document's channel.
+func (b *RedisBroker) publish(m redisMessage) error {
+	return b.client.Publish(b.prefix+m.documentID, m.payload)
+}
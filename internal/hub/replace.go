@@ -0,0 +1,125 @@
+package hub
+
+import (
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/operations"
+	"errors"
+	"fmt"
+	"log"
+)
+
+var (
+	// ErrDocumentDeleted is returned when writing to a deleted document.
+	ErrDocumentDeleted = errors.New("document deleted")
+	// ErrHubStopped is returned when the hub has shut down.
+	ErrHubStopped = errors.New("hub stopped")
+)
+
+// ReplaceContent sets a document's content if it is still at
+// expectedVersion, creating the document when expectedVersion is 0. The
+// change reaches connected clients as server-generated operations, so their
+// cursors and unsent edits survive. A stale version returns a
+// *document.VersionConflictError.
+func (h *Hub) ReplaceContent(documentID, content string, expectedVersion int) (int, error) {
+	var version int
+	var err error
+	if !h.do(func() { version, err = h.replaceContent(documentID, content, expectedVersion) }) {
+		return 0, ErrHubStopped
+	}
+	return version, err
+}
+
+// replaceContent runs on the hub goroutine so the generated operations are
+// ordered with client operations.
+func (h *Hub) replaceContent(documentID, content string, expectedVersion int) (int, error) {
+	if h.IsDeleted(documentID) {
+		return 0, ErrDocumentDeleted
+	}
+	if h.isPaused(documentID) {
+		return 0, ErrDocumentPaused
+	}
+
+	doc := h.GetOrCreateDocument(documentID)
+	ops, version, err := doc.ReplaceContent(expectedVersion, content)
+	if err != nil {
+		return version, err
+	}
+
+	if err := h.relayServerOperations(documentID, ops, nil, ""); err != nil {
+		return version, err
+	}
+
+	log.Printf("document %s replaced via API, version: %d", documentID, version)
+	return version, nil
+}
+
+// ApplyOperation applies a text operation for a trusted server-side
+// component, such as a bot, find and replace or a migration. op.Version is
+// the version the component read; the operation is transformed past later
+// changes, then versioned and broadcast like a client's. It is exempt from
+// the abuse policy's rate limits and is handled ahead of queued client
+// messages. It returns the resulting version, which is unchanged when
+// later edits made the operation redundant.
+func (h *Hub) ApplyOperation(documentID string, op *operations.Operation) (int, error) {
+	var version int
+	var err error
+	if !h.do(func() { version, err = h.applyOperation(documentID, op) }) {
+		return 0, ErrHubStopped
+	}
+	return version, err
+}
+
+func (h *Hub) applyOperation(documentID string, op *operations.Operation) (int, error) {
+	if h.IsDeleted(documentID) {
+		return 0, ErrDocumentDeleted
+	}
+	if h.isPaused(documentID) {
+		return 0, ErrDocumentPaused
+	}
+
+	doc := h.GetOrCreateDocument(documentID)
+	applied, err := doc.ApplyRebased(op)
+	if err != nil {
+		return doc.GetVersion(), err
+	}
+	if applied == nil {
+		log.Printf("server operation on document %s made redundant by later edits", documentID)
+		return doc.GetVersion(), nil
+	}
+	if err := h.relayServerOperations(documentID, []*operations.Operation{applied}, nil, ""); err != nil {
+		return applied.Version, err
+	}
+
+	log.Printf("server operation applied to document %s: %s", documentID, applied.String())
+	return applied.Version, nil
+}
+
+// relayServerOperations reports operations the server generated and applied
+// itself, and sends them to the document's clients as ordinary operations.
+// author and traceID name the client and message that caused them, if any.
+func (h *Hub) relayServerOperations(documentID string, ops []*operations.Operation, author *Author, traceID string) error {
+	for _, op := range ops {
+		h.events.Emit(events.Event{
+			Type:       events.TypeOperationApplied,
+			DocumentID: documentID,
+			Version:    op.Version,
+			Operation:  op,
+			TraceID:    traceID,
+		})
+
+		msg := NewOperationMessage(op)
+		msg.DocumentID = documentID
+		msg.TraceID = traceID
+		msg.Author = author
+		data, err := msg.ToBytes()
+		if err != nil {
+			return fmt.Errorf("serialization failed: %w", err)
+		}
+		h.broadcastOperation(documentID, op, data, nil)
+		h.shareOperation(documentID, op, author, traceID)
+	}
+	if len(ops) > 0 {
+		h.contentChanged(documentID, ops[len(ops)-1].Version, nil)
+	}
+	return nil
+}
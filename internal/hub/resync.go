@@ -0,0 +1,72 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"log"
+)
+
+// handleResync answers a reconnecting client that last saw msg.Version. If
+// the document has moved on it sends the operations made since, its own
+// included, in an operation_batch carrying the current version, or the
+// current content and version when those operations are no longer retained
+// or the document is not text. The hub handles a client's messages in
+// order, so the reply reflects every edit the client sent before asking.
+func (h *Hub) handleResync(documentID string, doc *document.Document, msg *Message, sender *Client) {
+	if sender == nil {
+		return
+	}
+	if doc.GetVersion() == msg.Version {
+		return
+	}
+	ops, err := doc.OperationsSince(msg.Version)
+	if err != nil {
+		log.Printf("resyncing client on document %s from version %d to %d with the content: %v", documentID, msg.Version, doc.GetVersion(), err)
+		h.sendContent(documentID, doc, sender)
+		return
+	}
+	log.Printf("resyncing client on document %s from version %d with %d operations", documentID, msg.Version, len(ops))
+	reply := NewOperationBatchMessage(documentID, ops)
+	reply.Version = doc.GetVersion()
+	data, err := reply.ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(sender, data)
+}
+
+// sendContent sends a client the document's current content and version,
+// replacing whatever it holds locally.
+func (h *Hub) sendContent(documentID string, doc *document.Document, client *Client) {
+	content, version := doc.GetContentAndVersion()
+	reply := NewContentMessage(content)
+	reply.DocumentID = documentID
+	reply.Version = version
+	data, err := reply.ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(client, data)
+}
+
+// sendSync sends a client the content and version of its document, empty
+// at version zero if the document does not exist yet, so it starts from
+// the state the hub's next operations apply to. The hub sends one to each
+// live client after its welcome, and another whenever it asks with
+// sync_request.
+func (h *Hub) sendSync(client *Client) {
+	if client == nil {
+		return
+	}
+	content, version := "", 0
+	if doc := h.GetDocument(client.documentID); doc != nil {
+		content, version = doc.GetContentAndVersion()
+	}
+	data, err := NewSyncMessage(client.documentID, content, version).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.sendToClient(client, data)
+}
@@ -0,0 +1,288 @@
+package hub
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// roomQueue is how many messages a room holds for its clients before it
+// drops further ones and kicks their recipients as too slow.
+const roomQueue = 256
+
+// room is one document's share of the hub: the clients connected to it,
+// historical sessions included, and two goroutines. One applies the
+// messages for the document, transforming, applying and saving its edits;
+// the other delivers what is sent to its clients. Only looking up and
+// opening rooms goes through h.mu, so a busy document's edits and fan-out
+// run alongside other documents' rather than ahead of them, each client's
+// messages are handled in the order it sent them, and each client still
+// receives its messages in the order they were sent.
+//
+// Work that spans documents, such as registration, transactions, admin
+// calls and legacy messages, runs with h.apply held for writing, so no
+// room applies anything meanwhile; rooms hold it for reading.
+type room struct {
+	documentID string
+	clients    map[*Client]bool // guarded by hub.mu
+	pending    int              // Messages taken for the room and not yet handled; guarded by hub.mu
+	work       chan *broadcastMessage
+	closed     chan struct{} // Closed once the room has neither clients nor pending messages
+
+	mu     sync.Mutex // Guards queue, queued and busy
+	queue  []delivery
+	queued int           // Messages in queue
+	busy   bool          // Deliveries taken off queue are still being delivered
+	wake   chan struct{} // Signaled when queue is appended to
+}
+
+// delivery is one message for some of a room's clients, or a step in the
+// room's or a client's lifecycle.
+type delivery struct {
+	message []byte
+	to      []*Client
+	lenient bool          // Leave clients with full buffers be instead of kicking them
+	queued  time.Time     // When the message was queued, if fan-out is measured
+	close   *Client       // Close the client's send channel
+	reached chan struct{} // Closed once everything queued before is delivered
+	stop    bool          // End the room's delivering goroutine
+}
+
+// handle applies the messages taken for the room, one at a time, until the
+// room closes or the hub shuts down.
+func (r *room) handle(h *Hub) {
+	for {
+		select {
+		case bm := <-r.work:
+			h.handleRouted(r, bm)
+			h.mu.Lock()
+			r.pending--
+			h.closeIfIdle(r)
+			h.mu.Unlock()
+		case <-r.closed:
+			return
+		case <-h.quit:
+			return
+		}
+	}
+}
+
+// run delivers the room's queue until it is told to stop. Only run sends
+// on or closes its clients' send channels.
+func (r *room) run(h *Hub) {
+	for range r.wake {
+		r.mu.Lock()
+		batch := r.queue
+		r.queue, r.queued, r.busy = nil, 0, true
+		r.mu.Unlock()
+
+		for _, d := range batch {
+			if d.stop {
+				return
+			}
+			r.send(h, d)
+		}
+
+		r.mu.Lock()
+		r.busy = false
+		r.mu.Unlock()
+	}
+}
+
+// send carries out one delivery.
+func (r *room) send(h *Hub, d delivery) {
+	switch {
+	case d.reached != nil:
+		close(d.reached)
+	case d.close != nil:
+		if !d.close.sendClosed {
+			d.close.sendClosed = true
+			close(d.close.send)
+		}
+	default:
+		for _, c := range d.to {
+			if c.sendClosed {
+				continue
+			}
+			select {
+			case c.send <- d.message:
+			default:
+				if !d.lenient {
+					h.dropSlow(c)
+				}
+			}
+		}
+		if !d.queued.IsZero() {
+			h.metrics.fanout.Observe(h.clock.Now().Sub(d.queued).Seconds())
+		}
+	}
+}
+
+// dropSlow kicks a client that cannot keep up with its messages.
+func (h *Hub) dropSlow(c *Client) {
+	if c.ctx == nil || c.ctx.Err() == nil {
+		h.metrics.dropped.Inc() // Once, not for every message it misses
+	}
+	h.kick(c, ErrSlowClient)
+	log.Printf("client marked for removal due to full send buffer")
+}
+
+// openRoom returns documentID's room, opening it if there is none. It
+// returns nil if the hub is stopping and the room cannot run. Callers must
+// hold h.mu.
+func (h *Hub) openRoom(documentID string) *room {
+	if r := h.rooms[documentID]; r != nil {
+		return r
+	}
+	r := &room{
+		documentID: documentID,
+		clients:    make(map[*Client]bool),
+		work:       make(chan *broadcastMessage),
+		closed:     make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+	if !h.spawn(RoutineRoom, func() { r.run(h) }) {
+		return nil
+	}
+	if !h.spawn(RoutineApply, func() { r.handle(h) }) {
+		h.enqueue(r, delivery{stop: true})
+		return nil
+	}
+	h.rooms[documentID] = r
+	return r
+}
+
+// join adds a registering client to its document's room, opening the room
+// if it is the first. It reports false if the hub is stopping and the room
+// cannot run. Callers must hold h.mu.
+func (h *Hub) join(client *Client) bool {
+	r := h.openRoom(client.documentID)
+	if r == nil {
+		return false
+	}
+	r.clients[client] = true
+	client.room = r
+	return true
+}
+
+// leave removes a client from its room once the messages queued for it are
+// delivered, closing its send channel, and closes the room if it was the
+// last and no message for it is pending. Callers must hold h.mu.
+func (h *Hub) leave(client *Client) {
+	r := client.room
+	if r == nil {
+		return
+	}
+	delete(r.clients, client)
+	h.enqueue(r, delivery{close: client})
+	h.closeIfIdle(r)
+}
+
+// closeIfIdle closes r if it has neither clients nor pending messages, so
+// the next message or client opens a new one. Callers must hold h.mu.
+func (h *Hub) closeIfIdle(r *room) {
+	if len(r.clients) > 0 || r.pending > 0 || h.rooms[r.documentID] != r {
+		return
+	}
+	delete(h.rooms, r.documentID)
+	close(r.closed)
+	h.enqueue(r, delivery{stop: true})
+}
+
+// take reserves documentID's room for a message, opening it if need be, so
+// it stays open until the message is handled. It returns nil if the hub is
+// stopping.
+func (h *Hub) take(documentID string) *room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := h.openRoom(documentID)
+	if r != nil {
+		r.pending++
+	}
+	return r
+}
+
+// release gives up a reservation made with take for a message that was not
+// handed to the room.
+func (h *Hub) release(r *room) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r.pending--
+	h.closeIfIdle(r)
+}
+
+// enqueue queues d with r without waiting. Once roomQueue messages are
+// waiting, further ones are dropped and their recipients kicked as too
+// slow, unless d is lenient; lifecycle steps are always queued. Callers
+// must hold h.mu.
+func (h *Hub) enqueue(r *room, d delivery) {
+	if d.message != nil && h.metrics.fanout != nil {
+		d.queued = h.clock.Now()
+	}
+	r.mu.Lock()
+	if d.message != nil && r.queued >= roomQueue {
+		r.mu.Unlock()
+		if !d.lenient {
+			log.Printf("room for document %s is %d messages behind, dropping a message", r.documentID, roomQueue)
+			for _, c := range d.to {
+				h.dropSlow(c)
+			}
+		}
+		return
+	}
+	r.queue = append(r.queue, d)
+	if d.message != nil {
+		r.queued++
+	}
+	r.mu.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default: // Already signaled
+	}
+}
+
+// deliver queues message for clients of r, kicking any whose buffer is
+// full. Callers must hold h.mu.
+func (h *Hub) deliver(r *room, message []byte, to ...*Client) {
+	if len(to) > 0 {
+		h.enqueue(r, delivery{message: message, to: to})
+	}
+}
+
+// settle waits until the rooms have delivered everything queued with them
+// so far.
+func (h *Hub) settle() {
+	var reached []chan struct{}
+	h.mu.RLock()
+	for _, r := range h.rooms {
+		r.mu.Lock()
+		behind := len(r.queue) > 0 || r.busy
+		r.mu.Unlock()
+		if !behind {
+			continue
+		}
+		ch := make(chan struct{})
+		h.enqueue(r, delivery{reached: ch})
+		reached = append(reached, ch)
+	}
+	h.mu.RUnlock()
+	for _, ch := range reached {
+		<-ch
+	}
+}
+
+// roomClients returns the clients of documentID's room for which keep
+// returns true. Callers must hold h.mu.
+func (h *Hub) roomClients(documentID string, keep func(*Client) bool) []*Client {
+	r := h.rooms[documentID]
+	if r == nil {
+		return nil
+	}
+	clients := make([]*Client, 0, len(r.clients))
+	for c := range r.clients {
+		if keep(c) {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
@@ -0,0 +1,44 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/sanitize"
+	"log"
+)
+
+// SetSanitizer sets how text inserted into a document, by operations or by
+// setting its content, is cleaned before it is applied. The zero Policy
+// turns cleaning off.
+func (h *Hub) SetSanitizer(documentID string, p sanitize.Policy) error {
+	var err error
+	if !h.do(func() { err = h.setSanitizer(documentID, p) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) setSanitizer(documentID string, p sanitize.Policy) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+	h.GetOrCreateDocument(documentID).SetSanitizer(p)
+	log.Printf("document %s sanitizer set to %+v", documentID, p)
+	return nil
+}
+
+// Sanitizer returns how text inserted into a document is cleaned.
+func (h *Hub) Sanitizer(documentID string) sanitize.Policy {
+	if doc := h.GetDocument(documentID); doc != nil {
+		return doc.Sanitizer()
+	}
+	return sanitize.Policy{}
+}
+
+// sanitizeOperation cleans the text of an insert operation in place.
+// Deletes are left alone: their text must match what the document holds.
+func (h *Hub) sanitizeOperation(doc *document.Document, op *operations.Operation) {
+	if op.Type == operations.OpInsert {
+		op.Text = doc.Sanitizer().Text(op.Text)
+	}
+}
@@ -0,0 +1,223 @@
+package hub
+
+import (
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// scheduleInterval is how often the scheduler checks for due actions, and
+// so how late an action may run.
+const scheduleInterval = time.Second
+
+// ErrUnknownAction is returned when scheduling an action the hub does not
+// know.
+var ErrUnknownAction = errors.New("unknown action")
+
+// ScheduleAction stores an action to run on a document at a.At and returns
+// it with its ID. Actions are kept with the document, so they survive
+// restarts when the hub has a store, and run within a second of being due;
+// one due already runs at once. A lock pauses edits as PauseDocument does,
+// with a.Reason shown to clients, unlock resumes them, publish publishes
+// the content as of then, and expire_invite makes the invite whose token is
+// a.Invite expire. When an action runs, the document's clients receive a
+// schedule_fired message and a schedule_fired event is emitted.
+func (h *Hub) ScheduleAction(documentID string, a document.ScheduledAction) (document.ScheduledAction, error) {
+	if !a.Action.Valid() {
+		return a, fmt.Errorf("%w %q", ErrUnknownAction, a.Action)
+	}
+	if a.At.IsZero() {
+		return a, fmt.Errorf("scheduled time is required")
+	}
+	a.ID = newToken()
+	var err error
+	if !h.do(func() { err = h.scheduleAction(documentID, a) }) {
+		return a, ErrHubStopped
+	}
+	return a, err
+}
+
+func (h *Hub) scheduleAction(documentID string, a document.ScheduledAction) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return ErrDocumentNotFound
+	}
+	if a.Action == document.ActionExpireInvite && !doc.HasInvite(a.Invite) {
+		return document.ErrInviteNotFound
+	}
+	if err := doc.Schedule(a); err != nil {
+		return err
+	}
+	log.Printf("%s scheduled on document %s for %s", a.Action, documentID, a.At.Format(time.RFC3339))
+	h.track(documentID, doc)
+	return nil
+}
+
+// CancelScheduled removes an action that has not run yet.
+func (h *Hub) CancelScheduled(documentID, id string) error {
+	var err error
+	if !h.do(func() {
+		doc := h.GetDocument(documentID)
+		if doc == nil || h.IsDeleted(documentID) {
+			err = ErrDocumentNotFound
+			return
+		}
+		if err = doc.Unschedule(id); err == nil {
+			h.track(documentID, doc)
+		}
+	}) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+// ScheduledActions returns the actions waiting on a document, soonest
+// first.
+func (h *Hub) ScheduledActions(documentID string) []document.ScheduledAction {
+	doc := h.GetDocument(documentID)
+	if doc == nil || h.IsDeleted(documentID) {
+		return nil
+	}
+	return doc.Scheduled()
+}
+
+// track records when the document's next action is due. Callers must be
+// on the Run goroutine.
+func (h *Hub) track(documentID string, doc *document.Document) {
+	if next, ok := doc.NextScheduled(); ok {
+		h.dueAt(documentID, next)
+	} else {
+		delete(h.due, documentID)
+	}
+}
+
+// dueAt records that the document has an action due at, and starts the
+// scheduler if it is not running. Callers must be on the Run goroutine.
+func (h *Hub) dueAt(documentID string, at time.Time) {
+	h.due[documentID] = at
+	if h.scheduling {
+		return
+	}
+	ticker := h.clock.NewTicker(scheduleInterval)
+	if !h.spawn(RoutineScheduler, func() { h.schedule(ticker) }) {
+		ticker.Stop()
+		return
+	}
+	h.scheduling = true
+}
+
+// schedule runs due actions on each tick until none is waiting.
+func (h *Hub) schedule(ticker clock.Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-ticker.C():
+			waiting := false
+			if !h.do(func() { waiting = h.runDue() }) || !waiting {
+				return
+			}
+		}
+	}
+}
+
+// runDue runs the actions due on every document and reports whether any
+// are still waiting; if not, the scheduler stops. Callers must be on the
+// Run goroutine.
+func (h *Hub) runDue() bool {
+	now := h.clock.Now()
+	for documentID, at := range h.due {
+		if at.After(now) {
+			continue
+		}
+		delete(h.due, documentID)
+		doc := h.GetDocument(documentID)
+		if doc == nil || h.IsDeleted(documentID) {
+			continue
+		}
+		for _, a := range doc.TakeDue(now) {
+			h.runScheduled(documentID, doc, a)
+		}
+		if next, ok := doc.NextScheduled(); ok {
+			h.due[documentID] = next
+		}
+	}
+	h.scheduling = len(h.due) > 0
+	return h.scheduling
+}
+
+// runScheduled runs one action and reports it to the document's clients
+// and the event sinks.
+func (h *Hub) runScheduled(documentID string, doc *document.Document, a document.ScheduledAction) {
+	var err error
+	switch a.Action {
+	case document.ActionLock:
+		reason := a.Reason
+		if reason == "" {
+			reason = "scheduled lock"
+		}
+		err = h.pauseDocument(documentID, PauseOptions{Reason: reason, RetryAfter: defaultRetryAfter})
+	case document.ActionUnlock:
+		err = h.resumeDocument(documentID)
+	case document.ActionPublish:
+		content, version := doc.GetContentAndVersion()
+		err = h.publish(documentID, document.Publication{Version: version, Content: content})
+	case document.ActionExpireInvite:
+		err = doc.ExpireInvite(a.Invite, h.clock.Now())
+	}
+
+	msg := &Message{Type: MsgTypeScheduleFired, DocumentID: documentID, Schedule: &a}
+	if err != nil {
+		log.Printf("scheduled %s on document %s failed: %v", a.Action, documentID, err)
+		msg.Reason = err.Error()
+	} else {
+		log.Printf("scheduled %s ran on document %s", a.Action, documentID)
+	}
+	if data, err := msg.ToBytes(); err == nil {
+		h.broadcastToDocument(documentID, data, nil)
+	}
+	h.events.Emit(events.Event{
+		Type:       events.TypeScheduleFired,
+		DocumentID: documentID,
+		Detail:     string(a.Action),
+	})
+}
+
+// indexSchedules finds the actions waiting on stored documents when the
+// hub starts, without keeping the documents loaded until they are due.
+func (h *Hub) indexSchedules() {
+	p := h.persistence
+	ids, err := p.store.List()
+	if err != nil {
+		log.Printf("listing stored documents for scheduled actions failed: %v", err)
+		return
+	}
+	found := make(map[string]time.Time)
+	for _, id := range ids {
+		snap, err := p.store.Load(id)
+		if err != nil || len(snap.Scheduled) == 0 {
+			continue
+		}
+		found[id] = snap.Scheduled[0].At
+	}
+	if len(found) == 0 {
+		return
+	}
+
+	h.do(func() {
+		for id, at := range found {
+			if _, ok := h.due[id]; !ok {
+				h.dueAt(id, at) // Unless loaded and tracked meanwhile
+			}
+		}
+	})
+	log.Printf("found scheduled actions on %d stored documents", len(found))
+}
@@ -0,0 +1,30 @@
+package hub
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// stamp prefixes an outbound JSON message with the server time and, if the
+// document is loaded, its current version, so clients can detect clock
+// skew, order messages and show when the document was last saved. Fields
+// the message already carries come later in the object and take
+// precedence. Non-JSON (legacy) messages are returned unchanged. Callers
+// must hold h.mu.
+func (h *Hub) stamp(data []byte, documentID string) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+
+	buf := make([]byte, 0, len(data)+48)
+	buf = append(buf, `{"server_time":`...)
+	buf = strconv.AppendInt(buf, h.clock.Now().UnixMilli(), 10)
+	if doc, ok := h.documents[documentID]; ok {
+		buf = append(buf, `,"version":`...)
+		buf = strconv.AppendInt(buf, int64(doc.GetVersion()), 10)
+	}
+	if rest := bytes.TrimSpace(data[1:]); len(rest) > 0 && rest[0] != '}' {
+		buf = append(buf, ',')
+	}
+	return append(buf, data[1:]...)
+}
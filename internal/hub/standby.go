@@ -0,0 +1,86 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/operations"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// ErrReplicaBehind is returned by ApplyReplicated when the standby's copy
+// of a document is missing or older than the operation's base version, so
+// it must be sent the document again.
+var ErrReplicaBehind = errors.New("replica behind primary")
+
+// LoadedDocumentIDs returns the IDs of the documents in memory, in sorted
+// order. Unlike DocumentIDs, documents only in the store are left out.
+func (h *Hub) LoadedDocumentIDs() []string {
+	h.mu.RLock()
+	ids := make([]string, 0, len(h.documents))
+	for id := range h.documents {
+		ids = append(ids, id)
+	}
+	h.mu.RUnlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// InstallReplica replaces a document with a copy of a primary's snapshot,
+// clearing any tombstone, so a standby holds the primary's state. A
+// standby serves no clients, so none are told.
+func (h *Hub) InstallReplica(documentID string, snap *document.Snapshot) {
+	doc := document.Restore(snap, h.clock)
+	h.mu.Lock()
+	if s := h.schemaFor(documentID); s != nil {
+		if err := doc.SetSchema(s); err != nil {
+			log.Printf("replicated document %s does not satisfy its schema: %v", documentID, err)
+		}
+	}
+	delete(h.tombstones, documentID)
+	h.documents[documentID] = doc
+	h.mu.Unlock()
+	if p := h.persistence; p != nil {
+		p.mu.Lock()
+		delete(p.saved, documentID) // save the new copy on the next flush
+		delete(p.broken, documentID)
+		p.mu.Unlock()
+	}
+
+	h.events.Emit(events.Event{
+		Type:       events.TypeContentSet,
+		DocumentID: documentID,
+		Version:    snap.Version,
+	})
+}
+
+// ApplyReplicated applies an operation a primary applied as version to a
+// standby's copy of the document. Versions the copy already has are
+// skipped; a gap returns ErrReplicaBehind.
+func (h *Hub) ApplyReplicated(documentID string, op *operations.Operation, version int) error {
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return fmt.Errorf("%w: document %s not replicated", ErrReplicaBehind, documentID)
+	}
+	current := doc.GetVersion()
+	if version <= current {
+		return nil
+	}
+	if version != current+1 {
+		return fmt.Errorf("%w: document %s at version %d, operation makes %d", ErrReplicaBehind, documentID, current, version)
+	}
+
+	c := *op
+	if _, _, err := doc.ApplyOperation(&c); err != nil {
+		return err
+	}
+	h.events.Emit(events.Event{
+		Type:       events.TypeOperationApplied,
+		DocumentID: documentID,
+		Version:    version,
+		Operation:  &c,
+	})
+	return nil
+}
@@ -0,0 +1,71 @@
+package hub
+
+import (
+	"sort"
+	"time"
+
+	"collaborative-docs/internal/document"
+)
+
+// DocumentStats summarizes one loaded document.
+type DocumentStats struct {
+	ID           string    `json:"id"`
+	Version      int       `json:"version"`
+	Length       int       `json:"length"`
+	MemoryBytes  int       `json:"memory_bytes"`
+	Clients      int       `json:"clients"`
+	Coalesced    int       `json:"coalesced_clients"` // Clients receiving batched operations
+	LastModified time.Time `json:"last_modified"`
+	Private      bool      `json:"private,omitempty"` // Visible only to admins and invited collaborators
+}
+
+// Stats summarizes the hub's connections and loaded documents.
+type Stats struct {
+	Clients          int             `json:"clients"`
+	TotalMemoryBytes int             `json:"total_memory_bytes"`
+	Documents        []DocumentStats `json:"documents"` // heaviest first
+}
+
+// Stats returns per-document statistics ordered by approximate memory use,
+// heaviest first, so eviction can target the most expensive documents.
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	clients := make(map[string]int)
+	coalesced := make(map[string]int)
+	for c := range h.clients {
+		clients[c.documentID]++
+		if c.cadence != nil {
+			coalesced[c.documentID]++
+		}
+	}
+	docs := make([]DocumentStats, 0, len(h.documents))
+	for id, doc := range h.documents {
+		version, lastModified, length := doc.GetStats()
+		visibility, _ := doc.Visibility()
+		docs = append(docs, DocumentStats{
+			ID:           id,
+			Version:      version,
+			Length:       length,
+			MemoryBytes:  doc.MemoryUsage(),
+			Clients:      clients[id],
+			Coalesced:    coalesced[id],
+			LastModified: lastModified,
+			Private:      visibility == document.VisibilityPrivate,
+		})
+	}
+	total := len(h.clients)
+	h.mu.RUnlock()
+
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].MemoryBytes != docs[j].MemoryBytes {
+			return docs[i].MemoryBytes > docs[j].MemoryBytes
+		}
+		return docs[i].ID < docs[j].ID
+	})
+
+	stats := Stats{Clients: total, Documents: docs}
+	for _, d := range docs {
+		stats.TotalMemoryBytes += d.MemoryBytes
+	}
+	return stats
+}
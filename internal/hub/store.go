@@ -0,0 +1,238 @@
+package hub
+
+import (
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/document"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultFlushInterval is how long changes to a document may wait before
+// they are saved to the store.
+const DefaultFlushInterval = 2 * time.Second
+
+// maxLogEntries is how many saves are appended to a document's operation
+// log before it is started again from a snapshot, bounding what a rebuild
+// replays.
+const maxLogEntries = 1000
+
+// persistence tracks what the hub has saved to its store.
+type persistence struct {
+	store    document.Store
+	interval time.Duration
+	mu       sync.Mutex        // Serializes saves and deletes
+	saved    map[string]uint64 // Change count of each document when last saved or loaded
+	broken   map[string]bool   // Documents whose stored copy failed to load, never saved over
+	logged   map[string]logPosition
+}
+
+// logPosition is where a document's operation log ends: the version
+// last logged, the checksum of its content and how many entries follow
+// the log's base.
+type logPosition struct {
+	version  int
+	checksum string
+	entries  int
+}
+
+// SetStore makes the hub persist documents in s. Documents are loaded from
+// it on first use, changed ones are saved at most once per interval, so a
+// burst of edits is written once, and all changes are saved at Shutdown.
+// Deleted documents are removed from it, and under memory pressure idle
+// documents are saved and unloaded instead of evicted. If s is also a
+// document.OpLog, each save appends the operations since the last one to
+// it, so a corrupt document can be rebuilt; see Rebuild. If s is also a
+// document.TeamStore, teams are loaded from it and kept in it. An
+// interval of zero uses DefaultFlushInterval. It must be called before
+// Run.
+func (h *Hub) SetStore(s document.Store, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	h.persistence = &persistence{
+		store:    s,
+		interval: interval,
+		saved:    make(map[string]uint64),
+		broken:   make(map[string]bool),
+		logged:   make(map[string]logPosition),
+	}
+	h.loadTeams(s)
+}
+
+// loadDocument reads a document from the store, returning nil if there is
+// no store or the document is not stored. A document that fails to load,
+// or whose content does not match its checksum, is logged and treated as
+// missing, but the stored copy is never replaced until it is rebuilt.
+// Callers must not hold h.mu.
+func (h *Hub) loadDocument(documentID string) *document.Document {
+	p := h.persistence
+	if p == nil {
+		return nil
+	}
+	snap, err := p.store.Load(documentID)
+	if errors.Is(err, document.ErrNotStored) {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		err = snap.Verify()
+	}
+	if err != nil {
+		log.Printf("loading document %s failed, it will not be saved until rebuilt: %v", documentID, err)
+		p.broken[documentID] = true
+		return nil
+	}
+	doc := document.Restore(snap, h.clock)
+	p.saved[documentID] = doc.Changes()
+	return doc
+}
+
+// persist saves changed documents on each tick until the hub shuts down.
+func (h *Hub) persist(ticker clock.Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.quit:
+			return
+		case <-ticker.C():
+			h.flush()
+		}
+	}
+}
+
+// flush saves every loaded document changed since it was last saved, and
+// reports whether all saves succeeded.
+func (h *Hub) flush() bool {
+	p := h.persistence
+	if p == nil {
+		return true
+	}
+	h.mu.RLock()
+	docs := make(map[string]*document.Document, len(h.documents))
+	for id, doc := range h.documents {
+		docs[id] = doc
+	}
+	h.mu.RUnlock()
+
+	ok := true
+	for id, doc := range docs {
+		// One save at a time, so rooms saving as they compact wait for
+		// that save rather than the whole flush.
+		p.mu.Lock()
+		if !h.save(id, doc) {
+			ok = false
+		}
+		p.mu.Unlock()
+	}
+	return ok
+}
+
+// save writes doc to the store if it changed since last saved, and
+// reports whether the store is up to date. Callers must hold
+// h.persistence.mu.
+func (h *Hub) save(documentID string, doc *document.Document) bool {
+	p := h.persistence
+	if p.broken[documentID] {
+		return false
+	}
+	if saved, ok := p.saved[documentID]; ok && saved == doc.Changes() {
+		return true
+	}
+	snap, changes := doc.Snapshot()
+	if oplog, ok := p.store.(document.OpLog); ok {
+		h.logChanges(oplog, documentID, doc, snap)
+	}
+	if err := p.store.Save(documentID, snap); err != nil {
+		log.Printf("saving document %s failed: %v", documentID, err)
+		return false
+	}
+	p.saved[documentID] = changes
+	return true
+}
+
+// logChanges appends the operations that took doc to snap since it was
+// last logged to oplog, or starts the log again at snap when they cannot
+// be: on the first save after loading, when the history no longer reaches
+// back far enough, when the logged content was rewritten since, as by a
+// redaction, or when the log is long enough. Callers must hold
+// h.persistence.mu.
+func (h *Hub) logChanges(oplog document.OpLog, documentID string, doc *document.Document, snap *document.Snapshot) {
+	p := h.persistence
+	last, ok := p.logged[documentID]
+	if ok && last.version == snap.Version && last.checksum == snap.Checksum {
+		return
+	}
+	if ok && last.entries < maxLogEntries {
+		content, err := doc.ContentAt(last.version)
+		if err == nil && document.Checksum(content) == last.checksum {
+			e, err := doc.LogEntry(last.version, snap.Version)
+			if err == nil {
+				err = oplog.AppendLog(documentID, e)
+			}
+			if err == nil {
+				p.logged[documentID] = logPosition{version: e.To, checksum: e.Checksum, entries: last.entries + 1}
+				return
+			}
+			if !errors.Is(err, document.ErrVersionUnavailable) {
+				log.Printf("logging operations of document %s failed: %v", documentID, err)
+			}
+		}
+	}
+	if err := oplog.StartLog(documentID, snap); err != nil {
+		log.Printf("starting operation log of document %s failed: %v", documentID, err)
+		delete(p.logged, documentID)
+		return
+	}
+	p.logged[documentID] = logPosition{version: snap.Version, checksum: snap.Checksum}
+}
+
+// forgetStored removes a deleted document from the store. The document
+// must already be gone from h.documents, so no flush saves it again.
+func (h *Hub) forgetStored(documentID string) {
+	p := h.persistence
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.saved, documentID)
+	delete(p.broken, documentID)
+	delete(p.logged, documentID)
+	if err := p.store.Delete(documentID); err != nil {
+		log.Printf("deleting stored document %s failed: %v", documentID, err)
+	}
+}
+
+// unload saves an idle document and drops it from memory, to be loaded
+// again on next use. It reports false, keeping the document, if it has
+// clients, changed during the save, or could not be saved.
+func (h *Hub) unload(documentID string) bool {
+	p := h.persistence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h.mu.RLock()
+	doc := h.documents[documentID]
+	h.mu.RUnlock()
+	if doc == nil || !h.save(documentID, doc) {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hasClients(documentID) {
+		return false
+	}
+	if h.documents[documentID] != doc || doc.Changes() != p.saved[documentID] {
+		return false
+	}
+	delete(h.documents, documentID)
+	delete(h.outlines, documentID)
+	delete(h.diagnostics, documentID)
+	delete(p.saved, documentID)
+	delete(p.logged, documentID)
+	return true
+}
@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"log"
+	"sync"
+)
+
+// subscriberBuffer is the channel capacity for each in-process subscriber.
+// Events are dropped for subscribers that fall this far behind.
+const subscriberBuffer = 64
+
+// subscribers tracks in-process listeners for per-document events.
+type subscribers[T any] struct {
+	subs map[string]map[chan T]struct{}
+	mu   sync.RWMutex
+}
+
+// newSubscribers creates an empty subscriber registry.
+func newSubscribers[T any]() subscribers[T] {
+	return subscribers[T]{subs: make(map[string]map[chan T]struct{})}
+}
+
+// subscribe registers a listener for a document. The returned cancel
+// function unsubscribes and closes the channel.
+func (s *subscribers[T]) subscribe(documentID string) (<-chan T, func()) {
+	ch := make(chan T, subscriberBuffer)
+
+	s.mu.Lock()
+	if s.subs[documentID] == nil {
+		s.subs[documentID] = make(map[chan T]struct{})
+	}
+	s.subs[documentID][ch] = struct{}{}
+	s.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subs[documentID], ch)
+			if len(s.subs[documentID]) == 0 {
+				delete(s.subs, documentID)
+			}
+			s.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// publish delivers an event to every subscriber of the document without
+// blocking the caller.
+func (s *subscribers[T]) publish(documentID string, event T) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for ch := range s.subs[documentID] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("subscriber for document %s is full, dropping event", documentID)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+)
+
+var (
+	// ErrTeamNotFound is returned for a team that does not exist.
+	ErrTeamNotFound = errors.New("team not found")
+	// ErrTeamExists is returned by CreateTeam for a team that exists.
+	ErrTeamExists = errors.New("team already exists")
+)
+
+// loadTeams reads the teams kept in a store that keeps them. Callers must
+// not hold h.mu.
+func (h *Hub) loadTeams(s document.Store) {
+	ts, ok := s.(document.TeamStore)
+	if !ok {
+		return
+	}
+	teams, err := ts.LoadTeams()
+	if err != nil {
+		log.Printf("loading teams failed: %v", err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range teams {
+		h.teams[t.ID] = t.Clone()
+	}
+}
+
+// CreateTeam adds a team without members. With a store that keeps teams
+// they survive restarts; otherwise they live as long as the hub.
+func (h *Hub) CreateTeam(teamID, name string) error {
+	if teamID == "" {
+		return fmt.Errorf("team ID is required")
+	}
+	var err error
+	if !h.do(func() {
+		h.mu.Lock()
+		if _, ok := h.teams[teamID]; ok {
+			h.mu.Unlock()
+			err = fmt.Errorf("%w: %s", ErrTeamExists, teamID)
+			return
+		}
+		t := &document.Team{ID: teamID, Name: name, Members: make(map[string]document.Role)}
+		h.teams[teamID] = t
+		h.mu.Unlock()
+		h.saveTeam(t)
+		h.teamChanged(teamID)
+	}) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+// SetTeamMembers gives users, by ID, a role in a team, which they hold on
+// every document the team owns; an empty role removes the member. Either
+// every change applies or, if any is invalid, none does. Like SetRoles it
+// is checked when sessions connect; sessions already open are not
+// affected.
+func (h *Hub) SetTeamMembers(teamID string, members map[string]document.Role) error {
+	for userID, role := range members {
+		if userID == "" {
+			return fmt.Errorf("user ID is required")
+		}
+		if err := checkRole(role); err != nil {
+			return err
+		}
+	}
+	var err error
+	if !h.do(func() {
+		h.mu.Lock()
+		t, ok := h.teams[teamID]
+		if !ok {
+			h.mu.Unlock()
+			err = fmt.Errorf("%w: %s", ErrTeamNotFound, teamID)
+			return
+		}
+		t = t.Clone() // readers of the old copy are not raced
+		for userID, role := range members {
+			if role == "" {
+				delete(t.Members, userID)
+			} else {
+				t.Members[userID] = role
+			}
+		}
+		h.teams[teamID] = t
+		h.mu.Unlock()
+		h.saveTeam(t)
+		h.teamChanged(teamID)
+	}) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+// DeleteTeam removes a team. Documents it owned keep its ID, and their
+// other access, but no longer give its former members any role; a team
+// later created with the same ID owns them again.
+func (h *Hub) DeleteTeam(teamID string) error {
+	var err error
+	if !h.do(func() {
+		h.mu.Lock()
+		_, ok := h.teams[teamID]
+		delete(h.teams, teamID)
+		h.mu.Unlock()
+		if !ok {
+			err = fmt.Errorf("%w: %s", ErrTeamNotFound, teamID)
+			return
+		}
+		if ts, ok := h.teamStore(); ok {
+			if err := ts.DeleteTeam(teamID); err != nil {
+				log.Printf("deleting stored team %s failed: %v", teamID, err)
+			}
+		}
+		h.teamChanged(teamID)
+	}) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+// Team returns a copy of a team, or nil if there is none.
+func (h *Hub) Team(teamID string) *document.Team {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if t, ok := h.teams[teamID]; ok {
+		return t.Clone()
+	}
+	return nil
+}
+
+// Teams returns copies of every team, sorted by ID.
+func (h *Hub) Teams() []*document.Team {
+	h.mu.RLock()
+	teams := make([]*document.Team, 0, len(h.teams))
+	for _, t := range h.teams {
+		teams = append(teams, t.Clone())
+	}
+	h.mu.RUnlock()
+	sort.Slice(teams, func(i, j int) bool { return teams[i].ID < teams[j].ID })
+	return teams
+}
+
+// TeamRole returns a user's role in a team and whether the user is a
+// member; a user is a member of no team "".
+func (h *Hub) TeamRole(teamID, userID string) (document.Role, bool) {
+	if teamID == "" || userID == "" {
+		return "", false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	t, ok := h.teams[teamID]
+	if !ok {
+		return "", false
+	}
+	role, ok := t.Members[userID]
+	return role, ok
+}
+
+// SetTeam makes a team the owner of a document, so its members get their
+// team role on it whatever its visibility; "" leaves it without one. Each
+// member of the previous and the new team emits an access_changed event.
+// Sessions already open are not affected.
+func (h *Hub) SetTeam(documentID, teamID string) error {
+	var err error
+	if !h.do(func() {
+		if teamID != "" && h.Team(teamID) == nil {
+			err = fmt.Errorf("%w: %s", ErrTeamNotFound, teamID)
+			return
+		}
+		err = h.setAccess(documentID, func(doc *document.Document) []string {
+			users := h.teamMembers(doc.Team())
+			doc.SetTeam(teamID)
+			users = append(users, h.teamMembers(teamID)...)
+			sort.Strings(users)
+			return slices.Compact(users)
+		})
+	}) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+// teamMembers returns the IDs of a team's members, or none if there is no
+// such team.
+func (h *Hub) teamMembers(teamID string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	t, ok := h.teams[teamID]
+	if !ok {
+		return nil
+	}
+	users := make([]string, 0, len(t.Members))
+	for userID := range t.Members {
+		users = append(users, userID)
+	}
+	return users
+}
+
+// teamStore returns the hub's store if it keeps teams.
+func (h *Hub) teamStore() (document.TeamStore, bool) {
+	if h.persistence == nil {
+		return nil, false
+	}
+	ts, ok := h.persistence.store.(document.TeamStore)
+	return ts, ok
+}
+
+// saveTeam writes a team to the store, if it keeps teams.
+func (h *Hub) saveTeam(t *document.Team) {
+	if ts, ok := h.teamStore(); ok {
+		if err := ts.SaveTeam(t); err != nil {
+			log.Printf("saving team %s failed: %v", t.ID, err)
+		}
+	}
+}
+
+// teamChanged reports a change to a team's members or roles.
+func (h *Hub) teamChanged(teamID string) {
+	log.Printf("team %s changed", teamID)
+	h.events.Emit(events.Event{Type: events.TypeTeamChanged, Detail: teamID})
+}
@@ -0,0 +1,45 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// maxTraceIDLength bounds a trace ID chosen by the client.
+const maxTraceIDLength = 64
+
+// trace gives msg, parsed from bm, a trace ID as the hub handles it,
+// keeping one the client chose if it is safe to log, so the message can be
+// followed through the hub's logs, the replies to its sender, the copies
+// its collaborators receive and the events it causes. A message queued
+// while its document is paused keeps its ID when handled again.
+func (bm *broadcastMessage) trace(msg *Message) {
+	if bm.traceID == "" {
+		bm.traceID = msg.TraceID
+		if !validTraceID(bm.traceID) {
+			bm.traceID = newTraceID()
+		}
+	}
+	msg.TraceID = bm.traceID
+}
+
+// newTraceID returns a random trace ID.
+func newTraceID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validTraceID reports whether id is a usable trace ID: letters, digits and
+// the separators common in tracing systems, so it cannot forge log lines.
+func validTraceID(id string) bool {
+	if id == "" || len(id) > maxTraceIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.' || r == ':') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// maxTransactionOperations bounds how many operations one transaction may
+// carry.
+const maxTransactionOperations = 100
+
+// ErrNotShared is returned when a transaction names a document its sender
+// may not be trusted to edit.
+var ErrNotShared = errors.New("document is not shared openly")
+
+// Transaction groups text operations on several documents that must apply
+// together or not at all, such as moving a section from one document to
+// another. Operations apply in order; several may target one document.
+type Transaction struct {
+	ID         string                  `json:"id,omitempty"` // Echoed in the reply
+	Operations []*TransactionOperation `json:"operations"`
+}
+
+// TransactionOperation is one operation of a transaction.
+type TransactionOperation struct {
+	DocumentID string                `json:"document_id"`
+	Operation  *operations.Operation `json:"operation"`
+}
+
+// handleTransaction applies a transaction and answers the sender with
+// transaction_committed, carrying the version each operation produced, or
+// transaction_aborted. Collaborators receive the operations only once all
+// of them have applied, so they never see part of a transaction; this
+// includes the sender, which should not apply them optimistically.
+func (h *Hub) handleTransaction(msg *Message, sender *Client) {
+	tx := msg.Transaction
+	reply := &Message{Type: MsgTypeTransactionCommitted, DocumentID: msg.DocumentID, Transaction: tx, TraceID: msg.TraceID}
+	if err := h.applyTransaction(tx, sender, msg.TraceID); err != nil {
+		log.Printf("transaction aborted: %v (trace %s)", err, msg.TraceID)
+		h.noteRejected(sender)
+		reply.Type = MsgTypeTransactionAborted
+		reply.Reason = err.Error()
+		errors.As(err, &reply.Violation)
+		reply.Transaction = nil
+		if tx != nil {
+			reply.Transaction = &Transaction{ID: tx.ID}
+		}
+	}
+	if sender == nil {
+		return
+	}
+	if data, err := reply.ToBytes(); err == nil {
+		h.sendToClient(sender, data)
+	}
+}
+
+// applyTransaction runs the two phases of a transaction. The first checks
+// that every document accepts edits before any is touched; the second
+// applies the operations, rolling every document back to its prior version
+// if one fails. Operations are relayed only after all of them applied,
+// carrying traceID.
+func (h *Hub) applyTransaction(tx *Transaction, sender *Client, traceID string) error {
+	if tx == nil || len(tx.Operations) == 0 {
+		return errors.New("empty transaction")
+	}
+	if len(tx.Operations) > maxTransactionOperations {
+		return fmt.Errorf("transaction has %d operations, limit is %d", len(tx.Operations), maxTransactionOperations)
+	}
+
+	for i, top := range tx.Operations {
+		switch {
+		case top == nil || top.DocumentID == "" || top.Operation == nil:
+			return fmt.Errorf("operation %d: document ID and operation are required", i)
+		case h.tombstoneFor(top.DocumentID) != nil:
+			return fmt.Errorf("document %s: %w", top.DocumentID, ErrDocumentDeleted)
+		case h.isPaused(top.DocumentID):
+			return fmt.Errorf("document %s: %w", top.DocumentID, ErrDocumentPaused)
+		case !h.mayTransact(sender, top.DocumentID):
+			return fmt.Errorf("document %s: %w", top.DocumentID, ErrNotShared)
+		}
+	}
+
+	before := make(map[string]int)
+	for i, top := range tx.Operations {
+		doc := h.GetOrCreateDocument(top.DocumentID)
+		if _, ok := before[top.DocumentID]; !ok {
+			before[top.DocumentID] = doc.GetVersion()
+		}
+		h.sanitizeOperation(doc, top.Operation)
+		var version int
+		var err error
+		if !sender.mayEditFences() {
+			err = doc.CheckFences(top.Operation)
+		}
+		if err == nil {
+			_, version, err = doc.ApplyOperation(top.Operation)
+		}
+		if err != nil {
+			h.rollbackTransaction(before)
+			return fmt.Errorf("operation %d on document %s: %w", i, top.DocumentID, err)
+		}
+		top.Operation.Version = version
+	}
+
+	for _, top := range tx.Operations {
+		if err := h.relayServerOperations(top.DocumentID, []*operations.Operation{top.Operation}, sender.author(), traceID); err != nil {
+			log.Printf("transaction relay on document %s failed: %v (trace %s)", top.DocumentID, err, traceID)
+		}
+	}
+	return nil
+}
+
+// rollbackTransaction restores each document to the version it had before
+// the transaction.
+func (h *Hub) rollbackTransaction(before map[string]int) {
+	for documentID, version := range before {
+		if err := h.GetDocument(documentID).Rollback(version); err != nil {
+			log.Printf("rollback of document %s to version %d failed: %v", documentID, version, err)
+		}
+	}
+}
+
+// mayTransact reports whether sender may edit documentID in a transaction.
+// The hub does not see the credentials a connection was opened with, so
+// besides the sender's own document only open documents, which anyone may
+// edit, are allowed.
+func (h *Hub) mayTransact(sender *Client, documentID string) bool {
+	if sender == nil || sender.documentID == documentID {
+		return true
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return true
+	}
+	v, _ := doc.Visibility()
+	return v == "" || v == document.VisibilityOpen
+}
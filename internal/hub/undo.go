@@ -0,0 +1,78 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// UndoVersion reverts the change that produced one version of a text
+// document, such as an accidentally pasted secret, without disturbing later
+// edits. The revert reaches connected clients as server-generated
+// operations. It returns the document's new version; see
+// document.UndoVersion for the errors.
+func (h *Hub) UndoVersion(documentID string, version int) (int, error) {
+	var newVersion int
+	var err error
+	if !h.do(func() { newVersion, err = h.undoVersion(documentID, version) }) {
+		return 0, ErrHubStopped
+	}
+	return newVersion, err
+}
+
+func (h *Hub) undoVersion(documentID string, version int) (int, error) {
+	if h.IsDeleted(documentID) {
+		return 0, ErrDocumentDeleted
+	}
+	if h.isPaused(documentID) {
+		return 0, ErrDocumentPaused
+	}
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return 0, fmt.Errorf("document %s not found", documentID)
+	}
+
+	ops, newVersion, err := doc.UndoVersion(version)
+	if err != nil {
+		return newVersion, err
+	}
+	if err := h.relayServerOperations(documentID, ops, nil, ""); err != nil {
+		return newVersion, err
+	}
+
+	log.Printf("document %s version %d undone by admin, version: %d", documentID, version, newVersion)
+	return newVersion, nil
+}
+
+// handleUndo reverts the sender's latest edit, or for a redo reapplies the
+// edit its latest undo reverted; see document.Undo. Only the sender's own
+// edits are affected. The result reaches every client, the sender
+// included, as a server operation, since no replica has applied it yet.
+func (h *Hub) handleUndo(doc *document.Document, documentID string, msg *Message, sender *Client) {
+	if sender == nil {
+		return
+	}
+	undo := doc.Undo
+	if msg.Type == MsgTypeRedo {
+		undo = doc.Redo
+	}
+	op, err := undo(sender.authorID())
+	switch {
+	case errors.Is(err, document.ErrNothingToUndo), errors.Is(err, document.ErrNothingToRedo):
+		log.Printf("%s on document %s ignored: %v (trace %s)", msg.Type, documentID, err, msg.TraceID)
+		return
+	case err != nil:
+		log.Printf("%s on document %s failed: %v (trace %s)", msg.Type, documentID, err, msg.TraceID)
+		h.noteRejected(sender)
+		h.failEdit(sender, documentID, msg, err)
+		return
+	case op == nil:
+		log.Printf("%s on document %s redundant after concurrent edits (trace %s)", msg.Type, documentID, msg.TraceID)
+		return
+	}
+	if err := h.relayServerOperations(documentID, []*operations.Operation{op}, sender.author(), msg.TraceID); err != nil {
+		log.Printf("%s on document %s not relayed: %v (trace %s)", msg.Type, documentID, err, msg.TraceID)
+	}
+}
@@ -0,0 +1,108 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/validators"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// ErrUnknownValidator is returned when selecting a validator the hub does
+// not have.
+var ErrUnknownValidator = errors.New("unknown validator")
+
+// SetValidator selects the syntax validator, such as "json", "yaml" or
+// "toml", that checks a text document after each change; "" removes it.
+// The document is checked at once, and collaborators receive a
+// diagnostics message whenever the problems found change.
+func (h *Hub) SetValidator(documentID, name string) error {
+	if _, ok := validators.Lookup(name); name != "" && !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownValidator, name)
+	}
+	var err error
+	if !h.do(func() { err = h.setValidator(documentID, name) }) {
+		return ErrHubStopped
+	}
+	return err
+}
+
+func (h *Hub) setValidator(documentID, name string) error {
+	if h.IsDeleted(documentID) {
+		return ErrDocumentDeleted
+	}
+	doc := h.GetOrCreateDocument(documentID)
+	if kind := doc.GetKind(); name != "" && kind != document.KindText {
+		return fmt.Errorf("validator on %s document", kind)
+	}
+	doc.SetValidator(name)
+	log.Printf("document %s validator set to %q", documentID, name)
+	h.validate(documentID)
+	return nil
+}
+
+// Diagnostics returns the problems the document's validator last found,
+// and the validator's name.
+func (h *Hub) Diagnostics(documentID string) ([]validators.Diagnostic, string) {
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return nil, ""
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return slices.Clone(h.diagnostics[documentID]), doc.GetValidator()
+}
+
+// validate runs the document's validator and sends its collaborators the
+// diagnostics if they changed. It is called after each edit.
+func (h *Hub) validate(documentID string) {
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return
+	}
+	var diagnostics []validators.Diagnostic
+	validator, ok := validators.Lookup(doc.GetValidator())
+	if ok {
+		diagnostics = validator(doc.GetContent())
+	}
+
+	h.mu.Lock()
+	previous, had := h.diagnostics[documentID]
+	if ok {
+		h.diagnostics[documentID] = diagnostics
+	} else {
+		delete(h.diagnostics, documentID)
+	}
+	h.mu.Unlock()
+	if !ok && !had || ok && had && slices.Equal(previous, diagnostics) {
+		return
+	}
+
+	data, err := newDiagnosticsMessage(documentID, diagnostics).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.broadcastToDocument(documentID, data, nil)
+}
+
+// sendDiagnostics sends a newly registered client the problems in its
+// document, if the document has a validator.
+func (h *Hub) sendDiagnostics(client *Client) {
+	h.mu.RLock()
+	diagnostics, ok := h.diagnostics[client.documentID]
+	h.mu.RUnlock()
+	if !ok {
+		return
+	}
+	if data, err := newDiagnosticsMessage(client.documentID, diagnostics).ToBytes(); err == nil {
+		h.sendToClient(client, data)
+	}
+}
+
+// newDiagnosticsMessage builds the diagnostics message for a document. An
+// empty list means the document is valid.
+func newDiagnosticsMessage(documentID string, diagnostics []validators.Diagnostic) *Message {
+	return &Message{Type: MsgTypeDiagnostics, DocumentID: documentID, Diagnostics: diagnostics}
+}
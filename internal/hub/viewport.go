@@ -0,0 +1,161 @@
+package hub
+
+import (
+	"collaborative-docs/internal/clock"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	viewportInterval = 100 * time.Millisecond // Minimum time between relayed viewports per client
+	maxViewportLines = 1 << 20                // Largest line number a viewport may report
+)
+
+// Viewport is the range of lines a client has on screen, relayed to
+// collaborators so they can show where others are reading. Lines are
+// zero-based and inclusive. The hub fills in ClientID.
+type Viewport struct {
+	ClientID  string `json:"client_id,omitempty"`
+	FirstLine int    `json:"first_line"`
+	LastLine  int    `json:"last_line"`
+	Left      bool   `json:"left,omitempty"` // The client disconnected; drop its indicator
+}
+
+// Validate checks the line range.
+func (v *Viewport) Validate() error {
+	if v.FirstLine < 0 || v.LastLine < v.FirstLine || v.LastLine > maxViewportLines {
+		return fmt.Errorf("viewport lines [%d, %d] out of range [0, %d]", v.FirstLine, v.LastLine, maxViewportLines)
+	}
+	return nil
+}
+
+// viewportState throttles one client's viewport updates. It is guarded by
+// h.stateMu.
+type viewportState struct {
+	current   Viewport  // last viewport relayed to collaborators
+	pending   *Viewport // newest viewport held back by the throttle
+	sentAt    time.Time
+	scheduled bool // a flush of pending is scheduled
+}
+
+// handleViewport relays a client's viewport to the other clients on the
+// document, at most once per viewportInterval. Updates arriving faster are
+// coalesced and the newest one is sent when the interval ends, so
+// collaborators always end up with the final position.
+func (h *Hub) handleViewport(documentID string, msg *Message, sender *Client) {
+	if msg.Viewport == nil || sender == nil {
+		return
+	}
+	if err := msg.Viewport.Validate(); err != nil {
+		log.Printf("viewport rejected for document %s: %v", documentID, err)
+		return
+	}
+
+	vp := Viewport{ClientID: sender.id, FirstLine: msg.Viewport.FirstLine, LastLine: msg.Viewport.LastLine}
+	h.stateMu.Lock()
+	state, ok := h.viewports[sender]
+	if !ok {
+		state = &viewportState{}
+		h.viewports[sender] = state
+	}
+
+	wait := viewportInterval - h.clock.Now().Sub(state.sentAt)
+	if wait <= 0 {
+		h.stateMu.Unlock()
+		h.relayViewport(sender, vp)
+		return
+	}
+
+	state.pending = &vp
+	schedule := !state.scheduled
+	state.scheduled = true
+	h.stateMu.Unlock()
+	if schedule {
+		ticker := h.clock.NewTicker(wait)
+		if !h.spawn(RoutineViewportFlush, func() { h.flushViewport(sender, ticker) }) {
+			ticker.Stop()
+		}
+	}
+}
+
+// flushViewport sends a client's pending viewport on the first tick.
+func (h *Hub) flushViewport(client *Client, ticker clock.Ticker) {
+	defer ticker.Stop()
+
+	select {
+	case <-h.quit:
+	case <-ticker.C():
+		h.do(func() {
+			h.stateMu.Lock()
+			state, ok := h.viewports[client]
+			var pending *Viewport
+			if ok {
+				state.scheduled = false
+				pending = state.pending
+			}
+			h.stateMu.Unlock()
+			if pending != nil {
+				h.relayViewport(client, *pending)
+			}
+		})
+	}
+}
+
+// relayViewport broadcasts vp to the client's collaborators.
+func (h *Hub) relayViewport(client *Client, vp Viewport) {
+	h.stateMu.Lock()
+	if state, ok := h.viewports[client]; ok {
+		state.current = vp
+		state.pending = nil
+		state.sentAt = h.clock.Now()
+	}
+	h.stateMu.Unlock()
+
+	data, err := NewViewportMessage(client.documentID, &vp).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.broadcastToView(client, data)
+}
+
+// sendViewports gives a newly registered client the viewports its
+// collaborators last shared.
+func (h *Hub) sendViewports(client *Client) {
+	h.stateMu.Lock()
+	var viewports []Viewport
+	for other, state := range h.viewports {
+		if other != client && other.sameView(client) {
+			viewports = append(viewports, state.current)
+		}
+	}
+	h.stateMu.Unlock()
+	for _, vp := range viewports {
+		data, err := NewViewportMessage(client.documentID, &vp).ToBytes()
+		if err != nil {
+			log.Printf("serialization failed: %v", err)
+			return
+		}
+		h.sendToClient(client, data)
+	}
+}
+
+// forgetViewport drops an unregistered client's viewport and tells its
+// collaborators to remove the indicator.
+func (h *Hub) forgetViewport(client *Client) {
+	h.stateMu.Lock()
+	_, ok := h.viewports[client]
+	delete(h.viewports, client)
+	h.stateMu.Unlock()
+	if !ok {
+		return
+	}
+
+	data, err := NewViewportMessage(client.documentID, &Viewport{ClientID: client.id, Left: true}).ToBytes()
+	if err != nil {
+		log.Printf("serialization failed: %v", err)
+		return
+	}
+	h.broadcastToView(client, data)
+}
@@ -0,0 +1,108 @@
+package hub
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrReadOnly is the reason given to a read-only session for refusing its
+// edits.
+var ErrReadOnly = errors.New("session may not edit the document")
+
+// NewReadOnlyClient creates a client on the live document that receives
+// every update but may not change the document, such as a visitor to a
+// public document.
+func NewReadOnlyClient(hub *Hub, conn Conn, documentID string) *Client {
+	c := NewClient(hub, conn, documentID)
+	c.readOnly = true
+	return c
+}
+
+// readOnlyTypes are the messages a read-only live session may send.
+var readOnlyTypes = map[MessageType]bool{
+	MsgTypeViewport:        true,
+	MsgTypeCursor:          true,
+	MsgTypePresenceRequest: true,
+	MsgTypeMetadataGet:     true,
+	MsgTypeBlobRequest:     true,
+	MsgTypeResync:          true,
+	MsgTypeSyncRequest:     true,
+	MsgTypeSyncMode:        true,
+
+	// Preferences are the user's own, not part of the document.
+	MsgTypePreferencesSet: true,
+	MsgTypePreferencesGet: true,
+}
+
+// refuseReadOnly reports whether bm comes from a read-only live session and
+// would change the document, in which case the sender is told the edit was
+// rejected.
+func (h *Hub) refuseReadOnly(bm *broadcastMessage) bool {
+	if bm.sender == nil || !bm.sender.readOnly {
+		return false
+	}
+	msg, err := MessageFromBytes(bm.message)
+	if err == nil && !IsLegacyContent(bm.message) && (msg.Ephemeral || readOnlyTypes[msg.Type]) {
+		return false
+	}
+	if err != nil {
+		msg = &Message{}
+	}
+	bm.trace(msg)
+	log.Printf("refusing edit from read-only session on document %s (trace %s)", bm.sender.documentID, msg.TraceID)
+	h.rejectEdit(bm.sender, bm.sender.documentID, msg, ErrReadOnly.Error(), nil)
+	return true
+}
+
+// SetVisibility sets who may open a document and returns its link token,
+// which is created the first time the document is shared by link or made
+// public. The server enforces visibility when clients connect and on REST
+// requests; sessions already open are not affected.
+func (h *Hub) SetVisibility(documentID string, v document.Visibility) (string, error) {
+	if !v.Valid() {
+		return "", fmt.Errorf("unknown visibility %q", v)
+	}
+	var token string
+	var err error
+	if !h.do(func() { token, err = h.setVisibility(documentID, v) }) {
+		return "", ErrHubStopped
+	}
+	return token, err
+}
+
+func (h *Hub) setVisibility(documentID string, v document.Visibility) (string, error) {
+	if h.IsDeleted(documentID) {
+		return "", ErrDocumentDeleted
+	}
+
+	doc := h.GetOrCreateDocument(documentID)
+	previous, token := doc.Visibility()
+	if token == "" && (v == document.VisibilityLink || v == document.VisibilityPublic) {
+		token = newToken()
+	}
+	doc.SetVisibility(v, token)
+	if previous == v {
+		return token, nil
+	}
+
+	log.Printf("document %s visibility changed from %s to %s", documentID, previous, v)
+	h.events.Emit(events.Event{
+		Type:       events.TypeVisibilityChanged,
+		DocumentID: documentID,
+		Detail:     string(v),
+	})
+	return token, nil
+}
+
+// newToken returns a random token for sharing a document by link or
+// invite.
+func newToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
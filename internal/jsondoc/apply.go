@@ -0,0 +1,181 @@
+package jsondoc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Apply executes an operation on a decoded JSON tree and returns the new
+// root. Containers along the path are modified in place.
+func Apply(root any, op *Operation) (any, error) {
+	if op == nil {
+		return nil, fmt.Errorf("operation cannot be nil")
+	}
+
+	if err := op.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid operation: %w", err)
+	}
+
+	if op.Type == OpNoop {
+		return root, nil
+	}
+
+	if len(op.Path) == 0 {
+		switch op.Type {
+		case OpSet:
+			return op.Value, nil
+		case OpIncrement:
+			return increment(root, op.Delta)
+		default:
+			return nil, fmt.Errorf("%s operation requires a non-empty path", op.Type)
+		}
+	}
+
+	return applyAt(root, op.Path, op)
+}
+
+// ApplyContent applies an operation to serialized JSON content.
+// Empty content is treated as an empty object.
+func ApplyContent(content string, op *Operation) (string, error) {
+	root, err := decode(content)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := Apply(root, op)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal document: %w", err)
+	}
+	return string(data), nil
+}
+
+// applyAt walks path from node and applies op at the final element.
+func applyAt(node any, path Path, op *Operation) (any, error) {
+	if len(path) == 1 {
+		return applyLeaf(node, path[0], op)
+	}
+
+	switch n := node.(type) {
+	case map[string]any:
+		key, ok := path[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("expected object key, got index %v", path[0])
+		}
+		child, exists := n[key]
+		if !exists {
+			return nil, fmt.Errorf("key %q not found", key)
+		}
+		updated, err := applyAt(child, path[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		n[key] = updated
+		return n, nil
+
+	case []any:
+		idx, ok := path[0].(int)
+		if !ok {
+			return nil, fmt.Errorf("expected array index, got key %q", path[0])
+		}
+		if idx >= len(n) {
+			return nil, fmt.Errorf("index %d out of range [0, %d)", idx, len(n))
+		}
+		updated, err := applyAt(n[idx], path[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		n[idx] = updated
+		return n, nil
+
+	default:
+		return nil, fmt.Errorf("cannot descend into %T", node)
+	}
+}
+
+// applyLeaf applies op to the child of container addressed by key.
+func applyLeaf(container any, key any, op *Operation) (any, error) {
+	switch c := container.(type) {
+	case map[string]any:
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected object key, got index %v", key)
+		}
+		switch op.Type {
+		case OpSet:
+			c[k] = op.Value
+		case OpDelete:
+			if _, exists := c[k]; !exists {
+				return nil, fmt.Errorf("key %q not found", k)
+			}
+			delete(c, k)
+		case OpIncrement:
+			updated, err := increment(c[k], op.Delta)
+			if err != nil {
+				return nil, err
+			}
+			c[k] = updated
+		default:
+			return nil, fmt.Errorf("%s operation requires an array", op.Type)
+		}
+		return c, nil
+
+	case []any:
+		idx, ok := key.(int)
+		if !ok {
+			return nil, fmt.Errorf("expected array index, got key %q", key)
+		}
+		return applyArray(c, idx, op)
+
+	default:
+		return nil, fmt.Errorf("cannot apply %s to %T", op.Type, container)
+	}
+}
+
+// applyArray applies op to the element of arr at idx.
+func applyArray(arr []any, idx int, op *Operation) ([]any, error) {
+	if op.Type == OpInsert {
+		if idx > len(arr) {
+			return nil, fmt.Errorf("insert index %d out of range [0, %d]", idx, len(arr))
+		}
+		return append(arr[:idx], append([]any{op.Value}, arr[idx:]...)...), nil
+	}
+
+	if idx >= len(arr) {
+		return nil, fmt.Errorf("index %d out of range [0, %d)", idx, len(arr))
+	}
+
+	switch op.Type {
+	case OpSet:
+		arr[idx] = op.Value
+	case OpDelete:
+		arr = append(arr[:idx], arr[idx+1:]...)
+	case OpMove:
+		if op.To >= len(arr) {
+			return nil, fmt.Errorf("move destination %d out of range [0, %d)", op.To, len(arr))
+		}
+		moved := arr[idx]
+		arr = append(arr[:idx], arr[idx+1:]...)
+		arr = append(arr[:op.To], append([]any{moved}, arr[op.To:]...)...)
+	case OpIncrement:
+		updated, err := increment(arr[idx], op.Delta)
+		if err != nil {
+			return nil, err
+		}
+		arr[idx] = updated
+	}
+	return arr, nil
+}
+
+// increment adds delta to a JSON number.
+func increment(value any, delta float64) (any, error) {
+	n, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("cannot increment %T", value)
+	}
+	return n + delta, nil
+}
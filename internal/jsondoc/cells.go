@@ -0,0 +1,193 @@
+package jsondoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ConflictPolicy decides how an operation is handled when the cell it
+// targets changed after the version the client based it on.
+type ConflictPolicy string
+
+const (
+	PolicyLastWriterWins ConflictPolicy = "last_writer_wins" // Apply the late operation anyway
+	PolicyReject         ConflictPolicy = "reject"           // Fail with a ConflictError
+	PolicyMerge          ConflictPolicy = "merge"            // Merge object values, otherwise last writer wins
+)
+
+// Valid reports whether p is a known policy.
+func (p ConflictPolicy) Valid() bool {
+	switch p {
+	case PolicyLastWriterWins, PolicyReject, PolicyMerge:
+		return true
+	}
+	return false
+}
+
+// ConflictError reports an optimistic-lock failure on a cell.
+type ConflictError struct {
+	Path     Path
+	Expected int // Version the client based its edit on
+	Actual   int // Version at which the cell was last changed
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict at %s: cell changed at version %d, expected %d", e.Path, e.Actual, e.Expected)
+}
+
+// PathVersions records, for each written path, the document version at which
+// it last changed. A cell's version is the newest write to it or any ancestor,
+// so replacing a row counts as changing every cell in it, and array
+// insert/delete/move counts as changing every element of the array.
+type PathVersions map[string]int
+
+// Get returns the version at which the node at path last changed, or 0 if
+// neither it nor any ancestor has been written.
+func (v PathVersions) Get(path Path) int {
+	latest := 0
+	for i := 0; i <= len(path); i++ {
+		if ver, ok := v[path[:i].String()]; ok && ver > latest {
+			latest = ver
+		}
+	}
+	return latest
+}
+
+// Record marks the node written by op as changed at version. Entries below
+// the written node are dropped, since its version now covers them.
+func (v PathVersions) Record(op *Operation, version int) {
+	if op.Type == OpNoop {
+		return
+	}
+
+	written := op.Path
+	if op.isArrayOp() {
+		written = op.Path[:len(op.Path)-1]
+	}
+
+	key := written.String()
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	for k := range v {
+		if strings.HasPrefix(k, prefix) {
+			delete(v, k)
+		}
+	}
+	v[key] = version
+}
+
+// Compact collapses every entry into a single root entry holding the newest
+// version, and returns the number of entries dropped. Afterwards every cell
+// reports that version, so stale IfVersion checks fail safe as conflicts.
+func (v PathVersions) Compact() int {
+	if len(v) <= 1 {
+		return 0
+	}
+	dropped := len(v) - 1
+	latest := 0
+	for k, ver := range v {
+		if ver > latest {
+			latest = ver
+		}
+		delete(v, k)
+	}
+	v[Path{}.String()] = latest
+	return dropped
+}
+
+// ResolveConflict checks op's IfVersion against the cell's current version
+// and returns the operation to apply to content under policy. Operations
+// without IfVersion, increments, and array operations are returned
+// unchanged, since increments commute and array indices are rebased by
+// Transform before the operation is applied.
+func ResolveConflict(content string, op *Operation, versions PathVersions, policy ConflictPolicy) (*Operation, error) {
+	if op.IfVersion == nil || op.Type == OpIncrement || op.isArrayOp() {
+		return op, nil
+	}
+
+	actual := versions.Get(op.Path)
+	if actual <= *op.IfVersion {
+		return op, nil
+	}
+
+	switch policy {
+	case PolicyReject:
+		return nil, &ConflictError{Path: op.Path, Expected: *op.IfVersion, Actual: actual}
+
+	case PolicyMerge:
+		if op.Type != OpSet {
+			return op, nil
+		}
+		root, err := decode(content)
+		if err != nil {
+			return nil, err
+		}
+		current, ok := Get(root, op.Path)
+		if !ok {
+			return op, nil
+		}
+		merged := *op
+		merged.Value = mergeValues(current, op.Value)
+		return &merged, nil
+
+	default:
+		return op, nil
+	}
+}
+
+// mergeValues shallow-merges two objects, with keys from incoming winning.
+// Non-object values are not mergeable and incoming replaces current.
+func mergeValues(current, incoming any) any {
+	cur, ok1 := current.(map[string]any)
+	inc, ok2 := incoming.(map[string]any)
+	if !ok1 || !ok2 {
+		return incoming
+	}
+
+	merged := make(map[string]any, len(cur)+len(inc))
+	for k, val := range cur {
+		merged[k] = val
+	}
+	for k, val := range inc {
+		merged[k] = val
+	}
+	return merged
+}
+
+// Get returns the value at path in a decoded JSON tree.
+func Get(root any, path Path) (any, bool) {
+	node := root
+	for _, elem := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			key, ok := elem.(string)
+			if !ok {
+				return nil, false
+			}
+			if node, ok = n[key]; !ok {
+				return nil, false
+			}
+		case []any:
+			idx, ok := elem.(int)
+			if !ok || idx >= len(n) {
+				return nil, false
+			}
+			node = n[idx]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// decode parses serialized JSON content, treating empty content as an
+// empty object.
+func decode(content string) (any, error) {
+	var root any = map[string]any{}
+	if content != "" {
+		if err := json.Unmarshal([]byte(content), &root); err != nil {
+			return nil, fmt.Errorf("document is not valid JSON: %w", err)
+		}
+	}
+	return root, nil
+}
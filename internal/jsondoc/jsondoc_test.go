@@ -0,0 +1,254 @@
+package jsondoc
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// TestPathUnmarshal verifies numeric path elements decode as indices.
+func TestPathUnmarshal(t *testing.T) {
+	var op Operation
+	if err := json.Unmarshal([]byte(`{"type":"set","path":["tasks",2,"done"],"value":true}`), &op); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+
+	want := Path{"tasks", 2, "done"}
+	if !reflect.DeepEqual(op.Path, want) {
+		t.Errorf("path = %#v, want %#v", op.Path, want)
+	}
+
+	if err := json.Unmarshal([]byte(`{"type":"set","path":[1.5]}`), &op); err == nil {
+		t.Error("expected error for fractional index")
+	}
+}
+
+// TestApplyContent verifies each operation type against serialized JSON.
+func TestApplyContent(t *testing.T) {
+	doc := `{"count":1,"tasks":["a","b","c"]}`
+
+	tests := []struct {
+		name    string
+		op      *Operation
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "set new key",
+			op:   &Operation{Type: OpSet, Path: Path{"title"}, Value: "Sprint"},
+			want: `{"count":1,"tasks":["a","b","c"],"title":"Sprint"}`,
+		},
+		{
+			name: "insert into array",
+			op:   &Operation{Type: OpInsert, Path: Path{"tasks", 1}, Value: "x"},
+			want: `{"count":1,"tasks":["a","x","b","c"]}`,
+		},
+		{
+			name: "delete array element",
+			op:   &Operation{Type: OpDelete, Path: Path{"tasks", 0}},
+			want: `{"count":1,"tasks":["b","c"]}`,
+		},
+		{
+			name: "move array element",
+			op:   &Operation{Type: OpMove, Path: Path{"tasks", 0}, To: 2},
+			want: `{"count":1,"tasks":["b","c","a"]}`,
+		},
+		{
+			name: "increment number",
+			op:   &Operation{Type: OpIncrement, Path: Path{"count"}, Delta: 4},
+			want: `{"count":5,"tasks":["a","b","c"]}`,
+		},
+		{
+			name:    "increment string",
+			op:      &Operation{Type: OpIncrement, Path: Path{"tasks", 0}, Delta: 1},
+			wantErr: true,
+		},
+		{
+			name:    "missing key",
+			op:      &Operation{Type: OpSet, Path: Path{"nope", "x"}, Value: 1},
+			wantErr: true,
+		},
+		{
+			name:    "insert with key path",
+			op:      &Operation{Type: OpInsert, Path: Path{"tasks"}, Value: 1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyContent(doc, tt.op)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyContent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ApplyContent() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTransformConvergence checks the transform property over random pairs of
+// operations on a tree mixing objects, arrays, and nested arrays.
+func TestTransformConvergence(t *testing.T) {
+	const start = `{"n":5,"obj":{"a":1,"b":2},"list":[0,1,2,3],"grid":[[1,2],[3,4],[5,6]]}`
+	rng := rand.New(rand.NewSource(1))
+
+	randomOp := func() *Operation {
+		paths := []Path{
+			{"n"}, {"obj"}, {"obj", "a"}, {"obj", "b"},
+			{"list"}, {"list", rng.Intn(4)},
+			{"grid", rng.Intn(3)}, {"grid", rng.Intn(3), rng.Intn(2)},
+		}
+		path := paths[rng.Intn(len(paths))]
+		_, isIndex := path[len(path)-1].(int)
+
+		switch rng.Intn(5) {
+		case 0:
+			return &Operation{Type: OpSet, Path: path, Value: float64(rng.Intn(100))}
+		case 1:
+			if isIndex {
+				return &Operation{Type: OpInsert, Path: path, Value: float64(rng.Intn(100))}
+			}
+		case 2:
+			if isIndex {
+				return &Operation{Type: OpMove, Path: path, To: rng.Intn(2)}
+			}
+		case 3:
+			if len(path) > 0 {
+				return &Operation{Type: OpDelete, Path: path}
+			}
+		}
+		return &Operation{Type: OpIncrement, Path: Path{"n"}, Delta: 1}
+	}
+
+	apply := func(ops ...*Operation) (string, error) {
+		content := start
+		for _, op := range ops {
+			var err error
+			if content, err = ApplyContent(content, op); err != nil {
+				return "", err
+			}
+		}
+		return content, nil
+	}
+
+	for i := 0; i < 5000; i++ {
+		op1, op2 := randomOp(), randomOp()
+		if _, err := apply(op1); err != nil {
+			continue
+		}
+		if _, err := apply(op2); err != nil {
+			continue
+		}
+
+		op1Prime, op2Prime, err := Transform(op1, op2)
+		if err != nil {
+			t.Fatalf("Transform(%s, %s) error: %v", op1, op2, err)
+		}
+
+		left, err := apply(op1, op2Prime)
+		if err != nil {
+			t.Fatalf("%s then %s: %v", op1, op2Prime, err)
+		}
+		right, err := apply(op2, op1Prime)
+		if err != nil {
+			t.Fatalf("%s then %s: %v", op2, op1Prime, err)
+		}
+
+		if left != right {
+			t.Fatalf("diverged for %s / %s: %s vs %s", op1, op2, left, right)
+		}
+	}
+}
+
+// TestPathVersions verifies cell versions account for ancestor and array writes.
+func TestPathVersions(t *testing.T) {
+	v := make(PathVersions)
+
+	v.Record(&Operation{Type: OpSet, Path: Path{"rows", 0, "a"}}, 1)
+	v.Record(&Operation{Type: OpSet, Path: Path{"rows", 1, "b"}}, 2)
+
+	if got := v.Get(Path{"rows", 0, "a"}); got != 1 {
+		t.Errorf("cell a version = %d, want 1", got)
+	}
+	if got := v.Get(Path{"rows", 0, "b"}); got != 0 {
+		t.Errorf("untouched cell version = %d, want 0", got)
+	}
+
+	v.Record(&Operation{Type: OpSet, Path: Path{"rows", 1}}, 3)
+	if got := v.Get(Path{"rows", 1, "b"}); got != 3 {
+		t.Errorf("cell under replaced row version = %d, want 3", got)
+	}
+
+	v.Record(&Operation{Type: OpInsert, Path: Path{"rows", 0}}, 4)
+	if got := v.Get(Path{"rows", 0, "a"}); got != 4 {
+		t.Errorf("cell after row insert version = %d, want 4", got)
+	}
+
+	v.Record(&Operation{Type: OpSet, Path: Path{"title"}}, 5)
+	if dropped := v.Compact(); dropped != 1 || len(v) != 1 {
+		t.Errorf("Compact() dropped %d leaving %d entries, want 1 leaving 1", dropped, len(v))
+	}
+	if got := v.Get(Path{"rows", 0, "a"}); got != 5 {
+		t.Errorf("cell version after compact = %d, want 5", got)
+	}
+}
+
+// TestResolveConflict verifies each policy for a stale same-cell edit.
+func TestResolveConflict(t *testing.T) {
+	content := `{"cell":{"x":1}}`
+	versions := PathVersions{"/cell": 5}
+	stale := 3
+
+	tests := []struct {
+		name      string
+		policy    ConflictPolicy
+		op        *Operation
+		wantValue any
+		wantErr   bool
+	}{
+		{
+			name:      "last writer wins",
+			policy:    PolicyLastWriterWins,
+			op:        &Operation{Type: OpSet, Path: Path{"cell"}, Value: map[string]any{"y": 2.0}, IfVersion: &stale},
+			wantValue: map[string]any{"y": 2.0},
+		},
+		{
+			name:    "reject",
+			policy:  PolicyReject,
+			op:      &Operation{Type: OpSet, Path: Path{"cell"}, Value: 7.0, IfVersion: &stale},
+			wantErr: true,
+		},
+		{
+			name:      "merge objects",
+			policy:    PolicyMerge,
+			op:        &Operation{Type: OpSet, Path: Path{"cell"}, Value: map[string]any{"y": 2.0}, IfVersion: &stale},
+			wantValue: map[string]any{"x": 1.0, "y": 2.0},
+		},
+		{
+			name:   "increment never conflicts",
+			policy: PolicyReject,
+			op:     &Operation{Type: OpIncrement, Path: Path{"cell", "x"}, Delta: 1, IfVersion: &stale},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveConflict(content, tt.op, versions, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveConflict() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if _, ok := err.(*ConflictError); !ok {
+					t.Errorf("expected *ConflictError, got %T", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got.Value, tt.wantValue) {
+				t.Errorf("value = %#v, want %#v", got.Value, tt.wantValue)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package jsondoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OpType represents the type of JSON tree operation.
+type OpType string
+
+const (
+	OpSet       OpType = "set"       // Replace the value at Path (creating object keys)
+	OpInsert    OpType = "insert"    // Insert Value into an array before index Path[-1]
+	OpDelete    OpType = "delete"    // Remove an object key or array element
+	OpMove      OpType = "move"      // Move array element Path[-1] to index To
+	OpIncrement OpType = "increment" // Add Delta to the number at Path
+	OpNoop      OpType = "noop"      // Result of transforming away a losing operation
+)
+
+// Path addresses a node in a JSON tree. Elements are object keys (string)
+// or array indices (int).
+type Path []any
+
+// UnmarshalJSON decodes a path, converting JSON numbers to array indices.
+func (p *Path) UnmarshalJSON(data []byte) error {
+	var raw []any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	path := make(Path, len(raw))
+	for i, elem := range raw {
+		switch v := elem.(type) {
+		case string:
+			path[i] = v
+		case float64:
+			if v != float64(int(v)) {
+				return fmt.Errorf("path element %d: index %v is not an integer", i, v)
+			}
+			path[i] = int(v)
+		default:
+			return fmt.Errorf("path element %d: must be a string or integer", i)
+		}
+	}
+
+	*p = path
+	return nil
+}
+
+// String renders the path in a JSON-pointer-like form, e.g. /tasks/2/done.
+func (p Path) String() string {
+	var sb strings.Builder
+	for _, elem := range p {
+		fmt.Fprintf(&sb, "/%v", elem)
+	}
+	if sb.Len() == 0 {
+		return "/"
+	}
+	return sb.String()
+}
+
+// Operation is an edit to a JSON document tree.
+type Operation struct {
+	Type    OpType  `json:"type"`
+	Path    Path    `json:"path"`
+	Value   any     `json:"value,omitempty"`
+	To      int     `json:"to,omitempty"`
+	Delta   float64 `json:"delta,omitempty"`
+	Version int     `json:"version"`
+
+	// IfVersion optionally locks the edit to the cell state the client saw:
+	// the operation conflicts if the cell changed after this version.
+	IfVersion *int `json:"if_version,omitempty"`
+	// PathVersion is set by the server to the cell's version after applying.
+	PathVersion int `json:"path_version,omitempty"`
+}
+
+// String returns a human-readable representation of the operation.
+func (op *Operation) String() string {
+	switch op.Type {
+	case OpMove:
+		return fmt.Sprintf("Move(%s to %d, v%d)", op.Path, op.To, op.Version)
+	case OpIncrement:
+		return fmt.Sprintf("Increment(%s by %v, v%d)", op.Path, op.Delta, op.Version)
+	default:
+		return fmt.Sprintf("%s(%s, v%d)", op.Type, op.Path, op.Version)
+	}
+}
+
+// Validate checks if the operation is well-formed.
+func (op *Operation) Validate() error {
+	for i, elem := range op.Path {
+		switch v := elem.(type) {
+		case string:
+		case int:
+			if v < 0 {
+				return fmt.Errorf("path element %d: negative index %d", i, v)
+			}
+		default:
+			return fmt.Errorf("path element %d: must be a string or integer", i)
+		}
+	}
+
+	switch op.Type {
+	case OpSet, OpIncrement, OpNoop:
+	case OpInsert, OpMove:
+		if _, ok := op.lastIndex(); !ok {
+			return fmt.Errorf("%s operation path must end in an array index", op.Type)
+		}
+		if op.Type == OpMove && op.To < 0 {
+			return fmt.Errorf("invalid move destination: %d (must be >= 0)", op.To)
+		}
+	case OpDelete:
+		if len(op.Path) == 0 {
+			return fmt.Errorf("cannot delete the document root")
+		}
+	default:
+		return fmt.Errorf("unknown json operation type: %s", op.Type)
+	}
+
+	if op.Version < 0 {
+		return fmt.Errorf("invalid version: %d (must be >= 0)", op.Version)
+	}
+
+	return nil
+}
+
+// lastIndex returns the final path element as an array index.
+func (op *Operation) lastIndex() (int, bool) {
+	if len(op.Path) == 0 {
+		return 0, false
+	}
+	idx, ok := op.Path[len(op.Path)-1].(int)
+	return idx, ok
+}
+
+// isArrayOp reports whether the operation shifts indices in its parent array.
+func (op *Operation) isArrayOp() bool {
+	switch op.Type {
+	case OpInsert, OpMove:
+		return true
+	case OpDelete:
+		_, ok := op.lastIndex()
+		return ok
+	default:
+		return false
+	}
+}
@@ -0,0 +1,167 @@
+package jsondoc
+
+import (
+	"collaborative-docs/internal/blocks"
+	"fmt"
+)
+
+// Transform adjusts two concurrent JSON operations created against the same
+// document version so they can be applied sequentially without conflict,
+// returning (op1', op2') with the same convergence property as
+// operations.Transform.
+//
+// Conflict rules:
+//   - Array indices shift exactly like block lists; see blocks.Transform.
+//   - A set or delete of a node wins over any operation below it.
+//   - Concurrent increments of the same number both apply.
+//   - A set wins over an increment of the same node; for two sets op1 wins.
+func Transform(op1, op2 *Operation) (*Operation, *Operation, error) {
+	if op1 == nil || op2 == nil {
+		return nil, nil, fmt.Errorf("operations cannot be nil")
+	}
+
+	if err := op1.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("op1 invalid: %w", err)
+	}
+	if err := op2.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("op2 invalid: %w", err)
+	}
+
+	op1Prime, err := transformAgainst(op1, op2, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	op2Prime, err := transformAgainst(op2, op1, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return op1Prime, op2Prime, nil
+}
+
+// transformAgainst returns a copy of op rewritten to apply after other.
+// wins reports whether op takes priority in ties.
+func transformAgainst(op, other *Operation, wins bool) (*Operation, error) {
+	result := clone(op)
+	if op.Type == OpNoop || other.Type == OpNoop {
+		return result, nil
+	}
+
+	if other.isArrayOp() {
+		container := other.Path[:len(other.Path)-1]
+		depth := len(container)
+		if len(op.Path) > depth && hasPrefix(op.Path, container) {
+			if idx, ok := op.Path[depth].(int); ok {
+				return result, transformIndex(result, op, other, idx, depth, wins)
+			}
+		}
+	}
+
+	switch {
+	case (other.Type == OpSet || other.Type == OpDelete) &&
+		len(other.Path) < len(op.Path) && hasPrefix(op.Path, other.Path):
+		// The subtree op edits was replaced or removed.
+		makeNoop(result)
+
+	case len(op.Path) == len(other.Path) && hasPrefix(op.Path, other.Path) && !op.isArrayOp():
+		if losesSamePath(op.Type, other.Type, wins) {
+			makeNoop(result)
+		}
+	}
+
+	return result, nil
+}
+
+// losesSamePath reports whether an operation of type op is discarded when
+// another operation of type other targets the same node.
+func losesSamePath(op, other OpType, wins bool) bool {
+	switch {
+	case op == OpDelete:
+		return other == OpDelete
+	case other == OpDelete:
+		return true
+	case op == OpSet && other == OpSet:
+		return !wins
+	case op == OpIncrement && other == OpSet:
+		return true
+	default:
+		return false
+	}
+}
+
+// transformIndex shifts the array index at depth in result, which addresses
+// element idx of the array other modifies. Array indices follow the same
+// rules as block lists, so the blocks transform does the arithmetic.
+func transformIndex(result, op, other *Operation, idx, depth int, wins bool) error {
+	var self *blocks.Operation
+	if op.isArrayOp() && len(op.Path) == depth+1 {
+		self = toListOp(op, idx)
+	} else {
+		// op edits inside the element; treat it as a reference to idx.
+		self = blocks.NewSetLevelOp(idx, 1, 0)
+	}
+	peer := toListOp(other, other.Path[depth].(int))
+
+	var transformed *blocks.Operation
+	var err error
+	if wins {
+		transformed, _, err = blocks.Transform(self, peer)
+	} else {
+		_, transformed, err = blocks.Transform(peer, self)
+	}
+	if err != nil {
+		return fmt.Errorf("array transform failed: %w", err)
+	}
+
+	if transformed.Type == blocks.OpNoop {
+		makeNoop(result)
+		return nil
+	}
+
+	result.Path[depth] = transformed.Index
+	if result.Type == OpMove && len(op.Path) == depth+1 {
+		result.To = transformed.To
+	}
+	return nil
+}
+
+// toListOp converts an array operation into its block-list equivalent.
+func toListOp(op *Operation, idx int) *blocks.Operation {
+	switch op.Type {
+	case OpInsert:
+		return blocks.NewInsertBlockOp(idx, blocks.Block{Kind: blocks.KindParagraph}, 0)
+	case OpMove:
+		return blocks.NewMoveBlockOp(idx, op.To, 0)
+	default:
+		return blocks.NewDeleteBlockOp(idx, 0)
+	}
+}
+
+// hasPrefix reports whether path starts with prefix.
+func hasPrefix(path, prefix Path) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// makeNoop turns op into a no-op, keeping its version.
+func makeNoop(op *Operation) {
+	op.Type = OpNoop
+	op.Path = nil
+	op.Value = nil
+	op.To = 0
+	op.Delta = 0
+}
+
+// clone copies op and advances its version, mirroring operations.Transform.
+func clone(op *Operation) *Operation {
+	c := *op
+	c.Path = append(Path(nil), op.Path...)
+	c.Version = op.Version + 1
+	return &c
+}
@@ -0,0 +1,326 @@
+// Package metrics exposes counters, gauges and histograms in the
+// Prometheus text exposition format, so a Prometheus server can scrape
+// them:
+//
+//	reg := metrics.NewRegistry()
+//	ops := reg.Counter("collab_operations_applied_total", "Operations applied.")
+//	ops.Inc()
+//	http.Handle("/metrics", reg)
+//
+// Counters and histograms are updated as things happen. Gauges are
+// computed by a function when scraped, from state kept elsewhere. Every
+// metric's methods may be called on nil, doing nothing, so code can record
+// into metrics that were never registered.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// validName matches metric and label names.
+var validName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Registry holds metrics and writes them for scraping.
+type Registry struct {
+	mu       sync.Mutex
+	families []family // In registration order
+	names    map[string]bool
+}
+
+// family is one registered metric, written with its HELP and TYPE lines.
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry creates a registry with no metrics.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds f under name. It panics if the name is invalid or taken,
+// which is a programming error.
+func (r *Registry) register(name string, labels []string, f family) {
+	for _, n := range append([]string{name}, labels...) {
+		if !validName.MatchString(n) {
+			panic(fmt.Sprintf("metrics: invalid name %q", n))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// Counter registers a counter: a count that only goes up, such as
+// operations applied.
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	r.register(name, nil, c)
+	return c
+}
+
+// CounterVec registers counters told apart by one label, such as failures
+// by reason.
+func (r *Registry) CounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{name: name, help: help, label: label, counts: make(map[string]*atomic.Uint64)}
+	r.register(name, []string{label}, c)
+	return c
+}
+
+// Histogram registers a histogram with the given bucket upper bounds, in
+// ascending order; a +Inf bucket is added.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: slices.Clone(buckets), counts: make([]uint64, len(buckets)+1)}
+	r.register(name, nil, h)
+	return h
+}
+
+// GaugeFunc registers a gauge whose value fn computes when scraped.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, nil, &gaugeFunc{name: name, help: help, fn: fn})
+}
+
+// GaugeVecFunc registers gauges told apart by one label, such as clients
+// by document, whose values by label value fn computes when scraped.
+func (r *Registry) GaugeVecFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(name, []string{label}, &gaugeVecFunc{name: name, help: help, label: label, fn: fn})
+}
+
+// WriteTo writes every metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// ServeHTTP answers a scrape with every metric.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+// Counter is a count that only goes up.
+type Counter struct {
+	name, help string
+	n          atomic.Uint64
+}
+
+// Inc adds one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds n.
+func (c *Counter) Add(n uint64) {
+	if c != nil {
+		c.n.Add(n)
+	}
+}
+
+// Value returns the count.
+func (c *Counter) Value() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.n.Load()
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	header(w, c.name, c.help, "counter")
+	sample(w, c.name, "", "", float64(c.n.Load()))
+}
+
+// CounterVec is counters told apart by one label.
+type CounterVec struct {
+	name, help, label string
+	mu                sync.RWMutex
+	counts            map[string]*atomic.Uint64 // By label value
+}
+
+// Inc adds one to the counter for value.
+func (c *CounterVec) Inc(value string) {
+	if c == nil {
+		return
+	}
+	c.mu.RLock()
+	n := c.counts[value]
+	c.mu.RUnlock()
+	if n == nil {
+		c.mu.Lock()
+		if n = c.counts[value]; n == nil {
+			n = new(atomic.Uint64)
+			c.counts[value] = n
+		}
+		c.mu.Unlock()
+	}
+	n.Add(1)
+}
+
+// Value returns the count for value.
+func (c *CounterVec) Value(value string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if n := c.counts[value]; n != nil {
+		return n.Load()
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	header(w, c.name, c.help, "counter")
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, value := range slices.Sorted(maps.Keys(c.counts)) {
+		sample(w, c.name, c.label, value, float64(c.counts[value].Load()))
+	}
+}
+
+// Histogram counts observations in buckets, such as latencies in seconds.
+type Histogram struct {
+	name, help string
+	buckets    []float64
+	mu         sync.Mutex
+	counts     []uint64 // Per bucket, not cumulative; the last is +Inf
+	count      uint64
+	sum        float64
+}
+
+// Observe records one observation.
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	i, _ := slices.BinarySearch(h.buckets, v) // First bound at or above v
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+// Count returns how many observations were recorded.
+func (h *Histogram) Count() uint64 {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	header(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for i, n := range h.counts {
+		cumulative += n
+		bound := math.Inf(1)
+		if i < len(h.buckets) {
+			bound = h.buckets[i]
+		}
+		sample(w, h.name+"_bucket", "le", formatFloat(bound), float64(cumulative))
+	}
+	sample(w, h.name+"_sum", "", "", h.sum)
+	sample(w, h.name+"_count", "", "", float64(h.count))
+}
+
+// gaugeFunc is a gauge computed when scraped.
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	header(w, g.name, g.help, "gauge")
+	sample(w, g.name, "", "", g.fn())
+}
+
+// gaugeVecFunc is gauges told apart by one label, computed when scraped.
+type gaugeVecFunc struct {
+	name, help, label string
+	fn                func() map[string]float64
+}
+
+func (g *gaugeVecFunc) write(w *bufio.Writer) {
+	header(w, g.name, g.help, "gauge")
+	values := g.fn()
+	for _, value := range slices.Sorted(maps.Keys(values)) {
+		sample(w, g.name, g.label, value, values[value])
+	}
+}
+
+// header writes a metric's HELP and TYPE lines.
+func header(w *bufio.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample line, with a label if label is set.
+func sample(w *bufio.Writer, name, label, value string, v float64) {
+	w.WriteString(name)
+	if label != "" {
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		fmt.Fprintf(w, `{%s="%s"}`, label, value)
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// formatFloat formats v as the exposition format writes numbers.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
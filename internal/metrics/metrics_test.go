@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestExposition verifies each kind of metric is written in the text
+// exposition format, in registration order.
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	ops := r.Counter("ops_total", "Operations.\nApplied.")
+	failed := r.CounterVec("failed_total", "Failures.", "reason")
+	latency := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	r.GaugeFunc("clients", "Clients.", func() float64 { return 2 })
+	r.GaugeVecFunc("size_bytes", "Sizes.", "document", func() map[string]float64 {
+		return map[string]float64{"b": 20, `a"\` + "\n": 10}
+	})
+
+	ops.Inc()
+	ops.Add(2)
+	failed.Inc("wrong_kind")
+	failed.Inc("fenced")
+	failed.Inc("fenced")
+	latency.Observe(0.05)
+	latency.Observe(0.1)
+	latency.Observe(3)
+
+	var out strings.Builder
+	if _, err := r.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo() error: %v", err)
+	}
+	want := `# HELP ops_total Operations.\nApplied.
+# TYPE ops_total counter
+ops_total 3
+# HELP failed_total Failures.
+# TYPE failed_total counter
+failed_total{reason="fenced"} 2
+failed_total{reason="wrong_kind"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.15
+latency_seconds_count 3
+# HELP clients Clients.
+# TYPE clients gauge
+clients 2
+# HELP size_bytes Sizes.
+# TYPE size_bytes gauge
+size_bytes{document="a\"\\\n"} 10
+size_bytes{document="b"} 20
+`
+	if out.String() != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+// TestNilMetrics verifies metrics that were never registered record
+// nothing without failing.
+func TestNilMetrics(t *testing.T) {
+	var c *Counter
+	var v *CounterVec
+	var h *Histogram
+	c.Inc()
+	v.Inc("x")
+	h.Observe(1)
+	if c.Value() != 0 || v.Value("x") != 0 || h.Count() != 0 {
+		t.Error("nil metrics recorded observations")
+	}
+}
+
+// TestRegisterTwice verifies a name can only be registered once.
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("ops_total", "Operations.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	r.GaugeFunc("ops_total", "Operations.", func() float64 { return 0 })
+}
+
+// TestServeHTTP verifies scrapes get the exposition format and other
+// methods are refused.
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("ops_total", "Operations.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != ContentType || !strings.Contains(rec.Body.String(), "ops_total 1\n") {
+		t.Errorf("GET = %d %q %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
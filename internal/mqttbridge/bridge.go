@@ -0,0 +1,234 @@
+package mqttbridge
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// publishQueueSize bounds events waiting to be published. MQTT observers are
+// best-effort, so events beyond this are dropped rather than stalling the hub.
+const publishQueueSize = 1024
+
+// maxAppendSize bounds the text an MQTT client may append in one message.
+const maxAppendSize = 4 * 1024
+
+// documentIDPattern matches valid document IDs in topic segments.
+var documentIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,100}$`)
+
+// Client is the subset of an MQTT client the bridge needs. An adapter over
+// a library such as Eclipse Paho is enough.
+type Client interface {
+	Publish(topic string, payload []byte, retained bool) error
+	Subscribe(topic string, handler func(topic string, payload []byte)) error
+}
+
+// Config controls topic layout and write access.
+type Config struct {
+	// Prefix is the topic root, e.g. "docs" gives docs/{id}/content.
+	Prefix string
+	// AllowAppend lets MQTT clients append text via {prefix}/{id}/append.
+	AllowAppend bool
+}
+
+// Bridge mirrors documents onto MQTT topics for clients without a
+// WebSocket stack:
+//
+//	{prefix}/{id}/content  retained snapshot of the current content
+//	{prefix}/{id}/events   change events as JSON
+//	{prefix}/{id}/append   inbound text appended to the document (optional)
+//
+// MQTT clients are anonymous, so only documents anyone may read, open and
+// public ones, are mirrored, and only open ones take appends.
+type Bridge struct {
+	client   Client
+	hub      *hub.Hub
+	config   Config
+	queue    chan events.Event
+	done     chan struct{}
+	once     sync.Once
+	mirrored map[string]bool // Documents last seen readable; only used from run
+
+	// appendMu serializes appends so each one sees the previous append's
+	// content when computing the end-of-document position.
+	appendMu sync.Mutex
+}
+
+// New creates a bridge. Call Start to begin publishing and register it with
+// the hub via AddEventSink.
+func New(client Client, h *hub.Hub, cfg Config) *Bridge {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "docs"
+	}
+	return &Bridge{
+		client:   client,
+		hub:      h,
+		config:   cfg,
+		queue:    make(chan events.Event, publishQueueSize),
+		done:     make(chan struct{}),
+		mirrored: make(map[string]bool),
+	}
+}
+
+// Start subscribes to append topics (if enabled) and starts publishing.
+func (b *Bridge) Start() error {
+	if b.config.AllowAppend {
+		if err := b.client.Subscribe(b.config.Prefix+"/+/append", b.handleAppend); err != nil {
+			return fmt.Errorf("failed to subscribe to append topic: %w", err)
+		}
+	}
+	go b.run()
+	return nil
+}
+
+// Stop halts publishing. Queued events are discarded.
+func (b *Bridge) Stop() {
+	b.once.Do(func() { close(b.done) })
+}
+
+// Publish implements events.Sink. It never blocks the hub.
+func (b *Bridge) Publish(event events.Event) {
+	select {
+	case b.queue <- event:
+	default:
+		log.Printf("mqtt bridge queue full, dropping %s event for %s", event.Type, event.DocumentID)
+	}
+}
+
+// run publishes queued events until Stop is called.
+func (b *Bridge) run() {
+	for {
+		select {
+		case <-b.done:
+			return
+		case event := <-b.queue:
+			b.publishEvent(event)
+		}
+	}
+}
+
+// publishEvent sends the event and, for content changes, a fresh retained
+// snapshot so new subscribers immediately see the current document. Events
+// of documents anonymous clients may not read are not sent, and a
+// document that stops being readable has its retained snapshot cleared.
+func (b *Bridge) publishEvent(event events.Event) {
+	was := b.mirrored[event.DocumentID]
+	if !b.readable(event) {
+		if was {
+			if err := b.client.Publish(b.topic(event.DocumentID, "content"), nil, true); err != nil {
+				log.Printf("mqtt bridge: snapshot clear failed: %v", err)
+			}
+		}
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("mqtt bridge: failed to encode event: %v", err)
+		return
+	}
+	if err := b.client.Publish(b.topic(event.DocumentID, "events"), payload, false); err != nil {
+		log.Printf("mqtt bridge: publish failed: %v", err)
+	}
+
+	switch event.Type {
+	case events.TypeOperationApplied, events.TypeContentSet, events.TypeDocumentCreated, events.TypeVisibilityChanged:
+	default:
+		return
+	}
+
+	doc := b.hub.GetDocument(event.DocumentID)
+	if doc == nil {
+		return
+	}
+	if err := b.client.Publish(b.topic(event.DocumentID, "content"), []byte(doc.GetContent()), true); err != nil {
+		log.Printf("mqtt bridge: snapshot publish failed: %v", err)
+	}
+}
+
+// readable reports whether anonymous clients may read the document event
+// is about, recording the answer for events that come once the document
+// is gone: those of deleted and evicted documents are sent if it was
+// readable until then.
+func (b *Bridge) readable(event events.Event) bool {
+	switch event.Type {
+	case events.TypeDocumentDeleted, events.TypeDocumentEvicted:
+		ok := b.mirrored[event.DocumentID]
+		delete(b.mirrored, event.DocumentID)
+		return ok
+	}
+	doc := b.hub.GetDocument(event.DocumentID)
+	if doc == nil {
+		return false
+	}
+	visibility, _ := doc.Visibility()
+	ok := visibility == document.VisibilityOpen || visibility == document.VisibilityPublic
+	if ok {
+		b.mirrored[event.DocumentID] = true
+	} else {
+		delete(b.mirrored, event.DocumentID)
+	}
+	return ok
+}
+
+// handleAppend turns an inbound append message into an insert operation at
+// the end of the document, routed through the hub like any client edit.
+// Appends come from anonymous clients, so they are only taken by open
+// documents and are sent as an untrusted client's, which may not change
+// fenced text.
+func (b *Bridge) handleAppend(topic string, payload []byte) {
+	documentID, ok := b.documentFromTopic(topic)
+	if !ok {
+		log.Printf("mqtt bridge: ignoring append on invalid topic %q", topic)
+		return
+	}
+	if len(payload) == 0 || len(payload) > maxAppendSize {
+		log.Printf("mqtt bridge: append of %d bytes rejected for %s", len(payload), documentID)
+		return
+	}
+
+	b.appendMu.Lock()
+	defer b.appendMu.Unlock()
+
+	doc := b.hub.GetOrCreateDocument(documentID)
+	if visibility, _ := doc.Visibility(); visibility != document.VisibilityOpen {
+		log.Printf("mqtt bridge: append rejected for %s document %s", visibility, documentID)
+		return
+	}
+	content, version := doc.GetContentAndVersion()
+	msg := hub.NewOperationMessage(operations.NewInsertOp(operations.Len(content), string(payload), version))
+	msg.DocumentID = documentID
+
+	data, err := msg.ToBytes()
+	if err != nil {
+		log.Printf("mqtt bridge: failed to encode append: %v", err)
+		return
+	}
+	// The client is never registered: the hub's replies to it are dropped.
+	b.hub.Submit(data, hub.NewLocalClient(b.hub, documentID, 1))
+}
+
+// topic builds {prefix}/{documentID}/{leaf}.
+func (b *Bridge) topic(documentID, leaf string) string {
+	return b.config.Prefix + "/" + documentID + "/" + leaf
+}
+
+// documentFromTopic extracts and validates the document ID segment.
+func (b *Bridge) documentFromTopic(topic string) (string, bool) {
+	rest, ok := strings.CutPrefix(topic, b.config.Prefix+"/")
+	if !ok {
+		return "", false
+	}
+	documentID, leaf, ok := strings.Cut(rest, "/")
+	if !ok || leaf != "append" || !documentIDPattern.MatchString(documentID) {
+		return "", false
+	}
+	return documentID, true
+}
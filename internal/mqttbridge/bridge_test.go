@@ -0,0 +1,155 @@
+package mqttbridge
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/hub"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClient is an in-memory MQTT client.
+type fakeClient struct {
+	handlers  map[string]func(string, []byte)
+	published map[string][]byte
+	retained  map[string]bool
+	mu        sync.Mutex
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		handlers:  make(map[string]func(string, []byte)),
+		published: make(map[string][]byte),
+		retained:  make(map[string]bool),
+	}
+}
+
+func (f *fakeClient) Publish(topic string, payload []byte, retained bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published[topic] = payload
+	f.retained[topic] = retained
+	return nil
+}
+
+func (f *fakeClient) Subscribe(topic string, handler func(string, []byte)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[topic] = handler
+	return nil
+}
+
+func (f *fakeClient) get(topic string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payload, ok := f.published[topic]
+	return payload, ok && f.retained[topic]
+}
+
+// TestBridgeAppendAndSnapshot verifies MQTT appends edit the document and the
+// retained content topic follows.
+func TestBridgeAppendAndSnapshot(t *testing.T) {
+	h := hub.NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	client := newFakeClient()
+	bridge := New(client, h, Config{AllowAppend: true})
+	h.AddEventSink(bridge)
+	if err := bridge.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer bridge.Stop()
+
+	handler := client.handlers["docs/+/append"]
+	if handler == nil {
+		t.Fatal("bridge did not subscribe to append topic")
+	}
+
+	handler("docs/sensor-log/append", []byte("temp=21\n"))
+	handler("docs/sensor-log/append", []byte("temp=22\n"))
+	handler("docs/../append", []byte("ignored"))
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if content, retained := client.get("docs/sensor-log/content"); retained && string(content) == "temp=21\ntemp=22\n" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	content, _ := client.get("docs/sensor-log/content")
+	t.Fatalf("retained content = %q, want both appends", content)
+}
+
+// TestDocumentFromTopic verifies topic parsing rejects malformed IDs.
+func TestDocumentFromTopic(t *testing.T) {
+	b := New(newFakeClient(), nil, Config{Prefix: "site/docs"})
+
+	tests := []struct {
+		topic  string
+		wantID string
+		wantOK bool
+	}{
+		{"site/docs/abc-1/append", "abc-1", true},
+		{"site/docs/abc/content", "", false},
+		{"other/abc/append", "", false},
+		{"site/docs/a.b/append", "", false},
+	}
+
+	for _, tt := range tests {
+		id, ok := b.documentFromTopic(tt.topic)
+		if id != tt.wantID || ok != tt.wantOK {
+			t.Errorf("documentFromTopic(%q) = (%q, %v), want (%q, %v)", tt.topic, id, ok, tt.wantID, tt.wantOK)
+		}
+	}
+}
+
+// TestBridgeVisibility verifies documents anonymous clients may not read
+// are not mirrored, and only open documents take appends.
+func TestBridgeVisibility(t *testing.T) {
+	h := hub.NewHub()
+	go h.Run()
+	defer h.Shutdown()
+
+	client := newFakeClient()
+	bridge := New(client, h, Config{AllowAppend: true})
+	h.AddEventSink(bridge)
+	if err := bridge.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer bridge.Stop()
+	handler := client.handlers["docs/+/append"]
+
+	version, _ := h.ReplaceContent("secret", "draft", 0)
+	h.SetVisibility("secret", document.VisibilityPrivate)
+	h.ReplaceContent("secret", "password=hunter2", version)
+	handler("docs/secret/append", []byte("x"))
+	h.ReplaceContent("notice", "read only", 0)
+	h.SetVisibility("notice", document.VisibilityPublic)
+	handler("docs/notice/append", []byte("x"))
+
+	// Events are published in order, so once this append shows the
+	// earlier ones have been handled.
+	handler("docs/marker/append", []byte("done"))
+	deadline := time.Now().Add(time.Second)
+	for content, _ := client.get("docs/marker/content"); string(content) != "done"; content, _ = client.get("docs/marker/content") {
+		if time.Now().After(deadline) {
+			t.Fatal("marker append was not mirrored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if content, _ := client.get("docs/secret/content"); len(content) != 0 {
+		t.Errorf("private document content = %q, want the snapshot cleared", content)
+	}
+	if got := h.GetDocument("secret").GetContent(); got != "password=hunter2" {
+		t.Errorf("private document after append = %q, want it unchanged", got)
+	}
+	if content, _ := client.get("docs/notice/content"); string(content) != "read only" {
+		t.Errorf("public document content = %q, want it mirrored", content)
+	}
+	if got := h.GetDocument("notice").GetContent(); got != "read only" {
+		t.Errorf("public document after append = %q, want it unchanged", got)
+	}
+}
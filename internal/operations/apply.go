@@ -22,6 +22,12 @@ func Apply(doc string, op *Operation) (string, error) {
 	case OpRetain:
 		return doc, nil
 
+	case OpFormat:
+		if _, _, err := locate(doc, op); err != nil {
+			return "", err
+		}
+		return doc, nil
+
 	default:
 		return "", fmt.Errorf("unknown operation type: %s", op.Type)
 	}
@@ -29,38 +35,59 @@ func Apply(doc string, op *Operation) (string, error) {
 
 // applyInsert inserts text at the specified position.
 func applyInsert(doc string, op *Operation) (string, error) {
-	docLen := len(doc)
+	docLen := Len(doc)
 
 	if op.Position < 0 || op.Position > docLen {
 		return "", fmt.Errorf("insert position %d out of range [0, %d]", op.Position, docLen)
 	}
+	at, ok := Offset(doc, op.Position)
+	if !ok {
+		return "", fmt.Errorf("%w: %d", ErrSplitsCharacter, op.Position)
+	}
 
-	result := doc[:op.Position] + op.Text + doc[op.Position:]
+	result := doc[:at] + op.Text + doc[at:]
 	return result, nil
 }
 
 // applyDelete removes text at the specified position.
 func applyDelete(doc string, op *Operation) (string, error) {
-	docLen := len(doc)
-	deleteLen := op.Length()
+	start, end, err := locate(doc, op)
+	if err != nil {
+		return "", err
+	}
+	result := doc[:start] + doc[end:]
+	return result, nil
+}
+
+// locate returns the byte offsets of the text a delete or format names,
+// checking it is there.
+func locate(doc string, op *Operation) (int, int, error) {
+	docLen := Len(doc)
+	opLen := op.Length()
 
 	if op.Position < 0 || op.Position >= docLen {
-		return "", fmt.Errorf("delete position %d out of range [0, %d)", op.Position, docLen)
+		return 0, 0, fmt.Errorf("%s position %d out of range [0, %d)", op.Type, op.Position, docLen)
 	}
 
-	if op.Position+deleteLen > docLen {
-		return "", fmt.Errorf("delete range [%d, %d) exceeds document length %d",
-			op.Position, op.Position+deleteLen, docLen)
+	if op.Position+opLen > docLen {
+		return 0, 0, fmt.Errorf("%s range [%d, %d) exceeds document length %d",
+			op.Type, op.Position, op.Position+opLen, docLen)
+	}
+	start, ok := Offset(doc, op.Position)
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: %d", ErrSplitsCharacter, op.Position)
+	}
+	end, ok := Offset(doc, op.Position+opLen)
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: %d", ErrSplitsCharacter, op.Position+opLen)
 	}
 
-	actualText := doc[op.Position : op.Position+deleteLen]
+	actualText := doc[start:end]
 	if actualText != op.Text {
-		return "", fmt.Errorf("delete text mismatch: expected '%s', found '%s'",
-			op.Text, actualText)
+		return 0, 0, fmt.Errorf("%s text mismatch: expected '%s', found '%s'",
+			op.Type, op.Text, actualText)
 	}
-
-	result := doc[:op.Position] + doc[op.Position+deleteLen:]
-	return result, nil
+	return start, end, nil
 }
 
 // ApplyAll applies a sequence of operations to a document.
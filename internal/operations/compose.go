@@ -0,0 +1,73 @@
+package operations
+
+// Compose merges a sequence of operations, each applying after the one
+// before it, into a shorter sequence with the same effect, such as the
+// keystrokes of someone typing a word. Typing that extends an insertion or
+// lands inside it joins it, deletes that meet join, as forward delete and
+// backspace do, and deleting freshly inserted text trims the insertion.
+// Operations that change nothing are dropped. A merged operation carries
+// the version and ID of the latest operation merged into it. The input is
+// not modified.
+func Compose(ops []*Operation) []*Operation {
+	var out []*Operation
+	for _, op := range ops {
+		if op == nil || op.Text == "" || op.Type == OpRetain {
+			continue
+		}
+		next := &Operation{}
+		*next = *op
+		for next != nil && len(out) > 0 {
+			merged, ok := merge(out[len(out)-1], next)
+			if !ok {
+				break
+			}
+			out, next = out[:len(out)-1], merged
+		}
+		if next != nil {
+			out = append(out, next)
+		}
+	}
+	return out
+}
+
+// merge returns one operation with the effect of a followed by b, or nil
+// if they cancel out. It reports false if they cannot be merged.
+func merge(a, b *Operation) (*Operation, bool) {
+	joined := &Operation{Type: a.Type, Position: a.Position, Version: b.Version, ID: b.ID}
+	switch {
+	case a.Type == OpInsert && b.Type == OpInsert:
+		at, ok := Offset(a.Text, b.Position-a.Position)
+		if !ok {
+			return nil, false
+		}
+		joined.Text = a.Text[:at] + b.Text + a.Text[at:]
+
+	case a.Type == OpDelete && b.Type == OpDelete:
+		switch {
+		case b.Position == a.Position:
+			joined.Text = a.Text + b.Text
+		case b.Position+b.Length() == a.Position:
+			joined.Position, joined.Text = b.Position, b.Text+a.Text
+		default:
+			return nil, false
+		}
+
+	case a.Type == OpInsert && b.Type == OpDelete:
+		from, ok := Offset(a.Text, b.Position-a.Position)
+		if !ok {
+			return nil, false
+		}
+		to, ok := Offset(a.Text, b.Position-a.Position+b.Length())
+		if !ok || a.Text[from:to] != b.Text {
+			return nil, false
+		}
+		joined.Text = a.Text[:from] + a.Text[to:]
+		if joined.Text == "" {
+			return nil, true
+		}
+
+	default:
+		return nil, false
+	}
+	return joined, true
+}
@@ -0,0 +1,47 @@
+package operations
+
+import "unicode/utf8"
+
+// Diff returns the operations that turn oldDoc into newDoc: at most one
+// delete followed by one insert, covering the span between the longest
+// common prefix and suffix. Boundaries are kept on rune starts so no
+// operation splits a UTF-8 sequence. Each operation is stamped with the
+// version it applies to, starting at version.
+func Diff(oldDoc, newDoc string, version int) []*Operation {
+	if oldDoc == newDoc {
+		return nil
+	}
+
+	prefix := 0
+	for prefix < len(oldDoc) && prefix < len(newDoc) && oldDoc[prefix] == newDoc[prefix] {
+		prefix++
+	}
+	for prefix > 0 && (!runeStart(oldDoc, prefix) || !runeStart(newDoc, prefix)) {
+		prefix--
+	}
+
+	suffix := 0
+	for suffix < len(oldDoc)-prefix && suffix < len(newDoc)-prefix &&
+		oldDoc[len(oldDoc)-1-suffix] == newDoc[len(newDoc)-1-suffix] {
+		suffix++
+	}
+	for suffix > 0 && (!runeStart(oldDoc, len(oldDoc)-suffix) || !runeStart(newDoc, len(newDoc)-suffix)) {
+		suffix--
+	}
+
+	pos := Len(oldDoc[:prefix])
+	var ops []*Operation
+	if removed := oldDoc[prefix : len(oldDoc)-suffix]; removed != "" {
+		ops = append(ops, NewDeleteOp(pos, removed, version))
+		version++
+	}
+	if added := newDoc[prefix : len(newDoc)-suffix]; added != "" {
+		ops = append(ops, NewInsertOp(pos, added, version))
+	}
+	return ops
+}
+
+// runeStart reports whether i is a rune boundary in s.
+func runeStart(s string, i int) bool {
+	return i == len(s) || utf8.RuneStart(s[i])
+}
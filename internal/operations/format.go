@@ -0,0 +1,224 @@
+package operations
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Attributes a text can carry. An empty value in a format clears the
+// attribute.
+const (
+	AttrBold    = "bold"    // "true"
+	AttrItalic  = "italic"  // "true"
+	AttrLink    = "link"    // The target URL
+	AttrHeading = "heading" // The level, "1" to "6"
+)
+
+// Attributes is formatting by attribute name, such as bold or a link.
+type Attributes map[string]string
+
+// Validate checks that every attribute is known and has a value it allows.
+func (a Attributes) Validate() error {
+	for name, value := range a {
+		switch {
+		case value == "":
+		case name == AttrBold || name == AttrItalic:
+			if value != "true" {
+				return fmt.Errorf("attribute %s must be \"true\", not %q", name, value)
+			}
+		case name == AttrLink:
+			if strings.ContainsFunc(value, func(r rune) bool { return r < ' ' }) {
+				return fmt.Errorf("attribute %s contains control characters", name)
+			}
+		case name == AttrHeading:
+			if len(value) != 1 || value[0] < '1' || value[0] > '6' {
+				return fmt.Errorf("attribute %s must be a level from 1 to 6, not %q", name, value)
+			}
+		default:
+			return fmt.Errorf("unknown attribute: %s", name)
+		}
+	}
+	return nil
+}
+
+// String lists the attributes by name, as name=value.
+func (a Attributes) String() string {
+	pairs := make([]string, 0, len(a))
+	for _, name := range slices.Sorted(maps.Keys(a)) {
+		pairs = append(pairs, name+"="+a[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// merge returns a copy of a with other's attributes set over it, leaving
+// out those other clears.
+func (a Attributes) merge(other Attributes) Attributes {
+	merged := make(Attributes, len(a)+len(other))
+	for name, value := range a {
+		merged[name] = value
+	}
+	for name, value := range other {
+		if value == "" {
+			delete(merged, name)
+		} else {
+			merged[name] = value
+		}
+	}
+	return merged
+}
+
+// without returns a copy of a leaving out the named attributes.
+func (a Attributes) without(names []string) Attributes {
+	out := make(Attributes, len(a))
+	for name, value := range a {
+		if !slices.Contains(names, name) {
+			out[name] = value
+		}
+	}
+	return out
+}
+
+// Span is formatting over a run of text.
+type Span struct {
+	Position   int        `json:"position"` // In UTF-16 code units
+	Length     int        `json:"length"`
+	Attributes Attributes `json:"attributes"`
+}
+
+// Spans is a text's formatting: runs in position order, none overlapping
+// or empty, with unformatted text between them. Neighbouring runs with
+// the same attributes are one span.
+type Spans []Span
+
+// Apply returns the formatting after op: text an insert adds carries its
+// attributes, a delete takes the formatting of its text with it and a
+// format sets its attributes over its range. Like op, it assumes op
+// applies to the text s formats. s is not modified.
+func (s Spans) Apply(op *Operation) Spans {
+	start, n := op.Position, op.Length()
+	end := start + n
+	var out Spans
+	switch op.Type {
+	case OpInsert:
+		for _, sp := range s {
+			switch spEnd := sp.Position + sp.Length; {
+			case spEnd <= start:
+				out = append(out, sp)
+			case sp.Position >= start:
+				out = append(out, Span{sp.Position + n, sp.Length, sp.Attributes})
+			default:
+				out = append(out,
+					Span{sp.Position, start - sp.Position, sp.Attributes},
+					Span{end, spEnd - start, sp.Attributes})
+			}
+		}
+		out = append(out, Span{start, n, Attributes(nil).merge(op.Attributes)})
+
+	case OpDelete:
+		for _, sp := range s {
+			spEnd := sp.Position + sp.Length
+			if sp.Position >= end {
+				sp.Position -= n
+			} else if spEnd > start {
+				sp.Length -= min(spEnd, end) - max(sp.Position, start)
+				sp.Position = min(sp.Position, start)
+			}
+			out = append(out, sp)
+		}
+
+	case OpFormat:
+		at := start // End of the range formatted so far
+		for _, sp := range s {
+			spEnd := sp.Position + sp.Length
+			if spEnd <= start || sp.Position >= end {
+				out = append(out, sp)
+				continue
+			}
+			if sp.Position < start {
+				out = append(out, Span{sp.Position, start - sp.Position, sp.Attributes})
+			}
+			if spEnd > end {
+				out = append(out, Span{end, spEnd - end, sp.Attributes})
+			}
+			from, to := max(sp.Position, start), min(spEnd, end)
+			if from > at {
+				out = append(out, Span{at, from - at, Attributes(nil).merge(op.Attributes)})
+			}
+			out = append(out, Span{from, to - from, sp.Attributes.merge(op.Attributes)})
+			at = to
+		}
+		if at < end {
+			out = append(out, Span{at, end - at, Attributes(nil).merge(op.Attributes)})
+		}
+
+	default:
+		out = append(out, s...)
+	}
+	return out.normalize()
+}
+
+// normalize sorts the spans and drops empty ones, joining neighbours with
+// the same attributes.
+func (s Spans) normalize() Spans {
+	slices.SortFunc(s, func(a, b Span) int { return a.Position - b.Position })
+	var out Spans
+	for _, sp := range s {
+		if sp.Length <= 0 || len(sp.Attributes) == 0 {
+			continue
+		}
+		if last := len(out) - 1; last >= 0 && out[last].Position+out[last].Length == sp.Position && maps.Equal(out[last].Attributes, sp.Attributes) {
+			out[last].Length += sp.Length
+			continue
+		}
+		out = append(out, sp)
+	}
+	return out
+}
+
+// Run is a stretch of text with the same formatting.
+type Run struct {
+	Text       string
+	Attributes Attributes // Nil for unformatted text
+}
+
+// Lines splits text into its lines, each a list of runs formatted as s
+// says, without the newlines. Formatting past the end of text is ignored.
+func (s Spans) Lines(text string) [][]Run {
+	var runs []Run
+	at, pos := 0, 0 // Byte offset and position in text
+	add := func(end int, attrs Attributes) {
+		if end > at {
+			runs = append(runs, Run{text[at:end], attrs})
+			at = end
+		}
+	}
+	for _, sp := range s {
+		start, ok := Offset(text, sp.Position)
+		if !ok || sp.Position < pos {
+			continue
+		}
+		end, ok := Offset(text, sp.Position+sp.Length)
+		if !ok {
+			end = len(text)
+		}
+		add(start, nil)
+		add(end, sp.Attributes)
+		pos = sp.Position + sp.Length
+	}
+	add(len(text), nil)
+
+	lines := [][]Run{nil}
+	for _, r := range runs {
+		for i, part := range strings.Split(r.Text, "\n") {
+			if i > 0 {
+				lines = append(lines, nil)
+			}
+			if part != "" {
+				lines[len(lines)-1] = append(lines[len(lines)-1], Run{part, r.Attributes})
+			}
+		}
+	}
+	return lines
+}
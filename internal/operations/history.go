@@ -0,0 +1,252 @@
+package operations
+
+import "errors"
+
+var (
+	// ErrNothingToUndo is returned by History.Undo when the author has no
+	// edit left that can be undone.
+	ErrNothingToUndo = errors.New("nothing to undo")
+	// ErrNothingToRedo is returned by History.Redo when the author has
+	// undone nothing since their last edit.
+	ErrNothingToRedo = errors.New("nothing to redo")
+	// ErrOverlap is returned by TransformUndo when later edits changed
+	// part of the text an operation reverts.
+	ErrOverlap = errors.New("later edits overlap the change")
+)
+
+// History records the operations applied to a document, and who made
+// them, so that an author can undo and redo their own edits while keeping
+// everyone else's. An undo is the inverse of the author's latest edit
+// transformed past every change made since, so it applies to the current
+// content; a redo is the same for the author's latest undo.
+//
+// Undo and Redo only compute the operation. The caller applies it and
+// reports it with RecordUndo or RecordRedo, which moves the edit between
+// the author's undo and redo stacks. History is not safe for concurrent
+// use.
+type History struct {
+	limit   int
+	first   int     // Sequence number of entries[0]
+	entries []entry // Oldest first
+	undo    stacks  // Per author: sequence numbers of edits that can be undone
+	redo    stacks  // Per author: sequence numbers of undos that can be redone
+}
+
+// entry is one applied operation.
+type entry struct {
+	op      *Operation
+	version int // Version of the document after op
+}
+
+// stacks holds a stack of sequence numbers per author, oldest first.
+type stacks map[string][]int
+
+// NewHistory creates a history that keeps the last limit operations. Edits
+// older than that can no longer be undone.
+func NewHistory(limit int) *History {
+	return &History{limit: limit, undo: make(stacks), redo: make(stacks)}
+}
+
+// Record adds operations applied to the document at version, in order,
+// skipping those that change nothing. author names the client that made
+// them, or is empty for changes nobody can undo, such as the server's.
+// They become the author's latest edits and clear what the author could
+// redo.
+func (h *History) Record(version int, author string, ops ...*Operation) {
+	for _, op := range ops {
+		if op.Text == "" || op.Type == OpRetain {
+			continue
+		}
+		seq := h.add(op, version)
+		if author != "" {
+			h.undo[author] = append(h.undo[author], seq)
+		}
+	}
+	if author != "" {
+		delete(h.redo, author)
+	}
+}
+
+// Undo returns the operation that reverts author's latest edit not yet
+// undone, to apply to the current content, or nil if later changes
+// already reverted it, e.g. by deleting the inserted text.
+func (h *History) Undo(author string) (*Operation, error) {
+	seq, ok := h.undo.top(author)
+	if !ok {
+		return nil, ErrNothingToUndo
+	}
+	return h.revert(seq)
+}
+
+// Redo returns the operation that reverts author's latest undo, or nil if
+// later changes already did; see Undo.
+func (h *History) Redo(author string) (*Operation, error) {
+	seq, ok := h.redo.top(author)
+	if !ok {
+		return nil, ErrNothingToRedo
+	}
+	return h.revert(seq)
+}
+
+// RecordUndo records op, returned by Undo for author and applied at
+// version, so the edit it reverted is no longer undone and the undo can be
+// redone. A nil op, for an edit that could not be undone, drops the edit.
+func (h *History) RecordUndo(version int, author string, op *Operation) {
+	h.undo.pop(author)
+	if op != nil {
+		h.redo[author] = append(h.redo[author], h.add(op, version))
+	}
+}
+
+// RecordRedo is RecordUndo for an operation returned by Redo: the redo can
+// be undone again.
+func (h *History) RecordRedo(version int, author string, op *Operation) {
+	h.redo.pop(author)
+	if op != nil {
+		h.undo[author] = append(h.undo[author], h.add(op, version))
+	}
+}
+
+// Rollback forgets the operations that produced versions after version,
+// and the undos and redos of them.
+func (h *History) Rollback(version int) {
+	n := len(h.entries)
+	for n > 0 && h.entries[n-1].version > version {
+		n--
+	}
+	h.entries = h.entries[:n]
+	h.undo.trim(h.first, h.first+n)
+	h.redo.trim(h.first, h.first+n)
+}
+
+// Reset forgets every operation, so nothing can be undone or redone.
+func (h *History) Reset() {
+	h.first += len(h.entries)
+	h.entries = nil
+	h.undo = make(stacks)
+	h.redo = make(stacks)
+}
+
+// add appends op, dropping the oldest entry past the limit, and returns
+// op's sequence number.
+func (h *History) add(op *Operation, version int) int {
+	if len(h.entries) == h.limit {
+		h.entries = append(h.entries[:0], h.entries[1:]...)
+		h.first++
+		h.undo.trim(h.first, h.first+len(h.entries))
+		h.redo.trim(h.first, h.first+len(h.entries))
+	}
+	h.entries = append(h.entries, entry{op: op, version: version})
+	return h.first + len(h.entries) - 1
+}
+
+// revert returns the inverse of entry seq transformed past every later
+// entry.
+func (h *History) revert(seq int) (*Operation, error) {
+	i := seq - h.first
+	later := make([]*Operation, 0, len(h.entries)-i-1)
+	for _, e := range h.entries[i+1:] {
+		later = append(later, e.op)
+	}
+	ops, err := TransformUndo([]*Operation{h.entries[i].op.Inverse()}, later)
+	if err != nil || len(ops) == 0 {
+		return nil, err
+	}
+	op := ops[0]
+	op.Version = h.entries[len(h.entries)-1].version
+	return op, nil
+}
+
+// top returns the latest sequence number on author's stack.
+func (s stacks) top(author string) (int, bool) {
+	stack := s[author]
+	if len(stack) == 0 {
+		return 0, false
+	}
+	return stack[len(stack)-1], true
+}
+
+// pop removes the latest sequence number from author's stack.
+func (s stacks) pop(author string) {
+	if stack := s[author]; len(stack) > 1 {
+		s[author] = stack[:len(stack)-1]
+	} else {
+		delete(s, author)
+	}
+}
+
+// trim drops sequence numbers outside [first, end) from every stack.
+func (s stacks) trim(first, end int) {
+	for author, stack := range s {
+		lo, hi := 0, len(stack)
+		for lo < hi && stack[lo] < first {
+			lo++
+		}
+		for hi > lo && stack[hi-1] >= end {
+			hi--
+		}
+		if lo == hi {
+			delete(s, author)
+		} else {
+			s[author] = stack[lo:hi]
+		}
+	}
+}
+
+// TransformPast rewrites ops, which apply to the same content as later, so
+// they apply after later instead. Operations later edits made redundant
+// are dropped. Neither input is modified.
+func TransformPast(ops, later []*Operation) ([]*Operation, error) {
+	out, err := transformPast(ops, later, false)
+	return compact(out), err
+}
+
+// TransformUndo is TransformPast for operations that revert an earlier
+// change. It returns ErrOverlap if later edits changed part of the text
+// an operation touches, such as typing inside text the operation deletes,
+// rather than transforming the revert into one that would remove their
+// text too. A deletion whose text later edits removed entirely is dropped.
+func TransformUndo(ops, later []*Operation) ([]*Operation, error) {
+	out, err := transformPast(ops, later, true)
+	return compact(out), err
+}
+
+// transformPast implements TransformPast and, if strict, TransformUndo. It
+// returns one operation per element of ops, nil where one was dropped.
+func transformPast(ops, later []*Operation, strict bool) ([]*Operation, error) {
+	later = append([]*Operation(nil), later...)
+	out := make([]*Operation, len(ops))
+	for i, op := range ops {
+		for j, prior := range later {
+			if prior.Text == "" || prior.Type == OpRetain {
+				continue
+			}
+			next, rest, err := Transform(op, prior)
+			if err != nil {
+				return nil, err
+			}
+			if strict && next.Text != op.Text && (next.Text != "" || op.Type != OpDelete) {
+				return nil, ErrOverlap
+			}
+			op, later[j] = next, rest
+			if op.Text == "" {
+				break
+			}
+		}
+		if op.Text != "" {
+			out[i] = op
+		}
+	}
+	return out, nil
+}
+
+// compact returns ops without its nil elements.
+func compact(ops []*Operation) []*Operation {
+	var out []*Operation
+	for _, op := range ops {
+		if op != nil {
+			out = append(out, op)
+		}
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package operations
+
+import "strings"
+
+// LineChange describes the line range an operation touched, so that
+// line-oriented consumers (linters, syntax highlighters) can re-process only
+// the affected region. Lines are zero-based.
+type LineChange struct {
+	StartLine int `json:"start_line"`
+	OldLines  int `json:"old_lines"` // Lines spanned by the region before the change
+	NewLines  int `json:"new_lines"` // Lines spanned by the region after the change
+}
+
+// LinesChanged computes the line range affected by op. The content may be
+// taken either before or after op was applied, since only the text ahead of
+// op.Position is inspected and no operation changes it. A format changes
+// the lines of the text it formats.
+func LinesChanged(content string, op *Operation) LineChange {
+	pos, ok := Offset(content, op.Position)
+	if !ok {
+		pos = len(content)
+	}
+
+	change := LineChange{
+		StartLine: strings.Count(content[:pos], "\n"),
+		OldLines:  1,
+		NewLines:  1,
+	}
+
+	switch op.Type {
+	case OpInsert:
+		change.NewLines += strings.Count(op.Text, "\n")
+	case OpDelete:
+		change.OldLines += strings.Count(op.Text, "\n")
+	case OpFormat:
+		change.OldLines += strings.Count(op.Text, "\n")
+		change.NewLines = change.OldLines
+	}
+
+	return change
+}
@@ -12,15 +12,20 @@ const (
 	OpInsert OpType = "insert" // Insert text at position
 	OpDelete OpType = "delete" // Delete text at position
 	OpRetain OpType = "retain" // Retain text (for composition)
+	OpFormat OpType = "format" // Set attributes on the text at position
 )
 
 // Operation represents a text editing operation in OT.
 // Operations can be transformed against each other for conflict resolution.
+// A format carries the text it formats, as a delete carries the text it
+// removes, and leaves the text itself unchanged; Spans tracks the result.
 type Operation struct {
-	Type     OpType `json:"type"`
-	Position int    `json:"position"`
-	Text     string `json:"text,omitempty"`
-	Version  int    `json:"version"`
+	Type       OpType     `json:"type"`
+	Position   int        `json:"position"` // In UTF-16 code units; see Len
+	Text       string     `json:"text,omitempty"`
+	Attributes Attributes `json:"attributes,omitempty"` // Of inserted text, or set by a format
+	Version    int        `json:"version"`
+	ID         string     `json:"id,omitempty"` // Client-chosen; the server applies each ID once
 }
 
 // NewInsertOp creates a new insert operation.
@@ -43,6 +48,18 @@ func NewDeleteOp(position int, text string, version int) *Operation {
 	}
 }
 
+// NewFormatOp creates a format operation setting attributes on text, the
+// text at position.
+func NewFormatOp(position int, text string, attributes Attributes, version int) *Operation {
+	return &Operation{
+		Type:       OpFormat,
+		Position:   position,
+		Text:       text,
+		Attributes: attributes,
+		Version:    version,
+	}
+}
+
 // String returns a human-readable representation of the operation.
 func (op *Operation) String() string {
 	switch op.Type {
@@ -51,7 +68,9 @@ func (op *Operation) String() string {
 	case OpDelete:
 		return fmt.Sprintf("Delete('%s' at %d, v%d)", op.Text, op.Position, op.Version)
 	case OpRetain:
-		return fmt.Sprintf("Retain(%d chars at %d, v%d)", len(op.Text), op.Position, op.Version)
+		return fmt.Sprintf("Retain(%d chars at %d, v%d)", op.Length(), op.Position, op.Version)
+	case OpFormat:
+		return fmt.Sprintf("Format('%s' at %d with %s, v%d)", op.Text, op.Position, op.Attributes, op.Version)
 	default:
 		return fmt.Sprintf("Unknown operation")
 	}
@@ -92,9 +111,22 @@ func (op *Operation) Validate() error {
 		}
 	case OpRetain:
 		// Retain is valid with empty text
+	case OpFormat:
+		if op.Text == "" {
+			return fmt.Errorf("format operation must have non-empty text")
+		}
+		if len(op.Attributes) == 0 {
+			return fmt.Errorf("format operation must set attributes")
+		}
 	default:
 		return fmt.Errorf("unknown operation type: %s", op.Type)
 	}
+	if len(op.Attributes) > 0 && op.Type != OpInsert && op.Type != OpFormat {
+		return fmt.Errorf("%s operation cannot have attributes", op.Type)
+	}
+	if err := op.Attributes.Validate(); err != nil {
+		return err
+	}
 
 	if op.Version < 0 {
 		return fmt.Errorf("invalid version: %d (must be >= 0)", op.Version)
@@ -103,7 +135,28 @@ func (op *Operation) Validate() error {
 	return nil
 }
 
-// Length returns the number of characters affected by this operation.
+// Length returns the number of UTF-16 code units affected by this
+// operation.
 func (op *Operation) Length() int {
-	return len(op.Text)
+	return Len(op.Text)
+}
+
+// Inverse returns the operation that undoes op on the document op produced:
+// a delete of the inserted text or an insert of the deleted text. A
+// format does not record the attributes it replaced, so its inverse clears
+// those it set; a deleted text's inverse inserts it unformatted.
+func (op *Operation) Inverse() *Operation {
+	inv := &Operation{Type: op.Type, Position: op.Position, Text: op.Text, Version: op.Version}
+	switch op.Type {
+	case OpInsert:
+		inv.Type = OpDelete
+	case OpDelete:
+		inv.Type = OpInsert
+	case OpFormat:
+		inv.Attributes = make(Attributes, len(op.Attributes))
+		for name := range op.Attributes {
+			inv.Attributes[name] = ""
+		}
+	}
+	return inv
 }
@@ -1,7 +1,10 @@
 package operations
 
 import (
+	"errors"
+	"reflect"
 	"testing"
+	"unicode/utf8"
 )
 
 // TestOperationCreation verifies operation constructor functions.
@@ -332,6 +335,20 @@ func TestTransformInsertDelete(t *testing.T) {
 			delete:  NewDeleteOp(0, "ab", 1), // delete "ab" at 0 -> "cd"
 			wantDoc: "cXd",
 		},
+		{
+			name:    "insert at end of delete",
+			doc:     "abcd",
+			insert:  NewInsertOp(3, "X", 1),  // insert X at 3 -> "abcXd"
+			delete:  NewDeleteOp(1, "bc", 1), // delete "bc" at 1 -> "ad"
+			wantDoc: "aXd",
+		},
+		{
+			name:    "insert inside delete",
+			doc:     "abcdef",
+			insert:  NewInsertOp(3, "XY", 1),   // insert XY at 3 -> "abcXYdef"
+			delete:  NewDeleteOp(1, "bcde", 1), // delete "bcde" at 1 -> "af"
+			wantDoc: "af",
+		},
 	}
 
 	for _, tt := range tests {
@@ -350,7 +367,10 @@ func TestTransformInsertDelete(t *testing.T) {
 
 			// Order 2: delete, then insert'
 			doc2, _ := Apply(tt.doc, tt.delete)
-			result2, _ := Apply(doc2, insertPrime)
+			result2 := doc2 // insert' is a no-op if deleted with the text around it
+			if insertPrime.Text != "" {
+				result2, _ = Apply(doc2, insertPrime)
+			}
 
 			if result1 != result2 {
 				t.Errorf("Results don't converge: '%v' != '%v'", result1, result2)
@@ -467,3 +487,452 @@ func TestJSONSerialization(t *testing.T) {
 		t.Errorf("Deserialized operation doesn't match: got %+v, want %+v", op2, op)
 	}
 }
+
+// TestLinesChanged verifies line ranges derived from operations.
+func TestLinesChanged(t *testing.T) {
+	content := "line0\nline1\nline2"
+
+	tests := []struct {
+		name string
+		op   *Operation
+		want LineChange
+	}{
+		{
+			name: "insert within line",
+			op:   NewInsertOp(8, "x", 0),
+			want: LineChange{StartLine: 1, OldLines: 1, NewLines: 1},
+		},
+		{
+			name: "insert new lines",
+			op:   NewInsertOp(0, "a\nb\n", 0),
+			want: LineChange{StartLine: 0, OldLines: 1, NewLines: 3},
+		},
+		{
+			name: "delete joining lines",
+			op:   NewDeleteOp(5, "\nline1\n", 0),
+			want: LineChange{StartLine: 0, OldLines: 3, NewLines: 1},
+		},
+		{
+			name: "position past end",
+			op:   NewInsertOp(100, "z", 0),
+			want: LineChange{StartLine: 2, OldLines: 1, NewLines: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LinesChanged(content, tt.op); got != tt.want {
+				t.Errorf("LinesChanged() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiff verifies generated operations rebuild the new document without
+// splitting multi-byte runes.
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldDoc  string
+		newDoc  string
+		wantOps int
+	}{
+		{name: "identical", oldDoc: "same", newDoc: "same", wantOps: 0},
+		{name: "append", oldDoc: "hello", newDoc: "hello world", wantOps: 1},
+		{name: "delete middle", oldDoc: "hello cruel world", newDoc: "hello world", wantOps: 1},
+		{name: "replace word", oldDoc: "the cat sat", newDoc: "the dog sat", wantOps: 2},
+		{name: "from empty", oldDoc: "", newDoc: "new", wantOps: 1},
+		{name: "to empty", oldDoc: "old", newDoc: "", wantOps: 1},
+		{name: "shared lead byte", oldDoc: "a世b", newDoc: "a丗b", wantOps: 2},
+		{name: "after an emoji", oldDoc: "😀 hi", newDoc: "😀 ho", wantOps: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := Diff(tt.oldDoc, tt.newDoc, 3)
+			if len(ops) != tt.wantOps {
+				t.Fatalf("Diff() returned %d ops, want %d: %v", len(ops), tt.wantOps, ops)
+			}
+
+			doc := tt.oldDoc
+			for i, op := range ops {
+				if op.Version != 3+i {
+					t.Errorf("op %d version = %d, want %d", i, op.Version, 3+i)
+				}
+				if !utf8.ValidString(op.Text) {
+					t.Errorf("op %d splits a rune: %q", i, op.Text)
+				}
+				var err error
+				if doc, err = Apply(doc, op); err != nil {
+					t.Fatalf("Apply(%s) error: %v", op, err)
+				}
+			}
+			if doc != tt.newDoc {
+				t.Errorf("applied diff = %q, want %q", doc, tt.newDoc)
+			}
+		})
+	}
+}
+
+// TestUnicodePositions verifies positions count UTF-16 code units, as
+// JavaScript does: one per BMP character such as CJK, two per emoji.
+func TestUnicodePositions(t *testing.T) {
+	const doc = "日本😀語"
+
+	if got := Len(doc); got != 5 {
+		t.Errorf("Len(%q) = %d, want 5", doc, got)
+	}
+	if got := NewInsertOp(0, "👍🏽", 0).Length(); got != 4 {
+		t.Errorf("Length() of a toned emoji = %d, want 4", got)
+	}
+
+	applyTests := []struct {
+		name string
+		op   *Operation
+		want string
+	}{
+		{"insert after CJK", NewInsertOp(2, "x", 0), "日本x😀語"},
+		{"insert after an emoji", NewInsertOp(4, "x", 0), "日本😀x語"},
+		{"insert at end", NewInsertOp(5, "!", 0), "日本😀語!"},
+		{"delete an emoji", NewDeleteOp(2, "😀", 0), "日本語"},
+		{"delete CJK after an emoji", NewDeleteOp(4, "語", 0), "日本😀"},
+	}
+	for _, tt := range applyTests {
+		got, err := Apply(doc, tt.op)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Apply() = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	for _, op := range []*Operation{NewInsertOp(3, "x", 0), NewDeleteOp(3, "語", 0)} {
+		if _, err := Apply(doc, op); !errors.Is(err, ErrSplitsCharacter) {
+			t.Errorf("Apply(%s) error = %v, want ErrSplitsCharacter", op, err)
+		}
+	}
+	if _, err := Apply(doc, NewInsertOp(6, "x", 0)); err == nil {
+		t.Error("Apply() accepted an insert past the end")
+	}
+
+	transformTests := []struct {
+		name     string
+		op1, op2 *Operation
+	}{
+		{"emoji before insert", NewInsertOp(0, "🎉", 0), NewInsertOp(2, "x", 0)},
+		{"insert after a delete", NewDeleteOp(1, "本😀", 0), NewInsertOp(5, "🎉", 0)},
+		{"delete after a delete", NewDeleteOp(0, "日", 0), NewDeleteOp(2, "😀", 0)},
+	}
+	for _, tt := range transformTests {
+		op1Prime, op2Prime, err := Transform(tt.op1, tt.op2)
+		if err != nil {
+			t.Fatalf("%s: Transform() error: %v", tt.name, err)
+		}
+		left, err := ApplyAll(doc, []*Operation{tt.op1, op2Prime})
+		if err != nil {
+			t.Fatalf("%s: applying op1 then op2' error: %v", tt.name, err)
+		}
+		right, err := ApplyAll(doc, []*Operation{tt.op2, op1Prime})
+		if err != nil {
+			t.Fatalf("%s: applying op2 then op1' error: %v", tt.name, err)
+		}
+		if left != right {
+			t.Errorf("%s: results diverge: %q vs %q", tt.name, left, right)
+		}
+	}
+
+	// The overlap is trimmed by code units, keeping whole characters.
+	_, op2Prime, err := Transform(NewDeleteOp(0, "日本😀", 0), NewDeleteOp(2, "😀語", 0))
+	if err != nil || op2Prime.Position != 0 || op2Prime.Text != "語" {
+		t.Errorf("Transform() of overlapping deletes = %v, %v; want Delete('語' at 0)", op2Prime, err)
+	}
+}
+
+// TestHistory verifies each author undoes and redoes only their own edits,
+// transformed past everything edited since.
+func TestHistory(t *testing.T) {
+	doc := "hello"
+	h := NewHistory(100)
+	version := 0
+	edit := func(author string, op *Operation) {
+		t.Helper()
+		var err error
+		if doc, err = Apply(doc, op); err != nil {
+			t.Fatalf("Apply(%s) error: %v", op, err)
+		}
+		version++
+		h.Record(version, author, op)
+	}
+	step := func(op *Operation, err error, record func(int, string, *Operation), author, want string) {
+		t.Helper()
+		if err != nil || op == nil {
+			t.Fatalf("%s: got %v, %v", author, op, err)
+		}
+		if op.Version != version {
+			t.Errorf("%s: operation version = %d, want %d", author, op.Version, version)
+		}
+		if doc, err = Apply(doc, op); err != nil {
+			t.Fatalf("Apply(%s) error: %v", op, err)
+		}
+		version++
+		record(version, author, op)
+		if doc != want {
+			t.Errorf("%s: content = %q, want %q", author, doc, want)
+		}
+	}
+
+	edit("alice", NewInsertOp(5, " world", 0))
+	edit("bob", NewInsertOp(0, ">> ", 1))
+
+	op, err := h.Undo("alice")
+	step(op, err, h.RecordUndo, "alice", ">> hello")
+	if _, err := h.Undo("alice"); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("second Undo() error = %v, want ErrNothingToUndo", err)
+	}
+	op, err = h.Redo("alice")
+	step(op, err, h.RecordRedo, "alice", ">> hello world")
+	if _, err := h.Redo("alice"); !errors.Is(err, ErrNothingToRedo) {
+		t.Errorf("second Redo() error = %v, want ErrNothingToRedo", err)
+	}
+	op, err = h.Undo("bob")
+	step(op, err, h.RecordUndo, "bob", "hello world")
+
+	// A new edit clears what can be redone.
+	edit("bob", NewInsertOp(0, "> ", version))
+	if _, err := h.Redo("bob"); !errors.Is(err, ErrNothingToRedo) {
+		t.Errorf("Redo() after an edit error = %v, want ErrNothingToRedo", err)
+	}
+
+	// An edit someone else already removed has nothing left to revert.
+	edit("carol", NewDeleteOp(7, " world", version))
+	if op, err := h.Undo("alice"); op != nil || err != nil {
+		t.Errorf("Undo() of a removed edit = %v, %v; want nil", op, err)
+	}
+	h.RecordUndo(version, "alice", nil)
+	if _, err := h.Undo("alice"); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("Undo() after dropping error = %v, want ErrNothingToUndo", err)
+	}
+
+	// An edit others changed part of conflicts rather than taking their
+	// text with it.
+	edit("dave", NewInsertOp(7, " there", version))
+	edit("erin", NewInsertOp(10, "X", version))
+	if op, err := h.Undo("dave"); !errors.Is(err, ErrOverlap) {
+		t.Errorf("Undo() of an overlapped edit = %v, %v; want ErrOverlap", op, err)
+	}
+
+	// Edits past the limit, or rolled back, are forgotten.
+	small := NewHistory(1)
+	small.Record(1, "alice", NewInsertOp(0, "a", 0))
+	small.Record(2, "bob", NewInsertOp(0, "b", 1))
+	if _, err := small.Undo("alice"); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("Undo() past the limit error = %v, want ErrNothingToUndo", err)
+	}
+	small.Rollback(1)
+	if _, err := small.Undo("bob"); !errors.Is(err, ErrNothingToUndo) {
+		t.Errorf("Undo() after Rollback error = %v, want ErrNothingToUndo", err)
+	}
+}
+
+// TestRebase verifies a client branch rebased past a server branch
+// produces the same content as the server branch rebased past the client's.
+func TestRebase(t *testing.T) {
+	base := "abc"
+	client := []*Operation{
+		{Type: OpInsert, Position: 1, Text: "X", Version: 0, ID: "c1"}, // aXbc
+		{Type: OpDelete, Position: 3, Text: "c", Version: 1, ID: "c2"}, // aXb
+		{Type: OpDelete, Position: 0, Text: "a", Version: 2, ID: "c3"}, // Xb
+	}
+	server := []*Operation{
+		NewDeleteOp(0, "a", 0), // bc
+		NewInsertOp(2, "Y", 1), // bcY
+	}
+
+	rebased, err := Rebase(client, server)
+	if err != nil {
+		t.Fatalf("Rebase() error: %v", err)
+	}
+	got, err := ApplyAll(base, append(append([]*Operation(nil), server...), rebased...))
+	if err != nil {
+		t.Fatalf("applying rebased client branch: %v", err)
+	}
+	serverRebased, err := Rebase(server, client)
+	if err != nil {
+		t.Fatalf("Rebase() of server branch error: %v", err)
+	}
+	want, err := ApplyAll(base, append(append([]*Operation(nil), client...), serverRebased...))
+	if err != nil {
+		t.Fatalf("applying rebased server branch: %v", err)
+	}
+	if got != "XbY" || got != want {
+		t.Errorf("content = %q and %q, want %q", got, want, "XbY")
+	}
+
+	// The deletion the server already made is dropped; the rest keep
+	// their IDs and move past the server's versions.
+	if len(rebased) != 2 || rebased[0].ID != "c1" || rebased[1].ID != "c2" {
+		t.Fatalf("rebased = %v, want c1 and c2", rebased)
+	}
+	if rebased[0].Version != 2 || rebased[1].Version != 3 {
+		t.Errorf("versions = %d, %d; want 2, 3", rebased[0].Version, rebased[1].Version)
+	}
+	if client[0].Position != 1 || client[0].Version != 0 {
+		t.Errorf("Rebase() modified its input: %v", client[0])
+	}
+
+	if _, err := Rebase([]*Operation{nil}, server); err == nil {
+		t.Error("nil client operation accepted")
+	}
+	if _, err := Rebase(client, []*Operation{{Type: OpDelete, Position: -1, Text: "a"}}); err == nil {
+		t.Error("invalid server operation accepted")
+	}
+}
+
+// TestCompose verifies composed sequences are shorter and have the same
+// effect as the operations they replace.
+func TestCompose(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		ops  []*Operation
+		want int // Operations left after composing
+	}{
+		{"typing", "", []*Operation{NewInsertOp(0, "h", 0), NewInsertOp(1, "i", 1), NewInsertOp(2, "😀", 2)}, 1},
+		{"typing inside an insertion", "", []*Operation{NewInsertOp(0, "hllo", 0), NewInsertOp(1, "e", 1)}, 1},
+		{"backspace", "hello", []*Operation{NewDeleteOp(4, "o", 0), NewDeleteOp(3, "l", 1), NewDeleteOp(2, "l", 2)}, 1},
+		{"forward delete", "hello", []*Operation{NewDeleteOp(0, "h", 0), NewDeleteOp(0, "e", 1)}, 1},
+		{"fixing a typo", "", []*Operation{NewInsertOp(0, "teh", 0), NewDeleteOp(1, "eh", 1), NewInsertOp(1, "he", 2)}, 1},
+		{"typing undone", "x", []*Operation{NewInsertOp(1, "ab", 0), NewDeleteOp(1, "ab", 1)}, 0},
+		{"apart", "hello", []*Operation{NewInsertOp(0, "a", 0), NewInsertOp(6, "b", 1), NewDeleteOp(1, "h", 2)}, 3},
+		{"nothing changed", "hello", []*Operation{{Type: OpRetain, Position: 0, Text: "he"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := ApplyAll(tt.doc, tt.ops)
+			if err != nil {
+				t.Fatalf("ApplyAll() error: %v", err)
+			}
+			composed := Compose(tt.ops)
+			got, err := ApplyAll(tt.doc, composed)
+			if err != nil || got != want {
+				t.Errorf("composed = %v gives %q, %v; want %q", composed, got, err, want)
+			}
+			if len(composed) != tt.want {
+				t.Errorf("len(Compose()) = %d, want %d", len(composed), tt.want)
+			}
+		})
+	}
+
+	ops := []*Operation{NewInsertOp(0, "a", 1), NewInsertOp(1, "b", 2)}
+	ops[1].ID = "op-2"
+	if composed := Compose(ops); composed[0].Version != 2 || composed[0].ID != "op-2" {
+		t.Errorf("composed = %+v, want the latest version and ID", composed[0])
+	}
+	if ops[0].Text != "a" {
+		t.Errorf("Compose() modified its input: %v", ops[0])
+	}
+}
+
+// TestFormat verifies formats leave the text alone, set their attributes
+// on the spans, and converge with concurrent edits and formats whichever
+// applies first.
+func TestFormat(t *testing.T) {
+	bold := Attributes{AttrBold: "true"}
+	plain := Attributes{AttrBold: ""}
+	italic := Attributes{AttrItalic: "true"}
+
+	for _, op := range []*Operation{
+		NewFormatOp(0, "", bold, 0),
+		NewFormatOp(0, "he", nil, 0),
+		NewFormatOp(0, "he", Attributes{"color": "red"}, 0),
+		NewFormatOp(0, "he", Attributes{AttrHeading: "7"}, 0),
+		{Type: OpDelete, Position: 0, Text: "he", Attributes: bold},
+	} {
+		if err := op.Validate(); err == nil {
+			t.Errorf("Validate(%v) succeeded", op)
+		}
+	}
+	if got, err := Apply("hello", NewFormatOp(1, "ell", bold, 0)); err != nil || got != "hello" {
+		t.Errorf("Apply(format) = %q, %v; want the text unchanged", got, err)
+	}
+	if _, err := Apply("hello", NewFormatOp(1, "elk", bold, 0)); err == nil {
+		t.Error("Apply(format) of text that is not there succeeded")
+	}
+
+	spans := Spans{}.Apply(NewFormatOp(1, "ell", bold, 0))
+	spans = spans.Apply(NewInsertOp(2, "XY", 1))
+	spans = spans.Apply(NewFormatOp(0, "hel", italic, 2))
+	spans = spans.Apply(NewDeleteOp(2, "XY", 3))
+	want := Spans{{0, 1, italic}, {1, 1, Attributes{AttrBold: "true", AttrItalic: "true"}}, {2, 2, bold}}
+	if !reflect.DeepEqual(spans, want) {
+		t.Errorf("spans = %v, want %v", spans, want)
+	}
+
+	doc := "hello world"
+	tests := []struct {
+		name     string
+		op1, op2 *Operation
+	}{
+		{"insert inside", NewFormatOp(0, "hello", bold, 0), NewInsertOp(2, "XY", 0)},
+		{"insert at the start", NewFormatOp(6, "world", bold, 0), NewInsertOp(6, "big ", 0)},
+		{"insert at the end", NewInsertOp(5, "!", 0), NewFormatOp(0, "hello", bold, 0)},
+		{"overlapping delete", NewDeleteOp(3, "lo wo", 0), NewFormatOp(0, "hello", bold, 0)},
+		{"delete of the range", NewFormatOp(0, "hello", bold, 0), NewDeleteOp(0, "hello ", 0)},
+		{"different attributes", NewFormatOp(0, "hello", bold, 0), NewFormatOp(3, "lo wo", italic, 0)},
+		{"inside a conflicting format", NewFormatOp(2, "llo", bold, 0), NewFormatOp(0, "hello world", plain, 0)},
+		{"around a conflicting format", NewFormatOp(0, "hello world", bold, 0), NewFormatOp(2, "llo", plain, 0)},
+		{"partly overlapping conflicts", NewFormatOp(0, "hello", bold, 0), NewFormatOp(3, "lo wo", plain, 0)},
+		{"partly overlapping conflicts and more", NewFormatOp(0, "hello", Attributes{AttrBold: "true", AttrItalic: "true"}, 0), NewFormatOp(3, "lo wo", plain, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op1, op2, err := Transform(tt.op1, tt.op2)
+			if err != nil {
+				t.Fatalf("Transform() error: %v", err)
+			}
+			apply := func(first, second *Operation) (string, Spans) {
+				text, spans := doc, Spans{{0, 2, italic}}
+				for _, op := range []*Operation{first, second} {
+					if op.Text == "" {
+						continue
+					}
+					if text, err = Apply(text, op); err != nil {
+						t.Fatalf("Apply(%v) error: %v", op, err)
+					}
+					spans = spans.Apply(op)
+				}
+				return text, spans
+			}
+			text1, spans1 := apply(tt.op1, op2)
+			text2, spans2 := apply(tt.op2, op1)
+			if text1 != text2 || !reflect.DeepEqual(spans1, spans2) {
+				t.Errorf("op1 first gives %q %v, op2 first %q %v", text1, spans1, text2, spans2)
+			}
+		})
+	}
+
+	if inv := NewFormatOp(0, "he", bold, 3).Inverse(); inv.Type != OpFormat || inv.Attributes[AttrBold] != "" || len(inv.Attributes) != 1 {
+		t.Errorf("Inverse() = %v, want a format clearing bold", inv)
+	}
+}
+
+// TestLines verifies text splits into lines of runs by its formatting,
+// counting positions in UTF-16 units.
+func TestLines(t *testing.T) {
+	bold := Attributes{AttrBold: "true"}
+	heading := Attributes{AttrHeading: "1"}
+	tests := []struct {
+		text  string
+		spans Spans
+		want  [][]Run
+	}{
+		{"", nil, [][]Run{nil}},
+		{"Title\nhello world", Spans{{0, 5, heading}, {12, 5, bold}}, [][]Run{{{"Title", heading}}, {{"hello ", nil}, {"world", bold}}}},
+		{"a\n\nb", Spans{{0, 4, bold}}, [][]Run{{{"a", bold}}, nil, {{"b", bold}}}},
+		{"😀ab", Spans{{2, 1, bold}}, [][]Run{{{"😀", nil}, {"a", bold}, {"b", nil}}}},
+		{"ab", Spans{{0, 100, bold}}, [][]Run{{{"ab", bold}}}},
+	}
+	for _, tt := range tests {
+		if got := tt.spans.Lines(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Lines(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
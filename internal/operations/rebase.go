@@ -0,0 +1,54 @@
+package operations
+
+import "fmt"
+
+// Rebase transforms a branch of client operations past a branch of server
+// operations, so the client's edits apply to the content the server's
+// produced. Both branches start from the same content and each operation
+// applies after the one before it in its branch, as when a client edits
+// offline or a fork is merged back. Every client operation is transformed
+// past every server operation, and the server branch is transformed past
+// each client operation in turn, so later client operations meet the
+// server's edits as they stand after the client's earlier ones.
+//
+// Concurrent insertions at the same position put the client's text first,
+// as the hub does for an operation it receives. Rebased operations keep
+// their IDs, and their versions advance by one per server operation.
+// Client operations that change nothing or that the server branch made
+// redundant, such as deleting text it already deleted, are dropped. Neither
+// input is modified.
+func Rebase(clientOps, serverOps []*Operation) ([]*Operation, error) {
+	for i, op := range clientOps {
+		if op == nil {
+			return nil, fmt.Errorf("client operation %d is nil", i)
+		}
+	}
+	for i, op := range serverOps {
+		if op == nil {
+			return nil, fmt.Errorf("server operation %d is nil", i)
+		}
+	}
+
+	var ops []*Operation
+	for _, op := range clientOps {
+		if op.Text != "" && op.Type != OpRetain {
+			ops = append(ops, op)
+		}
+	}
+	rebased, err := transformPast(ops, serverOps, false)
+	if err != nil {
+		return nil, fmt.Errorf("rebasing client branch: %w", err)
+	}
+
+	out := make([]*Operation, 0, len(rebased))
+	for i, op := range rebased {
+		if op == nil {
+			continue
+		}
+		c := *op // Unchanged operations are the caller's
+		c.ID = ops[i].ID
+		c.Version = ops[i].Version + len(serverOps)
+		out = append(out, &c)
+	}
+	return out, nil
+}
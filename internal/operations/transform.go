@@ -23,16 +23,18 @@ func Transform(op1, op2 *Operation) (*Operation, *Operation, error) {
 	}
 
 	op1Prime := &Operation{
-		Type:     op1.Type,
-		Position: op1.Position,
-		Text:     op1.Text,
-		Version:  op1.Version + 1,
+		Type:       op1.Type,
+		Position:   op1.Position,
+		Text:       op1.Text,
+		Attributes: op1.Attributes,
+		Version:    op1.Version + 1,
 	}
 	op2Prime := &Operation{
-		Type:     op2.Type,
-		Position: op2.Position,
-		Text:     op2.Text,
-		Version:  op2.Version + 1,
+		Type:       op2.Type,
+		Position:   op2.Position,
+		Text:       op2.Text,
+		Attributes: op2.Attributes,
+		Version:    op2.Version + 1,
 	}
 
 	switch {
@@ -44,6 +46,12 @@ func Transform(op1, op2 *Operation) (*Operation, *Operation, error) {
 		transformDeleteInsert(op1Prime, op2Prime)
 	case op1.Type == OpDelete && op2.Type == OpDelete:
 		transformDeleteDelete(op1Prime, op2Prime)
+	case op1.Type == OpFormat && op2.Type == OpFormat:
+		transformFormatFormat(op1Prime, op2Prime)
+	case op1.Type == OpFormat && op2.Type != OpRetain:
+		transformFormat(op1Prime, op2Prime)
+	case op2.Type == OpFormat && op1.Type != OpRetain:
+		transformFormat(op2Prime, op1Prime)
 	default:
 		return nil, nil, fmt.Errorf("unsupported operation type combination: %s vs %s", op1.Type, op2.Type)
 	}
@@ -65,11 +73,18 @@ func transformInsertInsert(op1, op2 *Operation) {
 
 // transformInsertDelete adjusts insert and delete operations.
 // Inserts at or before delete position shift the delete right.
+// An insert strictly inside the deleted text is deleted with it: the
+// delete takes in the inserted text and the insert becomes a no-op, as a
+// single delete cannot skip over the insert.
 func transformInsertDelete(insert, delete *Operation) {
+	end := delete.Position + delete.Length()
 	if insert.Position <= delete.Position {
 		delete.Position += insert.Length()
-	} else if insert.Position > delete.Position+delete.Length() {
+	} else if insert.Position >= end {
 		insert.Position -= delete.Length()
+	} else if at, ok := Offset(delete.Text, insert.Position-delete.Position); ok {
+		delete.Text = delete.Text[:at] + insert.Text + delete.Text[at:]
+		insert.Text = ""
 	} else {
 		insert.Position = delete.Position
 	}
@@ -81,8 +96,9 @@ func transformDeleteInsert(delete, insert *Operation) {
 }
 
 // transformDeleteDelete handles two concurrent delete operations.
-// Adjusts positions for non-overlapping deletes and handles overlaps
-// by marking redundant portions as empty.
+// Adjusts positions for non-overlapping deletes. Overlapping deletes each
+// drop the text the other removes, marking fully redundant ones as empty,
+// and start where the first of them did.
 func transformDeleteDelete(op1, op2 *Operation) {
 	op1Start := op1.Position
 	op1End := op1.Position + op1.Length()
@@ -99,29 +115,119 @@ func transformDeleteDelete(op1, op2 *Operation) {
 		return
 	}
 
-	if op1Start == op2Start && op1End == op2End {
-		op1.Text = ""
-		op2.Text = ""
+	start, end := max(op1Start, op2Start), min(op1End, op2End)
+	op1.Text = cut(op1.Text, start-op1Start, end-op1Start)
+	op2.Text = cut(op2.Text, start-op2Start, end-op2Start)
+	op1.Position = min(op1Start, op2Start)
+	op2.Position = op1.Position
+}
+
+// transformFormat adjusts a format and a concurrent insert or delete; the
+// other operation is left as it is, since a format does not change text.
+// A format moves with the text it formats and loses any of it the delete
+// removes. Text inserted strictly inside the formatted range is formatted
+// too: the format takes it in and the insert takes on the format's
+// attributes, so it ends up formatted whichever applies first.
+func transformFormat(format, other *Operation) {
+	start := format.Position
+	end := start + format.Length()
+	otherStart := other.Position
+	otherEnd := otherStart + other.Length()
+
+	if other.Type == OpInsert {
+		if otherStart <= start {
+			format.Position += other.Length()
+		} else if otherStart < end {
+			if at, ok := Offset(format.Text, otherStart-start); ok {
+				format.Text = format.Text[:at] + other.Text + format.Text[at:]
+				other.Attributes = other.Attributes.merge(format.Attributes)
+			}
+		}
 		return
 	}
 
-	if op2Start < op1Start {
-		overlap := min(op1End, op2End) - op1Start
-		op1.Position = op2Start
-		if overlap > 0 && overlap < op1.Length() {
-			op1.Text = op1.Text[overlap:]
-		} else if overlap >= op1.Length() {
-			op1.Text = ""
+	if otherEnd <= start {
+		format.Position -= other.Length()
+	} else if otherStart < end {
+		format.Text = cut(format.Text, max(start, otherStart)-start, min(end, otherEnd)-start)
+		format.Position = min(start, otherStart)
+	}
+}
+
+// transformFormatFormat resolves two concurrent formats. Attributes they
+// both set to different values over text they both cover are conflicts:
+// the format whose range lies inside the other's gives them up, or else,
+// for ranges that only partly overlap, the one that sets nothing else,
+// op1 if both do, gives up the shared text. If both set other attributes
+// too, no single operation expresses that, and op1 gives the conflicts up
+// over its whole range: replicas that applied op2 first then keep the
+// earlier values over the text only op1 covers.
+func transformFormatFormat(op1, op2 *Operation) {
+	start1, end1 := op1.Position, op1.Position+op1.Length()
+	start2, end2 := op2.Position, op2.Position+op2.Length()
+	if end1 <= start2 || end2 <= start1 {
+		return
+	}
+	var conflicts []string
+	for name, value := range op1.Attributes {
+		if other, ok := op2.Attributes[name]; ok && other != value {
+			conflicts = append(conflicts, name)
 		}
-	} else {
-		overlap := min(op1End, op2End) - op2Start
-		op2.Position = op1Start
-		if overlap > 0 && overlap < op2.Length() {
-			op2.Text = op2.Text[overlap:]
-		} else if overlap >= op2.Length() {
-			op2.Text = ""
+	}
+	if len(conflicts) == 0 {
+		return
+	}
+
+	// give drops the conflicts from loser, emptying its text if they were
+	// all it set.
+	give := func(loser *Operation) {
+		if loser.Attributes = loser.Attributes.without(conflicts); len(loser.Attributes) == 0 {
+			loser.Text = ""
+		}
+	}
+	// trim drops the conflicts from loser, or its text shared with winner
+	// when they are all it sets.
+	trim := func(loser, winner *Operation) {
+		if len(loser.Attributes) > len(conflicts) {
+			give(loser)
+			return
 		}
+		start, end := loser.Position, loser.Position+loser.Length()
+		loser.Text = cut(loser.Text, max(start, winner.Position)-start, min(end, winner.Position+winner.Length())-start)
+		if winner.Position <= start {
+			loser.Position = winner.Position + winner.Length()
+		}
+	}
+	switch {
+	case start2 <= start1 && end1 <= end2:
+		give(op1)
+	case start1 <= start2 && end2 <= end1:
+		give(op2)
+	case len(op1.Attributes) == len(conflicts) || len(op2.Attributes) > len(conflicts):
+		trim(op1, op2)
+	default:
+		trim(op2, op1)
+	}
+}
+
+// cut returns text without the code units in [from, to). A bound inside a
+// surrogate pair, possible only if the deletes disagree on the text they
+// remove, moves inward so the whole character is kept.
+func cut(text string, from, to int) string {
+	i, ok := Offset(text, from)
+	for !ok && from < to {
+		from++
+		i, ok = Offset(text, from)
+	}
+	j, ok := Offset(text, to)
+	for !ok && to > from {
+		to--
+		j, ok = Offset(text, to)
+	}
+	if from >= to {
+		return text
 	}
+	return text[:i] + text[j:]
 }
 
 // min returns the minimum of two integers.
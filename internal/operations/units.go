@@ -0,0 +1,43 @@
+package operations
+
+import (
+	"errors"
+	"unicode/utf16"
+)
+
+// Positions and lengths of operations count UTF-16 code units, as string
+// indices do in JavaScript, so offsets taken from a browser editor can be
+// sent as they are. Characters outside the Basic Multilingual Plane, such
+// as most emoji, count as two units.
+
+// ErrSplitsCharacter is returned for a position that falls between the two
+// halves of a surrogate pair.
+var ErrSplitsCharacter = errors.New("position splits a character")
+
+// Len returns the length of s in UTF-16 code units.
+func Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// Offset returns the byte offset in s of position pos. It reports false if
+// pos is negative, beyond the end of s, or splits a surrogate pair.
+func Offset(s string, pos int) (int, bool) {
+	if pos < 0 {
+		return 0, false
+	}
+	n := 0
+	for i, r := range s {
+		if n == pos {
+			return i, true
+		}
+		if n > pos {
+			return 0, false
+		}
+		n += utf16.RuneLen(r)
+	}
+	return len(s), n == pos
+}
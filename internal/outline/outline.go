@@ -0,0 +1,155 @@
+// Package outline extracts the heading outline of a markdown document, the
+// "# Title" lines a navigation sidebar lists, and keeps it current as
+// operations change the document.
+package outline
+
+import (
+	"slices"
+	"strings"
+
+	"collaborative-docs/internal/operations"
+)
+
+// Heading is one ATX heading.
+type Heading struct {
+	Level int    `json:"level"` // 1 for "#" through 6 for "######"
+	Text  string `json:"text"`
+	Line  int    `json:"line"` // Zero-based, like operations.LineChange
+}
+
+// Outline is a document's headings in order. Lines inside fenced code
+// blocks are not headings, so the outline also tracks fence lines.
+type Outline struct {
+	headings []Heading
+	fences   []int // Lines opening or closing a code fence
+}
+
+// New builds the outline of content.
+func New(content string) *Outline {
+	o := &Outline{}
+	o.parse(content)
+	return o
+}
+
+// Headings returns a copy of the headings.
+func (o *Outline) Headings() []Heading {
+	return slices.Clone(o.headings)
+}
+
+// Reparse rebuilds the outline from content, for changes too large to
+// track line by line, and reports whether it changed.
+func (o *Outline) Reparse(content string) bool {
+	before := o.headings
+	o.parse(content)
+	return !slices.Equal(before, o.headings)
+}
+
+// Update applies a change to the lines of content, the document after the
+// change, and reports whether the outline changed. Only the changed lines
+// are rescanned unless a code fence was added, removed or edited, which
+// can turn any later line into or out of code.
+func (o *Outline) Update(content string, change operations.LineChange) bool {
+	before := o.headings
+	oldEnd := change.StartLine + change.OldLines
+	touchesFence := slices.ContainsFunc(o.fences, func(line int) bool {
+		return line >= change.StartLine && line < oldEnd
+	})
+
+	lines := strings.Split(content, "\n")
+	newEnd := min(change.StartLine+change.NewLines, len(lines))
+	start := min(change.StartLine, newEnd)
+	if touchesFence || slices.ContainsFunc(lines[start:newEnd], isFence) {
+		return o.Reparse(content)
+	}
+
+	shift := change.NewLines - change.OldLines
+	inCode := o.inCode(change.StartLine)
+	var headings []Heading
+	for _, h := range o.headings {
+		if h.Line < change.StartLine {
+			headings = append(headings, h)
+		}
+	}
+	if !inCode {
+		for i := start; i < newEnd; i++ {
+			if h, ok := parseHeading(lines[i]); ok {
+				h.Line = i
+				headings = append(headings, h)
+			}
+		}
+	}
+	for _, h := range o.headings {
+		if h.Line >= oldEnd {
+			h.Line += shift
+			headings = append(headings, h)
+		}
+	}
+	for i, line := range o.fences {
+		if line >= oldEnd {
+			o.fences[i] = line + shift
+		}
+	}
+	o.headings = headings
+	return !slices.Equal(before, o.headings)
+}
+
+// inCode reports whether line lies inside a fenced code block.
+func (o *Outline) inCode(line int) bool {
+	open := false
+	for _, f := range o.fences {
+		if f >= line {
+			break
+		}
+		open = !open
+	}
+	return open
+}
+
+// parse rebuilds the outline from scratch.
+func (o *Outline) parse(content string) {
+	o.headings, o.fences = nil, nil
+	inCode := false
+	for i, line := range strings.Split(content, "\n") {
+		if isFence(line) {
+			o.fences = append(o.fences, i)
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		if h, ok := parseHeading(line); ok {
+			h.Line = i
+			o.headings = append(o.headings, h)
+		}
+	}
+}
+
+// isFence reports whether line opens or closes a fenced code block.
+func isFence(line string) bool {
+	line = strings.TrimLeft(line, " ")
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+// parseHeading parses an ATX heading: up to three spaces of indentation,
+// one to six '#', then a space or the end of the line. A closing run of
+// '#' is dropped.
+func parseHeading(line string) (Heading, bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return Heading{}, false
+	}
+	level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+	if level < 1 || level > 6 {
+		return Heading{}, false
+	}
+	rest := trimmed[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return Heading{}, false
+	}
+	text := strings.TrimSpace(rest)
+	if closed := strings.TrimRight(text, "#"); closed == "" || strings.HasSuffix(closed, " ") {
+		text = strings.TrimSpace(closed)
+	}
+	return Heading{Level: level, Text: text}, true
+}
@@ -0,0 +1,53 @@
+package outline
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"collaborative-docs/internal/operations"
+)
+
+func TestHeadings(t *testing.T) {
+	content := "# Title\ntext\n## Setup ##\n    # indented code\n#hashtag\n```\n# not a heading\n```\n###### Deep\n####### too deep\n## Issue #42"
+	want := []Heading{
+		{Level: 1, Text: "Title", Line: 0},
+		{Level: 2, Text: "Setup", Line: 2},
+		{Level: 6, Text: "Deep", Line: 8},
+		{Level: 2, Text: "Issue #42", Line: 10},
+	}
+	if got := New(content).Headings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Headings() = %+v, want %+v", got, want)
+	}
+}
+
+// TestUpdateMatchesParse applies random edits and checks the incremental
+// outline against one parsed from scratch after every step.
+func TestUpdateMatchesParse(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	fragments := []string{"# ", "## Intro", "\n", "text", "```", "~~~\n", "#", " ", "x\n# y\n"}
+
+	content := "# Start\nbody\n"
+	o := New(content)
+	for step := 0; step < 2000; step++ {
+		var op *operations.Operation
+		if len(content) > 0 && rng.Intn(3) == 0 {
+			pos := rng.Intn(len(content))
+			end := min(len(content), pos+1+rng.Intn(6))
+			op = operations.NewDeleteOp(pos, content[pos:end], step)
+		} else {
+			op = operations.NewInsertOp(rng.Intn(len(content)+1), fragments[rng.Intn(len(fragments))], step)
+		}
+		next, err := operations.Apply(content, op)
+		if err != nil {
+			t.Fatalf("step %d: Apply(%v) error: %v", step, op, err)
+		}
+		change := operations.LinesChanged(content, op)
+		content = next
+
+		o.Update(content, change)
+		if got, want := o.Headings(), New(content).Headings(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("step %d after %v on %q:\nincremental %+v\nparsed      %+v", step, op, content, got, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Package pressure classifies process memory use against configurable
+// watermarks so the server can shed load before it runs out of memory.
+package pressure
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Level is the current memory pressure.
+type Level int
+
+const (
+	LevelNormal   Level = iota // Below the high watermark
+	LevelHigh                  // Above the high watermark: compact history
+	LevelCritical              // Above the critical watermark: also evict idle documents
+)
+
+// String returns the level name used in logs and events.
+func (l Level) String() string {
+	switch l {
+	case LevelHigh:
+		return "high"
+	case LevelCritical:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+// Config sets the memory watermarks in bytes. A zero watermark is disabled.
+type Config struct {
+	High     uint64
+	Critical uint64
+}
+
+// Controller samples memory use and reports the pressure level.
+type Controller struct {
+	config Config
+	read   func() uint64
+	level  Level
+	mu     sync.Mutex
+}
+
+// NewController creates a controller reading heap usage from the runtime.
+func NewController(cfg Config) *Controller {
+	return NewControllerWithReader(cfg, heapInUse)
+}
+
+// NewControllerWithReader creates a controller with a custom memory reader,
+// mainly for tests.
+func NewControllerWithReader(cfg Config, read func() uint64) *Controller {
+	return &Controller{config: cfg, read: read}
+}
+
+// Sample reads current memory use and returns the level, the bytes in use,
+// and whether the level changed since the previous sample.
+func (c *Controller) Sample() (Level, uint64, bool) {
+	used := c.read()
+
+	level := LevelNormal
+	switch {
+	case c.config.Critical > 0 && used >= c.config.Critical:
+		level = LevelCritical
+	case c.config.High > 0 && used >= c.config.High:
+		level = LevelHigh
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := level != c.level
+	c.level = level
+	return level, used, changed
+}
+
+// heapInUse returns bytes in in-use heap spans.
+func heapInUse() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
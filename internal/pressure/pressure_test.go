@@ -0,0 +1,38 @@
+package pressure
+
+import "testing"
+
+// TestSample verifies watermark classification and change reporting.
+func TestSample(t *testing.T) {
+	var used uint64
+	c := NewControllerWithReader(Config{High: 100, Critical: 200}, func() uint64 { return used })
+
+	steps := []struct {
+		used        uint64
+		wantLevel   Level
+		wantChanged bool
+	}{
+		{used: 50, wantLevel: LevelNormal, wantChanged: false},
+		{used: 150, wantLevel: LevelHigh, wantChanged: true},
+		{used: 160, wantLevel: LevelHigh, wantChanged: false},
+		{used: 250, wantLevel: LevelCritical, wantChanged: true},
+		{used: 20, wantLevel: LevelNormal, wantChanged: true},
+	}
+
+	for _, step := range steps {
+		used = step.used
+		level, got, changed := c.Sample()
+		if level != step.wantLevel || changed != step.wantChanged || got != step.used {
+			t.Errorf("Sample() at %d = %s/%d/%v, want %s/%d/%v",
+				step.used, level, got, changed, step.wantLevel, step.used, step.wantChanged)
+		}
+	}
+}
+
+// TestSampleDisabledWatermark verifies a zero watermark never triggers.
+func TestSampleDisabledWatermark(t *testing.T) {
+	c := NewControllerWithReader(Config{Critical: 200}, func() uint64 { return 150 })
+	if level, _, _ := c.Sample(); level != LevelNormal {
+		t.Errorf("level = %s, want normal", level)
+	}
+}
@@ -0,0 +1,100 @@
+// Package protocol holds the WebSocket subprotocols clients may negotiate
+// with Sec-WebSocket-Protocol. Each one is a Codec translating between its
+// frames and the JSON messages the hub handles, so clients speaking
+// different encodings share documents over one endpoint.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Built-in subprotocol names.
+const (
+	NameJSON    = "collab.v2+json"
+	NameProto   = "collab.v2+proto"
+	NameMsgpack = "collab.v2+msgpack"
+)
+
+// ErrNotObject is returned when encoding a message that is not a JSON
+// object, such as a legacy client's raw content, in a binary encoding.
+var ErrNotObject = errors.New("message is not a JSON object")
+
+// Codec translates one subprotocol's frames to and from hub messages.
+type Codec interface {
+	// FrameType is the WebSocket frame type messages are sent in. Text
+	// frames may carry several messages separated by newlines; binary
+	// frames carry one.
+	FrameType() int
+	// Decode turns a received frame into a JSON message.
+	Decode(frame []byte) ([]byte, error)
+	// Encode turns a JSON message into a frame.
+	Encode(message []byte) ([]byte, error)
+}
+
+var builtin = map[string]Codec{
+	NameJSON:    JSON,
+	NameProto:   Proto,
+	NameMsgpack: Msgpack,
+}
+
+// Lookup returns the built-in codec registered under name.
+func Lookup(name string) (Codec, bool) {
+	c, ok := builtin[name]
+	return c, ok
+}
+
+// Names returns the built-in subprotocol names in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(builtin))
+	for name := range builtin {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JSON passes messages through unchanged in text frames. Clients that
+// negotiate no subprotocol use it too.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) FrameType() int                        { return websocket.TextMessage }
+func (jsonCodec) Decode(frame []byte) ([]byte, error)   { return frame, nil }
+func (jsonCodec) Encode(message []byte) ([]byte, error) { return message, nil }
+
+// Proto sends each message in a binary frame as a protobuf
+// google.protobuf.Struct with the JSON message's fields, so clients decode
+// it with any protobuf library's well-known types. Numbers travel as
+// doubles, as in JSON.
+var Proto Codec = protoCodec{}
+
+type protoCodec struct{}
+
+func (protoCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (protoCodec) Decode(frame []byte) ([]byte, error) {
+	var s structpb.Struct
+	if err := proto.Unmarshal(frame, &s); err != nil {
+		return nil, err
+	}
+	return json.Marshal(s.AsMap())
+}
+
+func (protoCodec) Encode(message []byte) ([]byte, error) {
+	var fields map[string]any
+	if err := json.Unmarshal(message, &fields); err != nil || fields == nil {
+		return nil, ErrNotObject
+	}
+	s, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(s)
+}
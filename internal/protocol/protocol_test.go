@@ -0,0 +1,123 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestProto(t *testing.T) {
+	message := []byte(`{"type":"operation","document_id":"notes","operation":{"type":"insert","position":3,"text":"hi","version":7},"tags":["a",true,null]}`)
+	frame, err := Proto.Encode(message)
+	if err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	decoded, err := Proto.Decode(frame)
+	if err != nil {
+		t.Fatalf("Decode() error: %v", err)
+	}
+	var want, got any
+	json.Unmarshal(message, &want)
+	json.Unmarshal(decoded, &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %s, want %s", decoded, message)
+	}
+
+	if _, err := Proto.Encode([]byte("legacy content")); !errors.Is(err, ErrNotObject) {
+		t.Errorf("Encode(legacy) error = %v, want ErrNotObject", err)
+	}
+	if _, err := Proto.Decode([]byte{0xff, 0xff}); err == nil {
+		t.Error("Decode(garbage) succeeded")
+	}
+}
+
+func TestMsgpack(t *testing.T) {
+	message := []byte(`{"type":"operation","document_id":"notes","operation":{"type":"insert","position":3,"text":"hi","version":70000},"tags":["a",true,null,-5,-200,1.5,300],"long":"` + strings.Repeat("x", 300) + `"}`)
+	frame, err := Msgpack.Encode(message)
+	if err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	if len(frame) >= len(message) {
+		t.Errorf("frame is %d bytes, want fewer than JSON's %d", len(frame), len(message))
+	}
+	decoded, err := Msgpack.Decode(frame)
+	if err != nil {
+		t.Fatalf("Decode() error: %v", err)
+	}
+	var want, got any
+	json.Unmarshal(message, &want)
+	json.Unmarshal(decoded, &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %s, want %s", decoded, message)
+	}
+	if !strings.Contains(string(decoded), `"version":70000`) {
+		t.Errorf("round trip = %s, want integers kept whole", decoded)
+	}
+
+	// {"a":1}, as another implementation would send it.
+	if decoded, err := Msgpack.Decode([]byte{0x81, 0xa1, 'a', 0x01}); err != nil || string(decoded) != `{"a":1}` {
+		t.Errorf("Decode(fixmap) = %s, %v", decoded, err)
+	}
+	if _, err := Msgpack.Encode([]byte("legacy content")); !errors.Is(err, ErrNotObject) {
+		t.Errorf("Encode(legacy) error = %v, want ErrNotObject", err)
+	}
+	for name, frame := range map[string][]byte{
+		"truncated":  frame[:len(frame)-1],
+		"trailing":   append(append([]byte(nil), frame...), 0xc0),
+		"not a map":  {0x91, 0x01},
+		"huge array": {0x81, 0xa1, 'a', 0xdd, 0xff, 0xff, 0xff, 0xff},
+		"int key":    {0x81, 0x01, 0x01},
+		"ext":        {0x81, 0xa1, 'a', 0xd4, 0x01, 0x01},
+		"deep":       append(append([]byte{0x81, 0xa1, 'a'}, bytes.Repeat([]byte{0x91}, 100)...), 0xc0),
+	} {
+		if _, err := Msgpack.Decode(frame); err == nil {
+			t.Errorf("Decode(%s) succeeded", name)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if got := Names(); !reflect.DeepEqual(got, []string{NameJSON, NameMsgpack, NameProto}) {
+		t.Errorf("Names() = %v", got)
+	}
+	for name, frameType := range map[string]int{NameJSON: websocket.TextMessage, NameProto: websocket.BinaryMessage, NameMsgpack: websocket.BinaryMessage} {
+		c, ok := Lookup(name)
+		if !ok || c.FrameType() != frameType {
+			t.Errorf("Lookup(%q) = %v, %v", name, c, ok)
+		}
+	}
+	if _, ok := Lookup("collab.v1"); ok {
+		t.Error("Lookup(unknown) succeeded")
+	}
+}
+
+// BenchmarkCodecs compares encoding and decoding a typical keystroke
+// operation, and reports each frame's size.
+func BenchmarkCodecs(b *testing.B) {
+	message := []byte(`{"type":"operation","document_id":"quarterly-planning","operation":{"type":"insert","position":1532,"text":"e","version":4821,"id":"c7-1289"},"author":{"client_id":"7","user_id":"alice","name":"Alice"},"trace_id":"9f86d081884c7d65","server_time":1767225600000,"version":4822}`)
+	for _, name := range Names() {
+		c, _ := Lookup(name)
+		frame, err := c.Encode(message)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name+"/encode", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				c.Encode(message)
+			}
+			b.ReportMetric(float64(len(frame)), "frame_bytes")
+		})
+		b.Run(name+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				c.Decode(frame)
+			}
+		})
+	}
+}
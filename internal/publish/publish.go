@@ -0,0 +1,96 @@
+// Package publish asks an external service, such as a review queue or a
+// compliance check, to approve a document version before it is published
+// to readers outside the editing session.
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultTimeout bounds a webhook call when no client is given.
+const defaultTimeout = 10 * time.Second
+
+// Request is the snapshot an approver decides on.
+type Request struct {
+	DocumentID  string `json:"document_id"`
+	Version     int    `json:"version"`
+	Content     string `json:"content"`
+	RequestedBy string `json:"requested_by,omitempty"` // Subject of the authenticated caller, if any
+}
+
+// Decision is an approver's answer. Reason explains a refusal.
+type Decision struct {
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Approver decides whether a snapshot may be published. An error means no
+// decision was made, for example because the approver was unreachable.
+type Approver interface {
+	Approve(ctx context.Context, req Request) (Decision, error)
+}
+
+// Webhook is an Approver that POSTs the request as JSON to a URL and reads
+// a Decision from a 2xx response. With a secret, the body is signed with
+// HMAC-SHA256 in the X-Signature-256 header as "sha256=<hex>", so the
+// receiver can check the request came from this server.
+type Webhook struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhook returns an approver calling url. secret may be empty to send
+// unsigned requests, and client may be nil.
+func NewWebhook(url, secret string, client *http.Client) *Webhook {
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	return &Webhook{url: url, secret: []byte(secret), client: client}
+}
+
+// Approve implements Approver.
+func (w *Webhook) Approve(ctx context.Context, r Request) (Decision, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return Decision{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		req.Header.Set("X-Signature-256", Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Decision{}, fmt.Errorf("POST %s: %s", w.url, resp.Status)
+	}
+	var d Decision
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&d); err != nil {
+		return Decision{}, fmt.Errorf("POST %s: invalid decision: %w", w.url, err)
+	}
+	return d, nil
+}
+
+// Sign returns the X-Signature-256 header value for body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	var got Request
+	var signature, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		signature, body = r.Header.Get("X-Signature-256"), string(data)
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		d := Decision{Approved: true}
+		if got.Content == "draft" {
+			d = Decision{Reason: "still a draft"}
+		}
+		json.NewEncoder(w).Encode(d)
+	}))
+	defer srv.Close()
+
+	hook := NewWebhook(srv.URL, "s3cret", nil)
+	d, err := hook.Approve(context.Background(), Request{DocumentID: "doc", Version: 3, Content: "final", RequestedBy: "alice"})
+	if err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if !d.Approved {
+		t.Errorf("decision = %+v, want approved", d)
+	}
+	if signature != Sign([]byte("s3cret"), []byte(body)) {
+		t.Errorf("signature %q does not match body", signature)
+	}
+	if got != (Request{DocumentID: "doc", Version: 3, Content: "final", RequestedBy: "alice"}) {
+		t.Errorf("webhook received %+v", got)
+	}
+
+	d, err = hook.Approve(context.Background(), Request{DocumentID: "doc", Version: 4, Content: "draft"})
+	if err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if d.Approved || d.Reason != "still a draft" {
+		t.Errorf("decision = %+v, want refusal with reason", d)
+	}
+
+	// Without a secret nothing is signed.
+	if _, err := NewWebhook(srv.URL, "", nil).Approve(context.Background(), Request{}); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if signature != "" {
+		t.Errorf("unsigned request carried X-Signature-256 %q", signature)
+	}
+}
+
+func TestWebhookFailure(t *testing.T) {
+	status := http.StatusInternalServerError
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, "not json")
+	}))
+	defer srv.Close()
+
+	hook := NewWebhook(srv.URL, "", nil)
+	if _, err := hook.Approve(context.Background(), Request{}); err == nil {
+		t.Error("5xx response was not an error")
+	}
+	status = http.StatusOK
+	if _, err := hook.Approve(context.Background(), Request{}); err == nil {
+		t.Error("undecodable decision was not an error")
+	}
+}
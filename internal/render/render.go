@@ -0,0 +1,142 @@
+// Package render turns a document into a standalone HTML page for readers
+// outside the editor. Markdown is rendered with raw HTML escaped, so a
+// page can be served from the application's own origin.
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+)
+
+// Input is what a page is rendered from.
+type Input struct {
+	Title    string
+	Kind     document.Kind
+	Language string // Editing language; text in any language but markdown is shown as code
+	Content  string
+	Version  int
+	// Formatting is the rich-text formatting of Content. Plain text with
+	// formatting is rendered as rich text rather than as markdown.
+	Formatting operations.Spans
+}
+
+// markdown renders GitHub-flavored markdown. It is safe for concurrent use.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="generator" content="collaborative-docs">
+<title>{{.Title}}</title>
+</head>
+<body>
+<article data-version="{{.Version}}">
+{{.Body}}
+</article>
+</body>
+</html>
+`))
+
+// HTML renders in as a complete page.
+func HTML(in Input) ([]byte, error) {
+	body, err := Body(in)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = page.Execute(&buf, struct {
+		Title   string
+		Version int
+		Body    template.HTML
+	}{in.Title, in.Version, body})
+	return buf.Bytes(), err
+}
+
+// Body renders the content of in: rich text and markdown as HTML, JSON
+// documents indented, and code as preformatted text.
+func Body(in Input) (template.HTML, error) {
+	switch {
+	case in.Kind == document.KindJSON:
+		var indented bytes.Buffer
+		if json.Indent(&indented, []byte(in.Content), "", "  ") != nil {
+			indented.Reset()
+			indented.WriteString(in.Content)
+		}
+		return code("json", indented.String()), nil
+	case in.Language != "" && in.Language != "markdown":
+		return code(in.Language, in.Content), nil
+	case in.Language == "" && len(in.Formatting) > 0:
+		return richText(in.Formatting.Lines(in.Content)), nil
+	}
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(in.Content), &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// code renders text as a preformatted block tagged with its language.
+func code(language, text string) template.HTML {
+	return template.HTML(`<pre><code class="language-` + template.HTMLEscapeString(language) + `">` +
+		template.HTMLEscapeString(text) + "</code></pre>\n")
+}
+
+// richText renders formatted lines as HTML: each line a paragraph, or a
+// heading if its first run has one, and blank lines left out.
+func richText(lines [][]operations.Run) template.HTML {
+	var b strings.Builder
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		tag := "p"
+		if level := line[0].Attributes[operations.AttrHeading]; level != "" {
+			tag = "h" + level
+		}
+		b.WriteString("<" + tag + ">")
+		for _, run := range line {
+			text := template.HTMLEscapeString(run.Text)
+			if run.Attributes[operations.AttrItalic] != "" {
+				text = "<em>" + text + "</em>"
+			}
+			if run.Attributes[operations.AttrBold] != "" {
+				text = "<strong>" + text + "</strong>"
+			}
+			if link := run.Attributes[operations.AttrLink]; SafeLink(link) {
+				text = `<a href="` + template.HTMLEscapeString(link) + `">` + text + "</a>"
+			}
+			b.WriteString(text)
+		}
+		b.WriteString("</" + tag + ">\n")
+	}
+	return template.HTML(b.String())
+}
+
+// SafeLink reports whether link is a URL a reader can follow safely: a web
+// or mail address, or one relative to the page. Scripts and other schemes
+// are not.
+func SafeLink(link string) bool {
+	if link == "" {
+		return false
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+)
+
+func TestBody(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Input
+		want string
+	}{
+		{"markdown", Input{Content: "# Title\n\n- **bold**"}, "<h1>Title</h1>\n<ul>\n<li><strong>bold</strong></li>\n</ul>\n"},
+		{"raw html is escaped", Input{Content: "<script>alert(1)</script>"}, "<!-- raw HTML omitted -->\n"},
+		{"table", Input{Language: "markdown", Content: "| a |\n|---|\n| 1 |"}, "<table>\n<thead>\n<tr>\n<th>a</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n</tr>\n</tbody>\n</table>\n"},
+		{"code", Input{Language: "go", Content: "if a < b {}"}, "<pre><code class=\"language-go\">if a &lt; b {}</code></pre>\n"},
+		{"rich text", Input{Content: "Notes\n\n<b> & c", Formatting: operations.Spans{
+			{Position: 0, Length: 5, Attributes: operations.Attributes{operations.AttrHeading: "2"}},
+			{Position: 7, Length: 3, Attributes: operations.Attributes{operations.AttrBold: "true", operations.AttrItalic: "true"}},
+			{Position: 13, Length: 1, Attributes: operations.Attributes{operations.AttrLink: "https://example.com/?a=1&b=2"}},
+		}}, "<h2>Notes</h2>\n<p><strong><em>&lt;b&gt;</em></strong> &amp; <a href=\"https://example.com/?a=1&amp;b=2\">c</a></p>\n"},
+		{"unsafe link", Input{Content: "x", Formatting: operations.Spans{
+			{Position: 0, Length: 1, Attributes: operations.Attributes{operations.AttrLink: "javascript:alert(1)"}},
+		}}, "<p>x</p>\n"},
+		{"json", Input{Kind: document.KindJSON, Content: `{"a":[1]}`}, "<pre><code class=\"language-json\">{\n  &#34;a&#34;: [\n    1\n  ]\n}</code></pre>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Body(tt.in)
+			if err != nil {
+				t.Fatalf("Body() error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Body() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTML(t *testing.T) {
+	got, err := HTML(Input{Title: "<notes>", Content: "Hello", Version: 7})
+	if err != nil {
+		t.Fatalf("HTML() error: %v", err)
+	}
+	for _, want := range []string{"<title>&lt;notes&gt;</title>", `data-version="7"`, "<p>Hello</p>"} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("page lacks %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Package replication keeps a hot standby server in step with a primary.
+// The primary streams its change events to the standby as records: a
+// snapshot of every loaded document first, then each operation applied and
+// a fresh snapshot whenever a document changes some other way. The standby
+// applies them to its own hub, so its documents stay warm, and is promoted
+// by hand when the primary fails. Edits the primary applied but had not yet
+// streamed are lost; Status reports how far behind the standby is.
+package replication
+
+import (
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamQueueSize bounds the events waiting to be streamed to one standby.
+// A standby that falls further behind is disconnected, and sent every
+// document again when it reconnects, rather than stalling the hub.
+const streamQueueSize = 1024
+
+// heartbeatInterval is how often an idle stream tells the standby the
+// primary is still there.
+const heartbeatInterval = time.Second
+
+// RecordType identifies the kind of replication record.
+type RecordType string
+
+const (
+	RecordSnapshot  RecordType = "snapshot"  // A document's full state
+	RecordOperation RecordType = "operation" // An operation the primary applied
+	RecordDeleted   RecordType = "deleted"   // A document was deleted
+	RecordHeartbeat RecordType = "heartbeat" // Nothing changed
+)
+
+// Record is one line of the replication stream.
+type Record struct {
+	Type       RecordType            `json:"type"`
+	DocumentID string                `json:"document_id,omitempty"`
+	Version    int                   `json:"version,omitempty"` // The operation produced
+	Operation  *operations.Operation `json:"operation,omitempty"`
+	Snapshot   *document.Snapshot    `json:"snapshot,omitempty"`
+	Time       time.Time             `json:"time"` // When the primary sent it
+}
+
+// Primary streams a hub's changes to standbys. Register it with the hub
+// via AddEventSink and serve it, behind admin authorization, to standbys.
+type Primary struct {
+	hub         *hub.Hub
+	mu          sync.Mutex
+	subscribers map[*subscriber]bool
+}
+
+// subscriber is one connected standby's queue of events.
+type subscriber struct {
+	queue    chan events.Event
+	overflow chan struct{} // Closed once the queue overflowed
+	once     sync.Once
+}
+
+// NewPrimary creates a primary streaming h's changes.
+func NewPrimary(h *hub.Hub) *Primary {
+	return &Primary{hub: h, subscribers: make(map[*subscriber]bool)}
+}
+
+// Publish implements events.Sink. It never blocks the hub.
+func (p *Primary) Publish(event events.Event) {
+	if !changesState(event.Type) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for s := range p.subscribers {
+		select {
+		case s.queue <- event:
+		default:
+			s.once.Do(func() { close(s.overflow) })
+		}
+	}
+}
+
+// changesState reports whether events of type t change a document's
+// replicated state. Presence, alerts and compaction do not, nor does
+// eviction, after which the standby keeps its copy warm.
+func changesState(t events.Type) bool {
+	switch t {
+	case events.TypeUserJoined, events.TypeUserLeft, events.TypeLatencyAlert,
+		events.TypeMemoryPressure, events.TypeDocumentEvicted, events.TypeClientThrottled,
+		events.TypeClientDisconnected, events.TypeEmbedChanged, events.TypeDocumentCompacted,
+		events.TypeOperationConflict:
+		return false
+	}
+	return true
+}
+
+// ServeHTTP streams records as NDJSON until the standby disconnects or
+// falls too far behind: snapshots of the loaded documents, then changes as
+// they happen, with a heartbeat whenever the stream is idle.
+func (p *Primary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Subscribe before the snapshots are taken, so no change falls between
+	// them; a change streamed twice is skipped by the standby.
+	s := &subscriber{queue: make(chan events.Event, streamQueueSize), overflow: make(chan struct{})}
+	p.mu.Lock()
+	p.subscribers[s] = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.subscribers, s)
+		p.mu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	send := func(rec *Record) bool {
+		rec.Time = p.hub.Clock().Now()
+		if err := enc.Encode(rec); err != nil {
+			log.Printf("replication stream to %s ended: %v", r.RemoteAddr, err)
+			return false
+		}
+		rc.Flush()
+		return true
+	}
+
+	log.Printf("standby %s connected", r.RemoteAddr)
+	for _, id := range p.hub.LoadedDocumentIDs() {
+		if rec := p.snapshot(id); rec != nil && !send(rec) {
+			return
+		}
+	}
+	if !send(&Record{Type: RecordHeartbeat}) {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Printf("standby %s disconnected", r.RemoteAddr)
+			return
+		case <-s.overflow:
+			log.Printf("standby %s fell too far behind, disconnecting it", r.RemoteAddr)
+			return
+		case <-heartbeat.C:
+			if !send(&Record{Type: RecordHeartbeat}) {
+				return
+			}
+		case event := <-s.queue:
+			if rec := p.record(event); rec != nil && !send(rec) {
+				return
+			}
+			heartbeat.Reset(heartbeatInterval)
+		}
+	}
+}
+
+// record turns a change event into the record that replicates it: text
+// operations as they were applied, deletions as such and other changes,
+// whose events do not say what changed, as a snapshot of the document.
+func (p *Primary) record(event events.Event) *Record {
+	switch {
+	case event.Type == events.TypeOperationApplied && event.Operation != nil:
+		return &Record{Type: RecordOperation, DocumentID: event.DocumentID, Version: event.Version, Operation: event.Operation}
+	case event.Type == events.TypeDocumentDeleted:
+		return &Record{Type: RecordDeleted, DocumentID: event.DocumentID}
+	}
+	return p.snapshot(event.DocumentID)
+}
+
+// snapshot returns a snapshot record of a document, or nil if it is gone.
+func (p *Primary) snapshot(documentID string) *Record {
+	if documentID == "" {
+		return nil
+	}
+	doc := p.hub.GetDocument(documentID)
+	if doc == nil {
+		return nil
+	}
+	snap, _ := doc.Snapshot()
+	return &Record{Type: RecordSnapshot, DocumentID: documentID, Snapshot: snap}
+}
@@ -0,0 +1,120 @@
+package replication
+
+import (
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a second passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// content returns a document's content and version on h, or "" and -1 if
+// it does not exist.
+func content(h *hub.Hub, documentID string) (string, int) {
+	doc := h.GetDocument(documentID)
+	if doc == nil {
+		return "", -1
+	}
+	return doc.GetContentAndVersion()
+}
+
+// TestStandby verifies a standby receives the documents loaded before it
+// connected and the changes after, and stops following once promoted.
+func TestStandby(t *testing.T) {
+	primary := hub.NewHub()
+	go primary.Run()
+	defer primary.Shutdown()
+	stream := NewPrimary(primary)
+	primary.AddEventSink(stream)
+	srv := httptest.NewServer(stream)
+	defer srv.Close()
+
+	if _, err := primary.ReplaceContent("before", "hello", 0); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+
+	replica := hub.NewHub()
+	go replica.Run()
+	defer replica.Shutdown()
+	standby := NewStandby(replica, srv.URL, "")
+	go standby.Run()
+	defer standby.Stop()
+
+	waitFor(t, "the snapshot", func() bool {
+		c, v := content(replica, "before")
+		return c == "hello" && v == 1
+	})
+
+	if _, err := primary.ReplaceContent("before", "hello world", 1); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+	if _, err := primary.ReplaceContent("after", "new", 0); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+	waitFor(t, "the changes", func() bool {
+		c1, v1 := content(replica, "before")
+		c2, _ := content(replica, "after")
+		return c1 == "hello world" && v1 == 2 && c2 == "new"
+	})
+
+	if err := primary.DeleteDocument("after", false); err != nil {
+		t.Fatalf("DeleteDocument() error: %v", err)
+	}
+	waitFor(t, "the deletion", func() bool {
+		_, v := content(replica, "after")
+		return v == -1
+	})
+	if st := standby.Status(); !st.Connected || st.Promoted || st.Documents != 1 || st.LastRecord.IsZero() {
+		t.Errorf("Status() = %+v, want connected with 1 document", st)
+	}
+
+	if st := standby.Promote(); !st.Promoted {
+		t.Errorf("Promote() = %+v, want promoted", st)
+	}
+	waitFor(t, "the stream to close", func() bool { return !standby.Status().Connected })
+	if _, err := primary.ReplaceContent("before", "lost", 2); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if c, _ := content(replica, "before"); c != "hello world" {
+		t.Errorf("promoted standby content = %q, want it to stop following", c)
+	}
+}
+
+// TestApplyReplicated verifies operations a standby already has are
+// skipped and a gap asks for the document again.
+func TestApplyReplicated(t *testing.T) {
+	h := hub.NewHub()
+	go h.Run()
+	defer h.Shutdown()
+	if err := h.ApplyReplicated("doc", operations.NewInsertOp(0, "a", 0), 1); err == nil {
+		t.Error("ApplyReplicated() to a missing document succeeded")
+	}
+	if _, err := h.ReplaceContent("doc", "ab", 0); err != nil {
+		t.Fatalf("ReplaceContent() error: %v", err)
+	}
+	if err := h.ApplyReplicated("doc", operations.NewInsertOp(0, "x", 0), 1); err != nil {
+		t.Errorf("ApplyReplicated() of a version already applied: %v", err)
+	}
+	if err := h.ApplyReplicated("doc", operations.NewInsertOp(2, "c", 1), 2); err != nil {
+		t.Errorf("ApplyReplicated() error: %v", err)
+	}
+	if err := h.ApplyReplicated("doc", operations.NewInsertOp(0, "z", 3), 4); err == nil {
+		t.Error("ApplyReplicated() past a gap succeeded")
+	}
+	if c, v := content(h, "doc"); c != "abc" || v != 2 {
+		t.Errorf("content = %q at %d, want \"abc\" at 2", c, v)
+	}
+}
@@ -0,0 +1,210 @@
+package replication
+
+import (
+	"collaborative-docs/internal/hub"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// retryInterval is how long a standby waits before reconnecting to its
+// primary.
+const retryInterval = time.Second
+
+// Status describes a standby's replication.
+type Status struct {
+	Primary   string `json:"primary"`
+	Promoted  bool   `json:"promoted"`
+	Connected bool   `json:"connected"`
+	// Documents is how many documents the standby holds.
+	Documents int `json:"documents"`
+	// LastRecord is when the primary sent the newest record the standby
+	// applied, by the primary's clock. Edits the primary applied after it
+	// are what a failover would lose.
+	LastRecord time.Time `json:"last_record,omitzero"`
+	// LagMS is how long ago, by the standby's clock, it last heard from
+	// the primary. A connected standby hears at least once per second.
+	LagMS float64 `json:"lag_ms"`
+}
+
+// Standby follows a primary's replication stream, applying it to a hub.
+type Standby struct {
+	hub    *hub.Hub
+	url    string
+	token  string
+	client *http.Client
+
+	mu        sync.Mutex
+	promoted  bool
+	connected bool
+	record    time.Time          // Primary's time of the newest record
+	heard     time.Time          // Standby's time it arrived
+	cancel    context.CancelFunc // Ends the current connection
+	stop      chan struct{}
+	once      sync.Once
+}
+
+// NewStandby creates a standby of the primary streaming at url, authorized
+// with the primary's admin token. Call Run to start following it.
+func NewStandby(h *hub.Hub, url, token string) *Standby {
+	return &Standby{
+		hub:    h,
+		url:    url,
+		token:  token,
+		client: &http.Client{},
+		stop:   make(chan struct{}),
+	}
+}
+
+// Run follows the primary, reconnecting whenever the stream ends, until
+// the standby is promoted or stopped.
+func (s *Standby) Run() {
+	for {
+		if err := s.follow(); err != nil {
+			log.Printf("replication from %s interrupted: %v", s.url, err)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// Promote stops following the primary, so the standby's hub can serve
+// clients as the new primary, and returns its final status. Promoting
+// twice is harmless.
+func (s *Standby) Promote() Status {
+	s.mu.Lock()
+	if !s.promoted {
+		s.promoted = true
+		log.Printf("promoted to primary; last record from %s sent at %s", s.url, s.record.Format(time.RFC3339Nano))
+	}
+	s.mu.Unlock()
+	s.Stop()
+	return s.Status()
+}
+
+// Stop stops following the primary without promoting the standby.
+func (s *Standby) Stop() {
+	s.once.Do(func() { close(s.stop) })
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+}
+
+// Promoted reports whether the standby has been promoted.
+func (s *Standby) Promoted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.promoted
+}
+
+// Status reports how far the standby has followed the primary.
+func (s *Standby) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := Status{
+		Primary:    s.url,
+		Promoted:   s.promoted,
+		Connected:  s.connected,
+		Documents:  len(s.hub.LoadedDocumentIDs()),
+		LastRecord: s.record,
+	}
+	if !s.heard.IsZero() {
+		st.LagMS = float64(s.hub.Clock().Now().Sub(s.heard)) / float64(time.Millisecond)
+	}
+	return st
+}
+
+// follow connects to the primary and applies its records until the stream
+// ends or the standby stops.
+func (s *Standby) follow() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+		s.mu.Unlock()
+		return nil
+	default:
+	}
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary returned %s", resp.Status)
+	}
+
+	s.setConnected(true)
+	defer s.setConnected(false)
+	log.Printf("following primary %s", s.url)
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); err != nil {
+			if ctx.Err() != nil {
+				return nil // stopped
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil // stopped; promotion takes the state as it is
+		}
+		if err := s.apply(&rec); err != nil {
+			// Reconnecting sends every document again.
+			return err
+		}
+		s.mu.Lock()
+		s.record = rec.Time
+		s.heard = s.hub.Clock().Now()
+		s.mu.Unlock()
+	}
+}
+
+// apply applies one record to the standby's hub.
+func (s *Standby) apply(rec *Record) error {
+	switch rec.Type {
+	case RecordSnapshot:
+		if rec.Snapshot == nil {
+			return fmt.Errorf("snapshot of %s without content", rec.DocumentID)
+		}
+		s.hub.InstallReplica(rec.DocumentID, rec.Snapshot)
+	case RecordOperation:
+		if rec.Operation == nil {
+			return fmt.Errorf("operation record for %s without an operation", rec.DocumentID)
+		}
+		return s.hub.ApplyReplicated(rec.DocumentID, rec.Operation, rec.Version)
+	case RecordDeleted:
+		if err := s.hub.DeleteDocument(rec.DocumentID, false); err != nil {
+			log.Printf("replicating deletion: %v", err)
+		}
+	case RecordHeartbeat:
+	default:
+		return errors.New("unknown record type: " + string(rec.Type))
+	}
+	return nil
+}
+
+func (s *Standby) setConnected(connected bool) {
+	s.mu.Lock()
+	s.connected = connected
+	s.mu.Unlock()
+}
@@ -0,0 +1,139 @@
+// Package sanitize cleans text pasted into a document before the server
+// applies it, so stray control characters, Windows line endings or copied
+// markup cannot break the tools that read the document afterwards.
+package sanitize
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Policy says how inserted text is cleaned. The zero Policy leaves text
+// unchanged.
+type Policy struct {
+	StripControl      bool `json:"strip_control"`      // Drop control characters other than tab and newline
+	NormalizeNewlines bool `json:"normalize_newlines"` // "\r\n" and lone "\r" become "\n"
+	NFC               bool `json:"nfc"`                // Compose to Unicode normalization form C
+	StripHTML         bool `json:"strip_html"`         // Drop tags, comments, scripts and styles; decode entities
+}
+
+// Standard cleans everything except markup, which is only stripped on
+// request because code documents legitimately contain it.
+var Standard = Policy{StripControl: true, NormalizeNewlines: true, NFC: true}
+
+// Enabled reports whether p changes any text.
+func (p Policy) Enabled() bool {
+	return p != Policy{}
+}
+
+// Text returns s cleaned according to p. Invalid UTF-8 is always dropped
+// by an enabled policy.
+func (p Policy) Text(s string) string {
+	if !p.Enabled() {
+		return s
+	}
+	s = strings.ToValidUTF8(s, "")
+	if p.StripHTML {
+		s = stripHTML(s)
+	}
+	if p.NormalizeNewlines {
+		s = strings.ReplaceAll(s, "\r\n", "\n")
+		s = strings.ReplaceAll(s, "\r", "\n")
+	}
+	if p.StripControl {
+		s = strings.Map(func(r rune) rune {
+			if r != '\n' && r != '\t' && unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	if p.NFC {
+		s = norm.NFC.String(s)
+	}
+	return s
+}
+
+// rawTextElements are dropped along with everything inside them.
+var rawTextElements = []string{"script", "style"}
+
+// stripHTML removes tags and comments and decodes entities. A '<' that does
+// not start a tag, as in "a < b", is kept.
+func stripHTML(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '<')
+		if i < 0 || i+1 == len(s) {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				break
+			}
+			s = s[end+len("-->"):]
+			continue
+		}
+		if !isTagStart(s[1:]) {
+			b.WriteByte('<')
+			s = s[1:]
+			continue
+		}
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			b.WriteString(s)
+			break
+		}
+		tag := s[:end+1]
+		s = s[end+1:]
+		if name := tagName(tag); name != "" && !strings.HasSuffix(tag, "/>") {
+			closing := indexFold(s, "</"+name)
+			if closing < 0 {
+				break
+			}
+			s = s[closing+len("</"+name):]
+			if gt := strings.IndexByte(s, '>'); gt >= 0 {
+				s = s[gt+1:]
+			}
+		}
+	}
+	return html.UnescapeString(b.String())
+}
+
+// indexFold is strings.Index ignoring ASCII case in s.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isTagStart reports whether s, the text after a '<', begins a tag.
+func isTagStart(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r == '/' || r == '!' || r < utf8.RuneSelf && unicode.IsLetter(r)
+}
+
+// tagName returns the element name if tag opens a raw text element.
+func tagName(tag string) string {
+	name := strings.ToLower(strings.TrimLeft(tag[1:], " "))
+	for _, raw := range rawTextElements {
+		if strings.HasPrefix(name, raw) {
+			rest := name[len(raw):]
+			if rest == "" || rest[0] == '>' || rest[0] == ' ' || rest[0] == '/' || rest[0] == '\t' || rest[0] == '\n' {
+				return raw
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package sanitize
+
+import "testing"
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		in     string
+		want   string
+	}{
+		{"zero policy", Policy{}, "a\r\n\x00b", "a\r\n\x00b"},
+		{"control characters", Policy{StripControl: true}, "a\x00b\x1b[31mc\td\n\u0085e\x7f", "ab[31mc\td\ne"},
+		{"newlines", Policy{NormalizeNewlines: true}, "a\r\nb\rc\n", "a\nb\nc\n"},
+		{"nfc", Policy{NFC: true}, "cafe\u0301", "caf\u00e9"},
+		{"invalid utf-8", Policy{NFC: true}, "a\xffb", "ab"},
+		{"standard", Standard, "x\r\n\x07y<b>", "x\ny<b>"},
+		{"html", Policy{StripHTML: true}, "<p>Tom &amp; <b>Jerry</b></p><!-- note -->", "Tom & Jerry"},
+		{"script and style", Policy{StripHTML: true}, "a<script>alert(1)</script>b<STYLE>p{}</style >c", "abc"},
+		{"self-closing script", Policy{StripHTML: true}, "a<script src=x />b", "ab"},
+		{"unclosed script", Policy{StripHTML: true}, "a<script>alert(1)", "a"},
+		{"not a tag", Policy{StripHTML: true}, "1 < 2 and 3 > 2 <", "1 < 2 and 3 > 2 <"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Text(tt.in); got != tt.want {
+				t.Errorf("Text(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
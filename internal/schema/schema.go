@@ -0,0 +1,192 @@
+// Package schema describes the line structure a text document must keep,
+// such as a plain-text title line followed by a free-form body, so the
+// server can reject edits that would break it.
+package schema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Section is a run of consecutive lines with shared rules.
+type Section struct {
+	Name      string // Reported in violations, e.g. "title"
+	Lines     int    // Lines in the section; 0 means all remaining lines
+	Plain     bool   // Lines must be plain text without markdown markup
+	MaxLength int    // Maximum characters per line; 0 means unlimited
+}
+
+// Schema is an ordered list of sections. Lines past the last section are
+// unconstrained, as are sections the document is too short to reach.
+type Schema struct {
+	Name     string
+	Sections []Section
+}
+
+// TitleBody locks the first line to a plain-text title of at most 200
+// characters and allows anything below it.
+var TitleBody = &Schema{
+	Name: "title-body",
+	Sections: []Section{
+		{Name: "title", Lines: 1, Plain: true, MaxLength: 200},
+		{Name: "body"},
+	},
+}
+
+// builtin holds the schemas that can be selected by name.
+var builtin = map[string]*Schema{
+	TitleBody.Name: TitleBody,
+}
+
+// Lookup returns the built-in schema with the given name.
+func Lookup(name string) (*Schema, bool) {
+	s, ok := builtin[name]
+	return s, ok
+}
+
+// Names returns the names of the built-in schemas in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(builtin))
+	for name := range builtin {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Rules a violation can break.
+const (
+	RuleMaxLength = "max_length" // A line is longer than its limit
+	RuleMaxLines  = "max_lines"  // The document has more lines than its limit
+	RulePlain     = "plain"      // A plain-text line contains markup or control characters
+)
+
+// ViolationError reports the first line that breaks a schema or a
+// document's limits. Lines are zero-based. It marshals to JSON so clients
+// can point at the offending line.
+type ViolationError struct {
+	Schema  string `json:"schema,omitempty"`  // Empty for a document's limits
+	Section string `json:"section,omitempty"` // Empty for a document's limits
+	Line    int    `json:"line"`
+	Rule    string `json:"rule"`
+	Limit   int    `json:"limit,omitempty"`  // For max_length and max_lines
+	Actual  int    `json:"actual,omitempty"` // For max_length and max_lines
+	Reason  string `json:"reason"`
+}
+
+func (e *ViolationError) Error() string {
+	if e.Schema == "" {
+		return fmt.Sprintf("line %d %s", e.Line, e.Reason)
+	}
+	return fmt.Sprintf("schema %s: %s line %d %s", e.Schema, e.Section, e.Line, e.Reason)
+}
+
+// Validate checks content against the schema and returns a
+// *ViolationError for the first offending line.
+func (s *Schema) Validate(content string) error {
+	lines := strings.Split(content, "\n")
+	line := 0
+	for _, sec := range s.Sections {
+		end := len(lines)
+		if sec.Lines > 0 {
+			end = min(line+sec.Lines, len(lines))
+		}
+		for ; line < end; line++ {
+			if v := sec.check(lines[line]); v != nil {
+				v.Schema, v.Section, v.Line = s.Name, sec.Name, line
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// check returns how text breaks the section's rules, or nil if it does not.
+func (sec Section) check(text string) *ViolationError {
+	if v := checkLength(text, sec.MaxLength); v != nil {
+		return v
+	}
+	if sec.Plain {
+		if reason := plainTextViolation(text); reason != "" {
+			return &ViolationError{Rule: RulePlain, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// checkLength reports a line longer than limit characters; 0 means
+// unlimited.
+func checkLength(text string, limit int) *ViolationError {
+	if limit <= 0 {
+		return nil
+	}
+	n := utf8.RuneCountInString(text)
+	if n <= limit {
+		return nil
+	}
+	return &ViolationError{
+		Rule:   RuleMaxLength,
+		Limit:  limit,
+		Actual: n,
+		Reason: fmt.Sprintf("is %d characters, limit %d", n, limit),
+	}
+}
+
+// Limits bound a document's shape, for uses such as collaborative config
+// editing where a tool downstream reads the file. Zero means unlimited.
+type Limits struct {
+	MaxLineLength int `json:"max_line_length"` // Characters per line
+	MaxLines      int `json:"max_lines"`
+}
+
+// Validate checks content against the limits and returns a
+// *ViolationError for the first offending line.
+func (l Limits) Validate(content string) error {
+	if l == (Limits{}) {
+		return nil
+	}
+	lines := strings.Split(content, "\n")
+	if l.MaxLines > 0 && len(lines) > l.MaxLines {
+		return &ViolationError{
+			Line:   l.MaxLines,
+			Rule:   RuleMaxLines,
+			Limit:  l.MaxLines,
+			Actual: len(lines),
+			Reason: fmt.Sprintf("is past the end: %d lines, limit %d", len(lines), l.MaxLines),
+		}
+	}
+	for i, text := range lines {
+		if v := checkLength(text, l.MaxLineLength); v != nil {
+			v.Line = i
+			return v
+		}
+	}
+	return nil
+}
+
+// inlineMarkup are characters that start markdown emphasis, code, links or
+// HTML anywhere in a line.
+const inlineMarkup = "*_`[]<>"
+
+// plainTextViolation reports markdown markup or control characters in a
+// line that must be plain text.
+func plainTextViolation(text string) string {
+	trimmed := strings.TrimLeft(text, " ")
+	for _, marker := range []string{"#", ">", "- ", "+ ", "```", "~~~"} {
+		if strings.HasPrefix(trimmed, marker) {
+			return fmt.Sprintf("starts with markdown %q", marker)
+		}
+	}
+	if i := strings.IndexAny(text, inlineMarkup); i >= 0 {
+		return fmt.Sprintf("contains markdown %q", text[i])
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) {
+			return fmt.Sprintf("contains control character %U", r)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package schema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestValidateTitleBody verifies the title line must be short plain text
+// while the body accepts anything.
+func TestValidateTitleBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantSection string
+	}{
+		{"empty document", "", ""},
+		{"plain title", "Quarterly plan", ""},
+		{"markdown body", "Plan\n# Goals\n- **ship** it", ""},
+		{"heading title", "# Plan\nbody", "title"},
+		{"emphasis in title", "The *real* plan", "title"},
+		{"link in title", "See [docs](x)", "title"},
+		{"control character", "Plan\tA", "title"},
+		{"long title", strings.Repeat("é", 201), "title"},
+		{"title at limit", strings.Repeat("é", 200), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := TitleBody.Validate(tt.content)
+			if tt.wantSection == "" {
+				if err != nil {
+					t.Errorf("Validate() error: %v", err)
+				}
+				return
+			}
+			var violation *ViolationError
+			if !errors.As(err, &violation) || violation.Section != tt.wantSection || violation.Line != 0 {
+				t.Errorf("Validate() = %v, want violation in %s line 0", err, tt.wantSection)
+			}
+		})
+	}
+}
+
+// TestLookup verifies built-in schemas are found by name.
+func TestLookup(t *testing.T) {
+	if s, ok := Lookup("title-body"); !ok || s != TitleBody {
+		t.Errorf("Lookup(title-body) = %v, %v", s, ok)
+	}
+	if _, ok := Lookup("nope"); ok {
+		t.Error("Lookup(nope) found a schema")
+	}
+}
+
+// TestLimits verifies line length and line count limits and the violation
+// each reports.
+func TestLimits(t *testing.T) {
+	limits := Limits{MaxLineLength: 10, MaxLines: 3}
+	tests := []struct {
+		name    string
+		content string
+		want    *ViolationError
+	}{
+		{"within limits", "port = 80\nhost = \"é\"\n", nil},
+		{"long line", "a\nkey = value!\n", &ViolationError{Line: 1, Rule: RuleMaxLength, Limit: 10, Actual: 12, Reason: "is 12 characters, limit 10"}},
+		{"too many lines", "a\nb\nc\nd", &ViolationError{Line: 3, Rule: RuleMaxLines, Limit: 3, Actual: 4, Reason: "is past the end: 4 lines, limit 3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := limits.Validate(tt.content)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Validate() error: %v", err)
+				}
+				return
+			}
+			var violation *ViolationError
+			if !errors.As(err, &violation) || *violation != *tt.want {
+				t.Errorf("Validate() = %#v, want %#v", err, tt.want)
+			}
+		})
+	}
+	if err := (Limits{}).Validate(strings.Repeat("x", 10000)); err != nil {
+		t.Errorf("zero Limits: Validate() error: %v", err)
+	}
+}
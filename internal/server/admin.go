@@ -0,0 +1,1131 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/jsondoc"
+	"collaborative-docs/internal/sanitize"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/internal/textnorm"
+	"collaborative-docs/internal/validators"
+)
+
+// maxBulkLineSize bounds one NDJSON record in a bulk request: a document of
+// maxContentSize plus room for its ID and JSON escaping.
+const maxBulkLineSize = 2*maxContentSize + 1024
+
+// bulkDocument is one record of a bulk import or export.
+type bulkDocument struct {
+	ID           string        `json:"id"`
+	Kind         document.Kind `json:"kind,omitempty"`
+	Content      string        `json:"content"`
+	Version      int           `json:"version,omitempty"`
+	LastModified *time.Time    `json:"last_modified,omitempty"`
+}
+
+// bulkResult reports the outcome for one record of a bulk import or delete.
+type bulkResult struct {
+	Line    int    `json:"line"`
+	ID      string `json:"id,omitempty"`
+	Version int    `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// registerAdminRoutes sets up the bulk migration API and the debug
+// endpoints, which list every document ID. They are only served when an
+// admin token is configured.
+func (s *Server) registerAdminRoutes() {
+	s.mux.HandleFunc("/admin/documents/import", s.requireAdmin(s.handleBulkImport))
+	s.mux.HandleFunc("/admin/documents/export", s.requireAdmin(s.handleBulkExport))
+	s.mux.HandleFunc("/admin/documents/delete", s.requireAdmin(s.handleBulkDelete))
+	s.mux.HandleFunc("/admin/documents/pause", s.requireAdmin(s.handlePause))
+	s.mux.HandleFunc("/admin/documents/resume", s.requireAdmin(s.handlePause))
+	s.mux.HandleFunc("/admin/documents/features", s.requireAdmin(s.handleFeatures))
+	s.mux.HandleFunc("/admin/documents/sanitize", s.requireAdmin(s.handleSanitize))
+	s.mux.HandleFunc("/admin/documents/normalization", s.requireAdmin(s.handleNormalization))
+	s.mux.HandleFunc("/admin/documents/conflict_policy", s.requireAdmin(s.handleConflictPolicy))
+	s.mux.HandleFunc("/admin/documents/limits", s.requireAdmin(s.handleLimits))
+	s.mux.HandleFunc("/admin/documents/history", s.requireAdmin(s.handleHistoryWindow))
+	s.mux.HandleFunc("/admin/documents/validator", s.requireAdmin(s.handleValidator))
+	s.mux.HandleFunc("/admin/documents/undo", s.requireAdmin(s.handleUndo))
+	s.mux.HandleFunc("/admin/documents/redact", s.requireAdmin(s.handleRedact))
+	s.mux.HandleFunc("/admin/documents/rebuild", s.requireAdmin(s.handleRebuild))
+	s.mux.HandleFunc("/admin/users/erase", s.requireAdmin(s.handleEraseUser))
+	s.mux.HandleFunc("/admin/documents/visibility", s.requireAdmin(s.handleVisibility))
+	s.mux.HandleFunc("/admin/documents/access", s.requireAdmin(s.handleAccess))
+	s.mux.HandleFunc("/admin/teams", s.requireAdmin(s.handleTeams))
+	s.mux.HandleFunc("/admin/documents/schedule", s.requireAdmin(s.handleSchedule))
+	s.mux.HandleFunc("/admin/clients", s.requireAdmin(s.handleClients))
+	s.mux.HandleFunc("/admin/replication/stream", s.requireAdmin(s.primary.ServeHTTP))
+	s.mux.HandleFunc("/admin/replication/status", s.requireAdmin(s.handleReplicationStatus))
+	s.mux.HandleFunc("/admin/replication/promote", s.requireAdmin(s.handlePromote))
+	s.mux.HandleFunc("/debug/latency", s.requireAdmin(s.handleLatency))
+	s.mux.HandleFunc("/debug/stats", s.requireAdmin(s.handleStats))
+	s.mux.HandleFunc("/debug/dashboard", s.requireAdmin(s.handleDashboard))
+	s.mux.HandleFunc("/debug/goroutines", s.requireAdmin(s.handleGoroutines))
+}
+
+// requireAdmin rejects requests without the configured bearer token.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether r carries the configured admin bearer token.
+func (s *Server) isAdmin(r *http.Request) bool {
+	if s.config.AdminToken == "" {
+		return false
+	}
+	want := []byte("Bearer " + s.config.AdminToken)
+	got := []byte(r.Header.Get("Authorization"))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// handleBulkImport creates documents from an NDJSON body of
+// {"id", "content"} records and streams one result per record. Existing
+// documents are left untouched and reported as errors, so an interrupted
+// migration can be rerun.
+func (s *Server) handleBulkImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.streamBulk(w, r, func(line []byte) bulkResult {
+		var rec bulkDocument
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return bulkResult{Error: "invalid record: " + err.Error()}
+		}
+		result := bulkResult{ID: rec.ID}
+		if !isValidDocumentID(rec.ID) {
+			result.Error = "invalid document ID"
+			return result
+		}
+		if rec.Kind != "" && rec.Kind != document.KindText {
+			result.Error = fmt.Sprintf("cannot import %s documents", rec.Kind)
+			return result
+		}
+
+		version, err := s.hub.ReplaceContent(rec.ID, rec.Content, 0)
+		var conflict *document.VersionConflictError
+		switch {
+		case errors.As(err, &conflict):
+			result.Error = "document already exists"
+		case err != nil:
+			result.Error = err.Error()
+		default:
+			result.Version = version
+		}
+		return result
+	})
+}
+
+// handleBulkDelete deletes the documents named by an NDJSON body of {"id"}
+// records and streams one result per record. With ?archive=true connected
+// clients keep a read-only copy, as with DELETE /api/documents/{id}.
+func (s *Server) handleBulkDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	archive := r.URL.Query().Get("archive") == "true"
+
+	s.streamBulk(w, r, func(line []byte) bulkResult {
+		var rec bulkDocument
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return bulkResult{Error: "invalid record: " + err.Error()}
+		}
+		result := bulkResult{ID: rec.ID}
+		if err := s.hub.DeleteDocument(rec.ID, archive); err != nil {
+			result.Error = err.Error()
+		}
+		return result
+	})
+}
+
+// handleBulkExport streams every loaded document as NDJSON, optionally
+// limited to IDs starting with ?prefix=. With ?resolve_embeds=true text
+// documents are exported with their embeds filled in, for publishing; such
+// an export no longer round-trips through import.
+func (s *Server) handleBulkExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+
+	for _, id := range s.hub.DocumentIDs() {
+		if !strings.HasPrefix(id, prefix) {
+			continue
+		}
+		doc := s.hub.GetDocument(id)
+		if doc == nil {
+			continue // deleted since listing
+		}
+		content, version := doc.GetContentAndVersion()
+		_, lastModified, _ := doc.GetStats()
+		rec := bulkDocument{
+			ID:           id,
+			Kind:         doc.GetKind(),
+			Content:      s.resolveEmbeds(r, id, doc.GetKind(), content),
+			Version:      version,
+			LastModified: &lastModified,
+		}
+		if err := enc.Encode(rec); err != nil {
+			log.Printf("bulk export aborted: %v", err)
+			return
+		}
+		rc.Flush()
+	}
+}
+
+// pauseRequest is the body of POST /admin/documents/pause and /resume.
+type pauseRequest struct {
+	ID           string `json:"id"`
+	Reason       string `json:"reason"`
+	Queue        bool   `json:"queue"`
+	RetryAfterMS int    `json:"retry_after_ms"`
+}
+
+// handlePause pauses or resumes edits to one document, depending on the
+// path.
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req pauseRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isValidDocumentID(req.ID) {
+		http.Error(w, "invalid document ID", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if strings.HasSuffix(r.URL.Path, "/resume") {
+		err = s.hub.ResumeDocument(req.ID)
+	} else {
+		err = s.hub.PauseDocument(req.ID, hub.PauseOptions{
+			Reason:     req.Reason,
+			Queue:      req.Queue,
+			RetryAfter: time.Duration(req.RetryAfterMS) * time.Millisecond,
+		})
+	}
+	switch {
+	case errors.Is(err, hub.ErrNotPaused):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, hub.ErrDocumentDeleted):
+		http.Error(w, err.Error(), http.StatusGone)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// featuresRequest is the body of POST /admin/documents/features.
+type featuresRequest struct {
+	ID       string          `json:"id"`
+	Features map[string]bool `json:"features"`
+}
+
+// handleFeatures reports (GET ?id=) or changes (POST) which optional
+// features a document has enabled. Both answer with the resulting flags.
+func (s *Server) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch r.Method {
+	case http.MethodGet:
+		id = r.URL.Query().Get("id")
+		if !isValidDocumentID(id) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodPost:
+		var req featuresRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isValidDocumentID(req.ID) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		err := s.hub.SetDocumentFeatures(req.ID, req.Features)
+		switch {
+		case errors.Is(err, hub.ErrUnknownFeature):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		id = req.ID
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.hub.DocumentFeatures(id))
+}
+
+// sanitizeRequest is the body of POST /admin/documents/sanitize.
+type sanitizeRequest struct {
+	ID     string          `json:"id"`
+	Policy sanitize.Policy `json:"policy"`
+}
+
+// handleSanitize reports (GET ?id=) or changes (POST) how text inserted
+// into a document is cleaned. Both answer with the resulting policy.
+func (s *Server) handleSanitize(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch r.Method {
+	case http.MethodGet:
+		id = r.URL.Query().Get("id")
+		if !isValidDocumentID(id) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodPost:
+		var req sanitizeRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isValidDocumentID(req.ID) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		err := s.hub.SetSanitizer(req.ID, req.Policy)
+		switch {
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		id = req.ID
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.hub.Sanitizer(id))
+}
+
+// normalizationRequest is the body of POST /admin/documents/normalization.
+type normalizationRequest struct {
+	ID   string        `json:"id"`
+	Form textnorm.Form `json:"form"`
+}
+
+// normalizationResponse reports a document's normalization form.
+type normalizationResponse struct {
+	Form textnorm.Form `json:"form"`
+}
+
+// handleNormalization reports (GET ?id=) or changes (POST) the Unicode
+// form a document's text is kept in: "nfc", "nfd", or "" for none. Both
+// answer with the resulting form.
+func (s *Server) handleNormalization(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch r.Method {
+	case http.MethodGet:
+		id = r.URL.Query().Get("id")
+		if !isValidDocumentID(id) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodPost:
+		var req normalizationRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isValidDocumentID(req.ID) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		err := s.hub.SetNormalization(req.ID, req.Form)
+		switch {
+		case errors.Is(err, hub.ErrUnknownNormalization):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		id = req.ID
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, normalizationResponse{Form: s.hub.Normalization(id)})
+}
+
+// conflictPolicyRequest is the body of POST /admin/documents/conflict_policy.
+type conflictPolicyRequest struct {
+	ID     string                 `json:"id"`
+	Policy jsondoc.ConflictPolicy `json:"policy"`
+}
+
+// conflictPolicyResponse reports a document's conflict policy.
+type conflictPolicyResponse struct {
+	Policy jsondoc.ConflictPolicy `json:"policy"`
+}
+
+// handleConflictPolicy reports (GET ?id=) or changes (POST) how a JSON
+// document's stale edits of a cell are handled: "last_writer_wins",
+// "reject" or "merge". Both answer with the resulting policy.
+func (s *Server) handleConflictPolicy(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch r.Method {
+	case http.MethodGet:
+		id = r.URL.Query().Get("id")
+		if !isValidDocumentID(id) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodPost:
+		var req conflictPolicyRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isValidDocumentID(req.ID) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		err := s.hub.SetConflictPolicy(req.ID, req.Policy)
+		switch {
+		case errors.Is(err, hub.ErrUnknownConflictPolicy):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		id = req.ID
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, conflictPolicyResponse{Policy: s.hub.ConflictPolicy(id)})
+}
+
+// limitsRequest is the body of POST /admin/documents/limits.
+type limitsRequest struct {
+	ID string `json:"id"`
+	schema.Limits
+}
+
+// handleLimits reports (GET ?id=) or changes (POST) the maximum line length
+// and line count a document enforces. Both answer with the resulting
+// limits. Limits the current content already breaks answer 409 with the
+// violation.
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch r.Method {
+	case http.MethodGet:
+		id = r.URL.Query().Get("id")
+		if !isValidDocumentID(id) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodPost:
+		var req limitsRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isValidDocumentID(req.ID) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		err := s.hub.SetLimits(req.ID, req.Limits)
+		var violation *schema.ViolationError
+		switch {
+		case errors.Is(err, hub.ErrInvalidLimits):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case errors.As(err, &violation):
+			writeJSON(w, http.StatusConflict, violationResponse{Error: err.Error(), Violation: violation})
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		id = req.ID
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.hub.Limits(id))
+}
+
+// historyWindow is the body of POST /admin/documents/history and the
+// response to both methods.
+type historyWindow struct {
+	ID       string `json:"id,omitempty"`
+	Versions int    `json:"versions"`
+	MaxAgeMS int64  `json:"max_age_ms"`
+}
+
+// handleHistoryWindow reports (GET ?id=) or changes (POST) how many
+// versions, and how old, of a document's operations are kept for
+// transforming late edits. Both answer with the resulting window.
+func (s *Server) handleHistoryWindow(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch r.Method {
+	case http.MethodGet:
+		id = r.URL.Query().Get("id")
+		if !isValidDocumentID(id) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodPost:
+		var req historyWindow
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isValidDocumentID(req.ID) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		err := s.hub.SetHistoryWindow(req.ID, document.HistoryWindow{
+			Versions: req.Versions,
+			MaxAge:   time.Duration(req.MaxAgeMS) * time.Millisecond,
+		})
+		switch {
+		case errors.Is(err, hub.ErrInvalidHistoryWindow):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		id = req.ID
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window := s.hub.HistoryWindow(id)
+	writeJSON(w, http.StatusOK, historyWindow{Versions: window.Versions, MaxAgeMS: window.MaxAge.Milliseconds()})
+}
+
+// validatorRequest is the body of POST /admin/documents/validator.
+type validatorRequest struct {
+	ID        string `json:"id"`
+	Validator string `json:"validator"`
+}
+
+// validatorResponse reports a document's validator and what it last found.
+type validatorResponse struct {
+	Validator   string                  `json:"validator"`
+	Diagnostics []validators.Diagnostic `json:"diagnostics"`
+}
+
+// handleValidator reports (GET ?id=) or changes (POST) the syntax
+// validator a document is checked with: "json", "yaml", "toml", or "" for
+// none. Both answer with the validator and its current diagnostics.
+func (s *Server) handleValidator(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch r.Method {
+	case http.MethodGet:
+		id = r.URL.Query().Get("id")
+		if !isValidDocumentID(id) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodPost:
+		var req validatorRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isValidDocumentID(req.ID) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		err := s.hub.SetValidator(req.ID, req.Validator)
+		switch {
+		case errors.Is(err, hub.ErrUnknownValidator):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		id = req.ID
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	diagnostics, validator := s.hub.Diagnostics(id)
+	if diagnostics == nil {
+		diagnostics = []validators.Diagnostic{}
+	}
+	writeJSON(w, http.StatusOK, validatorResponse{Validator: validator, Diagnostics: diagnostics})
+}
+
+// undoRequest is the body of POST /admin/documents/undo.
+type undoRequest struct {
+	ID      string `json:"id"`
+	Version int    `json:"version"` // Version whose change is reverted
+}
+
+// handleUndo reverts the change that produced one version of a document,
+// keeping later edits, and answers with the document's new version. A
+// change that later edits overlap cannot be undone and answers 409.
+func (s *Server) handleUndo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req undoRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isValidDocumentID(req.ID) {
+		http.Error(w, "invalid document ID", http.StatusBadRequest)
+		return
+	}
+	if s.hub.GetDocument(req.ID) == nil && !s.hub.IsDeleted(req.ID) {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	version, err := s.hub.UndoVersion(req.ID, req.Version)
+	switch {
+	case errors.Is(err, hub.ErrDocumentDeleted), errors.Is(err, document.ErrVersionUnavailable):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, document.ErrUndoConflict), errors.Is(err, hub.ErrDocumentPaused):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeJSON(w, http.StatusOK, documentVersionResponse{Version: version})
+	}
+}
+
+// redactRequest is the body of POST /admin/documents/redact. It names
+// either a pattern or a version range.
+type redactRequest struct {
+	ID          string `json:"id"`
+	Pattern     string `json:"pattern"` // Go regular expression
+	FromVersion int    `json:"from_version"`
+	ToVersion   int    `json:"to_version"`
+	Placeholder string `json:"placeholder"`
+}
+
+// handleRedact scrubs text from a document and its history and answers
+// with the document's new version.
+func (s *Server) handleRedact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req redactRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isValidDocumentID(req.ID) {
+		http.Error(w, "invalid document ID", http.StatusBadRequest)
+		return
+	}
+	redaction := document.Redaction{
+		FromVersion: req.FromVersion,
+		ToVersion:   req.ToVersion,
+		Placeholder: req.Placeholder,
+	}
+	if req.Pattern != "" {
+		pattern, err := regexp.Compile(req.Pattern)
+		if err != nil {
+			http.Error(w, "invalid pattern: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		redaction.Pattern = pattern
+	}
+	if s.hub.GetDocument(req.ID) == nil && !s.hub.IsDeleted(req.ID) {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	version, err := s.hub.Redact(req.ID, redaction)
+	switch {
+	case errors.Is(err, hub.ErrDocumentDeleted), errors.Is(err, document.ErrVersionUnavailable):
+		http.Error(w, err.Error(), http.StatusGone)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeJSON(w, http.StatusOK, documentVersionResponse{Version: version})
+	}
+}
+
+// rebuildRequest is the body of POST /admin/documents/rebuild.
+type rebuildRequest struct {
+	ID string `json:"id"`
+}
+
+// handleRebuild rebuilds a corrupt document from the store's operation
+// log and answers with where replay started and ended.
+func (s *Server) handleRebuild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req rebuildRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isValidDocumentID(req.ID) {
+		http.Error(w, "invalid document ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.hub.Rebuild(req.ID)
+	switch {
+	case errors.Is(err, hub.ErrNoOpLog):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case errors.Is(err, hub.ErrNothingToRebuild):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, hub.ErrDocumentDeleted):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, hub.ErrHubStopped):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// eraseUserRequest is the body of POST /admin/users/erase.
+type eraseUserRequest struct {
+	UserID      string `json:"user_id"`
+	Replacement string `json:"replacement"` // Anonymize instead of removing
+}
+
+// handleEraseUser removes a user ID from all document metadata,
+// preferences and access lists and answers with a report of what changed.
+func (s *Server) handleEraseUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req eraseUserRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	report, err := s.hub.EraseUser(req.UserID, req.Replacement)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// visibilityRequest is the body of POST /admin/documents/visibility.
+type visibilityRequest struct {
+	ID         string              `json:"id"`
+	Visibility document.Visibility `json:"visibility"`
+}
+
+// visibilityResponse reports who may open a document. LinkToken is passed
+// as ?token= by clients opening a link or public document.
+type visibilityResponse struct {
+	Visibility document.Visibility `json:"visibility"`
+	LinkToken  string              `json:"link_token,omitempty"`
+}
+
+// handleVisibility sets who may open a document and answers with its
+// visibility and link token.
+func (s *Server) handleVisibility(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req visibilityRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isValidDocumentID(req.ID) {
+		http.Error(w, "invalid document ID", http.StatusBadRequest)
+		return
+	}
+	if !req.Visibility.Valid() {
+		http.Error(w, fmt.Sprintf("unknown visibility %q", req.Visibility), http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.hub.SetVisibility(req.ID, req.Visibility)
+	switch {
+	case errors.Is(err, hub.ErrDocumentDeleted):
+		http.Error(w, err.Error(), http.StatusGone)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, visibilityResponse{Visibility: req.Visibility, LinkToken: token})
+	}
+}
+
+// accessRequest is the body of POST /admin/documents/access. A nil Owner
+// or Team leaves it unchanged.
+type accessRequest struct {
+	ID    string                   `json:"id"`
+	Owner *string                  `json:"owner"`
+	Team  *string                  `json:"team"`
+	Roles map[string]document.Role `json:"roles"` // By user ID; "" removes the entry
+}
+
+// accessResponse reports who owns a document, the team owning it and the
+// roles given to users.
+type accessResponse struct {
+	Owner string                   `json:"owner,omitempty"`
+	Team  string                   `json:"team,omitempty"`
+	Roles map[string]document.Role `json:"roles"`
+}
+
+// handleAccess reports (GET ?id=) or changes (POST) a document's owner,
+// team and the roles given to users. Both answer with the resulting
+// access list.
+func (s *Server) handleAccess(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch r.Method {
+	case http.MethodGet:
+		id = r.URL.Query().Get("id")
+		if !isValidDocumentID(id) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+
+	case http.MethodPost:
+		var req accessRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isValidDocumentID(req.ID) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		err := s.hub.SetRoles(req.ID, req.Roles)
+		if err == nil && req.Owner != nil {
+			err = s.hub.SetOwner(req.ID, *req.Owner)
+		}
+		if err == nil && req.Team != nil {
+			err = s.hub.SetTeam(req.ID, *req.Team)
+		}
+		switch {
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case errors.Is(err, hub.ErrTeamNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id = req.ID
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := accessResponse{Roles: map[string]document.Role{}}
+	if doc := s.hub.GetDocument(id); doc != nil {
+		resp.Owner, resp.Team, resp.Roles = doc.Owner(), doc.Team(), doc.Roles()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// teamRequest is the body of POST /admin/teams.
+type teamRequest struct {
+	ID      string                   `json:"id"`
+	Name    string                   `json:"name"`    // Only used when creating the team
+	Members map[string]document.Role `json:"members"` // By user ID; "" removes the member
+}
+
+// handleTeams lists teams (GET), or one with ?id=, creates or updates one
+// (POST), answering with the result, or deletes one (DELETE ?id=).
+func (s *Server) handleTeams(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	switch r.Method {
+	case http.MethodGet:
+		if id == "" {
+			writeJSON(w, http.StatusOK, s.hub.Teams())
+			return
+		}
+		t := s.hub.Team(id)
+		if t == nil {
+			http.Error(w, "team not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+
+	case http.MethodPost:
+		var req teamRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isValidDocumentID(req.ID) {
+			http.Error(w, "invalid team ID", http.StatusBadRequest)
+			return
+		}
+		err := s.hub.CreateTeam(req.ID, req.Name)
+		if errors.Is(err, hub.ErrTeamExists) {
+			err = nil
+		}
+		if err == nil && len(req.Members) > 0 {
+			err = s.hub.SetTeamMembers(req.ID, req.Members)
+		}
+		switch {
+		case errors.Is(err, hub.ErrTeamNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeJSON(w, http.StatusOK, s.hub.Team(req.ID))
+		}
+
+	case http.MethodDelete:
+		err := s.hub.DeleteTeam(id)
+		switch {
+		case errors.Is(err, hub.ErrTeamNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// scheduleRequest is the body of POST /admin/documents/schedule.
+type scheduleRequest struct {
+	ID string `json:"id"`
+	document.ScheduledAction
+}
+
+// handleSchedule lists (GET ?id=), adds (POST) or cancels
+// (DELETE ?id=&action_id=) a document's scheduled actions. Adding answers
+// with the stored action, including the ID that cancels it.
+func (s *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	switch r.Method {
+	case http.MethodGet:
+		if !isValidDocumentID(id) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		actions := s.hub.ScheduledActions(id)
+		if actions == nil {
+			actions = []document.ScheduledAction{}
+		}
+		writeJSON(w, http.StatusOK, actions)
+
+	case http.MethodPost:
+		var req scheduleRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !isValidDocumentID(req.ID) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		a, err := s.hub.ScheduleAction(req.ID, req.ScheduledAction)
+		switch {
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+		case errors.Is(err, hub.ErrDocumentNotFound), errors.Is(err, document.ErrInviteNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeJSON(w, http.StatusCreated, a)
+		}
+
+	case http.MethodDelete:
+		if !isValidDocumentID(id) {
+			http.Error(w, "invalid document ID", http.StatusBadRequest)
+			return
+		}
+		err := s.hub.CancelScheduled(id, r.URL.Query().Get("action_id"))
+		switch {
+		case errors.Is(err, hub.ErrDocumentNotFound), errors.Is(err, document.ErrScheduledNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// streamBulk applies fn to each non-empty line of an NDJSON request body
+// and streams the results as NDJSON. Bulk requests may run far longer than
+// the server's read and write timeouts, so those are lifted.
+func (s *Server) streamBulk(w http.ResponseWriter, r *http.Request, fn func(line []byte) bulkResult) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	rc.EnableFullDuplex()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkLineSize)
+
+	var ok, failed int
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		result := fn(line)
+		result.Line = n
+		if result.Error != "" {
+			failed++
+		} else {
+			ok++
+		}
+		if err := enc.Encode(result); err != nil {
+			log.Printf("bulk request aborted: %v", err)
+			return
+		}
+		rc.Flush()
+	}
+	if err := scanner.Err(); err != nil {
+		enc.Encode(bulkResult{Error: "reading request: " + err.Error()})
+	}
+
+	if s.config.LogEnabled {
+		log.Printf("bulk %s: %d succeeded, %d failed", r.URL.Path, ok, failed)
+	}
+}
+
+// handleClients lists connections with their traffic and abuse scores,
+// most suspicious first.
+func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clients, err := s.hub.Clients()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, clients)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"collaborative-docs/internal/auth"
+	"collaborative-docs/internal/handshake"
+)
+
+// authenticate checks the request's credentials against the configured
+// identity provider and returns the identity, or answers 401 and returns
+// nil. The token is read from "Authorization: Bearer" or, for browser
+// WebSockets that cannot set headers, ?auth_token=. Without a provider, or
+// for admin-authorized requests, every request passes with an anonymous
+// identity.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) *auth.Identity {
+	if s.config.Auth == nil || s.isAdmin(r) {
+		return &auth.Identity{}
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("auth_token")
+	}
+	if token == "" {
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return nil
+	}
+
+	id, err := s.config.Auth.ValidateToken(r.Context(), token)
+	switch {
+	case errors.Is(err, auth.ErrInvalidToken):
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return nil
+	case err != nil:
+		log.Printf("identity provider failed: %v", err)
+		http.Error(w, "identity provider unavailable", http.StatusServiceUnavailable)
+		return nil
+	}
+	return id
+}
+
+// withIdentity returns r carrying the identity authenticate returned, so
+// documentAccess can find the user's role.
+func withIdentity(r *http.Request, id *auth.Identity) *http.Request {
+	return r.WithContext(handshake.WithIdentity(r.Context(), id))
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/schema"
+)
+
+// checkpointRequest is the body of POST /api/documents/{id}/checkpoints.
+type checkpointRequest struct {
+	Label string `json:"label"`
+}
+
+// checkpointSummary describes a checkpoint in a listing, without its
+// content.
+type checkpointSummary struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label"`
+	Version   int       `json:"version"`
+	Length    int       `json:"length"` // Of the content, in bytes
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// handleCheckpoints serves /api/documents/{id}/checkpoints. GET lists a
+// document's checkpoints, oldest first, to anyone who may read it. POST
+// creates one of the current content, for those who may edit it.
+func (s *Server) handleCheckpoints(w http.ResponseWriter, r *http.Request, documentID string) {
+	required := accessWrite
+	if r.Method == http.MethodGet {
+		required = accessRead
+	}
+	doc, ok := s.checkpointDocument(w, r, documentID, required)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		summaries := []checkpointSummary{}
+		for _, cp := range doc.Checkpoints() {
+			summaries = append(summaries, checkpointSummary{
+				ID:        cp.ID,
+				Label:     cp.Label,
+				Version:   cp.Version,
+				Length:    len(cp.Content),
+				CreatedAt: cp.CreatedAt,
+			})
+		}
+		writeJSON(w, http.StatusOK, summaries)
+
+	case http.MethodPost:
+		var req checkpointRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cp, err := doc.CreateCheckpoint(req.Label)
+		switch {
+		case errors.Is(err, document.ErrWrongKind), errors.Is(err, document.ErrTooManyCheckpoints):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeJSON(w, http.StatusCreated, cp)
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCheckpoint serves /api/documents/{id}/checkpoints/{checkpoint} and
+// its /restore. GET returns the checkpoint with its content to anyone who
+// may read the document. POST .../restore sets the document's content back
+// to the checkpoint's, as new versions that reach connected clients, for
+// those who may edit it. DELETE removes the checkpoint; so that whoever
+// made the bad edits cannot also remove the way back, only admins, the
+// owner and maintainers may.
+func (s *Server) handleCheckpoint(w http.ResponseWriter, r *http.Request, documentID, path string) {
+	checkpointID, action, _ := strings.Cut(path, "/")
+	var required access
+	switch {
+	case action == "restore" && r.Method == http.MethodPost:
+		required = accessWrite
+	case action == "" && r.Method == http.MethodGet:
+		required = accessRead
+	case action == "" && r.Method == http.MethodDelete:
+		required = accessFences
+	case action == "" || action == "restore":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	doc, ok := s.checkpointDocument(w, r, documentID, required)
+	if !ok {
+		return
+	}
+	cp, err := doc.Checkpoint(checkpointID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, cp)
+
+	case r.Method == http.MethodDelete:
+		if err := doc.DeleteCheckpoint(cp.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		if err := s.checkFences(r, documentID, cp.Content); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		version, err := s.hub.RestoreCheckpoint(documentID, cp.ID)
+		var violation *schema.ViolationError
+		switch {
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+		case errors.Is(err, hub.ErrDocumentNotFound), errors.Is(err, document.ErrCheckpointNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, hub.ErrDocumentPaused):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case errors.As(err, &violation):
+			writeJSON(w, http.StatusUnprocessableEntity, violationResponse{Error: err.Error(), Violation: violation})
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusOK, documentVersionResponse{Version: version})
+		}
+	}
+}
+
+// checkpointDocument returns the document whose checkpoints r is for,
+// answering the request itself if the ID is invalid, the requester lacks
+// the access level required, or the document is deleted or missing.
+func (s *Server) checkpointDocument(w http.ResponseWriter, r *http.Request, documentID string, required access) (*document.Document, bool) {
+	if !isValidDocumentID(documentID) {
+		http.Error(w, "invalid document ID", http.StatusBadRequest)
+		return nil, false
+	}
+	if s.documentAccess(r, documentID) < required {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	if s.hub.IsDeleted(documentID) {
+		http.Error(w, hub.ErrDocumentDeleted.Error(), http.StatusGone)
+		return nil, false
+	}
+	doc := s.hub.GetDocument(documentID)
+	if doc == nil {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return nil, false
+	}
+	return doc, true
+}
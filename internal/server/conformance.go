@@ -0,0 +1,36 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"collaborative-docs/conformance"
+)
+
+// handleConformance serves /api/conformance, for client implementations
+// checking they speak the protocol as this server does. GET returns the
+// published test vectors; POST checks a client's results against them and
+// returns the report.
+func (s *Server) handleConformance(w http.ResponseWriter, r *http.Request) {
+	if s.authenticate(w, r) == nil {
+		return
+	}
+	vectors, err := conformance.Published()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, vectors)
+	case http.MethodPost:
+		var results conformance.Results
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&results); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, conformance.Check(vectors, &results))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/internal/slug"
+)
+
+// createDocumentRequest is the body of POST /api/documents.
+type createDocumentRequest struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+// createdDocument reports the ID chosen for a new document.
+type createdDocument struct {
+	ID string `json:"id"`
+}
+
+// handleDocuments serves /api/documents: GET lists documents and POST
+// creates one.
+func (s *Server) handleDocuments(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListDocuments(w, r)
+	case http.MethodPost:
+		if !s.refuseStandby(w) {
+			s.handleCreateDocument(w, r)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCreateDocument serves POST /api/documents: it creates a text
+// document holding the given content, empty by default, with an ID made
+// from the title, or a random one without, and answers 201 with the ID.
+// Taken IDs are retried with a suffix, and the generated ID never contains
+// a denied word or starts with a reserved prefix; see Config.IDs. Content
+// that breaks the schema for the ID answers 422. An authenticated creator
+// owns the document.
+func (s *Server) handleCreateDocument(w http.ResponseWriter, r *http.Request) {
+	identity := s.authenticate(w, r)
+	if identity == nil {
+		return
+	}
+	var req createDocumentRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var createErr error
+	id, err := s.config.IDs.Generate(req.Title, func(id string) bool {
+		createErr = s.hub.CreateDocument(id, req.Content)
+		// Only a taken ID is worth retrying; other failures are reported
+		// below.
+		return !errors.Is(createErr, hub.ErrDocumentExists)
+	})
+	var violation *schema.ViolationError
+	switch {
+	case errors.Is(createErr, hub.ErrHubStopped):
+		http.Error(w, createErr.Error(), http.StatusServiceUnavailable)
+	case errors.As(createErr, &violation):
+		writeJSON(w, http.StatusUnprocessableEntity, violationResponse{Error: createErr.Error(), Violation: violation})
+	case createErr != nil:
+		http.Error(w, createErr.Error(), http.StatusInternalServerError)
+	case errors.Is(err, slug.ErrExhausted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		if identity.Subject != "" {
+			if err := s.hub.SetOwner(id, identity.Subject); err != nil {
+				log.Printf("failed to record owner of document %s: %v", id, err)
+			}
+		}
+		w.Header().Set("Location", "/api/documents/"+id)
+		writeJSON(w, http.StatusCreated, createdDocument{ID: id})
+	}
+}
+
+// reservedID reports whether r would create documentID under a reserved
+// prefix without admin authorization. Documents that already exist are
+// not affected, so admins can create reserved documents and share them.
+func (s *Server) reservedID(r *http.Request, documentID string) bool {
+	return s.config.IDs.IsReserved(documentID) && !s.isAdmin(r) && s.hub.GetDocument(documentID) == nil
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/embed"
+)
+
+// resolveEmbeds returns content, the content of a text document, with its
+// ![[doc-id]] embeds filled in when r asks for ?resolve_embeds=true.
+// Documents r may not read are left as references, so embedding cannot
+// reveal a private document.
+func (s *Server) resolveEmbeds(r *http.Request, documentID string, kind document.Kind, content string) string {
+	if kind != document.KindText || r.URL.Query().Get("resolve_embeds") != "true" {
+		return content
+	}
+	return embed.Resolve(documentID, content, func(id string) (string, bool) {
+		if s.hub.IsDeleted(id) || s.documentAccess(r, id) < accessRead {
+			return "", false
+		}
+		doc := s.hub.GetDocument(id)
+		if doc == nil || doc.GetKind() != document.KindText {
+			return "", false
+		}
+		return doc.GetContent(), true
+	})
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+
+	"collaborative-docs/internal/export"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/render"
+)
+
+// handleExport serves GET /api/documents/{id}/export?format=md|html|txt:
+// the document's current content as a file to download. Rich text is
+// converted to Markdown or HTML markup.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isValidDocumentID(documentID) {
+		http.NotFound(w, r)
+		return
+	}
+	format, err := export.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.documentAccess(r, documentID) < accessRead {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if s.hub.IsDeleted(documentID) {
+		http.Error(w, hub.ErrDocumentDeleted.Error(), http.StatusGone)
+		return
+	}
+	doc := s.hub.GetDocument(documentID)
+	if doc == nil {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	content, formatting, version := doc.FormattedContent()
+	file, err := export.Export(render.Input{
+		Title:      documentID,
+		Kind:       doc.GetKind(),
+		Language:   doc.GetLanguage(),
+		Content:    content,
+		Version:    version,
+		Formatting: formatting,
+	}, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	w.Write(file.Data)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/hub"
+)
+
+// fencesRequest is the body of PUT /api/documents/{id}/fences.
+type fencesRequest struct {
+	Version *int            `json:"version"` // The version whose content the positions are in
+	Fences  document.Fences `json:"fences"`
+}
+
+// fencesResponse reports a document's fences and the version whose
+// content they fence.
+type fencesResponse struct {
+	Version int             `json:"version"`
+	Fences  document.Fences `json:"fences"`
+}
+
+// handleFences serves /api/documents/{id}/fences. GET returns the fenced
+// ranges of a document, which anyone who may read it may see. PUT replaces
+// them, for those who may edit fences: admins, the owner and maintainers.
+func (s *Server) handleFences(w http.ResponseWriter, r *http.Request, documentID string) {
+	if !isValidDocumentID(documentID) {
+		http.Error(w, "invalid document ID", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if s.documentAccess(r, documentID) < accessRead {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if s.hub.IsDeleted(documentID) {
+			http.Error(w, hub.ErrDocumentDeleted.Error(), http.StatusGone)
+			return
+		}
+		doc := s.hub.GetDocument(documentID)
+		if doc == nil {
+			http.Error(w, "document not found", http.StatusNotFound)
+			return
+		}
+		s.writeFences(w, doc)
+
+	case http.MethodPut:
+		if s.documentAccess(r, documentID) < accessFences || s.reservedID(r, documentID) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var req fencesRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Version == nil {
+			http.Error(w, "version is required", http.StatusBadRequest)
+			return
+		}
+
+		err := s.hub.SetFences(documentID, *req.Version, req.Fences)
+		var conflict *document.VersionConflictError
+		switch {
+		case errors.As(err, &conflict):
+			writeJSON(w, http.StatusConflict, documentVersionResponse{Version: conflict.Actual, Error: err.Error()})
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+		case errors.Is(err, document.ErrWrongKind):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			s.writeFences(w, s.hub.GetDocument(documentID))
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeFences answers with a document's current fences, in position order.
+func (s *Server) writeFences(w http.ResponseWriter, doc *document.Document) {
+	fences, version := doc.Fences()
+	if fences == nil {
+		fences = document.Fences{}
+	}
+	writeJSON(w, http.StatusOK, fencesResponse{Version: version, Fences: fences})
+}
+
+// checkFences returns an error wrapping document.ErrFenced if r may not
+// edit fences and replacing a document's content with content would change
+// its fenced text.
+func (s *Server) checkFences(r *http.Request, documentID, content string) error {
+	doc := s.hub.GetDocument(documentID)
+	if doc == nil || s.documentAccess(r, documentID) >= accessFences {
+		return nil
+	}
+	return doc.CheckFencedContent(content)
+}
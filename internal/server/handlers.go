@@ -1,11 +1,23 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/handshake"
 	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/outline"
+	"collaborative-docs/internal/protocol"
+	"collaborative-docs/internal/schema"
 
 	"github.com/gorilla/websocket"
 )
@@ -37,6 +49,14 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin:     checkOrigin,
 }
 
+// upgrade upgrades r to a WebSocket, negotiating permessage-deflate when
+// the hub compresses messages.
+func (s *Server) upgrade(w http.ResponseWriter, r *http.Request, header http.Header) (*websocket.Conn, error) {
+	u := upgrader
+	u.EnableCompression = s.hub.Compression().Threshold > 0
+	return u.Upgrade(w, r, header)
+}
+
 func checkOrigin(r *http.Request) bool {
 	origin := r.Header.Get("Origin")
 	if origin == "" {
@@ -68,24 +88,107 @@ func (s *Server) handleDoc(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleWebSocket upgrades HTTP connections to WebSocket and registers clients.
+// With ?version=N the session is a read-only view of that past version.
+// The document's visibility and the user's role decide whether the session
+// may open it and edit; see documentAccess. With ?role=viewer it is
+// read-only even for a user who may edit, and with ?role=editor it may
+// not change fenced text even for a maintainer. Config.Handshake runs first and may have
+// resolved the identity already. The session speaks the first subprotocol
+// the client offers that the server knows, or plain JSON if it offers none.
+// A standby refuses connections until it is promoted.
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if s.refuseStandby(w) {
+		return
+	}
 	documentID, err := extractDocumentID(r.URL.Path, "/ws/")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	id := handshake.Identity(r.Context())
+	if id == nil {
+		if id = s.authenticate(w, r); id == nil {
+			return
+		}
+		r = withIdentity(r, id)
+	}
+	level := s.documentAccess(r, documentID)
+	if level == accessNone || s.reservedID(r, documentID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	switch document.Role(r.URL.Query().Get("role")) {
+	case "", document.RoleMaintainer:
+	case document.RoleEditor:
+		level = min(level, accessWrite)
+	case document.RoleViewer:
+		level = min(level, accessRead)
+	default:
+		http.Error(w, "unknown role", http.StatusBadRequest)
+		return
+	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	protocolName, codec, ok := s.negotiateProtocol(r)
+	if !ok {
+		http.Error(w, "unsupported subprotocol; supported: "+strings.Join(s.protocolNames(), ", "), http.StatusBadRequest)
+		return
+	}
+
+	version := -1
+	if v := r.URL.Query().Get("version"); v != "" {
+		if version, err = s.checkHistoricalVersion(documentID, v); err != nil {
+			var invalid *ValidationError
+			status := http.StatusNotFound
+			if errors.As(err, &invalid) {
+				status = http.StatusBadRequest
+			} else if errors.Is(err, document.ErrVersionUnavailable) {
+				status = http.StatusGone
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	var header http.Header
+	if protocolName != "" {
+		header = http.Header{"Sec-Websocket-Protocol": {protocolName}}
+	}
+	conn, err := s.upgrade(w, r, header)
 	if err != nil {
 		log.Printf("websocket upgrade failed: %v", err)
 		return
 	}
 
 	if s.config.LogEnabled {
-		log.Printf("websocket connected for document: %s", documentID)
+		log.Printf("websocket connected for document: %s (subject: %q)", documentID, id.Subject)
 	}
 
+	var wsConn hub.Conn = conn
+	if s.config.WrapConn != nil {
+		wsConn = s.config.WrapConn(conn)
+	}
+	s.startClient(r, wsConn, codec, documentID, level, version)
+}
+
+// startClient registers a client for r's user on documentID, running over
+// conn, and starts its pumps. The session is a read-only view of version
+// if it is not negative, and read-only if level allows no more.
+func (s *Server) startClient(r *http.Request, conn hub.Conn, codec protocol.Codec, documentID string, level access, version int) {
+	id := handshake.Identity(r.Context())
 	client := hub.NewClient(s.hub, conn, documentID)
+	if version >= 0 {
+		client = hub.NewHistoricalClient(s.hub, conn, documentID, version)
+	} else if level == accessRead {
+		client = hub.NewReadOnlyClient(s.hub, conn, documentID)
+	} else if level >= accessFences {
+		client.AllowFences()
+	}
+	ctx, cancel := sessionContext(r)
+	client.SetContext(ctx)
+	context.AfterFunc(client.Context(), cancel)
+	client.SetCodec(codec)
+	client.SetIdentity(id.Subject, handshake.Tenant(r.Context()))
+	client.SetDisplayName(id.Name)
 	s.hub.Register(client)
 
 	// Start client read/write pumps
@@ -93,6 +196,430 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	go client.ReadPump()
 }
 
+// sessionContext is the context a WebSocket session runs under. It keeps
+// the handshake request's values but not its cancellation, which comes
+// when the handler returns, and ends at the deadline handshake middleware
+// set, if any.
+func sessionContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx := context.WithoutCancel(r.Context())
+	if deadline, ok := handshake.SessionDeadline(ctx); ok {
+		return context.WithDeadline(ctx, deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// negotiateProtocol picks the first subprotocol offered in
+// Sec-WebSocket-Protocol that the server speaks, preferring configured
+// protocols over built-in ones. A request offering none gets JSON and no
+// name; one offering only unknown protocols is refused.
+func (s *Server) negotiateProtocol(r *http.Request) (string, protocol.Codec, bool) {
+	offered := websocket.Subprotocols(r)
+	if len(offered) == 0 {
+		return "", protocol.JSON, true
+	}
+	for _, name := range offered {
+		if codec, ok := s.config.Protocols[name]; ok {
+			return name, codec, true
+		}
+		if codec, ok := protocol.Lookup(name); ok {
+			return name, codec, true
+		}
+	}
+	return "", nil, false
+}
+
+// protocolNames lists the subprotocols the server speaks in sorted order.
+func (s *Server) protocolNames() []string {
+	names := protocol.Names()
+	for name := range s.config.Protocols {
+		if _, ok := protocol.Lookup(name); !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkHistoricalVersion parses a requested past version and reports
+// whether the document can still be reconstructed at it.
+func (s *Server) checkHistoricalVersion(documentID, v string) (int, error) {
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 0 {
+		return 0, &ValidationError{Field: "version", Reason: "must be a non-negative integer"}
+	}
+	doc := s.hub.GetDocument(documentID)
+	if doc == nil {
+		return 0, fmt.Errorf("document %s not found", documentID)
+	}
+	if _, err := doc.ContentAt(version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// handleDocumentAPI serves /api/documents/{documentID}.
+//
+// GET returns the content and version, in the bulk export's format.
+// PUT replaces the content if the document is still at the given version
+// (0 creates it), answering 409 with the current version otherwise.
+// DELETE removes the document; with ?archive=true connected clients keep a
+// read-only view of the final content.
+func (s *Server) handleDocumentAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && s.refuseStandby(w) {
+		return
+	}
+	identity := s.authenticate(w, r)
+	if identity == nil {
+		return
+	}
+	r = withIdentity(r, identity)
+	if id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/documents/"), "/"); ok {
+		switch action {
+		case "outline":
+			s.handleOutline(w, r, id)
+		case "stats":
+			s.handleDocumentStats(w, r, id)
+		case "export":
+			s.handleExport(w, r, id)
+		case "import":
+			s.handleImport(w, r, id)
+		case "publish":
+			s.handlePublish(w, r, id, identity)
+		case "published":
+			s.handlePublished(w, r, id)
+		case "fences":
+			s.handleFences(w, r, id)
+		case "checkpoints":
+			s.handleCheckpoints(w, r, id)
+		default:
+			if checkpoint, ok := strings.CutPrefix(action, "checkpoints/"); ok {
+				s.handleCheckpoint(w, r, id, checkpoint)
+				return
+			}
+			s.handleInvites(w, r, id, action)
+		}
+		return
+	}
+	documentID, err := extractDocumentID(r.URL.Path, "/api/documents/")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	required := accessWrite
+	switch r.Method {
+	case http.MethodGet:
+		required = accessRead
+	case http.MethodDelete:
+		// Deleting also removes the stored copy, so, as with checkpoints,
+		// whoever may only edit cannot also remove the way back.
+		required = accessFences
+	}
+	if s.documentAccess(r, documentID) < required {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetDocument(w, r, documentID)
+
+	case http.MethodPut:
+		if s.reservedID(r, documentID) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		s.handlePutDocument(w, r, documentID)
+
+	case http.MethodDelete:
+		archive := r.URL.Query().Get("archive") == "true"
+		err := s.hub.DeleteDocument(documentID, archive)
+		switch {
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+		case errors.Is(err, hub.ErrDocumentNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, hub.ErrHubStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGetDocument reads a document, with embeds resolved on request.
+func (s *Server) handleGetDocument(w http.ResponseWriter, r *http.Request, documentID string) {
+	if s.hub.IsDeleted(documentID) {
+		http.Error(w, hub.ErrDocumentDeleted.Error(), http.StatusGone)
+		return
+	}
+	doc := s.hub.GetDocument(documentID)
+	if doc == nil {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	content, version := doc.GetContentAndVersion()
+	_, lastModified, _ := doc.GetStats()
+	writeJSON(w, http.StatusOK, bulkDocument{
+		ID:           documentID,
+		Kind:         doc.GetKind(),
+		Content:      s.resolveEmbeds(r, documentID, doc.GetKind(), content),
+		Version:      version,
+		LastModified: &lastModified,
+	})
+}
+
+// handleListDocuments serves GET /api/documents: the IDs of the documents
+// the request may read, loaded or stored, in sorted order. With
+// ?workflow_state=, only documents in that state are listed.
+func (s *Server) handleListDocuments(w http.ResponseWriter, r *http.Request) {
+	id := s.authenticate(w, r)
+	if id == nil {
+		return
+	}
+	r = withIdentity(r, id)
+	state := document.WorkflowState(r.URL.Query().Get("workflow_state"))
+	if state != "" && !state.Valid() {
+		http.Error(w, fmt.Sprintf("unknown workflow state %q", state), http.StatusBadRequest)
+		return
+	}
+	admin := s.isAdmin(r)
+	ids := []string{}
+	for _, id := range s.hub.DocumentIDs() {
+		if !admin && s.documentAccess(r, id) < accessRead {
+			continue
+		}
+		if state != "" {
+			if doc := s.hub.GetDocument(id); doc == nil || doc.WorkflowState() != state {
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	writeJSON(w, http.StatusOK, ids)
+}
+
+// documentStats is the body of GET /api/documents/{id}/stats.
+type documentStats struct {
+	ID            string                 `json:"id"`
+	Version       int                    `json:"version"`
+	LastModified  time.Time              `json:"last_modified"`
+	Length        int                    `json:"length"`         // Content size in bytes
+	Clients       int                    `json:"clients"`        // Connections to the document on this server
+	WorkflowState document.WorkflowState `json:"workflow_state"` // Draft, in review or final
+}
+
+// handleDocumentStats serves GET /api/documents/{id}/stats, a document's
+// size and activity for dashboards.
+func (s *Server) handleDocumentStats(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isValidDocumentID(documentID) {
+		http.NotFound(w, r)
+		return
+	}
+	if s.documentAccess(r, documentID) < accessRead {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if s.hub.IsDeleted(documentID) {
+		http.Error(w, hub.ErrDocumentDeleted.Error(), http.StatusGone)
+		return
+	}
+	doc := s.hub.GetDocument(documentID)
+	if doc == nil {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	version, lastModified, length := doc.GetStats()
+	writeJSON(w, http.StatusOK, documentStats{
+		ID:            documentID,
+		Version:       version,
+		LastModified:  lastModified,
+		Length:        length,
+		Clients:       s.hub.ClientCountForDocument(documentID),
+		WorkflowState: doc.WorkflowState(),
+	})
+}
+
+// handleOutline serves GET /api/documents/{id}/outline, the headings of a
+// text document for navigation sidebars.
+func (s *Server) handleOutline(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isValidDocumentID(documentID) {
+		http.NotFound(w, r)
+		return
+	}
+	if s.documentAccess(r, documentID) < accessRead {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if s.hub.IsDeleted(documentID) {
+		http.Error(w, hub.ErrDocumentDeleted.Error(), http.StatusGone)
+		return
+	}
+	if s.hub.GetDocument(documentID) == nil {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	headings, err := s.hub.Outline(documentID)
+	switch {
+	case errors.Is(err, hub.ErrDocumentDeleted):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, hub.ErrHubStopped):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		if headings == nil {
+			headings = []outline.Heading{}
+		}
+		writeJSON(w, http.StatusOK, headings)
+	}
+}
+
+// putDocumentRequest is the body of PUT /api/documents/{documentID}.
+type putDocumentRequest struct {
+	Content string `json:"content"`
+	Version *int   `json:"version"`
+}
+
+// documentVersionResponse reports a document's version after a write or
+// conflict.
+type documentVersionResponse struct {
+	Version int    `json:"version"`
+	Error   string `json:"error,omitempty"`
+}
+
+// violationResponse reports content that breaks a document's schema or
+// limits, naming the offending line.
+type violationResponse struct {
+	Error     string                 `json:"error"`
+	Violation *schema.ViolationError `json:"violation"`
+}
+
+// handlePutDocument replaces a document's content with optimistic
+// concurrency control.
+func (s *Server) handlePutDocument(w http.ResponseWriter, r *http.Request, documentID string) {
+	var req putDocumentRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Version == nil {
+		http.Error(w, "version is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.checkFences(r, documentID, req.Content); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	version, err := s.hub.ReplaceContent(documentID, req.Content, *req.Version)
+	var conflict *document.VersionConflictError
+	var violation *schema.ViolationError
+	switch {
+	case errors.As(err, &conflict):
+		writeJSON(w, http.StatusConflict, documentVersionResponse{Version: conflict.Actual, Error: err.Error()})
+	case errors.Is(err, hub.ErrDocumentDeleted):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, hub.ErrDocumentPaused):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.As(err, &violation):
+		writeJSON(w, http.StatusUnprocessableEntity, violationResponse{Error: err.Error(), Violation: violation})
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, documentVersionResponse{Version: version})
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to encode response: %v", err)
+	}
+}
+
+// handleLatency reports operation latency histograms as JSON.
+func (s *Server) handleLatency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.hub.LatencyReport()); err != nil {
+		log.Printf("failed to encode latency report: %v", err)
+	}
+}
+
+// handleStats reports connection counts and per-document memory usage as JSON.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.hub.Stats()); err != nil {
+		log.Printf("failed to encode stats: %v", err)
+	}
+}
+
+// handleGoroutines reports the hub's running goroutines by kind, and
+// clients whose pumps outlive them, as JSON.
+func (s *Server) handleGoroutines(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.hub.GoroutineReport()); err != nil {
+		log.Printf("failed to encode goroutine report: %v", err)
+	}
+}
+
+// defaultDashboardTop is how many documents the dashboard ranks by default.
+const defaultDashboardTop = 10
+
+// handleDashboard reports hub statistics, traffic and error rates, storage
+// usage and the busiest documents as JSON, for an ops dashboard. ?top=
+// sets how many documents are ranked, up to 100.
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	top := defaultDashboardTop
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 100 {
+			http.Error(w, "top must be between 0 and 100", http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+	dashboard, err := s.hub.Dashboard(top)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, dashboard)
+}
+
 // extractDocumentID parses and validates a document ID from a URL path.
 func extractDocumentID(path, prefix string) (string, error) {
 	documentID := strings.TrimSpace(strings.TrimPrefix(path, prefix))
@@ -1,9 +1,32 @@
 package server
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"collaborative-docs/conformance"
+	"collaborative-docs/internal/auth"
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/jsondoc"
+	"collaborative-docs/internal/metrics"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/outline"
+	"collaborative-docs/internal/publish"
+	"collaborative-docs/internal/sanitize"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/internal/slug"
+	"collaborative-docs/internal/store"
+
+	"github.com/gorilla/websocket"
 )
 
 // TestHandleRoot_Redirect verifies root path redirects to default document.
@@ -203,3 +226,1698 @@ func TestIsValidDocumentID_EdgeCases(t *testing.T) {
 		t.Error("isValidDocumentID should reject 101-character string")
 	}
 }
+
+// TestHandleDocumentAPI_Delete verifies DELETE removes documents for
+// admins only, not for anyone who may edit, and reports unknown and
+// already deleted ones.
+func TestHandleDocumentAPI_Delete(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	srv.hub.GetOrCreateDocument("old-notes").SetContent("bye")
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		admin      bool
+		wantStatus int
+	}{
+		{"anonymous editor", http.MethodDelete, "/api/documents/old-notes", false, http.StatusForbidden},
+		{"delete existing", http.MethodDelete, "/api/documents/old-notes", true, http.StatusNoContent},
+		{"delete again", http.MethodDelete, "/api/documents/old-notes", true, http.StatusGone},
+		{"delete unknown", http.MethodDelete, "/api/documents/never-made", true, http.StatusNotFound},
+		{"invalid id", http.MethodDelete, "/api/documents/bad$id", true, http.StatusBadRequest},
+		{"unsupported method", http.MethodPatch, "/api/documents/old-notes", true, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.admin {
+				req.Header.Set("Authorization", "Bearer secret")
+			}
+			srv.handleDocumentAPI(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	if !srv.hub.IsDeleted("old-notes") {
+		t.Error("document not marked deleted")
+	}
+}
+
+// TestHandleDocumentAPI_Put verifies versioned writes and that connected
+// clients receive the change as operations.
+func TestHandleDocumentAPI_Put(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	watcher := hub.NewLocalClient(srv.hub, "script-doc", 16)
+	srv.hub.Register(watcher)
+
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantVersion int
+	}{
+		{"create", `{"content":"v1 text","version":0}`, http.StatusOK, 1},
+		{"stale version", `{"content":"lost","version":0}`, http.StatusConflict, 1},
+		{"update", `{"content":"v2 text","version":1}`, http.StatusOK, 3},
+		{"missing version", `{"content":"x"}`, http.StatusBadRequest, 0},
+		{"malformed body", `{`, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/api/documents/script-doc", strings.NewReader(tt.body))
+			srv.handleDocumentAPI(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantVersion == 0 {
+				return
+			}
+			var resp documentVersionResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if resp.Version != tt.wantVersion {
+				t.Errorf("version = %d, want %d", resp.Version, tt.wantVersion)
+			}
+		})
+	}
+
+	var ops int
+	timeout := time.After(time.Second)
+	for ops < 3 {
+		select {
+		case data := <-watcher.Messages():
+			if msg, err := hub.MessageFromBytes(data); err == nil && msg.Type == hub.MsgTypeOperation {
+				ops++
+			}
+		case <-timeout:
+			t.Fatalf("watcher received %d operations, want 3", ops)
+		}
+	}
+}
+
+// TestHandleWebSocket_Version verifies historical sessions are refused
+// before the upgrade when the version cannot be served.
+func TestHandleWebSocket_Version(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	doc := srv.hub.GetOrCreateDocument("old-doc")
+	doc.SetContent("draft")
+	doc.CompactHistory() // version 0 is no longer reachable
+	doc.SetContent("final")
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{"malformed version", "/ws/old-doc?version=abc", http.StatusBadRequest},
+		{"negative version", "/ws/old-doc?version=-1", http.StatusBadRequest},
+		{"missing document", "/ws/new-doc?version=0", http.StatusNotFound},
+		{"future version", "/ws/old-doc?version=9", http.StatusNotFound},
+		{"compacted version", "/ws/old-doc?version=0", http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.handleWebSocket(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+// TestAdminBulkAPI verifies bulk import, export and delete stream one NDJSON
+// record per document and require the admin token.
+func TestAdminBulkAPI(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	results := func(rec *httptest.ResponseRecorder) []bulkResult {
+		var out []bulkResult
+		dec := json.NewDecoder(rec.Body)
+		for dec.More() {
+			var r bulkResult
+			if err := dec.Decode(&r); err != nil {
+				t.Fatalf("invalid NDJSON response: %v", err)
+			}
+			out = append(out, r)
+		}
+		return out
+	}
+
+	if rec := do(http.MethodGet, "/admin/documents/export", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	imported := results(do(http.MethodPost, "/admin/documents/import", "secret",
+		`{"id":"legacy-1","content":"first"}`+"\n"+
+			`{"id":"legacy-2","content":"second"}`+"\n\n"+
+			`{"id":"legacy-1","content":"again"}`+"\n"+
+			`{"id":"bad id","content":"x"}`+"\n"+
+			`not json`+"\n"))
+	wantErrors := []bool{false, false, true, true, true}
+	if len(imported) != len(wantErrors) {
+		t.Fatalf("import returned %d results, want %d: %+v", len(imported), len(wantErrors), imported)
+	}
+	for i, r := range imported {
+		if (r.Error != "") != wantErrors[i] {
+			t.Errorf("import result %d = %+v, want error: %v", i, r, wantErrors[i])
+		}
+	}
+	if imported[2].Line != 4 {
+		t.Errorf("duplicate reported on line %d, want 4", imported[2].Line)
+	}
+
+	rec := do(http.MethodGet, "/admin/documents/export?prefix=legacy-", "secret", "")
+	var exported []bulkDocument
+	dec := json.NewDecoder(rec.Body)
+	for dec.More() {
+		var d bulkDocument
+		if err := dec.Decode(&d); err != nil {
+			t.Fatalf("invalid export: %v", err)
+		}
+		exported = append(exported, d)
+	}
+	if len(exported) != 2 || exported[0].Content != "first" || exported[1].ID != "legacy-2" {
+		t.Errorf("export = %+v, want legacy-1 and legacy-2", exported)
+	}
+
+	deleted := results(do(http.MethodPost, "/admin/documents/delete", "secret",
+		`{"id":"legacy-1"}`+"\n"+`{"id":"missing"}`+"\n"))
+	if len(deleted) != 2 || deleted[0].Error != "" || deleted[1].Error == "" {
+		t.Errorf("delete results = %+v, want success then not found", deleted)
+	}
+	if !srv.hub.IsDeleted("legacy-1") {
+		t.Error("legacy-1 not deleted")
+	}
+}
+
+func TestAdminFeatures(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/admin/documents/features", `{"id":"big-doc","features":{"presence":false}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST status = %d: %s", rec.Code, rec.Body)
+	}
+	var features map[string]bool
+	if err := json.NewDecoder(rec.Body).Decode(&features); err != nil || features["presence"] || !features["blobs"] {
+		t.Errorf("POST response = %v, %v; want presence disabled", features, err)
+	}
+
+	rec = do(http.MethodGet, "/admin/documents/features?id=big-doc", "")
+	features = nil
+	if err := json.NewDecoder(rec.Body).Decode(&features); err != nil || features["presence"] {
+		t.Errorf("GET response = %v, %v; want presence disabled", features, err)
+	}
+
+	if rec := do(http.MethodPost, "/admin/documents/features", `{"id":"big-doc","features":{"comments":false}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown feature status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(http.MethodGet, "/admin/documents/features?id=bad%20id", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid ID status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminUndo(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	undo := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/documents/undo", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	srv.hub.ReplaceContent("leak-doc", "config", 0)
+	srv.hub.ReplaceContent("leak-doc", "config password=hunter2", 1)
+	srv.hub.ReplaceContent("leak-doc", "# config password=hunter2", 2)
+
+	rec := undo(`{"id":"leak-doc","version":2}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("undo status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp documentVersionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Version != 4 {
+		t.Errorf("undo response = %+v, %v; want version 4", resp, err)
+	}
+	if got := srv.hub.GetDocument("leak-doc").GetContent(); got != "# config" {
+		t.Errorf("content = %q, want %q", got, "# config")
+	}
+
+	if rec := undo(`{"id":"missing","version":1}`); rec.Code != http.StatusNotFound {
+		t.Errorf("missing document status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := undo(`{"id":"leak-doc","version":9}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("future version status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// TestDocumentVisibility verifies that visibility decides who may open a
+// document over WebSocket and REST.
+func TestDocumentVisibility(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	tokens := map[string]string{}
+	for id, v := range map[string]document.Visibility{
+		"open-doc":    document.VisibilityOpen,
+		"private-doc": document.VisibilityPrivate,
+		"link-doc":    document.VisibilityLink,
+		"public-doc":  document.VisibilityPublic,
+	} {
+		srv.hub.ReplaceContent(id, "text", 0)
+		token, err := srv.hub.SetVisibility(id, v)
+		if err != nil {
+			t.Fatalf("SetVisibility(%s) error: %v", id, err)
+		}
+		tokens[id] = token
+	}
+	if tokens["link-doc"] == "" || tokens["open-doc"] != "" {
+		t.Fatalf("link tokens = %v, want one for link-doc only", tokens)
+	}
+	link := "?token=" + tokens["link-doc"]
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		admin      bool
+		wantStatus int
+	}{
+		{"open read", http.MethodGet, "/api/documents/open-doc", false, http.StatusOK},
+		{"private read", http.MethodGet, "/api/documents/private-doc", false, http.StatusForbidden},
+		{"private read by admin", http.MethodGet, "/api/documents/private-doc", true, http.StatusOK},
+		{"link read without token", http.MethodGet, "/api/documents/link-doc", false, http.StatusForbidden},
+		{"link read with token", http.MethodGet, "/api/documents/link-doc" + link, false, http.StatusOK},
+		{"link token on other document", http.MethodGet, "/api/documents/private-doc" + link, false, http.StatusForbidden},
+		{"public read", http.MethodGet, "/api/documents/public-doc", false, http.StatusOK},
+		{"public write", http.MethodDelete, "/api/documents/public-doc", false, http.StatusForbidden},
+		{"private connect", http.MethodGet, "/ws/private-doc", false, http.StatusForbidden},
+		{"link connect without token", http.MethodGet, "/ws/link-doc", false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.admin {
+				req.Header.Set("Authorization", "Bearer secret")
+			}
+			srv.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	if token, _ := srv.hub.SetVisibility("public-doc", document.VisibilityPublic); token != tokens["public-doc"] {
+		t.Error("link token changed when visibility was set again")
+	}
+}
+
+// TestInviteAPI verifies that invites created by an editor admit the
+// redeemer to a private document with the invite's role.
+func TestInviteAPI(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	srv.hub.ReplaceContent("private-doc", "text", 0)
+	srv.hub.SetVisibility("private-doc", document.VisibilityPrivate)
+
+	do := func(method, target, body string, admin bool) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if admin {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/api/documents/private-doc/invites", `{"role":"viewer"}`, false); rec.Code != http.StatusForbidden {
+		t.Errorf("anonymous invite status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec := do(http.MethodPost, "/api/documents/private-doc/invites", `{"role":"viewer","max_uses":1}`, true)
+	var inv document.Invite
+	if err := json.NewDecoder(rec.Body).Decode(&inv); rec.Code != http.StatusCreated || err != nil {
+		t.Fatalf("create invite = %d, %v", rec.Code, err)
+	}
+
+	rec = do(http.MethodPost, "/api/documents/private-doc/invites/redeem", `{"token":"`+inv.Token+`","name":"Carol"}`, false)
+	var redeemed redeemInviteResponse
+	if err := json.NewDecoder(rec.Body).Decode(&redeemed); rec.Code != http.StatusOK || err != nil || redeemed.Role != document.RoleViewer {
+		t.Fatalf("redeem = %d, %+v, %v", rec.Code, redeemed, err)
+	}
+	withToken := "/api/documents/private-doc?token=" + redeemed.AccessToken
+	if rec := do(http.MethodGet, withToken, "", false); rec.Code != http.StatusOK {
+		t.Errorf("viewer read status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodDelete, withToken, "", false); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer delete status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := do(http.MethodPost, "/api/documents/private-doc/invites/redeem", `{"token":"`+inv.Token+`","name":"Dave"}`, false); rec.Code != http.StatusGone {
+		t.Errorf("used-up invite status = %d, want %d", rec.Code, http.StatusGone)
+	}
+	if rec := do(http.MethodPost, "/api/documents/private-doc/unknown", `{}`, true); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown action status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// TestAuthProvider verifies that a configured identity provider guards the
+// WebSocket and document endpoints.
+func TestAuthProvider(t *testing.T) {
+	srv := New(Config{
+		Port:       ":8080",
+		StaticDir:  "testdata",
+		AdminToken: "secret",
+		Auth:       auth.NewStaticKeys(map[string]auth.Identity{"api-key": {Subject: "svc"}}),
+	})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	srv.hub.ReplaceContent("guarded-doc", "text", 0)
+
+	tests := []struct {
+		name          string
+		target        string
+		authorization string
+		wantStatus    int
+	}{
+		{"no token", "/api/documents/guarded-doc", "", http.StatusUnauthorized},
+		{"wrong token", "/api/documents/guarded-doc", "Bearer nope", http.StatusUnauthorized},
+		{"bearer token", "/api/documents/guarded-doc", "Bearer api-key", http.StatusOK},
+		{"query token", "/api/documents/guarded-doc?auth_token=api-key", "", http.StatusOK},
+		{"admin token", "/api/documents/guarded-doc", "Bearer secret", http.StatusOK},
+		{"websocket without token", "/ws/guarded-doc", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			srv.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+// TestDocumentAccessList verifies that the creator owns a document, that
+// roles given through the admin API apply whatever the visibility, and
+// that a session may ask to be read-only.
+func TestDocumentAccessList(t *testing.T) {
+	srv := New(Config{
+		Port:       ":8080",
+		StaticDir:  "testdata",
+		AdminToken: "secret",
+		Auth: auth.NewStaticKeys(map[string]auth.Identity{
+			"alice-key": {Subject: "alice"},
+			"bob-key":   {Subject: "bob"},
+			"carol-key": {Subject: "carol"},
+		}),
+	})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/documents", "alice-key", `{"title":"Team Plan"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body)
+	}
+	var created createdDocument
+	json.NewDecoder(rec.Body).Decode(&created)
+	if owner := srv.hub.GetDocument(created.ID).Owner(); owner != "alice" {
+		t.Errorf("owner = %q, want the creator", owner)
+	}
+
+	rec = do(http.MethodPost, "/admin/documents/access", "secret", `{"id":"`+created.ID+`","roles":{"bob":"viewer","carol":"editor"}}`)
+	var list accessResponse
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&list) != nil {
+		t.Fatalf("access status = %d: %s", rec.Code, rec.Body)
+	}
+	want := accessResponse{Owner: "alice", Roles: map[string]document.Role{"bob": document.RoleViewer, "carol": document.RoleEditor}}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("access list = %+v, want %+v", list, want)
+	}
+	if rec := do(http.MethodPost, "/admin/documents/access", "secret", `{"id":"`+created.ID+`","roles":{"bob":"admin","carol":""}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown role status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if roles := srv.hub.GetDocument(created.ID).Roles(); len(roles) != 2 {
+		t.Errorf("roles = %v after a refused change, want both kept", roles)
+	}
+
+	// The document is open, but bob may only read it.
+	put := `{"content":"edited","version":0}`
+	target := "/api/documents/" + created.ID
+	if rec := do(http.MethodGet, target, "bob-key", ""); rec.Code != http.StatusOK {
+		t.Errorf("viewer read status = %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, target, "bob-key", put); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer write status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if _, err := srv.hub.SetVisibility(created.ID, document.VisibilityPrivate); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do(http.MethodPut, target, "alice-key", put); rec.Code != http.StatusOK {
+		t.Errorf("owner write status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodGet, target, "carol-key", ""); rec.Code != http.StatusOK {
+		t.Errorf("editor read of private document status = %d", rec.Code)
+	}
+
+	httpServer := httptest.NewServer(srv.Handler())
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws/" + created.ID
+	for _, tt := range []struct {
+		query        string
+		wantReadOnly bool
+	}{
+		{"?auth_token=bob-key", true},
+		{"?auth_token=carol-key", false},
+		{"?auth_token=carol-key&role=viewer", true},
+	} {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+tt.query, nil)
+		if err != nil {
+			t.Fatalf("dial %s failed: %v", tt.query, err)
+		}
+		var welcome hub.Message
+		if err := conn.ReadJSON(&welcome); err != nil || welcome.ReadOnly != tt.wantReadOnly {
+			t.Errorf("%s: welcome = %+v, %v; want read-only %v", tt.query, welcome, err, tt.wantReadOnly)
+		}
+		conn.Close()
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL+"?auth_token=bob-key&role=owner", nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown role dial = %v, want 400", err)
+	}
+}
+
+// TestTeamAccess verifies that members of the team owning a document get
+// their team role on it, in listings too, and that the greater of a
+// team role and a user's own role applies.
+func TestTeamAccess(t *testing.T) {
+	srv := New(Config{
+		Port:       ":8080",
+		StaticDir:  "testdata",
+		AdminToken: "secret",
+		Auth: auth.NewStaticKeys(map[string]auth.Identity{
+			"dave-key":  {Subject: "dave"},
+			"erin-key":  {Subject: "erin"},
+			"frank-key": {Subject: "frank"},
+		}),
+	})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	srv.hub.GetOrCreateDocument("plan").SetContent("draft")
+	if _, err := srv.hub.SetVisibility("plan", document.VisibilityPrivate); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do(http.MethodPost, "/admin/documents/access", "secret", `{"id":"plan","team":"writers"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown team status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	rec := do(http.MethodPost, "/admin/teams", "secret", `{"id":"writers","name":"Writers","members":{"dave":"editor","erin":"viewer"}}`)
+	var team document.Team
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&team) != nil || len(team.Members) != 2 {
+		t.Fatalf("create team = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/admin/teams", "secret", `{"id":"writers","members":{"erin":"owner"}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown role status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = do(http.MethodPost, "/admin/documents/access", "secret", `{"id":"plan","team":"writers"}`)
+	var list accessResponse
+	if json.NewDecoder(rec.Body).Decode(&list); rec.Code != http.StatusOK || list.Team != "writers" {
+		t.Fatalf("set team = %d %+v", rec.Code, list)
+	}
+
+	put := `{"content":"edited","version":1}`
+	for _, tt := range []struct {
+		method, key, body string
+		want              int
+	}{
+		{http.MethodPut, "dave-key", put, http.StatusOK},
+		{http.MethodGet, "erin-key", "", http.StatusOK},
+		{http.MethodPut, "erin-key", put, http.StatusForbidden},
+		{http.MethodGet, "frank-key", "", http.StatusForbidden},
+	} {
+		if rec := do(tt.method, "/api/documents/plan", tt.key, tt.body); rec.Code != tt.want {
+			t.Errorf("%s by %s status = %d, want %d", tt.method, tt.key, rec.Code, tt.want)
+		}
+	}
+	for key, want := range map[string][]string{"erin-key": {"plan"}, "frank-key": {}} {
+		var ids []string
+		json.NewDecoder(do(http.MethodGet, "/api/documents", key, "").Body).Decode(&ids)
+		if !slices.Equal(ids, want) {
+			t.Errorf("listing for %s = %v, want %v", key, ids, want)
+		}
+	}
+
+	if err := srv.hub.SetRoles("plan", map[string]document.Role{"erin": document.RoleEditor}); err != nil {
+		t.Fatal(err)
+	}
+	put = `{"content":"again","version":` + strconv.Itoa(srv.hub.GetDocument("plan").GetVersion()) + `}`
+	if rec := do(http.MethodPut, "/api/documents/plan", "erin-key", put); rec.Code != http.StatusOK {
+		t.Errorf("PUT by a team viewer with an editor role status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodDelete, "/admin/teams?id=writers", "secret", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete team status = %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/documents/plan", "dave-key", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("GET by a member of a deleted team status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+// TestMuxAccess verifies /mux authenticates once and checks each session
+// against its document as /ws/ would.
+func TestMuxAccess(t *testing.T) {
+	srv := New(Config{
+		Port:      ":8080",
+		StaticDir: "testdata",
+		Auth:      auth.NewStaticKeys(map[string]auth.Identity{"bob-key": {Subject: "bob"}}),
+	})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	srv.hub.GetOrCreateDocument("plans")
+	if _, err := srv.hub.SetVisibility("plans", document.VisibilityPrivate); err != nil {
+		t.Fatal(err)
+	}
+
+	httpServer := httptest.NewServer(srv.Handler())
+	defer httpServer.Close()
+	muxURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/mux"
+	if _, resp, err := websocket.DefaultDialer.Dial(muxURL, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated dial = %v, want 401", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(muxURL+"?auth_token=bob-key", nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	for _, open := range []string{
+		`{"type":"session_open","session":"tab1","document_id":"plans"}`,
+		`{"type":"session_open","session":"tab2","document_id":"bad id"}`,
+		`{"type":"session_open","session":"tab3","document_id":"notes","read_only":true}`,
+	} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(open)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closed := make(map[string]string)
+	var welcome *hub.Message
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for len(closed) < 2 || welcome == nil {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read failed with %v closed and welcome %+v: %v", closed, welcome, err)
+		}
+		for _, line := range strings.Split(string(frame), "\n") {
+			msg, err := hub.MessageFromBytes([]byte(line))
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch msg.Type {
+			case hub.MsgTypeSessionClosed:
+				closed[msg.Session] = msg.Reason
+			case hub.MsgTypeWelcome:
+				welcome = msg
+			}
+		}
+	}
+	if closed["tab1"] != "forbidden" || !strings.HasPrefix(closed["tab2"], "documentID") {
+		t.Errorf("refused sessions = %v, want tab1 forbidden and tab2 invalid", closed)
+	}
+	if welcome.Session != "tab3" || !welcome.ReadOnly || welcome.DocumentID != "notes" {
+		t.Errorf("welcome = %+v, want a read-only session tab3 on notes", welcome)
+	}
+}
+
+func TestAdminClients(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	c := hub.NewLocalClient(srv.hub, "stats-doc", 16)
+	srv.hub.Register(c)
+	srv.hub.Submit([]byte(`{"type":5}`), c)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/clients", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var clients []hub.ClientInfo
+	if err := json.NewDecoder(rec.Body).Decode(&clients); err != nil || len(clients) != 1 {
+		t.Fatalf("response = %+v, %v; want one client", clients, err)
+	}
+	if got := clients[0]; got.ID != c.ID() || got.ParseErrors != 1 || got.AbuseScore <= 0 {
+		t.Errorf("client = %+v, want the parse error scored", got)
+	}
+}
+
+// TestResolveEmbeds verifies embeds are filled in on request, except for
+// documents the reader may not open.
+func TestResolveEmbeds(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	srv.hub.ReplaceContent("handbook", "Intro\n![[policy#L2]]\n![[secret]]", 0)
+	srv.hub.ReplaceContent("policy", "Policy\nBe kind.", 0)
+	srv.hub.ReplaceContent("secret", "salaries", 0)
+	if _, err := srv.hub.SetVisibility("secret", document.VisibilityPrivate); err != nil {
+		t.Fatalf("SetVisibility() error: %v", err)
+	}
+
+	get := func(query string) string {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/documents/handbook"+query, nil))
+		var doc bulkDocument
+		if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+			t.Fatalf("GET %s: %v", query, err)
+		}
+		return doc.Content
+	}
+	if got := get(""); got != "Intro\n![[policy#L2]]\n![[secret]]" {
+		t.Errorf("stored content = %q, want references kept", got)
+	}
+	if got, want := get("?resolve_embeds=true"), "Intro\nBe kind.\n![[secret]]"; got != want {
+		t.Errorf("resolved content = %q, want %q", got, want)
+	}
+}
+
+func TestOutlineAPI(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	srv.hub.ReplaceContent("manual", "# Manual\n```\n# not a heading\n```\n## FAQ", 0)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	rec := get("/api/documents/manual/outline")
+	var headings []outline.Heading
+	if err := json.NewDecoder(rec.Body).Decode(&headings); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, %v", rec.Code, err)
+	}
+	want := []outline.Heading{{Level: 1, Text: "Manual", Line: 0}, {Level: 2, Text: "FAQ", Line: 4}}
+	if !reflect.DeepEqual(headings, want) {
+		t.Errorf("outline = %+v, want %+v", headings, want)
+	}
+	if rec := get("/api/documents/missing/outline"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing document status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestExportAPI(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	srv.hub.ReplaceContent("notes", "Notes\nship it", 0)
+	bold := operations.Attributes{operations.AttrBold: "true"}
+	if _, err := srv.hub.ApplyOperation("notes", operations.NewFormatOp(11, "it", bold, 1)); err != nil {
+		t.Fatalf("ApplyOperation() error: %v", err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	tests := []struct {
+		format, ctype, name, body string
+	}{
+		{"md", "text/markdown; charset=utf-8", "notes.md", "Notes\n\nship **it**\n"},
+		{"html", "text/html; charset=utf-8", "notes.html", "<p>ship <strong>it</strong></p>"},
+		{"txt", "text/plain; charset=utf-8", "notes.txt", "Notes\nship it"},
+	}
+	for _, tt := range tests {
+		rec := get("/api/documents/notes/export?format=" + tt.format)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != tt.ctype || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("export as %s = %d %s %q, want %s containing %q", tt.format, rec.Code, rec.Header().Get("Content-Type"), rec.Body, tt.ctype, tt.body)
+		}
+		if got, want := rec.Header().Get("Content-Disposition"), `attachment; filename=`+tt.name; got != want {
+			t.Errorf("export as %s Content-Disposition = %q, want %q", tt.format, got, want)
+		}
+	}
+	if rec := get("/api/documents/notes/export?format=pdf"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown format status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := get("/api/documents/missing/export"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing document status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestImportAPI(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/documents/notes/import", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	rec := post("text/markdown; charset=UTF-8", "\ufeff# Notes\r\nfirst\rsecond\r\n")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import status = %d %q", rec.Code, rec.Body)
+	}
+	if content := srv.hub.GetDocument("notes").GetContent(); content != "# Notes\nfirst\nsecond\n" {
+		t.Errorf("imported content = %q, want the BOM dropped and line endings normalized", content)
+	}
+
+	tests := []struct {
+		name, contentType, body string
+		want                    int
+	}{
+		{"html", "text/html", "<p>hi</p>", http.StatusUnsupportedMediaType},
+		{"latin-1", "text/plain; charset=iso-8859-1", "caf\xe9", http.StatusUnsupportedMediaType},
+		{"invalid UTF-8", "text/plain", "caf\xe9", http.StatusBadRequest},
+		{"too large", "text/plain", strings.Repeat("x", maxContentSize+1), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if rec := post(tt.contentType, tt.body); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+	if content := srv.hub.GetDocument("notes").GetContent(); content != "# Notes\nfirst\nsecond\n" {
+		t.Errorf("content = %q after refused imports", content)
+	}
+}
+
+// TestFencesAPI verifies only the owner and maintainers may fence text or
+// change it over REST, while editors may change the rest.
+func TestFencesAPI(t *testing.T) {
+	srv := New(Config{
+		Port:      ":8080",
+		StaticDir: "testdata",
+		Auth: auth.NewStaticKeys(map[string]auth.Identity{
+			"alice-key": {Subject: "alice"},
+			"bob-key":   {Subject: "bob"},
+			"carol-key": {Subject: "carol"},
+		}),
+	})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	if _, err := srv.hub.ReplaceContent("template", "Header\nbody", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.hub.SetOwner("template", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.hub.SetRoles("template", map[string]document.Role{"bob": document.RoleMaintainer, "carol": document.RoleEditor}); err != nil {
+		t.Fatal(err)
+	}
+
+	fences := `{"version":1,"fences":[{"id":"head","position":0,"length":6,"label":"boilerplate"}]}`
+	if rec := do(http.MethodPut, "/api/documents/template/fences", "carol-key", fences); rec.Code != http.StatusForbidden {
+		t.Errorf("editor fencing status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := do(http.MethodPut, "/api/documents/template/fences", "bob-key", `{"version":0,"fences":[]}`); rec.Code != http.StatusConflict {
+		t.Errorf("stale fencing status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := do(http.MethodPut, "/api/documents/template/fences", "bob-key", `{"version":1,"fences":[{"id":"x","position":3,"length":40}]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("fence past the end status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(http.MethodPut, "/api/documents/template/fences", "bob-key", fences); rec.Code != http.StatusOK {
+		t.Fatalf("maintainer fencing status = %d: %s", rec.Code, rec.Body)
+	}
+	rec := do(http.MethodGet, "/api/documents/template/fences", "carol-key", "")
+	var got fencesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, %v", rec.Code, err)
+	}
+	want := fencesResponse{Version: 1, Fences: document.Fences{{ID: "head", Position: 0, Length: 6, Label: "boilerplate"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fences = %+v, want %+v", got, want)
+	}
+
+	if rec := do(http.MethodPut, "/api/documents/template", "carol-key", `{"content":"Heading\nbody","version":1}`); rec.Code != http.StatusForbidden {
+		t.Errorf("editor changing fenced text status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := do(http.MethodPut, "/api/documents/template", "carol-key", `{"content":"Header\nnew body","version":1}`); rec.Code != http.StatusOK {
+		t.Errorf("editor changing the rest status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPut, "/api/documents/template", "alice-key", `{"content":"Heading\nnew body","version":2}`); rec.Code != http.StatusOK {
+		t.Errorf("owner changing fenced text status = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCheckpointsAPI(t *testing.T) {
+	srv := New(Config{
+		Port:      ":8080",
+		StaticDir: "testdata",
+		Auth: auth.NewStaticKeys(map[string]auth.Identity{
+			"alice-key": {Subject: "alice"},
+			"carol-key": {Subject: "carol"},
+		}),
+	})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	do := func(method, target, key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	if _, err := srv.hub.ReplaceContent("plan", "good plan", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.hub.SetOwner("plan", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.hub.SetRoles("plan", map[string]document.Role{"carol": document.RoleEditor}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := do(http.MethodPost, "/api/documents/plan/checkpoints", "carol-key", `{"label":""}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unlabeled checkpoint status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec := do(http.MethodPost, "/api/documents/plan/checkpoints", "carol-key", `{"label":"reviewed"}`)
+	var cp document.Checkpoint
+	if err := json.NewDecoder(rec.Body).Decode(&cp); err != nil || rec.Code != http.StatusCreated || cp.Content != "good plan" {
+		t.Fatalf("create status = %d, %v, %+v", rec.Code, err, cp)
+	}
+	if _, err := srv.hub.ReplaceContent("plan", "ruined", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = do(http.MethodGet, "/api/documents/plan/checkpoints", "carol-key", "")
+	var list []checkpointSummary
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 1 || list[0].ID != cp.ID || list[0].Label != "reviewed" || list[0].Length != len("good plan") {
+		t.Errorf("list = %+v, %v, want the one checkpoint without content", list, err)
+	}
+	if rec := do(http.MethodGet, "/api/documents/plan/checkpoints/"+cp.ID, "carol-key", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"content":"good plan"`) {
+		t.Errorf("get status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/api/documents/plan/checkpoints/missing/restore", "carol-key", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("restoring a missing checkpoint status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = do(http.MethodPost, "/api/documents/plan/checkpoints/"+cp.ID+"/restore", "carol-key", "")
+	var restored documentVersionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&restored); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("restore status = %d, %v", rec.Code, err)
+	}
+	if content, version := srv.hub.GetDocument("plan").GetContentAndVersion(); content != "good plan" || version != restored.Version {
+		t.Errorf("content = %q at %d, want the checkpoint's at %d", content, version, restored.Version)
+	}
+
+	if rec := do(http.MethodDelete, "/api/documents/plan/checkpoints/"+cp.ID, "carol-key", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("editor deleting status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := do(http.MethodDelete, "/api/documents/plan/checkpoints/"+cp.ID, "alice-key", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("owner deleting status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodGet, "/api/documents/plan/checkpoints/"+cp.ID, "alice-key", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestConformanceAPI(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata"})
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/conformance", nil))
+	var vectors conformance.Vectors
+	if err := json.NewDecoder(rec.Body).Decode(&vectors); err != nil || rec.Code != http.StatusOK || len(vectors.Apply) == 0 {
+		t.Fatalf("GET status = %d, %v, %d apply vectors", rec.Code, err, len(vectors.Apply))
+	}
+
+	first := vectors.Apply[0]
+	body := `{"apply": {"` + first.Name + `": {"content": "wrong"}}}`
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/conformance", strings.NewReader(body)))
+	var report conformance.Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("POST status = %d, %v", rec.Code, err)
+	}
+	if len(report.Failures) != 1 || report.Failures[0].Vector != "apply/"+first.Name || report.Passed != 0 {
+		t.Errorf("report = %+v, want the one result failed", report)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	off := New(Config{Port: ":8080", StaticDir: "testdata"})
+	rec := httptest.NewRecorder()
+	off.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(rec.Body.String(), "collab_") {
+		t.Errorf("metrics served without Metrics: %s", rec.Body)
+	}
+
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", Metrics: true})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/documents/metered", strings.NewReader(`{"content":"hello","version":0}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != metrics.ContentType {
+		t.Fatalf("GET status = %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{"collab_operations_applied_total ", `collab_document_size_bytes{document="metered"} `, "collab_documents_loaded 1\n"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, rec.Body)
+		}
+	}
+}
+
+func TestAdminSanitize(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/admin/documents/sanitize", `{"id":"notes","policy":{"strip_control":true,"strip_html":true}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST status = %d: %s", rec.Code, rec.Body)
+	}
+	want := sanitize.Policy{StripControl: true, StripHTML: true}
+	var policy sanitize.Policy
+	if err := json.NewDecoder(rec.Body).Decode(&policy); err != nil || policy != want {
+		t.Errorf("POST response = %+v, %v; want %+v", policy, err, want)
+	}
+
+	rec = do(http.MethodGet, "/admin/documents/sanitize?id=notes", "")
+	policy = sanitize.Policy{}
+	if err := json.NewDecoder(rec.Body).Decode(&policy); err != nil || policy != want {
+		t.Errorf("GET response = %+v, %v; want %+v", policy, err, want)
+	}
+
+	if rec := do(http.MethodGet, "/admin/documents/sanitize?id=bad%20id", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid ID status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminNormalization(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	srv.hub.GetOrCreateDocument("names").SetContent("Zoe\u0308")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/admin/documents/normalization", `{"id":"names","form":"nfc"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"form":"nfc"`) {
+		t.Fatalf("POST = %d %s, want form nfc", rec.Code, rec.Body)
+	}
+	if got := srv.hub.GetDocument("names").GetContent(); got != "Zo\u00eb" {
+		t.Errorf("content = %q, want it normalized", got)
+	}
+	if rec := do(http.MethodGet, "/admin/documents/normalization?id=names", ""); !strings.Contains(rec.Body.String(), `"form":"nfc"`) {
+		t.Errorf("GET = %s, want form nfc", rec.Body)
+	}
+	if rec := do(http.MethodPost, "/admin/documents/normalization", `{"id":"names","form":"nfkd"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown form status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminRebuild(t *testing.T) {
+	fs, err := store.NewFile(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFile() error: %v", err)
+	}
+	serve := func(srv *Server, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	memory := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go memory.hub.Run()
+	defer memory.hub.Shutdown()
+	if rec := serve(memory, http.MethodPost, "/admin/documents/rebuild", `{"id":"notes"}`); rec.Code != http.StatusNotImplemented {
+		t.Errorf("without a store status = %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	srv.hub.SetStore(fs, time.Hour)
+	go srv.hub.Run()
+	srv.hub.GetOrCreateDocument("notes").SetContent("hello")
+	if rec := serve(srv, http.MethodPost, "/admin/documents/rebuild", `{"id":"notes"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("before any save status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := serve(srv, http.MethodPost, "/admin/documents/rebuild", `{"id":"bad id"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid ID status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := serve(srv, http.MethodGet, "/admin/documents/rebuild", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	srv.hub.Shutdown() // saves the document
+	if rec := serve(srv, http.MethodPost, "/admin/documents/rebuild", `{"id":"notes"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("after Shutdown status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	srv = New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	srv.hub.SetStore(fs, time.Hour)
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	rec := serve(srv, http.MethodPost, "/admin/documents/rebuild", `{"id":"notes"}`)
+	var res hub.RebuildResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || rec.Code != http.StatusOK || res != (hub.RebuildResult{From: 1, Replayed: 1, Version: 2}) {
+		t.Errorf("POST = %d %+v, %v; want rebuilt from the stored version", rec.Code, res, err)
+	}
+	if got := srv.hub.GetDocument("notes").GetContent(); got != "hello" {
+		t.Errorf("rebuilt content = %q, want %q", got, "hello")
+	}
+}
+
+func TestAdminConflictPolicy(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	doc := srv.hub.GetOrCreateDocument("sheet")
+	doc.SetKind(document.KindJSON)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/admin/documents/conflict_policy?id=sheet", ""); !strings.Contains(rec.Body.String(), `"policy":"last_writer_wins"`) {
+		t.Errorf("default GET = %s, want last_writer_wins", rec.Body)
+	}
+	rec := do(http.MethodPost, "/admin/documents/conflict_policy", `{"id":"sheet","policy":"reject"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"policy":"reject"`) {
+		t.Fatalf("POST = %d %s, want policy reject", rec.Code, rec.Body)
+	}
+
+	doc.ApplyJSONOperation(&jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"a"}, Value: 1.0})
+	doc.ApplyJSONOperation(&jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"a"}, Value: 2.0, Version: 1})
+	seen := 1
+	stale := &jsondoc.Operation{Type: jsondoc.OpSet, Path: jsondoc.Path{"a"}, Value: 3.0, Version: 1, IfVersion: &seen}
+	if _, _, err := doc.ApplyJSONOperation(stale); err == nil {
+		t.Error("stale edit applied under reject policy")
+	}
+
+	if rec := do(http.MethodPost, "/admin/documents/conflict_policy", `{"id":"sheet","policy":"first_writer_wins"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown policy status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminLimits(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	srv.hub.GetOrCreateDocument("app-conf").SetContent("port = 80")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/admin/documents/limits", `{"id":"app-conf","max_line_length":5}`)
+	var failed violationResponse
+	if rec.Code != http.StatusConflict || json.NewDecoder(rec.Body).Decode(&failed) != nil || failed.Violation.Rule != schema.RuleMaxLength {
+		t.Errorf("limits the content breaks: %d %+v, want 409 with a max_length violation", rec.Code, failed)
+	}
+	if rec := do(http.MethodPost, "/admin/documents/limits", `{"id":"app-conf","max_lines":-1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative limit status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = do(http.MethodPost, "/admin/documents/limits", `{"id":"app-conf","max_line_length":40,"max_lines":2}`)
+	want := schema.Limits{MaxLineLength: 40, MaxLines: 2}
+	var limits schema.Limits
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&limits) != nil || limits != want {
+		t.Errorf("POST = %d %+v, want %+v", rec.Code, limits, want)
+	}
+	rec = do(http.MethodGet, "/admin/documents/limits?id=app-conf", "")
+	limits = schema.Limits{}
+	if json.NewDecoder(rec.Body).Decode(&limits) != nil || limits != want {
+		t.Errorf("GET = %+v, want %+v", limits, want)
+	}
+
+	// Writes through the REST API report the violation in the same shape.
+	rec = do(http.MethodPut, "/api/documents/app-conf", `{"content":"a\nb\nc","version":1}`)
+	failed = violationResponse{}
+	if rec.Code != http.StatusUnprocessableEntity || json.NewDecoder(rec.Body).Decode(&failed) != nil ||
+		failed.Violation.Rule != schema.RuleMaxLines || failed.Violation.Line != 2 {
+		t.Errorf("PUT = %d %+v, want 422 with a max_lines violation at line 2", rec.Code, failed)
+	}
+}
+
+func TestAdminSchedule(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	srv.hub.GetOrCreateDocument("exam")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/admin/documents/schedule", `{"id":"exam","action":"explode","at":"2030-01-01T09:00:00Z"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown action status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(http.MethodPost, "/admin/documents/schedule", `{"id":"nowhere","action":"lock","at":"2030-01-01T09:00:00Z"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("missing document status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec := do(http.MethodPost, "/admin/documents/schedule", `{"id":"exam","action":"lock","at":"2030-01-01T09:00:00Z","reason":"Time is up"}`)
+	var scheduled document.ScheduledAction
+	if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&scheduled) != nil || scheduled.ID == "" || scheduled.Reason != "Time is up" {
+		t.Fatalf("POST = %d %+v, want 201 with the action", rec.Code, scheduled)
+	}
+	rec = do(http.MethodGet, "/admin/documents/schedule?id=exam", "")
+	var listed []document.ScheduledAction
+	if json.NewDecoder(rec.Body).Decode(&listed) != nil || len(listed) != 1 || listed[0].ID != scheduled.ID {
+		t.Errorf("GET = %+v, want the scheduled lock", listed)
+	}
+
+	if rec := do(http.MethodDelete, "/admin/documents/schedule?id=exam&action_id="+scheduled.ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := do(http.MethodDelete, "/admin/documents/schedule?id=exam&action_id="+scheduled.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminValidator(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	srv.hub.GetOrCreateDocument("deploy").SetContent("replicas: 3\n image: app\n")
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/admin/documents/validator", `{"id":"deploy","validator":"yaml"}`)
+	var resp validatorResponse
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&resp) != nil {
+		t.Fatalf("POST = %d %s", rec.Code, rec.Body)
+	}
+	if resp.Validator != "yaml" || len(resp.Diagnostics) != 1 || resp.Diagnostics[0].Line != 1 {
+		t.Errorf("POST response = %+v, want one yaml diagnostic on line 1", resp)
+	}
+
+	rec = do(http.MethodPost, "/admin/documents/validator", `{"id":"deploy","validator":""}`)
+	if !strings.Contains(rec.Body.String(), `"diagnostics":[]`) {
+		t.Errorf("after removing the validator: %s, want no diagnostics", rec.Body)
+	}
+	if rec := do(http.MethodPost, "/admin/documents/validator", `{"id":"deploy","validator":"ini"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown validator status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// TestPublish verifies that publishing waits for the approval webhook and
+// that the published version stays put while editing continues.
+func TestPublish(t *testing.T) {
+	var requests []publish.Request
+	approver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req publish.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode approval request: %v", err)
+		}
+		requests = append(requests, req)
+		switch {
+		case strings.Contains(req.Content, "TODO"):
+			writeJSON(w, http.StatusOK, publish.Decision{Reason: "unfinished"})
+		case strings.Contains(req.Content, "crash"):
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusOK, publish.Decision{Approved: true})
+		}
+	}))
+	defer approver.Close()
+
+	srv := New(Config{
+		Port:      ":8080",
+		StaticDir: "testdata",
+		Auth:      auth.NewStaticKeys(map[string]auth.Identity{"key": {Subject: "editor"}}),
+		Approver:  publish.NewWebhook(approver.URL, "", nil),
+	})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer key")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	srv.hub.ReplaceContent("release-notes", "TODO", 0)
+	if rec := do(http.MethodGet, "/api/documents/release-notes/published"); rec.Code != http.StatusNotFound {
+		t.Errorf("unpublished GET status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	rec := do(http.MethodPost, "/api/documents/release-notes/publish")
+	var refused publishResponse
+	if err := json.NewDecoder(rec.Body).Decode(&refused); err != nil || rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("refused POST status = %d, %v", rec.Code, err)
+	}
+	if refused.Approved || refused.Reason != "unfinished" || refused.Version != 1 {
+		t.Errorf("refusal = %+v", refused)
+	}
+
+	version, _ := srv.hub.ReplaceContent("release-notes", "Version 2 is out.", 1)
+	if rec := do(http.MethodPost, "/api/documents/release-notes/publish"); rec.Code != http.StatusOK {
+		t.Fatalf("POST status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := requests[len(requests)-1]; got.Version != version || got.RequestedBy != "editor" {
+		t.Errorf("approval request = %+v, want version %d by editor", got, version)
+	}
+
+	// Edits after publishing, and a failed approval, leave it unchanged.
+	srv.hub.ReplaceContent("release-notes", "Version 3 may crash.", version)
+	if rec := do(http.MethodPost, "/api/documents/release-notes/publish"); rec.Code != http.StatusBadGateway {
+		t.Errorf("failed approval status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+	rec = do(http.MethodGet, "/api/documents/release-notes/published")
+	var doc publishedDocument
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, %v", rec.Code, err)
+	}
+	if doc.ID != "release-notes" || doc.Version != version || doc.Content != "Version 2 is out." {
+		t.Errorf("published = %+v, want version %d", doc, version)
+	}
+}
+
+// TestPage verifies that /d/{id} renders the published version, or the
+// latest one before publishing, and answers revalidation with 304.
+func TestPage(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	version, _ := srv.hub.ReplaceContent("guide", "# Draft", 0)
+	rec := get("/d/guide", "")
+	draftTag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<h1>Draft</h1>") || draftTag == "" {
+		t.Fatalf("draft GET = %d %q, ETag %q", rec.Code, rec.Body, draftTag)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if rec := get("/d/guide", draftTag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("revalidation status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+
+	if err := srv.hub.Publish("guide", document.Publication{Version: version, Content: "# Guide"}); err != nil {
+		t.Fatalf("Publish() error: %v", err)
+	}
+	srv.hub.ReplaceContent("guide", "# Rewrite in progress", version)
+	rec = get("/d/guide", draftTag)
+	publishedTag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<h1>Guide</h1>") || publishedTag == draftTag {
+		t.Fatalf("published GET = %d %q, ETag %q", rec.Code, rec.Body, publishedTag)
+	}
+	if rec := get("/d/guide", `W/"other", `+publishedTag); rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+	if _, err := srv.hub.Redact("guide", document.Redaction{Pattern: regexp.MustCompile(`Guide`)}); err != nil {
+		t.Fatalf("Redact() error: %v", err)
+	}
+	if rec := get("/d/guide", ""); strings.Contains(rec.Body.String(), "Guide") {
+		t.Errorf("published page after redaction = %q, want it scrubbed", rec.Body)
+	}
+
+	if _, err := srv.hub.SetVisibility("guide", document.VisibilityPrivate); err != nil {
+		t.Fatalf("SetVisibility() error: %v", err)
+	}
+	if rec := get("/d/guide", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("private GET status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := get("/d/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("missing GET status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	for _, id := range []string{"a", "b", "c"} {
+		srv.hub.ReplaceContent(id, "text", 0)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	for _, path := range []string{"/debug/dashboard", "/debug/stats", "/debug/latency", "/debug/goroutines"} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without the admin token = %d, want %d", path, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	rec := get("/debug/dashboard?top=2")
+	var d hub.Dashboard
+	if err := json.NewDecoder(rec.Body).Decode(&d); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, %v", rec.Code, err)
+	}
+	if d.Storage.Documents != 3 || len(d.TopDocuments) != 2 {
+		t.Errorf("dashboard = %+v, want 3 documents and the top 2", d)
+	}
+	if rec := get("/debug/dashboard?top=-1"); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative top status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// TestCreateDocument verifies that POST /api/documents generates IDs from
+// titles, retrying taken ones, and that reserved prefixes are kept for
+// admins.
+func TestCreateDocument(t *testing.T) {
+	srv := New(Config{
+		Port:       ":8080",
+		StaticDir:  "testdata",
+		AdminToken: "secret",
+		IDs:        slug.Policy{Reserved: []string{"admin-"}},
+	})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	create := func(title string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		body, _ := json.Marshal(createDocumentRequest{Title: title})
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(string(body))))
+		var created createdDocument
+		if err := json.NewDecoder(rec.Body).Decode(&created); err != nil || rec.Code != http.StatusCreated {
+			t.Fatalf("POST %q status = %d, %v", title, rec.Code, err)
+		}
+		if srv.hub.GetDocument(created.ID) == nil {
+			t.Errorf("document %s was not created", created.ID)
+		}
+		return created.ID
+	}
+
+	if id := create("Team Notes"); id != "team-notes" {
+		t.Errorf("id = %q, want team-notes", id)
+	}
+	if id := create("Team Notes"); !strings.HasPrefix(id, "team-notes-") {
+		t.Errorf("id = %q, want team-notes with a suffix", id)
+	}
+	if id := create("Shit list"); id != "list" {
+		t.Errorf("id = %q, want list", id)
+	}
+	if id := create("Admin handbook"); id != "doc-admin-handbook" {
+		t.Errorf("id = %q, want doc-admin-handbook", id)
+	}
+
+	put := func(id string, admin bool) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/api/documents/"+id, strings.NewReader(`{"content":"x","version":0}`))
+		if admin {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := put("admin-status", false); code != http.StatusForbidden {
+		t.Errorf("reserved PUT status = %d, want %d", code, http.StatusForbidden)
+	}
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws/admin-status", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("reserved WebSocket status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if code := put("admin-status", true); code != http.StatusOK {
+		t.Errorf("admin PUT status = %d, want %d", code, http.StatusOK)
+	}
+	if doc := srv.hub.GetDocument("admin-status"); doc == nil {
+		t.Error("admin could not create a reserved document")
+	}
+
+	rec = httptest.NewRecorder()
+	body, _ := json.Marshal(createDocumentRequest{Title: "Agenda", Content: "1. Budget"})
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(string(body))))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST with content status = %d: %s", rec.Code, rec.Body)
+	}
+	if content := srv.hub.GetDocument("agenda").GetContent(); content != "1. Budget" {
+		t.Errorf("content = %q, want the initial body", content)
+	}
+}
+
+// TestListDocumentsAndStats verifies that GET /api/documents lists only
+// documents the caller may read, and that the stats endpoint reports a
+// document's version, size and connections.
+func TestListDocumentsAndStats(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	srv.hub.ReplaceContent("open-doc", "hello", 0)
+	srv.hub.ReplaceContent("private-doc", "secret", 0)
+	if _, err := srv.hub.SetVisibility("private-doc", document.VisibilityPrivate); err != nil {
+		t.Fatal(err)
+	}
+	client := hub.NewLocalClient(srv.hub, "open-doc", 16)
+	srv.hub.Register(client)
+	defer srv.hub.Unregister(client)
+	<-client.Messages() // welcome, once registered
+
+	get := func(target string, admin bool, v any) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if admin {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+				t.Fatalf("GET %s: %v", target, err)
+			}
+		}
+		return rec.Code
+	}
+
+	var ids []string
+	if get("/api/documents", false, &ids); !reflect.DeepEqual(ids, []string{"open-doc"}) {
+		t.Errorf("listed %v, want [open-doc]", ids)
+	}
+	if get("/api/documents", true, &ids); !reflect.DeepEqual(ids, []string{"open-doc", "private-doc"}) {
+		t.Errorf("admin listed %v, want both documents", ids)
+	}
+
+	var stats documentStats
+	if code := get("/api/documents/open-doc/stats", false, &stats); code != http.StatusOK {
+		t.Fatalf("stats status = %d", code)
+	}
+	if stats.ID != "open-doc" || stats.Version != 1 || stats.Length != 5 || stats.Clients != 1 || stats.LastModified.IsZero() {
+		t.Errorf("stats = %+v, want version 1, length 5 and one client", stats)
+	}
+	if code := get("/api/documents/private-doc/stats", false, &stats); code != http.StatusForbidden {
+		t.Errorf("private stats status = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := get("/api/documents/missing/stats", false, &stats); code != http.StatusNotFound {
+		t.Errorf("missing stats status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	if err := srv.hub.SetDocumentMetadata("private-doc", map[string]string{"workflow_state": "in-review"}); err != nil {
+		t.Fatal(err)
+	}
+	if get("/api/documents?workflow_state=in-review", true, &ids); !reflect.DeepEqual(ids, []string{"private-doc"}) {
+		t.Errorf("listed %v in review, want [private-doc]", ids)
+	}
+	if get("/api/documents?workflow_state=in-review", false, &ids); len(ids) != 0 {
+		t.Errorf("listed %v in review without access", ids)
+	}
+	if get("/api/documents?workflow_state=draft", false, &ids); !reflect.DeepEqual(ids, []string{"open-doc"}) {
+		t.Errorf("listed %v as drafts, want [open-doc]", ids)
+	}
+	if code := get("/api/documents?workflow_state=done", false, &ids); code != http.StatusBadRequest {
+		t.Errorf("unknown state status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if get("/api/documents/private-doc/stats", true, &stats); stats.WorkflowState != document.WorkflowInReview {
+		t.Errorf("stats workflow state = %q, want in-review", stats.WorkflowState)
+	}
+}
+
+// TestCompression verifies permessage-deflate is negotiated only when
+// configured, and a large document arrives intact over it.
+func TestCompression(t *testing.T) {
+	big := strings.Repeat("all work and no play ", 2000)
+	for _, threshold := range []int{0, 1024} {
+		srv := New(Config{Port: ":8080", StaticDir: "testdata", Compression: hub.CompressionPolicy{Threshold: threshold}})
+		go srv.hub.Run()
+		if _, err := srv.hub.ReplaceContent("big", big, 0); err != nil {
+			t.Fatal(err)
+		}
+		httpServer := httptest.NewServer(srv.Handler())
+
+		dialer := websocket.Dialer{EnableCompression: true}
+		conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/ws/big", nil)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		negotiated := strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+		if negotiated != (threshold > 0) {
+			t.Errorf("threshold %d: negotiated = %v", threshold, negotiated)
+		}
+
+		found := false
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for !found {
+			_, frame, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("threshold %d: read failed before the content: %v", threshold, err)
+			}
+			for _, line := range strings.Split(string(frame), "\n") {
+				if msg, err := hub.MessageFromBytes([]byte(line)); err == nil && msg.Content == big {
+					found = true
+				}
+			}
+		}
+		conn.Close()
+		httpServer.Close()
+		srv.hub.Shutdown()
+	}
+}
+
+func TestAdminHistoryWindow(t *testing.T) {
+	srv := New(Config{Port: ":8080", StaticDir: "testdata", AdminToken: "secret"})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/admin/documents/history", `{"id":"notes","versions":-5}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative window status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec := do(http.MethodPost, "/admin/documents/history", `{"id":"notes","versions":50,"max_age_ms":60000}`)
+	want := historyWindow{Versions: 50, MaxAgeMS: 60000}
+	var got historyWindow
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&got) != nil || got != want {
+		t.Errorf("POST = %d %+v, want %+v", rec.Code, got, want)
+	}
+	got = historyWindow{}
+	if rec := do(http.MethodGet, "/admin/documents/history?id=notes", ""); json.NewDecoder(rec.Body).Decode(&got) != nil || got != want {
+		t.Errorf("GET = %+v, want %+v", got, want)
+	}
+}
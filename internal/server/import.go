@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/schema"
+)
+
+// importTypes are the media types an import accepts.
+var importTypes = map[string]bool{
+	"text/markdown":   true,
+	"text/x-markdown": true,
+	"text/plain":      true,
+}
+
+// handleImport serves POST /api/documents/{id}/import: the body, a
+// Markdown or plain text file, becomes the document's content as one
+// change, creating the document if needed. Connected clients receive the
+// new content in full. The body must be UTF-8; a byte order mark is
+// dropped and line endings become "\n".
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isValidDocumentID(documentID) {
+		http.Error(w, "invalid document ID", http.StatusBadRequest)
+		return
+	}
+	if s.documentAccess(r, documentID) < accessWrite || s.reservedID(r, documentID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !importTypes[mediaType] {
+		http.Error(w, "import takes text/markdown or text/plain", http.StatusUnsupportedMediaType)
+		return
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		http.Error(w, "import takes UTF-8, not "+charset, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxContentSize))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, "reading file: "+err.Error(), http.StatusBadRequest)
+		return
+	case !utf8.Valid(body):
+		http.Error(w, "file is not valid UTF-8", http.StatusBadRequest)
+		return
+	}
+
+	content := normalizeImport(string(body))
+	if err := s.checkFences(r, documentID, content); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	version, err := s.hub.ImportContent(documentID, content)
+	var violation *schema.ViolationError
+	switch {
+	case errors.Is(err, hub.ErrDocumentDeleted):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, hub.ErrDocumentPaused):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, document.ErrWrongKind):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.As(err, &violation):
+		writeJSON(w, http.StatusUnprocessableEntity, violationResponse{Error: err.Error(), Violation: violation})
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, documentVersionResponse{Version: version})
+	}
+}
+
+// normalizeImport drops a byte order mark and turns "\r\n" and lone "\r"
+// line endings into "\n", as editors on other systems save files.
+func normalizeImport(content string) string {
+	content = strings.TrimPrefix(content, "\ufeff")
+	return strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\r", "\n")
+}
@@ -1,8 +1,13 @@
 package server_test
 
 import (
+	"collaborative-docs/internal/auth"
+	"collaborative-docs/internal/handshake"
 	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/protocol"
+	"collaborative-docs/internal/server"
 	"collaborative-docs/internal/server/testutil"
+	"errors"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -204,3 +209,174 @@ func TestEmptyMessage(t *testing.T) {
 		t.Errorf("received non-empty message: %q", got)
 	}
 }
+
+// TestSubprotocols verifies that JSON and protobuf clients negotiate their
+// encodings on one endpoint and see each other's edits.
+func TestSubprotocols(t *testing.T) {
+	srv := server.New(server.Config{Port: ":8080", StaticDir: "testdata"})
+	go srv.Hub().Run()
+	defer srv.Hub().Shutdown()
+	httpServer := httptest.NewServer(srv.Handler())
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws/shared"
+
+	jsonConn := testutil.MustConnect(t, wsURL)
+	defer jsonConn.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{"collab.v9", protocol.NameProto}}
+	protoConn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("proto dial failed: %v", err)
+	}
+	defer protoConn.Close()
+	if got := protoConn.Subprotocol(); got != protocol.NameProto {
+		t.Fatalf("negotiated %q, want %q", got, protocol.NameProto)
+	}
+	msgpackConn, _, err := (&websocket.Dialer{Subprotocols: []string{protocol.NameMsgpack}}).Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("msgpack dial failed: %v", err)
+	}
+	defer msgpackConn.Close()
+	testutil.WaitForRegistration()
+
+	frame, err := protocol.Proto.Encode([]byte(`{"type":"operation","document_id":"shared","operation":{"type":"insert","position":0,"text":"hi","version":0,"id":"p1"}}`))
+	if err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+	if err := protoConn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if got := testutil.ReadNextContent(t, jsonConn); !strings.Contains(got, `"text":"hi"`) {
+		t.Errorf("JSON client received %s, want the operation", got)
+	}
+	msgpackConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, frame, err := msgpackConn.ReadMessage()
+		if err != nil {
+			t.Fatalf("MessagePack client received no operation: %v", err)
+		}
+		data, err := protocol.Msgpack.Decode(frame)
+		if err != nil {
+			t.Fatalf("Decode() error: %v", err)
+		}
+		if msg, _ := hub.MessageFromBytes(data); msg != nil && msg.Type == hub.MsgTypeOperation {
+			if msg.Operation == nil || msg.Operation.Text != "hi" {
+				t.Errorf("MessagePack client received %s, want the operation", data)
+			}
+			break
+		}
+	}
+
+	// The sender's acknowledgement arrives as a binary frame.
+	protoConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		frameType, frame, err := protoConn.ReadMessage()
+		if err != nil {
+			t.Fatalf("no acknowledgement: %v", err)
+		}
+		if frameType != websocket.BinaryMessage {
+			t.Fatalf("frame type = %d, want binary", frameType)
+		}
+		data, err := protocol.Proto.Decode(frame)
+		if err != nil {
+			t.Fatalf("Decode() error: %v", err)
+		}
+		if msg, _ := hub.MessageFromBytes(data); msg != nil && msg.Type == hub.MsgTypeAck {
+			break
+		}
+	}
+
+	dialer.Subprotocols = []string{"collab.v9"}
+	if _, resp, err := dialer.Dial(wsURL, nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown subprotocol dial = %v, want 400", err)
+	}
+}
+
+// TestHandshakeMiddleware verifies that identities and tenants resolved
+// before the upgrade reach the hub's clients, replacing the token check.
+func TestHandshakeMiddleware(t *testing.T) {
+	session := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cookie, err := r.Cookie("session"); err == nil {
+				r = r.WithContext(handshake.WithIdentity(r.Context(), &auth.Identity{Subject: cookie.Value}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	srv := server.New(server.Config{
+		Port:      ":8080",
+		StaticDir: "testdata",
+		Auth:      auth.NewStaticKeys(map[string]auth.Identity{"key": {Subject: "service"}}),
+		Handshake: []handshake.Middleware{
+			session,
+			handshake.ResolveTenant(func(*http.Request) (string, error) { return "acme", nil }),
+		},
+	})
+	go srv.Hub().Run()
+	defer srv.Hub().Shutdown()
+	httpServer := httptest.NewServer(srv.Handler())
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws/notes"
+
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without credentials = %v, want 401", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Cookie": {"session=alice"}})
+	if err != nil {
+		t.Fatalf("dial with session failed: %v", err)
+	}
+	defer conn.Close()
+	testutil.WaitForRegistration()
+
+	clients, err := srv.Hub().Clients()
+	if err != nil || len(clients) != 1 {
+		t.Fatalf("Clients() = %v, %v", clients, err)
+	}
+	if clients[0].Subject != "alice" || clients[0].Tenant != "acme" {
+		t.Errorf("client = %+v, want alice of acme", clients[0])
+	}
+}
+
+// TestSessionClose verifies a session deadline set during the handshake
+// and a hub shutdown each end the connection with a close frame giving
+// the reason.
+func TestSessionClose(t *testing.T) {
+	srv := server.New(server.Config{
+		Port:      ":8080",
+		StaticDir: "testdata",
+		Handshake: []handshake.Middleware{handshake.MaxSession(300*time.Millisecond, nil)},
+	})
+	go srv.Hub().Run()
+	httpServer := httptest.NewServer(srv.Handler())
+	defer httpServer.Close()
+	wsURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/ws/notes"
+
+	closeError := func(conn *websocket.Conn) *websocket.CloseError {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			_, _, err := conn.ReadMessage()
+			if err == nil {
+				continue
+			}
+			var ce *websocket.CloseError
+			if !errors.As(err, &ce) {
+				t.Fatalf("read error = %v, want a close frame", err)
+			}
+			return ce
+		}
+	}
+
+	expiring := testutil.MustConnect(t, wsURL)
+	defer expiring.Close()
+	if ce := closeError(expiring); ce.Code != websocket.ClosePolicyViolation || ce.Text != "session expired" {
+		t.Errorf("expired session closed with %d %q", ce.Code, ce.Text)
+	}
+
+	live := testutil.MustConnect(t, wsURL)
+	defer live.Close()
+	testutil.WaitForRegistration()
+	srv.Hub().Shutdown()
+	if ce := closeError(live); ce.Code != websocket.CloseGoingAway {
+		t.Errorf("shutdown closed with %d %q", ce.Code, ce.Text)
+	}
+}
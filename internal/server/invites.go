@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/hub"
+)
+
+// createInviteRequest is the body of POST /api/documents/{id}/invites.
+type createInviteRequest struct {
+	Role       document.Role `json:"role"`
+	TTLSeconds int           `json:"ttl_seconds"` // Zero never expires
+	MaxUses    int           `json:"max_uses"`    // Zero is unlimited
+}
+
+// redeemInviteRequest is the body of POST /api/documents/{id}/invites/redeem.
+type redeemInviteRequest struct {
+	Token string `json:"token"`
+	Name  string `json:"name"` // Shown to collaborators
+}
+
+// redeemInviteResponse carries the access token a new collaborator passes
+// as ?token= when connecting.
+type redeemInviteResponse struct {
+	AccessToken string        `json:"access_token"`
+	Role        document.Role `json:"role"`
+}
+
+// handleInvites serves /api/documents/{id}/invites, where collaborators
+// who may edit create invites, and /api/documents/{id}/invites/redeem,
+// where anyone holding an invite token joins the document's access list.
+func (s *Server) handleInvites(w http.ResponseWriter, r *http.Request, documentID, action string) {
+	if !isValidDocumentID(documentID) || (action != "invites" && action != "invites/redeem") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if action == "invites" {
+		if s.documentAccess(r, documentID) < accessWrite {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var req createInviteRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		inv, err := s.hub.CreateInvite(documentID, req.Role, time.Duration(req.TTLSeconds)*time.Second, req.MaxUses)
+		switch {
+		case errors.Is(err, hub.ErrDocumentDeleted):
+			http.Error(w, err.Error(), http.StatusGone)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeJSON(w, http.StatusCreated, inv)
+		}
+		return
+	}
+
+	var req redeemInviteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContentSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	accessToken, role, err := s.hub.RedeemInvite(documentID, req.Token, req.Name)
+	switch {
+	case errors.Is(err, document.ErrInviteNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, hub.ErrDocumentDeleted), errors.Is(err, document.ErrInviteExpired), errors.Is(err, document.ErrInviteUsedUp):
+		http.Error(w, err.Error(), http.StatusGone)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeJSON(w, http.StatusOK, redeemInviteResponse{AccessToken: accessToken, Role: role})
+	}
+}
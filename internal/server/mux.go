@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"collaborative-docs/internal/handshake"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/protocol"
+)
+
+// errForbidden refuses a mux session on a document the user may not open.
+var errForbidden = errors.New("forbidden")
+
+// handleMux upgrades /mux to a WebSocket carrying several sessions, such
+// as the tabs of a browser sharing one connection through a SharedWorker;
+// see hub.Mux. Config.Handshake and authentication run once for the
+// connection, and each session opened is checked against its document as
+// a connection to /ws/ would be, with the same ?token=. Sessions speak
+// plain JSON. A standby refuses connections until it is promoted.
+func (s *Server) handleMux(w http.ResponseWriter, r *http.Request) {
+	if s.refuseStandby(w) {
+		return
+	}
+	id := handshake.Identity(r.Context())
+	if id == nil {
+		if id = s.authenticate(w, r); id == nil {
+			return
+		}
+		r = withIdentity(r, id)
+	}
+
+	conn, err := s.upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("websocket upgrade failed: %v", err)
+		return
+	}
+	if s.config.LogEnabled {
+		log.Printf("mux connected (subject: %q)", id.Subject)
+	}
+
+	var wsConn hub.Conn = conn
+	if s.config.WrapConn != nil {
+		wsConn = s.config.WrapConn(conn)
+	}
+	mux := hub.NewMux(s.hub, wsConn, func(session, documentID string, readOnly bool, conn hub.Conn) error {
+		documentID, err := extractDocumentID(documentID, "")
+		if err != nil {
+			return err
+		}
+		level := s.documentAccess(r, documentID)
+		if level == accessNone || s.reservedID(r, documentID) {
+			return errForbidden
+		}
+		if readOnly {
+			level = min(level, accessRead)
+		}
+		s.startClient(r, conn, protocol.JSON, documentID, level, -1)
+		return nil
+	})
+	go mux.Run()
+}
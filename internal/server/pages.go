@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/render"
+)
+
+// page is a rendered /d/{id} response.
+type page struct {
+	body      []byte
+	etag      string
+	version   int
+	published bool // Rendered from the published version rather than the latest
+	public    bool // The document could be read by anyone when rendered
+}
+
+// pageCache holds rendered pages so that readers of a published document
+// are answered without reaching the hub. It is an events.Sink: publishing,
+// deleting, evicting, redacting or rebuilding a document or changing its
+// visibility drops its page.
+// A page of the latest version is instead checked against the document's
+// version on each request, since not every edit emits an event.
+type pageCache struct {
+	mu    sync.Mutex
+	pages map[string]*page
+	gens  map[string]uint64 // Bumped on each drop, so renders started before it are not stored
+}
+
+func newPageCache() *pageCache {
+	return &pageCache{pages: make(map[string]*page), gens: make(map[string]uint64)}
+}
+
+// Publish implements events.Sink.
+func (c *pageCache) Publish(e events.Event) {
+	switch e.Type {
+	case events.TypeDocumentPublished, events.TypeDocumentDeleted,
+		events.TypeDocumentEvicted, events.TypeVisibilityChanged,
+		events.TypeDocumentRedacted, events.TypeDocumentRebuilt:
+		c.mu.Lock()
+		delete(c.pages, e.DocumentID)
+		c.gens[e.DocumentID]++
+		c.mu.Unlock()
+	}
+}
+
+// get returns the cached page for a document and the generation to pass
+// to put when replacing it.
+func (c *pageCache) get(documentID string) (*page, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pages[documentID], c.gens[documentID]
+}
+
+// put caches p unless the document's page was dropped since gen was read.
+func (c *pageCache) put(documentID string, gen uint64, p *page) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[documentID] == gen {
+		c.pages[documentID] = p
+	}
+}
+
+// handlePage serves GET /d/{id}: the document's published version, or its
+// latest version if it was never published, rendered as HTML. Responses
+// carry an ETag, and a request whose If-None-Match names it gets 304. A
+// published page of a document anyone may read is served from the cache
+// without reaching the hub. Unpublished documents are drafts, so they need
+// the same authentication as the document API.
+func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	documentID := strings.TrimPrefix(r.URL.Path, "/d/")
+	if !isValidDocumentID(documentID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	p, gen := s.pages.get(documentID)
+	if p == nil || !p.published || !p.public {
+		var ok bool
+		if p, ok = s.loadPage(w, r, documentID, p, gen); !ok {
+			return
+		}
+	}
+
+	w.Header().Set("ETag", p.etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), p.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(p.body)))
+	if r.Method == http.MethodGet {
+		w.Write(p.body)
+	}
+}
+
+// loadPage checks access to a document through the hub and returns its
+// page, reusing cached if it is still current. It writes an error response
+// and returns false if the page cannot be served.
+func (s *Server) loadPage(w http.ResponseWriter, r *http.Request, documentID string, cached *page, gen uint64) (*page, bool) {
+	if s.hub.IsDeleted(documentID) {
+		http.Error(w, hub.ErrDocumentDeleted.Error(), http.StatusGone)
+		return nil, false
+	}
+	doc := s.hub.GetDocument(documentID)
+	if doc == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	publication, published := doc.Published()
+	if !published {
+		id := s.authenticate(w, r)
+		if id == nil {
+			return nil, false
+		}
+		r = withIdentity(r, id)
+	}
+	if s.documentAccess(r, documentID) < accessRead {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil, false
+	}
+
+	content, version := publication.Content, publication.Version
+	var formatting operations.Spans // Publications keep none
+	if !published {
+		content, formatting, version = doc.FormattedContent()
+	}
+	if cached != nil && cached.published == published && cached.version == version {
+		return cached, true
+	}
+
+	visibility, _ := doc.Visibility()
+	body, err := render.HTML(render.Input{
+		Title:      documentID,
+		Kind:       doc.GetKind(),
+		Language:   doc.GetLanguage(),
+		Content:    content,
+		Version:    version,
+		Formatting: formatting,
+	})
+	if err != nil {
+		log.Printf("rendering %s failed: %v", documentID, err)
+		http.Error(w, "rendering failed", http.StatusInternalServerError)
+		return nil, false
+	}
+	sum := sha256.Sum256(body)
+	p := &page{
+		body:      body,
+		etag:      `"` + hex.EncodeToString(sum[:16]) + `"`,
+		version:   version,
+		published: published,
+		public:    visibility == document.VisibilityOpen || visibility == document.VisibilityPublic,
+	}
+	s.pages.put(documentID, gen, p)
+	return p, true
+}
+
+// etagMatches reports whether an If-None-Match header names etag. Weak
+// validators match too, as RFC 9110 asks for this comparison.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
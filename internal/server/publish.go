@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"collaborative-docs/internal/auth"
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/publish"
+)
+
+// publishResponse is the reply to POST /api/documents/{id}/publish. A
+// refused snapshot is reported with the approver's reason and nothing is
+// published.
+type publishResponse struct {
+	Approved    bool       `json:"approved"`
+	Reason      string     `json:"reason,omitempty"`
+	Version     int        `json:"version"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+}
+
+// publishedDocument is the body of GET /api/documents/{id}/published.
+type publishedDocument struct {
+	ID string `json:"id"`
+	document.Publication
+}
+
+// handlePublish serves POST /api/documents/{id}/publish, where
+// collaborators who may edit ask for the current version to be published.
+// The server snapshots the document, asks the configured approver, and on
+// approval tags the snapshot as the published version. Publishing is only
+// available when an approver is configured.
+func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request, documentID string, id *auth.Identity) {
+	if s.config.Approver == nil || !isValidDocumentID(documentID) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.documentAccess(r, documentID) < accessWrite {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if s.hub.IsDeleted(documentID) {
+		http.Error(w, hub.ErrDocumentDeleted.Error(), http.StatusGone)
+		return
+	}
+	doc := s.hub.GetDocument(documentID)
+	if doc == nil {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+
+	content, version := doc.GetContentAndVersion()
+	decision, err := s.config.Approver.Approve(r.Context(), publish.Request{
+		DocumentID:  documentID,
+		Version:     version,
+		Content:     content,
+		RequestedBy: id.Subject,
+	})
+	if err != nil {
+		log.Printf("publish approval for %s failed: %v", documentID, err)
+		http.Error(w, "approver unavailable", http.StatusBadGateway)
+		return
+	}
+	if !decision.Approved {
+		log.Printf("publishing %s at version %d refused: %s", documentID, version, decision.Reason)
+		writeJSON(w, http.StatusUnprocessableEntity, publishResponse{Reason: decision.Reason, Version: version})
+		return
+	}
+
+	err = s.hub.Publish(documentID, document.Publication{Version: version, Content: content})
+	switch {
+	case errors.Is(err, hub.ErrDocumentDeleted):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, hub.ErrDocumentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, document.ErrStalePublication):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, hub.ErrHubStopped):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		p, _ := s.hub.Published(documentID)
+		writeJSON(w, http.StatusOK, publishResponse{
+			Approved:    true,
+			Version:     p.Version,
+			PublishedAt: &p.PublishedAt,
+		})
+	}
+}
+
+// handlePublished serves GET /api/documents/{id}/published, the last
+// approved version of a document. Its content only changes when a newer
+// version is published, so readers can link to it while editing goes on.
+func (s *Server) handlePublished(w http.ResponseWriter, r *http.Request, documentID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isValidDocumentID(documentID) {
+		http.NotFound(w, r)
+		return
+	}
+	if s.documentAccess(r, documentID) < accessRead {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if s.hub.IsDeleted(documentID) {
+		http.Error(w, hub.ErrDocumentDeleted.Error(), http.StatusGone)
+		return
+	}
+	p, ok := s.hub.Published(documentID)
+	if !ok {
+		http.Error(w, "document not published", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, publishedDocument{ID: documentID, Publication: p})
+}
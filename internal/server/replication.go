@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+)
+
+// handleReplicationStatus serves GET /admin/replication/status: how far a
+// standby has followed its primary. A primary has nothing to report.
+func (s *Server) handleReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.standby == nil {
+		http.Error(w, "not a standby", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.standby.Status())
+}
+
+// handlePromote serves POST /admin/replication/promote: the standby stops
+// following its primary and accepts clients and edits from then on. The
+// response is its status at promotion; edits the primary applied after
+// last_record are lost.
+func (s *Server) handlePromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.standby == nil {
+		http.Error(w, "not a standby", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.standby.Promote())
+}
+
+// refuseStandby answers r with 503 Service Unavailable if the server is a
+// standby not yet promoted, and reports whether it did.
+func (s *Server) refuseStandby(w http.ResponseWriter) bool {
+	if s.standby == nil || s.standby.Promoted() {
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "standby server: connect to the primary", http.StatusServiceUnavailable)
+	return true
+}
@@ -2,12 +2,33 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"collaborative-docs/internal/attachments"
+	"collaborative-docs/internal/auth"
+	"collaborative-docs/internal/clock"
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/events"
+	"collaborative-docs/internal/handshake"
 	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/metrics"
+	"collaborative-docs/internal/pressure"
+	"collaborative-docs/internal/protocol"
+	"collaborative-docs/internal/publish"
+	"collaborative-docs/internal/replication"
+	"collaborative-docs/internal/schema"
+	"collaborative-docs/internal/slug"
+)
+
+const (
+	maxAttachmentSize  = 10 * 1024 * 1024 // Maximum attachment upload size (10MB)
+	maxContentSize     = 1024 * 1024      // Maximum request body for document writes (1MB)
+	attachmentGCPeriod = 5 * time.Minute  // Interval between unreferenced attachment sweeps
+	memoryCheckPeriod  = 10 * time.Second // Interval between memory pressure samples
 )
 
 // Config holds server configuration.
@@ -16,19 +37,186 @@ type Config struct {
 	StaticDir      string
 	LogEnabled     bool
 	AllowedOrigins string
+
+	// AttachmentDir enables attachment uploads stored in this directory.
+	AttachmentDir string
+	// AttachmentSecret signs upload URLs; a random secret is used if empty.
+	AttachmentSecret string
+	// PublicURL is the externally visible base URL, used in upload URLs.
+	PublicURL string
+
+	// EventSinks receive the document change event stream (e.g. a KafkaSink).
+	EventSinks []events.Sink
+
+	// LatencyThreshold is the per-operation latency above which a
+	// latency_alert event is emitted; defaults to the 50ms SLO.
+	LatencyThreshold time.Duration
+
+	// CoalesceWindow holds each document's text operations for up to this
+	// long and composes consecutive ones from the same sender before
+	// relaying them, so fast typing sends fewer messages; zero relays each
+	// operation at once.
+	CoalesceWindow time.Duration
+
+	// MemoryHighWatermark and MemoryCriticalWatermark (bytes of heap in use)
+	// enable history compaction and idle document eviction; zero disables.
+	MemoryHighWatermark     uint64
+	MemoryCriticalWatermark uint64
+
+	// Abuse scores clients for flooding, refused edits and malformed
+	// messages, throttling or disconnecting them past its thresholds; the
+	// zero value scores without acting.
+	Abuse hub.AbusePolicy
+
+	// RateLimit drops each connection's messages over a token-bucket rate
+	// before they reach the hub, warning the client and then
+	// disconnecting it; the zero value does not limit.
+	RateLimit hub.RateLimit
+
+	// Schemas enforce a line structure on new text documents, keyed by
+	// document ID prefix ("" matches all); the longest matching prefix wins.
+	Schemas map[string]*schema.Schema
+
+	// AdminToken enables the bulk migration API under /admin/, authorized
+	// with "Authorization: Bearer <token>"; empty disables it.
+	AdminToken string
+
+	// Auth, when set, requires every WebSocket and /api/documents request
+	// to carry a token the provider accepts, such as an OIDC ID token or an
+	// API key. Admin-authorized requests are exempt.
+	Auth auth.Provider
+
+	// Handshake runs on WebSocket requests before the upgrade, first
+	// entry first, for concerns such as rate limiting, tenant resolution
+	// and logging. An identity it records with handshake.WithIdentity is
+	// used instead of checking the request against Auth.
+	Handshake []handshake.Middleware
+
+	// Approver, when set, enables POST /api/documents/{id}/publish: each
+	// snapshot is published only once it approves, such as a
+	// publish.Webhook calling an external review service.
+	Approver publish.Approver
+
+	// IDs governs the IDs POST /api/documents generates from titles and
+	// reserves ID prefixes, such as "admin-", for documents only admins may
+	// create.
+	IDs slug.Policy
+
+	// Protocols adds WebSocket subprotocols, such as a bridge for Yjs
+	// clients, to the built-in protocol.Names; an entry replaces a
+	// built-in of the same name.
+	Protocols map[string]protocol.Codec
+
+	// Store, when set, keeps documents across restarts: each is loaded on
+	// first use, and saved within FlushInterval of a change and at
+	// shutdown. FlushInterval defaults to hub.DefaultFlushInterval.
+	Store         document.Store
+	FlushInterval time.Duration
+
+	// Compaction bounds each document's operation history: after a number
+	// of operations or on an interval, a snapshot is saved to Store and all
+	// but the newest operations are dropped. Clients resyncing from a
+	// dropped version receive the full content. The zero value keeps the
+	// last 1000 versions.
+	Compaction hub.CompactionPolicy
+
+	// IdleTTL evicts documents that have gone this long without connected
+	// clients or changes, saving them to Store first; without a Store they
+	// are lost. Zero keeps documents loaded until memory pressure.
+	IdleTTL time.Duration
+
+	// Clock drives modification times, tickers and eviction; nil uses the
+	// wall clock. Tests pass a clock.Fake.
+	Clock clock.Clock
+
+	// WrapConn, when set, wraps each WebSocket connection before the hub
+	// uses it. Tests pass hub.NewFaultyConn to check that clients recover
+	// from dropped, delayed, duplicated and reordered messages.
+	WrapConn func(hub.Conn) hub.Conn
+
+	// Broker, when set, shares applied text operations with other server
+	// instances, such as through a hub.RedisBroker, so a document's
+	// clients can connect to any of them. Instances should share a Store.
+	Broker hub.Broker
+
+	// StandbyOf, when set, runs the server as a hot standby: it follows the
+	// replication stream at this URL, a primary's
+	// /admin/replication/stream authorized with StandbyToken, the
+	// primary's AdminToken, keeping copies of the primary's documents. It
+	// refuses clients and edits until promoted through
+	// /admin/replication/promote. A standby needs its own Store, if any.
+	StandbyOf    string
+	StandbyToken string
+
+	// Compression is the hub's policy, set with Hub.SetCompression like
+	// its other settings: it negotiates permessage-deflate with WebSocket
+	// clients that offer it and compresses messages to them of at least
+	// its threshold, such as full document contents; the zero value
+	// compresses nothing.
+	Compression hub.CompressionPolicy
+
+	// Metrics serves the hub's metrics at /metrics for Prometheus to
+	// scrape: clients per document, operations applied and refused,
+	// broadcast fan-out latency, document sizes and clients dropped for
+	// full send buffers.
+	Metrics bool
 }
 
 // Server represents the HTTP server and its dependencies.
 type Server struct {
-	config     Config
-	hub        *hub.Hub
-	httpServer *http.Server
-	mux        *http.ServeMux
+	config      Config
+	hub         *hub.Hub
+	httpServer  *http.Server
+	mux         *http.ServeMux
+	attachments *attachments.LocalStorage
+	pages       *pageCache
+	primary     *replication.Primary // Streams changes to standbys, with an admin token
+	standby     *replication.Standby // Follows a primary, with StandbyOf
+	metrics     *metrics.Registry    // Served at /metrics, with Metrics
+	stop        chan struct{}
 }
 
 // New creates and initializes a new Server instance.
 func New(cfg Config) *Server {
 	h := hub.NewHub()
+	if cfg.Clock != nil {
+		h.SetClock(cfg.Clock)
+	}
+	for _, sink := range cfg.EventSinks {
+		h.AddEventSink(sink)
+	}
+	pages := newPageCache()
+	h.AddEventSink(pages)
+	for prefix, sch := range cfg.Schemas {
+		h.SetSchema(prefix, sch)
+	}
+	if cfg.LatencyThreshold > 0 {
+		h.SetLatencyThreshold(cfg.LatencyThreshold)
+	}
+	if cfg.CoalesceWindow > 0 {
+		h.SetCoalesceWindow(cfg.CoalesceWindow)
+	}
+	h.SetAbusePolicy(cfg.Abuse)
+	h.SetRateLimit(cfg.RateLimit)
+	if cfg.Store != nil {
+		h.SetStore(cfg.Store, cfg.FlushInterval)
+	}
+	h.SetCompaction(cfg.Compaction)
+	h.SetIdleTTL(cfg.IdleTTL)
+	if err := h.SetCompression(cfg.Compression); err != nil {
+		log.Printf("compression disabled: %v", err)
+	}
+	if cfg.Broker != nil {
+		if err := h.SetBroker(cfg.Broker); err != nil {
+			log.Printf("running without a cluster: %v", err)
+		}
+	}
+	if cfg.MemoryHighWatermark > 0 || cfg.MemoryCriticalWatermark > 0 {
+		h.SetMemoryController(pressure.NewController(pressure.Config{
+			High:     cfg.MemoryHighWatermark,
+			Critical: cfg.MemoryCriticalWatermark,
+		}))
+	}
 
 	if cfg.AllowedOrigins != "" {
 		setAllowedOrigins(cfg.AllowedOrigins)
@@ -38,6 +226,25 @@ func New(cfg Config) *Server {
 		config: cfg,
 		hub:    h,
 		mux:    http.NewServeMux(),
+		pages:  pages,
+		stop:   make(chan struct{}),
+	}
+	if cfg.AdminToken != "" {
+		s.primary = replication.NewPrimary(h)
+		h.AddEventSink(s.primary)
+	}
+	if cfg.StandbyOf != "" {
+		s.standby = replication.NewStandby(h, cfg.StandbyOf, cfg.StandbyToken)
+	}
+	if cfg.Metrics {
+		s.metrics = metrics.NewRegistry()
+		h.RegisterMetrics(s.metrics)
+	}
+
+	if cfg.AttachmentDir != "" {
+		if err := s.enableAttachments(); err != nil {
+			log.Printf("attachments disabled: %v", err)
+		}
 	}
 
 	s.registerRoutes()
@@ -58,6 +265,16 @@ func (s *Server) Run() error {
 	// Start hub in background
 	go s.hub.Run()
 
+	if s.attachments != nil {
+		go s.collectAttachments()
+	}
+	if s.standby != nil {
+		go s.standby.Run()
+	}
+	if s.config.MemoryHighWatermark > 0 || s.config.MemoryCriticalWatermark > 0 {
+		go s.watchMemory()
+	}
+
 	if s.config.LogEnabled {
 		log.Println("hub started successfully")
 		log.Printf("server starting on http://localhost%s", s.config.Port)
@@ -73,8 +290,24 @@ func (s *Server) Run() error {
 	return nil
 }
 
+// Handler returns the server's HTTP handler, for serving it from a test
+// server instead of Run. The caller must run Hub().Run itself.
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Hub returns the server's hub.
+func (s *Server) Hub() *hub.Hub {
+	return s.hub
+}
+
 // Shutdown gracefully stops the server and hub.
 func (s *Server) Shutdown() error {
+	close(s.stop)
+	if s.standby != nil {
+		s.standby.Stop()
+	}
+
 	// Shutdown hub first to stop accepting new messages
 	s.hub.Shutdown()
 
@@ -89,7 +322,82 @@ func (s *Server) Shutdown() error {
 func (s *Server) registerRoutes() {
 	s.mux.HandleFunc("/", s.handleRoot)
 	s.mux.HandleFunc("/doc/", s.handleDoc)
-	s.mux.HandleFunc("/ws/", s.handleWebSocket)
+	s.mux.HandleFunc("/d/", s.handlePage)
+	s.mux.Handle("/ws/", handshake.Chain(http.HandlerFunc(s.handleWebSocket), s.config.Handshake...))
+	s.mux.Handle("/mux", handshake.Chain(http.HandlerFunc(s.handleMux), s.config.Handshake...))
+	s.mux.HandleFunc("/api/documents", s.handleDocuments)
+	s.mux.HandleFunc("/api/documents/", s.handleDocumentAPI)
+	s.mux.HandleFunc("/api/conformance", s.handleConformance)
 	s.mux.Handle("/static/", http.StripPrefix("/static/",
 		http.FileServer(http.Dir(s.config.StaticDir))))
+	if s.attachments != nil {
+		s.mux.Handle("/attachments/", s.attachments)
+	}
+	if s.metrics != nil {
+		s.mux.Handle("/metrics", s.metrics)
+	}
+	if s.config.AdminToken != "" {
+		s.registerAdminRoutes()
+	}
+}
+
+// enableAttachments sets up local attachment storage and hands the manager
+// to the hub.
+func (s *Server) enableAttachments() error {
+	secret := []byte(s.config.AttachmentSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("failed to generate attachment secret: %w", err)
+		}
+	}
+
+	publicURL := s.config.PublicURL
+	if publicURL == "" {
+		publicURL = "http://localhost" + s.config.Port
+	}
+
+	storage, err := attachments.NewLocalStorage(s.config.AttachmentDir,
+		publicURL+"/attachments/", secret, maxAttachmentSize)
+	if err != nil {
+		return err
+	}
+
+	s.attachments = storage
+	s.hub.SetAttachments(attachments.NewManager(storage, maxAttachmentSize))
+	return nil
+}
+
+// collectAttachments periodically removes attachments that documents no
+// longer reference, until the server shuts down.
+func (s *Server) collectAttachments() {
+	ticker := s.hub.Clock().NewTicker(attachmentGCPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C():
+			if n := s.hub.CollectAttachments(); n > 0 && s.config.LogEnabled {
+				log.Printf("removed %d unreferenced attachments", n)
+			}
+		}
+	}
+}
+
+// watchMemory periodically samples memory pressure so the hub can compact
+// history and evict idle documents, until the server shuts down.
+func (s *Server) watchMemory() {
+	ticker := s.hub.Clock().NewTicker(memoryCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C():
+			s.hub.CheckMemory()
+		}
+	}
 }
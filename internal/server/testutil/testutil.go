@@ -21,7 +21,7 @@ func MustConnect(t *testing.T, url string) *websocket.Conn {
 }
 
 // ReadNextContent reads the next content message from the connection,
-// automatically skipping over any user_count system messages.
+// automatically skipping over welcome, sync and user_count system messages.
 func ReadNextContent(t *testing.T, conn *websocket.Conn) string {
 	t.Helper()
 	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
@@ -34,7 +34,7 @@ func ReadNextContent(t *testing.T, conn *websocket.Conn) string {
 
 		var msg hub.Message
 		if err := json.Unmarshal(msgBytes, &msg); err == nil {
-			if msg.Type == "user_count" {
+			if isSystemMessage(msg.Type) {
 				continue
 			}
 			if msg.Type == "content" {
@@ -43,16 +43,28 @@ func ReadNextContent(t *testing.T, conn *websocket.Conn) string {
 			return string(msgBytes)
 		}
 
-		// Legacy format - check for batched messages
+		// Legacy format - check for batched messages, which may mix in
+		// JSON user counts queued alongside
 		parts := strings.Split(string(msgBytes), "\n")
 		for _, part := range parts {
-			if !strings.HasPrefix(part, "USER_COUNT:") {
-				return part
+			if strings.HasPrefix(part, "USER_COUNT:") {
+				continue
+			}
+			var msg hub.Message
+			if json.Unmarshal([]byte(part), &msg) == nil && isSystemMessage(msg.Type) {
+				continue
 			}
+			return part
 		}
 	}
 }
 
+// isSystemMessage reports whether the hub sends messages of this type on
+// its own rather than relaying them.
+func isSystemMessage(t hub.MessageType) bool {
+	return t == hub.MsgTypeUserCount || t == hub.MsgTypeWelcome || t == hub.MsgTypeSync
+}
+
 // SendMessage sends a text message and fails the test on error.
 func SendMessage(t *testing.T, conn *websocket.Conn, message string) {
 	t.Helper()
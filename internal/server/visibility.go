@@ -0,0 +1,84 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/handshake"
+)
+
+// access is what a request may do with a document.
+type access int
+
+const (
+	accessNone   access = iota // May not open the document
+	accessRead                 // May watch but not edit
+	accessWrite                // May read and edit
+	accessFences               // May also edit fenced ranges and delete checkpoints
+)
+
+// documentAccess resolves what r may do with a document from its
+// visibility. Admin-authorized requests allow everything. A user given a
+// role on the document, or owning it, has that role whatever the
+// visibility, so a viewer may only read even an open document; only the
+// owner and maintainers may edit fenced ranges. A member of the team that
+// owns the document has their team role the same way; a user with both
+// roles gets the greater. The user is
+// the identity recorded on r with withIdentity. Otherwise open documents
+// allow everything. The token passed as ?token= may be the link token, which
+// opens link and public documents for editing, or an access token from a
+// redeemed invite, which grants the invite's role whatever the visibility. Anyone
+// may read a public document; private documents admit only admins, owners
+// and invited collaborators.
+func (s *Server) documentAccess(r *http.Request, documentID string) access {
+	doc := s.hub.GetDocument(documentID)
+	if s.isAdmin(r) {
+		return accessFences
+	}
+	if doc == nil {
+		return accessWrite
+	}
+	if id := handshake.Identity(r.Context()); id != nil {
+		if doc.MayEditFences(id.Subject) {
+			return accessFences
+		}
+		role, ok := doc.RoleFor(id.Subject)
+		teamRole, inTeam := s.hub.TeamRole(doc.Team(), id.Subject)
+		switch {
+		case ok && inTeam:
+			return max(roleAccess(role), roleAccess(teamRole))
+		case ok:
+			return roleAccess(role)
+		case inTeam:
+			return roleAccess(teamRole)
+		}
+	}
+	visibility, linkToken := doc.Visibility()
+	if visibility == document.VisibilityOpen {
+		return accessWrite
+	}
+
+	token := r.URL.Query().Get("token")
+	if token != "" && visibility != document.VisibilityPrivate && subtle.ConstantTimeCompare([]byte(token), []byte(linkToken)) == 1 {
+		return accessWrite
+	}
+	if grant, ok := doc.GrantFor(token); ok {
+		return roleAccess(grant.Role)
+	}
+	if visibility == document.VisibilityPublic {
+		return accessRead
+	}
+	return accessNone
+}
+
+// roleAccess is what a collaborator with role may do.
+func roleAccess(role document.Role) access {
+	switch role {
+	case document.RoleMaintainer:
+		return accessFences
+	case document.RoleEditor:
+		return accessWrite
+	}
+	return accessRead
+}
@@ -0,0 +1,96 @@
+package slo
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are latency bucket upper bounds in milliseconds, dense
+// around the 50ms operation SLO.
+var DefaultBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// Histogram is a fixed-bucket latency histogram safe for concurrent use.
+type Histogram struct {
+	buckets []float64 // upper bounds in milliseconds
+	counts  []uint64  // len(buckets)+1, the last is the +Inf bucket
+	count   uint64
+	sum     float64
+	max     float64
+	mu      sync.Mutex
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds (ms).
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe records one latency sample.
+func (h *Histogram) Observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := 0
+	for i < len(h.buckets) && ms > h.buckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += ms
+	if ms > h.max {
+		h.max = ms
+	}
+}
+
+// Snapshot is a point-in-time summary of a histogram.
+type Snapshot struct {
+	Count   uint64    `json:"count"`
+	MeanMS  float64   `json:"mean_ms"`
+	MaxMS   float64   `json:"max_ms"`
+	P50MS   float64   `json:"p50_ms"`
+	P99MS   float64   `json:"p99_ms"`
+	Buckets []float64 `json:"buckets_ms"`
+	Counts  []uint64  `json:"counts"` // per bucket, the last entry is +Inf
+}
+
+// Snapshot summarizes the histogram.
+func (h *Histogram) Snapshot() Snapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := Snapshot{
+		Count:   h.count,
+		MaxMS:   h.max,
+		Buckets: append([]float64(nil), h.buckets...),
+		Counts:  append([]uint64(nil), h.counts...),
+	}
+	if h.count > 0 {
+		s.MeanMS = h.sum / float64(h.count)
+		s.P50MS = h.quantile(0.50)
+		s.P99MS = h.quantile(0.99)
+	}
+	return s
+}
+
+// quantile estimates the q-quantile as the upper bound of the bucket that
+// contains it; samples in the +Inf bucket report the observed maximum.
+// Callers must hold h.mu.
+func (h *Histogram) quantile(q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if i < len(h.buckets) {
+				return math.Min(h.buckets[i], h.max)
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
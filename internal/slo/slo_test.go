@@ -0,0 +1,97 @@
+package slo
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHistogramQuantiles verifies percentile estimates from bucket counts.
+func TestHistogramQuantiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		wantP50 float64
+		wantP99 float64
+		wantMax float64
+	}{
+		{
+			name:    "single fast sample",
+			samples: []time.Duration{300 * time.Microsecond},
+			wantP50: 0.3,
+			wantP99: 0.3,
+			wantMax: 0.3,
+		},
+		{
+			name:    "tail in higher bucket",
+			samples: append(repeat(3*time.Millisecond, 98), 40*time.Millisecond, 80*time.Millisecond),
+			wantP50: 5,
+			wantP99: 50,
+			wantMax: 80,
+		},
+		{
+			name:    "overflow bucket reports max",
+			samples: []time.Duration{2 * time.Second},
+			wantP50: 2000,
+			wantP99: 2000,
+			wantMax: 2000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistogram(DefaultBuckets)
+			for _, d := range tt.samples {
+				h.Observe(d)
+			}
+			s := h.Snapshot()
+			if s.Count != uint64(len(tt.samples)) {
+				t.Errorf("Count = %d, want %d", s.Count, len(tt.samples))
+			}
+			if s.P50MS != tt.wantP50 || s.P99MS != tt.wantP99 || s.MaxMS != tt.wantMax {
+				t.Errorf("p50/p99/max = %v/%v/%v, want %v/%v/%v",
+					s.P50MS, s.P99MS, s.MaxMS, tt.wantP50, tt.wantP99, tt.wantMax)
+			}
+		})
+	}
+}
+
+// TestTrackerAlerts verifies alerts fire above the threshold at most once
+// per document per cooldown.
+func TestTrackerAlerts(t *testing.T) {
+	var alerts []Alert
+	tr := NewTracker(10*time.Millisecond, func(a Alert) { alerts = append(alerts, a) })
+
+	tr.Observe("a", 5*time.Millisecond)
+	tr.Observe("a", 20*time.Millisecond)
+	tr.Observe("a", 30*time.Millisecond)
+	tr.Observe("b", 15*time.Millisecond)
+
+	if len(alerts) != 2 {
+		t.Fatalf("got %d alerts, want 2: %+v", len(alerts), alerts)
+	}
+	if alerts[0].DocumentID != "a" || alerts[0].Latency != 20*time.Millisecond {
+		t.Errorf("first alert = %+v", alerts[0])
+	}
+	if alerts[1].DocumentID != "b" {
+		t.Errorf("second alert = %+v", alerts[1])
+	}
+
+	r := tr.Report()
+	if r.Overall.Count != 4 || r.Documents["a"].Count != 3 || r.Documents["b"].Count != 1 {
+		t.Errorf("report counts = %d/%d/%d, want 4/3/1",
+			r.Overall.Count, r.Documents["a"].Count, r.Documents["b"].Count)
+	}
+
+	tr.Forget("a")
+	if _, ok := tr.Report().Documents["a"]; ok {
+		t.Error("document a still reported after Forget")
+	}
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = d
+	}
+	return out
+}
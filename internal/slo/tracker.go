@@ -0,0 +1,113 @@
+package slo
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultThreshold is the per-operation latency SLO.
+	DefaultThreshold = 50 * time.Millisecond
+	// alertCooldown limits alerts to one per document per interval.
+	alertCooldown = time.Minute
+)
+
+// Alert describes an operation that exceeded the latency threshold.
+type Alert struct {
+	DocumentID string
+	Latency    time.Duration
+	Threshold  time.Duration
+}
+
+// Tracker records receive-to-broadcast latency per document and raises
+// alerts when an operation exceeds the configured threshold.
+type Tracker struct {
+	threshold time.Duration
+	onAlert   func(Alert)
+	overall   *Histogram
+	docs      map[string]*Histogram
+	lastAlert map[string]time.Time
+	mu        sync.Mutex
+}
+
+// NewTracker creates a tracker. onAlert may be nil; it is called at most once
+// per document per minute, synchronously from Observe.
+func NewTracker(threshold time.Duration, onAlert func(Alert)) *Tracker {
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	return &Tracker{
+		threshold: threshold,
+		onAlert:   onAlert,
+		overall:   NewHistogram(DefaultBuckets),
+		docs:      make(map[string]*Histogram),
+		lastAlert: make(map[string]time.Time),
+	}
+}
+
+// Threshold returns the configured alert threshold.
+func (t *Tracker) Threshold() time.Duration {
+	return t.threshold
+}
+
+// Observe records the latency of one operation on a document.
+func (t *Tracker) Observe(documentID string, latency time.Duration) {
+	t.mu.Lock()
+	hist, ok := t.docs[documentID]
+	if !ok {
+		hist = NewHistogram(DefaultBuckets)
+		t.docs[documentID] = hist
+	}
+
+	alert := false
+	if latency > t.threshold {
+		now := time.Now()
+		if now.Sub(t.lastAlert[documentID]) >= alertCooldown {
+			t.lastAlert[documentID] = now
+			alert = true
+		}
+	}
+	t.mu.Unlock()
+
+	hist.Observe(latency)
+	t.overall.Observe(latency)
+
+	if alert && t.onAlert != nil {
+		t.onAlert(Alert{DocumentID: documentID, Latency: latency, Threshold: t.threshold})
+	}
+}
+
+// Report is the latency summary across all documents.
+type Report struct {
+	ThresholdMS float64             `json:"threshold_ms"`
+	Overall     Snapshot            `json:"overall"`
+	Documents   map[string]Snapshot `json:"documents"`
+}
+
+// Report summarizes latency overall and per document.
+func (t *Tracker) Report() Report {
+	t.mu.Lock()
+	docs := make(map[string]*Histogram, len(t.docs))
+	for id, h := range t.docs {
+		docs[id] = h
+	}
+	t.mu.Unlock()
+
+	r := Report{
+		ThresholdMS: float64(t.threshold) / float64(time.Millisecond),
+		Overall:     t.overall.Snapshot(),
+		Documents:   make(map[string]Snapshot, len(docs)),
+	}
+	for id, h := range docs {
+		r.Documents[id] = h.Snapshot()
+	}
+	return r
+}
+
+// Forget drops the histogram for a document, e.g. once it is unloaded.
+func (t *Tracker) Forget(documentID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.docs, documentID)
+	delete(t.lastAlert, documentID)
+}
@@ -0,0 +1,187 @@
+// Package slug generates human-readable document IDs, such as
+// "quarterly-planning" from a title or "calm-river-42" at random, that are
+// safe to share in links: IDs containing denied words are never generated,
+// and reserved prefixes such as "admin-" are kept for the system.
+package slug
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	maxLength   = 60 // Longest slug made from a title, before any suffix
+	maxAttempts = 8  // Candidates tried before giving up
+	suffixLen   = 4
+)
+
+// ErrExhausted is returned when every candidate ID was taken or refused.
+var ErrExhausted = errors.New("no free document ID found")
+
+// DefaultDenylist holds words no generated ID contains. Policy.Denylist
+// extends it.
+var DefaultDenylist = []string{
+	"arse", "ass", "asshole", "bastard", "bitch", "bollocks", "bullshit",
+	"cock", "cunt", "dick", "fag", "fuck", "fucker", "fucking",
+	"motherfucker", "piss", "prick", "pussy", "shit", "slut", "twat",
+	"wank", "whore",
+}
+
+// suffixAlphabet has no vowels, so random suffixes cannot spell words.
+const suffixAlphabet = "23456789bcdfghjkmnpqrstvwxz"
+
+// Word lists for random slugs, chosen to be harmless in any combination.
+var (
+	adjectives = []string{
+		"amber", "bold", "brave", "bright", "brisk", "calm", "clever", "cozy",
+		"crisp", "eager", "fancy", "gentle", "golden", "happy", "jolly", "keen",
+		"kind", "lively", "lucky", "mellow", "merry", "misty", "neat", "noble",
+		"proud", "quick", "quiet", "rapid", "shiny", "silver", "sunny", "swift",
+	}
+	nouns = []string{
+		"acorn", "badger", "beacon", "brook", "canyon", "cedar", "comet", "coral",
+		"falcon", "fern", "harbor", "heron", "island", "lantern", "maple", "meadow",
+		"meteor", "otter", "panda", "pebble", "pine", "planet", "raven", "reef",
+		"river", "sparrow", "summit", "thistle", "tiger", "valley", "willow", "zephyr",
+	}
+)
+
+// Policy decides which IDs may be generated and which users may create.
+// The zero Policy denies DefaultDenylist and reserves nothing.
+type Policy struct {
+	Denylist []string // Extra words no generated ID contains, matched per word
+	Reserved []string // ID prefixes only the system may create, e.g. "admin-"
+}
+
+// IsReserved reports whether id starts with a reserved prefix, ignoring case.
+func (p Policy) IsReserved(id string) bool {
+	id = strings.ToLower(id)
+	for _, prefix := range p.Reserved {
+		if prefix != "" && strings.HasPrefix(id, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Denied reports whether any word of id, split at hyphens and underscores,
+// is on the denylist. Digits standing in for letters, as in "sh1t", are
+// also read as letters.
+func (p Policy) Denied(id string) bool {
+	for _, word := range words(id) {
+		if p.deniedWord(word) || p.deniedWord(unleet(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) deniedWord(word string) bool {
+	for _, list := range [][]string{DefaultDenylist, p.Denylist} {
+		for _, denied := range list {
+			if strings.EqualFold(word, denied) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allowed reports whether id may be generated for a user.
+func (p Policy) Allowed(id string) bool {
+	return id != "" && !p.Denied(id) && !p.IsReserved(id)
+}
+
+// Generate returns a new document ID. With a title the ID is made from it,
+// with denied words left out; otherwise, or if nothing usable remains, it
+// is two random words and a number. claim is called with each candidate
+// and reports whether it was free and is now taken, so the caller creates
+// the document atomically. Taken candidates are retried with a random
+// suffix, up to a limit.
+func (p Policy) Generate(title string, claim func(id string) bool) (string, error) {
+	base := p.FromTitle(title)
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		var id string
+		switch {
+		case base == "":
+			id = Random()
+		case attempt == 0:
+			id = base
+		default:
+			id = base + "-" + suffix()
+		}
+		if p.Allowed(id) && claim(id) {
+			return id, nil
+		}
+	}
+	return "", ErrExhausted
+}
+
+// FromTitle turns a title into a slug: lowercase ASCII letters and digits
+// joined by hyphens, with accents removed and denied words left out. A
+// slug that would start with a reserved prefix gets "doc-" in front. It
+// returns "" if nothing usable remains.
+func (p Policy) FromTitle(title string) string {
+	var kept []string
+	length := 0
+	for _, word := range strings.FieldsFunc(fold(title), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	}) {
+		if p.Denied(word) {
+			continue
+		}
+		if length+len(word) > maxLength {
+			break
+		}
+		kept = append(kept, word)
+		length += len(word) + 1
+	}
+	s := strings.Join(kept, "-")
+	if s != "" && p.IsReserved(s) {
+		s = "doc-" + s
+	}
+	return s
+}
+
+// Random returns two random words and a number, such as "calm-river-42".
+func Random() string {
+	return adjectives[rand.IntN(len(adjectives))] + "-" + nouns[rand.IntN(len(nouns))] + "-" +
+		strconv.Itoa(10+rand.IntN(90))
+}
+
+// fold lowercases s and strips accents, so "Café Résumé" reads as
+// "cafe resume".
+func fold(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// words splits an ID into the words checked against the denylist.
+func words(id string) []string {
+	return strings.FieldsFunc(strings.ToLower(id), func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+}
+
+// unleet reads digits used as letters, as in "5h1t".
+func unleet(word string) string {
+	return strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b").Replace(word)
+}
+
+func suffix() string {
+	b := make([]byte, suffixLen)
+	for i := range b {
+		b[i] = suffixAlphabet[rand.IntN(len(suffixAlphabet))]
+	}
+	return string(b)
+}
@@ -0,0 +1,91 @@
+package slug
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFromTitle(t *testing.T) {
+	p := Policy{Denylist: []string{"secret"}, Reserved: []string{"admin-"}}
+	tests := []struct {
+		title, want string
+	}{
+		{"Quarterly Planning", "quarterly-planning"},
+		{"  Café Résumé, v2!  ", "cafe-resume-v2"},
+		{"Shit happens", "happens"},
+		{"Our SECRET plan", "our-plan"},
+		{"Admin notes", "doc-admin-notes"},
+		{"Administration", "administration"},
+		{"!!!", ""},
+		{strings.Repeat("word ", 20), strings.TrimSuffix(strings.Repeat("word-", 12), "-")},
+	}
+	for _, tt := range tests {
+		if got := p.FromTitle(tt.title); got != tt.want {
+			t.Errorf("FromTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p := Policy{Reserved: []string{"admin-", "System-"}}
+	tests := []struct {
+		id               string
+		denied, reserved bool
+	}{
+		{"team-notes", false, false},
+		{"bull-shit", true, false},
+		{"SH1T_list", true, false},
+		{"shitake", false, false},
+		{"admin-panel", false, true},
+		{"ADMIN-panel", false, true},
+		{"system-status", false, true},
+		{"admin", false, false},
+	}
+	for _, tt := range tests {
+		if got := p.Denied(tt.id); got != tt.denied {
+			t.Errorf("Denied(%q) = %v, want %v", tt.id, got, tt.denied)
+		}
+		if got := p.IsReserved(tt.id); got != tt.reserved {
+			t.Errorf("IsReserved(%q) = %v, want %v", tt.id, got, tt.reserved)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	var p Policy
+	taken := map[string]bool{"meeting-notes": true}
+	claim := func(id string) bool {
+		if taken[id] {
+			return false
+		}
+		taken[id] = true
+		return true
+	}
+
+	id, err := p.Generate("Meeting Notes", claim)
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if !regexp.MustCompile(`^meeting-notes-[2-9b-z]{4}$`).MatchString(id) {
+		t.Errorf("Generate() = %q, want meeting-notes with a suffix", id)
+	}
+
+	id, err = p.Generate("", claim)
+	if err != nil {
+		t.Fatalf("Generate() error: %v", err)
+	}
+	if !regexp.MustCompile(`^[a-z]+-[a-z]+-[0-9]{2}$`).MatchString(id) {
+		t.Errorf("Generate() = %q, want a random slug", id)
+	}
+
+	attempts := 0
+	_, err = p.Generate("Meeting Notes", func(string) bool { attempts++; return false })
+	if !errors.Is(err, ErrExhausted) {
+		t.Errorf("Generate() error = %v, want ErrExhausted", err)
+	}
+	if attempts != maxAttempts {
+		t.Errorf("claim called %d times, want %d", attempts, maxAttempts)
+	}
+}
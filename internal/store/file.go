@@ -0,0 +1,298 @@
+// Package store provides document.Store backends: File keeps one JSON file
+// per document in a directory, and SQLite keeps documents in a database
+// file. Both are also a document.OpLog and a document.TeamStore.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"collaborative-docs/internal/document"
+)
+
+const (
+	fileExt = ".json"
+	teamDir = "teams" // Subdirectory teams are kept in
+)
+
+// File stores each document as a JSON file in a directory. Writes go to a
+// temporary file renamed into place, so a crash never leaves a document
+// half written.
+type File struct {
+	dir string
+}
+
+// NewFile creates a store rooted at dir, creating the directory if needed.
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	return &File{dir: dir}, nil
+}
+
+// Load implements document.Store.
+func (f *File) Load(id string) (*document.Snapshot, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, document.ErrNotStored
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s document.Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("document %s: %w", id, err)
+	}
+	return &s, nil
+}
+
+// Save implements document.Store.
+func (f *File) Save(id string, s *document.Snapshot) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return f.write(path, data)
+}
+
+// write replaces the file at path with data through a temporary file.
+func (f *File) write(path string, data []byte) error {
+	tmp, err := os.CreateTemp(f.dir, ".save-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// List implements document.Store.
+func (f *File) List() ([]string, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), fileExt); ok && e.Type().IsRegular() && validID(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Delete implements document.Store.
+func (f *File) Delete(id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{path, f.basePath(path), f.logPath(path)} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartLog implements document.OpLog. The base is kept next to the
+// document as id.base.json and the entries as lines of JSON in id.log.
+func (f *File) StartLog(id string, base *document.Snapshot) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(base)
+	if err != nil {
+		return err
+	}
+	// Entries of the old log left behind by a failure below are skipped
+	// or still apply on top of the old base, so the log never goes bad.
+	if err := os.Truncate(f.logPath(path), 0); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return f.write(f.basePath(path), data)
+}
+
+// AppendLog implements document.OpLog.
+func (f *File) AppendLog(id string, e *document.LogEntry) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.logPath(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadLog implements document.OpLog. A torn last line, left by a crash
+// mid-append, ends the log.
+func (f *File) LoadLog(id string) (*document.Snapshot, []*document.LogEntry, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(f.basePath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, document.ErrNotStored
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var base document.Snapshot
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil, nil, fmt.Errorf("log base of document %s: %w", id, err)
+	}
+	data, err = os.ReadFile(f.logPath(path))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+	var entries []*document.LogEntry
+	for line := range strings.Lines(string(data)) {
+		var e document.LogEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			break
+		}
+		entries = append(entries, &e)
+	}
+	return &base, entries, nil
+}
+
+// path returns the file a document is kept in.
+func (f *File) path(id string) (string, error) {
+	if !validID(id) {
+		return "", fmt.Errorf("invalid document ID: %q", id)
+	}
+	return filepath.Join(f.dir, id+fileExt), nil
+}
+
+// LoadTeams implements document.TeamStore. Teams are kept as JSON files
+// in the teams subdirectory.
+func (f *File) LoadTeams() ([]*document.Team, error) {
+	entries, err := os.ReadDir(filepath.Join(f.dir, teamDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var teams []*document.Team
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), fileExt)
+		if !ok || !e.Type().IsRegular() || !validID(id) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.dir, teamDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var t document.Team
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, fmt.Errorf("team %s: %w", id, err)
+		}
+		teams = append(teams, &t)
+	}
+	return teams, nil
+}
+
+// SaveTeam implements document.TeamStore.
+func (f *File) SaveTeam(t *document.Team) error {
+	path, err := f.teamPath(t.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return f.write(path, data)
+}
+
+// DeleteTeam implements document.TeamStore.
+func (f *File) DeleteTeam(id string) error {
+	path, err := f.teamPath(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// teamPath returns the file a team is kept in.
+func (f *File) teamPath(id string) (string, error) {
+	if !validID(id) {
+		return "", fmt.Errorf("invalid team ID: %q", id)
+	}
+	return filepath.Join(f.dir, teamDir, id+fileExt), nil
+}
+
+// basePath returns the file the base of a document's log is kept in,
+// given the document's own.
+func (f *File) basePath(path string) string {
+	return strings.TrimSuffix(path, fileExt) + ".base" + fileExt
+}
+
+// logPath returns the file a document's log entries are kept in, given
+// the document's own.
+func (f *File) logPath(path string) string {
+	return strings.TrimSuffix(path, fileExt) + ".log"
+}
+
+// validID reports whether id is safe to use as a file name: the server
+// only accepts IDs of letters, digits, hyphens and underscores, and
+// nothing else may reach the directory.
+func validID(id string) bool {
+	if id == "" || len(id) > 100 {
+		return false
+	}
+	for _, r := range id {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
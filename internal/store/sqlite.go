@@ -0,0 +1,229 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"collaborative-docs/internal/document"
+
+	_ "modernc.org/sqlite" // Registers the "sqlite" driver
+)
+
+// SQLite stores documents as JSON rows in an SQLite database. The driver
+// is pure Go, so it works in binaries built with CGO_ENABLED=0.
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens or creates the database at path.
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS documents (
+		id TEXT PRIMARY KEY,
+		snapshot BLOB NOT NULL,
+		version INTEGER NOT NULL,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create documents table: %w", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS oplog_bases (
+		id TEXT PRIMARY KEY,
+		snapshot BLOB NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS oplog (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		id TEXT NOT NULL,
+		entry BLOB NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS oplog_id ON oplog (id, seq)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create oplog tables: %w", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS teams (
+		id TEXT PRIMARY KEY,
+		team BLOB NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create teams table: %w", err)
+	}
+	return &SQLite{db: db}, nil
+}
+
+// Close closes the database.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// Load implements document.Store.
+func (s *SQLite) Load(id string) (*document.Snapshot, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT snapshot FROM documents WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, document.ErrNotStored
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap document.Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("document %s: %w", id, err)
+	}
+	return &snap, nil
+}
+
+// Save implements document.Store.
+func (s *SQLite) Save(id string, snap *document.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO documents (id, snapshot, version, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (id) DO UPDATE SET snapshot = excluded.snapshot,
+			version = excluded.version, updated_at = excluded.updated_at`,
+		id, data, snap.Version)
+	return err
+}
+
+// List implements document.Store.
+func (s *SQLite) List() ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM documents ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Delete implements document.Store.
+func (s *SQLite) Delete(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"documents", "oplog_bases", "oplog"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// StartLog implements document.OpLog.
+func (s *SQLite) StartLog(id string, base *document.Snapshot) error {
+	data, err := json.Marshal(base)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM oplog WHERE id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO oplog_bases (id, snapshot) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET snapshot = excluded.snapshot`, id, data); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AppendLog implements document.OpLog.
+func (s *SQLite) AppendLog(id string, e *document.LogEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO oplog (id, entry) VALUES (?, ?)`, id, data)
+	return err
+}
+
+// LoadLog implements document.OpLog.
+func (s *SQLite) LoadLog(id string) (*document.Snapshot, []*document.LogEntry, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT snapshot FROM oplog_bases WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, document.ErrNotStored
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var base document.Snapshot
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil, nil, fmt.Errorf("log base of document %s: %w", id, err)
+	}
+	rows, err := s.db.Query(`SELECT entry FROM oplog WHERE id = ? ORDER BY seq`, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var entries []*document.LogEntry
+	for rows.Next() {
+		if err := rows.Scan(&data); err != nil {
+			return nil, nil, err
+		}
+		var e document.LogEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			break
+		}
+		entries = append(entries, &e)
+	}
+	return &base, entries, rows.Err()
+}
+
+// LoadTeams implements document.TeamStore.
+func (s *SQLite) LoadTeams() ([]*document.Team, error) {
+	rows, err := s.db.Query(`SELECT id, team FROM teams ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var teams []*document.Team
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var t document.Team
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, fmt.Errorf("team %s: %w", id, err)
+		}
+		teams = append(teams, &t)
+	}
+	return teams, rows.Err()
+}
+
+// SaveTeam implements document.TeamStore.
+func (s *SQLite) SaveTeam(t *document.Team) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO teams (id, team) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET team = excluded.team`, t.ID, data)
+	return err
+}
+
+// DeleteTeam implements document.TeamStore.
+func (s *SQLite) DeleteTeam(id string) error {
+	_, err := s.db.Exec(`DELETE FROM teams WHERE id = ?`, id)
+	return err
+}
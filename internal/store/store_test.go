@@ -0,0 +1,213 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"collaborative-docs/internal/document"
+	"collaborative-docs/internal/operations"
+)
+
+// TestStores runs the same checks against every store. The Docker image
+// runs it with CGO_ENABLED=0, as its binary is built, so a store that
+// needs cgo fails the image build rather than the server at runtime.
+func TestStores(t *testing.T) {
+	dir := t.TempDir()
+	file, err := NewFile(filepath.Join(dir, "docs"))
+	if err != nil {
+		t.Fatalf("NewFile() error: %v", err)
+	}
+	db, err := OpenSQLite(filepath.Join(dir, "docs.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error: %v", err)
+	}
+	defer db.Close()
+
+	for name, s := range map[string]logStore{"file": file, "sqlite": db} {
+		t.Run(name, func(t *testing.T) { testStore(t, s) })
+		t.Run(name+"/oplog", func(t *testing.T) { testOpLog(t, s) })
+		t.Run(name+"/teams", func(t *testing.T) { testTeams(t, s) })
+	}
+}
+
+type logStore interface {
+	document.Store
+	document.OpLog
+	document.TeamStore
+}
+
+func testStore(t *testing.T, s document.Store) {
+	if _, err := s.Load("notes"); !errors.Is(err, document.ErrNotStored) {
+		t.Fatalf("Load() of missing document error = %v, want ErrNotStored", err)
+	}
+
+	snap := &document.Snapshot{
+		Content:      "hello",
+		Version:      3,
+		LastModified: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Kind:         document.KindText,
+		Metadata:     map[string]string{"title": "Notes"},
+		Visibility:   document.VisibilityLink,
+		LinkToken:    "secret",
+		Grants:       map[string]document.Grant{"tok": {Name: "bob", Role: document.RoleViewer}},
+		Blobs:        []*document.Blob{{ID: "b1", ContentType: "image/png", Data: []byte{1, 2}}},
+	}
+	for _, id := range []string{"notes", "agenda"} {
+		if err := s.Save(id, snap); err != nil {
+			t.Fatalf("Save(%s) error: %v", id, err)
+		}
+	}
+	snap.Version = 4
+	if err := s.Save("notes", snap); err != nil {
+		t.Fatalf("Save() again error: %v", err)
+	}
+
+	got, err := s.Load("notes")
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !reflect.DeepEqual(got, snap) {
+		t.Errorf("Load() = %+v, want %+v", got, snap)
+	}
+	if ids, err := s.List(); err != nil || !reflect.DeepEqual(ids, []string{"agenda", "notes"}) {
+		t.Errorf("List() = %v, %v", ids, err)
+	}
+
+	if err := s.Delete("notes"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if err := s.Delete("notes"); err != nil {
+		t.Errorf("Delete() of missing document error: %v", err)
+	}
+	if _, err := s.Load("notes"); !errors.Is(err, document.ErrNotStored) {
+		t.Errorf("Load() after Delete error = %v, want ErrNotStored", err)
+	}
+	if ids, _ := s.List(); !reflect.DeepEqual(ids, []string{"agenda"}) {
+		t.Errorf("List() after Delete = %v", ids)
+	}
+}
+
+func testOpLog(t *testing.T, s logStore) {
+	if _, _, err := s.LoadLog("log"); !errors.Is(err, document.ErrNotStored) {
+		t.Fatalf("LoadLog() of missing log error = %v, want ErrNotStored", err)
+	}
+
+	base := &document.Snapshot{Content: "ab", Checksum: document.Checksum("ab"), Version: 2, Kind: document.KindText}
+	if err := s.StartLog("log", base); err != nil {
+		t.Fatalf("StartLog() error: %v", err)
+	}
+	entries := []*document.LogEntry{
+		{From: 2, To: 3, Ops: []*operations.Operation{operations.NewInsertOp(2, "c", 3)}, Checksum: document.Checksum("abc")},
+		{From: 3, To: 4, Ops: []*operations.Operation{operations.NewDeleteOp(0, "a", 4)}, Checksum: document.Checksum("bc")},
+	}
+	for _, e := range entries {
+		if err := s.AppendLog("log", e); err != nil {
+			t.Fatalf("AppendLog() error: %v", err)
+		}
+	}
+	gotBase, got, err := s.LoadLog("log")
+	if err != nil {
+		t.Fatalf("LoadLog() error: %v", err)
+	}
+	if !reflect.DeepEqual(gotBase, base) || !reflect.DeepEqual(got, entries) {
+		t.Errorf("LoadLog() = %+v, %+v, want %+v, %+v", gotBase, got, base, entries)
+	}
+	if ids, _ := s.List(); len(ids) != 1 || ids[0] != "agenda" {
+		t.Errorf("List() with a log = %v, want only stored documents", ids)
+	}
+
+	base = &document.Snapshot{Content: "bc", Version: 4}
+	if err := s.StartLog("log", base); err != nil {
+		t.Fatalf("StartLog() again error: %v", err)
+	}
+	if gotBase, got, err := s.LoadLog("log"); err != nil || gotBase.Version != 4 || len(got) != 0 {
+		t.Errorf("LoadLog() after restart = %+v, %v, %v, want empty log at version 4", gotBase, got, err)
+	}
+
+	if err := s.Delete("log"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, _, err := s.LoadLog("log"); !errors.Is(err, document.ErrNotStored) {
+		t.Errorf("LoadLog() after Delete error = %v, want ErrNotStored", err)
+	}
+}
+
+func testTeams(t *testing.T, s logStore) {
+	if teams, err := s.LoadTeams(); err != nil || len(teams) != 0 {
+		t.Fatalf("LoadTeams() of an empty store = %v, %v", teams, err)
+	}
+	writers := &document.Team{ID: "writers", Name: "Writers", Members: map[string]document.Role{"alice": document.RoleEditor}}
+	if err := s.SaveTeam(writers); err != nil {
+		t.Fatalf("SaveTeam() error: %v", err)
+	}
+	writers.Members["bob"] = document.RoleViewer
+	if err := s.SaveTeam(writers); err != nil {
+		t.Fatalf("SaveTeam() again error: %v", err)
+	}
+	if err := s.SaveTeam(&document.Team{ID: "legal"}); err != nil {
+		t.Fatalf("SaveTeam() error: %v", err)
+	}
+	teams, err := s.LoadTeams()
+	if err != nil {
+		t.Fatalf("LoadTeams() error: %v", err)
+	}
+	slices.SortFunc(teams, func(a, b *document.Team) int { return strings.Compare(a.ID, b.ID) })
+	if len(teams) != 2 || teams[0].ID != "legal" || !reflect.DeepEqual(teams[1], writers) {
+		t.Errorf("LoadTeams() = %+v, want legal and %+v", teams, writers)
+	}
+	if ids, _ := s.List(); slices.Contains(ids, "writers") || slices.Contains(ids, "teams") {
+		t.Errorf("List() = %v, want no teams", ids)
+	}
+
+	if err := s.DeleteTeam("legal"); err != nil {
+		t.Fatalf("DeleteTeam() error: %v", err)
+	}
+	if err := s.DeleteTeam("legal"); err != nil {
+		t.Errorf("DeleteTeam() of a missing team error: %v", err)
+	}
+	if teams, _ := s.LoadTeams(); len(teams) != 1 {
+		t.Errorf("LoadTeams() after DeleteTeam = %+v", teams)
+	}
+}
+
+func TestFileLogStopsAtTornEntry(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFile(dir)
+	if err != nil {
+		t.Fatalf("NewFile() error: %v", err)
+	}
+	if err := s.StartLog("notes", &document.Snapshot{}); err != nil {
+		t.Fatalf("StartLog() error: %v", err)
+	}
+	if err := s.AppendLog("notes", &document.LogEntry{From: 0, To: 1}); err != nil {
+		t.Fatalf("AppendLog() error: %v", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "notes.log"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"from":1,"to":`)
+	f.Close()
+
+	if _, entries, err := s.LoadLog("notes"); err != nil || len(entries) != 1 {
+		t.Errorf("LoadLog() = %v, %v, want the one whole entry", entries, err)
+	}
+}
+
+func TestFileRejectsPaths(t *testing.T) {
+	s, err := NewFile(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFile() error: %v", err)
+	}
+	for _, id := range []string{"../escape", "a/b", ""} {
+		if err := s.Save(id, &document.Snapshot{}); err == nil {
+			t.Errorf("Save(%q) succeeded", id)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Package textnorm keeps document text in one Unicode normalization form
+// and checks that edit positions fall between grapheme clusters, the
+// characters a reader sees, so no client can leave half an "é" or half a
+// flag in a document.
+package textnorm
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Form is a Unicode normalization form.
+type Form string
+
+const (
+	FormNone Form = ""    // Text is stored as written
+	FormNFC  Form = "nfc" // Canonical composition, "é" as one code point
+	FormNFD  Form = "nfd" // Canonical decomposition, "é" as "e" and a combining accent
+)
+
+// Valid reports whether f is a known form.
+func (f Form) Valid() bool {
+	switch f {
+	case FormNone, FormNFC, FormNFD:
+		return true
+	}
+	return false
+}
+
+// String returns s in form f.
+func (f Form) String(s string) string {
+	switch f {
+	case FormNFC:
+		return norm.NFC.String(s)
+	case FormNFD:
+		return norm.NFD.String(s)
+	}
+	return s
+}
+
+// IsRuneBoundary reports whether byte offset pos in s does not split a
+// UTF-8 sequence.
+func IsRuneBoundary(s string, pos int) bool {
+	if pos < 0 || pos > len(s) {
+		return false
+	}
+	return pos == len(s) || utf8.RuneStart(s[pos])
+}
+
+// IsBoundary reports whether byte offset pos in s falls between two
+// grapheme clusters. It follows the rules of Unicode Standard Annex #29
+// that matter when editing: CR LF, combining marks and other extenders,
+// Hangul syllables, emoji joined with ZWJ, and regional indicator pairs.
+// Emoji sequences are approximated by treating any symbol after a ZWJ as
+// joined.
+func IsBoundary(s string, pos int) bool {
+	if !IsRuneBoundary(s, pos) {
+		return false
+	}
+	if pos == 0 || pos == len(s) {
+		return true
+	}
+	before, _ := utf8.DecodeLastRuneInString(s[:pos])
+	after, _ := utf8.DecodeRuneInString(s[pos:])
+	switch {
+	case before == '\r' && after == '\n':
+		return false
+	case unicode.IsControl(before) || unicode.IsControl(after):
+		return true
+	case joinsHangul(before, after):
+		return false
+	case extends(after):
+		return false
+	case before == zwj && unicode.Is(unicode.So, after):
+		return false
+	case isRegional(before) && isRegional(after):
+		// Indicators pair up from the start of the run.
+		run := 0
+		for rest := s[:pos]; rest != ""; run++ {
+			r, size := utf8.DecodeLastRuneInString(rest)
+			if !isRegional(r) {
+				break
+			}
+			rest = rest[:len(rest)-size]
+		}
+		return run%2 == 0
+	}
+	return true
+}
+
+const zwj = '\u200d' // Zero width joiner
+
+// extends reports whether r attaches to the cluster before it.
+func extends(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc, unicode.Other_Grapheme_Extend) ||
+		r == zwj || r >= 0x1f3fb && r <= 0x1f3ff // Emoji skin tone modifiers
+}
+
+func isRegional(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// Hangul jamo and precomposed syllables, which combine into one cluster.
+const (
+	hangulBase  = 0xac00
+	hangulLast  = 0xd7a3
+	hangulTails = 28 // Trailing consonant choices per syllable, including none
+)
+
+func isLeading(r rune) bool  { return r >= 0x1100 && r <= 0x115f || r >= 0xa960 && r <= 0xa97c }
+func isVowel(r rune) bool    { return r >= 0x1160 && r <= 0x11a7 || r >= 0xd7b0 && r <= 0xd7c6 }
+func isTrailing(r rune) bool { return r >= 0x11a8 && r <= 0x11ff || r >= 0xd7cb && r <= 0xd7fb }
+func isSyllable(r rune) bool { return r >= hangulBase && r <= hangulLast }
+
+// joinsHangul reports whether two Hangul code points form one syllable.
+func joinsHangul(before, after rune) bool {
+	switch {
+	case isLeading(before):
+		return isLeading(after) || isVowel(after) || isSyllable(after)
+	case isVowel(before) || isSyllable(before) && (before-hangulBase)%hangulTails == 0:
+		return isVowel(after) || isTrailing(after)
+	case isTrailing(before) || isSyllable(before):
+		return isTrailing(after)
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package textnorm
+
+import "testing"
+
+func TestString(t *testing.T) {
+	composed, decomposed := "café", "café"
+	tests := []struct {
+		form Form
+		in   string
+		want string
+	}{
+		{FormNFC, decomposed, composed},
+		{FormNFD, composed, decomposed},
+		{FormNone, decomposed, decomposed},
+	}
+	for _, tt := range tests {
+		if got := tt.form.String(tt.in); got != tt.want {
+			t.Errorf("%q.String(%q) = %q, want %q", tt.form, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsBoundary(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		pos  int
+		want bool
+	}{
+		{"start", "ab", 0, true},
+		{"end", "ab", 2, true},
+		{"between letters", "ab", 1, true},
+		{"past the end", "ab", 3, false},
+		{"inside a UTF-8 sequence", "é", 1, false},
+		{"before a combining mark", "éx", 1, false},
+		{"after a combining mark", "éx", 3, true},
+		{"inside CR LF", "a\r\nb", 2, false},
+		{"mark after a newline", "\ń", 1, true},
+		{"before a skin tone", "\U0001F44D\U0001F3FD", 4, false},
+		{"before ZWJ", "\U0001F469‍\U0001F4BB", 4, false},
+		{"after ZWJ", "\U0001F469‍\U0001F4BB", 7, false},
+		{"inside a flag", "\U0001F1EB\U0001F1F7\U0001F1E9\U0001F1EA", 4, false},
+		{"between flags", "\U0001F1EB\U0001F1F7\U0001F1E9\U0001F1EA", 8, true},
+		{"hangul L then V", "가", 3, false},
+		{"hangul LV then T", "각", 3, false},
+		{"hangul LVT then T", "각ᆨ", 3, false},
+		{"hangul LVT then V", "각ᅡ", 3, true},
+		{"hangul syllables", "가가", 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsBoundary(tt.s, tt.pos); got != tt.want {
+				t.Errorf("IsBoundary(%q, %d) = %v, want %v", tt.s, tt.pos, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package validators checks the syntax of config documents, such as JSON,
+// YAML or TOML files edited together, and reports each problem where an
+// editor can underline it.
+package validators
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Diagnostic is one problem found in a document. Line and Column are
+// zero-based, like outline headings, and Column counts characters.
+type Diagnostic struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// Validator checks content and returns its problems, or none if it is
+// valid. Blank content is valid: a new document has nothing to check yet.
+type Validator func(content string) []Diagnostic
+
+// builtin holds the validators that can be selected by name.
+var builtin = map[string]Validator{
+	"json": validateJSON,
+	"yaml": validateYAML,
+	"toml": validateTOML,
+}
+
+// Lookup returns the built-in validator with the given name.
+func Lookup(name string) (Validator, bool) {
+	v, ok := builtin[name]
+	return v, ok
+}
+
+// Names returns the names of the built-in validators in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(builtin))
+	for name := range builtin {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateJSON(content string) []Diagnostic {
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
+	var v any
+	err := json.Unmarshal([]byte(content), &v)
+	if err == nil {
+		return nil
+	}
+	offset := len(content)
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		// The offset is just past the byte that failed to parse.
+		offset = max(int(syntax.Offset)-1, 0)
+	}
+	line, column := position(content, offset)
+	return []Diagnostic{{Line: line, Column: column, Message: strings.TrimPrefix(err.Error(), "json: ")}}
+}
+
+// yamlError matches the position yaml.v3 puts in its error messages.
+var yamlError = regexp.MustCompile(`^yaml: line (\d+)(?:, column (\d+))?: (.*)$`)
+
+func validateYAML(content string) []Diagnostic {
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err == nil {
+			continue
+		}
+		d := Diagnostic{Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if m := yamlError.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			d.Line = max(line-1, 0)
+			if m[2] != "" {
+				column, _ := strconv.Atoi(m[2])
+				d.Column = max(column-1, 0)
+			}
+			d.Message = m[3]
+		}
+		return []Diagnostic{d}
+	}
+}
+
+func validateTOML(content string) []Diagnostic {
+	var v map[string]any
+	_, err := toml.Decode(content, &v)
+	if err == nil {
+		return nil
+	}
+	var parse toml.ParseError
+	if !errors.As(err, &parse) {
+		return []Diagnostic{{Message: err.Error()}}
+	}
+	line, column := position(content, parse.Position.Start)
+	return []Diagnostic{{Line: line, Column: column, Message: parse.Message}}
+}
+
+// position converts a byte offset in content to a zero-based line and
+// character column.
+func position(content string, offset int) (line, column int) {
+	offset = min(offset, len(content))
+	before := content[:offset]
+	line = strings.Count(before, "\n")
+	return line, utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:])
+}
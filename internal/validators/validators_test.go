@@ -0,0 +1,37 @@
+package validators
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidators(t *testing.T) {
+	tests := []struct {
+		validator string
+		content   string
+		want      []Diagnostic
+	}{
+		{"json", `{"port": 80}`, nil},
+		{"json", "  \n", nil},
+		{"json", "{\n  \"a\": 1,\n  \"\u00e9\": x\n}", []Diagnostic{{Line: 2, Column: 7, Message: "invalid character 'x' looking for beginning of value"}}},
+		{"json", "[1] 2", []Diagnostic{{Line: 0, Column: 4, Message: "invalid character '2' after top-level value"}}},
+		{"yaml", "port: 80\nhosts: [a, b]\n---\nname: x\n", nil},
+		{"yaml", "a: 1\n b: 2\n", []Diagnostic{{Line: 1, Column: 0, Message: "mapping values are not allowed in this context"}}},
+		{"yaml", "a: 1\n---\nb: : c\n", []Diagnostic{{Line: 2, Column: 0, Message: "mapping values are not allowed in this context"}}},
+		{"toml", "port = 80\n[server]\nhost = \"x\"\n", nil},
+		{"toml", "a = 1\nb = \n", []Diagnostic{{Line: 1, Column: 4, Message: "expected value but found '\\n' instead"}}},
+		{"toml", "a = 1\na = 2\n", []Diagnostic{{Line: 1, Column: 0, Message: "Key 'a' has already been defined."}}},
+	}
+	for _, tt := range tests {
+		validate, ok := Lookup(tt.validator)
+		if !ok {
+			t.Fatalf("Lookup(%q) found nothing", tt.validator)
+		}
+		if got := validate(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s(%q) = %+v, want %+v", tt.validator, tt.content, got, tt.want)
+		}
+	}
+	if names := Names(); !reflect.DeepEqual(names, []string{"json", "toml", "yaml"}) {
+		t.Errorf("Names() = %v", names)
+	}
+}
@@ -0,0 +1,751 @@
+// Package sdk is a Go client for the collaborative editing server. It keeps
+// a local replica of one document in sync over a WebSocket connection.
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/internal/schema"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrClosed is returned when editing through a closed client.
+	ErrClosed = errors.New("sdk: client closed")
+	// ErrDeleted is returned when editing a document the server deleted.
+	ErrDeleted = errors.New("sdk: document deleted")
+)
+
+// writeTimeout bounds how long sending one message may take.
+const writeTimeout = 10 * time.Second
+
+// Client is a connection to one document.
+type Client struct {
+	documentID string
+	url        string
+	header     http.Header
+	conn       *websocket.Conn // replaced on reconnect; guarded by writeMu
+	reconnect  *Backoff
+	content    string
+	version    int
+	users      int
+	cursors    map[string]hub.Cursor // collaborators' cursors by client ID
+	presence   []hub.Collaborator    // last presence snapshot, ordered by client ID
+	deleted    bool
+	readOnly   bool
+	paused     bool
+	saved      time.Time     // server time of the last applied change
+	skew       time.Duration // server clock minus local clock
+	caps       *hub.Capabilities
+	queue      QueueStore
+	pending    []*operations.Operation // sent but not acknowledged, oldest first
+	sync       *hub.SyncSettings       // last requested, restored on reconnect
+	state      ConnState
+	onState    []func(ConnState, error)
+	onChange   []func(content string)
+	onLocal    []func(op *operations.Operation)
+	onAck      []func(op *operations.Operation, version int)
+	onReject   []func(op *operations.Operation, violation *schema.ViolationError)
+	onError    []func(op *operations.Operation, code hub.ErrorCode, reason string)
+	onConflict []func(report *hub.ConflictReport)
+	err        error
+	closed     chan struct{}
+	stop       chan struct{} // closed by Close
+	stopOnce   sync.Once
+	mu         sync.Mutex
+	writeMu    sync.Mutex
+}
+
+// Dial connects to the document at serverURL (e.g. "ws://localhost:8080").
+func Dial(serverURL, documentID string) (*Client, error) {
+	return DialWithHeader(serverURL, documentID, nil)
+}
+
+// DialWithHeader is like Dial but sends extra handshake headers, such as
+// Origin or Authorization.
+func DialWithHeader(serverURL, documentID string, header http.Header) (*Client, error) {
+	return DialWithOptions(serverURL, documentID, Options{Header: header})
+}
+
+// Options configures a connection.
+type Options struct {
+	Header http.Header // Extra handshake headers, such as Origin or Authorization
+	Queue  QueueStore  // Keeps unacknowledged edits across restarts; nil keeps them in memory
+
+	// Reconnect redials a lost connection with this backoff, resubmitting
+	// unacknowledged edits and resyncing the replica. Nil disables it.
+	Reconnect *Backoff
+}
+
+// DialWithOptions is like Dial with additional options. With a Queue, edits
+// left unacknowledged by a previous process are resubmitted on connect; the
+// server recognizes their IDs and applies each only once.
+func DialWithOptions(serverURL, documentID string, opts Options) (*Client, error) {
+	var pending []*operations.Operation
+	if opts.Queue != nil {
+		ops, err := opts.Queue.Load()
+		if err != nil {
+			return nil, err
+		}
+		pending = ops
+	}
+
+	c := &Client{
+		documentID: documentID,
+		url:        strings.TrimSuffix(serverURL, "/") + "/ws/" + documentID,
+		header:     opts.Header,
+		reconnect:  opts.Reconnect,
+		queue:      opts.Queue,
+		pending:    pending,
+		closed:     make(chan struct{}),
+		stop:       make(chan struct{}),
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	go c.readLoop()
+
+	// The content sent on connect predates the resubmitted edits and so
+	// may lack them or, if a previous process lost their acks, already
+	// hold them; restore ends with a sync that settles which.
+	if len(pending) > 0 {
+		if err := c.restore(); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to resubmit queued edits: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// dial opens a new connection to the document.
+func (c *Client) dial() (*websocket.Conn, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(c.url, c.header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", c.url, err)
+	}
+	return conn, nil
+}
+
+// DocumentID returns the document this client edits.
+func (c *Client) DocumentID() string {
+	return c.documentID
+}
+
+// Content returns the local replica of the document.
+func (c *Client) Content() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.content
+}
+
+// Version returns the document version the replica reflects.
+func (c *Client) Version() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// Users returns the latest user count reported by the server.
+func (c *Client) Users() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.users
+}
+
+// Cursors returns the cursors collaborators last shared, ordered by
+// client ID.
+func (c *Client) Cursors() []hub.Cursor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cursors := make([]hub.Cursor, 0, len(c.cursors))
+	for _, cursor := range c.cursors {
+		cursors = append(cursors, cursor)
+	}
+	sort.Slice(cursors, func(i, j int) bool { return cursors[i].ClientID < cursors[j].ClientID })
+	return cursors
+}
+
+// Collaborators returns the collaborators in the last presence snapshot,
+// ordered by client ID, with their cursors and idle states; see
+// RequestPresence.
+func (c *Client) Collaborators() []hub.Collaborator {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]hub.Collaborator(nil), c.presence...)
+}
+
+// Deleted reports whether the server deleted the document. If ReadOnly is
+// also set, Content holds the archived final content.
+func (c *Client) Deleted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deleted
+}
+
+// ReadOnly reports whether the replica is read-only: an archived copy of a
+// deleted document, a past version the server is replaying, or a document
+// the server only lets this session view.
+func (c *Client) ReadOnly() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readOnly
+}
+
+// LastSaved returns the server time of the last change the replica
+// applied, for display such as "last saved at 14:05".
+func (c *Client) LastSaved() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saved
+}
+
+// ClockSkew estimates how far the server clock is ahead of the local clock,
+// from the timestamp on the latest server message. Network delay makes the
+// estimate slightly low.
+func (c *Client) ClockSkew() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skew
+}
+
+// Capabilities returns what the server advertised on connect, updated when
+// the document's features change, or nil if its welcome message has not
+// arrived (or the server predates it).
+func (c *Client) Capabilities() *hub.Capabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.caps
+}
+
+// Pending returns the number of edits the server has not acknowledged.
+func (c *Client) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Paused reports whether an administrator paused edits to the document.
+// Edits made while paused are held or rejected by the server.
+func (c *Client) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// OnChange registers fn to be called with the new content after every
+// local or remote change. fn runs on the client's goroutines and must not
+// block.
+func (c *Client) OnChange(fn func(content string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onChange = append(c.onChange, fn)
+}
+
+// OnLocalEdit registers fn to be called with each local edit once it is
+// applied to the replica, before the server confirms it. Editors can render
+// the edit as pending until OnAck reports the same operation ID. fn runs on
+// the caller's goroutine and must not block.
+func (c *Client) OnLocalEdit(fn func(op *operations.Operation)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onLocal = append(c.onLocal, fn)
+}
+
+// OnAck registers fn to be called when the server confirms a local edit,
+// with the version it assigned. fn runs on the client's goroutines and must
+// not block.
+func (c *Client) OnAck(fn func(op *operations.Operation, version int)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onAck = append(c.onAck, fn)
+}
+
+// OnReject registers fn to be called when the server refuses a local edit
+// because it would break the document's schema or limits. violation names
+// the offending line. The server follows with its content, which replaces
+// the edit in the replica. fn runs on the client's goroutines and must not
+// block.
+func (c *Client) OnReject(fn func(op *operations.Operation, violation *schema.ViolationError)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReject = append(c.onReject, fn)
+}
+
+// OnError registers fn to be called when the server could not apply a local
+// edit, e.g. because its position was out of range, with the code and
+// reason it gave; op is nil for an edit the client did not identify. The
+// client then asks for the server's content, which replaces the edit in the
+// replica. An edit failing while an earlier one is unacknowledged is kept
+// for resubmission instead, since the server may have lost the earlier one.
+// fn runs on the client's goroutines and must not block.
+func (c *Client) OnError(fn func(op *operations.Operation, code hub.ErrorCode, reason string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onError = append(c.onError, fn)
+}
+
+// OnConflict registers fn to be called when concurrent edits refused or
+// reshaped a local edit, e.g. deleted part of the text it deleted, with
+// the server's report of who made them and how the edit was applied. It
+// follows the edit's ack or error. fn runs on the client's goroutines and
+// must not block.
+func (c *Client) OnConflict(fn func(report *hub.ConflictReport)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onConflict = append(c.onConflict, fn)
+}
+
+// Insert inserts text at position pos, in UTF-16 code units as in
+// JavaScript, locally and on the server.
+func (c *Client) Insert(pos int, text string) error {
+	c.mu.Lock()
+	op := operations.NewInsertOp(pos, text, c.version)
+	c.mu.Unlock()
+	return c.edit(op)
+}
+
+// Delete removes length UTF-16 code units at pos, locally and on the
+// server.
+func (c *Client) Delete(pos, length int) error {
+	c.mu.Lock()
+	start, ok := operations.Offset(c.content, pos)
+	end, endOK := operations.Offset(c.content, pos+length)
+	if !ok || !endOK || length <= 0 {
+		size := operations.Len(c.content)
+		c.mu.Unlock()
+		return fmt.Errorf("delete range [%d, %d) out of range [0, %d] or splits a character", pos, pos+length, size)
+	}
+	op := operations.NewDeleteOp(pos, c.content[start:end], c.version)
+	c.mu.Unlock()
+	return c.edit(op)
+}
+
+// Append inserts text at the end of the document.
+func (c *Client) Append(text string) error {
+	c.mu.Lock()
+	pos := operations.Len(c.content)
+	c.mu.Unlock()
+	return c.Insert(pos, text)
+}
+
+// Undo asks the server to revert this connection's latest edit it has
+// applied, keeping everyone else's. The revert arrives as a remote
+// operation. Edits made before a reconnect can no longer be undone.
+func (c *Client) Undo() error {
+	return c.send(&hub.Message{Type: hub.MsgTypeUndo, DocumentID: c.documentID})
+}
+
+// Redo asks the server to reapply the edit the latest Undo reverted. Any
+// edit made since the Undo clears what can be redone.
+func (c *Client) Redo() error {
+	return c.send(&hub.Message{Type: hub.MsgTypeRedo, DocumentID: c.documentID})
+}
+
+// RequestSync asks the server for the document's current content and
+// version, which replace the replica's once they arrive. Edits not yet
+// acknowledged are kept on top.
+func (c *Client) RequestSync() error {
+	return c.send(&hub.Message{Type: hub.MsgTypeSyncRequest, DocumentID: c.documentID})
+}
+
+// RequestPresence asks the server for every collaborator's cursor, name
+// and idle state in one message. Once it arrives, Collaborators returns
+// them and Cursors holds their cursors.
+func (c *Client) RequestPresence() error {
+	return c.send(&hub.Message{Type: hub.MsgTypePresenceRequest, DocumentID: c.documentID})
+}
+
+// SetCursor shares this connection's caret and selection with
+// collaborators, as UTF-16 positions in the replica: anchor where the
+// selection started and head where the caret is. name and color, as
+// #rrggbb, are how collaborators display it; an empty name shows the
+// authenticated principal. The server forgets the cursor when the
+// connection closes, so set it again after a reconnect.
+func (c *Client) SetCursor(anchor, head int, name, color string) error {
+	cursor := &hub.Cursor{Anchor: anchor, Head: head, Name: name, Color: color}
+	return c.send(hub.NewCursorMessage(c.documentID, cursor))
+}
+
+// SetSync asks the server how to deliver remote edits. hub.SyncCoalesced
+// batches them once per interval (zero uses the server default) to save
+// bandwidth; hub.SyncRealtime, the default, delivers each one immediately.
+// The server may clamp the interval.
+func (c *Client) SetSync(mode hub.SyncMode, interval time.Duration) error {
+	settings := &hub.SyncSettings{Mode: mode, IntervalMS: int(interval / time.Millisecond)}
+	c.mu.Lock()
+	if c.sync != nil {
+		settings.ContentDeltas = c.sync.ContentDeltas
+	}
+	c.sync = settings
+	c.mu.Unlock()
+	return c.send(hub.NewSyncModeMessage(c.documentID, settings))
+}
+
+// SetContentDeltas asks the server to send content changes, such as a
+// legacy client replacing the whole text, as the operations that turn the
+// replica's version into the new one whenever those are smaller than the
+// content. The replica applies either form.
+func (c *Client) SetContentDeltas(on bool) error {
+	settings := &hub.SyncSettings{Mode: hub.SyncRealtime}
+	c.mu.Lock()
+	if c.sync != nil {
+		*settings = *c.sync
+	}
+	settings.ContentDeltas = on
+	c.sync = settings
+	c.mu.Unlock()
+	return c.send(hub.NewSyncModeMessage(c.documentID, settings))
+}
+
+// edit applies op to the replica, queues it until the server acknowledges
+// it and sends it. The server does not echo an author's own operations, so
+// the replica advances the version itself. With reconnection enabled a
+// failed send is not an error: the edit is resubmitted on reconnect.
+func (c *Client) edit(op *operations.Operation) error {
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+
+	c.mu.Lock()
+	if c.deleted {
+		c.mu.Unlock()
+		return ErrDeleted
+	}
+	content, err := operations.Apply(c.content, op)
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	op.ID = newOperationID()
+	if err := c.savePending(append(c.pending, op)); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.content = content
+	c.version++
+	callbacks, local := c.onChange, c.onLocal
+	c.mu.Unlock()
+
+	// Report the edit before sending it, so its ack cannot arrive first.
+	for _, fn := range local {
+		fn(op)
+	}
+	if err := c.sendOperation(op); err != nil && c.reconnect == nil {
+		return err
+	}
+	notify(callbacks, content)
+	return nil
+}
+
+// sendOperation writes one operation to the server.
+func (c *Client) sendOperation(op *operations.Operation) error {
+	msg := hub.NewOperationMessage(op)
+	msg.DocumentID = c.documentID
+	return c.send(msg)
+}
+
+// savePending replaces the pending queue, persisting it first if the client
+// has a QueueStore. Callers must hold c.mu.
+func (c *Client) savePending(ops []*operations.Operation) error {
+	if c.queue != nil {
+		if err := c.queue.Save(ops); err != nil {
+			return err
+		}
+	}
+	c.pending = ops
+	return nil
+}
+
+// acknowledge drops an acknowledged operation from the pending queue and
+// returns it, or nil if it is not pending. Callers must hold c.mu.
+func (c *Client) acknowledge(id string) *operations.Operation {
+	for i, op := range c.pending {
+		if op.ID != id {
+			continue
+		}
+		ops := append(c.pending[:i:i], c.pending[i+1:]...)
+		// On a failed save the operation stays queued and a later
+		// resubmission is deduplicated by the server.
+		c.savePending(ops)
+		return op
+	}
+	return nil
+}
+
+// send writes one message to the server.
+func (c *Client) send(msg *hub.Message) error {
+	data, err := msg.ToBytes()
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Close disconnects from the server and stops reconnecting.
+func (c *Client) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.writeMu.Lock()
+	err := c.conn.Close()
+	c.writeMu.Unlock()
+	<-c.closed
+	return err
+}
+
+// Done is closed when the client is closed or the connection ends without
+// reconnecting.
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns the error that ended the client, if any.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// readLoop applies server messages until the client is closed,
+// reconnecting lost connections if enabled.
+func (c *Client) readLoop() {
+	defer close(c.closed)
+
+	for {
+		err := c.readFrames()
+		select {
+		case <-c.stop:
+			c.setState(StateClosed, nil)
+			return
+		default:
+		}
+		if c.reconnect != nil {
+			c.setState(StateReconnecting, err)
+			if c.redial() {
+				c.setState(StateConnected, nil)
+				continue
+			}
+		}
+
+		c.mu.Lock()
+		if !errors.Is(err, net.ErrClosed) {
+			c.err = err
+		}
+		c.mu.Unlock()
+		c.setState(StateClosed, err)
+		return
+	}
+}
+
+// readFrames applies server messages until the current connection fails.
+// The server batches queued messages into one frame separated by newlines.
+func (c *Client) readFrames() error {
+	c.writeMu.Lock()
+	conn := c.conn
+	c.writeMu.Unlock()
+
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(frame), "\n") {
+			c.handle([]byte(line))
+		}
+	}
+}
+
+// handle applies one server message to the replica.
+func (c *Client) handle(data []byte) {
+	msg, err := hub.MessageFromBytes(data)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	var serverTime time.Time
+	if msg.ServerTime != 0 {
+		serverTime = time.UnixMilli(msg.ServerTime)
+		c.skew = time.Until(serverTime)
+	}
+
+	switch msg.Type {
+	case hub.MsgTypeUserCount:
+		c.users = msg.UserCount
+		c.mu.Unlock()
+		return
+
+	case hub.MsgTypeCursor:
+		if msg.Cursor != nil {
+			if msg.Cursor.Left {
+				delete(c.cursors, msg.Cursor.ClientID)
+			} else {
+				if c.cursors == nil {
+					c.cursors = make(map[string]hub.Cursor)
+				}
+				c.cursors[msg.Cursor.ClientID] = *msg.Cursor
+			}
+		}
+		c.mu.Unlock()
+		return
+
+	case hub.MsgTypePresence:
+		c.presence = msg.Presence
+		c.cursors = make(map[string]hub.Cursor)
+		for _, collaborator := range msg.Presence {
+			if collaborator.Cursor != nil {
+				c.cursors[collaborator.ClientID] = *collaborator.Cursor
+			}
+		}
+		c.mu.Unlock()
+		return
+
+	case hub.MsgTypeWelcome, hub.MsgTypeCapabilities:
+		if msg.Type == hub.MsgTypeWelcome {
+			// A new connection: the server sends the current cursors next.
+			c.cursors = nil
+		}
+		c.caps = msg.Capabilities
+		c.readOnly = c.readOnly || msg.ReadOnly
+		c.mu.Unlock()
+		return
+
+	case hub.MsgTypeAck:
+		op, callbacks := c.acknowledge(msg.AckID), c.onAck
+		c.mu.Unlock()
+		if op != nil {
+			for _, fn := range callbacks {
+				fn(op, msg.Version)
+			}
+		}
+		return
+
+	case hub.MsgTypeRejected:
+		op, callbacks := c.acknowledge(msg.AckID), c.onReject
+		c.mu.Unlock()
+		if op != nil {
+			for _, fn := range callbacks {
+				fn(op, msg.Violation)
+			}
+		}
+		return
+
+	case hub.MsgTypeError:
+		// An edit sent after others still unacknowledged may only have
+		// failed because the server lost one of them; it stays pending and
+		// is resubmitted with them on reconnect.
+		for _, op := range c.pending[min(1, len(c.pending)):] {
+			if op.ID == msg.AckID {
+				c.mu.Unlock()
+				return
+			}
+		}
+		op, callbacks := c.acknowledge(msg.AckID), c.onError
+		c.mu.Unlock()
+		for _, fn := range callbacks {
+			fn(op, msg.Code, msg.Reason)
+		}
+		// The failed edit is still applied locally; the sync undoes it. If
+		// the request cannot be sent the connection is broken, and the
+		// sync after reconnecting does the same.
+		c.RequestSync()
+		return
+
+	case hub.MsgTypeConflict:
+		callbacks := c.onConflict
+		c.mu.Unlock()
+		if msg.Conflict != nil {
+			for _, fn := range callbacks {
+				fn(msg.Conflict)
+			}
+		}
+		return
+
+	case hub.MsgTypeDocumentPaused, hub.MsgTypeDocumentResumed:
+		c.paused = msg.Type == hub.MsgTypeDocumentPaused
+		c.mu.Unlock()
+		return
+
+	case hub.MsgTypeOperation:
+		if msg.Operation == nil {
+			c.mu.Unlock()
+			return
+		}
+		content, err := operations.Apply(c.content, msg.Operation)
+		if err != nil {
+			c.err = fmt.Errorf("replica diverged at version %d: %w", msg.Operation.Version, err)
+			c.mu.Unlock()
+			return
+		}
+		c.content = content
+		c.version = msg.Operation.Version
+		c.saved = serverTime
+
+	case hub.MsgTypeOperationBatch:
+		content, version := c.content, c.version
+		for _, op := range msg.Operations {
+			next, err := operations.Apply(content, op)
+			if err != nil {
+				c.err = fmt.Errorf("replica diverged at version %d: %w", op.Version, err)
+				c.mu.Unlock()
+				return
+			}
+			content, version = next, op.Version
+		}
+		c.content, c.version = content, version
+		c.saved = serverTime
+
+	case hub.MsgTypeContent, hub.MsgTypeSync:
+		c.content = msg.Content
+		c.readOnly = c.readOnly || msg.ReadOnly
+		// A sync names its version even when it is zero, for a new
+		// document; legacy content messages may leave it out.
+		if msg.Version != 0 || msg.Type == hub.MsgTypeSync {
+			c.version = msg.Version
+		}
+		c.saved = serverTime
+		// Edits the server has not acknowledged are not in the content
+		// yet; keep them on top, where the server will apply them.
+		for _, op := range c.pending {
+			if content, err := operations.Apply(c.content, op); err == nil {
+				c.content = content
+				c.version++
+			}
+		}
+
+	case hub.MsgTypeDocumentDeleted:
+		c.deleted = true
+		c.readOnly = msg.ReadOnly
+		c.content = msg.Content
+
+	default:
+		c.mu.Unlock()
+		return
+	}
+	content, callbacks := c.content, c.onChange
+	c.mu.Unlock()
+
+	notify(callbacks, content)
+}
+
+func notify(callbacks []func(string), content string) {
+	for _, fn := range callbacks {
+		fn(content)
+	}
+}
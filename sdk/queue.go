@@ -0,0 +1,86 @@
+package sdk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"collaborative-docs/internal/operations"
+)
+
+// QueueStore persists operations the server has not acknowledged yet, so
+// they survive a process restart. Save replaces the stored queue.
+type QueueStore interface {
+	Load() ([]*operations.Operation, error)
+	Save(ops []*operations.Operation) error
+}
+
+// FileQueue is a QueueStore backed by a JSON file.
+type FileQueue struct {
+	path string
+}
+
+// NewFileQueue returns a queue stored at path. The file is created on the
+// first save.
+func NewFileQueue(path string) *FileQueue {
+	return &FileQueue{path: path}
+}
+
+// Load implements QueueStore. A missing file is an empty queue.
+func (q *FileQueue) Load() ([]*operations.Operation, error) {
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+	var ops []*operations.Operation
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, fmt.Errorf("failed to parse queue %s: %w", q.path, err)
+	}
+	return ops, nil
+}
+
+// Save implements QueueStore. It writes a temporary file and renames it
+// over the queue, so a crash never leaves a partial queue behind.
+func (q *FileQueue) Save(ops []*operations.Operation) error {
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return fmt.Errorf("failed to marshal queue: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write queue: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write queue: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write queue: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return fmt.Errorf("failed to write queue: %w", err)
+	}
+	return nil
+}
+
+// newOperationID returns a random ID the server uses to apply an operation
+// once, however often it is resubmitted.
+func newOperationID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
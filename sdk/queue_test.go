@@ -0,0 +1,81 @@
+package sdk_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"collaborative-docs/collabtest"
+	"collaborative-docs/internal/operations"
+	"collaborative-docs/sdk"
+)
+
+// TestFileQueue verifies a missing queue loads empty, a corrupt one fails,
+// and saves replace the queue without leaving temporary files behind.
+func TestFileQueue(t *testing.T) {
+	dir := t.TempDir()
+	q := sdk.NewFileQueue(filepath.Join(dir, "queue.json"))
+
+	if ops, err := q.Load(); err != nil || len(ops) != 0 {
+		t.Fatalf("Load() of missing file = %v, %v; want empty", ops, err)
+	}
+
+	op := operations.NewInsertOp(0, "hello", 3)
+	op.ID = "op-1"
+	if err := q.Save([]*operations.Operation{op}); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	if err := q.Save([]*operations.Operation{op, operations.NewDeleteOp(0, "h", 4)}); err != nil {
+		t.Fatalf("second Save() error: %v", err)
+	}
+	ops, err := q.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(ops) != 2 || !reflect.DeepEqual(ops[0], op) {
+		t.Errorf("Load() = %v, want the two saved operations", ops)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the queue", len(entries))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "queue.json"), []byte(`[{"type":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Load(); err == nil {
+		t.Error("Load() of corrupt file succeeded")
+	}
+}
+
+// TestQueueResubmit verifies edits a previous process left unacknowledged
+// are resubmitted on connect, applied once however often the process
+// restarts, and dropped from the queue once acknowledged.
+func TestQueueResubmit(t *testing.T) {
+	env := collabtest.New(t, "queued")
+	q := sdk.NewFileQueue(filepath.Join(t.TempDir(), "queue.json"))
+
+	op := operations.NewInsertOp(0, "offline", 0)
+	op.ID = "op-1"
+	for _, user := range []string{"first run", "second run"} {
+		// The process exited before the server acknowledged op.
+		if err := q.Save([]*operations.Operation{op}); err != nil {
+			t.Fatal(err)
+		}
+		c := env.ConnectWithOptions(user, sdk.Options{Queue: q})
+		for deadline := time.Now().Add(collabtest.DefaultTimeout); c.Pending() != 0; time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: resubmitted edit never acknowledged", user)
+			}
+		}
+		if got := env.WaitConverged(); got != "offline" {
+			t.Errorf("%s: content = %q, want %q", user, got, "offline")
+		}
+		env.Disconnect(user)
+	}
+
+	if ops, err := q.Load(); err != nil || len(ops) != 0 {
+		t.Errorf("queue after acknowledgement = %v, %v; want empty", ops, err)
+	}
+}
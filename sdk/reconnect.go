@@ -0,0 +1,152 @@
+package sdk
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"collaborative-docs/internal/hub"
+	"collaborative-docs/internal/operations"
+)
+
+// ConnState is the state of a client's connection.
+type ConnState int
+
+const (
+	StateConnected    ConnState = iota // Connected and in sync
+	StateReconnecting                  // Connection lost; redialing with backoff
+	StateClosed                        // Closed by Close, or reconnection gave up
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// Backoff controls reconnection after a lost connection. The delay before
+// attempt n is Initial·Multiplier^n, capped at Max, then reduced by a
+// random fraction up to Jitter so clients dropped together do not redial
+// together.
+type Backoff struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64 // In [0, 1]
+	MaxAttempts int     // Consecutive failed attempts before giving up; 0 retries forever
+}
+
+// DefaultBackoff redials after 500ms, doubling up to 30s, forever.
+var DefaultBackoff = Backoff{
+	Initial:    500 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.5,
+}
+
+// delay returns how long to wait before the given zero-based attempt.
+func (b *Backoff) delay(attempt int) time.Duration {
+	d := float64(b.Initial) * math.Pow(max(b.Multiplier, 1), float64(attempt))
+	if b.Max > 0 {
+		d = min(d, float64(b.Max))
+	}
+	d -= d * min(max(b.Jitter, 0), 1) * rand.Float64()
+	return time.Duration(d)
+}
+
+// OnStateChange registers fn to be called when the connection state
+// changes, with the error that caused it, if any. fn runs on the client's
+// goroutines and must not block.
+func (c *Client) OnStateChange(fn func(state ConnState, err error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onState = append(c.onState, fn)
+}
+
+// State returns the current connection state.
+func (c *Client) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+func (c *Client) setState(state ConnState, err error) {
+	c.mu.Lock()
+	if c.state == state {
+		c.mu.Unlock()
+		return
+	}
+	c.state = state
+	callbacks := c.onState
+	c.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(state, err)
+	}
+}
+
+// redial reconnects with backoff until it succeeds, the client is closed or
+// the attempts run out, and reports whether it reconnected.
+func (c *Client) redial() bool {
+	for attempt := 0; c.reconnect.MaxAttempts == 0 || attempt < c.reconnect.MaxAttempts; attempt++ {
+		select {
+		case <-c.stop:
+			return false
+		case <-time.After(c.reconnect.delay(attempt)):
+		}
+
+		conn, err := c.dial()
+		if err != nil {
+			continue
+		}
+		c.writeMu.Lock()
+		c.conn = conn
+		c.writeMu.Unlock()
+
+		// Close may have run while dialing and closed the old connection.
+		select {
+		case <-c.stop:
+			conn.Close()
+			return false
+		default:
+		}
+		if err := c.restore(); err != nil {
+			conn.Close()
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// restore brings a new connection back to the session's state: the
+// requested sync mode, the unacknowledged edits (which the server applies
+// once each, by ID) and finally a sync request. The server answers it after
+// the resubmitted edits, so their acks arrive first and the content it
+// sends holds them, including any the server applied before the old
+// connection lost their acks.
+func (c *Client) restore() error {
+	c.mu.Lock()
+	settings := c.sync
+	pending := append([]*operations.Operation(nil), c.pending...)
+	c.mu.Unlock()
+
+	if settings != nil {
+		if err := c.send(hub.NewSyncModeMessage(c.documentID, settings)); err != nil {
+			return err
+		}
+	}
+	for _, op := range pending {
+		if err := c.sendOperation(op); err != nil {
+			return err
+		}
+	}
+	return c.RequestSync()
+}